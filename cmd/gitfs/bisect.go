@@ -0,0 +1,101 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/gravypod/gitfs/internal/cli"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"github.com/gravypod/gitfs/pkg/mount"
+	"log"
+)
+
+// bisectMount implements `gitfs bisect-mount`, which mounts the commit halfway between --good and --bad and moves the
+// mount to the next commit to test whenever it is marked good or bad through .gitfs/bisect/, so a bisection can be
+// driven by writing files instead of checking out each commit.
+func bisectMount(args []string) error {
+	flagSet := flag.NewFlagSet("gitfs bisect-mount", flag.ExitOnError)
+	cli.RegisterConfigFlag(flagSet)
+	repositoryDirectory := flagSet.String("git-dir", "", "Path to bare git repo to bisect.")
+	mountPath := flagSet.String("mount", "/tmp/gitfs", "Location to mount the commit being tested. You must have write access to this directory.")
+	bad := flagSet.String("bad", "master", "Branch, tag, or commit known to be bad.")
+	var good cli.StringList
+	flagSet.Var(&good, "good", "Branch, tag, or commit known to be good, an ancestor of --bad. May be repeated.")
+	symlinks := flagSet.String("symlinks", "rewrite", "How to serve symlinks pointing outside of the repository: rewrite, hide, or passthrough.")
+	gitFlags := cli.RegisterGitFlags(flagSet)
+	logFlags := cli.RegisterLogFlags(flagSet)
+	if err := cli.ParseWithConfig(flagSet, args); err != nil {
+		return err
+	}
+	if err := logFlags.Apply(); err != nil {
+		return err
+	}
+	if *repositoryDirectory == "" {
+		return fmt.Errorf("must provide a bare git repository (--git-dir)")
+	}
+	if len(good) == 0 {
+		return fmt.Errorf("must provide at least one good commit (--good)")
+	}
+
+	badRef, err := gitfs.ParseRef(*bad)
+	if err != nil {
+		return fmt.Errorf("invalid --bad: %v", err)
+	}
+	var goodRefs []gitfs.Ref
+	for _, name := range good {
+		ref, err := gitfs.ParseRef(name)
+		if err != nil {
+			return fmt.Errorf("invalid --good: %v", err)
+		}
+		goodRefs = append(goodRefs, ref)
+	}
+	symlinkPolicy, err := gitfs.ParseSymlinkPolicy(*symlinks)
+	if err != nil {
+		return fmt.Errorf("invalid --symlinks: %v", err)
+	}
+	gitOptions, err := gitFlags.Options()
+	if err != nil {
+		return fmt.Errorf("invalid git flags: %v", err)
+	}
+	// The bisection outlives the filesystems built for each commit, so they share one client it owns.
+	git, err := gitfs.NewCliGit(*repositoryDirectory, gitOptions...)
+	if err != nil {
+		return fmt.Errorf("failed to create git client for directory '%s': %v", *repositoryDirectory, err)
+	}
+	bisection, err := gitfs.NewBisection(git, badRef, goodRefs)
+	if err != nil {
+		return err
+	}
+
+	mounted, err := mount.Mount(context.Background(), mount.Options{
+		GitDir:        *repositoryDirectory,
+		Git:           git,
+		Bisection:     bisection,
+		Symlinks:      symlinkPolicy,
+		Introspection: true,
+		MountPoint:    *mountPath,
+		HandleSignals: true,
+		ErrorLogger:   log.New(log.Writer(), "fuse error: ", log.Flags()),
+	})
+	if err != nil {
+		return fmt.Errorf("mount failed: %v", err)
+	}
+	log.Printf("Mounted at %s. Write to %s/%s/%s/good or bad to mark the commit being tested.", mounted.Dir(),
+		mounted.Dir(), gitfs.IntrospectionDirectory, gitfs.BisectDirectory)
+	log.Print(bisection.Status())
+	return mounted.Join(context.Background())
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gravypod/gitfs/internal/cli"
+	"github.com/gravypod/gitfs/pkg/httpfs"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// cache implements `gitfs cache stats` and `gitfs cache flush`, which talk to the --admin-listen address of a running
+// gitfs so operators can recover from bad cache contents without restarting its mounts.
+func cache(args []string) error {
+	if len(args) == 0 || (args[0] != "stats" && args[0] != "flush") {
+		return fmt.Errorf("usage: gitfs cache stats|flush --token-file FILE [--admin ADDRESS] [--scope blobs|trees|all]")
+	}
+	command := args[0]
+	flagSet := flag.NewFlagSet("gitfs cache "+command, flag.ExitOnError)
+	admin := flagSet.String("admin", "localhost:46054", "The --admin-listen address of the gitfs to talk to.")
+	scope := flagSet.String("scope", "all", "What to flush: blobs, trees, or all.")
+	tokenFile := flagSet.String("token-file", "", "File holding the --admin-token-file token of the gitfs to talk to.")
+	if err := flagSet.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *tokenFile == "" {
+		return fmt.Errorf("--token-file must name the file holding the admin token")
+	}
+	token, err := cli.ReadAdminToken(*tokenFile)
+	if err != nil {
+		return err
+	}
+
+	endpoint := url.URL{Scheme: "http", Host: *admin, Path: httpfs.CacheAdminPath + command}
+	method := http.MethodGet
+	if command == "flush" {
+		endpoint.RawQuery = url.Values{"scope": {*scope}}.Encode()
+		method = http.MethodPost
+	}
+	request, err := http.NewRequest(method, endpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to reach gitfs at %s: %v", *admin, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("gitfs refused to %s its cache: %s", command, body)
+	}
+	_, err = io.Copy(os.Stdout, response.Body)
+	return err
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gravypod/gitfs/internal/cli"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressInterval is how often `gitfs cp` reports how far along it is.
+const progressInterval = 500 * time.Millisecond
+
+// cp implements `gitfs cp`, which copies a file or directory out of a revision much faster than cp -r through a
+// mount by reading blobs in parallel batches straight from git.
+func cp(args []string) error {
+	flagSet := flag.NewFlagSet("gitfs cp", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: gitfs cp [flags] <src-path> <dst-dir>\n")
+		flagSet.PrintDefaults()
+	}
+	cli.RegisterConfigFlag(flagSet)
+	repositoryDirectory := flagSet.String("git-dir", "", "Path to bare git repo to copy from.")
+	ref := flagSet.String("ref", "master", "Branch, tag, or commit to copy from, like main, refs/tags/v1.2, or a commit hash.")
+	jobs := flagSet.Int("jobs", 0, "How many git commands read blobs at once. 0 runs one per CPU.")
+	progress := flagSet.Bool("progress", true, "Report how many files and bytes have been copied while copying.")
+	gitFlags := cli.RegisterGitFlags(flagSet)
+	if err := cli.ParseWithConfig(flagSet, args); err != nil {
+		return err
+	}
+	if flagSet.NArg() != 2 {
+		flagSet.Usage()
+		return fmt.Errorf("must provide a path to copy and a directory to copy it into")
+	}
+	if *repositoryDirectory == "" {
+		return fmt.Errorf("must provide a bare git repository (--git-dir)")
+	}
+	src, dst := flagSet.Arg(0), flagSet.Arg(1)
+	if info, err := os.Stat(dst); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dst)
+	}
+
+	reference, err := gitfs.ParseRef(*ref)
+	if err != nil {
+		return fmt.Errorf("invalid --ref: %v", err)
+	}
+	gitOptions, err := gitFlags.Options()
+	if err != nil {
+		return fmt.Errorf("invalid git flags: %v", err)
+	}
+	git, err := gitfs.NewCliGit(*repositoryDirectory, gitOptions...)
+	if err != nil {
+		return fmt.Errorf("failed to create git client for directory '%s': %v", *repositoryDirectory, err)
+	}
+
+	options := gitfs.CopyOptions{Jobs: *jobs}
+	var mu sync.Mutex
+	var reported time.Time
+	if *progress {
+		options.Progress = func(stats gitfs.CopyStats) {
+			mu.Lock()
+			defer mu.Unlock()
+			if time.Since(reported) < progressInterval && stats.Files != stats.TotalFiles {
+				return
+			}
+			reported = time.Now()
+			fmt.Fprintf(os.Stderr, "\rCopied %d/%d files (%d/%d bytes)", stats.Files, stats.TotalFiles, stats.Bytes,
+				stats.TotalBytes)
+		}
+	}
+
+	start := time.Now()
+	stats, err := gitfs.CopyTree(git, reference, src, dst, options)
+	if *progress && stats.Files > 0 {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %v", src, err)
+	}
+	log.Printf("Copied %d files (%d bytes) in %s", stats.Files, stats.Bytes, time.Since(start))
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gravypod/gitfs/internal/cli"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"github.com/gravypod/gitfs/pkg/mount"
+)
+
+// doctor implements `gitfs doctor`, which checks everything mounting a repository needs and says what to do about
+// anything that is missing.
+func doctor(args []string) error {
+	flagSet := flag.NewFlagSet("gitfs doctor", flag.ExitOnError)
+	cli.RegisterConfigFlag(flagSet)
+	repositoryDirectory := flagSet.String("git-dir", "", "Path to the bare git repo that would be mounted.")
+	ref := flagSet.String("ref", "master", "Branch, tag, or commit that would be mounted.")
+	gitFlags := cli.RegisterGitFlags(flagSet)
+	if err := cli.ParseWithConfig(flagSet, args); err != nil {
+		return err
+	}
+	if *repositoryDirectory == "" {
+		return fmt.Errorf("no repository provided. Please specify '-git-dir'")
+	}
+	reference, err := gitfs.ParseRef(*ref)
+	if err != nil {
+		return fmt.Errorf("invalid --ref: %v", err)
+	}
+	gitOptions, err := gitFlags.Options()
+	if err != nil {
+		return fmt.Errorf("invalid git flags: %v", err)
+	}
+
+	diagnoses := append(gitfs.Diagnose(*repositoryDirectory, reference, gitOptions...), mount.DiagnoseFuse()...)
+	failed := 0
+	for _, diagnosis := range diagnoses {
+		switch {
+		case diagnosis.Failed():
+			failed++
+			fmt.Printf("FAIL  %s: %s\n      %s\n", diagnosis.Check, diagnosis.Problem, diagnosis.Remediation)
+		case diagnosis.Problem != "":
+			fmt.Printf("WARN  %s: %s\n      %s\n", diagnosis.Check, diagnosis.Problem, diagnosis.Remediation)
+		default:
+			fmt.Printf("ok    %s: %s\n", diagnosis.Check, diagnosis.Detail)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(diagnoses))
+	}
+	return nil
+}
@@ -17,75 +17,519 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"github.com/gravypod/gitfs/internal/cli"
 	gitfs "github.com/gravypod/gitfs/pkg"
-	"github.com/jacobsa/fuse"
+	"github.com/gravypod/gitfs/pkg/httpfs"
+	"github.com/gravypod/gitfs/pkg/mount"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 )
 
-var (
-	repositoryDirectory = flag.String("git-dir", "", "Path to bare git repo to serve.")
-	mountPath           = flag.String("mount", "/tmp/gitfs", "Location to mount gitfs. You must have write access to this directory.")
-)
+type flags struct {
+	repositoryDirectory   *string
+	reposDir              *string
+	reposDirInterval      *time.Duration
+	repositoryConcurrency *int
+	mountPath             *string
+	mountSpecs            *cli.StringList
+	ref                   *string
+	refResolver           *string
+	resolve               *string
+	resolveInterval       *time.Duration
+	index                 *bool
+	dirtyWorktree         *string
+	expectCommit          *string
+	verifySignatures      *bool
+	gpgHome               *string
+	sshAllowedSigners     *string
+	remoteAddress         *string
+	exposeGitObjects      *bool
+	introspection         *bool
+	archives              *bool
+	releases              *bool
+	history               *bool
+	maxFileSize           *int64
+	chunkSeparator        *string
+	rateLimits            *gitfs.RateLimits
+	templates             *cli.StringList
+	buildCache            *string
+	accessLog             *string
+	symlinks              *string
+	normalization         *string
+	directoryTimes        *bool
+	directoryOrder        *string
+	trace                 *bool
+	fuseDebug             *bool
+	idleUnmount           *time.Duration
+	attributeTTL          *time.Duration
+	entryTTL              *time.Duration
+	watch                 *bool
+	control               *bool
+	controlUIDs           *cli.StringList
+	adminListen           *string
+	adminTokenFile        *string
+	gitFlags              *cli.GitFlags
+	logFlags              *cli.LogFlags
+}
 
-func main() {
-	flag.Parse()
+func registerFlags(flagSet *flag.FlagSet) *flags {
+	cli.RegisterConfigFlag(flagSet)
+	templates := new(cli.StringList)
+	mountSpecs := new(cli.StringList)
+	controlUIDs := new(cli.StringList)
+	flagSet.Var(controlUIDs, "control-uid", "A uid allowed to write to /.gitfs/control with --control. May be repeated. Only the user running gitfs is allowed if none are given.")
+	flagSet.Var(mountSpecs, "mount-spec", "Mount a ref of --git-dir at a directory, like ref=main:/mnt/main or tag=v1.2:/mnt/v1.2, instead of master at --mount. May be repeated to serve several refs from one process, with what each one logs and traces labelled with its mount point.")
+	flagSet.Var(templates, "template", "Expand @@COMMIT@@, @@DESCRIBE@@, @@REF@@, @@BRANCH@@, and @@TAG@@ in files matching this pattern. May be repeated.")
+	return &flags{
+		repositoryDirectory:   flagSet.String("git-dir", "", "Path to bare git repo to serve."),
+		reposDir:              flagSet.String("repos-dir", "", "Directory of bare git repos to serve side by side instead of --git-dir, each in a directory named after it. Repos that fail to open are left out without failing the others, and repos can be added and removed while mounted through --admin-listen."),
+		reposDirInterval:      flagSet.Duration("repos-dir-interval", 10*time.Second, "How often to look for bare git repos added to or removed from --repos-dir, and serve or stop serving them without remounting. 0 only looks when mounting."),
+		repositoryConcurrency: flagSet.Int("repo-concurrency", 0, "Calls each repo of --repos-dir may serve at once, so one that hangs can't tie up more. 0 is unlimited."),
+		mountPath:             flagSet.String("mount", "/tmp/gitfs", "Location to mount gitfs. You must have write access to this directory."),
+		mountSpecs:            mountSpecs,
+		ref:                   flagSet.String("ref", "master", "Branch, tag, or commit to mount at --mount, like main, refs/tags/v1.2, or a commit hash."),
+		refResolver:           flagSet.String("ref-resolver", "", "Hook mapping --resolve to the commit to mount instead of --ref, asked again on SIGHUP, refreshes through /.gitfs/control, and every --resolve-interval. exec:COMMAND runs COMMAND with the name and mounts the commit it prints. An http:// or https:// URL, like https://ci.example.com/last-green/{name}, or file:PATH reads the commit from the first line of what it serves, so CI can publish the last commit that passed for gitfs to mount."),
+		resolveInterval:       flagSet.Duration("resolve-interval", 0, "How often to ask --ref-resolver again while mounted, like 30s, refreshing the mount when the commit changes. The kernel forgets what it cached within a second like with --watch. 0 only asks when mounting and refreshing."),
+		resolve:               flagSet.String("resolve", "", "Name for --ref-resolver to map to a commit, like main for the last commit of main that passed CI."),
+		index:                 flagSet.Bool("index", false, "Mount the files staged in the index of a non-bare repository, exactly what would be committed next, instead of --ref. --git-dir is the repository's .git directory."),
+		dirtyWorktree:         flagSet.String("dirty-worktree", "", "Working tree of a non-bare --git-dir to show the uncommitted changes of on top of --ref, which should be the branch it has checked out. Changed files are read from disk. Disabled if empty."),
+		expectCommit:          flagSet.String("expect-commit", "", "Refuse to mount unless --ref points to this commit, and keep serving it even if --ref moves. Disabled if empty."),
+		verifySignatures:      flagSet.Bool("verify-signatures", false, "Refuse to mount unless the commit, or the annotated tag for tags, is signed by a key in --gpg-home or --ssh-allowed-signers. The verified commit is served even if the ref moves."),
+		gpgHome:               flagSet.String("gpg-home", "", "GnuPG home directory holding the keyring --verify-signatures trusts. Defaults to GnuPG's own."),
+		sshAllowedSigners:     flagSet.String("ssh-allowed-signers", "", "ssh-keygen allowed signers file --verify-signatures trusts for SSH signatures. Defaults to git's gpg.ssh.allowedSignersFile."),
+		remoteAddress:         flagSet.String("remote", "", "Address of a gitfsd server to mount instead of a local repository."),
+		exposeGitObjects:      flagSet.Bool("expose-git-objects", false, "Expose the repository's refs and objects read-only at /.gitobjects/."),
+		introspection:         flagSet.Bool("introspection", true, "Expose the commit being served at /.gitfs/commit and /.gitfs/describe, its git note at /.gitfs/notes, a snapshot ID of exactly what is served at /.gitfs/id, a counter that grows whenever what is served changes at /.gitfs/epoch, the commits made since the ref last moved at /.gitfs/CHANGELOG.txt, repository statistics at /.gitfs/stats.json, the files held open at /.gitfs/handles, and the last commit to change each path at /.gitfs/meta/<path>.json."),
+		archives:              flagSet.Bool("archives", false, "Browse .tar, .tar.gz, .tgz, and .zip files as read-only directories named after them with a .d suffix."),
+		history:               flagSet.Bool("history", false, "Serve every version of each file at /history-of/<path>/<n>-<short hash>, numbered from the oldest and named after the commit that made it, following files across renames like git log --follow. Shadows any history-of directory in the repository."),
+		releases:              flagSet.Bool("releases", false, "Serve the tree of every tag named after a semantic version, like v1.2.3, at /releases/<tag>/, with /releases/latest linking to the newest one that isn't a prerelease. Shadows any releases directory in the repository."),
+		chunkSeparator:        flagSet.String("chunk-separator", "", "Present files split into numbered chunks named <file><separator><n>, like video.mp4.000, video.mp4.001, and so on for a separator of ., as the single file they were split from. Reads only fetch the chunks they overlap. Chunks are listed as their file but can still be opened by name. Disabled if empty."),
+		maxFileSize:           flagSet.Int64("max-file-size", 0, "Refuse to read files larger than this many bytes. They are still listed with their real size. 0 is unlimited."),
+		rateLimits:            cli.RegisterRateLimitFlags(flagSet),
+		templates:             templates,
+		buildCache:            flagSet.String("build-cache", "", "Directory to keep files ignored by the repository's .gitignore in. They can be written to through the mount so builds can run in it. The mount is read-only if empty."),
+		accessLog:             flagSet.String("access-log", "", "File to append a JSON line to for every file read and directory listed, with the uid that did it and the commit it was read from. Disabled if empty."),
+		symlinks:              flagSet.String("symlinks", "rewrite", "How to serve symlinks pointing outside of the repository: rewrite, hide, or passthrough."),
+		normalization:         flagSet.String("unicode-normalization", "none", "Unicode normalization form to list names in, nfc or nfd, for clients like macOS that ask for names in a different form than they were committed in. Names are found in any form unless this is none."),
+		directoryTimes:        flagSet.Bool("directory-times", false, "Report the time of the last commit that changed something within each directory as its modification time instead of the epoch, so make-style staleness checks work against directories."),
+		directoryOrder:        flagSet.String("directory-order", "git", "Order directory listings by git, name, or dirs-first."),
+		trace:                 flagSet.Bool("trace", false, "Log every FUSE operation with an id and the git commands it ran."),
+		fuseDebug:             flagSet.Bool("fuse-debug", false, "Log every request and response exchanged with the kernel. Very noisy."),
+		idleUnmount:           flagSet.Duration("idle-unmount", 0, "Unmount and exit once nothing has used the mount for this long, like 30m. 0 stays mounted."),
+		attributeTTL:          flagSet.Duration("attribute-ttl", 0, "How long the kernel may cache file attributes, like 500ms. 0 caches them until unmounted, or for a second with --watch."),
+		entryTTL:              flagSet.Duration("entry-ttl", 0, "How long the kernel may cache directory entries, like 500ms. 0 caches them until unmounted, or for a second with --watch."),
+		watch:                 flagSet.Bool("watch", false, "Expect --ref to move while mounted, like a branch that is pushed to, and have the kernel forget what it cached within a second so the new commit shows up promptly."),
+		control:               flagSet.Bool("control", false, "Let 'echo refresh > /.gitfs/control' from inside the mount reopen the repository so a ref that was just pushed to is read again. The mount is writable so it can be written to, but the kernel still caches what it read for --attribute-ttl and --entry-ttl."),
+		controlUIDs:           controlUIDs,
+		adminListen:           flagSet.String("admin-listen", "", "Address to serve cache statistics and flushing on for gitfs cache, and adding, removing, and running git gc on repos of --repos-dir, like localhost:46054. Needs --admin-token-file. Disabled if empty."),
+		adminTokenFile:        flagSet.String("admin-token-file", "", "File holding the token requests to --admin-listen must pass as Authorization: Bearer <token>."),
+		gitFlags:              cli.RegisterGitFlags(flagSet),
+		logFlags:              cli.RegisterLogFlags(flagSet),
+	}
+}
+
+// loadOptions parses the command line and config file into the options for every mount. Mounts from --mount-spec
+// share a single git client and are named after their mount point.
+func loadOptions(errorHandling flag.ErrorHandling) ([]mount.Options, *flags, error) {
+	flagSet := flag.NewFlagSet(os.Args[0], errorHandling)
+	f := registerFlags(flagSet)
+	if err := cli.ParseWithConfig(flagSet, os.Args[1:]); err != nil {
+		return nil, nil, err
+	}
+	options, err := mountOptions(f)
+	return options, f, err
+}
 
-	if *repositoryDirectory == "" {
-		log.Fatalf("Must provide a bare git repository (--git-dir)")
+// mountOptions builds the options for every mount from f.
+func mountOptions(f *flags) ([]mount.Options, error) {
+	if *f.repositoryDirectory == "" && *f.reposDir == "" && *f.remoteAddress == "" {
+		return nil, fmt.Errorf("must provide a bare git repository (--git-dir), a directory of them (--repos-dir), or a remote server (--remote)")
+	}
+	if *f.reposDir != "" {
+		if *f.repositoryDirectory != "" || *f.remoteAddress != "" {
+			return nil, fmt.Errorf("--repos-dir can't be used with --git-dir or --remote")
+		}
+		if len(*f.mountSpecs) > 0 || *f.index || *f.expectCommit != "" || *f.dirtyWorktree != "" {
+			return nil, fmt.Errorf("--repos-dir can't be used with --mount-spec, --index, --expect-commit, or --dirty-worktree, which only apply to a single repository")
+		}
 	}
 
-	if *mountPath == "" {
-		log.Fatalf("Must provide a location to mount into (--mount)")
+	if *f.adminListen != "" && *f.adminTokenFile == "" {
+		return nil, fmt.Errorf("--admin-listen needs --admin-token-file")
 	}
 
-	if _, err := os.Stat(*mountPath); os.IsNotExist(err) {
-		err := os.Mkdir(*mountPath, os.FileMode(0444))
+	if *f.mountPath == "" && len(*f.mountSpecs) == 0 {
+		return nil, fmt.Errorf("must provide a location to mount into (--mount)")
+	}
+
+	var specs []mount.Spec
+	for _, spec := range *f.mountSpecs {
+		parsed, err := mount.ParseSpec(spec)
 		if err != nil {
-			log.Fatalf("Could not create mount path: %v", err)
+			return nil, fmt.Errorf("invalid --mount-spec: %v", err)
 		}
+		specs = append(specs, parsed)
+	}
+	if len(specs) > 0 && *f.repositoryDirectory == "" {
+		return nil, fmt.Errorf("--mount-spec needs a bare git repository (--git-dir)")
+	}
+	if len(specs) > 0 && *f.expectCommit != "" {
+		return nil, fmt.Errorf("--expect-commit only applies to --ref and can't be used with --mount-spec")
+	}
+	if *f.remoteAddress != "" && *f.expectCommit != "" {
+		return nil, fmt.Errorf("--expect-commit needs a bare git repository (--git-dir)")
+	}
+	if *f.remoteAddress != "" && *f.verifySignatures {
+		return nil, fmt.Errorf("--verify-signatures needs a bare git repository (--git-dir)")
 	}
 
-	absoluteMountPath, err := filepath.Abs(*mountPath)
+	ref, err := gitfs.ParseRef(*f.ref)
 	if err != nil {
-		log.Fatalf("failed to resolve path: %v", err)
+		return nil, fmt.Errorf("invalid --ref: %v", err)
+	}
+	var refResolver gitfs.RefResolver
+	if (*f.refResolver == "") != (*f.resolve == "") {
+		return nil, fmt.Errorf("--ref-resolver and --resolve must be used together")
+	}
+	if *f.refResolver != "" {
+		if len(specs) > 0 || *f.index || *f.expectCommit != "" || *f.reposDir != "" || *f.remoteAddress != "" {
+			return nil, fmt.Errorf("--ref-resolver can't be used with --mount-spec, --index, --expect-commit, --repos-dir, or --remote")
+		}
+		refResolver, err = gitfs.ParseRefResolver(*f.refResolver)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --ref-resolver: %v", err)
+		}
+	}
+	if *f.index {
+		if len(specs) > 0 || *f.expectCommit != "" || *f.verifySignatures {
+			return nil, fmt.Errorf("--index can't be used with --mount-spec, --expect-commit, or --verify-signatures since nothing in it is committed")
+		}
+		ref = gitfs.IndexRef()
 	}
-	log.Printf("Attempting to mount to %s", absoluteMountPath)
 
-	config := fuse.MountConfig{
-		ReadOnly:                  true,
-		DisableWritebackCaching:   true,
-		EnableSymlinkCaching:      false,
-		DisableDefaultPermissions: true,
+	symlinkPolicy, err := gitfs.ParseSymlinkPolicy(*f.symlinks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --symlinks: %v", err)
+	}
 
-		DebugLogger: log.New(os.Stderr, "fuse debug: ", 0),
-		ErrorLogger: log.New(os.Stderr, "fuse error: ", 0),
+	normalization, err := gitfs.ParseUnicodeNormalization(*f.normalization)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --unicode-normalization: %v", err)
 	}
 
-	git, err := gitfs.NewCliGit(*repositoryDirectory)
+	directoryOrder, err := gitfs.ParseDirectoryOrder(*f.directoryOrder)
 	if err != nil {
-		log.Fatalf("Failed to create git client for directory '%s': %v", *repositoryDirectory,
-			err)
+		return nil, fmt.Errorf("invalid --directory-order: %v", err)
 	}
 
-	branch := "master"
-	fs := gitfs.NewReferenceFileSystem(git, gitfs.GitReference{Branch: &branch})
+	gitOptions, err := f.gitFlags.Options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid git flags: %v", err)
+	}
+	var controlUIDs []uint32
+	for _, uid := range *f.controlUIDs {
+		parsed, err := strconv.ParseUint(uid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --control-uid '%s': %v", uid, err)
+		}
+		controlUIDs = append(controlUIDs, uint32(parsed))
+	}
+	if len(controlUIDs) > 0 && !*f.control {
+		return nil, fmt.Errorf("--control-uid only applies to --control")
+	}
+
+	if *f.gpgHome != "" {
+		gitOptions = append(gitOptions, gitfs.WithEnvironment("GNUPGHOME="+*f.gpgHome))
+	}
+	if *f.sshAllowedSigners != "" {
+		gitOptions = append(gitOptions, gitfs.WithGitConfig("gpg.ssh.allowedSignersFile", *f.sshAllowedSigners))
+	}
+
+	var tracer *gitfs.Tracer
+	if *f.trace {
+		tracer = gitfs.NewTracer(log.New(os.Stderr, "trace: ", log.Lmicroseconds))
+	}
 
-	server, err := gitfs.NewBillyFuseServer(fs)
+	// FUSE logs go wherever the rest of the process logs, formatted the same way.
+	var debugLogger *log.Logger
+	if *f.fuseDebug {
+		debugLogger = log.New(log.Writer(), "fuse debug: ", log.Flags())
+	}
+
+	options := mount.Options{
+		GitDir:                *f.repositoryDirectory,
+		GitOptions:            gitOptions,
+		Remote:                *f.remoteAddress,
+		ReposDir:              *f.reposDir,
+		ReposDirInterval:      *f.reposDirInterval,
+		RepositoryConcurrency: *f.repositoryConcurrency,
+		Ref:                   ref,
+		RefResolver:           refResolver,
+		ResolveName:           *f.resolve,
+		ResolveInterval:       *f.resolveInterval,
+		ExpectCommit:          *f.expectCommit,
+		DirtyWorktree:         *f.dirtyWorktree,
+		VerifySignatures:      *f.verifySignatures,
+		Symlinks:              symlinkPolicy,
+		Normalization:         normalization,
+		DirectoryTimes:        *f.directoryTimes,
+		DirectoryOrder:        directoryOrder,
+		ExposeGitObjects:      *f.exposeGitObjects,
+		Introspection:         *f.introspection,
+		Archives:              *f.archives,
+		Releases:              *f.releases,
+		History:               *f.history,
+		MaxFileSize:           *f.maxFileSize,
+		ChunkSeparator:        *f.chunkSeparator,
+		RateLimits:            *f.rateLimits,
+		Templates:             *f.templates,
+		BuildCache:            *f.buildCache,
+		AccessLog:             *f.accessLog,
+		MountPoint:            *f.mountPath,
+		HandleSignals:         true,
+		IdleUnmount:           *f.idleUnmount,
+		AttributeTTL:          *f.attributeTTL,
+		EntryTTL:              *f.entryTTL,
+		Watch:                 *f.watch,
+		Control:               *f.control,
+		ControlUIDs:           controlUIDs,
+		Tracer:                tracer,
+
+		DebugLogger: debugLogger,
+		ErrorLogger: log.New(log.Writer(), "fuse error: ", log.Flags()),
+	}
+	if len(specs) == 0 {
+		return []mount.Options{options}, nil
+	}
+
+	options.Git, err = gitfs.NewCliGit(options.GitDir, append(gitOptions, gitfs.WithTracer(tracer))...)
 	if err != nil {
-		log.Fatalf("Failed to start go-billy server: %v", err)
+		return nil, fmt.Errorf("failed to create git client for directory '%s': %v", options.GitDir, err)
 	}
-	log.Println("Server started")
+	var mounts []mount.Options
+	for _, spec := range specs {
+		options.Ref = spec.Ref
+		options.MountPoint = spec.MountPoint
+		options.Name = spec.MountPoint
+		mounts = append(mounts, options)
+	}
+	return mounts, nil
+}
 
-	mounted, err := fuse.Mount(absoluteMountPath, server, &config)
+// mountSet tracks the mounts being served by their mount point so a reload can add and remove them.
+type mountSet struct {
+	mu      sync.Mutex
+	mounted map[string]*mount.Mounted
+	wg      sync.WaitGroup
+	crashes []error
+}
+
+func newMountSet() *mountSet {
+	return &mountSet{mounted: map[string]*mount.Mounted{}}
+}
+
+// mount mounts options and serves it until it is unmounted.
+func (s *mountSet) mount(options mount.Options) (*mount.Mounted, error) {
+	log.Printf("Attempting to mount to %s", options.MountPoint)
+	mounted, err := mount.Mount(context.Background(), options)
 	if err != nil {
-		log.Fatalf("Mount failed: %v", err)
+		return nil, err
 	}
+	log.Printf("Mounted at %s", mounted.Dir())
+
+	s.mu.Lock()
+	s.mounted[mounted.Dir()] = mounted
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		err := mounted.Join(context.Background())
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.mounted, mounted.Dir())
+		if err != nil {
+			s.crashes = append(s.crashes, fmt.Errorf("mount at %s crashed: %v", mounted.Dir(), err))
+		}
+	}()
+	return mounted, nil
+}
 
-	err = mounted.Join(context.Background())
+// unmountAll unmounts everything mounted so far.
+func (s *mountSet) unmountAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, mounted := range s.mounted {
+		mounted.Unmount()
+	}
+}
+
+// wait blocks until every mount has been unmounted and returns why any of them crashed.
+func (s *mountSet) wait() []error {
+	s.wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.crashes
+}
+
+// reload makes the mounts match options: mount points that are new are mounted, those still wanted are reloaded, and
+// the rest are unmounted. New mounts come first so the set is never empty while the process is meant to keep serving.
+func (s *mountSet) reload(options []mount.Options) {
+	s.mu.Lock()
+	current := map[string]*mount.Mounted{}
+	for dir, mounted := range s.mounted {
+		current[dir] = mounted
+	}
+	s.mu.Unlock()
+
+	wanted := map[string]bool{}
+	for _, options := range options {
+		dir, err := filepath.Abs(options.MountPoint)
+		if err != nil {
+			log.Printf("Not mounting %s: %v", options.MountPoint, err)
+			continue
+		}
+		wanted[dir] = true
+		mounted, ok := current[dir]
+		if !ok {
+			if _, err := s.mount(options); err != nil {
+				log.Printf("Mount failed: %v", err)
+			}
+			continue
+		}
+		if err := mounted.Reload(options); err != nil {
+			log.Printf("Keeping the current configuration of %s: %v", dir, err)
+			continue
+		}
+		log.Printf("Reloaded configuration of %s", dir)
+	}
+	for dir, mounted := range current {
+		if wanted[dir] {
+			continue
+		}
+		if err := mounted.Unmount(); err != nil {
+			log.Printf("Failed to unmount %s: %v", dir, err)
+			continue
+		}
+		log.Printf("Unmounted %s", dir)
+	}
+}
+
+// reloadOnHangup re-reads the flags and config file whenever the process receives SIGHUP, mounting and unmounting
+// whatever --mount-spec entries were added or removed.
+func reloadOnHangup(mounts *mountSet) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		log.Printf("Received SIGHUP, reloading configuration")
+		options, _, err := loadOptions(flag.ContinueOnError)
+		if err != nil {
+			log.Printf("Keeping the current configuration: %v", err)
+			continue
+		}
+		mounts.reload(options)
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "warm" {
+		if err := warm(os.Args[2:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cp" {
+		if err := cp(os.Args[2:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bisect-mount" {
+		if err := bisectMount(os.Args[2:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "link-farm" {
+		if err := linkFarm(os.Args[2:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		if err := cache(os.Args[2:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "log" {
+		if err := gitLog(os.Args[2:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		if err := doctor(os.Args[2:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	mountOptions, f, err := loadOptions(flag.ExitOnError)
 	if err != nil {
-		log.Fatalf("Mount crashed: %v", err)
+		log.Fatalf("%v", err)
+	}
+	if err := f.logFlags.Apply(); err != nil {
+		log.Fatalf("%v", err)
 	}
 
+	var adminToken string
+	if *f.adminListen != "" {
+		adminToken, err = cli.ReadAdminToken(*f.adminTokenFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	mounts := newMountSet()
+	var first *mount.Mounted
+	for _, options := range mountOptions {
+		mounted, err := mounts.mount(options)
+		if err != nil {
+			mounts.unmountAll()
+			log.Fatalf("Mount failed: %v", err)
+		}
+		if first == nil {
+			first = mounted
+		}
+	}
+
+	if *f.adminListen != "" {
+		cache, err := f.gitFlags.OpenCache()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		admin := http.NewServeMux()
+		admin.Handle(httpfs.CacheAdminPath, httpfs.NewCacheAdminHandler(cache, adminToken))
+		if *f.reposDir != "" {
+			admin.Handle(httpfs.RepositoriesAdminPath, httpfs.NewRepositoriesAdminHandler(first, *f.reposDir, adminToken))
+		}
+		go func() {
+			log.Printf("Admin server started at %s", *f.adminListen)
+			log.Fatalf("Admin server crashed: %v", http.ListenAndServe(*f.adminListen, admin))
+		}()
+	}
+
+	go reloadOnHangup(mounts)
+
+	for _, err := range mounts.wait() {
+		log.Fatalf("%v", err)
+	}
 }
@@ -0,0 +1,75 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gravypod/gitfs/internal/cli"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"log"
+	"time"
+)
+
+// linkFarm implements `gitfs link-farm`, which materializes a revision as hard links into a blob store shared by
+// every farm built from it, so local copies of many commits take about as long as a checkout and share storage.
+func linkFarm(args []string) error {
+	flagSet := flag.NewFlagSet("gitfs link-farm", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: gitfs link-farm [flags] <dst-dir>\n")
+		flagSet.PrintDefaults()
+	}
+	cli.RegisterConfigFlag(flagSet)
+	repositoryDirectory := flagSet.String("git-dir", "", "Path to bare git repo to read.")
+	ref := flagSet.String("ref", "master", "Branch, tag, or commit to materialize, like main, refs/tags/v1.2, or a commit hash.")
+	store := flagSet.String("store", "", "Directory of blobs to link to, filled in from --git-dir as needed. It must be on the same filesystem as <dst-dir> and can be shared by any number of farms.")
+	jobs := flagSet.Int("jobs", 0, "How many git commands read blobs at once. 0 runs one per CPU.")
+	gitFlags := cli.RegisterGitFlags(flagSet)
+	if err := cli.ParseWithConfig(flagSet, args); err != nil {
+		return err
+	}
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+		return fmt.Errorf("must provide a directory to create the farm in")
+	}
+	if *repositoryDirectory == "" {
+		return fmt.Errorf("must provide a bare git repository (--git-dir)")
+	}
+	if *store == "" {
+		return fmt.Errorf("must provide a directory to keep blobs in (--store)")
+	}
+
+	reference, err := gitfs.ParseRef(*ref)
+	if err != nil {
+		return fmt.Errorf("invalid --ref: %v", err)
+	}
+	gitOptions, err := gitFlags.Options()
+	if err != nil {
+		return fmt.Errorf("invalid git flags: %v", err)
+	}
+	git, err := gitfs.NewCliGit(*repositoryDirectory, gitOptions...)
+	if err != nil {
+		return fmt.Errorf("failed to create git client for directory '%s': %v", *repositoryDirectory, err)
+	}
+
+	start := time.Now()
+	stats, err := gitfs.LinkFarm(git, reference, *store, flagSet.Arg(0), gitfs.LinkFarmOptions{Jobs: *jobs})
+	if err != nil {
+		return fmt.Errorf("failed to build link farm: %v", err)
+	}
+	log.Printf("Linked %d files, storing %d new blobs (%d bytes), in %s", stats.Files, stats.Stored,
+		stats.StoredBytes, time.Since(start))
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/gravypod/gitfs/internal/cli"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"os"
+	"time"
+)
+
+// gitLog implements `gitfs log --grep`, which finds the commits whose message matches a pattern like git log --grep
+// and prints each with the lines that matched, or all of them as the JSON githttp's commit search returns.
+func gitLog(args []string) error {
+	flagSet := flag.NewFlagSet("gitfs log", flag.ExitOnError)
+	cli.RegisterConfigFlag(flagSet)
+	repositoryDirectory := flagSet.String("git-dir", "", "Path to bare git repo to search.")
+	ref := flagSet.String("ref", "master", "Branch, tag, or commit to search the history of.")
+	pattern := flagSet.String("grep", "", "Extended regular expression to match commit messages against, line by line.")
+	limit := flagSet.Int("limit", 0, "Stop after this many commits. 0 is unlimited.")
+	asJSON := flagSet.Bool("json", false, "Print the commits as a JSON array instead of one per line.")
+	gitFlags := cli.RegisterGitFlags(flagSet)
+	if err := cli.ParseWithConfig(flagSet, args); err != nil {
+		return err
+	}
+
+	if *repositoryDirectory == "" {
+		return fmt.Errorf("must provide a bare git repository (--git-dir)")
+	}
+	if *pattern == "" {
+		return fmt.Errorf("must provide a pattern to search for (--grep)")
+	}
+	gitOptions, err := gitFlags.Options()
+	if err != nil {
+		return fmt.Errorf("invalid git flags: %v", err)
+	}
+	git, err := gitfs.NewCliGit(*repositoryDirectory, gitOptions...)
+	if err != nil {
+		return fmt.Errorf("failed to create git client for directory '%s': %v", *repositoryDirectory, err)
+	}
+
+	results, err := gitfs.SearchCommits(git, gitfs.CommitRef(*ref), *pattern, *limit)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	}
+	for _, result := range results {
+		fmt.Printf("%s %s %s\n", result.Hash, result.Date.Format(time.RFC3339), result.Subject)
+		for i, match := range result.Matches {
+			// The subject was already printed.
+			if i == 0 && match == result.Subject {
+				continue
+			}
+			fmt.Printf("    %s\n", match)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/gravypod/gitfs/internal/cli"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"log"
+	"time"
+)
+
+// warm implements `gitfs warm`, which reads a revision ahead of time so the first build against a mount doesn't pay
+// for cold caches. With --git-dir the whole revision is read by two git commands, and with --mount it walks an
+// existing mount, which also fills the kernel's caches.
+func warm(args []string) error {
+	flagSet := flag.NewFlagSet("gitfs warm", flag.ExitOnError)
+	cli.RegisterConfigFlag(flagSet)
+	repositoryDirectory := flagSet.String("git-dir", "", "Path to bare git repo to read.")
+	ref := flagSet.String("ref", "master", "Branch, tag, or commit to read.")
+	mountPath := flagSet.String("mount", "", "Walk this gitfs mount instead of reading --git-dir directly.")
+	var paths cli.StringList
+	flagSet.Var(&paths, "paths", "Only read files matching this pattern. Directories are always listed. May be repeated.")
+	gitFlags := cli.RegisterGitFlags(flagSet)
+	if err := cli.ParseWithConfig(flagSet, args); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var stats gitfs.WarmStats
+	switch {
+	case *mountPath != "":
+		var err error
+		if stats, err = gitfs.Warm(osfs.New(*mountPath), paths); err != nil {
+			return err
+		}
+	case *repositoryDirectory != "":
+		gitOptions, err := gitFlags.Options()
+		if err != nil {
+			return fmt.Errorf("invalid git flags: %v", err)
+		}
+		git, err := gitfs.NewCliGit(*repositoryDirectory, gitOptions...)
+		if err != nil {
+			return fmt.Errorf("failed to create git client for directory '%s': %v", *repositoryDirectory, err)
+		}
+		commit, err := git.ResolveCommit(gitfs.CommitRef(*ref))
+		if err != nil {
+			return fmt.Errorf("failed to resolve --ref %s: %v", *ref, err)
+		}
+		if stats, err = gitfs.WarmTree(git, gitfs.CommitRef(commit), paths); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("must provide a bare git repository (--git-dir) or a mount (--mount)")
+	}
+
+	log.Printf("Warmed %d directories and %d files (%d bytes) in %s", stats.Directories, stats.Files, stats.Bytes,
+		time.Since(start))
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"github.com/gravypod/gitfs/internal/cli"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"github.com/gravypod/gitfs/pkg/remote"
+	"log"
+	"net"
+	"os"
+)
+
+var (
+	repositoryDirectory = flag.String("git-dir", "", "Path to bare git repo to serve.")
+	listenAddress       = flag.String("listen", "localhost:46052", "Address to serve the remote filesystem protocol on. The protocol has no authentication, so only listen on other interfaces, like 0.0.0.0:46052, on networks where every machine may read the repository.")
+	introspection       = flag.Bool("introspection", true, "Expose the commit being served at /.gitfs/commit and /.gitfs/describe, its git note at /.gitfs/notes, a snapshot ID of exactly what is served at /.gitfs/id, a counter that grows whenever what is served changes at /.gitfs/epoch, the commits made since the ref last moved at /.gitfs/CHANGELOG.txt, repository statistics at /.gitfs/stats.json, the files held open at /.gitfs/handles, and the last commit to change each path at /.gitfs/meta/<path>.json.")
+	archives            = flag.Bool("archives", false, "Browse .tar, .tar.gz, .tgz, and .zip files as read-only directories named after them with a .d suffix.")
+	history             = flag.Bool("history", false, "Serve every version of each file at /history-of/<path>/<n>-<short hash>, numbered from the oldest and named after the commit that made it, following files across renames like git log --follow. Shadows any history-of directory in the repository.")
+	releases            = flag.Bool("releases", false, "Serve the tree of every tag named after a semantic version, like v1.2.3, at /releases/<tag>/, with /releases/latest linking to the newest one that isn't a prerelease. Shadows any releases directory in the repository.")
+	chunkSeparator      = flag.String("chunk-separator", "", "Present files split into numbered chunks named <file><separator><n>, like video.mp4.000, video.mp4.001, and so on for a separator of ., as the single file they were split from. Reads only fetch the chunks they overlap. Chunks are listed as their file but can still be opened by name. Disabled if empty.")
+	maxFileSize         = flag.Int64("max-file-size", 0, "Refuse to read files larger than this many bytes. They are still listed with their real size. 0 is unlimited.")
+	symlinks            = flag.String("symlinks", "rewrite", "How to serve symlinks pointing outside of the repository: rewrite, hide, or passthrough.")
+	directoryTimes      = flag.Bool("directory-times", false, "Report the time of the last commit that changed something within each directory as its modification time instead of the epoch, so make-style staleness checks work against directories.")
+	directoryOrder      = flag.String("directory-order", "git", "Order directory listings by git, name, or dirs-first.")
+	trace               = flag.Bool("trace", false, "Log every remote filesystem call with an id and the git commands it ran.")
+	gitFlags            = cli.RegisterGitFlags(flag.CommandLine)
+	logFlags            = cli.RegisterLogFlags(flag.CommandLine)
+	rateLimits          = cli.RegisterRateLimitFlags(flag.CommandLine)
+	secretFilter        = flag.Bool("secret-filter", true, "Refuse to serve files that look like they contain private keys or access tokens.")
+	templates           cli.StringList
+	secretPatterns      cli.StringList
+)
+
+func init() {
+	flag.Var(&secretPatterns, "secret-pattern", "A regular expression for --secret-filter to refuse files matching instead of the built in patterns. May be repeated.")
+	flag.Var(&templates, "template", "Expand @@COMMIT@@, @@DESCRIBE@@, @@REF@@, @@BRANCH@@, and @@TAG@@ in files matching this pattern. May be repeated.")
+}
+
+func main() {
+	cli.RegisterConfigFlag(flag.CommandLine)
+	if err := cli.ParseWithConfig(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := logFlags.Apply(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if len(*repositoryDirectory) == 0 {
+		log.Fatalf("Must provide a bare git repository (--git-dir)")
+	}
+
+	symlinkPolicy, err := gitfs.ParseSymlinkPolicy(*symlinks)
+	if err != nil {
+		log.Fatalf("Invalid --symlinks: %v", err)
+	}
+
+	order, err := gitfs.ParseDirectoryOrder(*directoryOrder)
+	if err != nil {
+		log.Fatalf("Invalid --directory-order: %v", err)
+	}
+
+	gitOptions, err := gitFlags.Options()
+	if err != nil {
+		log.Fatalf("Invalid git flags: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", *listenAddress)
+	if err != nil {
+		log.Fatalf("could not bind tcp port: %v", err)
+	}
+	defer listener.Close()
+
+	var tracer *gitfs.Tracer
+	if *trace {
+		tracer = gitfs.NewTracer(log.New(os.Stderr, "trace: ", log.Lmicroseconds))
+		gitOptions = append(gitOptions, gitfs.WithTracer(tracer))
+	}
+
+	git, err := gitfs.NewCliGit(*repositoryDirectory, gitOptions...)
+	if err != nil {
+		log.Fatalf("Failed to create git client for directory '%s': %v", *repositoryDirectory,
+			err)
+	}
+
+	fs, err := gitfs.New(git, gitfs.Options{
+		Ref:            gitfs.BranchRef("master"),
+		Symlinks:       symlinkPolicy,
+		DirectoryTimes: *directoryTimes,
+		History:        *history,
+		Releases:       *releases,
+		MaxFileSize:    *maxFileSize,
+		RateLimits:     *rateLimits,
+		ChunkSeparator: *chunkSeparator,
+		Archives:       *archives,
+		Templates:      templates,
+		Introspection:  *introspection,
+		GitDir:         *repositoryDirectory,
+		SecretFilter:   *secretFilter,
+		SecretPatterns: secretPatterns,
+		DirectoryOrder: order,
+	})
+	if err != nil {
+		log.Fatalf("Failed to build filesystem: %v", err)
+	}
+	fs = gitfs.NewTracingFileSystem(fs, tracer, "remote")
+
+	err = remote.Serve(listener, fs, git)
+	if err != nil {
+		log.Fatalf("Remote server crashed: %v", err)
+	}
+}
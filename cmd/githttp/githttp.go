@@ -0,0 +1,194 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"github.com/gravypod/gitfs/internal/cli"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"github.com/gravypod/gitfs/pkg/httpfs"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+)
+
+var (
+	repositoryDirectory = flag.String("git-dir", "", "Path to bare git repo to serve.")
+	listenAddress       = flag.String("listen", "0.0.0.0:46053", "Address to serve HTTP on.")
+	smartHTTP           = flag.Bool("smart-http", false, "Also serve the repository to git clone and git fetch at /.git.")
+	readme              = flag.Bool("readme", false, "Render the README.md of each directory above its listing.")
+	listingTemplate     = flag.String("listing-template", "", "An html/template file to render directory listings with instead of the built in listing. It is executed with an httpfs.Listing.")
+	maxListingEntries   = flag.Int("max-listing-entries", 10000, "The most entries a JSON directory listing returns at once. Larger directories are listed a page at a time, each page naming the entry to pass as ?after= to get the next. 0 is unlimited.")
+	commitSearch        = flag.Bool("commit-search", false, "Search the messages of the commits reachable from the branch being served at /api/search/commits?q=<pattern>, with an extended regular expression like git log --grep takes. Shadows that path in the repository.")
+	introspection       = flag.Bool("introspection", true, "Expose the commit being served at /.gitfs/commit and /.gitfs/describe, its git note at /.gitfs/notes, a snapshot ID of exactly what is served at /.gitfs/id, a counter that grows whenever what is served changes at /.gitfs/epoch, the commits made since the ref last moved at /.gitfs/CHANGELOG.txt, repository statistics at /.gitfs/stats.json, the files held open at /.gitfs/handles, and the last commit to change each path at /.gitfs/meta/<path>.json.")
+	archives            = flag.Bool("archives", false, "Browse .tar, .tar.gz, .tgz, and .zip files as read-only directories named after them with a .d suffix.")
+	history             = flag.Bool("history", false, "Serve every version of each file at /history-of/<path>/<n>-<short hash>, numbered from the oldest and named after the commit that made it, following files across renames like git log --follow. Shadows any history-of directory in the repository.")
+	releases            = flag.Bool("releases", false, "Serve the tree of every tag named after a semantic version, like v1.2.3, at /releases/<tag>/, with /releases/latest linking to the newest one that isn't a prerelease. Shadows any releases directory in the repository.")
+	textOnly            = flag.Bool("text-only", false, "Serve a one line placeholder instead of each binary file, told apart like git does by a null byte near its start, for exporting just the source of a tree to review tools. Applies to files in browsed archives too.")
+	chunkSeparator      = flag.String("chunk-separator", "", "Present files split into numbered chunks named <file><separator><n>, like video.mp4.000, video.mp4.001, and so on for a separator of ., as the single file they were split from. Reads only fetch the chunks they overlap. Chunks are listed as their file but can still be opened by name. Disabled if empty.")
+	maxFileSize         = flag.Int64("max-file-size", 0, "Refuse to read files larger than this many bytes. They are still listed with their real size. 0 is unlimited.")
+	symlinks            = flag.String("symlinks", "rewrite", "How to serve symlinks pointing outside of the repository: rewrite, hide, or passthrough.")
+	directoryTimes      = flag.Bool("directory-times", false, "Report the time of the last commit that changed something within each directory as its modification time instead of the epoch, so make-style staleness checks work against directories.")
+	directoryOrder      = flag.String("directory-order", "git", "Order directory listings by git, name, or dirs-first.")
+	hideDotfiles        = flag.String("hide-dotfiles", "none", "Hide files and directories starting with a dot: none, listings to leave them out of listings, or strict to hide them completely.")
+	gitFlags            = cli.RegisterGitFlags(flag.CommandLine)
+	logFlags            = cli.RegisterLogFlags(flag.CommandLine)
+	rateLimits          = cli.RegisterRateLimitFlags(flag.CommandLine)
+	accessLog           = flag.String("access-log", "", "File to append a JSON line to for every file read and directory listed, with the client address and the commit it was read from. Disabled if empty.")
+	adminListen         = flag.String("admin-listen", "", "Address to serve cache statistics and flushing on, separately from --listen, like localhost:46054. Needs --admin-token-file. Disabled if empty.")
+	adminTokenFile      = flag.String("admin-token-file", "", "File holding the token requests to --admin-listen must pass as Authorization: Bearer <token>.")
+	secretFilter        = flag.Bool("secret-filter", true, "Refuse to serve files that look like they contain private keys or access tokens. Can't be combined with --smart-http, which would serve them anyway.")
+	templates           cli.StringList
+	secretPatterns      cli.StringList
+)
+
+func init() {
+	flag.Var(&secretPatterns, "secret-pattern", "A regular expression for --secret-filter to refuse files matching instead of the built in patterns. May be repeated.")
+	flag.Var(&templates, "template", "Expand @@COMMIT@@, @@DESCRIBE@@, @@REF@@, @@BRANCH@@, and @@TAG@@ in files matching this pattern. May be repeated.")
+}
+
+func main() {
+	cli.RegisterConfigFlag(flag.CommandLine)
+	if err := cli.ParseWithConfig(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := logFlags.Apply(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if len(*repositoryDirectory) == 0 {
+		log.Fatalf("Must provide a bare git repository (--git-dir)")
+	}
+	if *secretFilter && *smartHTTP {
+		log.Fatalf("--smart-http lets anyone clone every blob without --secret-filter scanning it, pass --secret-filter=false to serve both")
+	}
+
+	symlinkPolicy, err := gitfs.ParseSymlinkPolicy(*symlinks)
+	if err != nil {
+		log.Fatalf("Invalid --symlinks: %v", err)
+	}
+
+	order, err := gitfs.ParseDirectoryOrder(*directoryOrder)
+	if err != nil {
+		log.Fatalf("Invalid --directory-order: %v", err)
+	}
+
+	dotfilePolicy, err := gitfs.ParseDotfilePolicy(*hideDotfiles)
+	if err != nil {
+		log.Fatalf("Invalid --hide-dotfiles: %v", err)
+	}
+
+	gitOptions, err := gitFlags.Options()
+	if err != nil {
+		log.Fatalf("Invalid git flags: %v", err)
+	}
+
+	git, err := gitfs.NewCliGit(*repositoryDirectory, gitOptions...)
+	if err != nil {
+		log.Fatalf("Failed to create git client for directory '%s': %v", *repositoryDirectory,
+			err)
+	}
+
+	reference := gitfs.BranchRef("master")
+	fs, err := gitfs.New(git, gitfs.Options{
+		Ref:            reference,
+		Symlinks:       symlinkPolicy,
+		DirectoryTimes: *directoryTimes,
+		History:        *history,
+		Releases:       *releases,
+		MaxFileSize:    *maxFileSize,
+		RateLimits:     *rateLimits,
+		ChunkSeparator: *chunkSeparator,
+		Archives:       *archives,
+		Templates:      templates,
+		Dotfiles:       dotfilePolicy,
+		TextOnly:       *textOnly,
+		Introspection:  *introspection,
+		GitDir:         *repositoryDirectory,
+		SecretFilter:   *secretFilter,
+		SecretPatterns: secretPatterns,
+		DirectoryOrder: order,
+	})
+	if err != nil {
+		log.Fatalf("Failed to build filesystem: %v", err)
+	}
+
+	var listing *template.Template
+	if *listingTemplate != "" {
+		listing, err = template.ParseFiles(*listingTemplate)
+		if err != nil {
+			log.Fatalf("Invalid --listing-template: %v", err)
+		}
+	}
+
+	handlerOptions := httpfs.HandlerOptions{Listing: listing, MaxListingEntries: *maxListingEntries}
+	if *readme {
+		handlerOptions.Readme = httpfs.BasicMarkdown{}
+	}
+	handler := httpfs.NewHandlerWithOptions(fs, handlerOptions)
+	if *accessLog != "" {
+		file, err := os.OpenFile(*accessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatalf("Failed to open access log: %v", err)
+		}
+		accessLog := gitfs.NewAccessLog(file, git, reference)
+		handler = httpfs.NewPerClientHandler(func(client string) http.Handler {
+			return httpfs.NewHandlerWithOptions(accessLog.FileSystem(fs, client), handlerOptions)
+		})
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", handler)
+	if *commitSearch {
+		mux.Handle(httpfs.CommitSearchPath, httpfs.NewCommitSearchHandler(git, reference))
+	}
+	if *smartHTTP {
+		executable := gitFlags.Executable
+		if executable == "" {
+			executable = "git"
+		}
+		executable, err = exec.LookPath(executable)
+		if err != nil {
+			log.Fatalf("Failed to find git for --smart-http: %v", err)
+		}
+		mux.Handle(httpfs.SmartHTTPPath+"/", httpfs.NewSmartHTTPHandler(executable, *repositoryDirectory, httpfs.SmartHTTPPath))
+	}
+
+	if *adminListen != "" {
+		if *adminTokenFile == "" {
+			log.Fatalf("--admin-listen needs --admin-token-file")
+		}
+		token, err := cli.ReadAdminToken(*adminTokenFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		cache, err := gitFlags.OpenCache()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		go func() {
+			log.Printf("Admin server started at %s\n", *adminListen)
+			log.Fatalf("Admin server crashed: %v", http.ListenAndServe(*adminListen, httpfs.NewCacheAdminHandler(cache, token)))
+		}()
+	}
+
+	log.Printf("HTTP server started at %s\n", *listenAddress)
+	err = http.ListenAndServe(*listenAddress, mux)
+	if err != nil {
+		log.Fatalf("HTTP server crashed: %v", err)
+	}
+}
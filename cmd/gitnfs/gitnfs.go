@@ -15,42 +15,321 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"flag"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/gravypod/gitfs/internal/cli"
 	gitfs "github.com/gravypod/gitfs/pkg"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	gitnfs "github.com/gravypod/gitfs/pkg/nfs"
 	"github.com/willscott/go-nfs"
 	nfshelper "github.com/willscott/go-nfs/helpers"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-var repositoryDirectory = flag.String("git-dir", "", "Path to bare git repo to serve.")
+type flags struct {
+	repositoryDirectory *string
+	listenAddress       *string
+	portmapper          *bool
+	portmapperAddress   *string
+	maxClients          *int
+	perClientRate       *float64
+	metricsAddress      *string
+	exposeGitObjects    *bool
+	introspection       *bool
+	archives            *bool
+	releases            *bool
+	history             *bool
+	maxFileSize         *int64
+	chunkSeparator      *string
+	rateLimits          *gitfs.RateLimits
+	templates           *cli.StringList
+	secretFilter        *bool
+	secretPatterns      *cli.StringList
+	symlinks            *string
+	followSymlinks      *bool
+	normalization       *string
+	directoryTimes      *bool
+	directoryOrder      *string
+	hideDotfiles        *string
+	trace               *bool
+	accessLog           *string
+	idleExit            *time.Duration
+	gitFlags            *cli.GitFlags
+	logFlags            *cli.LogFlags
+}
+
+func registerFlags(flagSet *flag.FlagSet) *flags {
+	cli.RegisterConfigFlag(flagSet)
+	templates := new(cli.StringList)
+	secretPatterns := new(cli.StringList)
+	flagSet.Var(secretPatterns, "secret-pattern", "A regular expression for --secret-filter to refuse files matching instead of the built in patterns. May be repeated.")
+	flagSet.Var(templates, "template", "Expand @@COMMIT@@, @@DESCRIBE@@, @@REF@@, @@BRANCH@@, and @@TAG@@ in files matching this pattern. May be repeated.")
+	return &flags{
+		repositoryDirectory: flagSet.String("git-dir", "", "Path to bare git repo to serve."),
+		listenAddress:       flagSet.String("listen", "0.0.0.0:46051", "Address to serve NFS and MOUNT on."),
+		portmapper:          flagSet.Bool("portmapper", false, "Run a portmapper so clients can find the NFS and MOUNT services without being told the port. Don't use this if the host already runs rpcbind."),
+		portmapperAddress:   flagSet.String("portmapper-listen", "0.0.0.0:111", "Address to serve the portmapper on over TCP and UDP."),
+		maxClients:          flagSet.Int("max-clients", 0, "Most client addresses that may be connected at once. 0 is unlimited."),
+		perClientRate:       flagSet.Float64("per-client-rate", 0, "Requests per second each client address may make before it is slowed down. 0 is unlimited."),
+		metricsAddress:      flagSet.String("metrics-listen", "", "Address to serve per-client statistics on at /debug/vars. Disabled if empty."),
+		exposeGitObjects:    flagSet.Bool("expose-git-objects", false, "Expose the repository's refs and objects read-only at /.gitobjects/."),
+		introspection:       flagSet.Bool("introspection", true, "Expose the commit being served at /.gitfs/commit and /.gitfs/describe, its git note at /.gitfs/notes, a snapshot ID of exactly what is served at /.gitfs/id, a counter that grows whenever what is served changes at /.gitfs/epoch, the commits made since the ref last moved at /.gitfs/CHANGELOG.txt, repository statistics at /.gitfs/stats.json, the files held open at /.gitfs/handles, and the last commit to change each path at /.gitfs/meta/<path>.json."),
+		archives:            flagSet.Bool("archives", false, "Browse .tar, .tar.gz, .tgz, and .zip files as read-only directories named after them with a .d suffix."),
+		history:             flagSet.Bool("history", false, "Serve every version of each file at /history-of/<path>/<n>-<short hash>, numbered from the oldest and named after the commit that made it, following files across renames like git log --follow. Shadows any history-of directory in the repository."),
+		releases:            flagSet.Bool("releases", false, "Serve the tree of every tag named after a semantic version, like v1.2.3, at /releases/<tag>/, with /releases/latest linking to the newest one that isn't a prerelease. Shadows any releases directory in the repository."),
+		chunkSeparator:      flagSet.String("chunk-separator", "", "Present files split into numbered chunks named <file><separator><n>, like video.mp4.000, video.mp4.001, and so on for a separator of ., as the single file they were split from. Reads only fetch the chunks they overlap. Chunks are listed as their file but can still be opened by name. Disabled if empty."),
+		maxFileSize:         flagSet.Int64("max-file-size", 0, "Refuse to read files larger than this many bytes. They are still listed with their real size. 0 is unlimited."),
+		rateLimits:          cli.RegisterRateLimitFlags(flagSet),
+		templates:           templates,
+		secretFilter:        flagSet.Bool("secret-filter", true, "Refuse to serve files that look like they contain private keys or access tokens. Can't be combined with --expose-git-objects, which would serve them anyway."),
+		secretPatterns:      secretPatterns,
+		symlinks:            flagSet.String("symlinks", "rewrite", "How to serve symlinks pointing outside of the repository: rewrite, hide, or passthrough."),
+		followSymlinks:      flagSet.Bool("follow-symlinks", false, "Resolve symlinks on the server and serve those pointing to files and directories in the repository as what they point to, for clients that can't follow symlinks or would follow them outside of the export. Symlinks that dangle or point outside of the repository are still served as symlinks."),
+		normalization:       flagSet.String("unicode-normalization", "none", "Unicode normalization form to list names in, nfc or nfd, for clients like macOS that ask for names in a different form than they were committed in. Names are found in any form unless this is none."),
+		directoryTimes:      flagSet.Bool("directory-times", false, "Report the time of the last commit that changed something within each directory as its modification time instead of the epoch, so make-style staleness checks work against directories."),
+		directoryOrder:      flagSet.String("directory-order", "git", "Order directory listings by git, name, or dirs-first."),
+		hideDotfiles:        flagSet.String("hide-dotfiles", "none", "Hide files and directories starting with a dot: none, listings to leave them out of listings, or strict to hide them completely."),
+		trace:               flagSet.Bool("trace", false, "Log every NFS filesystem call with an id and the git commands it ran."),
+		accessLog:           flagSet.String("access-log", "", "File to append a JSON line to for every file read and directory listed, with the client address and the commit it was read from. Disabled if empty."),
+		idleExit:            flagSet.Duration("idle-exit", 0, "Stop serving and exit once no NFS requests have touched the filesystem for this long, like 30m. 0 serves forever."),
+		gitFlags:            cli.RegisterGitFlags(flagSet),
+		logFlags:            cli.RegisterLogFlags(flagSet),
+	}
+}
+
+// loadFileSystem parses the command line and config file and builds the filesystem they describe.
+func loadFileSystem(errorHandling flag.ErrorHandling) (*flags, billy.Filesystem, error) {
+	flagSet := flag.NewFlagSet(os.Args[0], errorHandling)
+	f := registerFlags(flagSet)
+	if err := cli.ParseWithConfig(flagSet, os.Args[1:]); err != nil {
+		return nil, nil, err
+	}
+
+	if len(*f.repositoryDirectory) == 0 {
+		return nil, nil, fmt.Errorf("no repository provided. Please specify '-git-dir'")
+	}
+	// Loose objects and packs are compressed, so the secret filter could never match what they contain.
+	if *f.secretFilter && *f.exposeGitObjects {
+		return nil, nil, fmt.Errorf("--expose-git-objects serves every blob without --secret-filter scanning it, pass --secret-filter=false to serve both")
+	}
+
+	symlinkPolicy, err := gitfs.ParseSymlinkPolicy(*f.symlinks)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid --symlinks: %v", err)
+	}
+
+	normalization, err := gitfs.ParseUnicodeNormalization(*f.normalization)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid --unicode-normalization: %v", err)
+	}
+
+	directoryOrder, err := gitfs.ParseDirectoryOrder(*f.directoryOrder)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid --directory-order: %v", err)
+	}
+
+	dotfilePolicy, err := gitfs.ParseDotfilePolicy(*f.hideDotfiles)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid --hide-dotfiles: %v", err)
+	}
+
+	gitOptions, err := f.gitFlags.Options()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid git flags: %v", err)
+	}
+
+	var tracer *gitfs.Tracer
+	if *f.trace {
+		tracer = gitfs.NewTracer(log.New(os.Stderr, "trace: ", log.Lmicroseconds))
+		gitOptions = append(gitOptions, gitfs.WithTracer(tracer))
+	}
+
+	git, err := gitfs.NewCliGit(*f.repositoryDirectory, gitOptions...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create git client for directory '%s': %v", *f.repositoryDirectory, err)
+	}
+
+	fs, err := gitfs.New(git, gitfs.Options{
+		Ref:                 gitfs.BranchRef("master"),
+		Symlinks:            symlinkPolicy,
+		DereferenceSymlinks: *f.followSymlinks,
+		Normalization:       normalization,
+		DirectoryTimes:      *f.directoryTimes,
+		History:             *f.history,
+		Releases:            *f.releases,
+		MaxFileSize:         *f.maxFileSize,
+		RateLimits:          *f.rateLimits,
+		ChunkSeparator:      *f.chunkSeparator,
+		Archives:            *f.archives,
+		Templates:           *f.templates,
+		Dotfiles:            dotfilePolicy,
+		Introspection:       *f.introspection,
+		ExposeGitObjects:    *f.exposeGitObjects,
+		GitDir:              *f.repositoryDirectory,
+		SecretFilter:        *f.secretFilter,
+		SecretPatterns:      *f.secretPatterns,
+		DirectoryOrder:      directoryOrder,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build filesystem: %v", err)
+	}
+	fs = gitfs.NewTracingFileSystem(fs, tracer, "nfs")
+	return f, fs, nil
+}
+
+// reloadOnHangup re-reads the flags and config file whenever the process receives SIGHUP. The repository being
+// served and the addresses being listened on can't be changed this way.
+func reloadOnHangup(repositoryDirectory string, swappable *gitfs.SwappableFileSystem) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		log.Printf("Received SIGHUP, reloading configuration")
+		f, fs, err := loadFileSystem(flag.ContinueOnError)
+		if err != nil {
+			log.Printf("Keeping the current configuration: %v", err)
+			continue
+		}
+		if *f.repositoryDirectory != repositoryDirectory {
+			log.Printf("Keeping the current configuration: changing --git-dir requires a restart")
+			continue
+		}
+		swappable.Swap(fs)
+		log.Printf("Reloaded configuration")
+	}
+}
+
+// servePortmapper advertises the NFS and MOUNT services listening on port. go-nfs serves both programs on the same
+// TCP port and doesn't support UDP, so only TCP mappings are registered.
+func servePortmapper(address string, port int) error {
+	mappings := []gitnfs.Mapping{
+		{Program: gitnfs.NfsProgram, Version: 3, Protocol: gitnfs.ProtocolTCP, Port: uint32(port)},
+		{Program: gitnfs.MountProgram, Version: 3, Protocol: gitnfs.ProtocolTCP, Port: uint32(port)},
+	}
+	portmapper := gitnfs.NewPortmapper(mappings...)
+
+	udp, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	tcp, err := net.Listen("tcp", address)
+	if err != nil {
+		udp.Close()
+		return err
+	}
+	go func() {
+		log.Printf("portmapper stopped serving udp: %v", portmapper.ServeUDP(udp))
+	}()
+	go func() {
+		log.Printf("portmapper stopped serving tcp: %v", portmapper.ServeTCP(tcp))
+	}()
+	log.Printf("Portmapper started at %s\n", address)
+	return nil
+}
+
+// openAccessLog appends to the access log at path, resolving the commit being served with its own git client. It
+// returns nil if path is empty.
+func openAccessLog(path, repositoryDirectory string, gitFlags *cli.GitFlags) (*gitfs.AccessLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	gitOptions, err := gitFlags.Options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid git flags: %v", err)
+	}
+	// The access log only resolves the ref now and then, which isn't worth keeping git processes running for.
+	git, err := gitfs.NewCliGit(repositoryDirectory, append(gitOptions, gitfs.WithProcessPool(0, 0))...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create git client for directory '%s': %v", repositoryDirectory, err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %v", err)
+	}
+	return gitfs.NewAccessLog(file, git, gitfs.BranchRef("master")), nil
+}
 
 func main() {
-	flag.Parse()
+	f, fs, err := loadFileSystem(flag.ExitOnError)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := f.logFlags.Apply(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	repositoryDirectory := *f.repositoryDirectory
 
-	if len(*repositoryDirectory) == 0 {
-		panic("No repository provided. Please specify '-git-dir'")
+	tracker := gitnfs.NewClientTracker(gitnfs.ClientLimits{
+		MaxClients:    *f.maxClients,
+		PerClientRate: *f.perClientRate,
+	})
+	expvar.Publish("nfs_clients", expvar.Func(func() interface{} {
+		return tracker.Stats()
+	}))
+	expvar.Publish("git_retries", expvar.Func(func() interface{} {
+		return gitism.Retries()
+	}))
+	if *f.metricsAddress != "" {
+		go func() {
+			log.Printf("metrics server stopped: %v", http.ListenAndServe(*f.metricsAddress, nil))
+		}()
 	}
 
-	listener, err := net.Listen("tcp", "0.0.0.0:46051")
+	tcpListener, err := net.Listen("tcp", *f.listenAddress)
 	if err != nil {
 		log.Panicf("could not bind tcp port: %v", err)
 	}
+	listener := tracker.Listen(tcpListener)
 	defer listener.Close()
 	log.Printf("NFS server started at %s\n", listener.Addr())
 
-	git, err := gitfs.NewCliGit(*repositoryDirectory)
-	if err != nil {
-		log.Fatalf("Failed to create git client for directory '%s': %v", *repositoryDirectory,
-			err)
+	if *f.portmapper {
+		if err := servePortmapper(*f.portmapperAddress, listener.Addr().(*net.TCPAddr).Port); err != nil {
+			log.Fatalf("Failed to start the portmapper: %v", err)
+		}
 	}
 
-	branch := "master"
-	fs := gitfs.NewReferenceFileSystem(git, gitfs.GitReference{Branch: &branch})
+	swappable := gitfs.NewSwappableFileSystem(fs)
+	go reloadOnHangup(repositoryDirectory, swappable)
+
+	var served billy.Filesystem = swappable
+	idleExit := *f.idleExit
+	if idleExit > 0 {
+		idle := gitfs.NewIdleTracker()
+		served = gitfs.NewIdleFileSystem(served, idle)
+		go func() {
+			idle.WaitIdle(context.Background(), idleExit)
+			log.Printf("No NFS requests for %v, exiting", idleExit)
+			listener.Close()
+		}()
+	}
 
-	authHandler := nfshelper.NewNullAuthHandler(fs)
-	cachedFs := nfshelper.NewCachingHandler(authHandler, 1024)
-	err = nfs.Serve(listener, cachedFs)
+	authHandler := nfshelper.NewNullAuthHandler(served)
+	handler := gitnfs.NewStableHandler(authHandler, served, repositoryDirectory+"@master")
+	accessLog, err := openAccessLog(*f.accessLog, repositoryDirectory, f.gitFlags)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if accessLog == nil {
+		err = nfs.Serve(listener, handler)
+	} else {
+		// Each connection gets its own view of the filesystem so reads can be attributed to the client.
+		err = gitnfs.ServePerClient(listener, func(client string) nfs.Handler {
+			return gitnfs.NewClientHandler(handler, accessLog.FileSystem(served, client))
+		})
+	}
+	if idleExit > 0 && errors.Is(err, net.ErrClosed) {
+		return
+	}
 	if err != nil {
 		log.Panicln(err)
 	}
@@ -4,7 +4,11 @@ go 1.16
 
 require (
 	github.com/go-git/go-billy/v5 v5.3.1
+	github.com/go-git/go-git/v5 v5.4.2
 	github.com/google/go-cmp v0.5.9
-	github.com/jacobsa/fuse v0.0.0-20210811193110-7782064498ca
+	github.com/jacobsa/fuse v0.0.0-20230124164109-5e0f2e6b432b
+	github.com/spf13/afero v1.6.0
 	github.com/willscott/go-nfs v0.0.0-20210811210748-50c14995daf6
+	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/text v0.13.0
 )
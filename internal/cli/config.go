@@ -0,0 +1,118 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ConfigFlag is the flag naming the config file read by ParseWithConfig.
+const ConfigFlag = "config"
+
+// EnvPrefix starts the name of the environment variable ParseWithConfig reads each flag from.
+const EnvPrefix = "GITFS_"
+
+// RegisterConfigFlag adds --config to flags.
+func RegisterConfigFlag(flags *flag.FlagSet) *string {
+	return flags.String(ConfigFlag, "", "JSON file of flag settings, keyed by flag name. Flags given on the command line or in the environment take precedence. Re-read on SIGHUP.")
+}
+
+// EnvName is the environment variable that sets the flag called name. --git-dir is set by GITFS_GIT_DIR.
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// ParseWithConfig parses args into flags and then fills in every flag that wasn't on the command line from the
+// environment variable named by EnvName, and then every flag still unset from the file named by --config, which may
+// itself come from GITFS_CONFIG. The file is a JSON object keyed by flag name. Values are strings, numbers, or
+// booleans, or lists of them for flags that may be repeated. An environment variable sets a repeatable flag once.
+// flags must have been passed to RegisterConfigFlag.
+func ParseWithConfig(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(EnvName(f.Name))
+		if err != nil || explicit[f.Name] || !ok {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s has an invalid value for '%s': %v", EnvName(f.Name), f.Name, setErr)
+			return
+		}
+		explicit[f.Name] = true
+	})
+	if err != nil {
+		return err
+	}
+
+	configFlag := flags.Lookup(ConfigFlag)
+	if configFlag == nil || configFlag.Value.String() == "" {
+		return nil
+	}
+	path := configFlag.Value.String()
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+	var settings map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	// Keep numbers as written so they parse the same way they would on the command line.
+	decoder.UseNumber()
+	if err := decoder.Decode(&settings); err != nil {
+		return fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+
+	for name, value := range settings {
+		if name == ConfigFlag {
+			return fmt.Errorf("config %s cannot set '%s'", path, ConfigFlag)
+		}
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("config %s sets unknown flag '%s'", path, name)
+		}
+		if explicit[name] {
+			continue
+		}
+
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, value := range values {
+			switch value.(type) {
+			case string, bool, json.Number:
+			default:
+				return fmt.Errorf("config %s has an unsupported value for '%s': %v", path, name, value)
+			}
+			if err := flags.Set(name, fmt.Sprint(value)); err != nil {
+				return fmt.Errorf("config %s has an invalid value for '%s': %v", path, name, err)
+			}
+		}
+	}
+	return nil
+}
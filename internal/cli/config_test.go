@@ -0,0 +1,136 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"flag"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseWithConfig(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(config, []byte(`{
+		"git-dir": "/srv/config.git",
+		"symlinks": "hide",
+		"introspection": false,
+		"depth": 1000000,
+		"git-config": ["core.quotePath=false", "gc.auto=0"]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterConfigFlag(flagSet)
+	gitDir := flagSet.String("git-dir", "", "")
+	symlinks := flagSet.String("symlinks", "rewrite", "")
+	introspection := flagSet.Bool("introspection", true, "")
+	depth := flagSet.Int("depth", 0, "")
+	gitFlags := RegisterGitFlags(flagSet)
+
+	err = ParseWithConfig(flagSet, []string{"--config", config, "--git-dir", "/srv/flag.git"})
+	if err != nil {
+		t.Fatalf("ParseWithConfig() failed: %v", err)
+	}
+
+	if *gitDir != "/srv/flag.git" {
+		t.Fatalf("command line should take precedence over the config but --git-dir is %s", *gitDir)
+	}
+	if *symlinks != "hide" || *introspection || *depth != 1000000 {
+		t.Fatalf("config was not applied: symlinks=%s introspection=%v depth=%d", *symlinks, *introspection, *depth)
+	}
+	if diff := cmp.Diff(StringList{"core.quotePath=false", "gc.auto=0"}, gitFlags.Config); diff != "" {
+		t.Fatal(diff)
+	}
+
+	t.Run("environment", func(t *testing.T) {
+		if err := os.WriteFile(config, []byte(`{"symlinks": "hide", "introspection": false}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		setenv(t, "GITFS_CONFIG", config)
+		setenv(t, "GITFS_GIT_DIR", "/srv/env.git")
+		setenv(t, "GITFS_SYMLINKS", "passthrough")
+		setenv(t, "GITFS_MAX_FILE_SIZE", "1024")
+
+		flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+		RegisterConfigFlag(flagSet)
+		gitDir := flagSet.String("git-dir", "", "")
+		symlinks := flagSet.String("symlinks", "rewrite", "")
+		introspection := flagSet.Bool("introspection", true, "")
+		maxFileSize := flagSet.Int64("max-file-size", 0, "")
+		if err := ParseWithConfig(flagSet, []string{"--git-dir", "/srv/flag.git"}); err != nil {
+			t.Fatalf("ParseWithConfig() failed: %v", err)
+		}
+
+		if *gitDir != "/srv/flag.git" {
+			t.Fatalf("command line should take precedence over the environment but --git-dir is %s", *gitDir)
+		}
+		if *symlinks != "passthrough" || *maxFileSize != 1024 {
+			t.Fatalf("environment should take precedence over the config: symlinks=%s max-file-size=%d", *symlinks, *maxFileSize)
+		}
+		if *introspection {
+			t.Fatalf("config named by GITFS_CONFIG was not applied")
+		}
+
+		setenv(t, "GITFS_MAX_FILE_SIZE", "large")
+		flagSet = flag.NewFlagSet("test", flag.ContinueOnError)
+		RegisterConfigFlag(flagSet)
+		flagSet.Int64("max-file-size", 0, "")
+		if err := ParseWithConfig(flagSet, nil); err == nil {
+			t.Fatalf("ParseWithConfig() should reject invalid environment variables")
+		}
+	})
+
+	t.Run("unknown flag", func(t *testing.T) {
+		if err := os.WriteFile(config, []byte(`{"missing": true}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+		RegisterConfigFlag(flagSet)
+		if err := ParseWithConfig(flagSet, []string{"--config", config}); err == nil {
+			t.Fatalf("ParseWithConfig() should reject unknown flags")
+		}
+	})
+}
+
+// setenv sets an environment variable until the test finishes.
+func setenv(t *testing.T, key, value string) {
+	previous, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestEnvName(t *testing.T) {
+	for name, want := range map[string]string{
+		"git-dir":         "GITFS_GIT_DIR",
+		"mount":           "GITFS_MOUNT",
+		"large-file-rate": "GITFS_LARGE_FILE_RATE",
+	} {
+		if got := EnvName(name); got != want {
+			t.Fatalf("EnvName(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cli holds the command line flags shared by the gitfs binaries.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	cachesMu sync.Mutex
+	// caches holds every cache opened by spec, so reloading the configuration keeps using the same warm cache.
+	caches = map[string]*gitfs.ManagedCache{}
+	// budgets holds every memory budget by its limit and spill directory, so reloading the configuration keeps
+	// counting what is already open.
+	budgets = map[string]*gitfs.MemoryBudget{}
+)
+
+// StringList is a flag that can be passed more than once.
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *StringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// GitFlags control how git is run.
+type GitFlags struct {
+	Executable string
+	Path       string
+	Config     StringList
+	Alternates StringList
+	// FetchRemotes are the remotes missing refs and objects are fetched from, in order. Nothing is fetched if empty.
+	FetchRemotes StringList
+	// Cache is a gitfs.ParseCache spec for where blobs and listings are cached. Nothing is cached if empty.
+	Cache string
+	// MemoryBudget is the most bytes of blobs open files and a memory cache may hold together. 0 is unlimited.
+	MemoryBudget int64
+	// SpillDir is where files opened over MemoryBudget are read from.
+	SpillDir string
+	// Workers is how many git processes are kept running to read objects from, and WorkerRequests how many objects
+	// each answers before it is replaced. Git is started for every read if Workers is 0.
+	Workers        int
+	WorkerRequests int
+	// ReplaceObjects serves the objects refs/replace/ replaces objects with instead of the originals.
+	ReplaceObjects bool
+	// Faults is a gitfs.ParseFaults spec of delays and failures to inject into calls to git. None are if empty.
+	Faults string
+	// Retries is how many times git is run again when the repository is locked.
+	Retries int
+}
+
+// RegisterGitFlags adds the flags for running git to flags.
+func RegisterGitFlags(flags *flag.FlagSet) *GitFlags {
+	f := new(GitFlags)
+	flags.StringVar(&f.Executable, "git-binary", "", "git binary to run. Defaults to git found on $PATH.")
+	flags.StringVar(&f.Path, "git-path", "", "Replaces $PATH when finding and running git.")
+	flags.Var(&f.Config, "git-config", "A key=value git config setting passed to git with -c. May be repeated.")
+	flags.Var(&f.Alternates, "git-alternates", "An extra object directory for git to read from. May be repeated.")
+	flags.Var(&f.FetchRemotes, "fetch-missing-from", "Remote name or URL, like origin, to fetch refs and objects missing from the repository from instead of failing. May be repeated to add mirrors, which are tried in order when fetching from the ones before them fails. Failed fetches back off before being tried again.")
+	flags.StringVar(&f.Cache, "cache", "", "Where to cache blobs and listings of commits: memory, memory:BYTES, disk:DIR, or redis://HOST:PORT or memcached://HOST:PORT to share one cache between daemons serving the same repository. Nothing is cached if empty.")
+	flags.Int64Var(&f.MemoryBudget, "memory-budget", 0, "Most bytes of file contents that open files and a memory --cache may hold together. The least recently used cached values are evicted to make room, and files that still don't fit are read from a temporary file in --spill-dir. 0 is unlimited.")
+	flags.StringVar(&f.SpillDir, "spill-dir", "", "Directory for the temporary files of files opened over --memory-budget. Defaults to $TMPDIR.")
+	flags.IntVar(&f.Workers, "git-workers", 0, "git cat-file processes to keep running for reading files, and as many for looking up their sizes, so reads don't wait for git to start. 0 starts git for every read.")
+	flags.IntVar(&f.WorkerRequests, "git-worker-requests", 1000, "Objects each of --git-workers reads before it is replaced with a fresh process.")
+	flags.BoolVar(&f.ReplaceObjects, "replace-objects", true, "Serve the objects that refs/replace/ replaces objects with, like git does. If false, the original objects are served.")
+	flags.IntVar(&f.Retries, "git-retries", 3, "Times to run a git command again when it failed because maintenance like git gc or git repack held a lock on the repository, backing off between attempts. 0 never runs it again.")
+	flags.StringVar(&f.Faults, "inject-faults", "", "For soak testing, delay and fail calls to git on purpose: comma separated latency=DURATION (the longest random delay) and failures=RATE (the fraction of calls failing with EIO) settings, optionally prefixed with a method like ReadBlob. to only apply to it. For example latency=50ms,ReadBlob.failures=0.01.")
+	return f
+}
+
+// LogFlags control what is logged.
+type LogFlags struct {
+	// RedactPaths hashes paths in logs, traces, and access logs with gitfs.RedactPath.
+	RedactPaths bool
+	// RedactPathsKeyFile holds the key paths are hashed with. A random key is used if empty.
+	RedactPathsKeyFile string
+}
+
+// RegisterLogFlags adds the flags controlling what is logged to flags.
+func RegisterLogFlags(flags *flag.FlagSet) *LogFlags {
+	f := new(LogFlags)
+	flags.BoolVar(&f.RedactPaths, "redact-paths", false, "Hash every path in logs, traces, and access logs, keeping how deep it is, which paths share directories, and the extension of its file, for deployments where the names of files are sensitive.")
+	flags.StringVar(&f.RedactPathsKeyFile, "redact-paths-key-file", "", "File holding the key --redact-paths hashes paths with, so the same path is hashed the same way by every frontend and across restarts. Defaults to a random key for each process.")
+	return f
+}
+
+// Apply makes the flags take effect for the whole process. It must be called before anything is served.
+func (f *LogFlags) Apply() error {
+	if !f.RedactPaths {
+		if f.RedactPathsKeyFile != "" {
+			return fmt.Errorf("--redact-paths-key-file requires --redact-paths")
+		}
+		return nil
+	}
+	var key string
+	if f.RedactPathsKeyFile != "" {
+		contents, err := os.ReadFile(f.RedactPathsKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read --redact-paths-key-file: %v", err)
+		}
+		key = strings.TrimSpace(string(contents))
+		if key == "" {
+			return fmt.Errorf("--redact-paths-key-file %s is empty", f.RedactPathsKeyFile)
+		}
+	}
+	return gitfs.RedactPathsInLogs(key)
+}
+
+// RegisterRateLimitFlags adds the flags for gitfs.RateLimits to flags.
+func RegisterRateLimitFlags(flags *flag.FlagSet) *gitfs.RateLimits {
+	limits := new(gitfs.RateLimits)
+	flags.Int64Var(&limits.LargeFileSize, "large-file-size", 8<<20, "Size in bytes above which opening a file counts against --large-file-rate.")
+	flags.Float64Var(&limits.LargeFileRate, "large-file-rate", 0, "Files larger than --large-file-size that may be opened per second before opens are slowed down. 0 is unlimited.")
+	flags.Float64Var(&limits.ListingRate, "listing-rate", 0, "Directories that may be listed per second before listings are slowed down. 0 is unlimited.")
+	return limits
+}
+
+// Options converts the flags into options for gitfs.NewCliGit.
+func (f *GitFlags) Options() ([]gitfs.CliOption, error) {
+	var options []gitfs.CliOption
+	if f.Executable != "" {
+		options = append(options, gitfs.WithGitExecutable(f.Executable))
+	}
+	if f.Path != "" {
+		options = append(options, gitfs.WithPath(f.Path))
+	}
+	for _, setting := range f.Config {
+		index := strings.IndexRune(setting, '=')
+		if index <= 0 {
+			return nil, fmt.Errorf("--git-config '%s' must be in the form key=value", setting)
+		}
+		options = append(options, gitfs.WithGitConfig(setting[:index], setting[index+1:]))
+	}
+	if len(f.Alternates) > 0 {
+		var alternates []string
+		for _, alternate := range f.Alternates {
+			alternates = append(alternates, strings.Split(alternate, string(os.PathListSeparator))...)
+		}
+		options = append(options, gitfs.WithAlternateObjectDirectories(alternates...))
+	}
+	if len(f.FetchRemotes) > 0 {
+		options = append(options, gitfs.WithFetchOnMissing(f.FetchRemotes...))
+	}
+	cache, err := f.OpenCache()
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		options = append(options, gitfs.WithCache(cache))
+	}
+	if f.Retries > 0 {
+		options = append(options, gitfs.WithGitRetries(f.Retries))
+	}
+	if f.Workers > 0 {
+		options = append(options, gitfs.WithProcessPool(f.Workers, f.WorkerRequests))
+	}
+	if budget := f.openMemoryBudget(); budget != nil {
+		options = append(options, gitfs.WithMemoryBudget(budget))
+	}
+	if !f.ReplaceObjects {
+		options = append(options, gitfs.WithReplaceObjects(false))
+	}
+	if f.Faults != "" {
+		faults, err := gitfs.ParseFaults(f.Faults)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --inject-faults: %v", err)
+		}
+		options = append(options, gitfs.WithFaults(faults))
+	}
+	return options, nil
+}
+
+// openMemoryBudget returns the budget --memory-budget describes, shared by every client in the process. It is nil if
+// --memory-budget is 0.
+func (f *GitFlags) openMemoryBudget() *gitfs.MemoryBudget {
+	if f.MemoryBudget <= 0 {
+		return nil
+	}
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+	key := fmt.Sprintf("%d:%s", f.MemoryBudget, f.SpillDir)
+	if _, ok := budgets[key]; !ok {
+		budgets[key] = gitfs.NewMemoryBudget(f.MemoryBudget, f.SpillDir)
+	}
+	return budgets[key]
+}
+
+// OpenCache opens the cache --cache describes, or returns the one already opened for it. It is nil if --cache is
+// empty.
+func (f *GitFlags) OpenCache() (*gitfs.ManagedCache, error) {
+	if f.Cache == "" {
+		return nil, nil
+	}
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+	if cache, ok := caches[f.Cache]; ok {
+		return cache, nil
+	}
+	cache, err := gitfs.ParseCache(f.Cache)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --cache: %v", err)
+	}
+	caches[f.Cache] = gitfs.NewManagedCache(cache)
+	return caches[f.Cache], nil
+}
+
+// ReadAdminToken reads the token admin requests must carry from the file at path, ignoring surrounding whitespace.
+func ReadAdminToken(path string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read --admin-token-file: %v", err)
+	}
+	token := strings.TrimSpace(string(contents))
+	if token == "" {
+		return "", fmt.Errorf("--admin-token-file %s is empty", path)
+	}
+	return token, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"encoding/json"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/helper/chroot"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// accessLogCommitTTL is how long a resolved commit is reused for so busy mounts don't run git for every file read.
+const accessLogCommitTTL = time.Second
+
+// AccessEntry is a single line of an access log.
+type AccessEntry struct {
+	Time time.Time `json:"time"`
+	// Client identifies who read the path: "uid:N" for FUSE and the address of the client for NFS and HTTP.
+	Client string `json:"client"`
+	// Op is "read" or "write" for files and "list" for directories.
+	Op string `json:"op"`
+	// Path is hashed with RedactPath when paths are redacted.
+	Path   string `json:"path"`
+	Ref    string `json:"ref"`
+	Commit string `json:"commit,omitempty"`
+	// Labels name the mount and repository the path was accessed through when they are labelled, see
+	// AccessLog.Labelled.
+	Labels string `json:"labels,omitempty"`
+}
+
+// AccessLog writes a JSON line for every file read and directory listed, recording who accessed which path at which
+// revision for auditing access to source on shared mounts. A nil *AccessLog is valid and records nothing.
+type AccessLog struct {
+	*accessLogWriter
+	label string
+}
+
+// accessLogWriter is what an AccessLog shares with its labelled copies.
+type accessLogWriter struct {
+	git       Git
+	reference Ref
+
+	mu       sync.Mutex
+	encoder  *json.Encoder
+	commit   string
+	resolved time.Time
+}
+
+// NewAccessLog writes entries for reads of reference to w. git may be nil when the repository isn't local, in which
+// case only the name of reference is recorded.
+func NewAccessLog(w io.Writer, git Git, reference Ref) *AccessLog {
+	return &AccessLog{accessLogWriter: &accessLogWriter{
+		git:       git,
+		reference: reference,
+		encoder:   json.NewEncoder(w),
+	}}
+}
+
+// Labelled is l with every entry it writes labelled with label, the mount or repository the paths are accessed
+// through. Labels nest like those of Tracer.Labelled.
+func (l *AccessLog) Labelled(label string) *AccessLog {
+	if l == nil || label == "" {
+		return l
+	}
+	return &AccessLog{accessLogWriter: l.accessLogWriter, label: JoinLabels(l.label, label)}
+}
+
+// record logs that client accessed path with op. Must be called with l.mu held.
+func (l *AccessLog) record(client, op, path string) {
+	now := time.Now()
+	if l.git != nil && now.Sub(l.resolved) > accessLogCommitTTL {
+		commit, err := l.git.ResolveCommit(l.reference)
+		if err != nil {
+			// Still record the access, just without the commit.
+			commit = ""
+		}
+		l.commit, l.resolved = commit, now
+	}
+
+	err := l.encoder.Encode(AccessEntry{
+		Time:   now.UTC(),
+		Client: client,
+		Op:     op,
+		Path:   RedactPath(path),
+		Ref:    l.reference.String(),
+		Commit: l.commit,
+		Labels: l.label,
+	})
+	if err != nil {
+		log.Printf("failed to write to the access log: %v", err)
+	}
+}
+
+// Record logs that client accessed path with op.
+func (l *AccessLog) Record(client, op, path string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.record(client, op, path)
+}
+
+// FileSystem wraps fs so every file opened for reading and every directory listed through it is recorded against
+// client. fs is returned as is when l is nil.
+func (l *AccessLog) FileSystem(fs billy.Filesystem, client string) billy.Filesystem {
+	if l == nil {
+		return fs
+	}
+	return accessLogFileSystem{
+		Filesystem: fs,
+		log:        l,
+		client:     client,
+	}
+}
+
+type accessLogFileSystem struct {
+	billy.Filesystem
+	log    *AccessLog
+	client string
+}
+
+func (s accessLogFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s accessLogFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	file, err := s.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	op := "read"
+	if flag&writeFlags != 0 {
+		op = "write"
+	}
+	s.log.Record(s.client, op, filename)
+	return file, nil
+}
+
+func (s accessLogFileSystem) ReadDir(filename string) ([]os.FileInfo, error) {
+	files, err := s.Filesystem.ReadDir(filename)
+	if err == nil {
+		s.log.Record(s.client, "list", filename)
+	}
+	return files, err
+}
+
+// Chroot keeps logging paths from the original root.
+func (s accessLogFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(s, path), nil
+}
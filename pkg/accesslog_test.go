@@ -0,0 +1,77 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/go-git/go-billy/v5/util"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "base")
+	reference := BranchRef("master")
+	fs := NewReferenceFileSystem(git, reference)
+
+	var nilLog *AccessLog
+	if _, ok := nilLog.FileSystem(fs, "uid:0").(accessLogFileSystem); ok {
+		t.Fatal("a nil access log should not wrap the filesystem")
+	}
+
+	var output bytes.Buffer
+	accessLog := NewAccessLog(&output, git, reference)
+	logged := accessLog.FileSystem(fs, "10.0.0.1")
+	if _, err := util.ReadFile(logged, "real.txt"); err != nil {
+		t.Fatalf("reading real.txt failed: %v", err)
+	}
+	if _, err := logged.ReadDir("test"); err != nil {
+		t.Fatalf("ReadDir(test) failed: %v", err)
+	}
+	chroot, err := logged.Chroot("test")
+	if err != nil {
+		t.Fatalf("Chroot(test) failed: %v", err)
+	}
+	if _, err := util.ReadFile(chroot, "nested.txt"); err != nil {
+		t.Fatalf("reading test/nested.txt failed: %v", err)
+	}
+	if _, err := logged.Open("missing.txt"); err == nil {
+		t.Fatal("Open(missing.txt) should fail")
+	}
+
+	commit, err := git.ResolveCommit(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AccessEntry{
+		{Client: "10.0.0.1", Op: "read", Path: "real.txt"},
+		{Client: "10.0.0.1", Op: "list", Path: "test"},
+		{Client: "10.0.0.1", Op: "read", Path: "test/nested.txt"},
+	}
+	decoder := json.NewDecoder(&output)
+	for _, expected := range want {
+		var entry AccessEntry
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("failed to decode access log entry: %v", err)
+		}
+		if entry.Client != expected.Client || entry.Op != expected.Op || entry.Path != expected.Path ||
+			entry.Ref != "refs/heads/master" || entry.Commit != commit || entry.Time.IsZero() {
+			t.Fatalf("logged %+v but expected %+v", entry, expected)
+		}
+	}
+	if decoder.More() {
+		t.Fatal("failed accesses should not be logged")
+	}
+}
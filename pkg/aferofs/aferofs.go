@@ -0,0 +1,273 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aferofs adapts a gitfs filesystem to afero.Fs for libraries that take afero rather than billy.
+package aferofs
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/spf13/afero"
+	"io"
+	"os"
+	"time"
+)
+
+// errIsDirectory is returned when reading or writing a directory opened as a file.
+var errIsDirectory = errors.New("is a directory")
+
+// fileSystem adapts a billy.Filesystem to afero.Fs and afero.Symlinker.
+type fileSystem struct {
+	fs billy.Filesystem
+}
+
+// New exposes fs as an afero.Fs. Directories can be opened to list them, as with os.Open. Writes are passed to fs, so
+// they fail with billy.ErrReadOnly for anything served from git.
+func New(fs billy.Filesystem) afero.Fs {
+	return fileSystem{fs: fs}
+}
+
+func (s fileSystem) Name() string {
+	return "gitfs"
+}
+
+func (s fileSystem) Create(name string) (afero.File, error) {
+	file, err := s.fs.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &regularFile{File: file, fs: s.fs, name: name}, nil
+}
+
+func (s fileSystem) Mkdir(name string, perm os.FileMode) error {
+	return s.fs.MkdirAll(name, perm)
+}
+
+func (s fileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return s.fs.MkdirAll(path, perm)
+}
+
+func (s fileSystem) Open(name string) (afero.File, error) {
+	return s.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (s fileSystem) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag == os.O_RDONLY {
+		info, err := s.fs.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			return &directory{fs: s.fs, name: name, info: info}, nil
+		}
+	}
+
+	file, err := s.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &regularFile{File: file, fs: s.fs, name: name}, nil
+}
+
+func (s fileSystem) Remove(name string) error {
+	return s.fs.Remove(name)
+}
+
+func (s fileSystem) RemoveAll(path string) error {
+	return util.RemoveAll(s.fs, path)
+}
+
+func (s fileSystem) Rename(oldname, newname string) error {
+	return s.fs.Rename(oldname, newname)
+}
+
+func (s fileSystem) Stat(name string) (os.FileInfo, error) {
+	return s.fs.Stat(name)
+}
+
+func (s fileSystem) Chmod(name string, mode os.FileMode) error {
+	change, ok := s.fs.(billy.Change)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+	return change.Chmod(name, mode)
+}
+
+func (s fileSystem) Chown(name string, uid, gid int) error {
+	change, ok := s.fs.(billy.Change)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+	return change.Chown(name, uid, gid)
+}
+
+func (s fileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	change, ok := s.fs.(billy.Change)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+	return change.Chtimes(name, atime, mtime)
+}
+
+// afero.Symlinker type implementation
+
+func (s fileSystem) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	info, err := s.fs.Lstat(name)
+	return info, true, err
+}
+
+func (s fileSystem) SymlinkIfPossible(oldname, newname string) error {
+	return s.fs.Symlink(oldname, newname)
+}
+
+func (s fileSystem) ReadlinkIfPossible(name string) (string, error) {
+	return s.fs.Readlink(name)
+}
+
+// regularFile adapts a billy.File to afero.File.
+type regularFile struct {
+	billy.File
+	fs   billy.Filesystem
+	name string
+}
+
+func (f *regularFile) WriteAt(p []byte, off int64) (n int, err error) {
+	if writerAt, ok := f.File.(io.WriterAt); ok {
+		return writerAt.WriteAt(p, off)
+	}
+	return 0, billy.ErrNotSupported
+}
+
+func (f *regularFile) WriteString(s string) (ret int, err error) {
+	return f.Write([]byte(s))
+}
+
+func (f *regularFile) Readdir(count int) ([]os.FileInfo, error) {
+	_ = count
+	return nil, errors.New("not a directory")
+}
+
+func (f *regularFile) Readdirnames(n int) ([]string, error) {
+	_ = n
+	return nil, errors.New("not a directory")
+}
+
+func (f *regularFile) Stat() (os.FileInfo, error) {
+	return f.fs.Stat(f.name)
+}
+
+func (f *regularFile) Sync() error {
+	return nil
+}
+
+// directory is an opened directory. It can only be listed.
+type directory struct {
+	fs      billy.Filesystem
+	name    string
+	info    os.FileInfo
+	entries []os.FileInfo
+	read    bool
+}
+
+func (d *directory) Name() string {
+	return d.name
+}
+
+func (d *directory) Close() error {
+	return nil
+}
+
+func (d *directory) Read(p []byte) (int, error) {
+	_ = p
+	return 0, errIsDirectory
+}
+
+func (d *directory) ReadAt(p []byte, off int64) (int, error) {
+	_ = p
+	_ = off
+	return 0, errIsDirectory
+}
+
+func (d *directory) Seek(offset int64, whence int) (int64, error) {
+	_ = offset
+	_ = whence
+	return 0, errIsDirectory
+}
+
+func (d *directory) Write(p []byte) (int, error) {
+	_ = p
+	return 0, errIsDirectory
+}
+
+func (d *directory) WriteAt(p []byte, off int64) (int, error) {
+	_ = p
+	_ = off
+	return 0, errIsDirectory
+}
+
+func (d *directory) WriteString(s string) (int, error) {
+	_ = s
+	return 0, errIsDirectory
+}
+
+func (d *directory) Truncate(size int64) error {
+	_ = size
+	return errIsDirectory
+}
+
+func (d *directory) Sync() error {
+	return nil
+}
+
+func (d *directory) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+// Readdir follows os.File.Readdir: a positive count returns at most count entries and io.EOF once there are none left,
+// otherwise everything left is returned.
+func (d *directory) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.read {
+		entries, err := d.fs.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.read = true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+func (d *directory) Readdirnames(n int) ([]string, error) {
+	entries, err := d.Readdir(n)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, err
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aferofs
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/google/go-cmp/cmp"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"github.com/spf13/afero"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// runPlaybook builds a repository from one of the playbooks shared with pkg's tests and returns its git directory.
+func runPlaybook(t *testing.T, playbook string) string {
+	script, err := filepath.Abs(filepath.Join("..", "testdata", "playbooks", playbook+".sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cmd := exec.Command(script)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("playbook '%s' failed: %v", playbook, err)
+	}
+	return filepath.Join(dir, ".git")
+}
+
+func TestReferenceFileSystem(t *testing.T) {
+	git, err := gitfs.NewCliGit(runPlaybook(t, "symlinks"))
+	if err != nil {
+		t.Fatalf("NewCliGit() failed: %v", err)
+	}
+	fs := New(gitfs.NewReferenceFileSystemWithSymlinks(git, gitfs.BranchRef("master"), gitfs.SymlinkPassThrough))
+
+	t.Run("read", func(t *testing.T) {
+		contents, err := afero.ReadFile(fs, "real.txt")
+		if err != nil || string(contents) != "Hello World\n" {
+			t.Fatalf("real.txt contained %q, %v", contents, err)
+		}
+	})
+
+	t.Run("walk", func(t *testing.T) {
+		var paths []string
+		err := afero.Walk(fs, ".", func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			paths = append(paths, path)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Walk() failed: %v", err)
+		}
+		want := []string{".", "absolute.txt", "nested", "nested/escaping.txt", "nested/up.txt", "real.txt", "relative.txt"}
+		if diff := cmp.Diff(want, paths); diff != "" {
+			t.Fatalf("Walk() visited the wrong paths (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("symlinks", func(t *testing.T) {
+		linker, ok := fs.(afero.Symlinker)
+		if !ok {
+			t.Fatalf("New() should support symlinks")
+		}
+		target, err := linker.ReadlinkIfPossible("relative.txt")
+		if err != nil || target != "real.txt" {
+			t.Fatalf("ReadlinkIfPossible(relative.txt) = %q, %v", target, err)
+		}
+		info, lstatCalled, err := linker.LstatIfPossible("relative.txt")
+		if err != nil || !lstatCalled || info.Mode()&os.ModeSymlink == 0 {
+			t.Fatalf("LstatIfPossible(relative.txt) = %v, %v, %v", info, lstatCalled, err)
+		}
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		if err := afero.WriteFile(fs, "new.txt", []byte("nope"), 0644); !errors.Is(err, billy.ErrReadOnly) {
+			t.Fatalf("WriteFile(new.txt) should fail with ErrReadOnly: %v", err)
+		}
+		if err := fs.Chmod("real.txt", 0777); !errors.Is(err, billy.ErrReadOnly) {
+			t.Fatalf("Chmod(real.txt) should fail with ErrReadOnly: %v", err)
+		}
+	})
+}
+
+func TestDirectory(t *testing.T) {
+	backing := memfs.New()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := util.WriteFile(backing, filepath.Join("dir", name), nil, 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	fs := New(backing)
+
+	dir, err := fs.Open("dir")
+	if err != nil {
+		t.Fatalf("Open(dir) failed: %v", err)
+	}
+	defer dir.Close()
+
+	var names []string
+	for {
+		batch, err := dir.Readdirnames(2)
+		names = append(names, batch...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Readdirnames(2) failed: %v", err)
+		}
+		if len(batch) > 2 {
+			t.Fatalf("Readdirnames(2) returned %d names", len(batch))
+		}
+	}
+	if diff := cmp.Diff([]string{"a", "b", "c"}, names); diff != "" {
+		t.Fatalf("Readdirnames() listed the wrong names (-want +got):\n%s", diff)
+	}
+
+	if _, err := dir.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("reading a directory should fail")
+	}
+}
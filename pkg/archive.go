@@ -0,0 +1,575 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ArchiveSuffix is appended to the name of an archive to get the directory NewArchiveFileSystem exposes its contents
+// at, so build.tar.gz is browsable at build.tar.gz.d/.
+const ArchiveSuffix = ".d"
+
+const (
+	// maxArchiveSize is the most an archive, or everything a tarball unpacks to, may take up in memory.
+	maxArchiveSize = 128 << 20
+	// archiveCacheSize is how many unpacked archives are kept around between calls.
+	archiveCacheSize = 4
+	// maxArchiveSymlinkHops is how many symlinks in an archive are followed before giving up.
+	maxArchiveSymlinkHops = 8
+)
+
+// ErrArchiveTooLarge is returned when browsing an archive would use more than maxArchiveSize bytes of memory.
+var ErrArchiveTooLarge = fmt.Errorf("archive is too large to browse: %w", syscall.EFBIG)
+
+type archiveFormat struct {
+	extension string
+	read      func(contents []byte) (*archiveIndex, error)
+}
+
+var archiveFormats = []archiveFormat{
+	{extension: ".tar.gz", read: readTarGzArchive},
+	{extension: ".tgz", read: readTarGzArchive},
+	{extension: ".tar", read: readTarArchive},
+	{extension: ".zip", read: readZipArchive},
+}
+
+func archiveFormatOf(name string) (archiveFormat, bool) {
+	for _, format := range archiveFormats {
+		if strings.HasSuffix(name, format.extension) && name != format.extension {
+			return format, true
+		}
+	}
+	return archiveFormat{}, false
+}
+
+type archiveInfo struct {
+	name    string
+	mode    os.FileMode
+	size    int64
+	modTime time.Time
+}
+
+func (i archiveInfo) Name() string {
+	return i.name
+}
+
+func (i archiveInfo) Size() int64 {
+	return i.size
+}
+
+func (i archiveInfo) Mode() fs.FileMode {
+	return i.mode
+}
+
+func (i archiveInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i archiveInfo) IsDir() bool {
+	return i.mode.IsDir()
+}
+
+func (i archiveInfo) Sys() interface{} {
+	return nil
+}
+
+type archiveEntry struct {
+	info archiveInfo
+	// children are the names of the entries in a directory.
+	children []string
+	// target is where a symlink points and resolved is that target as a path within the archive.
+	target   string
+	resolved string
+	// contents reads a regular file.
+	contents func() ([]byte, error)
+}
+
+// archiveIndex is the unpacked listing of an archive. Entries are keyed by their cleaned path within the archive, with
+// "." being its root.
+type archiveIndex struct {
+	entries map[string]*archiveEntry
+}
+
+func newArchiveIndex() *archiveIndex {
+	return &archiveIndex{
+		entries: map[string]*archiveEntry{
+			".": {info: archiveInfo{mode: os.ModeDir | 0555}},
+		},
+	}
+}
+
+// add records entry at name, creating any parent directories the archive didn't list itself. Entries whose names
+// escape the archive or collide with a file used as a directory are skipped, as are symlinks pointing outside of it.
+func (a *archiveIndex) add(name string, entry *archiveEntry) {
+	root := RootGitPath()
+	resolved, err := root.Resolve(name)
+	if err != nil || resolved.IsRoot() {
+		return
+	}
+
+	if entry.info.mode&os.ModeSymlink != 0 {
+		parent := resolved.Parent()
+		target, err := parent.Resolve(entry.target)
+		if path.IsAbs(entry.target) || err != nil {
+			return
+		}
+		entry.resolved = target.String()
+	}
+
+	parent := a.entries["."]
+	for i := 1; i < len(resolved.Path); i++ {
+		directory := FilePath{Path: resolved.Path[:i]}
+		existing, exists := a.entries[directory.String()]
+		if !exists {
+			existing = &archiveEntry{info: archiveInfo{name: resolved.Path[i-1], mode: os.ModeDir | 0555}}
+			a.entries[directory.String()] = existing
+			parent.children = append(parent.children, existing.info.name)
+		}
+		if !existing.info.IsDir() {
+			return
+		}
+		parent = existing
+	}
+
+	entry.info.name = resolved.Path[len(resolved.Path)-1]
+	existing, exists := a.entries[resolved.String()]
+	if !exists {
+		parent.children = append(parent.children, entry.info.name)
+	} else if existing.info.IsDir() {
+		if !entry.info.IsDir() {
+			return
+		}
+		// Directories can be listed after their contents. Keep the contents and take the listed mode.
+		entry.children = existing.children
+	}
+	a.entries[resolved.String()] = entry
+}
+
+// lookup finds the entry at inner, following symlinks if follow is set.
+func (a *archiveIndex) lookup(inner string, follow bool) (*archiveEntry, error) {
+	entry, exists := a.entries[inner]
+	for hops := 0; exists && follow && entry.info.mode&os.ModeSymlink != 0; hops++ {
+		if hops == maxArchiveSymlinkHops {
+			return nil, syscall.ELOOP
+		}
+		entry, exists = a.entries[entry.resolved]
+	}
+	if !exists {
+		return nil, fs.ErrNotExist
+	}
+	return entry, nil
+}
+
+func archiveFileMode(mode os.FileMode) os.FileMode {
+	return mode.Perm()&0555 | 0444
+}
+
+func readTarGzArchive(contents []byte) (*archiveIndex, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(contents))
+	if err != nil {
+		return nil, err
+	}
+	return readTar(reader)
+}
+
+func readTarArchive(contents []byte) (*archiveIndex, error) {
+	return readTar(bytes.NewReader(contents))
+}
+
+// readTar unpacks every file into memory as tarballs can't be read out of order.
+func readTar(r io.Reader) (*archiveIndex, error) {
+	index := newArchiveIndex()
+	reader := tar.NewReader(r)
+	var total int64
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return index, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		info := archiveInfo{size: header.Size, modTime: header.ModTime}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			info.mode = os.ModeDir | 0555
+			info.size = 0
+			index.add(header.Name, &archiveEntry{info: info})
+		case tar.TypeReg:
+			total += header.Size
+			if total > maxArchiveSize {
+				return nil, ErrArchiveTooLarge
+			}
+			contents, err := io.ReadAll(reader)
+			if err != nil {
+				return nil, err
+			}
+			info.mode = archiveFileMode(os.FileMode(header.Mode))
+			index.add(header.Name, &archiveEntry{info: info, contents: func() ([]byte, error) {
+				return contents, nil
+			}})
+		case tar.TypeSymlink:
+			info.mode = os.ModeSymlink | 0777
+			info.size = int64(len(header.Linkname))
+			index.add(header.Name, &archiveEntry{info: info, target: header.Linkname})
+		case tar.TypeLink:
+			// Hard links share the contents of a file earlier in the archive.
+			root := RootGitPath()
+			target, err := root.Resolve(header.Linkname)
+			if err != nil {
+				continue
+			}
+			if linked, exists := index.entries[target.String()]; exists && linked.info.mode.IsRegular() {
+				info.mode = linked.info.mode
+				info.size = linked.info.size
+				index.add(header.Name, &archiveEntry{info: info, contents: linked.contents})
+			}
+		}
+	}
+}
+
+// readZipArchive only reads the zip's directory. Files are decompressed when they are opened.
+func readZipArchive(contents []byte) (*archiveIndex, error) {
+	reader, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		return nil, err
+	}
+
+	index := newArchiveIndex()
+	for _, file := range reader.File {
+		file := file
+		read := func() ([]byte, error) {
+			if file.UncompressedSize64 > maxArchiveSize {
+				return nil, ErrArchiveTooLarge
+			}
+			contents, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer contents.Close()
+			return io.ReadAll(io.LimitReader(contents, int64(file.UncompressedSize64)))
+		}
+
+		info := archiveInfo{size: int64(file.UncompressedSize64), modTime: file.Modified}
+		mode := file.Mode()
+		switch {
+		case mode.IsDir():
+			info.mode = os.ModeDir | 0555
+			info.size = 0
+			index.add(file.Name, &archiveEntry{info: info})
+		case mode&os.ModeSymlink != 0:
+			target, err := read()
+			if err != nil {
+				return nil, err
+			}
+			info.mode = os.ModeSymlink | 0777
+			index.add(file.Name, &archiveEntry{info: info, target: string(target)})
+		case mode.IsRegular():
+			info.mode = archiveFileMode(mode)
+			index.add(file.Name, &archiveEntry{info: info, contents: read})
+		}
+	}
+	return index, nil
+}
+
+// archiveCache keeps recently unpacked archives keyed by the hash of their blob.
+type archiveCache struct {
+	mu      sync.Mutex
+	indexes map[string]*archiveIndex
+}
+
+func (c *archiveCache) get(hash string) (*archiveIndex, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	index, ok := c.indexes[hash]
+	return index, ok
+}
+
+func (c *archiveCache) put(hash string, index *archiveIndex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for evicted := range c.indexes {
+		if len(c.indexes) < archiveCacheSize {
+			break
+		}
+		delete(c.indexes, evicted)
+	}
+	c.indexes[hash] = index
+}
+
+// archiveLocation is a path inside of an archive's directory.
+type archiveLocation struct {
+	index *archiveIndex
+	// name is the name of the archive's directory and modTime is when the archive was last modified.
+	name    string
+	modTime time.Time
+	// inner is the path within the archive.
+	inner string
+}
+
+// archiveFileSystem exposes the contents of tarballs and zips in fs as read-only directories next to them.
+type archiveFileSystem struct {
+	billy.Filesystem
+	cache *archiveCache
+}
+
+// NewArchiveFileSystem makes every .tar, .tar.gz, .tgz, and .zip file in fs browsable at the same path with
+// ArchiveSuffix appended. These directories shadow anything of the same name in fs.
+func NewArchiveFileSystem(fs billy.Filesystem) billy.Filesystem {
+	return archiveFileSystem{
+		Filesystem: fs,
+		cache:      &archiveCache{indexes: make(map[string]*archiveIndex)},
+	}
+}
+
+// lookup finds the archive whose directory filename is in. ok is false for paths outside of every archive's directory.
+func (s archiveFileSystem) lookup(filename string) (location archiveLocation, ok bool, err error) {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil {
+		return archiveLocation{}, false, err
+	}
+
+	for i, part := range resolved.Path {
+		name := strings.TrimSuffix(part, ArchiveSuffix)
+		format, isArchive := archiveFormatOf(name)
+		if name == part || !isArchive {
+			continue
+		}
+
+		archive := FilePath{Path: append(append([]string{}, resolved.Path[:i]...), name)}
+		info, err := s.Filesystem.Lstat(archive.String())
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		index, err := s.load(archive.String(), info, format)
+		if err != nil {
+			return archiveLocation{}, true, err
+		}
+		inner := FilePath{Path: resolved.Path[i+1:]}
+		return archiveLocation{index: index, name: part, modTime: info.ModTime(), inner: inner.String()}, true, nil
+	}
+	return archiveLocation{}, false, nil
+}
+
+// load unpacks the archive at filename. Archives served from git are cached by their blob's hash.
+func (s archiveFileSystem) load(filename string, info os.FileInfo, format archiveFormat) (*archiveIndex, error) {
+	hash, cacheable := ObjectHash(info)
+	if cacheable {
+		if index, ok := s.cache.get(hash); ok {
+			return index, nil
+		}
+	}
+
+	if info.Size() > maxArchiveSize {
+		return nil, ErrArchiveTooLarge
+	}
+	file, err := s.Filesystem.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	contents, err := io.ReadAll(io.LimitReader(file, maxArchiveSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(contents) > maxArchiveSize {
+		return nil, ErrArchiveTooLarge
+	}
+
+	index, err := format.read(contents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive %s: %w", filename, err)
+	}
+	if cacheable {
+		s.cache.put(hash, index)
+	}
+	return index, nil
+}
+
+func (s archiveFileSystem) stat(location archiveLocation, follow bool) (os.FileInfo, error) {
+	entry, err := location.index.lookup(location.inner, follow)
+	if err != nil {
+		return nil, err
+	}
+	info := entry.info
+	if location.inner == "." {
+		info.name = location.name
+		info.modTime = location.modTime
+	}
+	return info, nil
+}
+
+func (s archiveFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s archiveFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	location, ok, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	if flag != os.O_RDONLY {
+		return nil, billy.ErrReadOnly
+	}
+	entry, err := location.index.lookup(location.inner, true)
+	if err != nil {
+		return nil, err
+	}
+	if entry.info.IsDir() {
+		return nil, ErrIsDirectory
+	}
+
+	contents, err := entry.contents()
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryFile(filename, contents), nil
+}
+
+func (s archiveFileSystem) Stat(filename string) (os.FileInfo, error) {
+	location, ok, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.Filesystem.Stat(filename)
+	}
+	return s.stat(location, true)
+}
+
+func (s archiveFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	location, ok, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.Filesystem.Lstat(filename)
+	}
+	return s.stat(location, false)
+}
+
+func (s archiveFileSystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	location, ok, err := s.lookup(dirname)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		files, err := s.Filesystem.ReadDir(dirname)
+		if err != nil {
+			return nil, err
+		}
+
+		archives := make(map[string]bool)
+		for _, file := range files {
+			if _, isArchive := archiveFormatOf(file.Name()); isArchive && file.Mode().IsRegular() {
+				archives[file.Name()+ArchiveSuffix] = true
+			}
+		}
+		if len(archives) == 0 {
+			return files, nil
+		}
+
+		// Drop anything the archives' directories shadow so the listing matches what Stat returns.
+		listing := make([]os.FileInfo, 0, len(files)+len(archives))
+		for _, file := range files {
+			if archives[file.Name()] {
+				continue
+			}
+			listing = append(listing, file)
+			if archives[file.Name()+ArchiveSuffix] {
+				listing = append(listing, archiveInfo{
+					name:    file.Name() + ArchiveSuffix,
+					mode:    os.ModeDir | 0555,
+					modTime: file.ModTime(),
+				})
+			}
+		}
+		return listing, nil
+	}
+
+	entry, err := location.index.lookup(location.inner, true)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.info.IsDir() {
+		return nil, fs.ErrInvalid
+	}
+
+	names := append([]string{}, entry.children...)
+	sort.Strings(names)
+	files := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		files = append(files, location.index.entries[path.Join(location.inner, name)].info)
+	}
+	return files, nil
+}
+
+func (s archiveFileSystem) Readlink(link string) (string, error) {
+	location, ok, err := s.lookup(link)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return s.Filesystem.Readlink(link)
+	}
+
+	entry, err := location.index.lookup(location.inner, false)
+	if err != nil {
+		return "", err
+	}
+	if entry.info.mode&os.ModeSymlink == 0 {
+		return "", fs.ErrInvalid
+	}
+	return entry.target, nil
+}
+
+// Chroot keeps archives browsable in the new root. Archives can't be chrooted into.
+func (s archiveFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	_, ok, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, billy.ErrNotSupported
+	}
+	fs, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return archiveFileSystem{Filesystem: fs, cache: s.cache}, nil
+}
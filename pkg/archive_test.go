@@ -0,0 +1,197 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"io"
+	"os"
+	"testing"
+)
+
+type archiveTestFile struct {
+	name     string
+	contents string
+	link     string
+}
+
+var archiveTestFiles = []archiveTestFile{
+	{name: "README", contents: "top level\n"},
+	{name: "bin/tool", contents: "#!/bin/sh\n"},
+	{name: "bin/current", link: "tool"},
+	{name: "../escape", contents: "should be skipped\n"},
+	{name: "bin/outside", link: "../../escape"},
+}
+
+func writeTarGz(t *testing.T) []byte {
+	var buffer bytes.Buffer
+	compressed := gzip.NewWriter(&buffer)
+	writer := tar.NewWriter(compressed)
+	for _, file := range archiveTestFiles {
+		header := &tar.Header{Name: file.name, Mode: 0755, Size: int64(len(file.contents)), Typeflag: tar.TypeReg}
+		if file.link != "" {
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = file.link
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := writer.Write([]byte(file.contents)); err != nil {
+			t.Fatalf("failed to write tar contents: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+	if err := compressed.Close(); err != nil {
+		t.Fatalf("failed to close gzip: %v", err)
+	}
+	return buffer.Bytes()
+}
+
+func writeZip(t *testing.T) []byte {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, file := range archiveTestFiles {
+		header := &zip.FileHeader{Name: file.name, Method: zip.Deflate}
+		header.SetMode(0755)
+		contents := file.contents
+		if file.link != "" {
+			header.SetMode(os.ModeSymlink | 0777)
+			contents = file.link
+		}
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatalf("failed to write zip header: %v", err)
+		}
+		if _, err := w.Write([]byte(contents)); err != nil {
+			t.Fatalf("failed to write zip contents: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	return buffer.Bytes()
+}
+
+func TestArchiveFileSystem(t *testing.T) {
+	backing := memfs.New()
+	for name, contents := range map[string][]byte{
+		"artifacts/build.tar.gz": writeTarGz(t),
+		"artifacts/build.zip":    writeZip(t),
+		"artifacts/notes.txt":    []byte("not an archive\n"),
+	} {
+		if err := util.WriteFile(backing, name, contents, 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	fs := NewArchiveFileSystem(backing)
+
+	t.Run("listing", func(t *testing.T) {
+		files, err := fs.ReadDir("artifacts")
+		if err != nil {
+			t.Fatalf("ReadDir(artifacts) failed: %v", err)
+		}
+		filesMap := fileMap(files)
+		for _, name := range []string{"build.tar.gz", "build.zip", "notes.txt"} {
+			if info, ok := filesMap[name]; !ok || info.IsDir() {
+				t.Fatalf("artifacts should contain the file %s: %v", name, files)
+			}
+		}
+		for _, name := range []string{"build.tar.gz.d", "build.zip.d"} {
+			if info, ok := filesMap[name]; !ok || !info.IsDir() {
+				t.Fatalf("artifacts should contain the directory %s: %v", name, files)
+			}
+		}
+		if _, ok := filesMap["notes.txt.d"]; ok {
+			t.Fatalf("notes.txt is not an archive: %v", files)
+		}
+	})
+
+	for _, archive := range []string{"artifacts/build.tar.gz.d", "artifacts/build.zip.d"} {
+		t.Run(archive, func(t *testing.T) {
+			info, err := fs.Stat(archive)
+			if err != nil || !info.IsDir() {
+				t.Fatalf("Stat(%s) should be a directory: %v, %v", archive, info, err)
+			}
+
+			files, err := fs.ReadDir(archive)
+			if err != nil {
+				t.Fatalf("ReadDir(%s) failed: %v", archive, err)
+			}
+			if len(files) != 2 || files[0].Name() != "README" || files[1].Name() != "bin" {
+				t.Fatalf("%s should only contain README and bin: %v", archive, files)
+			}
+
+			files, err = fs.ReadDir(archive + "/bin")
+			if err != nil {
+				t.Fatalf("ReadDir(%s/bin) failed: %v", archive, err)
+			}
+			if len(files) != 2 || files[0].Name() != "current" || files[1].Name() != "tool" {
+				t.Fatalf("%s/bin should only contain current and tool: %v", archive, files)
+			}
+			if files[1].Mode().Perm() != 0555 {
+				t.Fatalf("%s/bin/tool should be read-only and executable: %v", archive, files[1].Mode())
+			}
+
+			file, err := fs.Open(archive + "/bin/current")
+			if err != nil {
+				t.Fatalf("Open(%s/bin/current) failed: %v", archive, err)
+			}
+			contents, err := io.ReadAll(file)
+			if err != nil || string(contents) != "#!/bin/sh\n" {
+				t.Fatalf("%s/bin/current contained %q, %v", archive, contents, err)
+			}
+
+			target, err := fs.Readlink(archive + "/bin/current")
+			if err != nil || target != "tool" {
+				t.Fatalf("Readlink(%s/bin/current) = %q, %v", archive, target, err)
+			}
+
+			if _, err := fs.OpenFile(archive+"/README", os.O_RDWR, 0); !errors.Is(err, billy.ErrReadOnly) {
+				t.Fatalf("writing to %s/README should fail with ErrReadOnly: %v", archive, err)
+			}
+			if _, err := fs.Open(archive + "/bin"); !errors.Is(err, ErrIsDirectory) {
+				t.Fatalf("Open(%s/bin) should fail with ErrIsDirectory: %v", archive, err)
+			}
+		})
+	}
+
+	t.Run("not an archive", func(t *testing.T) {
+		if _, err := fs.Stat("artifacts/notes.txt.d"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Stat(artifacts/notes.txt.d) should not exist: %v", err)
+		}
+	})
+
+	t.Run("chroot", func(t *testing.T) {
+		chrooted, err := fs.Chroot("artifacts")
+		if err != nil {
+			t.Fatalf("Chroot(artifacts) failed: %v", err)
+		}
+		if _, err := chrooted.Stat("build.zip.d/README"); err != nil {
+			t.Fatalf("Stat(build.zip.d/README) failed in the new root: %v", err)
+		}
+		if _, err := fs.Chroot("artifacts/build.zip.d"); !errors.Is(err, billy.ErrNotSupported) {
+			t.Fatalf("Chroot into an archive should fail with ErrNotSupported: %v", err)
+		}
+	})
+}
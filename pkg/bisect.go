@@ -0,0 +1,365 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// BisectDirectory is where NewBisectFileSystem exposes the files controlling a bisection, under
+// IntrospectionDirectory.
+const BisectDirectory = "bisect"
+
+// ErrBisectDone is returned when marking commits after a bisection found the first bad commit.
+var ErrBisectDone = fmt.Errorf("bisection already found the first bad commit: %w", syscall.EINVAL)
+
+// BisectStatus describes where a bisection is.
+type BisectStatus struct {
+	// Commit is the commit to test, or the first bad commit when Done.
+	Commit string
+	// Good are the commits known to be good and Bad is the oldest commit known to be bad.
+	Good []string
+	Bad  string
+	// Remaining and Steps are roughly how many commits are left to test after Commit and how many more times a commit
+	// needs to be marked. See gitism.BisectStep.
+	Remaining int
+	Steps     int
+	Done      bool
+}
+
+// String describes the status like git bisect does.
+func (s BisectStatus) String() string {
+	if s.Done {
+		return fmt.Sprintf("%s is the first bad commit\n", s.Commit)
+	}
+	return fmt.Sprintf("Bisecting: %d revisions left to test after this (roughly %d steps)\n%s\n", s.Remaining, s.Steps,
+		s.Commit)
+}
+
+// Bisection searches the commits between good and bad commits for the first bad one, like git bisect, without
+// touching the repository. Every commit marked good or bad halves what is left to test.
+type Bisection struct {
+	git Git
+
+	mu   sync.Mutex
+	good []string
+	bad  string
+	step gitism.BisectStep
+	// moved is told about the next commit to test before the bisection moves to it.
+	moved func(commit string) error
+}
+
+// NewBisection starts bisecting between bad and good, which should be ancestors of bad.
+func NewBisection(git Git, bad Ref, good []Ref) (*Bisection, error) {
+	if len(good) == 0 {
+		return nil, fmt.Errorf("bisecting needs at least one good commit")
+	}
+	b := &Bisection{git: git}
+	var err error
+	b.bad, err = git.ResolveCommit(bad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve bad commit %s: %w", bad, err)
+	}
+	for _, reference := range good {
+		commit, err := git.ResolveCommit(reference)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve good commit %s: %w", reference, err)
+		}
+		b.good = append(b.good, commit)
+	}
+	b.step, err = git.Bisect(b.bad, b.good)
+	if err != nil {
+		return nil, fmt.Errorf("no commits to bisect between %v and %s: %w", good, bad, err)
+	}
+	return b, nil
+}
+
+// OnMove has moved called with the next commit to test whenever a commit is marked, before the bisection moves to
+// it. The bisection stays where it is if moved fails.
+func (b *Bisection) OnMove(moved func(commit string) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.moved = moved
+}
+
+// Commit is the commit to test, or the first bad commit once the bisection is done.
+func (b *Bisection) Commit() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.step.Commit
+}
+
+// Status describes where the bisection is.
+func (b *Bisection) Status() BisectStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BisectStatus{
+		Commit:    b.step.Commit,
+		Good:      append([]string{}, b.good...),
+		Bad:       b.bad,
+		Remaining: b.step.Remaining,
+		Steps:     b.step.Steps,
+		Done:      b.done(),
+	}
+}
+
+// done reports whether the first bad commit was found. Must be called with b.mu held.
+func (b *Bisection) done() bool {
+	return b.step.Candidates <= 1
+}
+
+// MarkGood records that the commit being tested is good and moves on to the next one.
+func (b *Bisection) MarkGood() error {
+	return b.mark(true)
+}
+
+// MarkBad records that the commit being tested is bad and moves on to the next one.
+func (b *Bisection) MarkBad() error {
+	return b.mark(false)
+}
+
+func (b *Bisection) mark(good bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done() {
+		return ErrBisectDone
+	}
+
+	goods, bad := b.good, b.bad
+	if good {
+		goods = append(append([]string{}, goods...), b.step.Commit)
+	} else {
+		bad = b.step.Commit
+	}
+	step, err := b.git.Bisect(bad, goods)
+	if err != nil {
+		return err
+	}
+	if b.moved != nil && step.Commit != b.step.Commit {
+		if err := b.moved(step.Commit); err != nil {
+			return err
+		}
+	}
+	b.good, b.bad, b.step = goods, bad, step
+	return nil
+}
+
+// bisectFiles are the files in BisectDirectory.
+var bisectFiles = map[string]bool{
+	"bad":    true,
+	"good":   true,
+	"status": true,
+}
+
+// bisectFileSystem adds the files controlling a bisection under /.gitfs/bisect/.
+type bisectFileSystem struct {
+	billy.Filesystem
+	bisection *Bisection
+}
+
+// NewBisectFileSystem exposes .gitfs/bisect/status describing bisection on top of fs, which should serve the commit
+// being tested. Writing anything to .gitfs/bisect/good or .gitfs/bisect/bad marks that commit good or bad, and the
+// bisection tells whoever is serving fs which commit to serve next through Bisection.OnMove.
+func NewBisectFileSystem(fs billy.Filesystem, bisection *Bisection) billy.Filesystem {
+	return bisectFileSystem{Filesystem: fs, bisection: bisection}
+}
+
+// lookup maps filename to a file in /.gitfs/bisect/. ok is false for other paths and name is empty for the directory
+// itself.
+func (s bisectFileSystem) lookup(filename string) (name string, ok bool, err error) {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil {
+		return "", false, err
+	}
+	if len(resolved.Path) < 2 || resolved.Path[0] != IntrospectionDirectory || resolved.Path[1] != BisectDirectory {
+		return "", false, nil
+	}
+
+	switch len(resolved.Path) {
+	case 2:
+		return "", true, nil
+	case 3:
+		if bisectFiles[resolved.Path[2]] {
+			return resolved.Path[2], true, nil
+		}
+	}
+	return "", true, fs.ErrNotExist
+}
+
+// introspectionDirectory reports whether filename is /.gitfs/ itself.
+func (s bisectFileSystem) introspectionDirectory(filename string) bool {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	return err == nil && len(resolved.Path) == 1 && resolved.Path[0] == IntrospectionDirectory
+}
+
+func (s bisectFileSystem) stat(name string) os.FileInfo {
+	switch name {
+	case "":
+		return introspectionInfo{name: BisectDirectory, mode: os.ModeDir | 0555}
+	case "status":
+		return introspectionInfo{name: name, mode: 0444, size: int64(len(s.bisection.Status().String()))}
+	default:
+		return introspectionInfo{name: name, mode: 0222}
+	}
+}
+
+func (s bisectFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s bisectFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	name, ok, err := s.lookup(filename)
+	if !ok {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	switch name {
+	case "":
+		return nil, ErrIsDirectory
+	case "status":
+		if flag&writeFlags != 0 {
+			return nil, billy.ErrReadOnly
+		}
+		return newMemoryFile(filename, []byte(s.bisection.Status().String())), nil
+	case "good":
+		return bisectMarkFile{memoryFile: newMemoryFile(filename, nil), mark: s.bisection.MarkGood}, nil
+	default:
+		return bisectMarkFile{memoryFile: newMemoryFile(filename, nil), mark: s.bisection.MarkBad}, nil
+	}
+}
+
+func (s bisectFileSystem) Stat(filename string) (os.FileInfo, error) {
+	name, ok, err := s.lookup(filename)
+	if !ok {
+		return s.statIntrospectionDirectory(filename, s.Filesystem.Stat)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: filename, Err: err}
+	}
+	return s.stat(name), nil
+}
+
+func (s bisectFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	name, ok, err := s.lookup(filename)
+	if !ok {
+		return s.statIntrospectionDirectory(filename, s.Filesystem.Lstat)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+	return s.stat(name), nil
+}
+
+// statIntrospectionDirectory stats filename with stat, making up /.gitfs/ if nothing else serves it so
+// /.gitfs/bisect/ can be reached.
+func (s bisectFileSystem) statIntrospectionDirectory(filename string, stat func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	info, err := stat(filename)
+	if errors.Is(err, fs.ErrNotExist) && s.introspectionDirectory(filename) {
+		return introspectionInfo{name: IntrospectionDirectory, mode: os.ModeDir | 0555}, nil
+	}
+	return info, err
+}
+
+func (s bisectFileSystem) ReadDir(filename string) ([]os.FileInfo, error) {
+	name, ok, err := s.lookup(filename)
+	if !ok {
+		if !s.introspectionDirectory(filename) {
+			return s.Filesystem.ReadDir(filename)
+		}
+		files, err := s.Filesystem.ReadDir(filename)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		files = append(files, s.stat(""))
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Name() < files[j].Name()
+		})
+		return files, nil
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+	if name != "" {
+		return nil, ErrNotDirectory
+	}
+
+	names := make([]string, 0, len(bisectFiles))
+	for name := range bisectFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var files []os.FileInfo
+	for _, name := range names {
+		files = append(files, s.stat(name))
+	}
+	return files, nil
+}
+
+func (s bisectFileSystem) Readlink(link string) (string, error) {
+	_, ok, err := s.lookup(link)
+	if !ok {
+		return s.Filesystem.Readlink(link)
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", fs.ErrInvalid
+}
+
+func (s bisectFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	_, ok, err := s.lookup(path)
+	if !ok {
+		return s.Filesystem.Chroot(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, billy.ErrNotSupported
+}
+
+// bisectMarkFile marks the commit being tested when anything is written to it. Truncating it does nothing so shells
+// can redirect into it.
+type bisectMarkFile struct {
+	memoryFile
+	mark func() error
+}
+
+func (f bisectMarkFile) Write(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := f.mark(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f bisectMarkFile) Truncate(size int64) error {
+	_ = size
+	return nil
+}
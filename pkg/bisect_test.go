@@ -0,0 +1,146 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBisection(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "bisect")
+	bisection, err := NewBisection(git, TagRef("v8"), []Ref{TagRef("v1")})
+	if err != nil {
+		t.Fatalf("NewBisection() failed: %v", err)
+	}
+	firstBad, err := git.ResolveCommit(TagRef("v6"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := NewSwappableFileSystem(NewReferenceFileSystem(git, CommitRef(bisection.Commit())))
+	bisection.OnMove(func(commit string) error {
+		served.Swap(NewReferenceFileSystem(git, CommitRef(commit)))
+		return nil
+	})
+	fs := NewBisectFileSystem(served, bisection)
+
+	read := func(t *testing.T, filename string) string {
+		file, err := fs.Open(filename)
+		if err != nil {
+			t.Fatalf("Open(%s) failed: %v", filename, err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", filename, err)
+		}
+		return string(contents)
+	}
+	mark := func(t *testing.T, filename string) error {
+		file, err := fs.OpenFile(filename, os.O_WRONLY|os.O_TRUNC, 0)
+		if err != nil {
+			t.Fatalf("OpenFile(%s) failed: %v", filename, err)
+		}
+		defer file.Close()
+		if err := file.Truncate(0); err != nil {
+			t.Fatalf("truncating %s failed: %v", filename, err)
+		}
+		_, err = file.Write([]byte("\n"))
+		return err
+	}
+
+	t.Run("listing", func(t *testing.T) {
+		paths, err := fs.ReadDir(IntrospectionDirectory)
+		if err != nil {
+			t.Fatalf("ReadDir(%s) failed: %v", IntrospectionDirectory, err)
+		}
+		if len(paths) != 1 || paths[0].Name() != BisectDirectory || !paths[0].IsDir() {
+			t.Fatalf("%s contained %v", IntrospectionDirectory, paths)
+		}
+		paths, err = fs.ReadDir(".gitfs/bisect")
+		if err != nil {
+			t.Fatalf("ReadDir(.gitfs/bisect) failed: %v", err)
+		}
+		var names []string
+		for _, path := range paths {
+			names = append(names, path.Name())
+		}
+		if strings.Join(names, " ") != "bad good status" {
+			t.Fatalf(".gitfs/bisect contained %v", paths)
+		}
+	})
+
+	t.Run("failed move", func(t *testing.T) {
+		commit := bisection.Commit()
+		bisection.OnMove(func(string) error {
+			return errors.New("failed to move")
+		})
+		defer bisection.OnMove(func(commit string) error {
+			served.Swap(NewReferenceFileSystem(git, CommitRef(commit)))
+			return nil
+		})
+		if err := mark(t, ".gitfs/bisect/good"); err == nil {
+			t.Fatal("marking a commit succeeded even though the mount could not move")
+		}
+		if bisection.Commit() != commit {
+			t.Fatalf("bisection moved to %s even though the mount could not", bisection.Commit())
+		}
+	})
+
+	t.Run("bisect", func(t *testing.T) {
+		for steps := 0; !bisection.Status().Done; steps++ {
+			if steps > 3 {
+				t.Fatalf("bisecting 7 commits took more than 3 steps: %s", bisection.Status())
+			}
+			status := read(t, ".gitfs/bisect/status")
+			if !strings.Contains(status, bisection.Commit()) {
+				t.Fatalf(".gitfs/bisect/status does not name the commit being tested: %s", status)
+			}
+			marker := ".gitfs/bisect/good"
+			if read(t, "status.txt") == "broken\n" {
+				marker = ".gitfs/bisect/bad"
+			}
+			if err := mark(t, marker); err != nil {
+				t.Fatalf("writing to %s failed: %v", marker, err)
+			}
+		}
+
+		if bisection.Commit() != firstBad {
+			t.Fatalf("bisection found %s instead of %s", bisection.Commit(), firstBad)
+		}
+		if status := read(t, ".gitfs/bisect/status"); status != firstBad+" is the first bad commit\n" {
+			t.Fatalf(".gitfs/bisect/status = %q", status)
+		}
+		if version := read(t, "version.txt"); version != "6\n" {
+			t.Fatalf("the first bad commit was not served, read version %q", version)
+		}
+		if err := mark(t, ".gitfs/bisect/bad"); !errors.Is(err, ErrBisectDone) {
+			t.Fatalf("marking a commit after bisecting = %v, expected %v", err, ErrBisectDone)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := NewBisection(git, TagRef("v1"), []Ref{TagRef("v8")}); err == nil {
+			t.Fatal("bisecting from a bad commit older than the good one succeeded")
+		}
+		if _, err := NewBisection(git, TagRef("v8"), nil); err == nil {
+			t.Fatal("bisecting without a good commit succeeded")
+		}
+	})
+}
@@ -0,0 +1,268 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// MemoryBudget bounds how many bytes of blobs open files and memory caches hold together. Opening a file that doesn't
+// fit first evicts the least recently used values of memory caches, and if that isn't enough the file is streamed to
+// a temporary file on disk and read from there instead. A nil *MemoryBudget is unlimited.
+//
+// Blobs that are both open and cached are counted twice, so the budget errs on the side of using less memory.
+type MemoryBudget struct {
+	limit int64
+	dir   string
+
+	mu     sync.Mutex
+	open   int64
+	caches []budgetedCache
+	spills int64
+}
+
+// budgetedCache is a cache whose values count against a MemoryBudget.
+type budgetedCache interface {
+	usedBytes() int64
+	// shrink evicts least recently used values until n bytes are freed or nothing is left, returning how many bytes
+	// were freed.
+	shrink(n int64) int64
+}
+
+// MemoryStats describe how a MemoryBudget is being spent.
+type MemoryStats struct {
+	Limit int64 `json:"limit"`
+	// Open is the bytes held by open files and Cached the bytes held by memory caches.
+	Open   int64 `json:"open"`
+	Cached int64 `json:"cached"`
+	// Spills counts the files opened from disk because they didn't fit.
+	Spills int64 `json:"spills"`
+}
+
+// NewMemoryBudget lets open files and memory caches hold up to limit bytes of blobs. Files that don't fit are spilled
+// to temporary files in dir, or the default directory for temporary files if dir is empty. A limit of 0 or less is
+// unlimited, which is a nil *MemoryBudget.
+func NewMemoryBudget(limit int64, dir string) *MemoryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &MemoryBudget{limit: limit, dir: dir}
+}
+
+// track counts what cache holds against b, if it holds values in memory.
+func (b *MemoryBudget) track(cache Cache) {
+	if b == nil {
+		return
+	}
+	if managed, ok := cache.(*ManagedCache); ok {
+		cache = managed.Cache
+	}
+	memory, ok := cache.(*memoryCache)
+	if !ok {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, tracked := range b.caches {
+		if tracked == budgetedCache(memory) {
+			return
+		}
+	}
+	b.caches = append(b.caches, memory)
+	memory.mu.Lock()
+	memory.budget = b
+	memory.mu.Unlock()
+}
+
+// used is how many bytes are held. Must be called with b.mu held.
+func (b *MemoryBudget) used() (open, cached int64) {
+	for _, cache := range b.caches {
+		cached += cache.usedBytes()
+	}
+	return b.open, cached
+}
+
+// fit evicts from caches until extra more bytes fit in the budget, reporting whether they do. Must be called with b.mu
+// held, and caches must not call into b while holding their own locks.
+func (b *MemoryBudget) fit(extra int64) bool {
+	open, cached := b.used()
+	over := open + cached + extra - b.limit
+	for _, cache := range b.caches {
+		if over <= 0 {
+			break
+		}
+		over -= cache.shrink(over)
+	}
+	return over <= 0
+}
+
+// reserve counts n bytes of an open file against b, reporting false if they don't fit even after evicting everything
+// cached.
+func (b *MemoryBudget) reserve(n int64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.fit(n) {
+		b.spills++
+		return false
+	}
+	b.open += n
+	return true
+}
+
+// release returns n bytes reserved for a file that was closed.
+func (b *MemoryBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open -= n
+}
+
+// trim evicts from caches until they fit in b again after one of them grew.
+func (b *MemoryBudget) trim() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fit(0)
+}
+
+// Stats returns how b is being spent.
+func (b *MemoryBudget) Stats() MemoryStats {
+	if b == nil {
+		return MemoryStats{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	open, cached := b.used()
+	return MemoryStats{Limit: b.limit, Open: open, Cached: cached, Spills: b.spills}
+}
+
+// blobReader reads the contents of an opened blob.
+type blobReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// openedBlob is a blob opened for reading, either held in memory or spilled to disk.
+type openedBlob struct {
+	blobReader
+	// size is the length of the contents and spilled is true when they are read from disk.
+	size    int64
+	spilled bool
+	// close releases what the blob holds. It may be called more than once.
+	close func() error
+}
+
+// closeOnce makes close safe to call more than once, since files may be closed again by callers.
+func closeOnce(close func() error) func() error {
+	var once sync.Once
+	var err error
+	return func() error {
+		once.Do(func() {
+			err = close()
+		})
+		return err
+	}
+}
+
+func memoryBlob(contents []byte) openedBlob {
+	return openedBlob{
+		blobReader: bytes.NewReader(contents),
+		size:       int64(len(contents)),
+		close:      func() error { return nil },
+	}
+}
+
+// openBlob opens the blob named by hash, which is size bytes, within the memory budget of git if it has one.
+func openBlob(git Git, hash string, size int64) (openedBlob, error) {
+	if git, ok := git.(interface {
+		openBlob(hash string, size int64) (openedBlob, error)
+	}); ok {
+		return git.openBlob(hash, size)
+	}
+	contents, err := git.ReadBlob(hash)
+	if err != nil {
+		return openedBlob{}, err
+	}
+	return memoryBlob(contents), nil
+}
+
+func (g cliGit) openBlob(hash string, size int64) (openedBlob, error) {
+	if g.budget.reserve(size) {
+		contents, err := g.ReadBlob(hash)
+		if err != nil {
+			g.budget.release(size)
+			return openedBlob{}, err
+		}
+		blob := memoryBlob(contents)
+		blob.close = closeOnce(func() error {
+			g.budget.release(size)
+			return nil
+		})
+		return blob, nil
+	}
+	return g.spillBlob(hash, size)
+}
+
+// spillBlob streams the blob named by hash to a temporary file that is removed as soon as it is created, so the disk
+// space is given back when the file is closed even if the process dies.
+func (g cliGit) spillBlob(hash string, size int64) (openedBlob, error) {
+	log.Printf("memory budget is spent, reading blob %s of %d bytes from disk\n", hash, size)
+	file, err := os.CreateTemp(g.budget.dir, "gitfs-blob-")
+	if err != nil {
+		return openedBlob{}, err
+	}
+	if err := os.Remove(file.Name()); err != nil {
+		file.Close()
+		return openedBlob{}, err
+	}
+
+	err = g.cli.CatFileTo("blob", hash, file)
+	if err != nil && g.fetches.fetch(hash) == nil {
+		if err = file.Truncate(0); err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			err = g.cli.CatFileTo("blob", hash, file)
+		}
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return openedBlob{}, err
+	}
+	return openedBlob{blobReader: file, size: size, spilled: true, close: closeOnce(file.Close)}, nil
+}
+
+func (g cliGit) budgetStats() (MemoryStats, bool) {
+	if g.budget == nil {
+		return MemoryStats{}, false
+	}
+	return g.budget.Stats(), true
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"io"
+	"testing"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	if NewMemoryBudget(0, "") != nil {
+		t.Fatal("a limit of 0 should be unlimited")
+	}
+
+	budget := NewMemoryBudget(100, "")
+	cache := NewMemoryCache(1000)
+	budget.track(NewManagedCache(cache))
+	for _, key := range []string{"blob/a", "blob/b", "blob/c"} {
+		if err := cache.Set(key, make([]byte, 40)); err != nil {
+			t.Fatalf("Set(%s) failed: %v", key, err)
+		}
+	}
+	if stats := budget.Stats(); stats.Cached != 80 {
+		t.Fatalf("the cache grew past the budget: %+v", stats)
+	}
+	if _, err := cache.Get("blob/a"); err != ErrCacheMiss {
+		t.Fatalf("the least recently used value should have been evicted: %v", err)
+	}
+
+	if !budget.reserve(50) {
+		t.Fatal("reserve(50) should evict cached values to fit")
+	}
+	if stats := budget.Stats(); stats.Open != 50 || stats.Cached != 40 {
+		t.Fatalf("reserving 50 bytes left %+v", stats)
+	}
+	if budget.reserve(200) {
+		t.Fatal("reserve(200) can't fit in a budget of 100")
+	}
+	budget.release(50)
+	if stats := budget.Stats(); stats.Open != 0 || stats.Spills != 1 {
+		t.Fatalf("releasing left %+v", stats)
+	}
+}
+
+func TestCliGitMemoryBudget(t *testing.T) {
+	tmp := t.TempDir()
+	repository, err := runPlaybook("base", tmp)
+	if err != nil {
+		t.Fatalf("playbook 'base' failed: %v", err)
+	}
+	master := BranchRef("master")
+
+	open := func(t *testing.T, budget *MemoryBudget) (OpenHandle, MemoryStats) {
+		git, err := NewCliGit(repository, WithMemoryBudget(budget))
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := NewReferenceFileSystem(git, master).Open("real.txt")
+		if err != nil {
+			t.Fatalf("Open(real.txt) failed: %v", err)
+		}
+		defer func() {
+			file.Close()
+			if stats := budget.Stats(); stats.Open != 0 {
+				t.Fatalf("closing real.txt left %+v", stats)
+			}
+		}()
+		contents, err := io.ReadAll(file)
+		if err != nil || string(contents) != "Hello World\n" {
+			t.Fatalf("real.txt contained %q, %v", contents, err)
+		}
+		for _, handle := range OpenHandles(git) {
+			if handle.Path == "real.txt" {
+				return handle, budget.Stats()
+			}
+		}
+		t.Fatal("real.txt isn't listed as open")
+		return OpenHandle{}, MemoryStats{}
+	}
+
+	t.Run("in memory", func(t *testing.T) {
+		handle, stats := open(t, NewMemoryBudget(1<<20, ""))
+		if handle.Spilled || stats.Open != 12 || stats.Spills != 0 {
+			t.Fatalf("real.txt fits in the budget but was opened as %+v with %+v", handle, stats)
+		}
+	})
+
+	t.Run("spilled", func(t *testing.T) {
+		handle, stats := open(t, NewMemoryBudget(1, t.TempDir()))
+		if !handle.Spilled || stats.Open != 0 || stats.Spills != 1 {
+			t.Fatalf("real.txt doesn't fit in the budget but was opened as %+v with %+v", handle, stats)
+		}
+	})
+}
@@ -0,0 +1,289 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/helper/chroot"
+	"github.com/go-git/go-billy/v5/util"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// writeFlags are the flags to OpenFile that need a writable file.
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+// buildCacheFileSystem serves paths that the repository's .gitignore ignores out of a writable cache directory and
+// everything else from the read-only tree underneath. Build outputs like build/ or node_modules/ can then be written
+// next to the sources of a mounted revision.
+type buildCacheFileSystem struct {
+	billy.Filesystem
+	cache  billy.Filesystem
+	ignore Gitignore
+}
+
+// NewBuildCacheFileSystem overlays cache on top of fs for every path ignored by the .gitignore at the root of fs. Only
+// the root .gitignore is read and only when the filesystem is created. Writes to paths that aren't ignored fail with
+// billy.ErrReadOnly.
+func NewBuildCacheFileSystem(fs billy.Filesystem, cache billy.Filesystem) (billy.Filesystem, error) {
+	contents, err := util.ReadFile(fs, ".gitignore")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return buildCacheFileSystem{
+		Filesystem: fs,
+		cache:      cache,
+		ignore:     ParseGitignore(contents),
+	}, nil
+}
+
+// clean turns filename into a path relative to the root of the filesystem.
+func (s buildCacheFileSystem) clean(filename string) (string, error) {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil {
+		return "", err
+	}
+	if resolved.IsRoot() {
+		return ".", nil
+	}
+	return strings.Join(resolved.Path, "/"), nil
+}
+
+// cached looks filename up in the cache. ok is false if it is not ignored or not in the cache.
+func (s buildCacheFileSystem) cached(filename string) (name string, ok bool, err error) {
+	name, err = s.clean(filename)
+	if err != nil {
+		return "", false, err
+	}
+	if name == "." {
+		return name, false, nil
+	}
+	info, err := s.cache.Lstat(name)
+	if err != nil {
+		return name, false, nil
+	}
+	return name, s.ignore.Match(name, info.IsDir()), nil
+}
+
+// writable checks that filename may be created in the cache and creates the directories above it there.
+func (s buildCacheFileSystem) writable(filename string, isDir bool) (string, error) {
+	name, err := s.clean(filename)
+	if err != nil {
+		return "", err
+	}
+	if name == "." || !s.ignore.Match(name, isDir) {
+		return "", billy.ErrReadOnly
+	}
+
+	parent := path.Dir(name)
+	if info, err := s.Stat(parent); err != nil {
+		return "", err
+	} else if !info.IsDir() {
+		return "", &fs.PathError{Op: "open", Path: filename, Err: ErrNotDirectory}
+	}
+	if parent != "." {
+		if err := s.cache.MkdirAll(parent, 0755); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+// billy.Basic type implementation
+
+func (s buildCacheFileSystem) Create(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (s buildCacheFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s buildCacheFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	name, ok, err := s.cached(filename)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return s.cache.OpenFile(name, flag, perm)
+	}
+	if flag&writeFlags == 0 {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	name, err = s.writable(filename, false)
+	if err != nil {
+		return nil, err
+	}
+	return s.cache.OpenFile(name, flag, perm)
+}
+
+func (s buildCacheFileSystem) Stat(filename string) (os.FileInfo, error) {
+	name, ok, err := s.cached(filename)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return s.cache.Stat(name)
+	}
+	return s.Filesystem.Stat(filename)
+}
+
+func (s buildCacheFileSystem) Rename(oldpath, newpath string) error {
+	from, ok, err := s.cached(oldpath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return billy.ErrReadOnly
+	}
+	info, err := s.cache.Lstat(from)
+	if err != nil {
+		return err
+	}
+	to, err := s.writable(newpath, info.IsDir())
+	if err != nil {
+		return err
+	}
+	return s.cache.Rename(from, to)
+}
+
+func (s buildCacheFileSystem) Remove(filename string) error {
+	name, ok, err := s.cached(filename)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return billy.ErrReadOnly
+	}
+	return s.cache.Remove(name)
+}
+
+// billy.TempFile type implementation
+
+func (s buildCacheFileSystem) TempFile(dir, prefix string) (billy.File, error) {
+	name, ok, err := s.cached(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, billy.ErrReadOnly
+	}
+	return s.cache.TempFile(name, prefix)
+}
+
+// billy.Dir type implementation
+
+// ReadDir lists filename from the tree with any ignored files from the cache added to it. Ignored files in both are
+// listed from the cache.
+func (s buildCacheFileSystem) ReadDir(filename string) ([]os.FileInfo, error) {
+	name, ok, err := s.cached(filename)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return s.cache.ReadDir(name)
+	}
+
+	files, err := s.Filesystem.ReadDir(filename)
+	if err != nil {
+		return nil, err
+	}
+	cached, err := s.cache.ReadDir(name)
+	if err != nil {
+		// Nothing has been written under this directory yet.
+		return files, nil
+	}
+
+	indexes := make(map[string]int, len(files))
+	for i, file := range files {
+		indexes[file.Name()] = i
+	}
+	for _, file := range cached {
+		if !s.ignore.Match(path.Join(name, file.Name()), file.IsDir()) {
+			continue
+		}
+		if i, exists := indexes[file.Name()]; exists {
+			files[i] = file
+		} else {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func (s buildCacheFileSystem) MkdirAll(filename string, perm os.FileMode) error {
+	if info, err := s.Stat(filename); err == nil && info.IsDir() {
+		return nil
+	}
+
+	name, err := s.clean(filename)
+	if err != nil {
+		return err
+	}
+	if parent := path.Dir(name); parent != "." {
+		if err := s.MkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	name, err = s.writable(name, true)
+	if err != nil {
+		return err
+	}
+	return s.cache.MkdirAll(name, perm)
+}
+
+// billy.Symlink type implementation
+
+func (s buildCacheFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	name, ok, err := s.cached(filename)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return s.cache.Lstat(name)
+	}
+	return s.Filesystem.Lstat(filename)
+}
+
+func (s buildCacheFileSystem) Symlink(target, link string) error {
+	name, err := s.writable(link, false)
+	if err != nil {
+		return err
+	}
+	return s.cache.Symlink(target, name)
+}
+
+func (s buildCacheFileSystem) Readlink(link string) (string, error) {
+	name, ok, err := s.cached(link)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return s.cache.Readlink(name)
+	}
+	return s.Filesystem.Readlink(link)
+}
+
+// billy.Chroot type implementation
+
+// Chroot keeps matching paths against the .gitignore of the original root.
+func (s buildCacheFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(s, path), nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"os"
+	"testing"
+)
+
+func TestBuildCacheFileSystem(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "buildcache")
+	cache := memfs.New()
+	fs, err := NewBuildCacheFileSystem(NewReferenceFileSystem(git, BranchRef("master")), cache)
+	if err != nil {
+		t.Fatalf("NewBuildCacheFileSystem() failed: %v", err)
+	}
+
+	t.Run("ignored", func(t *testing.T) {
+		if err := fs.MkdirAll("build/out", 0755); err != nil {
+			t.Fatalf("MkdirAll(build/out) failed: %v", err)
+		}
+		if err := util.WriteFile(fs, "build/out/main", []byte("binary"), 0755); err != nil {
+			t.Fatalf("writing build/out/main failed: %v", err)
+		}
+		if err := util.WriteFile(fs, "src/main.o", []byte("object"), 0644); err != nil {
+			t.Fatalf("writing src/main.o failed: %v", err)
+		}
+		if contents, err := util.ReadFile(cache, "build/out/main"); err != nil || string(contents) != "binary" {
+			t.Fatalf("build/out/main was not written to the cache: %q, %v", contents, err)
+		}
+		if contents, err := util.ReadFile(fs, "src/main.o"); err != nil || string(contents) != "object" {
+			t.Fatalf("reading src/main.o returned %q, %v", contents, err)
+		}
+
+		if err := fs.Rename("src/main.o", "build/main.o"); err != nil {
+			t.Fatalf("Rename(src/main.o, build/main.o) failed: %v", err)
+		}
+		if err := fs.Remove("build/main.o"); err != nil {
+			t.Fatalf("Remove(build/main.o) failed: %v", err)
+		}
+		if _, err := fs.Stat("build/main.o"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("build/main.o should have been removed: %v", err)
+		}
+	})
+
+	t.Run("listings", func(t *testing.T) {
+		files, err := fs.ReadDir(".")
+		if err != nil {
+			t.Fatalf("ReadDir(.) failed: %v", err)
+		}
+		got := fileMap(files)
+		for _, name := range []string{".gitignore", "src", "prebuilt.o", "build"} {
+			if _, ok := got[name]; !ok {
+				t.Fatalf("ReadDir(.) is missing %s: %v", name, files)
+			}
+		}
+		if len(files) != 4 {
+			t.Fatalf("ReadDir(.) returned %v", files)
+		}
+
+		files, err = fs.ReadDir("build/out")
+		if err != nil || len(files) != 1 || files[0].Name() != "main" {
+			t.Fatalf("ReadDir(build/out) returned %v, %v", files, err)
+		}
+	})
+
+	t.Run("tracked", func(t *testing.T) {
+		if contents, err := util.ReadFile(fs, "src/main.c"); err != nil || len(contents) == 0 {
+			t.Fatalf("reading src/main.c returned %q, %v", contents, err)
+		}
+		if contents, err := util.ReadFile(fs, "prebuilt.o"); err != nil || string(contents) != "Checked in even though it is ignored\n" {
+			t.Fatalf("reading prebuilt.o returned %q, %v", contents, err)
+		}
+
+		for name, write := range map[string]func() error{
+			"write src/main.c":  func() error { return util.WriteFile(fs, "src/main.c", nil, 0644) },
+			"create src/new.c":  func() error { return util.WriteFile(fs, "src/new.c", nil, 0644) },
+			"remove src/main.c": func() error { return fs.Remove("src/main.c") },
+			"mkdir docs":        func() error { return fs.MkdirAll("docs", 0755) },
+		} {
+			if err := write(); !errors.Is(err, billy.ErrReadOnly) {
+				t.Fatalf("%s should fail with ErrReadOnly: %v", name, err)
+			}
+		}
+
+		if err := util.WriteFile(fs, "missing/main.o", nil, 0644); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("writing into a directory that doesn't exist should fail with ErrNotExist: %v", err)
+		}
+	})
+}
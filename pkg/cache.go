@@ -0,0 +1,481 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultMemoryCacheSize is how many bytes a memory cache holds when ParseCache isn't given a size.
+const defaultMemoryCacheSize = 256 << 20
+
+// ErrCacheMiss is returned by Cache.Get for keys that aren't cached.
+var ErrCacheMiss = errors.New("not in cache")
+
+// Cache stores values that never change once written, like the contents of a blob, under keys that name them by
+// hash. Values may be dropped at any time, so a Cache shared between many gitfs daemons serving the same repository
+// only has to be fast, not durable. Callers must not modify the slices passed to or returned by a Cache.
+type Cache interface {
+	// Get returns the value stored under key or ErrCacheMiss.
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	// Flush drops every value in namespace, the part of the key before its first slash, or every value if namespace
+	// is empty.
+	Flush(namespace string) error
+}
+
+// CacheScope picks which values ManagedCache.FlushScope drops.
+type CacheScope uint8
+
+const (
+	// CacheScopeAll is everything gitfs caches.
+	CacheScopeAll CacheScope = iota
+	// CacheScopeBlobs is the contents and sizes of blobs.
+	CacheScopeBlobs
+	// CacheScopeTrees is listings of trees.
+	CacheScopeTrees
+)
+
+func ParseCacheScope(name string) (CacheScope, error) {
+	switch name {
+	case "all":
+		return CacheScopeAll, nil
+	case "blobs":
+		return CacheScopeBlobs, nil
+	case "trees":
+		return CacheScopeTrees, nil
+	default:
+		return 0, fmt.Errorf("unknown cache scope '%s', expected blobs, trees, or all", name)
+	}
+}
+
+func (s CacheScope) String() string {
+	switch s {
+	case CacheScopeAll:
+		return "all"
+	case CacheScopeBlobs:
+		return "blobs"
+	case CacheScopeTrees:
+		return "trees"
+	default:
+		return "unknown-cache-scope"
+	}
+}
+
+// namespaces are the namespaces of the keys in s.
+func (s CacheScope) namespaces() []string {
+	switch s {
+	case CacheScopeBlobs:
+		return []string{"blob", "size"}
+	case CacheScopeTrees:
+		return []string{"tree", "directory"}
+	default:
+		return []string{""}
+	}
+}
+
+// cacheNamespace is the part of key before its first slash.
+func cacheNamespace(key string) string {
+	if index := strings.IndexByte(key, '/'); index >= 0 {
+		return key[:index]
+	}
+	return ""
+}
+
+// CacheStats counts how a ManagedCache has been used since it was opened.
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Evictions is only counted for memory caches. Other caches evict values on their own.
+	Evictions int64 `json:"evictions"`
+	// Errors counts reads and writes that failed for reasons other than a miss.
+	Errors  int64 `json:"errors"`
+	Flushes int64 `json:"flushes"`
+}
+
+// ManagedCache is a Cache that counts its hits and misses and can be flushed by scope, for operators recovering from
+// bad cache contents without restarting.
+type ManagedCache struct {
+	Cache
+
+	mu    sync.Mutex
+	stats CacheStats
+}
+
+// NewManagedCache counts how cache is used.
+func NewManagedCache(cache Cache) *ManagedCache {
+	return &ManagedCache{Cache: cache}
+}
+
+func (c *ManagedCache) Get(key string) ([]byte, error) {
+	value, err := c.Cache.Get(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case err == nil:
+		c.stats.Hits++
+	case errors.Is(err, ErrCacheMiss):
+		c.stats.Misses++
+	default:
+		c.stats.Errors++
+	}
+	return value, err
+}
+
+func (c *ManagedCache) Set(key string, value []byte) error {
+	err := c.Cache.Set(key, value)
+	if err != nil {
+		c.mu.Lock()
+		c.stats.Errors++
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// FlushScope drops every value in scope.
+func (c *ManagedCache) FlushScope(scope CacheScope) error {
+	for _, namespace := range scope.namespaces() {
+		if err := c.Cache.Flush(namespace); err != nil {
+			return err
+		}
+	}
+	advanceEpoch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Flushes++
+	return nil
+}
+
+// Stats returns the counters of c so far.
+func (c *ManagedCache) Stats() CacheStats {
+	c.mu.Lock()
+	stats := c.stats
+	c.mu.Unlock()
+	if counter, ok := c.Cache.(interface{ evictions() int64 }); ok {
+		stats.Evictions = counter.evictions()
+	}
+	return stats
+}
+
+// ParseCache builds the Cache spec describes: "memory" or "memory:BYTES" for a cache in this process, "disk:DIR" for
+// one in a directory, or "redis://HOST:PORT" or "memcached://HOST:PORT" for one shared over the network. An empty spec
+// is no cache, which is a nil Cache.
+func ParseCache(spec string) (Cache, error) {
+	switch {
+	case spec == "":
+		return nil, nil
+	case spec == "memory":
+		return NewMemoryCache(defaultMemoryCacheSize), nil
+	case strings.HasPrefix(spec, "memory:"):
+		size, err := strconv.ParseInt(strings.TrimPrefix(spec, "memory:"), 10, 64)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("cache size in '%s' must be a positive number of bytes", spec)
+		}
+		return NewMemoryCache(size), nil
+	case strings.HasPrefix(spec, "disk:"):
+		return NewDiskCache(strings.TrimPrefix(spec, "disk:"))
+	case strings.HasPrefix(spec, "redis://"):
+		return NewRedisCache(strings.TrimPrefix(spec, "redis://")), nil
+	case strings.HasPrefix(spec, "memcached://"):
+		return NewMemcachedCache(strings.TrimPrefix(spec, "memcached://")), nil
+	default:
+		return nil, fmt.Errorf("unknown cache '%s', expected memory, disk:DIR, redis://ADDRESS, or memcached://ADDRESS", spec)
+	}
+}
+
+type memoryCacheEntry struct {
+	key   string
+	value []byte
+}
+
+// memoryCache keeps the most recently used values in memory, up to size bytes.
+type memoryCache struct {
+	mu      sync.Mutex
+	size    int64
+	used    int64
+	evicted int64
+	order   *list.List
+	entries map[string]*list.Element
+	// budget, if set, is told whenever the cache grows so it can evict more than size alone would.
+	budget *MemoryBudget
+}
+
+// NewMemoryCache caches up to size bytes of values in memory, dropping the least recently used ones first.
+func NewMemoryCache(size int64) Cache {
+	return &memoryCache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *memoryCache) Get(key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*memoryCacheEntry).value, nil
+}
+
+func (c *memoryCache) Set(key string, value []byte) error {
+	if int64(len(value)) > c.size {
+		// It would push out everything else and still not fit.
+		return nil
+	}
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.used -= int64(len(element.Value.(*memoryCacheEntry).value))
+		c.order.Remove(element)
+	}
+	c.entries[key] = c.order.PushFront(&memoryCacheEntry{key: key, value: value})
+	c.used += int64(len(value))
+	for c.used > c.size {
+		c.evictOldest()
+	}
+	budget := c.budget
+	c.mu.Unlock()
+
+	// The budget locks c itself to evict.
+	budget.trim()
+	return nil
+}
+
+// evictOldest drops the least recently used value. Must be called with c.mu held.
+func (c *memoryCache) evictOldest() {
+	oldest := c.order.Back()
+	entry := oldest.Value.(*memoryCacheEntry)
+	c.order.Remove(oldest)
+	delete(c.entries, entry.key)
+	c.used -= int64(len(entry.value))
+	c.evicted++
+}
+
+func (c *memoryCache) usedBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used
+}
+
+func (c *memoryCache) shrink(n int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	before := c.used
+	for before-c.used < n && c.order.Len() > 0 {
+		c.evictOldest()
+	}
+	return before - c.used
+}
+
+func (c *memoryCache) Flush(namespace string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, element := range c.entries {
+		if namespace == "" || cacheNamespace(key) == namespace {
+			c.used -= int64(len(element.Value.(*memoryCacheEntry).value))
+			c.order.Remove(element)
+			delete(c.entries, key)
+		}
+	}
+	return nil
+}
+
+func (c *memoryCache) evictions() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evicted
+}
+
+// diskCache keeps values in files named by the hash of their key.
+type diskCache struct {
+	dir string
+}
+
+// NewDiskCache caches values in files under dir, creating it if needed. Nothing is ever removed, so dir should be
+// somewhere that is cleaned up, like a tmpfs or a directory pruned by a cron job.
+func NewDiskCache(dir string) (Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return diskCache{dir: dir}, nil
+}
+
+// namespaceDir is the directory the values in namespace are stored under. gitfs only uses lowercase namespaces, and
+// anything else shares a directory.
+func (c diskCache) namespaceDir(namespace string) string {
+	if namespace == "" || strings.Trim(namespace, "abcdefghijklmnopqrstuvwxyz") != "" {
+		namespace = "other"
+	}
+	return filepath.Join(c.dir, namespace)
+}
+
+// path is where key is stored, in the directory of its namespace and spread over directories named by the first byte
+// of its hash.
+func (c diskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.namespaceDir(cacheNamespace(key)), name[:2], name[2:])
+}
+
+func (c diskCache) Get(key string) ([]byte, error) {
+	value, err := os.ReadFile(c.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCacheMiss
+	}
+	return value, err
+}
+
+func (c diskCache) Set(key string, value []byte) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write somewhere else first so readers never see half of a value.
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(value)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+	return err
+}
+
+func (c diskCache) Flush(namespace string) error {
+	if namespace != "" {
+		return os.RemoveAll(c.namespaceDir(namespace))
+	}
+	namespaces, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, dir := range namespaces {
+		if err := os.RemoveAll(filepath.Join(c.dir, dir.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cacheStats reports how g's cache has been used, if it is a ManagedCache.
+func (g cliGit) cacheStats() (CacheStats, bool) {
+	managed, ok := g.cache.(*ManagedCache)
+	if !ok {
+		return CacheStats{}, false
+	}
+	return managed.Stats(), true
+}
+
+// cacheGet returns what is cached under key, if g has a cache and it has key. Keys naming replaced objects are never
+// cached.
+func (g cliGit) cacheGet(key string) ([]byte, bool) {
+	if g.cache == nil || !g.cacheable(key) {
+		return nil, false
+	}
+	value, err := g.cache.Get(key)
+	if err != nil {
+		if !errors.Is(err, ErrCacheMiss) {
+			log.Printf("failed to read %s from the cache: %v", key, err)
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+// cacheSet caches value under key if g has a cache.
+func (g cliGit) cacheSet(key string, value []byte) {
+	if g.cache == nil || !g.cacheable(key) {
+		return
+	}
+	if err := g.cache.Set(key, value); err != nil {
+		log.Printf("failed to write %s to the cache: %v", key, err)
+	}
+}
+
+// cachedBlob returns the blob named by hash if it is cached. Blobs from a network cache are checked against hash and
+// treated as missing if they don't match, so whoever can write to the cache can't change what is served.
+func (g cliGit) cachedBlob(hash string) ([]byte, bool) {
+	contents, ok := g.cacheGet("blob/" + hash)
+	if !ok || !isNetworkCache(g.cache) {
+		return contents, ok
+	}
+	format := gitism.SHA1
+	if len(hash) == gitism.SHA256.HashLength() {
+		format = gitism.SHA256
+	}
+	if format.BlobHash(contents) != hash {
+		log.Printf("ignoring blob %s from the cache since its contents don't match its hash", hash)
+		return nil, false
+	}
+	return contents, true
+}
+
+// listingCacheKey names the listing of kind at path in the cache. Only listings of commits named by their full hash
+// can't change, so the key is empty for every other ref.
+func listingCacheKey(kind string, path GitPath) string {
+	if path.Reference.Kind != RefCommit || !gitism.IsHash(path.Reference.Name) {
+		return ""
+	}
+	return kind + "/" + path.Reference.Name + "/" + path.TreePath
+}
+
+// cachedListing calls handler with what list lists, reading it from the cache under key if it's there and caching it
+// if it isn't. Nothing is cached for an empty key or an empty listing, since the commit may yet be fetched. Network
+// caches aren't used, like in BlobSize, since a listing can't be checked without listing the tree.
+func (g cliGit) cachedListing(key string, list func(handler func(entry gitism.TreeEntry) error) error, handler func(entry gitism.TreeEntry) error) error {
+	if g.cache == nil || key == "" || !g.cacheable(key) || isNetworkCache(g.cache) {
+		return list(handler)
+	}
+	if cached, ok := g.cacheGet(key); ok {
+		var entries []gitism.TreeEntry
+		if err := json.Unmarshal(cached, &entries); err == nil {
+			for _, entry := range entries {
+				if err := handler(entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	var entries []gitism.TreeEntry
+	err := list(func(entry gitism.TreeEntry) error {
+		entries = append(entries, entry)
+		return handler(entry)
+	})
+	if err == nil && len(entries) > 0 {
+		if value, err := json.Marshal(entries); err == nil {
+			g.cacheSet(key, value)
+		}
+	}
+	return err
+}
@@ -0,0 +1,436 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCacheServer serves one of the network cache protocols from memory. serve answers a single request read from
+// reader out of values.
+func fakeCacheServer(t *testing.T, serve func(reader *bufio.Reader, w io.Writer, values map[string][]byte) error) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	values := map[string][]byte{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					mu.Lock()
+					err := serve(reader, conn, values)
+					mu.Unlock()
+					if err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func readFakeLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	return strings.TrimSuffix(line, "\r\n"), err
+}
+
+func serveFakeRedis(reader *bufio.Reader, w io.Writer, values map[string][]byte) error {
+	line, err := readFakeLine(reader)
+	if err != nil {
+		return err
+	}
+	count, _ := strconv.Atoi(strings.TrimPrefix(line, "*"))
+	var args []string
+	for i := 0; i < count; i++ {
+		line, err := readFakeLine(reader)
+		if err != nil {
+			return err
+		}
+		length, _ := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		arg := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, arg); err != nil {
+			return err
+		}
+		args = append(args, string(arg[:length]))
+	}
+	switch args[0] {
+	case "GET":
+		value, ok := values[args[1]]
+		if !ok {
+			_, err = io.WriteString(w, "$-1\r\n")
+			return err
+		}
+		_, err = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(value), value)
+	case "SET":
+		values[args[1]] = []byte(args[2])
+		_, err = io.WriteString(w, "+OK\r\n")
+	case "SCAN":
+		// Everything fits in one page, and gitfs only matches prefixes.
+		var keys []string
+		for key := range values {
+			if strings.HasPrefix(key, strings.TrimSuffix(args[3], "*")) {
+				keys = append(keys, key)
+			}
+		}
+		reply := fmt.Sprintf("*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+		for _, key := range keys {
+			reply += fmt.Sprintf("$%d\r\n%s\r\n", len(key), key)
+		}
+		_, err = io.WriteString(w, reply)
+	case "DEL":
+		for _, key := range args[1:] {
+			delete(values, key)
+		}
+		_, err = fmt.Fprintf(w, ":%d\r\n", len(args)-1)
+	default:
+		_, err = io.WriteString(w, "-ERR unknown command\r\n")
+	}
+	return err
+}
+
+func serveFakeMemcached(reader *bufio.Reader, w io.Writer, values map[string][]byte) error {
+	line, err := readFakeLine(reader)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "get":
+		if value, ok := values[fields[1]]; ok {
+			_, err = fmt.Fprintf(w, "VALUE %s 0 %d\r\n%s\r\n", fields[1], len(value), value)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = io.WriteString(w, "END\r\n")
+	case "set":
+		length, _ := strconv.Atoi(fields[4])
+		value := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, value); err != nil {
+			return err
+		}
+		values[fields[1]] = value[:length]
+		_, err = io.WriteString(w, "STORED\r\n")
+	case "flush_all":
+		for key := range values {
+			delete(values, key)
+		}
+		_, err = io.WriteString(w, "OK\r\n")
+	default:
+		_, err = io.WriteString(w, "ERROR\r\n")
+	}
+	return err
+}
+
+func TestCaches(t *testing.T) {
+	disk, err := NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	caches := map[string]Cache{
+		"memory":    NewMemoryCache(1 << 20),
+		"disk":      disk,
+		"redis":     NewRedisCache(fakeCacheServer(t, serveFakeRedis)),
+		"memcached": NewMemcachedCache(fakeCacheServer(t, serveFakeMemcached)),
+	}
+	for name, cache := range caches {
+		t.Run(name, func(t *testing.T) {
+			if _, err := cache.Get("blob/missing"); !errors.Is(err, ErrCacheMiss) {
+				t.Fatalf("Get() of a missing key returned %v, expected ErrCacheMiss", err)
+			}
+
+			values := map[string]string{
+				"blob/557db03de997c86a4a028e1ebd3a1ceb225be238": "Hello World\n",
+				"blob/binary":                         "\x00\r\nEND\r\n\xff",
+				"tree/a path with spaces":             "[]",
+				"tree/" + strings.Repeat("long/", 60): "long key",
+			}
+			for key, value := range values {
+				if err := cache.Set(key, []byte(value)); err != nil {
+					t.Fatalf("Set(%s) failed: %v", key, err)
+				}
+			}
+			for key, value := range values {
+				if cached, err := cache.Get(key); err != nil || string(cached) != value {
+					t.Fatalf("Get(%s) = %q, %v, expected %q", key, cached, err, value)
+				}
+			}
+
+			if err := cache.Flush("tree"); err != nil {
+				t.Fatalf("Flush(tree) failed: %v", err)
+			}
+			if _, err := cache.Get("tree/a path with spaces"); !errors.Is(err, ErrCacheMiss) {
+				t.Fatalf("Get() after Flush(tree) returned %v, expected ErrCacheMiss", err)
+			}
+			// memcached can only flush everything.
+			if _, err := cache.Get("blob/binary"); err != nil && name != "memcached" {
+				t.Fatalf("Flush(tree) dropped a blob: %v", err)
+			}
+			if err := cache.Flush(""); err != nil {
+				t.Fatalf("Flush() failed: %v", err)
+			}
+			for key := range values {
+				if _, err := cache.Get(key); !errors.Is(err, ErrCacheMiss) {
+					t.Fatalf("Get(%s) after Flush() returned %v, expected ErrCacheMiss", key, err)
+				}
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		address := listener.Addr().String()
+		listener.Close()
+		if _, err := NewRedisCache(address).Get("blob/missing"); err == nil || errors.Is(err, ErrCacheMiss) {
+			t.Fatalf("Get() from a server that isn't running returned %v", err)
+		}
+	})
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	cache := NewMemoryCache(10)
+	_ = cache.Set("a", []byte("aaaa"))
+	_ = cache.Set("b", []byte("bbbb"))
+	// Using a makes b the least recently used.
+	if _, err := cache.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+	_ = cache.Set("c", []byte("cccc"))
+	if _, err := cache.Get("b"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("b should have been evicted, Get() returned %v", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, err := cache.Get(key); err != nil {
+			t.Fatalf("%s should still be cached: %v", key, err)
+		}
+	}
+	_ = cache.Set("huge", []byte("too big to ever fit"))
+	if _, err := cache.Get("a"); err != nil {
+		t.Fatal("a value larger than the cache evicted everything else")
+	}
+}
+
+func TestManagedCache(t *testing.T) {
+	cache := NewManagedCache(NewMemoryCache(8))
+	_ = cache.Set("blob/a", []byte("aaaa"))
+	_ = cache.Set("tree/b", []byte("bbbb"))
+	_, _ = cache.Get("blob/a")
+	_, _ = cache.Get("blob/missing")
+	// Pushes out tree/b, the least recently used.
+	_ = cache.Set("size/c", []byte("cccc"))
+
+	expected := CacheStats{Hits: 1, Misses: 1, Evictions: 1}
+	if stats := cache.Stats(); stats != expected {
+		t.Fatalf("Stats() = %+v, expected %+v", stats, expected)
+	}
+
+	if err := cache.FlushScope(CacheScopeBlobs); err != nil {
+		t.Fatalf("FlushScope(blobs) failed: %v", err)
+	}
+	for _, key := range []string{"blob/a", "size/c"} {
+		if _, err := cache.Get(key); !errors.Is(err, ErrCacheMiss) {
+			t.Fatalf("%s survived flushing blobs: %v", key, err)
+		}
+	}
+	if stats := cache.Stats(); stats.Flushes != 1 || stats.Misses != 3 {
+		t.Fatalf("Stats() = %+v after flushing", stats)
+	}
+
+	for _, name := range []string{"blobs", "trees", "all"} {
+		if scope, err := ParseCacheScope(name); err != nil || scope.String() != name {
+			t.Fatalf("ParseCacheScope(%s) = %s, %v", name, scope, err)
+		}
+	}
+	if _, err := ParseCacheScope("refs"); err == nil {
+		t.Fatal("ParseCacheScope() accepted an unknown scope")
+	}
+}
+
+func TestParseCache(t *testing.T) {
+	dir := t.TempDir()
+	valid := []string{"", "memory", "memory:1024", "disk:" + dir, "redis://localhost:6379", "memcached://localhost:11211"}
+	for _, spec := range valid {
+		if _, err := ParseCache(spec); err != nil {
+			t.Fatalf("ParseCache(%s) failed: %v", spec, err)
+		}
+	}
+	if cache, _ := ParseCache(""); cache != nil {
+		t.Fatal("ParseCache() of an empty spec should be no cache")
+	}
+	for _, spec := range []string{"memory:0", "memory:lots", "s3://bucket"} {
+		if _, err := ParseCache(spec); err == nil {
+			t.Fatalf("ParseCache(%s) accepted an invalid spec", spec)
+		}
+	}
+}
+
+func TestCliGitCache(t *testing.T) {
+	tmp := t.TempDir()
+	repository, err := runPlaybook("base", tmp)
+	if err != nil {
+		t.Fatalf("playbook 'base' failed: %v", err)
+	}
+	cache := NewMemoryCache(1 << 20)
+	count := func(record string) int {
+		recorded, _ := os.ReadFile(record)
+		return strings.Count(string(recorded), "\n")
+	}
+
+	t.Run("blobs", func(t *testing.T) {
+		wrapper, reads := recordingGit(t, "cat-file", 0)
+		git, err := NewCliGit(repository, WithGitExecutable(wrapper), WithCache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		const realTxt = "557db03de997c86a4a028e1ebd3a1ceb225be238"
+		for i := 0; i < 2; i++ {
+			if contents, err := git.ReadBlob(realTxt); err != nil || string(contents) != "Hello World\n" {
+				t.Fatalf("ReadBlob() = %q, %v", contents, err)
+			}
+			if size, err := git.BlobSize(realTxt); err != nil || size != 12 {
+				t.Fatalf("BlobSize() = %d, %v", size, err)
+			}
+		}
+		if runs := count(reads); runs != 2 {
+			t.Fatalf("reading a blob and its size twice ran cat-file %d times, expected 2", runs)
+		}
+
+		// Another client sharing the cache doesn't need git at all.
+		wrapper, reads = recordingGit(t, "cat-file", 0)
+		other, err := NewCliGit(repository, WithGitExecutable(wrapper), WithCache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.ReadBlob(realTxt); err != nil {
+			t.Fatal(err)
+		}
+		if runs := count(reads); runs != 0 {
+			t.Fatalf("a blob in the shared cache ran cat-file %d times", runs)
+		}
+	})
+
+	t.Run("network cache", func(t *testing.T) {
+		network := NewRedisCache(fakeCacheServer(t, serveFakeRedis))
+		git, err := NewCliGit(repository, WithCache(NewManagedCache(network)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		const realTxt = "557db03de997c86a4a028e1ebd3a1ceb225be238"
+		if err := network.Set("blob/"+realTxt, []byte("Goodbye World\n")); err != nil {
+			t.Fatal(err)
+		}
+		if err := network.Set("size/"+realTxt, []byte("14")); err != nil {
+			t.Fatal(err)
+		}
+		if contents, err := git.ReadBlob(realTxt); err != nil || string(contents) != "Hello World\n" {
+			t.Fatalf("ReadBlob() = %q, %v, expected the tampered cache entry to be ignored", contents, err)
+		}
+		if size, err := git.BlobSize(realTxt); err != nil || size != 12 {
+			t.Fatalf("BlobSize() = %d, %v, expected the size in the network cache to be ignored", size, err)
+		}
+		if cached, err := network.Get("blob/" + realTxt); err != nil || string(cached) != "Hello World\n" {
+			t.Fatalf("the network cache holds %q, %v, expected the blob read from git", cached, err)
+		}
+
+		commit, err := git.ResolveCommit(BranchRef(BranchMaster))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tampered, _ := json.Marshal([]gitism.TreeEntry{{
+			Mode:   gitism.FileMode{Type: gitism.RegularFile, Perms: 0644},
+			Object: gitism.BlobObject,
+			Hash:   realTxt,
+			Size:   "14",
+			Path:   "test/evil.txt",
+		}})
+		for _, kind := range []string{"tree", "directory"} {
+			if err := network.Set(listingCacheKey(kind, GitPath{Reference: CommitRef(commit), TreePath: "test"}), tampered); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var names []string
+		err = git.ListDirectory(GitPath{Reference: CommitRef(commit), TreePath: "test"}, func(entry gitism.TreeEntry) error {
+			names = append(names, entry.Path)
+			return nil
+		})
+		if err != nil || len(names) != 3 {
+			t.Fatalf("ListDirectory(test) = %v, %v, expected the tampered listing in the network cache to be ignored", names, err)
+		}
+	})
+
+	t.Run("listings", func(t *testing.T) {
+		wrapper, listings := recordingGit(t, "ls-tree", 0)
+		git, err := NewCliGit(repository, WithGitExecutable(wrapper), WithCache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit, err := git.ResolveCommit(BranchRef(BranchMaster))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		list := func(ref Ref) []string {
+			var names []string
+			err := git.ListDirectory(GitPath{Reference: ref, TreePath: "test"}, func(entry gitism.TreeEntry) error {
+				names = append(names, entry.Path)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("ListDirectory(%s) failed: %v", ref, err)
+			}
+			return names
+		}
+		first := list(CommitRef(commit))
+		if second := list(CommitRef(commit)); !cmp.Equal(first, second) || len(first) != 3 {
+			t.Fatalf("the cached listing %v differs from the listing %v", second, first)
+		}
+		if runs := count(listings); runs != 1 {
+			t.Fatalf("listing a commit twice ran ls-tree %d times, expected 1", runs)
+		}
+
+		// Branches move, so their listings are never cached.
+		list(BranchRef(BranchMaster))
+		list(BranchRef(BranchMaster))
+		if runs := count(listings); runs != 3 {
+			t.Fatalf("listing a branch twice ran ls-tree %d more times, expected 2", runs-1)
+		}
+	})
+}
@@ -0,0 +1,370 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// cacheTimeout bounds every request to a network cache. A slow cache is treated as a broken one.
+	cacheTimeout = 2 * time.Second
+	// maxIdleCacheConnections is how many connections to a network cache are kept open between requests.
+	maxIdleCacheConnections = 8
+	// maxMemcachedKey is the longest key memcached accepts.
+	maxMemcachedKey = 250
+	// maxMemcachedValue is the largest value memcached stores by default, leaving room for its item header.
+	maxMemcachedValue = 1<<20 - 512
+	// maxRedisValue is the largest value redis stores by default.
+	maxRedisValue = 512 << 20
+)
+
+var errCacheProtocol = errors.New("unexpected reply from cache")
+
+// cacheConn is a connection to a network cache with a buffered reader for its replies.
+type cacheConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// readLine reads a reply line without its trailing \r\n.
+func (c *cacheConn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// readValue reads a value of length bytes followed by \r\n.
+func (c *cacheConn) readValue(length int) ([]byte, error) {
+	value := make([]byte, length+2)
+	if _, err := io.ReadFull(c.reader, value); err != nil {
+		return nil, err
+	}
+	return value[:length], nil
+}
+
+// cachePool reuses connections to the cache at address.
+type cachePool struct {
+	address string
+	idle    chan *cacheConn
+}
+
+func newCachePool(address string) *cachePool {
+	return &cachePool{address: address, idle: make(chan *cacheConn, maxIdleCacheConnections)}
+}
+
+// do runs request on an idle connection, or a new one if there are none. Connections a request fails on, other than
+// with ErrCacheMiss, are closed since what is left to read from them is unknown.
+func (p *cachePool) do(request func(conn *cacheConn) error) error {
+	var conn *cacheConn
+	select {
+	case conn = <-p.idle:
+	default:
+		c, err := net.DialTimeout("tcp", p.address, cacheTimeout)
+		if err != nil {
+			return err
+		}
+		conn = &cacheConn{Conn: c, reader: bufio.NewReader(c)}
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(cacheTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	err := request(conn)
+	if err != nil && !errors.Is(err, ErrCacheMiss) {
+		conn.Close()
+		return err
+	}
+	select {
+	case p.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return err
+}
+
+// isNetworkCache reports if cache stores its values in redis or memcached, where anyone who can reach the server can
+// change them.
+func isNetworkCache(cache Cache) bool {
+	if managed, ok := cache.(*ManagedCache); ok {
+		cache = managed.Cache
+	}
+	switch cache.(type) {
+	case redisCache, memcachedCache:
+		return true
+	default:
+		return false
+	}
+}
+
+// redisKeyPrefix keeps the keys of gitfs apart from anything else stored in the same redis database.
+const redisKeyPrefix = "gitfs/"
+
+// redisCache stores values in redis.
+type redisCache struct {
+	pool *cachePool
+}
+
+// NewRedisCache caches values in the redis server at address, like localhost:6379. How long values are kept is up to
+// the server's eviction policy.
+func NewRedisCache(address string) Cache {
+	return redisCache{pool: newCachePool(address)}
+}
+
+// writeRedisCommand sends args as a redis command.
+func writeRedisCommand(w io.Writer, args ...[]byte) error {
+	command := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		command = append(command, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		command = append(command, arg...)
+		command = append(command, "\r\n"...)
+	}
+	_, err := w.Write(command)
+	return err
+}
+
+// readRedisReply reads a reply to a command: a string for simple strings, []byte for bulk strings, int64 for
+// integers, []interface{} for arrays, and nil for nulls. Error replies are returned as errors.
+func readRedisReply(conn *cacheConn) (interface{}, error) {
+	line, err := conn.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errCacheProtocol
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errCacheProtocol
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		return conn.readValue(length)
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errCacheProtocol
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readRedisReply(conn); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, errCacheProtocol
+	}
+}
+
+// command runs args and returns the reply.
+func (c redisCache) command(args ...[]byte) (interface{}, error) {
+	var reply interface{}
+	err := c.pool.do(func(conn *cacheConn) error {
+		if err := writeRedisCommand(conn, args...); err != nil {
+			return err
+		}
+		var err error
+		reply, err = readRedisReply(conn)
+		return err
+	})
+	return reply, err
+}
+
+func (c redisCache) Get(key string) ([]byte, error) {
+	reply, err := c.command([]byte("GET"), []byte(redisKeyPrefix+key))
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrCacheMiss
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, errCacheProtocol
+	}
+	return value, nil
+}
+
+func (c redisCache) Set(key string, value []byte) error {
+	if len(value) > maxRedisValue {
+		return nil
+	}
+	reply, err := c.command([]byte("SET"), []byte(redisKeyPrefix+key), value)
+	if err != nil {
+		return err
+	}
+	if reply != "OK" {
+		return errCacheProtocol
+	}
+	return nil
+}
+
+// Flush deletes the keys of namespace a batch at a time, so other clients aren't blocked for long.
+func (c redisCache) Flush(namespace string) error {
+	pattern := redisKeyPrefix + "*"
+	if namespace != "" {
+		pattern = redisKeyPrefix + namespace + "/*"
+	}
+	cursor := []byte("0")
+	for {
+		reply, err := c.command([]byte("SCAN"), cursor, []byte("MATCH"), []byte(pattern), []byte("COUNT"), []byte("1000"))
+		if err != nil {
+			return err
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return errCacheProtocol
+		}
+		next, ok := page[0].([]byte)
+		keys, ok2 := page[1].([]interface{})
+		if !ok || !ok2 {
+			return errCacheProtocol
+		}
+		if len(keys) > 0 {
+			del := [][]byte{[]byte("DEL")}
+			for _, key := range keys {
+				key, ok := key.([]byte)
+				if !ok {
+					return errCacheProtocol
+				}
+				del = append(del, key)
+			}
+			if _, err := c.command(del...); err != nil {
+				return err
+			}
+		}
+		if string(next) == "0" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// memcachedCache stores values in memcached.
+type memcachedCache struct {
+	pool *cachePool
+}
+
+// NewMemcachedCache caches values in the memcached server at address, like localhost:11211. Values larger than
+// memcached's default item size aren't cached, and flushing any namespace flushes the whole server.
+func NewMemcachedCache(address string) Cache {
+	return memcachedCache{pool: newCachePool(address)}
+}
+
+// memcachedKey is key if memcached accepts it as is and otherwise its hash, since keys can't be long or hold spaces.
+func memcachedKey(key string) string {
+	if len(key) <= maxMemcachedKey && !strings.ContainsAny(key, " \t\r\n\x00") {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "sha256/" + hex.EncodeToString(sum[:])
+}
+
+func (c memcachedCache) Get(key string) ([]byte, error) {
+	key = memcachedKey(key)
+	var value []byte
+	err := c.pool.do(func(conn *cacheConn) error {
+		if _, err := io.WriteString(conn, "get "+key+"\r\n"); err != nil {
+			return err
+		}
+		reply, err := conn.readLine()
+		if err != nil {
+			return err
+		}
+		if reply == "END" {
+			return ErrCacheMiss
+		}
+		// VALUE <key> <flags> <bytes>
+		fields := strings.Fields(reply)
+		if len(fields) != 4 || fields[0] != "VALUE" {
+			return fmt.Errorf("memcached: %s", reply)
+		}
+		length, err := strconv.Atoi(fields[3])
+		if err != nil || length < 0 {
+			return errCacheProtocol
+		}
+		if value, err = conn.readValue(length); err != nil {
+			return err
+		}
+		if end, err := conn.readLine(); err != nil || end != "END" {
+			return errCacheProtocol
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (c memcachedCache) Set(key string, value []byte) error {
+	if len(value) > maxMemcachedValue {
+		return nil
+	}
+	key = memcachedKey(key)
+	return c.pool.do(func(conn *cacheConn) error {
+		command := append([]byte(fmt.Sprintf("set %s 0 0 %d\r\n", key, len(value))), value...)
+		if _, err := conn.Write(append(command, "\r\n"...)); err != nil {
+			return err
+		}
+		reply, err := conn.readLine()
+		if err != nil {
+			return err
+		}
+		if reply != "STORED" {
+			return fmt.Errorf("memcached: %s", reply)
+		}
+		return nil
+	})
+}
+
+// Flush drops every value, since memcached can't list or delete keys by prefix.
+func (c memcachedCache) Flush(namespace string) error {
+	return c.pool.do(func(conn *cacheConn) error {
+		if _, err := io.WriteString(conn, "flush_all\r\n"); err != nil {
+			return err
+		}
+		reply, err := conn.readLine()
+		if err != nil {
+			return err
+		}
+		if reply != "OK" {
+			return fmt.Errorf("memcached: %s", reply)
+		}
+		return nil
+	})
+}
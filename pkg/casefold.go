@@ -0,0 +1,97 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"os"
+	"strings"
+)
+
+// caseInsensitiveFileSystem looks paths up ignoring capitalization for clients, like those from Windows and macOS,
+// that expect it. Paths that exist exactly as written are never searched for.
+type caseInsensitiveFileSystem struct {
+	billy.Filesystem
+}
+
+// resolve finds the committed path filename refers to. A name matching exactly is preferred over one that only
+// matches when ignoring case, and the first in the listing wins between those. Paths that don't match anything are
+// returned as they were for the underlying filesystem to reject.
+func (s caseInsensitiveFileSystem) resolve(filename string) string {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil || resolved.IsRoot() {
+		return filename
+	}
+	if _, err := s.Filesystem.Lstat(resolved.String()); err == nil {
+		return filename
+	}
+
+	matched := RootGitPath()
+	for _, part := range resolved.Path {
+		names, err := ReadDirNames(s.Filesystem, matched.String())
+		if err != nil {
+			return filename
+		}
+		found := ""
+		for _, name := range names {
+			if name == part {
+				found = name
+				break
+			}
+			if found == "" && strings.EqualFold(name, part) {
+				found = name
+			}
+		}
+		if found == "" {
+			return filename
+		}
+		matched = FilePath{Path: append(matched.Path, found)}
+	}
+	return matched.String()
+}
+
+func (s caseInsensitiveFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s caseInsensitiveFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	return s.Filesystem.OpenFile(s.resolve(filename), flag, perm)
+}
+
+func (s caseInsensitiveFileSystem) Stat(filename string) (os.FileInfo, error) {
+	return s.Filesystem.Stat(s.resolve(filename))
+}
+
+func (s caseInsensitiveFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	return s.Filesystem.Lstat(s.resolve(filename))
+}
+
+func (s caseInsensitiveFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	return s.Filesystem.ReadDir(s.resolve(path))
+}
+
+func (s caseInsensitiveFileSystem) Readlink(link string) (string, error) {
+	return s.Filesystem.Readlink(s.resolve(link))
+}
+
+// Chroot keeps ignoring case in the new root.
+func (s caseInsensitiveFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	fs, err := s.Filesystem.Chroot(s.resolve(path))
+	if err != nil {
+		return nil, err
+	}
+	return caseInsensitiveFileSystem{Filesystem: fs}, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gravypod/gitfs/pkg/gitism"
+)
+
+const (
+	// ChangelogFile is the name of the file in IntrospectionDirectory listing the commits made since the ref last
+	// moved.
+	ChangelogFile = "CHANGELOG.txt"
+	// maxChangelogCommits bounds how many commits the changelog lists, since each is described with its own command.
+	maxChangelogCommits = 100
+)
+
+// errChangelogDone stops walking history once the changelog reached the previous commit or filled up.
+var errChangelogDone = errors.New("changelog is complete")
+
+// readChangelog generates .gitfs/CHANGELOG.txt, the first-parent history between the commit the ref resolved to
+// before it last moved and the one it resolves to now, so consumers can tell what changed across a refresh without
+// running git. The first line is previous..current, followed by the full hash and subject of each commit, newest
+// first, like git log --first-parent --oneline previous..current. It is empty until the ref moved while mounted.
+func (s introspectionFileSystem) readChangelog() ([]byte, error) {
+	commit, err := s.git.ResolveCommit(s.reference)
+	if err != nil {
+		return nil, err
+	}
+	_, previous := observeEpoch(s.repository, s.reference, commit)
+	if previous == "" {
+		return nil, nil
+	}
+
+	var changelog bytes.Buffer
+	fmt.Fprintf(&changelog, "%s..%s\n", previous, commit)
+	// The previous commit may have been named by a RefResolver, or collected since.
+	previous, err = s.git.ResolveCommit(CommitRef(previous))
+	if err != nil {
+		changelog.WriteString("The previous commit is no longer in the repository.\n")
+		return changelog.Bytes(), nil
+	}
+
+	var commits []string
+	reached := false
+	err = s.git.WalkCommits(CommitRef(commit), true, func(walked gitism.GraphCommit) error {
+		if walked.Hash == previous {
+			reached = true
+			return errChangelogDone
+		}
+		if len(commits) == maxChangelogCommits {
+			return errChangelogDone
+		}
+		commits = append(commits, walked.Hash)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errChangelogDone) {
+		return nil, err
+	}
+
+	for _, hash := range commits {
+		info, err := s.git.LastCommit(hash, "")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&changelog, "%s %s\n", hash, commitSubject(info.Message))
+	}
+	switch {
+	case reached:
+	case len(commits) == maxChangelogCommits:
+		fmt.Fprintf(&changelog, "Older commits were left out after the first %d.\n", maxChangelogCommits)
+	default:
+		changelog.WriteString("The previous commit is not a first parent of the current one, so history was rewritten.\n")
+	}
+	return changelog.Bytes(), nil
+}
@@ -0,0 +1,408 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChunkConvention recognizes the chunks a large file was split into so NewChunkedFileSystem can put it back together.
+type ChunkConvention interface {
+	// Chunk reports whether name, the name of a file in a directory, is a chunk. If it is, file is the name of the
+	// file it was split from, in the same directory, and index is its position among that file's chunks.
+	Chunk(name string) (file string, index int, ok bool)
+}
+
+// splitChunks is the convention of split -d and most archivers: chunks are named after their file with separator and
+// their number appended, like video.mp4.000, video.mp4.001, and so on.
+type splitChunks struct {
+	separator string
+}
+
+// NewSplitChunkConvention recognizes chunks named <file><separator><n>, numbered from 0 or 1.
+func NewSplitChunkConvention(separator string) ChunkConvention {
+	return splitChunks{separator: separator}
+}
+
+func (c splitChunks) Chunk(name string) (file string, index int, ok bool) {
+	split := strings.LastIndex(name, c.separator)
+	if split <= 0 {
+		return "", 0, false
+	}
+	number := name[split+len(c.separator):]
+	for _, r := range number {
+		if r < '0' || r > '9' {
+			return "", 0, false
+		}
+	}
+	index, err := strconv.Atoi(number)
+	if err != nil {
+		return "", 0, false
+	}
+	return name[:split], index, true
+}
+
+// fileChunk is a chunk of a file put back together.
+type fileChunk struct {
+	name string
+	// offset is where the chunk starts in the file it was split from.
+	offset int64
+	size   int64
+}
+
+// chunkedInfo describes a file put back together from chunks. It takes its mode and time from the first chunk.
+type chunkedInfo struct {
+	name    string
+	mode    os.FileMode
+	size    int64
+	modTime time.Time
+	chunks  []fileChunk
+	// first is the number of the first chunk.
+	first int
+}
+
+func (i chunkedInfo) Name() string {
+	return i.name
+}
+
+func (i chunkedInfo) Size() int64 {
+	return i.size
+}
+
+func (i chunkedInfo) Mode() fs.FileMode {
+	return i.mode
+}
+
+func (i chunkedInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i chunkedInfo) IsDir() bool {
+	return false
+}
+
+func (i chunkedInfo) Sys() interface{} {
+	return nil
+}
+
+// assembleChunks finds the files split into chunks among files, the listing of dirname. Chunks only make up a file if
+// they are regular files numbered one after the other from 0 or 1, and nothing in files already has the file's name.
+func assembleChunks(convention ChunkConvention, dirname string, files []os.FileInfo) map[string]*chunkedInfo {
+	type numbered struct {
+		info  os.FileInfo
+		index int
+	}
+	names := make(map[string]bool, len(files))
+	candidates := make(map[string][]numbered)
+	for _, file := range files {
+		names[file.Name()] = true
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		if name, index, ok := convention.Chunk(file.Name()); ok {
+			candidates[name] = append(candidates[name], numbered{info: file, index: index})
+		}
+	}
+
+	assembled := make(map[string]*chunkedInfo)
+	for name, chunks := range candidates {
+		if names[name] {
+			continue
+		}
+		sort.Slice(chunks, func(i, j int) bool {
+			return chunks[i].index < chunks[j].index
+		})
+		first := chunks[0].index
+		if first > 1 {
+			continue
+		}
+		info := &chunkedInfo{
+			name:    name,
+			mode:    chunks[0].info.Mode(),
+			modTime: chunks[0].info.ModTime(),
+			first:   first,
+		}
+		for i, chunk := range chunks {
+			if chunk.index != first+i {
+				info = nil
+				break
+			}
+			info.chunks = append(info.chunks, fileChunk{
+				name:   path.Join(dirname, chunk.info.Name()),
+				offset: info.size,
+				size:   chunk.info.Size(),
+			})
+			info.size += chunk.info.Size()
+		}
+		if info != nil {
+			assembled[name] = info
+		}
+	}
+	return assembled
+}
+
+// chunkedFile reads a file put back together from chunks, only opening the chunks that are read from.
+type chunkedFile struct {
+	fs   billy.Filesystem
+	name string
+	info *chunkedInfo
+
+	mu     sync.Mutex
+	offset int64
+	// opened is the index of the chunk held open in file, which is only swapped for another once reads move past it.
+	opened int
+	file   billy.File
+}
+
+func (f *chunkedFile) Name() string {
+	return filepath.Base(f.name)
+}
+
+func (f *chunkedFile) Write(p []byte) (n int, err error) {
+	_ = p
+	return 0, billy.ErrNotSupported
+}
+
+func (f *chunkedFile) Read(p []byte) (n int, err error) {
+	f.mu.Lock()
+	offset := f.offset
+	f.mu.Unlock()
+	n, err = f.ReadAt(p, offset)
+	f.mu.Lock()
+	f.offset = offset + int64(n)
+	f.mu.Unlock()
+	return n, err
+}
+
+// chunk opens the chunk at index, closing the one opened before it.
+func (f *chunkedFile) chunk(index int) (billy.File, error) {
+	if f.file != nil && f.opened == index {
+		return f.file, nil
+	}
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	file, err := f.fs.Open(f.info.chunks[index].name)
+	if err != nil {
+		return nil, err
+	}
+	f.file, f.opened = file, index
+	return file, nil
+}
+
+func (f *chunkedFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	chunks := f.info.chunks
+	index := sort.Search(len(chunks), func(i int) bool {
+		return chunks[i].offset+chunks[i].size > off
+	})
+	for ; n < len(p) && index < len(chunks); index++ {
+		chunk := chunks[index]
+		within := off + int64(n) - chunk.offset
+		buffer := p[n:]
+		if remaining := chunk.size - within; int64(len(buffer)) > remaining {
+			buffer = buffer[:remaining]
+		}
+		file, err := f.chunk(index)
+		if err != nil {
+			return n, err
+		}
+		read, err := file.ReadAt(buffer, within)
+		n += read
+		if read < len(buffer) {
+			if err == nil || err == io.EOF {
+				// The chunk is shorter than it was when the file was listed.
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *chunkedFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	default:
+		return 0, fs.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *chunkedFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *chunkedFile) Lock() error {
+	return billy.ErrNotSupported
+}
+
+func (f *chunkedFile) Unlock() error {
+	return billy.ErrNotSupported
+}
+
+func (f *chunkedFile) Truncate(size int64) error {
+	_ = size
+	return billy.ErrNotSupported
+}
+
+// chunkedFileSystem presents files that were split into chunks to fit in git as the single file they were split from.
+// Listings show the file in place of its chunks, which can still be opened by name.
+type chunkedFileSystem struct {
+	billy.Filesystem
+	convention ChunkConvention
+}
+
+// NewChunkedFileSystem puts the files of fs that were split into chunks, as convention recognizes them, back together.
+// Reads only open the chunks they overlap, so a file too large to be read from git in one piece can still be read a
+// piece at a time. Files of fs always win over a file put together from chunks of the same name. A nil convention
+// returns fs.
+func NewChunkedFileSystem(fs billy.Filesystem, convention ChunkConvention) billy.Filesystem {
+	if convention == nil {
+		return fs
+	}
+	return chunkedFileSystem{
+		Filesystem: fs,
+		convention: convention,
+	}
+}
+
+// lookup finds the file put together from chunks at filename. ok is false if there is none, including when fs has a
+// file there itself.
+func (s chunkedFileSystem) lookup(filename string) (info *chunkedInfo, ok bool, err error) {
+	if _, err := s.Filesystem.Lstat(filename); !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	name := path.Base(filename)
+	dirname := path.Dir(filename)
+	files, err := s.Filesystem.ReadDir(dirname)
+	if err != nil {
+		// The parent missing or not being a directory is the same as filename not existing.
+		return nil, false, nil
+	}
+	info, ok = assembleChunks(s.convention, dirname, files)[name]
+	return info, ok, nil
+}
+
+func (s chunkedFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s chunkedFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	info, ok, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+	if flag != os.O_RDONLY {
+		return nil, billy.ErrReadOnly
+	}
+	return &chunkedFile{fs: s.Filesystem, name: filename, info: info}, nil
+}
+
+func (s chunkedFileSystem) Stat(filename string) (os.FileInfo, error) {
+	info, ok, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.Filesystem.Stat(filename)
+	}
+	return *info, nil
+}
+
+func (s chunkedFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	info, ok, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.Filesystem.Lstat(filename)
+	}
+	return *info, nil
+}
+
+func (s chunkedFileSystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	files, err := s.Filesystem.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	assembled := assembleChunks(s.convention, dirname, files)
+	if len(assembled) == 0 {
+		return files, nil
+	}
+
+	// Each file takes the place of its first chunk.
+	listing := make([]os.FileInfo, 0, len(files))
+	for _, file := range files {
+		name, index, isChunk := s.convention.Chunk(file.Name())
+		info, chunked := assembled[name]
+		if !isChunk || !chunked || !file.Mode().IsRegular() {
+			listing = append(listing, file)
+			continue
+		}
+		if index == info.first {
+			listing = append(listing, *info)
+		}
+	}
+	return listing, nil
+}
+
+// Chroot keeps putting chunks back together in the new root.
+func (s chunkedFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	fs, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewChunkedFileSystem(fs, s.convention), nil
+}
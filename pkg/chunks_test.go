@@ -0,0 +1,136 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"testing"
+)
+
+func TestChunkedFileSystem(t *testing.T) {
+	git := NewFakeGit(nil)
+	git.Commit("master", "Add chunks", map[string]FakeFile{
+		"video.mp4.000": {Contents: "0123"},
+		"video.mp4.001": {Contents: "4567"},
+		"video.mp4.002": {Contents: "89"},
+		"notes.txt":     {Contents: "notes"},
+		"gap.bin.1":     {Contents: "a"},
+		"gap.bin.3":     {Contents: "c"},
+		"both.txt":      {Contents: "real"},
+		"both.txt.1":    {Contents: "chunk"},
+		"dir/one.1":     {Contents: "one"},
+	})
+	fs := NewChunkedFileSystem(NewReferenceFileSystem(git, BranchRef("master")), NewSplitChunkConvention("."))
+
+	read := func(t *testing.T, filename string) string {
+		file, err := fs.Open(filename)
+		if err != nil {
+			t.Fatalf("Open(%s) failed: %v", filename, err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", filename, err)
+		}
+		return string(contents)
+	}
+
+	t.Run("listing", func(t *testing.T) {
+		files, err := fs.ReadDir("")
+		if err != nil {
+			t.Fatalf("ReadDir() failed: %v", err)
+		}
+		got := make(map[string]int64)
+		for _, file := range files {
+			got[file.Name()] = file.Size()
+		}
+		want := map[string]int64{
+			"video.mp4":  10,
+			"notes.txt":  5,
+			"gap.bin.1":  1,
+			"gap.bin.3":  1,
+			"both.txt":   4,
+			"both.txt.1": 5,
+			"dir":        0,
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("ReadDir() returned the wrong files (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		if got := read(t, "video.mp4"); got != "0123456789" {
+			t.Fatalf("video.mp4 contained %q", got)
+		}
+		if got := read(t, "dir/one"); got != "one" {
+			t.Fatalf("dir/one contained %q", got)
+		}
+		if got := read(t, "both.txt"); got != "real" {
+			t.Fatalf("both.txt contained %q, want the file committed under that name", got)
+		}
+		if got := read(t, "video.mp4.001"); got != "4567" {
+			t.Fatalf("video.mp4.001 contained %q", got)
+		}
+
+		info, err := fs.Stat("video.mp4")
+		if err != nil {
+			t.Fatalf("Stat(video.mp4) failed: %v", err)
+		}
+		if info.Size() != 10 || !info.Mode().IsRegular() {
+			t.Fatalf("Stat(video.mp4) = %d bytes with mode %v", info.Size(), info.Mode())
+		}
+		if _, err := fs.Stat("gap.bin"); err == nil {
+			t.Fatalf("Stat(gap.bin) succeeded for chunks with a gap in their numbers")
+		}
+	})
+
+	t.Run("read at", func(t *testing.T) {
+		file, err := fs.Open("video.mp4")
+		if err != nil {
+			t.Fatalf("Open(video.mp4) failed: %v", err)
+		}
+		defer file.Close()
+
+		buffer := make([]byte, 5)
+		if n, err := file.ReadAt(buffer, 3); err != nil || string(buffer[:n]) != "34567" {
+			t.Fatalf("ReadAt(5 bytes, 3) = %q, %v across chunks", buffer[:n], err)
+		}
+		if n, err := file.ReadAt(buffer, 8); err != io.EOF || string(buffer[:n]) != "89" {
+			t.Fatalf("ReadAt(5 bytes, 8) = %q, %v, want the last chunk and io.EOF", buffer[:n], err)
+		}
+		if n, err := file.ReadAt(buffer, 10); err != io.EOF || n != 0 {
+			t.Fatalf("ReadAt(5 bytes, 10) = %d bytes, %v past the end", n, err)
+		}
+
+		if _, err := file.Seek(-4, io.SeekEnd); err != nil {
+			t.Fatalf("Seek(-4, end) failed: %v", err)
+		}
+		rest, err := io.ReadAll(file)
+		if err != nil || string(rest) != "6789" {
+			t.Fatalf("reading after Seek(-4, end) = %q, %v", rest, err)
+		}
+	})
+
+	t.Run("chroot", func(t *testing.T) {
+		chrooted, err := fs.Chroot("dir")
+		if err != nil {
+			t.Fatalf("Chroot(dir) failed: %v", err)
+		}
+		if _, err := chrooted.Stat("one"); err != nil {
+			t.Fatalf("Stat(one) failed in the chroot: %v", err)
+		}
+	})
+}
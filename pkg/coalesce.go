@@ -0,0 +1,63 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"sync"
+)
+
+// blobRead is a ReadBlob that other callers asking for the same blob are waiting on.
+type blobRead struct {
+	done     chan struct{}
+	contents []byte
+	err      error
+}
+
+// blobReads coalesces concurrent reads of the same blob, so a burst of requests for a cold file runs git once
+// instead of once per request.
+type blobReads struct {
+	mu       sync.Mutex
+	inFlight map[string]*blobRead
+}
+
+func newBlobReads() *blobReads {
+	return &blobReads{inFlight: map[string]*blobRead{}}
+}
+
+// do calls read for hash unless a read for hash is already running, in which case it waits for that one and returns
+// its result instead. Every caller is handed the same slice, so it must not be modified. If read panics, the callers
+// waiting on it get an error and the next caller reads hash again.
+func (r *blobReads) do(hash string, read func() ([]byte, error)) ([]byte, error) {
+	r.mu.Lock()
+	if pending, ok := r.inFlight[hash]; ok {
+		r.mu.Unlock()
+		<-pending.done
+		return pending.contents, pending.err
+	}
+	pending := &blobRead{done: make(chan struct{})}
+	r.inFlight[hash] = pending
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.inFlight, hash)
+		r.mu.Unlock()
+		close(pending.done)
+	}()
+	pending.err = fmt.Errorf("reading blob %s panicked", hash)
+	pending.contents, pending.err = read()
+	return pending.contents, pending.err
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlobReadsPanic(t *testing.T) {
+	reads := newBlobReads()
+	started, release := make(chan struct{}), make(chan struct{})
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		reads.do("aaaa", func() ([]byte, error) {
+			close(started)
+			<-release
+			panic("read failed")
+		})
+	}()
+	<-started
+
+	waited := make(chan error)
+	go func() {
+		_, err := reads.do("aaaa", func() ([]byte, error) {
+			t.Error("a read already in flight should not be repeated")
+			return nil, nil
+		})
+		waited <- err
+	}()
+	// Give the second caller time to start waiting on the first.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	if recovered := <-panicked; recovered == nil {
+		t.Fatal("the panic in read should reach the caller that ran it")
+	}
+	select {
+	case err := <-waited:
+		if err == nil {
+			t.Fatal("a caller waiting on a read that panicked should get an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a caller waiting on a read that panicked never returned")
+	}
+
+	contents, err := reads.do("aaaa", func() ([]byte, error) { return []byte("a"), nil })
+	if err != nil || string(contents) != "a" {
+		t.Fatalf("do() = %q, %v after a read panicked, expected the blob to be read again", contents, err)
+	}
+}
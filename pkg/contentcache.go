@@ -0,0 +1,94 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"io"
+	"log"
+	"os"
+)
+
+// contentCacheFileSystem serves files from a Cache by the hash of their blob, so a file opened again, or another file
+// with the same contents, isn't read from git twice.
+type contentCacheFileSystem struct {
+	billy.Filesystem
+	cache       Cache
+	maxFileSize int64
+}
+
+func newContentCacheFileSystem(fs billy.Filesystem, options CacheOptions) billy.Filesystem {
+	maxFileSize := options.MaxFileSize
+	if maxFileSize <= 0 {
+		maxFileSize = defaultCachedFileSize
+	}
+	return contentCacheFileSystem{
+		Filesystem:  fs,
+		cache:       options.Cache,
+		maxFileSize: maxFileSize,
+	}
+}
+
+func (s contentCacheFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s contentCacheFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag != os.O_RDONLY {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+	info, err := s.Filesystem.Lstat(filename)
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := ObjectHash(info)
+	if !ok || !info.Mode().IsRegular() || info.Size() > s.maxFileSize {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	key := "blob/" + hash
+	contents, err := s.cache.Get(key)
+	if err == nil {
+		return newMemoryFile(filename, contents), nil
+	}
+	if !errors.Is(err, ErrCacheMiss) {
+		log.Printf("failed to read %s from the cache: %v\n", key, err)
+	}
+
+	file, err := s.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	contents, err = io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.cache.Set(key, contents); err != nil {
+		log.Printf("failed to write %s to the cache: %v\n", key, err)
+	}
+	return newMemoryFile(filename, contents), nil
+}
+
+// Chroot keeps using the cache in the new root.
+func (s contentCacheFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	fs, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	s.Filesystem = fs
+	return s, nil
+}
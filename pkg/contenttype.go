@@ -0,0 +1,103 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+)
+
+const (
+	// sniffLength is how much of a file is read to guess its type, which is all http.DetectContentType looks at.
+	sniffLength = 512
+	// maxCachedContentTypes bounds how many sniffed blobs are remembered. The cache is dropped when it fills up.
+	maxCachedContentTypes = 4096
+)
+
+// contentTypes remembers the sniffed type of blobs by hash. Blobs never change so every frontend can share it.
+var contentTypes = &contentTypeCache{types: map[string]string{}}
+
+type contentTypeCache struct {
+	mu    sync.Mutex
+	types map[string]string
+}
+
+func (c *contentTypeCache) get(hash string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	contentType, ok := c.types[hash]
+	return contentType, ok
+}
+
+func (c *contentTypeCache) put(hash, contentType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.types) >= maxCachedContentTypes {
+		c.types = map[string]string{}
+	}
+	c.types[hash] = contentType
+}
+
+// ContentType guesses the MIME type of a file called name from its extension, falling back to sniffing head, the
+// start of its contents. Text types include the charset.
+func ContentType(name string, head []byte) string {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType
+	}
+	if len(head) > sniffLength {
+		head = head[:sniffLength]
+	}
+	return http.DetectContentType(head)
+}
+
+// DetectContentType is ContentType for the file name in fs described by info. The start of the file is only read when
+// its extension isn't known, and what is sniffed from blobs straight from git is cached by their hash. Placeholders
+// for binary files are text whatever their extension says.
+func DetectContentType(fs billy.Filesystem, name string, info os.FileInfo) (string, error) {
+	if _, ok := info.(placeholderInfo); ok {
+		return PlaceholderContentType, nil
+	}
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType, nil
+	}
+	hash, cacheable := ObjectHash(info)
+	if cacheable {
+		if contentType, ok := contentTypes.get(hash); ok {
+			return contentType, nil
+		}
+	}
+
+	file, err := fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	contentType := http.DetectContentType(head[:n])
+	if cacheable {
+		contentTypes.put(hash, contentType)
+	}
+	return contentType, nil
+}
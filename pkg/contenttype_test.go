@@ -0,0 +1,85 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"os"
+	"testing"
+)
+
+// hashedInfo makes a file look like it came straight from git as the blob hash.
+type hashedInfo struct {
+	os.FileInfo
+	hash string
+}
+
+func (i hashedInfo) Sys() interface{} {
+	return &GitFileStat{Hash: i.hash}
+}
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		head     string
+		expected string
+	}{
+		{name: "index.html", head: "", expected: "text/html; charset=utf-8"},
+		{name: "logo.png", head: "not really a png", expected: "image/png"},
+		{name: "Makefile", head: "all:\n\tgo build ./...\n", expected: "text/plain; charset=utf-8"},
+		{name: "image", head: "\x89PNG\r\n\x1a\n", expected: "image/png"},
+		{name: "binary", head: "\x00\x01\x02", expected: "application/octet-stream"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := ContentType(test.name, []byte(test.head)); actual != test.expected {
+				t.Fatalf("ContentType(%s) = %s, expected %s", test.name, actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	fs := memfs.New()
+	if err := util.WriteFile(fs, "LICENSE", []byte("Apache License"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat("LICENSE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual, err := DetectContentType(fs, "LICENSE", info); err != nil || actual != "text/plain; charset=utf-8" {
+		t.Fatalf("DetectContentType(LICENSE) = %s, %v", actual, err)
+	}
+
+	t.Run("cached by blob hash", func(t *testing.T) {
+		hashed := hashedInfo{FileInfo: info, hash: "0123456789abcdef0123456789abcdef01234567"}
+		if _, err := DetectContentType(fs, "LICENSE", hashed); err != nil {
+			t.Fatal(err)
+		}
+		// A blob's contents can't change, so the file isn't sniffed again.
+		if err := util.WriteFile(fs, "LICENSE", []byte("\x89PNG\r\n\x1a\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if actual, err := DetectContentType(fs, "LICENSE", hashed); err != nil || actual != "text/plain; charset=utf-8" {
+			t.Fatalf("DetectContentType(LICENSE) = %s, %v after it was cached", actual, err)
+		}
+		if actual, err := DetectContentType(fs, "LICENSE", info); err != nil || actual != "image/png" {
+			t.Fatalf("DetectContentType(LICENSE) = %s, %v for a file without a hash", actual, err)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// ControlFile is the name of the file in IntrospectionDirectory that NewControlFileSystem takes commands through.
+const ControlFile = "control"
+
+// ErrUnknownControlCommand is returned when something other than a command is written to ControlFile.
+var ErrUnknownControlCommand = fmt.Errorf("unknown control command, expected refresh: %w", syscall.EINVAL)
+
+// controlFileSystem adds /.gitfs/control on top of a filesystem.
+type controlFileSystem struct {
+	billy.Filesystem
+	refresh func() error
+}
+
+// NewControlFileSystem exposes .gitfs/control on top of fs. Writing refresh to it, like with
+// `echo refresh > .gitfs/control`, calls refresh and fails the write with its error, so jobs that just moved the ref
+// can have the mount catch up before reading it. Commands are separated by whitespace and anything else fails the
+// write with ErrUnknownControlCommand. Who may write to it is up to whoever serves fs.
+func NewControlFileSystem(fs billy.Filesystem, refresh func() error) billy.Filesystem {
+	return controlFileSystem{Filesystem: fs, refresh: refresh}
+}
+
+// IsControlFile reports whether filename is /.gitfs/control.
+func IsControlFile(filename string) bool {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	return err == nil && len(resolved.Path) == 2 && resolved.Path[0] == IntrospectionDirectory &&
+		resolved.Path[1] == ControlFile
+}
+
+// introspectionDirectory reports whether filename is /.gitfs/ itself.
+func (s controlFileSystem) introspectionDirectory(filename string) bool {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	return err == nil && len(resolved.Path) == 1 && resolved.Path[0] == IntrospectionDirectory
+}
+
+func (s controlFileSystem) info() os.FileInfo {
+	return introspectionInfo{name: ControlFile, mode: 0222}
+}
+
+// run runs the commands written to the control file.
+func (s controlFileSystem) run(p []byte) error {
+	commands := strings.Fields(string(p))
+	for _, command := range commands {
+		if command != "refresh" {
+			return fmt.Errorf("%w: '%s'", ErrUnknownControlCommand, command)
+		}
+	}
+	for range commands {
+		if err := s.refresh(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s controlFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s controlFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if !IsControlFile(filename) {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+	return controlFile{memoryFile: newMemoryFile(filename, nil), run: s.run}, nil
+}
+
+func (s controlFileSystem) Stat(filename string) (os.FileInfo, error) {
+	if IsControlFile(filename) {
+		return s.info(), nil
+	}
+	return s.statIntrospectionDirectory(filename, s.Filesystem.Stat)
+}
+
+func (s controlFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	if IsControlFile(filename) {
+		return s.info(), nil
+	}
+	return s.statIntrospectionDirectory(filename, s.Filesystem.Lstat)
+}
+
+// statIntrospectionDirectory stats filename with stat, making up /.gitfs/ if nothing else serves it so
+// /.gitfs/control can be reached.
+func (s controlFileSystem) statIntrospectionDirectory(filename string, stat func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	info, err := stat(filename)
+	if errors.Is(err, fs.ErrNotExist) && s.introspectionDirectory(filename) {
+		return introspectionInfo{name: IntrospectionDirectory, mode: os.ModeDir | 0555}, nil
+	}
+	return info, err
+}
+
+func (s controlFileSystem) ReadDir(filename string) ([]os.FileInfo, error) {
+	if IsControlFile(filename) {
+		return nil, ErrNotDirectory
+	}
+	if !s.introspectionDirectory(filename) {
+		return s.Filesystem.ReadDir(filename)
+	}
+	files, err := s.Filesystem.ReadDir(filename)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	files = append(files, s.info())
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+	return files, nil
+}
+
+func (s controlFileSystem) Readlink(link string) (string, error) {
+	if IsControlFile(link) {
+		return "", fs.ErrInvalid
+	}
+	return s.Filesystem.Readlink(link)
+}
+
+func (s controlFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	if IsControlFile(path) {
+		return nil, billy.ErrNotSupported
+	}
+	return s.Filesystem.Chroot(path)
+}
+
+// controlFile runs the commands written to it. Truncating it does nothing so shells can redirect into it.
+type controlFile struct {
+	memoryFile
+	run func(p []byte) error
+}
+
+func (f controlFile) Write(p []byte) (n int, err error) {
+	if err := f.run(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f controlFile) Truncate(size int64) error {
+	_ = size
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jacobsa/fuse/fuseops"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestControlFileSystem(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "base")
+	refreshes := 0
+	filesystem := NewControlFileSystem(NewReferenceFileSystem(git, BranchRef("master")), func() error {
+		refreshes++
+		return nil
+	})
+
+	t.Run("listing", func(t *testing.T) {
+		if info, err := filesystem.Stat(IntrospectionDirectory); err != nil || !info.IsDir() {
+			t.Fatalf("Stat(%s) = %v, %v, expected a directory", IntrospectionDirectory, info, err)
+		}
+		files, err := filesystem.ReadDir(IntrospectionDirectory)
+		if err != nil || len(files) != 1 || files[0].Name() != ControlFile {
+			t.Fatalf("ReadDir(%s) = %v, %v", IntrospectionDirectory, files, err)
+		}
+		if _, err := filesystem.Stat("real.txt"); err != nil {
+			t.Fatalf("Stat(real.txt) failed: %v", err)
+		}
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		before := refreshes
+		if err := util.WriteFile(filesystem, ".gitfs/control", []byte("refresh\n"), 0); err != nil {
+			t.Fatalf("writing refresh failed: %v", err)
+		}
+		if refreshes != before+1 {
+			t.Fatalf("refreshed %d times, expected once", refreshes-before)
+		}
+	})
+
+	t.Run("unknown command", func(t *testing.T) {
+		before := refreshes
+		err := util.WriteFile(filesystem, ".gitfs/control", []byte("refresh\nreboot\n"), 0)
+		if !errors.Is(err, ErrUnknownControlCommand) || !errors.Is(err, syscall.EINVAL) {
+			t.Fatalf("writing an unknown command returned %v, expected ErrUnknownControlCommand", err)
+		}
+		if refreshes != before {
+			t.Fatal("refreshed along with an unknown command")
+		}
+	})
+
+	t.Run("refresh fails", func(t *testing.T) {
+		failing := NewControlFileSystem(NewReferenceFileSystem(git, BranchRef("master")), func() error {
+			return fs.ErrPermission
+		})
+		if err := util.WriteFile(failing, ".gitfs/control", []byte("refresh"), 0); !errors.Is(err, fs.ErrPermission) {
+			t.Fatalf("writing refresh returned %v, expected the error refreshing failed with", err)
+		}
+	})
+}
+
+func TestFuseAuthorizeWrite(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "base")
+	filesystem := NewControlFileSystem(NewReferenceFileSystem(git, BranchRef("master")), func() error { return nil })
+	var authorized []string
+	fileSystem, err := NewBillyFuseWithOptions(filesystem, FuseOptions{
+		AuthorizeWrite: func(uid uint32, path string) error {
+			if uid != uint32(os.Getuid()) {
+				t.Errorf("AuthorizeWrite() was asked about uid %d, expected %d", uid, os.Getuid())
+			}
+			authorized = append(authorized, path)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewBillyFuseWithOptions() failed: %v", err)
+	}
+	f := fileSystem.(*billyFuse)
+	ctx := context.Background()
+
+	directory := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: IntrospectionDirectory}
+	if err := f.LookUpInode(ctx, directory); err != nil {
+		t.Fatalf("LookUpInode(%s) failed: %v", IntrospectionDirectory, err)
+	}
+	control := &fuseops.LookUpInodeOp{Parent: directory.Entry.Child, Name: ControlFile}
+	if err := f.LookUpInode(ctx, control); err != nil {
+		t.Fatalf("LookUpInode(%s) failed: %v", ControlFile, err)
+	}
+	write := &fuseops.WriteFileOp{Inode: control.Entry.Child, Data: []byte("refresh\n")}
+	write.OpContext.Pid = uint32(os.Getpid())
+	if err := f.WriteFile(ctx, write); err != nil {
+		t.Fatalf("WriteFile(%s) failed: %v", ControlFile, err)
+	}
+	if len(authorized) != 1 || !IsControlFile(authorized[0]) {
+		t.Fatalf("AuthorizeWrite() was asked about %v", authorized)
+	}
+
+	f.authorizeWrite = func(uid uint32, path string) error {
+		return &fs.PathError{Op: "write", Path: path, Err: fs.ErrPermission}
+	}
+	if err := f.WriteFile(ctx, write); err != syscall.EACCES {
+		t.Fatalf("WriteFile(%s) returned %v when it wasn't authorized, expected EACCES", ControlFile, err)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errCopyStopped stops the other batches of a copy once one of them fails.
+var errCopyStopped = errors.New("copy stopped")
+
+// CopyStats counts what CopyTree has copied so far out of everything it will copy.
+type CopyStats struct {
+	Files      int
+	TotalFiles int
+	Bytes      int64
+	TotalBytes int64
+}
+
+// CopyOptions customize CopyTree.
+type CopyOptions struct {
+	// Jobs is how many git commands read blobs at once. 0 runs one per CPU.
+	Jobs int
+	// Progress, if set, is called with the running totals after every file is written. It is never called
+	// concurrently.
+	Progress func(stats CopyStats)
+}
+
+// copyBatch is the files one git command reads.
+type copyBatch struct {
+	hashes  []string
+	targets []string
+	entries []gitism.TreeEntry
+	bytes   int64
+}
+
+// CopyTree copies src, a file or directory of ref, into the existing directory dst like cp -r would. Blobs are read
+// in a few large batches running in parallel instead of one at a time, which is much faster than copying through a
+// mount. ref is resolved once, so the copy is of a single commit even if ref moves while it runs. Submodules are
+// copied as empty directories.
+func CopyTree(git Git, ref Ref, src, dst string, options CopyOptions) (CopyStats, error) {
+	commit, err := git.ResolveCommit(ref)
+	if err != nil {
+		return CopyStats{}, err
+	}
+	reference := CommitRef(commit)
+
+	src = path.Clean("/" + src)[1:]
+	base := dst
+	if src != "" {
+		base = filepath.Join(dst, path.Base(src))
+	}
+
+	var stats CopyStats
+	var files []gitism.TreeEntry
+	var targets []string
+	listed := false
+	err = git.ListTreeRecursive(GitPath{Reference: reference, TreePath: src}, func(entry gitism.TreeEntry) error {
+		listed = true
+		relative := entry.Path
+		if src != "" {
+			relative = strings.TrimPrefix(strings.TrimPrefix(entry.Path, src), "/")
+		}
+		target := filepath.Join(base, filepath.FromSlash(relative))
+		if entry.Mode.Type == gitism.Gitlink {
+			return os.MkdirAll(target, 0755)
+		}
+		size, _ := strconv.ParseInt(entry.Size, 10, 64)
+		stats.TotalFiles++
+		stats.TotalBytes += size
+		files = append(files, entry)
+		targets = append(targets, target)
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	if !listed {
+		return stats, &fs.PathError{Op: "copy", Path: src, Err: fs.ErrNotExist}
+	}
+
+	batches := splitCopy(files, targets, options.Jobs)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch *copyBatch) {
+			defer wg.Done()
+			i := 0
+			err := git.ReadBlobs(batch.hashes, func(hash string, contents []byte) error {
+				mu.Lock()
+				stopped := firstErr != nil
+				mu.Unlock()
+				if stopped {
+					return errCopyStopped
+				}
+
+				entry, target := batch.entries[i], batch.targets[i]
+				i++
+				if err := writeCopy(entry, target, contents); err != nil {
+					return err
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				stats.Files++
+				stats.Bytes += int64(len(contents))
+				if options.Progress != nil {
+					options.Progress(stats)
+				}
+				return nil
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil && !errors.Is(err, errCopyStopped) {
+				firstErr = err
+			}
+		}(batch)
+	}
+	wg.Wait()
+	return stats, firstErr
+}
+
+// splitCopy splits files into at most jobs batches of about the same number of bytes.
+func splitCopy(files []gitism.TreeEntry, targets []string, jobs int) []*copyBatch {
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	if jobs > len(files) {
+		jobs = len(files)
+	}
+	batches := make([]*copyBatch, jobs)
+	for i := range batches {
+		batches[i] = new(copyBatch)
+	}
+
+	// Handing out the largest files first to the batch with the fewest bytes keeps one batch from being stuck with
+	// all of the large files.
+	order := make([]int, len(files))
+	sizes := make([]int64, len(files))
+	for i, file := range files {
+		order[i] = i
+		sizes[i], _ = strconv.ParseInt(file.Size, 10, 64)
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return sizes[order[a]] > sizes[order[b]]
+	})
+	for _, i := range order {
+		smallest := batches[0]
+		for _, batch := range batches[1:] {
+			if batch.bytes < smallest.bytes {
+				smallest = batch
+			}
+		}
+		smallest.hashes = append(smallest.hashes, files[i].Hash)
+		smallest.entries = append(smallest.entries, files[i])
+		smallest.targets = append(smallest.targets, targets[i])
+		smallest.bytes += sizes[i]
+	}
+	return batches
+}
+
+// writeCopy writes contents, the blob of entry, to target as a file or a symlink.
+func writeCopy(entry gitism.TreeEntry, target string, contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if entry.Mode.Type == gitism.Symlink {
+		if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return os.Symlink(string(contents), target)
+	}
+	var perm os.FileMode = 0644
+	if entry.Mode.Perms&0111 != 0 {
+		perm = 0755
+	}
+	return os.WriteFile(target, contents, perm)
+}
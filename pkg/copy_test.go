@@ -0,0 +1,119 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyTree(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "base")
+	master := BranchRef("master")
+
+	t.Run("everything", func(t *testing.T) {
+		dst := t.TempDir()
+		var last CopyStats
+		stats, err := CopyTree(git, master, ".", dst, CopyOptions{Jobs: 2, Progress: func(stats CopyStats) {
+			last = stats
+		}})
+		if err != nil {
+			t.Fatalf("CopyTree() failed: %v", err)
+		}
+		if stats.Files != 5 || stats.Files != stats.TotalFiles || stats.Bytes != stats.TotalBytes || last != stats {
+			t.Fatalf("CopyTree() returned %+v and last reported %+v", stats, last)
+		}
+
+		if contents, err := os.ReadFile(filepath.Join(dst, "test", "nested.txt")); err != nil || string(contents) != "Nested file\n" {
+			t.Fatalf("test/nested.txt contained %q, %v", contents, err)
+		}
+		if info, err := os.Stat(filepath.Join(dst, "executable.sh")); err != nil || info.Mode().Perm() != 0755 {
+			t.Fatalf("executable.sh was copied with %v, %v", info.Mode(), err)
+		}
+		if target, err := os.Readlink(filepath.Join(dst, "symlink.txt")); err != nil || target != "real.txt" {
+			t.Fatalf("symlink.txt pointed at %q, %v", target, err)
+		}
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		dst := t.TempDir()
+		if _, err := CopyTree(git, master, "/test/", dst, CopyOptions{}); err != nil {
+			t.Fatalf("CopyTree(test) failed: %v", err)
+		}
+		entries, err := os.ReadDir(dst)
+		if err != nil || len(entries) != 1 || entries[0].Name() != "test" {
+			t.Fatalf("copying test created %v, %v", entries, err)
+		}
+		if _, err := os.Lstat(filepath.Join(dst, "test", "escaping.txt")); err != nil {
+			t.Fatalf("test/escaping.txt wasn't copied: %v", err)
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		dst := t.TempDir()
+		if _, err := CopyTree(git, master, "real.txt", dst, CopyOptions{}); err != nil {
+			t.Fatalf("CopyTree(real.txt) failed: %v", err)
+		}
+		if contents, err := os.ReadFile(filepath.Join(dst, "real.txt")); err != nil || string(contents) != "Hello World\n" {
+			t.Fatalf("real.txt contained %q, %v", contents, err)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := CopyTree(git, master, "missing", t.TempDir(), CopyOptions{}); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("CopyTree(missing) returned %v, expected ErrNotExist", err)
+		}
+	})
+}
+
+func TestReadBlobs(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "base")
+	const realTxt = "557db03de997c86a4a028e1ebd3a1ceb225be238"
+
+	var read []string
+	err := git.ReadBlobs([]string{realTxt, realTxt}, func(hash string, contents []byte) error {
+		read = append(read, string(contents))
+		return nil
+	})
+	if err != nil || len(read) != 2 || read[0] != "Hello World\n" || read[1] != read[0] {
+		t.Fatalf("ReadBlobs() read %q, %v", read, err)
+	}
+
+	err = git.ReadBlobs([]string{realTxt, "0000000000000000000000000000000000000000"}, func(string, []byte) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatal("ReadBlobs() of a missing object succeeded")
+	}
+}
+
+func TestSplitCopy(t *testing.T) {
+	sizes := []string{"100", "1", "1", "1", "90", "5"}
+	var files []gitism.TreeEntry
+	for _, size := range sizes {
+		files = append(files, gitism.TreeEntry{Hash: size, Size: size})
+	}
+	batches := splitCopy(files, make([]string, len(files)), 2)
+	if len(batches) != 2 || batches[0].bytes != 100 || batches[1].bytes != 98 {
+		t.Fatalf("splitCopy() made batches of %d and %d bytes", batches[0].bytes, batches[1].bytes)
+	}
+	if batches := splitCopy(files[:1], make([]string, 1), 8); len(batches) != 1 {
+		t.Fatalf("splitCopy() made %d batches for a single file", len(batches))
+	}
+}
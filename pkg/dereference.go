@@ -0,0 +1,120 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"io/fs"
+	"os"
+	"path"
+	"syscall"
+)
+
+// dereferenceFileSystem presents symlinks to files and directories in the tree as what they point to.
+type dereferenceFileSystem struct {
+	billy.Filesystem
+}
+
+// NewDereferenceFileSystem resolves symlinks on the server and serves each one whose target is in the tree as the file
+// or directory it points to, for clients that can't follow symlinks or that would follow them outside of what is
+// served, like NFS clients resolving absolute targets against their own root. Symlinks that dangle, loop, or point
+// outside of the tree are still served as symlinks.
+func NewDereferenceFileSystem(fs billy.Filesystem) billy.Filesystem {
+	return dereferenceFileSystem{Filesystem: fs}
+}
+
+// dereference finds the path filename is served from. ok is false if it can't be resolved within the tree, in which
+// case filename is served as it is.
+func (s dereferenceFileSystem) dereference(filename string) (resolved string, ok bool) {
+	// Most paths have no symlinks in them at all, which a single Lstat can tell.
+	if info, err := s.Filesystem.Lstat(filename); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		return filename, true
+	}
+	resolved, escapes, err := followSymlinkFileSystem{Filesystem: s.Filesystem}.resolve(filename)
+	if err != nil || escapes {
+		return "", false
+	}
+	if _, err := s.Filesystem.Lstat(resolved); err != nil {
+		return "", false
+	}
+	return resolved, true
+}
+
+func (s dereferenceFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s dereferenceFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if resolved, ok := s.dereference(filename); ok {
+		filename = resolved
+	}
+	return s.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (s dereferenceFileSystem) Stat(filename string) (os.FileInfo, error) {
+	return followSymlinkFileSystem{Filesystem: s.Filesystem}.Stat(filename)
+}
+
+func (s dereferenceFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	resolved, ok := s.dereference(filename)
+	if !ok {
+		return s.Filesystem.Lstat(filename)
+	}
+	info, err := s.Filesystem.Lstat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	return followedInfo{FileInfo: info, name: path.Base(filename)}, nil
+}
+
+// Readlink fails with EINVAL for dereferenced symlinks, like it does for anything else that isn't a symlink.
+func (s dereferenceFileSystem) Readlink(link string) (string, error) {
+	if _, ok := s.dereference(link); ok {
+		return "", &fs.PathError{Op: "readlink", Path: link, Err: syscall.EINVAL}
+	}
+	return s.Filesystem.Readlink(link)
+}
+
+// ReadDir lists what the directory at filename points to, with the symlinks in it dereferenced.
+func (s dereferenceFileSystem) ReadDir(filename string) ([]os.FileInfo, error) {
+	if resolved, ok := s.dereference(filename); ok {
+		filename = resolved
+	}
+	files, err := s.Filesystem.ReadDir(filename)
+	if err != nil {
+		return nil, err
+	}
+	for i, file := range files {
+		if file.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if info, err := s.Lstat(s.Join(filename, file.Name())); err == nil {
+			files[i] = info
+		}
+	}
+	return files, nil
+}
+
+// Chroot keeps dereferencing symlinks in the new root.
+func (s dereferenceFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	if resolved, ok := s.dereference(path); ok {
+		path = resolved
+	}
+	fs, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewDereferenceFileSystem(fs), nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5/util"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestDereferenceFileSystem(t *testing.T) {
+	git := NewFakeGit(nil)
+	git.Commit("master", "Add links", map[string]FakeFile{
+		"real.txt":     {Contents: "Hello World\n"},
+		"dir/a.txt":    {Contents: "a"},
+		"relative.txt": {Contents: "real.txt", Mode: FakeModeSymlink},
+		"linkdir":      {Contents: "dir", Mode: FakeModeSymlink},
+		"dir/up.txt":   {Contents: "../relative.txt", Mode: FakeModeSymlink},
+		"absolute.txt": {Contents: "/etc/passwd", Mode: FakeModeSymlink},
+		"dangling.txt": {Contents: "missing.txt", Mode: FakeModeSymlink},
+		"loop":         {Contents: "loop", Mode: FakeModeSymlink},
+	})
+	fs := NewDereferenceFileSystem(NewReferenceFileSystemWithSymlinks(git, BranchRef("master"), SymlinkPassThrough))
+
+	for _, filename := range []string{"relative.txt", "dir/up.txt", "linkdir/up.txt"} {
+		info, err := fs.Lstat(filename)
+		if err != nil {
+			t.Fatalf("Lstat(%s) failed: %v", filename, err)
+		}
+		if !info.Mode().IsRegular() || info.Size() != int64(len("Hello World\n")) {
+			t.Fatalf("Lstat(%s) = %s %d, expected real.txt", filename, info.Mode(), info.Size())
+		}
+		contents, err := util.ReadFile(fs, filename)
+		if err != nil || string(contents) != "Hello World\n" {
+			t.Fatalf("reading %s returned %q, %v", filename, contents, err)
+		}
+		if _, err := fs.Readlink(filename); !errors.Is(err, syscall.EINVAL) {
+			t.Fatalf("Readlink(%s) returned %v, expected EINVAL", filename, err)
+		}
+	}
+
+	if info, err := fs.Lstat("linkdir"); err != nil || !info.IsDir() || info.Name() != "linkdir" {
+		t.Fatalf("Lstat(linkdir) = %v, %v, expected a directory", info, err)
+	}
+	listing, err := fs.ReadDir("linkdir")
+	if err != nil {
+		t.Fatalf("ReadDir(linkdir) failed: %v", err)
+	}
+	if files := fileMap(listing); len(files) != 2 || files["a.txt"] == nil || !files["up.txt"].Mode().IsRegular() {
+		t.Fatalf("ReadDir(linkdir) returned %v", listing)
+	}
+	chrooted, err := fs.Chroot("linkdir")
+	if err != nil {
+		t.Fatalf("Chroot(linkdir) failed: %v", err)
+	}
+	if contents, err := util.ReadFile(chrooted, "a.txt"); err != nil || string(contents) != "a" {
+		t.Fatalf("reading a.txt in linkdir returned %q, %v", contents, err)
+	}
+
+	// Only what can be resolved within the tree is dereferenced.
+	root, err := fs.ReadDir(".")
+	if err != nil {
+		t.Fatalf("ReadDir(.) failed: %v", err)
+	}
+	files := fileMap(root)
+	for _, link := range []string{"absolute.txt", "dangling.txt", "loop"} {
+		if info, err := fs.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Fatalf("Lstat(%s) = %v, %v, expected a symlink", link, info, err)
+		}
+		if files[link] == nil || files[link].Mode()&os.ModeSymlink == 0 {
+			t.Fatalf("ReadDir(.) listed %s as %v, expected a symlink", link, files[link])
+		}
+	}
+	if target, err := fs.Readlink("absolute.txt"); err != nil || target != "/etc/passwd" {
+		t.Fatalf("Readlink(absolute.txt) = %s, %v", target, err)
+	}
+	if !files["relative.txt"].Mode().IsRegular() || !files["linkdir"].IsDir() {
+		t.Fatalf("ReadDir(.) didn't dereference relative.txt and linkdir: %v", root)
+	}
+}
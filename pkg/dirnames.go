@@ -0,0 +1,43 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import "github.com/go-git/go-billy/v5"
+
+// DirNamesReader is a filesystem that can list the names in a directory more cheaply than ReadDir.
+type DirNamesReader interface {
+	ReadDirNames(path string) ([]string, error)
+}
+
+// ReadDirNames lists the names in the directory at path, using fs's ReadDirNames if it has one. Decorators hide it, so
+// it is only used when fs is a ReferenceFileSystem or passes it along.
+func ReadDirNames(fs billy.Filesystem, path string) ([]string, error) {
+	if reader, ok := fs.(DirNamesReader); ok {
+		return reader.ReadDirNames(path)
+	}
+	return readDirNames(fs, path)
+}
+
+func readDirNames(fs billy.Filesystem, path string) ([]string, error) {
+	files, err := fs.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	return names, nil
+}
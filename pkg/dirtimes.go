@@ -0,0 +1,171 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/helper/chroot"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// directoryTimesCommitTTL is how long the commit reference points to is reused for when looking up directories
+	// that aren't cached yet.
+	directoryTimesCommitTTL = time.Second
+	// maxCachedDirectoryTimes bounds how many trees' times are remembered before starting over.
+	maxCachedDirectoryTimes = 1 << 16
+)
+
+// directoryTimes remembers when each tree was last changed by its hash, so a directory is only looked up again once
+// its contents change.
+type directoryTimes struct {
+	git       Git
+	reference Ref
+
+	mu       sync.Mutex
+	times    map[string]time.Time
+	commit   string
+	resolved time.Time
+}
+
+// resolve returns the commit reference points to. Must be called with t.mu held.
+func (t *directoryTimes) resolve() (string, error) {
+	now := time.Now()
+	if t.commit == "" || now.Sub(t.resolved) > directoryTimesCommitTTL {
+		commit, err := t.git.ResolveCommit(t.reference)
+		if err != nil {
+			return "", err
+		}
+		t.commit, t.resolved = commit, now
+	}
+	return t.commit, nil
+}
+
+// lookup returns the time of the last commit that changed the directory at path, whose tree is hash. The root has
+// no hash of its own so it is keyed by the commit instead.
+func (t *directoryTimes) lookup(path FilePath, hash string) (time.Time, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	commit, err := t.resolve()
+	if err != nil {
+		return time.Time{}, err
+	}
+	key := hash
+	if path.IsRoot() {
+		key = commit
+	}
+	if modTime, ok := t.times[key]; ok {
+		return modTime, nil
+	}
+
+	modTime, err := t.git.LastModified(commit, path.String())
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(t.times) >= maxCachedDirectoryTimes {
+		t.times = make(map[string]time.Time)
+	}
+	t.times[key] = modTime
+	return modTime, nil
+}
+
+type directoryTimeInfo struct {
+	os.FileInfo
+	modTime time.Time
+}
+
+func (i directoryTimeInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+// directoryTimeFileSystem reports the time of the last commit that changed something within a directory as its
+// modification time, instead of the epoch, so make-style staleness checks see directories change.
+type directoryTimeFileSystem struct {
+	billy.Filesystem
+	times *directoryTimes
+}
+
+// NewDirectoryTimeFileSystem reports the modification time of every directory in fs, a reference filesystem of
+// reference, as the time of the last commit to change something within it. Times are cached by tree hash so each
+// version of a directory is only looked up once.
+func NewDirectoryTimeFileSystem(fs billy.Filesystem, git Git, reference Ref) billy.Filesystem {
+	return directoryTimeFileSystem{
+		Filesystem: fs,
+		times: &directoryTimes{
+			git:       git,
+			reference: reference,
+			times:     make(map[string]time.Time),
+		},
+	}
+}
+
+// withModTime replaces the modification time of info if it is a directory read from git. Failures are logged and
+// leave info as is since a wrong time is better than failing the stat.
+func (s directoryTimeFileSystem) withModTime(filename string, info os.FileInfo) os.FileInfo {
+	if !info.IsDir() {
+		return info
+	}
+	hash, ok := ObjectHash(info)
+	if !ok {
+		return info
+	}
+	root := RootGitPath()
+	path, err := root.Resolve(filename)
+	if err != nil || (hash == "" && !path.IsRoot()) {
+		return info
+	}
+	modTime, err := s.times.lookup(path, hash)
+	if err != nil {
+		log.Printf("failed to find when %s last changed: %s\n", RedactPath(filename), redactError(err, filename))
+		return info
+	}
+	return directoryTimeInfo{FileInfo: info, modTime: modTime}
+}
+
+func (s directoryTimeFileSystem) Stat(filename string) (os.FileInfo, error) {
+	info, err := s.Filesystem.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return s.withModTime(filename, info), nil
+}
+
+func (s directoryTimeFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	info, err := s.Filesystem.Lstat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return s.withModTime(filename, info), nil
+}
+
+func (s directoryTimeFileSystem) ReadDir(filename string) ([]os.FileInfo, error) {
+	files, err := s.Filesystem.ReadDir(filename)
+	if err != nil {
+		return nil, err
+	}
+	for i, info := range files {
+		files[i] = s.withModTime(s.Join(filename, info.Name()), info)
+	}
+	return files, nil
+}
+
+// Chroot keeps looking up directories by their path from the original root.
+func (s directoryTimeFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(s, path), nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDirectoryTimeFileSystem(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "dates")
+	master := BranchRef("master")
+	fs := NewDirectoryTimeFileSystem(NewReferenceFileSystem(git, master), git, master)
+
+	oldTime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	newTime := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("stat", func(t *testing.T) {
+		for name, expected := range map[string]time.Time{"/": newTime, "old": oldTime, "new": newTime} {
+			info, err := fs.Stat(name)
+			if err != nil {
+				t.Fatalf("Stat(%s) failed: %v", name, err)
+			}
+			if !info.ModTime().Equal(expected) {
+				t.Fatalf("Stat(%s).ModTime() = %v, expected %v", name, info.ModTime().UTC(), expected)
+			}
+		}
+	})
+
+	t.Run("files", func(t *testing.T) {
+		info, err := fs.Stat("old/file.txt")
+		if err != nil {
+			t.Fatalf("Stat(old/file.txt) failed: %v", err)
+		}
+		if !info.ModTime().Equal(time.Unix(0, 0)) {
+			t.Fatalf("files should keep their modification time, got %v", info.ModTime())
+		}
+	})
+
+	t.Run("read dir", func(t *testing.T) {
+		files, err := fs.ReadDir("/")
+		if err != nil {
+			t.Fatalf("ReadDir(/) failed: %v", err)
+		}
+		times := map[string]time.Time{}
+		for _, info := range files {
+			times[info.Name()] = info.ModTime()
+		}
+		if !times["old"].Equal(oldTime) || !times["new"].Equal(newTime) {
+			t.Fatalf("ReadDir(/) reported %v", times)
+		}
+	})
+
+	t.Run("chroot", func(t *testing.T) {
+		chrooted, err := fs.Chroot("old")
+		if err != nil {
+			t.Fatalf("Chroot(old) failed: %v", err)
+		}
+		info, err := chrooted.Stat("/")
+		if err != nil || !info.ModTime().Equal(oldTime) {
+			t.Fatalf("Stat(/) after Chroot(old) = %v, %v, expected %v", info, err, oldTime)
+		}
+	})
+}
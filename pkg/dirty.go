@@ -0,0 +1,229 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/helper/chroot"
+	"github.com/go-git/go-billy/v5/osfs"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// worktreeStatusTTL is how long the list of changed files is reused before git status is run again.
+const worktreeStatusTTL = time.Second
+
+// worktreeStatus remembers which paths of a working tree differ from HEAD.
+type worktreeStatus struct {
+	list func(handler func(path string) error) error
+	now  func() time.Time
+
+	mu      sync.Mutex
+	checked time.Time
+	// dirty holds every changed path and every directory above one. children holds the names of the dirty paths in
+	// each directory.
+	dirty    map[string]bool
+	children map[string][]string
+}
+
+// load returns the dirty paths, running git status again if they are older than worktreeStatusTTL.
+func (s *worktreeStatus) load() (map[string]bool, map[string][]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.dirty != nil && now.Sub(s.checked) < worktreeStatusTTL {
+		return s.dirty, s.children, nil
+	}
+
+	dirty := map[string]bool{}
+	children := map[string][]string{}
+	err := s.list(func(changed string) error {
+		for name := changed; name != "." && !dirty[name]; name = path.Dir(name) {
+			dirty[name] = true
+			parent := path.Dir(name)
+			children[parent] = append(children[parent], path.Base(name))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	s.dirty, s.children, s.checked = dirty, children, now
+	return dirty, children, nil
+}
+
+// dirtyFileSystem serves the files of a working tree that differ from HEAD straight from disk and everything else
+// from the tree underneath, so a mount of HEAD shows uncommitted changes.
+type dirtyFileSystem struct {
+	billy.Filesystem
+	worktree billy.Filesystem
+	status   *worktreeStatus
+}
+
+// NewDirtyFileSystem overlays the uncommitted changes in worktree, a working tree of the repository git reads, on top
+// of fs, which should be serving HEAD. Files that were changed or added are read from disk and files that were
+// deleted disappear. Ignored files aren't shown. git status is run at most once every worktreeStatusTTL, and the
+// overlay is read-only.
+func NewDirtyFileSystem(fs billy.Filesystem, git Git, worktree string) billy.Filesystem {
+	return dirtyFileSystem{
+		Filesystem: fs,
+		worktree:   osfs.New(worktree),
+		status: &worktreeStatus{
+			list: func(handler func(path string) error) error {
+				return git.ListWorktreeChanges(worktree, handler)
+			},
+			now: time.Now,
+		},
+	}
+}
+
+// lookup turns filename into a path relative to the root and reports if it has to be served from the working tree.
+func (s dirtyFileSystem) lookup(filename string) (name string, dirty bool, err error) {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil {
+		return "", false, err
+	}
+	if resolved.IsRoot() {
+		return ".", false, nil
+	}
+	name = strings.Join(resolved.Path, "/")
+	paths, _, err := s.status.load()
+	if err != nil {
+		return "", false, err
+	}
+	return name, paths[name], nil
+}
+
+// billy.Basic type implementation
+
+func (s dirtyFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s dirtyFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	name, dirty, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !dirty {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+	if flag&writeFlags != 0 {
+		return nil, billy.ErrReadOnly
+	}
+	return s.worktree.OpenFile(name, flag, perm)
+}
+
+func (s dirtyFileSystem) Stat(filename string) (os.FileInfo, error) {
+	name, dirty, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !dirty {
+		return s.Filesystem.Stat(filename)
+	}
+	return s.worktree.Stat(name)
+}
+
+// billy.Dir type implementation
+
+// ReadDir lists filename from the tree underneath with its dirty entries replaced by, added from, or removed to
+// match the working tree.
+func (s dirtyFileSystem) ReadDir(filename string) ([]os.FileInfo, error) {
+	name, dirty, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+	paths, children, err := s.status.load()
+	if err != nil {
+		return nil, err
+	}
+	if len(children[name]) == 0 {
+		if dirty {
+			return s.worktree.ReadDir(name)
+		}
+		return s.Filesystem.ReadDir(filename)
+	}
+	if dirty {
+		// The directory may have been deleted or replaced by a file.
+		if info, err := s.worktree.Stat(name); err != nil {
+			return nil, err
+		} else if !info.IsDir() {
+			return nil, &fs.PathError{Op: "readdir", Path: filename, Err: ErrNotDirectory}
+		}
+	}
+
+	files, err := s.Filesystem.ReadDir(filename)
+	// A dirty directory that was added to the working tree isn't in the tree underneath.
+	if err != nil && !dirty {
+		return nil, err
+	}
+	listing := make([]os.FileInfo, 0, len(files)+len(children[name]))
+	listed := map[string]bool{}
+	for _, file := range files {
+		listed[file.Name()] = true
+		if !paths[path.Join(name, file.Name())] {
+			listing = append(listing, file)
+		} else if info, err := s.worktree.Lstat(path.Join(name, file.Name())); err == nil {
+			listing = append(listing, info)
+		}
+	}
+	for _, child := range children[name] {
+		if listed[child] {
+			continue
+		}
+		if info, err := s.worktree.Lstat(path.Join(name, child)); err == nil {
+			listing = append(listing, info)
+		}
+	}
+	return listing, nil
+}
+
+// billy.Symlink type implementation
+
+func (s dirtyFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	name, dirty, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !dirty {
+		return s.Filesystem.Lstat(filename)
+	}
+	return s.worktree.Lstat(name)
+}
+
+func (s dirtyFileSystem) Readlink(link string) (string, error) {
+	name, dirty, err := s.lookup(link)
+	if err != nil {
+		return "", err
+	}
+	if !dirty {
+		return s.Filesystem.Readlink(link)
+	}
+	return s.worktree.Readlink(name)
+}
+
+// billy.Chroot type implementation
+
+// Chroot keeps looking paths up relative to the root of the working tree.
+func (s dirtyFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(s, path), nil
+}
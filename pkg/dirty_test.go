@@ -0,0 +1,137 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirtyFileSystem(t *testing.T) {
+	gitDir, err := runPlaybook("dirty", t.TempDir())
+	if err != nil {
+		t.Fatalf("playbook 'dirty' failed: %v", err)
+	}
+	git, err := NewCliGit(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree := filepath.Dir(gitDir)
+	fs := NewDirtyFileSystem(NewReferenceFileSystem(git, BranchRef("master")), git, worktree)
+
+	names := func(dir string) []string {
+		files, err := fs.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir(%s) failed: %v", dir, err)
+		}
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		return names
+	}
+	if diff := cmp.Diff([]string{".gitignore", "changed.txt", "dir", "added"}, names("/")); diff != "" {
+		t.Fatalf("ReadDir(/) mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"unchanged.txt", "untracked.txt"}, names("dir")); diff != "" {
+		t.Fatalf("ReadDir(dir) mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"file.txt"}, names("added/nested")); diff != "" {
+		t.Fatalf("ReadDir(added/nested) mismatch (-want +got):\n%s", diff)
+	}
+
+	for name, want := range map[string]string{
+		"changed.txt":           "Changed\n",
+		"dir/unchanged.txt":     "Committed\n",
+		"dir/untracked.txt":     "Untracked\n",
+		"added/nested/file.txt": "Added\n",
+	} {
+		contents, err := util.ReadFile(fs, name)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) failed: %v", name, err)
+		}
+		if string(contents) != want {
+			t.Fatalf("ReadFile(%s) = %q, want %q", name, contents, want)
+		}
+		info, err := fs.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat(%s) failed: %v", name, err)
+		}
+		if info.Size() != int64(len(want)) {
+			t.Fatalf("Stat(%s).Size() = %d, want %d", name, info.Size(), len(want))
+		}
+	}
+
+	for _, name := range []string{"deleted.txt", "removed", "removed/file.txt", "ignored.log"} {
+		if _, err := fs.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Stat(%s) should fail with os.ErrNotExist: %v", name, err)
+		}
+	}
+	if _, err := fs.ReadDir("removed"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("ReadDir(removed) should fail with os.ErrNotExist: %v", err)
+	}
+	if _, err := fs.OpenFile("changed.txt", os.O_RDWR, 0); !errors.Is(err, billy.ErrReadOnly) {
+		t.Fatalf("OpenFile(changed.txt, O_RDWR) should fail with billy.ErrReadOnly: %v", err)
+	}
+
+	chrooted, err := fs.Chroot("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := util.ReadFile(chrooted, "untracked.txt"); err != nil || string(contents) != "Untracked\n" {
+		t.Fatalf("ReadFile(untracked.txt) under Chroot(dir) = %q, %v", contents, err)
+	}
+}
+
+func TestWorktreeStatusTTL(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	runs := 0
+	status := &worktreeStatus{
+		list: func(handler func(path string) error) error {
+			runs++
+			return handler("a/b/c.txt")
+		},
+		now: clock.Now,
+	}
+
+	for i := 0; i < 2; i++ {
+		dirty, children, err := status.load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(map[string]bool{"a": true, "a/b": true, "a/b/c.txt": true}, dirty); diff != "" {
+			t.Fatalf("dirty mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(map[string][]string{".": {"a"}, "a": {"b"}, "a/b": {"c.txt"}}, children); diff != "" {
+			t.Fatalf("children mismatch (-want +got):\n%s", diff)
+		}
+	}
+	if runs != 1 {
+		t.Fatalf("git status ran %d times within worktreeStatusTTL", runs)
+	}
+	clock.Advance(worktreeStatusTTL)
+	if _, _, err := status.load(); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 2 {
+		t.Fatalf("git status should run again after worktreeStatusTTL, ran %d times", runs)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io"
+	"os"
+)
+
+// maxLooseObjects is how many loose objects a repository may have before Diagnose suggests packing them. Every loose
+// object read is a file opened and inflated on its own.
+const maxLooseObjects = 10000
+
+// Diagnosis is the outcome of one check made by Diagnose.
+type Diagnosis struct {
+	// Check names what was checked.
+	Check string
+	// Detail describes what was found.
+	Detail string
+	// Problem is what is wrong, and empty if the check passed.
+	Problem string
+	// Warning is set if Problem doesn't stop gitfs from working.
+	Warning bool
+	// Remediation is what to do about Problem.
+	Remediation string
+}
+
+// Failed reports if the check found something that stops gitfs from working.
+func (d Diagnosis) Failed() bool {
+	return d.Problem != "" && !d.Warning
+}
+
+// gitFeatures are the ways gitfs runs git that older versions don't support, along with an example of each to try.
+// Each is run against the empty tree so they work in any repository, including one without commits.
+var gitFeatures = []struct {
+	name string
+	args func(emptyTree string) []string
+}{
+	{"ls-tree -z --long", func(emptyTree string) []string {
+		return []string{"ls-tree", "-z", "--long", emptyTree}
+	}},
+	{"for-each-ref --format=%(refname:lstrip=2)", func(string) []string {
+		return []string{"for-each-ref", "--count=1", "--format=%(refname:lstrip=2) %(objectname)"}
+	}},
+}
+
+// Diagnose checks that git can be run with options and serve the repository at gitDirectory, and that reference
+// exists in it. It runs every check it can rather than stopping at the first problem.
+func Diagnose(gitDirectory string, reference Ref, options ...CliOption) []Diagnosis {
+	cliOptions := cliOptions{}
+	for _, option := range options {
+		option(&cliOptions)
+	}
+
+	var diagnoses []Diagnosis
+	cli, err := gitism.NewCommandWithOptions(gitDirectory, cliOptions.CommandOptions)
+	if errors.Is(err, gitism.ErrUnsupportedVersion) {
+		return append(diagnoses, Diagnosis{
+			Check:       "git",
+			Problem:     err.Error(),
+			Remediation: fmt.Sprintf("Upgrade git to %s or newer, or point --git-binary or --git-path at one.", gitism.MinimumVersion),
+		})
+	}
+	if cliOptions.fallsBack(err) {
+		diagnoses = append(diagnoses, Diagnosis{
+			Check:       "git",
+			Problem:     err.Error(),
+			Warning:     true,
+			Remediation: "Install git to read the repository with it instead of the embedded git backend, which can't check signatures, bisect, or list the changes in a worktree.",
+		})
+		return append(diagnoses, diagnoseRef(gitDirectory, reference, options))
+	}
+	if err != nil {
+		return append(diagnoses, Diagnosis{
+			Check:       "git",
+			Problem:     err.Error(),
+			Remediation: "Install git, or point --git-binary or --git-path at it. Check that it runs for the user gitfs runs as.",
+		})
+	}
+	version := cli.Version()
+	diagnoses = append(diagnoses, Diagnosis{Check: "git", Detail: "version " + version.String()})
+
+	if info, err := os.Stat(gitDirectory); err != nil || !info.IsDir() {
+		return append(diagnoses, Diagnosis{
+			Check:       "repository",
+			Problem:     fmt.Sprintf("%s is not a directory", gitDirectory),
+			Remediation: "Pass the path of a bare repository, or of the .git directory of a clone, with --git-dir.",
+		})
+	}
+	format, err := cli.ObjectFormat()
+	if err != nil {
+		return append(diagnoses, Diagnosis{
+			Check:       "repository",
+			Problem:     fmt.Sprintf("git can't read %s: %v", gitDirectory, err),
+			Remediation: "Pass the path of a bare repository, or of the .git directory of a clone, with --git-dir. A repository owned by another user also needs safe.directory set with --git-config.",
+		})
+	}
+	diagnoses = append(diagnoses, Diagnosis{Check: "repository", Detail: fmt.Sprintf("%s objects", format)})
+
+	for _, feature := range gitFeatures {
+		diagnosis := Diagnosis{Check: "git " + feature.name, Detail: "supported"}
+		if err := cli.Check(feature.args(format.EmptyTree())...); err != nil {
+			diagnosis.Detail = ""
+			diagnosis.Problem = fmt.Sprintf("git %s doesn't support it: %v", version, err)
+			diagnosis.Remediation = "Upgrade git to a newer release."
+		}
+		diagnoses = append(diagnoses, diagnosis)
+	}
+	diagnoses = append(diagnoses, diagnoseBatch(cli, format, version))
+
+	counts, err := cli.CountObjects()
+	switch {
+	case err != nil:
+		diagnoses = append(diagnoses, Diagnosis{
+			Check:       "objects",
+			Problem:     fmt.Sprintf("failed to count objects: %v", err),
+			Remediation: "Run git fsck in the repository to find out what is wrong with it.",
+		})
+	case counts.Garbage > 0:
+		diagnoses = append(diagnoses, Diagnosis{
+			Check:       "objects",
+			Problem:     fmt.Sprintf("%d garbage files in the object directory", counts.Garbage),
+			Warning:     true,
+			Remediation: "Run git gc in the repository to clean them up.",
+		})
+	case counts.Loose > maxLooseObjects:
+		diagnoses = append(diagnoses, Diagnosis{
+			Check:       "objects",
+			Problem:     fmt.Sprintf("%d loose objects, which are slower to read than packed ones", counts.Loose),
+			Warning:     true,
+			Remediation: "Run git gc or git repack -d in the repository to pack them.",
+		})
+	default:
+		diagnoses = append(diagnoses, Diagnosis{
+			Check:  "objects",
+			Detail: fmt.Sprintf("%d packed in %d packs, %d loose", counts.Packed, counts.Packs, counts.Loose),
+		})
+	}
+
+	return append(diagnoses, diagnoseRef(gitDirectory, reference, options))
+}
+
+// diagnoseRef checks that reference resolves to a commit in the repository at gitDirectory.
+func diagnoseRef(gitDirectory string, reference Ref, options []CliOption) Diagnosis {
+	git, err := NewCliGit(gitDirectory, options...)
+	if err != nil {
+		return Diagnosis{Check: "ref", Problem: err.Error()}
+	}
+	if closer, ok := git.(io.Closer); ok {
+		defer closer.Close()
+	}
+	commit, err := git.ResolveCommit(reference)
+	if err != nil {
+		return Diagnosis{
+			Check:       "ref",
+			Problem:     fmt.Sprintf("%s doesn't resolve to a commit: %v", reference, err),
+			Remediation: "Pass a branch, tag, or commit that exists in the repository with --ref, or fetch it.",
+		}
+	}
+	return Diagnosis{Check: "ref", Detail: fmt.Sprintf("%s is %s with the %s backend", reference, commit, Backend(git))}
+}
+
+// diagnoseBatch checks that git cat-file --batch, which files are read through, answers requests.
+func diagnoseBatch(cli gitism.Command, format gitism.ObjectFormat, version gitism.Version) Diagnosis {
+	diagnosis := Diagnosis{
+		Check:       "git cat-file --batch",
+		Remediation: "Upgrade git to a newer release.",
+	}
+	batch, err := cli.StartBatch(false)
+	if err != nil {
+		diagnosis.Problem = fmt.Sprintf("git %s couldn't start it: %v", version, err)
+		return diagnosis
+	}
+	defer batch.Close()
+	if objectType, _, _, err := batch.Object(format.EmptyTree()); err != nil || objectType != "tree" {
+		diagnosis.Problem = fmt.Sprintf("git %s didn't read the empty tree with it: %s, %v", version, objectType, err)
+		return diagnosis
+	}
+	diagnosis.Remediation = ""
+	diagnosis.Detail = "supported"
+	return diagnosis
+}
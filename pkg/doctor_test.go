@@ -0,0 +1,72 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	repository, err := runPlaybook("base", t.TempDir())
+	if err != nil {
+		t.Fatalf("playbook 'base' failed: %v", err)
+	}
+	checks := func(diagnoses []Diagnosis) map[string]Diagnosis {
+		byCheck := map[string]Diagnosis{}
+		for _, diagnosis := range diagnoses {
+			byCheck[diagnosis.Check] = diagnosis
+		}
+		return byCheck
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		diagnoses := Diagnose(repository, BranchRef("master"))
+		for _, diagnosis := range diagnoses {
+			if diagnosis.Problem != "" {
+				t.Fatalf("%s found a problem in a healthy repository: %s", diagnosis.Check, diagnosis.Problem)
+			}
+		}
+		for _, check := range []string{"git", "repository", "git cat-file --batch", "objects", "ref"} {
+			if _, ok := checks(diagnoses)[check]; !ok {
+				t.Fatalf("%s wasn't checked: %+v", check, diagnoses)
+			}
+		}
+	})
+
+	t.Run("missing ref", func(t *testing.T) {
+		ref := checks(Diagnose(repository, BranchRef("missing")))["ref"]
+		if !ref.Failed() || ref.Remediation == "" {
+			t.Fatalf("a missing ref was diagnosed as %+v", ref)
+		}
+	})
+
+	t.Run("not a repository", func(t *testing.T) {
+		diagnoses := checks(Diagnose(filepath.Join(t.TempDir(), "missing"), BranchRef("master")))
+		if !diagnoses["repository"].Failed() || diagnoses["repository"].Remediation == "" {
+			t.Fatalf("a missing repository was diagnosed as %+v", diagnoses["repository"])
+		}
+		if _, ok := diagnoses["ref"]; ok {
+			t.Fatal("refs were checked in a repository that doesn't exist")
+		}
+	})
+
+	t.Run("missing git", func(t *testing.T) {
+		diagnoses := Diagnose(repository, BranchRef("master"), WithGitExecutable(filepath.Join(t.TempDir(), "git")))
+		if len(diagnoses) != 1 || !diagnoses[0].Failed() || diagnoses[0].Check != "git" {
+			t.Fatalf("a missing git was diagnosed as %+v", diagnoses)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// DotfilePolicy decides whether files and directories whose names start with "." are served.
+type DotfilePolicy uint8
+
+const (
+	// DotfilesShow serves dotfiles like any other file.
+	DotfilesShow DotfilePolicy = iota
+	// DotfilesHideListings leaves dotfiles out of listings but still serves them to anyone asking for them by name.
+	DotfilesHideListings
+	// DotfilesHideStrict leaves dotfiles out of listings and acts as if they don't exist.
+	DotfilesHideStrict
+)
+
+func ParseDotfilePolicy(name string) (DotfilePolicy, error) {
+	switch name {
+	case "none":
+		return DotfilesShow, nil
+	case "listings":
+		return DotfilesHideListings, nil
+	case "strict":
+		return DotfilesHideStrict, nil
+	default:
+		return 0, fmt.Errorf("unknown dotfile policy '%s', expected none, listings, or strict", name)
+	}
+}
+
+func (p DotfilePolicy) String() string {
+	switch p {
+	case DotfilesShow:
+		return "none"
+	case DotfilesHideListings:
+		return "listings"
+	case DotfilesHideStrict:
+		return "strict"
+	default:
+		return "unknown-dotfile-policy"
+	}
+}
+
+func isDotfile(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// dotfileFileSystem hides dotfiles according to policy.
+type dotfileFileSystem struct {
+	billy.Filesystem
+	policy DotfilePolicy
+}
+
+// NewDotfileFileSystem hides the dotfiles of fs according to policy. DotfilesShow returns fs.
+func NewDotfileFileSystem(fs billy.Filesystem, policy DotfilePolicy) billy.Filesystem {
+	if policy == DotfilesShow {
+		return fs
+	}
+	return dotfileFileSystem{
+		Filesystem: fs,
+		policy:     policy,
+	}
+}
+
+// hidden reports whether filename passes through a dotfile and policy hides those from lookups. Paths that can't be
+// resolved are left for the underlying filesystem to reject.
+func (s dotfileFileSystem) hidden(filename string) bool {
+	if s.policy != DotfilesHideStrict {
+		return false
+	}
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil {
+		return false
+	}
+	for _, part := range resolved.Path {
+		if isDotfile(part) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s dotfileFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s dotfileFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if s.hidden(filename) {
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: fs.ErrNotExist}
+	}
+	return s.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (s dotfileFileSystem) Stat(filename string) (os.FileInfo, error) {
+	if s.hidden(filename) {
+		return nil, &fs.PathError{Op: "stat", Path: filename, Err: fs.ErrNotExist}
+	}
+	return s.Filesystem.Stat(filename)
+}
+
+func (s dotfileFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	if s.hidden(filename) {
+		return nil, &fs.PathError{Op: "lstat", Path: filename, Err: fs.ErrNotExist}
+	}
+	return s.Filesystem.Lstat(filename)
+}
+
+func (s dotfileFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	if s.hidden(path) {
+		return nil, &fs.PathError{Op: "readdir", Path: path, Err: fs.ErrNotExist}
+	}
+	files, err := s.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := make([]os.FileInfo, 0, len(files))
+	for _, file := range files {
+		if !isDotfile(file.Name()) {
+			listing = append(listing, file)
+		}
+	}
+	return listing, nil
+}
+
+func (s dotfileFileSystem) Readlink(link string) (string, error) {
+	if s.hidden(link) {
+		return "", &fs.PathError{Op: "readlink", Path: link, Err: fs.ErrNotExist}
+	}
+	return s.Filesystem.Readlink(link)
+}
+
+// Chroot keeps hiding dotfiles in the new root.
+func (s dotfileFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	if s.hidden(path) {
+		return nil, &fs.PathError{Op: "chroot", Path: path, Err: fs.ErrNotExist}
+	}
+	fs, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewDotfileFileSystem(fs, s.policy), nil
+}
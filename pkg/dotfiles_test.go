@@ -0,0 +1,93 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"testing"
+)
+
+func TestDotfileFileSystem(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "dotfiles")
+	reference := BranchRef("master")
+
+	names := func(t *testing.T, fs billy.Filesystem, path string) []string {
+		files, err := fs.ReadDir(path)
+		if err != nil {
+			t.Fatalf("ReadDir(%s) failed: %v", path, err)
+		}
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		return names
+	}
+
+	t.Run("listings", func(t *testing.T) {
+		policy, err := ParseDotfilePolicy("listings")
+		if err != nil {
+			t.Fatalf("ParseDotfilePolicy(listings) failed: %v", err)
+		}
+		fs := NewDotfileFileSystem(NewReferenceFileSystem(git, reference), policy)
+
+		if diff := cmp.Diff([]string{"README.md", "src"}, names(t, fs, ".")); diff != "" {
+			t.Fatalf("ReadDir(.) should hide dotfiles (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff([]string{"main.go"}, names(t, fs, "src")); diff != "" {
+			t.Fatalf("ReadDir(src) should hide dotfiles (-want +got):\n%s", diff)
+		}
+		if _, err := fs.Stat(".github/workflows/ci.yml"); err != nil {
+			t.Fatalf("Stat(.github/workflows/ci.yml) should still work: %v", err)
+		}
+		if _, err := fs.Open("src/.env"); err != nil {
+			t.Fatalf("Open(src/.env) should still work: %v", err)
+		}
+		if diff := cmp.Diff([]string{"workflows"}, names(t, fs, ".github")); diff != "" {
+			t.Fatalf("ReadDir(.github) should list its contents (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		fs := NewDotfileFileSystem(NewReferenceFileSystem(git, reference), DotfilesHideStrict)
+
+		if diff := cmp.Diff([]string{"README.md", "src"}, names(t, fs, ".")); diff != "" {
+			t.Fatalf("ReadDir(.) should hide dotfiles (-want +got):\n%s", diff)
+		}
+		for _, path := range []string{".github", ".github/workflows/ci.yml", "src/.env"} {
+			if _, err := fs.Stat(path); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("Stat(%s) should not exist: %v", path, err)
+			}
+		}
+		if _, err := fs.Open("src/.env"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Open(src/.env) should not exist: %v", err)
+		}
+		if _, err := fs.ReadDir(".github"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("ReadDir(.github) should not exist: %v", err)
+		}
+		if _, err := fs.Stat("src/main.go"); err != nil {
+			t.Fatalf("Stat(src/main.go) failed: %v", err)
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		fs := NewDotfileFileSystem(NewReferenceFileSystem(git, reference), DotfilesShow)
+		if _, wrapped := fs.(dotfileFileSystem); wrapped {
+			t.Fatalf("DotfilesShow should not wrap the filesystem")
+		}
+	})
+}
@@ -0,0 +1,968 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-billy/v5/osfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrEmbeddedUnsupported is returned by the embedded git backend for what only the git executable can do.
+var ErrEmbeddedUnsupported = errors.New("not supported by the embedded git backend")
+
+const (
+	// describeCandidates is how many tags Describe considers before picking the closest, like git describe.
+	describeCandidates = 10
+	// renameScore is the similarity, as a percentage, a deleted and an added file need to be listed as a rename. It
+	// is git's default.
+	renameScore = 50
+)
+
+// embeddedGit is a Git that reads the repository with go-git instead of running git, for hosts that don't have git
+// installed. It doesn't follow replace refs, and can't check signatures, list the changes in a worktree, or bisect.
+type embeddedGit struct {
+	directory string
+	// mu serializes reads of the repository since go-git doesn't make them safe to share between goroutines.
+	// Handlers are called without it held so they can call back into the Git.
+	mu         *sync.Mutex
+	repository *gogit.Repository
+	handles    *openHandles
+}
+
+// NewEmbeddedGit reads the repository at gitDirectory, a bare repository or the .git directory of a clone, without
+// running git.
+func NewEmbeddedGit(gitDirectory string) (Git, error) {
+	directory := gitDirectory
+	if directory == "" {
+		directory = ".git"
+	}
+	storage := filesystem.NewStorage(osfs.New(directory), cache.NewObjectLRUDefault())
+	repository, err := gogit.Open(storage, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not open repository '%s': %v", directory, err)
+	}
+	if config, err := repository.Config(); err == nil {
+		format := config.Raw.Section("extensions").Option("objectformat")
+		if format != "" && gitism.NewObjectFormat(format) != gitism.SHA1 {
+			return nil, fmt.Errorf("repository '%s' names objects with %s, which the embedded git backend can't read",
+				directory, format)
+		}
+	}
+	return embeddedGit{directory: directory, mu: &sync.Mutex{}, repository: repository, handles: newOpenHandles()}, nil
+}
+
+func (g embeddedGit) backend() string {
+	return "embedded"
+}
+
+// revision is the revision go-git resolves to the commit ref points to.
+func (g embeddedGit) revision(ref Ref) (string, error) {
+	return ref.treeLike()
+}
+
+// commit reads the commit revision resolves to. Must be called with g.mu held.
+func (g embeddedGit) commit(revision string) (*object.Commit, error) {
+	hash, err := g.repository.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, fmt.Errorf("unknown revision '%s': %v: %w", revision, err, fs.ErrNotExist)
+	}
+	return g.repository.CommitObject(*hash)
+}
+
+// tree reads the tree of the commit ref points to. Must be called with g.mu held.
+func (g embeddedGit) tree(ref Ref, sized bool) (embeddedTree, error) {
+	revision, err := g.revision(ref)
+	if err != nil {
+		return embeddedTree{}, fmt.Errorf("please provide a Commit, Tag, or Branch: %v", err)
+	}
+	commit, err := g.commit(revision)
+	if err != nil {
+		return embeddedTree{}, err
+	}
+	root, err := commit.Tree()
+	if err != nil {
+		return embeddedTree{}, err
+	}
+	return embeddedTree{repository: g.repository, root: root, sized: sized}, nil
+}
+
+// entries calls handler with what list finds in the tree path points into. Everything is found before handler is
+// called so g.mu isn't held while it runs.
+func (g embeddedGit) entries(path GitPath, sized bool, list func(tree embeddedTree, add func(entry gitism.TreeEntry) error) error, handler func(entry gitism.TreeEntry) error) error {
+	var found []gitism.TreeEntry
+	g.mu.Lock()
+	tree, err := g.tree(path.Reference, sized)
+	if err == nil {
+		err = list(tree, func(entry gitism.TreeEntry) error {
+			found = append(found, entry)
+			return nil
+		})
+	}
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, entry := range found {
+		if err := handler(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g embeddedGit) ListTree(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	return g.entries(path, true, func(tree embeddedTree, add func(entry gitism.TreeEntry) error) error {
+		return tree.list(path.TreePath, add)
+	}, handler)
+}
+
+func (g embeddedGit) ListDirectory(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	return g.entries(path, true, func(tree embeddedTree, add func(entry gitism.TreeEntry) error) error {
+		return tree.listDirectory(path.TreePath, add)
+	}, handler)
+}
+
+func (g embeddedGit) ListTreeRecursive(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	return g.entries(path, true, func(tree embeddedTree, add func(entry gitism.TreeEntry) error) error {
+		return tree.listRecursive(path.TreePath, add)
+	}, handler)
+}
+
+func (g embeddedGit) ListTreeNames(path GitPath, handler func(path string) error) error {
+	return g.entries(path, false, func(tree embeddedTree, add func(entry gitism.TreeEntry) error) error {
+		return tree.list(path.TreePath, add)
+	}, func(entry gitism.TreeEntry) error {
+		return handler(entry.Path)
+	})
+}
+
+// refNames lists the names of the refs starting with prefix, without it, in order like git for-each-ref.
+func (g embeddedGit) refNames(prefix string, handler func(name string) error) error {
+	var names []string
+	g.mu.Lock()
+	refs, err := g.repository.References()
+	if err == nil {
+		err = refs.ForEach(func(ref *plumbing.Reference) error {
+			if name := ref.Name().String(); strings.HasPrefix(name, prefix) {
+				names = append(names, strings.TrimPrefix(name, prefix))
+			}
+			return nil
+		})
+	}
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := handler(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g embeddedGit) ListBranches(handler func(branch string) error) error {
+	return g.refNames(branchPrefix, handler)
+}
+
+func (g embeddedGit) ListTags(handler func(branch string) error) error {
+	return g.refNames(tagPrefix, handler)
+}
+
+func (g embeddedGit) ListCommits(ref Ref, handler func(branch string) error) error {
+	if ref.Kind == RefCommit {
+		return ErrCannotListCommit
+	}
+	return g.WalkCommits(ref, false, func(commit gitism.GraphCommit) error {
+		return handler(commit.Hash)
+	})
+}
+
+// hashStrings names hashes the way git prints them.
+func hashStrings(hashes []plumbing.Hash) []string {
+	names := make([]string, len(hashes))
+	for i, hash := range hashes {
+		names[i] = hash.String()
+	}
+	return names
+}
+
+func (g embeddedGit) CommitParents(commit string) ([]string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	found, err := g.commit(commit)
+	if err != nil {
+		return nil, err
+	}
+	return hashStrings(found.ParentHashes), nil
+}
+
+// walkedCommit is a commit waiting to be walked, and sequence orders those committed at the same time by when they
+// were found.
+type walkedCommit struct {
+	commit   *object.Commit
+	sequence int
+}
+
+// commitQueue holds the commits waiting to be walked, newest committed first.
+type commitQueue []walkedCommit
+
+func (q commitQueue) Len() int {
+	return len(q)
+}
+
+func (q commitQueue) Less(i, j int) bool {
+	if !q[i].commit.Committer.When.Equal(q[j].commit.Committer.When) {
+		return q[i].commit.Committer.When.After(q[j].commit.Committer.When)
+	}
+	return q[i].sequence < q[j].sequence
+}
+
+func (q commitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *commitQueue) Push(x interface{}) {
+	*q = append(*q, x.(walkedCommit))
+}
+
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
+
+// walk calls visit with every commit reachable from start, newest committed first like git rev-list, until visit
+// asks to stop. Must be called with g.mu held.
+func (g embeddedGit) walk(start *object.Commit, firstParent bool, visit func(commit *object.Commit) (stop bool, err error)) error {
+	seen := map[plumbing.Hash]bool{start.Hash: true}
+	queue := &commitQueue{{commit: start}}
+	sequence := 1
+	for queue.Len() > 0 {
+		next := heap.Pop(queue).(walkedCommit).commit
+		if stop, err := visit(next); stop || err != nil {
+			return err
+		}
+
+		parents := next.ParentHashes
+		if firstParent && len(parents) > 1 {
+			parents = parents[:1]
+		}
+		for _, parent := range parents {
+			if seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			commit, err := g.repository.CommitObject(parent)
+			if err != nil {
+				return err
+			}
+			heap.Push(queue, walkedCommit{commit: commit, sequence: sequence})
+			sequence++
+		}
+	}
+	return nil
+}
+
+// reachable is the set of every commit reachable from start. Must be called with g.mu held.
+func (g embeddedGit) reachable(start *object.Commit) (map[plumbing.Hash]bool, error) {
+	hashes := map[plumbing.Hash]bool{}
+	err := g.walk(start, false, func(commit *object.Commit) (bool, error) {
+		hashes[commit.Hash] = true
+		return false, nil
+	})
+	return hashes, err
+}
+
+func (g embeddedGit) WalkCommits(ref Ref, firstParent bool, handler func(commit gitism.GraphCommit) error) error {
+	var walked []gitism.GraphCommit
+	g.mu.Lock()
+	revision, err := g.revision(ref)
+	if err == nil {
+		var start *object.Commit
+		start, err = g.commit(revision)
+		if err == nil {
+			err = g.walk(start, firstParent, func(commit *object.Commit) (bool, error) {
+				walked = append(walked, gitism.GraphCommit{Hash: commit.Hash.String(), Parents: hashStrings(commit.ParentHashes)})
+				return false, nil
+			})
+		}
+	}
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, commit := range walked {
+		if err := handler(commit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListChanges compares commit to its parent, or to nothing for the first commit, like git diff-tree --root. Nothing
+// is listed for merges.
+func (g embeddedGit) ListChanges(commit string, handler func(change gitism.Change) error) error {
+	g.mu.Lock()
+	changes, err := g.changes(commit)
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if err := handler(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changes is ListChanges. Must be called with g.mu held.
+func (g embeddedGit) changes(commit string) ([]gitism.Change, error) {
+	found, err := g.commit(commit)
+	if err != nil || len(found.ParentHashes) > 1 {
+		return nil, err
+	}
+	tree, err := found.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var previous *object.Tree
+	if len(found.ParentHashes) == 1 {
+		parent, err := g.repository.CommitObject(found.ParentHashes[0])
+		if err != nil {
+			return nil, err
+		}
+		if previous, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+	diff, err := object.DiffTreeWithOptions(context.Background(), previous, tree, &object.DiffTreeOptions{
+		DetectRenames: true,
+		RenameScore:   renameScore,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	missing := gitism.SHA1.MissingHash()
+	changes := make([]gitism.Change, 0, len(diff))
+	for _, difference := range diff {
+		from, to := difference.From, difference.To
+		change := gitism.Change{PreviousHash: missing, Hash: missing, Path: to.Name}
+		if from.Name != "" {
+			change.PreviousHash = from.TreeEntry.Hash.String()
+			change.PreviousMode = gitism.NewFileMode(uint16(from.TreeEntry.Mode))
+		}
+		if to.Name != "" {
+			change.Hash = to.TreeEntry.Hash.String()
+			change.Mode = gitism.NewFileMode(uint16(to.TreeEntry.Mode))
+		}
+		switch {
+		case from.Name == "":
+			change.Type = gitism.ChangeAddition
+		case to.Name == "":
+			change.Type, change.Path = gitism.ChangeDeletion, from.Name
+		case from.Name != to.Name:
+			change.Type, change.PreviousPath = gitism.ChangeRename, from.Name
+			if change.PreviousHash == change.Hash {
+				change.Score = 100
+			}
+		case change.PreviousMode.Type != change.Mode.Type:
+			change.Type = gitism.ChangeFileType
+		default:
+			change.Type = gitism.ChangeModification
+		}
+		changes = append(changes, change)
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// objectHash parses the full hash of an object.
+func objectHash(hash string) (plumbing.Hash, error) {
+	if len(hash) != gitism.SHA1.HashLength() || !isHash(hash) {
+		return plumbing.ZeroHash, fmt.Errorf("invalid object name '%s'", hash)
+	}
+	return plumbing.NewHash(hash), nil
+}
+
+func (g embeddedGit) ReadBlob(hash string) ([]byte, error) {
+	name, err := objectHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	blob, err := g.repository.BlobObject(name)
+	if err != nil {
+		return nil, fmt.Errorf("no blob %s: %v: %w", hash, err, fs.ErrNotExist)
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+func (g embeddedGit) ReadBlobs(hashes []string, handler func(hash string, contents []byte) error) error {
+	for _, hash := range hashes {
+		contents, err := g.ReadBlob(hash)
+		if err != nil {
+			return err
+		}
+		if err := handler(hash, contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g embeddedGit) ReadTreeBlobs(path GitPath, include func(entry gitism.TreeEntry) bool, handler func(entry gitism.TreeEntry, contents []byte) error) error {
+	return readTreeBlobs(g, path, include, handler)
+}
+
+func (g embeddedGit) BlobSize(hash string) (int64, error) {
+	name, err := objectHash(hash)
+	if err != nil {
+		return 0, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	size, err := g.repository.Storer.EncodedObjectSize(name)
+	if err != nil {
+		return 0, fmt.Errorf("no object %s: %v: %w", hash, err, fs.ErrNotExist)
+	}
+	return size, nil
+}
+
+func (g embeddedGit) ResolveCommit(ref Ref) (string, error) {
+	revision, err := g.revision(ref)
+	if err != nil {
+		return "", err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	commit, err := g.commit(revision)
+	if err != nil {
+		return "", err
+	}
+	return commit.Hash.String(), nil
+}
+
+func (g embeddedGit) VerifyCommit(commit string) error {
+	return fmt.Errorf("%w: the embedded git backend can't check the signature of commit %s", ErrBadSignature, commit)
+}
+
+func (g embeddedGit) VerifyTag(tag string) error {
+	return fmt.Errorf("%w: the embedded git backend can't check the signature of tag %s", ErrBadSignature, tag)
+}
+
+func (g embeddedGit) ListWorktreeChanges(worktree string, handler func(path string) error) error {
+	return fmt.Errorf("listing the changes in worktree '%s' is %w", worktree, ErrEmbeddedUnsupported)
+}
+
+// ReadNote looks commit's note up in the tree of notesRef, whose files are named after the objects they annotate,
+// possibly split into directories by the leading characters of the name.
+func (g embeddedGit) ReadNote(notesRef string, commit string) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	annotated, err := g.commit(commit)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := g.commit(notesRef)
+	if err != nil {
+		return nil, ErrNoNote
+	}
+	tree, err := notes.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var note *object.File
+	err = tree.Files().ForEach(func(file *object.File) error {
+		if strings.ReplaceAll(file.Name, "/", "") == annotated.Hash.String() {
+			note = file
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if note == nil {
+		return nil, ErrNoNote
+	}
+	contents, err := note.Contents()
+	return []byte(contents), err
+}
+
+// tagged lists the names of the tags pointing at each commit, in order. Must be called with g.mu held.
+func (g embeddedGit) tagged() (map[plumbing.Hash][]string, error) {
+	refs, err := g.repository.Tags()
+	if err != nil {
+		return nil, err
+	}
+	tagged := map[plumbing.Hash][]string{}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		// Tags of trees and blobs don't resolve to commits and can't describe one.
+		if commit, err := g.repository.ResolveRevision(plumbing.Revision(ref.Name())); err == nil {
+			tagged[*commit] = append(tagged[*commit], ref.Name().Short())
+		}
+		return nil
+	})
+	for _, names := range tagged {
+		sort.Strings(names)
+	}
+	return tagged, err
+}
+
+// Describe names commit after the tag the fewest commits behind it of the first few tags found walking back from it,
+// like git describe --tags --always.
+func (g embeddedGit) Describe(commit string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	start, err := g.commit(commit)
+	if err != nil {
+		return "", err
+	}
+	tagged, err := g.tagged()
+	if err != nil {
+		return "", err
+	}
+	reachable, err := g.reachable(start)
+	if err != nil {
+		return "", err
+	}
+
+	best, distance, candidates := "", 0, 0
+	err = g.walk(start, false, func(walked *object.Commit) (bool, error) {
+		names, ok := tagged[walked.Hash]
+		if !ok {
+			return false, nil
+		}
+		behind, err := g.reachable(walked)
+		if err != nil {
+			return true, err
+		}
+		ahead := 0
+		for reached := range reachable {
+			if !behind[reached] {
+				ahead++
+			}
+		}
+		if best == "" || ahead < distance {
+			best, distance = names[0], ahead
+		}
+		candidates++
+		return candidates == describeCandidates, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	hash := start.Hash.String()
+	switch {
+	case best == "":
+		return hash[:7], nil
+	case distance == 0:
+		return best, nil
+	default:
+		return fmt.Sprintf("%s-%d-g%s", best, distance, hash[:7]), nil
+	}
+}
+
+// hashAt is the hash of the object at treePath in commit or "" if there is nothing there. Must be called with g.mu
+// held.
+func (g embeddedGit) hashAt(commit *object.Commit, treePath string) (string, error) {
+	root, err := commit.Tree()
+	if err != nil {
+		return "", err
+	}
+	entry, ok, err := embeddedTree{repository: g.repository, root: root}.find(treePath)
+	if err != nil || !ok {
+		return "", err
+	}
+	return entry.Hash, nil
+}
+
+// lastCommit is the newest commit reachable from commit that changed something at or under treePath. Commits that
+// kept treePath the same as one of their parents didn't change it, like git log's default history simplification.
+// Must be called with g.mu held.
+func (g embeddedGit) lastCommit(commit string, treePath string) (*object.Commit, error) {
+	start, err := g.commit(commit)
+	if err != nil {
+		return nil, err
+	}
+	treePath, _ = cleanTreePath(treePath)
+
+	var found *object.Commit
+	err = g.walk(start, false, func(walked *object.Commit) (bool, error) {
+		if treePath == "" {
+			found = walked
+			return true, nil
+		}
+		current, err := g.hashAt(walked, treePath)
+		if err != nil {
+			return true, err
+		}
+		if current == "" && len(walked.ParentHashes) == 0 {
+			return false, nil
+		}
+		for _, hash := range walked.ParentHashes {
+			parent, err := g.repository.CommitObject(hash)
+			if err != nil {
+				return true, err
+			}
+			previous, err := g.hashAt(parent, treePath)
+			if err != nil || previous == current {
+				return err != nil, err
+			}
+		}
+		found = walked
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no commit changed '%s'", treePath)
+	}
+	return found, nil
+}
+
+func (g embeddedGit) LastModified(commit string, path string) (time.Time, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	found, err := g.lastCommit(commit, path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(found.Committer.When.Unix(), 0), nil
+}
+
+func (g embeddedGit) LastCommit(commit string, path string) (gitism.CommitInfo, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	found, err := g.lastCommit(commit, path)
+	if err != nil {
+		return gitism.CommitInfo{}, err
+	}
+	return commitInfo(found), nil
+}
+
+// commitInfo describes commit the way git log does with gitism.CommitInfoFormat.
+func commitInfo(commit *object.Commit) gitism.CommitInfo {
+	return gitism.CommitInfo{
+		Hash:        commit.Hash.String(),
+		AuthorName:  commit.Author.Name,
+		AuthorEmail: commit.Author.Email,
+		AuthorTime:  time.Unix(commit.Author.When.Unix(), 0),
+		Message:     strings.TrimRight(commit.Message, "\n"),
+	}
+}
+
+func (g embeddedGit) FileHistory(ref Ref, path string, handler func(commit string, change gitism.Change) error) error {
+	return followFileHistory(g, ref, path, handler)
+}
+
+func (g embeddedGit) SearchCommits(ref Ref, pattern string, limit int, handler func(commit gitism.CommitInfo) error) error {
+	matcher, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	var found []gitism.CommitInfo
+	g.mu.Lock()
+	revision, err := g.revision(ref)
+	if err == nil {
+		var start *object.Commit
+		start, err = g.commit(revision)
+		if err == nil {
+			err = g.walk(start, false, func(commit *object.Commit) (bool, error) {
+				info := commitInfo(commit)
+				if len(MatchingLines(matcher, info.Message)) > 0 {
+					found = append(found, info)
+				}
+				return len(found) == limit, nil
+			})
+		}
+	}
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, commit := range found {
+		if err := handler(commit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g embeddedGit) Bisect(bad string, good []string) (gitism.BisectStep, error) {
+	return gitism.BisectStep{}, fmt.Errorf("bisecting is %w", ErrEmbeddedUnsupported)
+}
+
+// ObjectFormat is always SHA-1 since NewEmbeddedGit refuses repositories using anything else.
+func (g embeddedGit) ObjectFormat() (gitism.ObjectFormat, error) {
+	return gitism.SHA1, nil
+}
+
+// CountObjects counts the objects in the repository's object directory like git count-objects -v, except that sizes
+// are of the files rather than the disk space they take up.
+func (g embeddedGit) CountObjects() (gitism.ObjectCounts, error) {
+	var counts gitism.ObjectCounts
+	objects := filepath.Join(g.directory, "objects")
+	directories, err := ioutil.ReadDir(objects)
+	if err != nil {
+		return counts, err
+	}
+	var looseSize, packSize int64
+	for _, directory := range directories {
+		if len(directory.Name()) != 2 || !isHex(directory.Name()) || !directory.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(objects, directory.Name()))
+		if err != nil {
+			return counts, err
+		}
+		for _, file := range files {
+			if isHex(file.Name()) && len(directory.Name()+file.Name()) == gitism.SHA1.HashLength() {
+				counts.Loose++
+				looseSize += file.Size()
+			}
+		}
+	}
+
+	packs, err := filepath.Glob(filepath.Join(objects, "pack", "*.pack"))
+	if err != nil {
+		return counts, err
+	}
+	for _, pack := range packs {
+		index := strings.TrimSuffix(pack, ".pack") + ".idx"
+		packed, indexSize, err := packIndexObjects(index)
+		if err != nil {
+			// git doesn't count packs without an index either.
+			continue
+		}
+		info, err := os.Stat(pack)
+		if err != nil {
+			return counts, err
+		}
+		counts.Packs++
+		counts.Packed += packed
+		packSize += info.Size() + indexSize
+	}
+	counts.LooseSize, counts.PackSize = looseSize/1024, packSize/1024
+	return counts, nil
+}
+
+// isHex reports if name is made up only of lowercase hexadecimal digits, like the names of loose objects.
+func isHex(name string) bool {
+	return strings.Trim(name, "0123456789abcdef") == ""
+}
+
+// packIndexMagic starts every pack index but the first version, which starts with its fan-out table.
+var packIndexMagic = []byte{0xff, 't', 'O', 'c'}
+
+// packIndexObjects reads how many objects the pack index at path has from the last entry of its fan-out table, and
+// returns its size.
+func packIndexObjects(path string) (int64, int64, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	fanout := contents
+	if bytes.HasPrefix(contents, packIndexMagic) {
+		fanout = contents[8:]
+	}
+	if len(fanout) < 256*4 {
+		return 0, 0, fmt.Errorf("pack index '%s' is truncated", path)
+	}
+	return int64(binary.BigEndian.Uint32(fanout[255*4:])), int64(len(contents)), nil
+}
+
+// embeddedTree answers ls-tree for a tree read with go-git, the way pathTree does for a tree made up from paths.
+type embeddedTree struct {
+	repository *gogit.Repository
+	root       *object.Tree
+	// sized is set to look up the size of every blob listed, which ls-tree --long prints.
+	sized bool
+}
+
+// entry describes the entry of a tree at entryPath.
+func (t embeddedTree) entry(entry object.TreeEntry, entryPath string) (gitism.TreeEntry, error) {
+	described := gitism.TreeEntry{
+		Mode: gitism.NewFileMode(uint16(entry.Mode)),
+		Hash: entry.Hash.String(),
+		Size: "-",
+		Path: entryPath,
+	}
+	switch entry.Mode {
+	case filemode.Dir:
+		described.Object = gitism.TreeObject
+	case filemode.Submodule:
+		described.Object = gitism.CommitObject
+	default:
+		described.Object = gitism.BlobObject
+		if t.sized {
+			size, err := t.repository.Storer.EncodedObjectSize(entry.Hash)
+			if err != nil {
+				return gitism.TreeEntry{}, fmt.Errorf("could not find the size of '%s': %v", entryPath, err)
+			}
+			described.Size = fmt.Sprint(size)
+		}
+	}
+	return described, nil
+}
+
+// find returns the entry at treePath, which must be clean, with the root at "".
+func (t embeddedTree) find(treePath string) (gitism.TreeEntry, bool, error) {
+	if treePath == "" {
+		return gitism.TreeEntry{Mode: gitism.NewFileMode(0040000), Object: gitism.TreeObject, Hash: t.root.Hash.String(), Size: "-"}, true, nil
+	}
+	tree := t.root
+	parts := strings.Split(treePath, "/")
+	for i, part := range parts {
+		var found *object.TreeEntry
+		for j := range tree.Entries {
+			if tree.Entries[j].Name == part {
+				found = &tree.Entries[j]
+				break
+			}
+		}
+		if found == nil {
+			return gitism.TreeEntry{}, false, nil
+		}
+		if i == len(parts)-1 {
+			entry, err := t.entry(*found, treePath)
+			return entry, err == nil, err
+		}
+		if found.Mode != filemode.Dir {
+			return gitism.TreeEntry{}, false, nil
+		}
+		subtree, err := t.repository.TreeObject(found.Hash)
+		if err != nil {
+			return gitism.TreeEntry{}, false, err
+		}
+		tree = subtree
+	}
+	return gitism.TreeEntry{}, false, nil
+}
+
+// children calls handler with every entry of the directory at treePath, described by entry.
+func (t embeddedTree) children(treePath string, directory gitism.TreeEntry, handler func(entry gitism.TreeEntry) error) error {
+	tree, err := t.repository.TreeObject(plumbing.NewHash(directory.Hash))
+	if err != nil {
+		return err
+	}
+	for _, child := range tree.Entries {
+		childPath := child.Name
+		if treePath != "" {
+			childPath = treePath + "/" + child.Name
+		}
+		entry, err := t.entry(child, childPath)
+		if err != nil {
+			return err
+		}
+		if err := handler(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list is Git.ListTree for the tree.
+func (t embeddedTree) list(treePath string, handler func(entry gitism.TreeEntry) error) error {
+	treePath, listChildren := cleanTreePath(treePath)
+	entry, ok, err := t.find(treePath)
+	if err != nil || !ok {
+		return err
+	}
+	if !listChildren || entry.Object == gitism.CommitObject {
+		// Like ls-tree, a submodule lists itself when its children are asked for.
+		return handler(entry)
+	}
+	if entry.Object != gitism.TreeObject {
+		return nil
+	}
+	return t.children(treePath, entry, handler)
+}
+
+// listDirectory is Git.ListDirectory for the tree.
+func (t embeddedTree) listDirectory(treePath string, handler func(entry gitism.TreeEntry) error) error {
+	treePath, listChildren := cleanTreePath(treePath)
+	entry, ok, err := t.find(treePath)
+	if err != nil || !ok || entry.Object == gitism.BlobObject {
+		return err
+	}
+	if listChildren && entry.Object == gitism.CommitObject {
+		// Like ls-tree -t, the children of a submodule are nothing rather than the submodule itself.
+		return nil
+	}
+	if err := handler(entry); err != nil {
+		return err
+	}
+	if entry.Object != gitism.TreeObject {
+		return nil
+	}
+	return t.children(treePath, entry, handler)
+}
+
+// listRecursive is Git.ListTreeRecursive for the tree.
+func (t embeddedTree) listRecursive(treePath string, handler func(entry gitism.TreeEntry) error) error {
+	treePath, _ = cleanTreePath(treePath)
+	entry, ok, err := t.find(treePath)
+	if err != nil || !ok {
+		return err
+	}
+	return t.listEntryRecursive(treePath, entry, handler)
+}
+
+func (t embeddedTree) listEntryRecursive(treePath string, entry gitism.TreeEntry, handler func(entry gitism.TreeEntry) error) error {
+	if entry.Object != gitism.TreeObject {
+		return handler(entry)
+	}
+	return t.children(treePath, entry, func(child gitism.TreeEntry) error {
+		return t.listEntryRecursive(child.Path, child, handler)
+	})
+}
@@ -0,0 +1,22 @@
+//go:build embeddedgit
+// +build embeddedgit
+
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+// embeddedFallback is set by building with the embeddedgit tag, which makes NewCliGit read repositories with the
+// embedded git backend when there is no git executable, so one static binary works where git isn't installed.
+const embeddedFallback = true
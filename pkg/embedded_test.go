@@ -0,0 +1,266 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"os/exec"
+	"testing"
+)
+
+// gitSurvey is everything a Git reports about the master branch of a repository, for comparing two of them.
+type gitSurvey struct {
+	Branches, Tags []string
+	Commits        []gitism.GraphCommit
+	Changes        map[string][]gitism.Change
+	Descriptions   map[string]string
+	Listings       map[string][]gitism.TreeEntry
+	Names          map[string][]string
+	LastCommits    map[string]gitism.CommitInfo
+	Searches       map[string][]gitism.CommitInfo
+	Histories      map[string][]string
+	Blobs          map[string]string
+	TreeBlobs      map[string]string
+}
+
+func surveyGit(t *testing.T, git Git) gitSurvey {
+	survey := gitSurvey{
+		Changes:      map[string][]gitism.Change{},
+		Descriptions: map[string]string{},
+		Listings:     map[string][]gitism.TreeEntry{},
+		Names:        map[string][]string{},
+		LastCommits:  map[string]gitism.CommitInfo{},
+		Searches:     map[string][]gitism.CommitInfo{},
+		Histories:    map[string][]string{},
+		Blobs:        map[string]string{},
+		TreeBlobs:    map[string]string{},
+	}
+	collectNames := func(names *[]string) func(name string) error {
+		return func(name string) error {
+			*names = append(*names, name)
+			return nil
+		}
+	}
+	if err := git.ListBranches(collectNames(&survey.Branches)); err != nil {
+		t.Fatalf("ListBranches() failed: %v", err)
+	}
+	if err := git.ListTags(collectNames(&survey.Tags)); err != nil {
+		t.Fatalf("ListTags() failed: %v", err)
+	}
+
+	master := BranchRef("master")
+	err := git.WalkCommits(master, false, func(commit gitism.GraphCommit) error {
+		survey.Commits = append(survey.Commits, commit)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkCommits() failed: %v", err)
+	}
+	for _, commit := range survey.Commits {
+		err := git.ListChanges(commit.Hash, func(change gitism.Change) error {
+			survey.Changes[commit.Hash] = append(survey.Changes[commit.Hash], change)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ListChanges(%s) failed: %v", commit.Hash, err)
+		}
+		if survey.Descriptions[commit.Hash], err = git.Describe(commit.Hash); err != nil {
+			t.Fatalf("Describe(%s) failed: %v", commit.Hash, err)
+		}
+	}
+
+	for _, pattern := range []string{"^Add", "file|link", "missing"} {
+		err := git.SearchCommits(master, pattern, 2, func(commit gitism.CommitInfo) error {
+			survey.Searches[pattern] = append(survey.Searches[pattern], commit)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("SearchCommits(%q) failed: %v", pattern, err)
+		}
+	}
+
+	var paths []string
+	err = git.ListTreeRecursive(GitPath{Reference: master}, func(entry gitism.TreeEntry) error {
+		paths = append(paths, entry.Path)
+		if entry.Object == gitism.BlobObject {
+			contents, err := git.ReadBlob(entry.Hash)
+			survey.Blobs[entry.Hash] = string(contents)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListTreeRecursive() failed: %v", err)
+	}
+	err = git.ReadTreeBlobs(GitPath{Reference: master}, nil, func(entry gitism.TreeEntry, contents []byte) error {
+		survey.TreeBlobs[entry.Path] = string(contents)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadTreeBlobs() failed: %v", err)
+	}
+	for _, path := range paths {
+		err := git.FileHistory(master, path, func(commit string, change gitism.Change) error {
+			survey.Histories[path] = append(survey.Histories[path], commit+" "+change.Path+" "+change.Hash)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("FileHistory(%q) failed: %v", path, err)
+		}
+	}
+	for _, path := range append(paths, ".", "missing") {
+		for _, treePath := range []string{path, path + "/"} {
+			collect := func(kind string) func(entry gitism.TreeEntry) error {
+				return func(entry gitism.TreeEntry) error {
+					survey.Listings[kind+" "+treePath] = append(survey.Listings[kind+" "+treePath], entry)
+					return nil
+				}
+			}
+			gitPath := GitPath{Reference: master, TreePath: treePath}
+			if err := git.ListTree(gitPath, collect("tree")); err != nil {
+				t.Fatalf("ListTree(%q) failed: %v", treePath, err)
+			}
+			// git lists the children of the root without the root itself, which is never asked for.
+			if path != "." {
+				if err := git.ListDirectory(gitPath, collect("directory")); err != nil {
+					t.Fatalf("ListDirectory(%q) failed: %v", treePath, err)
+				}
+			}
+			names := survey.Names[treePath]
+			if err := git.ListTreeNames(gitPath, collectNames(&names)); err != nil {
+				t.Fatalf("ListTreeNames(%q) failed: %v", treePath, err)
+			}
+			survey.Names[treePath] = names
+		}
+		if path == "missing" {
+			continue
+		}
+		if survey.LastCommits[path], err = git.LastCommit("master", path); err != nil {
+			t.Fatalf("LastCommit(%q) failed: %v", path, err)
+		}
+	}
+	return survey
+}
+
+func TestEmbeddedGitMatchesGit(t *testing.T) {
+	for _, playbook := range []string{"base", "merges", "rename", "submodule", "symlinks", "tags", "executables"} {
+		t.Run(playbook, func(t *testing.T) {
+			repository, err := runPlaybook(playbook, t.TempDir())
+			if err != nil {
+				t.Fatalf("playbook '%s' failed: %v", playbook, err)
+			}
+			cli, err := NewCliGit(repository)
+			if err != nil {
+				t.Fatal(err)
+			}
+			embedded, err := NewEmbeddedGit(repository)
+			if err != nil {
+				t.Fatalf("NewEmbeddedGit() failed: %v", err)
+			}
+			if diff := cmp.Diff(surveyGit(t, cli), surveyGit(t, embedded)); diff != "" {
+				t.Fatalf("the embedded git backend doesn't match git: %s", diff)
+			}
+			compareCounts(t, cli, embedded)
+
+			// Everything has to read the same from packs as it did from loose objects.
+			if output, err := exec.Command("git", "--git-dir", repository, "gc", "--quiet").CombinedOutput(); err != nil {
+				t.Fatalf("git gc failed: %v: %s", err, output)
+			}
+			if embedded, err = NewEmbeddedGit(repository); err != nil {
+				t.Fatalf("NewEmbeddedGit() failed: %v", err)
+			}
+			if diff := cmp.Diff(surveyGit(t, cli), surveyGit(t, embedded)); diff != "" {
+				t.Fatalf("the embedded git backend doesn't match git once packed: %s", diff)
+			}
+			compareCounts(t, cli, embedded)
+
+			for _, ref := range []Ref{BranchRef("master"), CommitRef("HEAD"), CommitRef("master~1")} {
+				want, err := cli.ResolveCommit(ref)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err := embedded.ResolveCommit(ref); err != nil || got != want {
+					t.Fatalf("ResolveCommit(%v) = %s, %v, want %s", ref, got, err, want)
+				}
+			}
+			if _, err := embedded.ResolveCommit(BranchRef("missing")); err == nil {
+				t.Fatal("resolved a branch that doesn't exist")
+			}
+		})
+	}
+}
+
+func compareCounts(t *testing.T, cli, embedded Git) {
+	want, err := cli.CountObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := embedded.CountObjects()
+	if err != nil {
+		t.Fatalf("CountObjects() failed: %v", err)
+	}
+	if got.Loose != want.Loose || got.Packed != want.Packed || got.Packs != want.Packs {
+		t.Fatalf("CountObjects() = %+v, want %+v", got, want)
+	}
+}
+
+func TestEmbeddedGitUnsupported(t *testing.T) {
+	repository, err := runPlaybook("base", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	git, err := NewEmbeddedGit(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Backend(git) != "embedded" {
+		t.Fatalf("Backend() = %q", Backend(git))
+	}
+	if err := git.VerifyCommit("HEAD"); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("VerifyCommit() = %v, want ErrBadSignature", err)
+	}
+	if _, err := git.Bisect("HEAD", nil); !errors.Is(err, ErrEmbeddedUnsupported) {
+		t.Fatalf("Bisect() = %v, want ErrEmbeddedUnsupported", err)
+	}
+	if err := Maintain(git, gitism.MaintenanceGC); !errors.Is(err, ErrMaintenanceUnsupported) {
+		t.Fatalf("Maintain() = %v, want ErrMaintenanceUnsupported", err)
+	}
+	if _, err := NewEmbeddedGit(t.TempDir()); err == nil {
+		t.Fatal("opened a directory that isn't a repository")
+	}
+}
+
+func TestEmbeddedFallback(t *testing.T) {
+	repository, err := runPlaybook("base", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An empty $PATH has no git to find.
+	git, err := NewCliGit(repository, WithPath(t.TempDir()))
+	if !embeddedFallback {
+		if !errors.Is(err, gitism.ErrGitNotFound) {
+			t.Fatalf("NewCliGit() = %v, want ErrGitNotFound", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("NewCliGit() didn't fall back to the embedded git backend: %v", err)
+	}
+	if Backend(git) != "embedded" {
+		t.Fatalf("Backend() = %q, want embedded", Backend(git))
+	}
+}
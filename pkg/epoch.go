@@ -0,0 +1,108 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// EpochFile is the name of the file in IntrospectionDirectory holding the epoch of what is served.
+const EpochFile = "epoch"
+
+// epochs count the changes to what the process serves. The epoch of a ref of a repository is the number of times
+// caches were invalidated anywhere in the process plus the number of times the ref was seen to move, so it only ever
+// grows and a tool that sees it unchanged knows the tree it read is still the one being served. It is kept for the
+// whole process so it keeps growing across reloads, which replace the filesystems reading it.
+var epochs = struct {
+	sync.Mutex
+	invalidations uint64
+	served        map[string]*servedEpoch
+}{served: map[string]*servedEpoch{}}
+
+// servedEpoch is what was last seen of a ref.
+type servedEpoch struct {
+	commit string
+	moves  uint64
+	// previous is the commit the ref resolved to before it last moved, see ChangelogFile.
+	previous string
+}
+
+// advanceEpoch advances the epoch of everything served after caches were dropped or the filesystems serving them
+// were replaced.
+func advanceEpoch() {
+	epochs.Lock()
+	defer epochs.Unlock()
+	epochs.invalidations++
+}
+
+func epochKey(repository string, reference Ref) string {
+	return repository + "\x00" + reference.String()
+}
+
+// observeEpoch returns the epoch of reference of repository now that it resolves to commit, advancing it if the
+// reference moved since it was last observed. previous is the commit it resolved to before it last moved, and empty
+// if it never did.
+func observeEpoch(repository string, reference Ref, commit string) (epoch uint64, previous string) {
+	key := epochKey(repository, reference)
+	epochs.Lock()
+	defer epochs.Unlock()
+	served, ok := epochs.served[key]
+	if !ok {
+		served = &servedEpoch{commit: commit}
+		epochs.served[key] = served
+	}
+	if served.commit != commit {
+		served.previous = served.commit
+		served.commit = commit
+		served.moves++
+	}
+	return epochs.invalidations + served.moves, served.previous
+}
+
+// FollowRef carries what was seen of from over to to when a mount starts serving repository at to in place of from,
+// like when the name a RefResolver resolves moves to another commit. The epoch of to keeps growing from where from
+// left off, and the changelog of to lists the commits since the one served at from.
+func FollowRef(repository string, from Ref, to Ref) {
+	if from == to {
+		return
+	}
+	epochs.Lock()
+	defer epochs.Unlock()
+	followed := servedEpoch{}
+	if served, ok := epochs.served[epochKey(repository, from)]; ok {
+		followed = *served
+	} else if from.Kind == RefCommit {
+		followed.commit = from.Name
+	} else {
+		return
+	}
+	epochs.served[epochKey(repository, to)] = &followed
+}
+
+// readEpoch generates .gitfs/epoch. The ref is resolved on every read so a move is noticed by whoever looks first.
+// Its modification time is the epoch in seconds since 1970, so statting it is enough to tell it advanced even when
+// its size stays the same.
+func (s introspectionFileSystem) readEpoch() ([]byte, os.FileInfo, error) {
+	commit, err := s.git.ResolveCommit(s.reference)
+	if err != nil {
+		return nil, nil, err
+	}
+	epoch, _ := observeEpoch(s.repository, s.reference, commit)
+	contents := []byte(fmt.Sprintf("%d\n", epoch))
+	return contents, introspectionInfo{name: EpochFile, mode: 0444, size: int64(len(contents)), modTime: time.Unix(int64(epoch), 0)}, nil
+}
@@ -0,0 +1,840 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io/fs"
+	"math/bits"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoWorktree is returned by FakeGit.ListWorktreeChanges as a FakeGit only has commits.
+var ErrNoWorktree = errors.New("repository has no worktree")
+
+// The modes git records files with, for FakeFile.Mode.
+const (
+	FakeModeRegular    uint16 = 0100644
+	FakeModeExecutable uint16 = 0100755
+	FakeModeSymlink    uint16 = 0120000
+	FakeModeSubmodule  uint16 = 0160000
+)
+
+const (
+	fakeAuthorName  = "gitfs"
+	fakeAuthorEmail = "gitfs@example.com"
+)
+
+// FakeFile is a file committed to a FakeGit.
+type FakeFile struct {
+	// Contents is what a file holds, the target of a symlink, or the hash of a submodule's commit.
+	Contents string
+	// Mode is the mode git records for the file. The zero value is FakeModeRegular.
+	Mode uint16
+}
+
+type fakeCommit struct {
+	info    gitism.CommitInfo
+	parents []string
+	files   map[string]FakeFile
+	tree    *pathTree
+	// sequence orders commits made at the same time, newest last.
+	sequence int
+}
+
+// newerThan orders commits the way git rev-list prints them.
+func (c *fakeCommit) newerThan(other *fakeCommit) bool {
+	if !c.info.AuthorTime.Equal(other.info.AuthorTime) {
+		return c.info.AuthorTime.After(other.info.AuthorTime)
+	}
+	return c.sequence > other.sequence
+}
+
+// hashAt is the hash of the object at treePath or "" if there is nothing there.
+func (c *fakeCommit) hashAt(treePath string) string {
+	treePath, _ = cleanTreePath(treePath)
+	return c.tree.entries[treePath].Hash
+}
+
+// FakeGit is a Git whose commits are made in Go and kept in memory, for testing code built on Git without running
+// git. Objects are hashed exactly like git would, so trees committed to a FakeGit have the same hashes as the same
+// trees committed with git.
+type FakeGit struct {
+	clock func() time.Time
+	// ownClock is set when FakeGit keeps time itself, in which case every commit is made a second after the last.
+	ownClock *FakeClock
+
+	mu       sync.Mutex
+	blobs    map[string][]byte
+	commits  map[string]*fakeCommit
+	branches map[string]string
+	tags     map[string]string
+	notes    map[string]map[string][]byte
+	// head is the branch HEAD points to, which is the first one committed to.
+	head string
+	// handles are the files open from filesystems of the repository.
+	handles *openHandles
+}
+
+// NewFakeGit makes an empty repository whose commits are dated by clock. A nil clock dates the first commit at the
+// start of 2021 and every one after it a second later.
+func NewFakeGit(clock func() time.Time) *FakeGit {
+	g := &FakeGit{
+		clock:    clock,
+		blobs:    map[string][]byte{},
+		commits:  map[string]*fakeCommit{},
+		branches: map[string]string{},
+		tags:     map[string]string{},
+		notes:    map[string]map[string][]byte{},
+		handles:  newOpenHandles(),
+	}
+	if clock == nil {
+		g.ownClock = NewFakeClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+		g.clock = g.ownClock.Now
+	}
+	return g
+}
+
+// fakeObjectHash names an object of kind with contents the same way git does.
+func fakeObjectHash(kind string, contents []byte) string {
+	hash := sha1.New()
+	_, _ = fmt.Fprintf(hash, "%s %d\x00", kind, len(contents))
+	_, _ = hash.Write(contents)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Commit commits files, keyed by their path, on top of branch and moves branch to the new commit, which is returned.
+// Files already on branch are kept unless they are replaced.
+func (g *FakeGit) Commit(branch, message string, files map[string]FakeFile) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var parents []string
+	if tip, ok := g.branches[branch]; ok {
+		parents = append(parents, tip)
+	}
+	return g.commit(branch, message, parents, files)
+}
+
+// Merge commits files on top of branch with other, a revision, as its second parent.
+func (g *FakeGit) Merge(branch, other, message string, files map[string]FakeFile) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	tip, ok := g.branches[branch]
+	if !ok {
+		return "", fmt.Errorf("no branch '%s' to merge into: %w", branch, fs.ErrNotExist)
+	}
+	merged, err := g.revision(other)
+	if err != nil {
+		return "", err
+	}
+	return g.commit(branch, message, []string{tip, merged}, files), nil
+}
+
+// commit makes a commit of files on top of the first of parents and points branch at it. Must be called with g.mu
+// held.
+func (g *FakeGit) commit(branch, message string, parents []string, files map[string]FakeFile) string {
+	merged := map[string]FakeFile{}
+	if len(parents) > 0 {
+		for name, file := range g.commits[parents[0]].files {
+			merged[name] = file
+		}
+	}
+	for name, file := range files {
+		merged[path.Clean(strings.TrimPrefix(name, "/"))] = file
+	}
+	tree, treeHash := g.buildTree(merged)
+
+	when := g.clock()
+	if g.ownClock != nil {
+		g.ownClock.Advance(time.Second)
+	}
+	var object strings.Builder
+	fmt.Fprintf(&object, "tree %s\n", treeHash)
+	for _, parent := range parents {
+		fmt.Fprintf(&object, "parent %s\n", parent)
+	}
+	signature := fmt.Sprintf("%s <%s> %d +0000", fakeAuthorName, fakeAuthorEmail, when.Unix())
+	fmt.Fprintf(&object, "author %s\ncommitter %s\n\n%s\n", signature, signature, message)
+	hash := fakeObjectHash("commit", []byte(object.String()))
+
+	g.commits[hash] = &fakeCommit{
+		info: gitism.CommitInfo{
+			Hash:        hash,
+			AuthorName:  fakeAuthorName,
+			AuthorEmail: fakeAuthorEmail,
+			AuthorTime:  time.Unix(when.Unix(), 0),
+			Message:     message,
+		},
+		parents:  parents,
+		files:    merged,
+		tree:     tree,
+		sequence: len(g.commits),
+	}
+	if g.head == "" {
+		g.head = branch
+	}
+	g.branches[branch] = hash
+	return hash
+}
+
+// buildTree lists files the way ls-tree would and hashes every tree in it. Must be called with g.mu held.
+func (g *FakeGit) buildTree(files map[string]FakeFile) (*pathTree, string) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	// Sorting whole paths puts every directory's entries in the same order as git's trees.
+	sort.Strings(names)
+
+	tree := newPathTree()
+	modes := map[string]uint16{}
+	for _, name := range names {
+		file := files[name]
+		mode := file.Mode
+		if mode == 0 {
+			mode = FakeModeRegular
+		}
+		entry := gitism.TreeEntry{Mode: gitism.NewFileMode(mode), Path: name}
+		if mode == FakeModeSubmodule {
+			entry.Object, entry.Hash, entry.Size = gitism.CommitObject, file.Contents, "-"
+		} else {
+			contents := []byte(file.Contents)
+			entry.Object, entry.Hash, entry.Size = gitism.BlobObject, fakeObjectHash("blob", contents), strconv.Itoa(len(contents))
+			g.blobs[entry.Hash] = contents
+		}
+		modes[name] = mode
+		tree.add(entry)
+	}
+	return tree, hashFakeTree(tree, modes, "")
+}
+
+// hashFakeTree fills in the hash of the directory dir in tree, and of every directory in it, and returns it.
+func hashFakeTree(tree *pathTree, modes map[string]uint16, dir string) string {
+	var object []byte
+	for _, child := range tree.children[dir] {
+		entry := tree.entries[child]
+		mode := modes[child]
+		if entry.Object == gitism.TreeObject {
+			entry.Hash = hashFakeTree(tree, modes, child)
+			mode = 040000
+		}
+		raw, _ := hex.DecodeString(entry.Hash)
+		object = append(object, fmt.Sprintf("%o %s\x00", mode, path.Base(child))...)
+		object = append(object, raw...)
+	}
+	entry := tree.entries[dir]
+	entry.Hash = fakeObjectHash("tree", object)
+	tree.entries[dir] = entry
+	return entry.Hash
+}
+
+// Branch points branch at revision.
+func (g *FakeGit) Branch(branch, revision string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	commit, err := g.revision(revision)
+	if err != nil {
+		return err
+	}
+	if g.head == "" {
+		g.head = branch
+	}
+	g.branches[branch] = commit
+	return nil
+}
+
+// Tag points tag at revision.
+func (g *FakeGit) Tag(tag, revision string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	commit, err := g.revision(revision)
+	if err != nil {
+		return err
+	}
+	g.tags[tag] = commit
+	return nil
+}
+
+// Note attaches contents to revision in notesRef, like refs/notes/commits.
+func (g *FakeGit) Note(notesRef, revision string, contents []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	commit, err := g.revision(revision)
+	if err != nil {
+		return err
+	}
+	if g.notes[notesRef] == nil {
+		g.notes[notesRef] = map[string][]byte{}
+	}
+	g.notes[notesRef][commit] = contents
+	return nil
+}
+
+// revision resolves a commit hash, an abbreviation of one, a branch, a tag, or HEAD to the hash of a commit. Must be
+// called with g.mu held.
+func (g *FakeGit) revision(name string) (string, error) {
+	switch {
+	case name == "":
+		return "", ErrNoTreeLikeSpecified
+	case name == "HEAD":
+		name = g.head
+	case strings.HasPrefix(name, branchPrefix):
+		if commit, ok := g.branches[strings.TrimPrefix(name, branchPrefix)]; ok {
+			return commit, nil
+		}
+		return "", fmt.Errorf("unknown revision '%s': %w", name, fs.ErrNotExist)
+	case strings.HasPrefix(name, tagPrefix):
+		if commit, ok := g.tags[strings.TrimPrefix(name, tagPrefix)]; ok {
+			return commit, nil
+		}
+		return "", fmt.Errorf("unknown revision '%s': %w", name, fs.ErrNotExist)
+	}
+	if commit, ok := g.branches[name]; ok {
+		return commit, nil
+	}
+	if commit, ok := g.tags[name]; ok {
+		return commit, nil
+	}
+	if _, ok := g.commits[name]; ok {
+		return name, nil
+	}
+	if len(name) >= 4 && isHash(name) {
+		var matched []string
+		for hash := range g.commits {
+			if strings.HasPrefix(hash, name) {
+				matched = append(matched, hash)
+			}
+		}
+		if len(matched) == 1 {
+			return matched[0], nil
+		}
+	}
+	return "", fmt.Errorf("unknown revision '%s': %w", name, fs.ErrNotExist)
+}
+
+// resolve is ResolveCommit. Must be called with g.mu held.
+func (g *FakeGit) resolve(ref Ref) (string, error) {
+	switch ref.Kind {
+	case RefIndex:
+		return "", ErrIndexRef
+	case RefBranch:
+		if commit, ok := g.branches[ref.Name]; ok {
+			return commit, nil
+		}
+		return "", fmt.Errorf("unknown branch '%s': %w", ref.Name, fs.ErrNotExist)
+	case RefTag:
+		if commit, ok := g.tags[ref.Name]; ok {
+			return commit, nil
+		}
+		return "", fmt.Errorf("unknown tag '%s': %w", ref.Name, fs.ErrNotExist)
+	default:
+		return g.revision(ref.Name)
+	}
+}
+
+// lookup returns the commit revision names.
+func (g *FakeGit) lookup(revision string) (*fakeCommit, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	hash, err := g.revision(revision)
+	if err != nil {
+		return nil, err
+	}
+	return g.commits[hash], nil
+}
+
+// tree returns the tree of the commit ref points to. Trees never change once committed so they can be listed without
+// holding g.mu, which lets handlers call back into g.
+func (g *FakeGit) tree(ref Ref) (*pathTree, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	hash, err := g.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	return g.commits[hash].tree, nil
+}
+
+// walk returns every commit reachable from start, newest first like git rev-list. Must be called with g.mu held.
+func (g *FakeGit) walk(start string, firstParent bool) []*fakeCommit {
+	seen := map[string]bool{start: true}
+	queue := []*fakeCommit{g.commits[start]}
+	var walked []*fakeCommit
+	for len(queue) > 0 {
+		sort.SliceStable(queue, func(i, j int) bool {
+			return queue[i].newerThan(queue[j])
+		})
+		next := queue[0]
+		queue = queue[1:]
+		walked = append(walked, next)
+
+		parents := next.parents
+		if firstParent && len(parents) > 1 {
+			parents = parents[:1]
+		}
+		for _, parent := range parents {
+			if !seen[parent] {
+				seen[parent] = true
+				queue = append(queue, g.commits[parent])
+			}
+		}
+	}
+	return walked
+}
+
+// reachable is the set of hashes of every commit reachable from start. Must be called with g.mu held.
+func (g *FakeGit) reachable(start string) map[string]bool {
+	hashes := map[string]bool{}
+	for _, commit := range g.walk(start, false) {
+		hashes[commit.info.Hash] = true
+	}
+	return hashes
+}
+
+func (g *FakeGit) ListTree(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	tree, err := g.tree(path.Reference)
+	if err != nil {
+		return err
+	}
+	return tree.list(path.TreePath, handler)
+}
+
+func (g *FakeGit) ListDirectory(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	tree, err := g.tree(path.Reference)
+	if err != nil {
+		return err
+	}
+	return tree.listDirectory(path.TreePath, handler)
+}
+
+func (g *FakeGit) ListTreeRecursive(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	tree, err := g.tree(path.Reference)
+	if err != nil {
+		return err
+	}
+	return tree.listRecursive(path.TreePath, handler)
+}
+
+func (g *FakeGit) ListTreeNames(path GitPath, handler func(path string) error) error {
+	tree, err := g.tree(path.Reference)
+	if err != nil {
+		return err
+	}
+	return tree.list(path.TreePath, func(entry gitism.TreeEntry) error {
+		return handler(entry.Path)
+	})
+}
+
+// sortedKeys lists the keys of refs in order like git branch and git tag do.
+func sortedKeys(refs map[string]string) []string {
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (g *FakeGit) ListBranches(handler func(branch string) error) error {
+	g.mu.Lock()
+	branches := sortedKeys(g.branches)
+	g.mu.Unlock()
+	for _, branch := range branches {
+		if err := handler(branch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *FakeGit) ListTags(handler func(branch string) error) error {
+	g.mu.Lock()
+	tags := sortedKeys(g.tags)
+	g.mu.Unlock()
+	for _, tag := range tags {
+		if err := handler(tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *FakeGit) ListCommits(ref Ref, handler func(branch string) error) error {
+	if ref.Kind == RefCommit {
+		return ErrCannotListCommit
+	}
+	return g.WalkCommits(ref, false, func(commit gitism.GraphCommit) error {
+		return handler(commit.Hash)
+	})
+}
+
+func (g *FakeGit) CommitParents(commit string) ([]string, error) {
+	found, err := g.lookup(commit)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), found.parents...), nil
+}
+
+func (g *FakeGit) WalkCommits(ref Ref, firstParent bool, handler func(commit gitism.GraphCommit) error) error {
+	g.mu.Lock()
+	hash, err := g.resolve(ref)
+	if err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	walked := g.walk(hash, firstParent)
+	g.mu.Unlock()
+
+	for _, commit := range walked {
+		graph := gitism.GraphCommit{Hash: commit.info.Hash, Parents: append([]string(nil), commit.parents...)}
+		if err := handler(graph); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListChanges compares commit to its first parent. Renames aren't detected, they are listed as a deletion and an
+// addition.
+func (g *FakeGit) ListChanges(commit string, handler func(change gitism.Change) error) error {
+	found, err := g.lookup(commit)
+	if err != nil {
+		return err
+	}
+	previous := newPathTree()
+	if len(found.parents) > 0 {
+		parent, err := g.lookup(found.parents[0])
+		if err != nil {
+			return err
+		}
+		previous = parent.tree
+	}
+
+	var paths []string
+	for name, entry := range found.tree.entries {
+		if entry.Object != gitism.TreeObject {
+			paths = append(paths, name)
+		}
+	}
+	for name, entry := range previous.entries {
+		if after, ok := found.tree.entries[name]; entry.Object != gitism.TreeObject && (!ok || after.Object == gitism.TreeObject) {
+			paths = append(paths, name)
+		}
+	}
+	sort.Strings(paths)
+
+	missing := gitism.SHA1.MissingHash()
+	for _, name := range paths {
+		before, existed := previous.entries[name]
+		after, exists := found.tree.entries[name]
+		existed = existed && before.Object != gitism.TreeObject
+		exists = exists && after.Object != gitism.TreeObject
+		change := gitism.Change{Path: name, PreviousHash: missing, Hash: missing}
+		switch {
+		case !existed:
+			change.Type, change.Hash, change.Mode = gitism.ChangeAddition, after.Hash, after.Mode
+		case !exists:
+			change.Type, change.PreviousHash, change.PreviousMode = gitism.ChangeDeletion, before.Hash, before.Mode
+		case before.Hash != after.Hash || before.Mode != after.Mode:
+			change.Type = gitism.ChangeModification
+			change.PreviousHash, change.PreviousMode = before.Hash, before.Mode
+			change.Hash, change.Mode = after.Hash, after.Mode
+		default:
+			continue
+		}
+		if err := handler(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *FakeGit) ReadBlob(hash string) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	contents, ok := g.blobs[hash]
+	if !ok {
+		return nil, fmt.Errorf("no blob %s: %w", hash, fs.ErrNotExist)
+	}
+	return contents, nil
+}
+
+func (g *FakeGit) ReadBlobs(hashes []string, handler func(hash string, contents []byte) error) error {
+	for _, hash := range hashes {
+		contents, err := g.ReadBlob(hash)
+		if err != nil {
+			return err
+		}
+		if err := handler(hash, contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *FakeGit) ReadTreeBlobs(path GitPath, include func(entry gitism.TreeEntry) bool, handler func(entry gitism.TreeEntry, contents []byte) error) error {
+	return readTreeBlobs(g, path, include, handler)
+}
+
+func (g *FakeGit) BlobSize(hash string) (int64, error) {
+	contents, err := g.ReadBlob(hash)
+	return int64(len(contents)), err
+}
+
+func (g *FakeGit) ResolveCommit(ref Ref) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resolve(ref)
+}
+
+// VerifyCommit always fails since nothing committed to a FakeGit is signed.
+func (g *FakeGit) VerifyCommit(commit string) error {
+	return fmt.Errorf("commit %s is not signed: %w", commit, ErrBadSignature)
+}
+
+// VerifyTag always fails since nothing committed to a FakeGit is signed.
+func (g *FakeGit) VerifyTag(tag string) error {
+	return fmt.Errorf("tag %s is not signed: %w", tag, ErrBadSignature)
+}
+
+func (g *FakeGit) ListWorktreeChanges(worktree string, handler func(path string) error) error {
+	return ErrNoWorktree
+}
+
+func (g *FakeGit) ReadNote(notesRef string, commit string) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	hash, err := g.revision(commit)
+	if err != nil {
+		return nil, err
+	}
+	note, ok := g.notes[notesRef][hash]
+	if !ok {
+		return nil, ErrNoNote
+	}
+	return note, nil
+}
+
+// Describe names commit after the tag the fewest commits behind it, like git describe --tags --always.
+func (g *FakeGit) Describe(commit string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	hash, err := g.revision(commit)
+	if err != nil {
+		return "", err
+	}
+	reachable := g.reachable(hash)
+
+	best, distance := "", 0
+	for _, tag := range sortedKeys(g.tags) {
+		tagged := g.tags[tag]
+		if !reachable[tagged] {
+			continue
+		}
+		ahead := 0
+		behind := g.reachable(tagged)
+		for reached := range reachable {
+			if !behind[reached] {
+				ahead += 1
+			}
+		}
+		if best == "" || ahead < distance {
+			best, distance = tag, ahead
+		}
+	}
+	switch {
+	case best == "":
+		return hash[:7], nil
+	case distance == 0:
+		return best, nil
+	default:
+		return fmt.Sprintf("%s-%d-g%s", best, distance, hash[:7]), nil
+	}
+}
+
+// lastCommit is the newest commit reachable from commit that changed something at or under treePath. Commits that
+// kept treePath the same as one of their parents didn't change it, like git log's default history simplification.
+func (g *FakeGit) lastCommit(commit string, treePath string) (*fakeCommit, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	hash, err := g.revision(commit)
+	if err != nil {
+		return nil, err
+	}
+	for _, walked := range g.walk(hash, false) {
+		if treePath == "" {
+			return walked, nil
+		}
+		current := walked.hashAt(treePath)
+		changed := current != ""
+		for _, parent := range walked.parents {
+			if g.commits[parent].hashAt(treePath) == current {
+				changed = false
+				break
+			}
+		}
+		if changed {
+			return walked, nil
+		}
+	}
+	return nil, fmt.Errorf("no commit changed '%s'", treePath)
+}
+
+func (g *FakeGit) LastModified(commit string, path string) (time.Time, error) {
+	found, err := g.lastCommit(commit, path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return found.info.AuthorTime, nil
+}
+
+func (g *FakeGit) LastCommit(commit string, path string) (gitism.CommitInfo, error) {
+	found, err := g.lastCommit(commit, path)
+	if err != nil {
+		return gitism.CommitInfo{}, err
+	}
+	return found.info, nil
+}
+
+func (g *FakeGit) SearchCommits(ref Ref, pattern string, limit int, handler func(commit gitism.CommitInfo) error) error {
+	matcher, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	hash, err := g.resolve(ref)
+	if err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	walked := g.walk(hash, false)
+	g.mu.Unlock()
+
+	found := 0
+	for _, commit := range walked {
+		if len(MatchingLines(matcher, commit.info.Message)) == 0 {
+			continue
+		}
+		if err := handler(commit.info); err != nil {
+			return err
+		}
+		if found++; found == limit {
+			break
+		}
+	}
+	return nil
+}
+
+func (g *FakeGit) FileHistory(ref Ref, path string, handler func(commit string, change gitism.Change) error) error {
+	return followFileHistory(g, ref, path, handler)
+}
+
+// estimateBisectSteps is how many more commits git expects to test when all could still be the first bad one.
+func estimateBisectSteps(all int) int {
+	if all < 3 {
+		return 0
+	}
+	n := bits.Len(uint(all)) - 1
+	e := 1 << n
+	if e < 3*(all-e) {
+		return n
+	}
+	return n - 1
+}
+
+// Bisect picks the commit that splits the candidates reachable from bad but not good most evenly, like git rev-list
+// --bisect-vars.
+func (g *FakeGit) Bisect(bad string, good []string) (gitism.BisectStep, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	badHash, err := g.revision(bad)
+	if err != nil {
+		return gitism.BisectStep{}, err
+	}
+	excluded := map[string]bool{}
+	for _, revision := range good {
+		goodHash, err := g.revision(revision)
+		if err != nil {
+			return gitism.BisectStep{}, err
+		}
+		for hash := range g.reachable(goodHash) {
+			excluded[hash] = true
+		}
+	}
+
+	candidates := map[string]bool{}
+	var ordered []string
+	for _, commit := range g.walk(badHash, false) {
+		if !excluded[commit.info.Hash] {
+			candidates[commit.info.Hash] = true
+			ordered = append(ordered, commit.info.Hash)
+		}
+	}
+	all := len(ordered)
+	if all == 0 {
+		return gitism.BisectStep{}, fmt.Errorf("no commit to bisect between %s and %v", bad, good)
+	}
+
+	best, reaches := "", 0
+	split := func(weight int) int {
+		if weight < all-weight {
+			return weight
+		}
+		return all - weight
+	}
+	// Candidates are newest first and git settles ties on the older commit.
+	for _, candidate := range ordered {
+		weight := 0
+		for hash := range g.reachable(candidate) {
+			if candidates[hash] {
+				weight += 1
+			}
+		}
+		if best == "" || split(weight) >= split(reaches) {
+			best, reaches = candidate, weight
+		}
+	}
+	remaining := all - reaches
+	if remaining < reaches {
+		remaining = reaches
+	}
+	return gitism.BisectStep{Commit: best, Candidates: all, Remaining: remaining - 1, Steps: estimateBisectSteps(all)}, nil
+}
+
+func (g *FakeGit) ObjectFormat() (gitism.ObjectFormat, error) {
+	return gitism.SHA1, nil
+}
+
+// CountObjects counts every blob and commit as a loose object.
+func (g *FakeGit) CountObjects() (gitism.ObjectCounts, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var size int64
+	for _, contents := range g.blobs {
+		size += int64(len(contents))
+	}
+	return gitism.ObjectCounts{Loose: int64(len(g.blobs) + len(g.commits)), LooseSize: size / 1024}, nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"testing"
+	"time"
+)
+
+// fakeSymlinks commits the same files as the symlinks playbook to a FakeGit.
+func fakeSymlinks() *FakeGit {
+	git := NewFakeGit(nil)
+	git.Commit("master", "Add a normal file", map[string]FakeFile{
+		"real.txt": {Contents: "Hello World\n"},
+	})
+	git.Commit("master", "Add symlinks inside of the repo", map[string]FakeFile{
+		"relative.txt":  {Contents: "real.txt", Mode: FakeModeSymlink},
+		"nested/up.txt": {Contents: "../real.txt", Mode: FakeModeSymlink},
+	})
+	git.Commit("master", "Add symlinks outside of the repo", map[string]FakeFile{
+		"absolute.txt":        {Contents: "/etc/passwd", Mode: FakeModeSymlink},
+		"nested/escaping.txt": {Contents: "../../outside.txt", Mode: FakeModeSymlink},
+	})
+	return git
+}
+
+func TestFakeGitMatchesGit(t *testing.T) {
+	real := newGitCliFromPlaybook(t, "symlinks")
+	fake := fakeSymlinks()
+
+	list := func(t *testing.T, git Git) map[string][]gitism.TreeEntry {
+		listings := map[string][]gitism.TreeEntry{}
+		collect := func(name string) func(entry gitism.TreeEntry) error {
+			return func(entry gitism.TreeEntry) error {
+				listings[name] = append(listings[name], entry)
+				return nil
+			}
+		}
+		reference := BranchRef("master")
+		if err := git.ListTree(GitPath{Reference: reference, TreePath: "./"}, collect("tree")); err != nil {
+			t.Fatalf("ListTree() failed: %v", err)
+		}
+		if err := git.ListDirectory(GitPath{Reference: reference, TreePath: "nested"}, collect("directory")); err != nil {
+			t.Fatalf("ListDirectory() failed: %v", err)
+		}
+		if err := git.ListTreeRecursive(GitPath{Reference: reference}, collect("recursive")); err != nil {
+			t.Fatalf("ListTreeRecursive() failed: %v", err)
+		}
+		return listings
+	}
+	if diff := cmp.Diff(list(t, real), list(t, fake)); diff != "" {
+		t.Fatalf("FakeGit lists the tree differently than git (-git +fake):\n%s", diff)
+	}
+}
+
+func TestFakeGitHistory(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	git := NewFakeGit(clock.Now)
+	first := git.Commit("master", "First", map[string]FakeFile{"a.txt": {Contents: "a"}, "dir/b.txt": {Contents: "b"}})
+	if err := git.Tag("v1", first); err != nil {
+		t.Fatalf("Tag() failed: %v", err)
+	}
+	clock.Advance(time.Minute)
+	second := git.Commit("master", "Second", map[string]FakeFile{"a.txt": {Contents: "changed"}})
+	if err := git.Branch("feature", first); err != nil {
+		t.Fatalf("Branch() failed: %v", err)
+	}
+	clock.Advance(time.Minute)
+	feature := git.Commit("feature", "Feature", map[string]FakeFile{"dir/c.txt": {Contents: "c"}})
+	clock.Advance(time.Minute)
+	merge, err := git.Merge("master", "feature", "Merge", map[string]FakeFile{"dir/c.txt": {Contents: "c"}})
+	if err != nil {
+		t.Fatalf("Merge() failed: %v", err)
+	}
+
+	if head, err := git.ResolveCommit(CommitRef("HEAD")); err != nil || head != merge {
+		t.Fatalf("ResolveCommit(HEAD) = %s, %v, expected %s", head, err, merge)
+	}
+	if abbreviated, err := git.ResolveCommit(CommitRef(second[:7])); err != nil || abbreviated != second {
+		t.Fatalf("ResolveCommit(%s) = %s, %v, expected %s", second[:7], abbreviated, err, second)
+	}
+
+	walk := func(firstParent bool) []string {
+		var hashes []string
+		err := git.WalkCommits(BranchRef("master"), firstParent, func(commit gitism.GraphCommit) error {
+			hashes = append(hashes, commit.Hash)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("WalkCommits() failed: %v", err)
+		}
+		return hashes
+	}
+	if diff := cmp.Diff([]string{merge, feature, second, first}, walk(false)); diff != "" {
+		t.Fatalf("unexpected history (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{merge, second, first}, walk(true)); diff != "" {
+		t.Fatalf("unexpected first parent history (-want +got):\n%s", diff)
+	}
+
+	var changes []string
+	err = git.ListChanges(second, func(change gitism.Change) error {
+		if change.Type != gitism.ChangeModification {
+			t.Fatalf("%s was changed by %d, expected a modification", change.Path, change.Type)
+		}
+		changes = append(changes, change.Path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListChanges() failed: %v", err)
+	}
+	if diff := cmp.Diff([]string{"a.txt"}, changes); diff != "" {
+		t.Fatalf("unexpected changes (-want +got):\n%s", diff)
+	}
+
+	// The merge took dir/c.txt from feature, so it was last changed there.
+	if info, err := git.LastCommit(merge, "dir/c.txt"); err != nil || info.Hash != feature || info.Message != "Feature" {
+		t.Fatalf("LastCommit(dir/c.txt) = %+v, %v, expected %s", info, err, feature)
+	}
+	if modified, err := git.LastModified(merge, "a.txt"); err != nil || !modified.Equal(time.Unix(1060, 0)) {
+		t.Fatalf("LastModified(a.txt) = %v, %v", modified, err)
+	}
+
+	if name, err := git.Describe(first); err != nil || name != "v1" {
+		t.Fatalf("Describe(%s) = %s, %v, expected v1", first, name, err)
+	}
+	if name, err := git.Describe(second); err != nil || name != "v1-1-g"+second[:7] {
+		t.Fatalf("Describe(%s) = %s, %v", second, name, err)
+	}
+
+	if err := git.Note("refs/notes/commits", "v1", []byte("released")); err != nil {
+		t.Fatalf("Note() failed: %v", err)
+	}
+	if note, err := git.ReadNote("refs/notes/commits", first); err != nil || string(note) != "released" {
+		t.Fatalf("ReadNote() = %q, %v", note, err)
+	}
+	if _, err := git.ReadNote("refs/notes/commits", second); !errors.Is(err, ErrNoNote) {
+		t.Fatalf("ReadNote() of a commit without a note returned %v", err)
+	}
+}
+
+func TestFakeGitBisect(t *testing.T) {
+	git := NewFakeGit(nil)
+	var commits []string
+	for i := 0; i < 8; i++ {
+		commits = append(commits, git.Commit("master", "Commit", map[string]FakeFile{"count.txt": {Contents: string(rune('0' + i))}}))
+	}
+
+	step, err := git.Bisect(commits[7], []string{commits[0]})
+	if err != nil {
+		t.Fatalf("Bisect() failed: %v", err)
+	}
+	if diff := cmp.Diff(gitism.BisectStep{Commit: commits[3], Candidates: 7, Remaining: 3, Steps: 2}, step); diff != "" {
+		t.Fatalf("unexpected bisect step (-want +got):\n%s", diff)
+	}
+	if _, err := git.Bisect(commits[0], []string{commits[0]}); err == nil {
+		t.Fatalf("Bisect() with nothing between bad and good succeeded")
+	}
+}
@@ -0,0 +1,427 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrInjectedFault is returned by a Git from NewFaultyGit in place of what the method would have returned. It is an
+// I/O error so FUSE and NFS clients see what they would if git failed for real.
+var ErrInjectedFault = fmt.Errorf("injected fault: %w", syscall.EIO)
+
+// faultMethods are the methods of Git faults can be injected into.
+var faultMethods = []string{
+	"ListTree", "ListDirectory", "ListTreeRecursive", "ListTreeNames", "ListBranches", "ListTags", "ListCommits",
+	"CommitParents", "WalkCommits", "ListChanges", "ReadBlob", "ReadBlobs", "ReadTreeBlobs", "BlobSize",
+	"ResolveCommit", "VerifyCommit", "VerifyTag", "ListWorktreeChanges", "ReadNote", "Describe", "LastModified",
+	"LastCommit", "SearchCommits", "FileHistory", "Bisect", "ObjectFormat", "CountObjects",
+}
+
+// Fault is how a call to git misbehaves.
+type Fault struct {
+	// Latency is the longest a call is delayed by. Each call waits a random duration up to it.
+	Latency time.Duration
+	// FailureRate is the fraction of calls, from 0 to 1, that fail with ErrInjectedFault instead of running.
+	FailureRate float64
+}
+
+// Faults picks the Fault for each method of Git.
+type Faults struct {
+	// Default is the Fault for methods not in Methods.
+	Default Fault
+	// Methods holds the Fault of methods that misbehave differently, by name, like "ReadBlob".
+	Methods map[string]Fault
+}
+
+// fault is the Fault of method.
+func (f Faults) fault(method string) Fault {
+	if fault, ok := f.Methods[method]; ok {
+		return fault
+	}
+	return f.Default
+}
+
+// ParseFaults parses a comma separated list of latency=DURATION and failures=RATE settings, each of which may be
+// prefixed with the name of a Git method and a period to only apply to it, like
+// "latency=10ms,ReadBlob.latency=1s,ListTree.failures=0.05". Settings for a method start from the defaults.
+func ParseFaults(spec string) (Faults, error) {
+	var faults Faults
+	type setting struct{ method, name, value string }
+	var settings []setting
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		separator := strings.IndexRune(part, '=')
+		if separator <= 0 {
+			return Faults{}, fmt.Errorf("fault '%s' must be in the form [method.]setting=value", part)
+		}
+		parsed := setting{name: part[:separator], value: part[separator+1:]}
+		if dot := strings.LastIndex(parsed.name, "."); dot >= 0 {
+			parsed.method, parsed.name = parsed.name[:dot], parsed.name[dot+1:]
+			if !isFaultMethod(parsed.method) {
+				return Faults{}, fmt.Errorf("unknown git method '%s' in fault '%s'", parsed.method, part)
+			}
+		}
+		settings = append(settings, parsed)
+	}
+
+	apply := func(fault *Fault, s setting) error {
+		switch s.name {
+		case "latency":
+			latency, err := time.ParseDuration(s.value)
+			if err != nil || latency < 0 {
+				return fmt.Errorf("invalid latency '%s'", s.value)
+			}
+			fault.Latency = latency
+		case "failures":
+			rate, err := strconv.ParseFloat(s.value, 64)
+			if err != nil || rate < 0 || rate > 1 {
+				return fmt.Errorf("invalid failure rate '%s', expected a number from 0 to 1", s.value)
+			}
+			fault.FailureRate = rate
+		default:
+			return fmt.Errorf("unknown fault setting '%s', expected latency or failures", s.name)
+		}
+		return nil
+	}
+	// Defaults go first so methods start from them no matter where they are in spec.
+	for _, s := range settings {
+		if s.method == "" {
+			if err := apply(&faults.Default, s); err != nil {
+				return Faults{}, err
+			}
+		}
+	}
+	for _, s := range settings {
+		if s.method == "" {
+			continue
+		}
+		if faults.Methods == nil {
+			faults.Methods = map[string]Fault{}
+		}
+		fault, ok := faults.Methods[s.method]
+		if !ok {
+			fault = faults.Default
+		}
+		if err := apply(&fault, s); err != nil {
+			return Faults{}, err
+		}
+		faults.Methods[s.method] = fault
+	}
+	return faults, nil
+}
+
+func isFaultMethod(name string) bool {
+	for _, method := range faultMethods {
+		if method == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WithFaults makes the client misbehave according to faults. See NewFaultyGit.
+func WithFaults(faults Faults) CliOption {
+	return func(options *cliOptions) {
+		options.faults = &faults
+	}
+}
+
+// faultyGit delays and fails calls to git before they are made.
+type faultyGit struct {
+	git    Git
+	faults Faults
+	sleep  func(time.Duration)
+	// random returns a number in [0, 1).
+	random func() float64
+}
+
+// NewFaultyGit delays calls to git and fails some of them according to faults, for testing how the filesystems built
+// on it cope with a slow or flaky git. Nothing that runs a git command is exempt, including the reads done for the
+// statistics in .gitfs.
+func NewFaultyGit(git Git, faults Faults) Git {
+	return newFaultyGit(git, faults, time.Sleep, rand.Float64)
+}
+
+func newFaultyGit(git Git, faults Faults, sleep func(time.Duration), random func() float64) Git {
+	return faultyGit{git: git, faults: faults, sleep: sleep, random: random}
+}
+
+// inject delays a call to method and decides if it fails.
+func (g faultyGit) inject(method string) error {
+	fault := g.faults.fault(method)
+	if fault.Latency > 0 {
+		g.sleep(time.Duration(g.random() * float64(fault.Latency)))
+	}
+	if fault.FailureRate > 0 && g.random() < fault.FailureRate {
+		return fmt.Errorf("%s: %w", method, ErrInjectedFault)
+	}
+	return nil
+}
+
+func (g faultyGit) ListTree(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	if err := g.inject("ListTree"); err != nil {
+		return err
+	}
+	return g.git.ListTree(path, handler)
+}
+
+func (g faultyGit) ListDirectory(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	if err := g.inject("ListDirectory"); err != nil {
+		return err
+	}
+	return g.git.ListDirectory(path, handler)
+}
+
+func (g faultyGit) ListTreeRecursive(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	if err := g.inject("ListTreeRecursive"); err != nil {
+		return err
+	}
+	return g.git.ListTreeRecursive(path, handler)
+}
+
+func (g faultyGit) ListTreeNames(path GitPath, handler func(path string) error) error {
+	if err := g.inject("ListTreeNames"); err != nil {
+		return err
+	}
+	return g.git.ListTreeNames(path, handler)
+}
+
+func (g faultyGit) ListBranches(handler func(branch string) error) error {
+	if err := g.inject("ListBranches"); err != nil {
+		return err
+	}
+	return g.git.ListBranches(handler)
+}
+
+func (g faultyGit) ListTags(handler func(branch string) error) error {
+	if err := g.inject("ListTags"); err != nil {
+		return err
+	}
+	return g.git.ListTags(handler)
+}
+
+func (g faultyGit) ListCommits(ref Ref, handler func(branch string) error) error {
+	if err := g.inject("ListCommits"); err != nil {
+		return err
+	}
+	return g.git.ListCommits(ref, handler)
+}
+
+func (g faultyGit) CommitParents(commit string) ([]string, error) {
+	if err := g.inject("CommitParents"); err != nil {
+		return nil, err
+	}
+	return g.git.CommitParents(commit)
+}
+
+func (g faultyGit) WalkCommits(ref Ref, firstParent bool, handler func(commit gitism.GraphCommit) error) error {
+	if err := g.inject("WalkCommits"); err != nil {
+		return err
+	}
+	return g.git.WalkCommits(ref, firstParent, handler)
+}
+
+func (g faultyGit) ListChanges(commit string, handler func(change gitism.Change) error) error {
+	if err := g.inject("ListChanges"); err != nil {
+		return err
+	}
+	return g.git.ListChanges(commit, handler)
+}
+
+func (g faultyGit) ReadBlob(hash string) ([]byte, error) {
+	if err := g.inject("ReadBlob"); err != nil {
+		return nil, err
+	}
+	return g.git.ReadBlob(hash)
+}
+
+func (g faultyGit) ReadBlobs(hashes []string, handler func(hash string, contents []byte) error) error {
+	if err := g.inject("ReadBlobs"); err != nil {
+		return err
+	}
+	return g.git.ReadBlobs(hashes, handler)
+}
+
+func (g faultyGit) ReadTreeBlobs(path GitPath, include func(entry gitism.TreeEntry) bool, handler func(entry gitism.TreeEntry, contents []byte) error) error {
+	if err := g.inject("ReadTreeBlobs"); err != nil {
+		return err
+	}
+	return g.git.ReadTreeBlobs(path, include, handler)
+}
+
+func (g faultyGit) BlobSize(hash string) (int64, error) {
+	if err := g.inject("BlobSize"); err != nil {
+		return 0, err
+	}
+	return g.git.BlobSize(hash)
+}
+
+func (g faultyGit) ResolveCommit(ref Ref) (string, error) {
+	if err := g.inject("ResolveCommit"); err != nil {
+		return "", err
+	}
+	return g.git.ResolveCommit(ref)
+}
+
+func (g faultyGit) VerifyCommit(commit string) error {
+	if err := g.inject("VerifyCommit"); err != nil {
+		return err
+	}
+	return g.git.VerifyCommit(commit)
+}
+
+func (g faultyGit) VerifyTag(tag string) error {
+	if err := g.inject("VerifyTag"); err != nil {
+		return err
+	}
+	return g.git.VerifyTag(tag)
+}
+
+func (g faultyGit) ListWorktreeChanges(worktree string, handler func(path string) error) error {
+	if err := g.inject("ListWorktreeChanges"); err != nil {
+		return err
+	}
+	return g.git.ListWorktreeChanges(worktree, handler)
+}
+
+func (g faultyGit) ReadNote(notesRef string, commit string) ([]byte, error) {
+	if err := g.inject("ReadNote"); err != nil {
+		return nil, err
+	}
+	return g.git.ReadNote(notesRef, commit)
+}
+
+func (g faultyGit) Describe(commit string) (string, error) {
+	if err := g.inject("Describe"); err != nil {
+		return "", err
+	}
+	return g.git.Describe(commit)
+}
+
+func (g faultyGit) LastModified(commit string, path string) (time.Time, error) {
+	if err := g.inject("LastModified"); err != nil {
+		return time.Time{}, err
+	}
+	return g.git.LastModified(commit, path)
+}
+
+func (g faultyGit) LastCommit(commit string, path string) (gitism.CommitInfo, error) {
+	if err := g.inject("LastCommit"); err != nil {
+		return gitism.CommitInfo{}, err
+	}
+	return g.git.LastCommit(commit, path)
+}
+
+func (g faultyGit) SearchCommits(ref Ref, pattern string, limit int, handler func(commit gitism.CommitInfo) error) error {
+	if err := g.inject("SearchCommits"); err != nil {
+		return err
+	}
+	return g.git.SearchCommits(ref, pattern, limit, handler)
+}
+
+func (g faultyGit) FileHistory(ref Ref, path string, handler func(commit string, change gitism.Change) error) error {
+	if err := g.inject("FileHistory"); err != nil {
+		return err
+	}
+	return g.git.FileHistory(ref, path, handler)
+}
+
+func (g faultyGit) Bisect(bad string, good []string) (gitism.BisectStep, error) {
+	if err := g.inject("Bisect"); err != nil {
+		return gitism.BisectStep{}, err
+	}
+	return g.git.Bisect(bad, good)
+}
+
+func (g faultyGit) ObjectFormat() (gitism.ObjectFormat, error) {
+	if err := g.inject("ObjectFormat"); err != nil {
+		return "", err
+	}
+	return g.git.ObjectFormat()
+}
+
+func (g faultyGit) CountObjects() (gitism.ObjectCounts, error) {
+	if err := g.inject("CountObjects"); err != nil {
+		return gitism.ObjectCounts{}, err
+	}
+	return g.git.CountObjects()
+}
+
+// The methods below pass through what the wrapped client offers beyond Git, so injecting faults doesn't change how
+// files are read or what .gitfs/stats.json reports.
+
+// openBlob is ReadBlob for opening a file within the memory budget of the wrapped client.
+func (g faultyGit) openBlob(hash string, size int64) (openedBlob, error) {
+	if err := g.inject("ReadBlob"); err != nil {
+		return openedBlob{}, err
+	}
+	return openBlob(g.git, hash, size)
+}
+
+func (g faultyGit) linkedObjects() ([]string, int, error) {
+	if git, ok := g.git.(interface {
+		linkedObjects() ([]string, int, error)
+	}); ok {
+		return git.linkedObjects()
+	}
+	return nil, 0, nil
+}
+
+func (g faultyGit) fetchStats() (FetchStats, bool) {
+	if git, ok := g.git.(interface{ fetchStats() (FetchStats, bool) }); ok {
+		return git.fetchStats()
+	}
+	return FetchStats{}, false
+}
+
+func (g faultyGit) cacheStats() (CacheStats, bool) {
+	if git, ok := g.git.(interface{ cacheStats() (CacheStats, bool) }); ok {
+		return git.cacheStats()
+	}
+	return CacheStats{}, false
+}
+
+func (g faultyGit) backend() string {
+	return Backend(g.git)
+}
+
+func (g faultyGit) maintain(task gitism.MaintenanceTask) error {
+	return Maintain(g.git, task)
+}
+
+func (g faultyGit) budgetStats() (MemoryStats, bool) {
+	if git, ok := g.git.(interface{ budgetStats() (MemoryStats, bool) }); ok {
+		return git.budgetStats()
+	}
+	return MemoryStats{}, false
+}
+
+func (g faultyGit) Close() error {
+	if closer, ok := g.git.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseFaults(t *testing.T) {
+	faults, err := ParseFaults("ReadBlob.latency=2s, latency=100ms,failures=0.01,ListTree.failures=0.5")
+	if err != nil {
+		t.Fatalf("ParseFaults() failed: %v", err)
+	}
+	expected := Faults{
+		Default: Fault{Latency: 100 * time.Millisecond, FailureRate: 0.01},
+		Methods: map[string]Fault{
+			"ReadBlob": {Latency: 2 * time.Second, FailureRate: 0.01},
+			"ListTree": {Latency: 100 * time.Millisecond, FailureRate: 0.5},
+		},
+	}
+	if diff := cmp.Diff(expected, faults); diff != "" {
+		t.Fatalf("unexpected faults (-want +got):\n%s", diff)
+	}
+
+	for _, spec := range []string{"latency", "latency=fast", "latency=-1s", "failures=2", "jitter=1s", "Push.failures=1"} {
+		if _, err := ParseFaults(spec); err == nil {
+			t.Errorf("ParseFaults(%q) succeeded, expected an error", spec)
+		}
+	}
+}
+
+func TestFaultMethodsCoverGit(t *testing.T) {
+	var methods []string
+	git := reflect.TypeOf((*Git)(nil)).Elem()
+	for i := 0; i < git.NumMethod(); i++ {
+		methods = append(methods, git.Method(i).Name)
+	}
+	listed := append([]string(nil), faultMethods...)
+	sort.Strings(listed)
+	if diff := cmp.Diff(methods, listed); diff != "" {
+		t.Fatalf("faultMethods doesn't list every method of Git (-want +got):\n%s", diff)
+	}
+}
+
+func TestFaultyGit(t *testing.T) {
+	fake := NewFakeGit(nil)
+	hash := fake.Commit("master", "Add a file", map[string]FakeFile{"a.txt": {Contents: "a"}})
+	clock := NewFakeClock(time.Unix(0, 0))
+	// Every call waits half its latency, and fails if its failure rate is over one half.
+	random := func() float64 { return 0.5 }
+	git := newFaultyGit(fake, Faults{
+		Default: Fault{Latency: time.Second},
+		Methods: map[string]Fault{"ReadBlob": {FailureRate: 0.75}},
+	}, clock.Sleep, random)
+
+	if commit, err := git.ResolveCommit(BranchRef("master")); err != nil || commit != hash {
+		t.Fatalf("ResolveCommit() = %s, %v, expected %s", commit, err, hash)
+	}
+	if clock.Slept() != 500*time.Millisecond {
+		t.Fatalf("ResolveCommit() was delayed by %v, expected 500ms", clock.Slept())
+	}
+
+	_, err := git.ReadBlob("2e65efe2a145dda7ee51d1741299f848e5bf752e")
+	if !errors.Is(err, ErrInjectedFault) || !errors.Is(err, syscall.EIO) {
+		t.Fatalf("ReadBlob() = %v, expected an injected EIO", err)
+	}
+	if clock.Slept() != 500*time.Millisecond {
+		t.Fatalf("ReadBlob() was delayed by %v, expected no delay", clock.Slept()-500*time.Millisecond)
+	}
+}
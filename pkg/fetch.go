@@ -0,0 +1,148 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// minFetchBackoff is how long a ref or object that failed to fetch is left alone before it is fetched again.
+	minFetchBackoff = time.Second
+	// maxFetchBackoff bounds how long the wait between fetches of something that keeps failing grows to.
+	maxFetchBackoff = 5 * time.Minute
+)
+
+// errFetchSkipped is returned instead of fetching something that failed to fetch too recently.
+var errFetchSkipped = errors.New("fetch skipped after a recent failure")
+
+// FetchStats counts the fetches run for refs and objects missing from the repository.
+type FetchStats struct {
+	Fetches  int64 `json:"fetches"`
+	Failures int64 `json:"failures"`
+	// Failovers counts fetches that failed from the first remote and were fetched from a later one instead.
+	Failovers int64 `json:"failovers"`
+	// Skipped counts missing refs and objects that weren't fetched because fetching them failed recently.
+	Skipped int64 `json:"skipped"`
+}
+
+// WithFetchOnMissing fetches refs and objects that aren't in the repository from remotes, like origin, instead of
+// failing, so a partial or stale clone fills itself in as it is read. Remotes are names or URLs tried in order, so
+// mirrors listed after the primary keep fetches working while it is down. Something that fails to fetch from every
+// remote isn't tried again until a backoff that doubles with every failure has passed.
+func WithFetchOnMissing(remotes ...string) CliOption {
+	return func(options *cliOptions) {
+		options.fetchRemotes = append(options.fetchRemotes, remotes...)
+	}
+}
+
+// fetchFailure is how long to leave a refspec that failed to fetch alone.
+type fetchFailure struct {
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// missingFetches fetches refs and objects on demand. Fetches are run one at a time since concurrent fetches into
+// the same repository fight over its locks. A nil *missingFetches never fetches anything.
+type missingFetches struct {
+	remotes []string
+	run     func(remote, refspec string) error
+	now     func() time.Time
+
+	mu       sync.Mutex
+	stats    FetchStats
+	failures map[string]fetchFailure
+}
+
+func newMissingFetches(remotes []string, run func(remote, refspec string) error, now func() time.Time) *missingFetches {
+	return &missingFetches{remotes: remotes, run: run, now: now, failures: map[string]fetchFailure{}}
+}
+
+// fetch fetches refspec from the first remote that has it unless refspec is empty or failed to fetch too recently.
+func (f *missingFetches) fetch(refspec string) error {
+	if f == nil || refspec == "" {
+		return errFetchSkipped
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	failure, failed := f.failures[refspec]
+	if failed && f.now().Before(failure.retryAt) {
+		f.stats.Skipped++
+		return errFetchSkipped
+	}
+
+	f.stats.Fetches++
+	if err := f.runInOrder(refspec); err != nil {
+		f.stats.Failures++
+		failure.backoff *= 2
+		if failure.backoff < minFetchBackoff {
+			failure.backoff = minFetchBackoff
+		} else if failure.backoff > maxFetchBackoff {
+			failure.backoff = maxFetchBackoff
+		}
+		failure.retryAt = f.now().Add(failure.backoff)
+		f.failures[refspec] = failure
+		return err
+	}
+	delete(f.failures, refspec)
+	return nil
+}
+
+// runInOrder fetches refspec from each remote until one succeeds. The error is the primary's since mirrors are
+// expected to lag behind it.
+func (f *missingFetches) runInOrder(refspec string) error {
+	var primaryErr error
+	for i, remote := range f.remotes {
+		err := f.run(remote, refspec)
+		if err == nil {
+			if i > 0 {
+				f.stats.Failovers++
+			}
+			return nil
+		}
+		if i == 0 {
+			primaryErr = err
+		}
+	}
+	return primaryErr
+}
+
+// fetchMissingRef fetches ref if it isn't in the repository, and reports whether it was.
+func (g cliGit) fetchMissingRef(ref Ref) bool {
+	if g.fetches == nil {
+		return false
+	}
+	treeLike, err := ref.treeLike()
+	if err != nil {
+		return false
+	}
+	if _, err := g.cli.RevParse(treeLike); err == nil {
+		return false
+	}
+	return g.fetches.fetch(ref.refspec()) == nil
+}
+
+// fetchStats reports the fetches run for missing refs and objects, and false if they aren't fetched.
+func (g cliGit) fetchStats() (FetchStats, bool) {
+	if g.fetches == nil {
+		return FetchStats{}, false
+	}
+	g.fetches.mu.Lock()
+	defer g.fetches.mu.Unlock()
+	return g.fetches.stats, true
+}
@@ -0,0 +1,190 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// emptyClone creates an empty bare repository whose origin is upstream.
+func emptyClone(t *testing.T, upstream string) string {
+	dir := filepath.Join(t.TempDir(), "clone.git")
+	if err := exec.Command("git", "init", "--quiet", "--bare", dir).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.Command("git", "--git-dir", dir, "remote", "add", "origin", upstream).Run(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFetchOnMissing(t *testing.T) {
+	upstreamDir, err := runPlaybook("tags", t.TempDir())
+	if err != nil {
+		t.Fatalf("playbook 'tags' failed: %v", err)
+	}
+	upstream, err := NewCliGit(upstreamDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := upstream.ResolveCommit(BranchRef("master"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		git, err := NewCliGit(emptyClone(t, upstreamDir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := git.ResolveCommit(BranchRef("master")); err == nil {
+			t.Fatal("ResolveCommit(master) should fail without fetching")
+		}
+	})
+
+	t.Run("refs", func(t *testing.T) {
+		git, err := NewCliGit(emptyClone(t, upstreamDir), WithFetchOnMissing("origin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit, err := git.ResolveCommit(BranchRef("master"))
+		if err != nil {
+			t.Fatalf("ResolveCommit(master) should fetch master: %v", err)
+		}
+		if commit != master {
+			t.Fatalf("ResolveCommit(master) = %s, want %s", commit, master)
+		}
+
+		// ls-tree lists nothing for a missing ref, which fetches it too.
+		var paths []string
+		err = git.ListTree(GitPath{Reference: TagRef("v1.0"), TreePath: "."}, func(entry gitism.TreeEntry) error {
+			paths = append(paths, entry.Path)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{"real.txt"}, paths); diff != "" {
+			t.Fatalf("ListTree(v1.0) should fetch v1.0 (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("objects", func(t *testing.T) {
+		var hash string
+		err := upstream.ListTree(GitPath{Reference: BranchRef("master"), TreePath: "real.txt"}, func(entry gitism.TreeEntry) error {
+			hash = entry.Hash
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		git, err := NewCliGit(emptyClone(t, upstreamDir), WithFetchOnMissing("origin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := git.ReadBlob(hash)
+		if err != nil {
+			t.Fatalf("ReadBlob(%s) should fetch the blob: %v", hash, err)
+		}
+		if string(contents) != "Hello World, again\n" {
+			t.Fatalf("ReadBlob(%s) = %q", hash, contents)
+		}
+	})
+
+	t.Run("failover", func(t *testing.T) {
+		// The primary is a path with no repository behind it, so the mirror after it is fetched from instead.
+		down := filepath.Join(t.TempDir(), "down.git")
+		git, err := NewCliGit(emptyClone(t, upstreamDir), WithFetchOnMissing(down, upstreamDir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit, err := git.ResolveCommit(BranchRef("master"))
+		if err != nil {
+			t.Fatalf("ResolveCommit(master) should fetch master from the mirror: %v", err)
+		}
+		if commit != master {
+			t.Fatalf("ResolveCommit(master) = %s, want %s", commit, master)
+		}
+		stats, _ := git.(cliGit).fetchStats()
+		if diff := cmp.Diff(FetchStats{Fetches: 1, Failovers: 1}, stats); diff != "" {
+			t.Fatalf("fetchStats() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		git, err := NewCliGit(emptyClone(t, upstreamDir), WithFetchOnMissing("origin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := git.ResolveCommit(BranchRef("missing")); err == nil {
+				t.Fatal("ResolveCommit(missing) should fail")
+			}
+		}
+		stats, ok := git.(cliGit).fetchStats()
+		if !ok {
+			t.Fatal("fetchStats() should report fetches")
+		}
+		if diff := cmp.Diff(FetchStats{Fetches: 1, Failures: 1, Skipped: 1}, stats); diff != "" {
+			t.Fatalf("fetchStats() mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestMissingFetchesBackoff(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	fail := true
+	fetches := newMissingFetches([]string{"origin"}, func(string, string) error {
+		if fail {
+			return errors.New("remote is down")
+		}
+		return nil
+	}, clock.Now)
+
+	// Each failure doubles how long the refspec is left alone for, up to maxFetchBackoff.
+	for _, backoff := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if err := fetches.fetch("main"); err == nil || errors.Is(err, errFetchSkipped) {
+			t.Fatalf("fetch() at %v should have run and failed: %v", clock.Now(), err)
+		}
+		clock.Advance(backoff - time.Nanosecond)
+		if err := fetches.fetch("main"); !errors.Is(err, errFetchSkipped) {
+			t.Fatalf("fetch() within %v of a failure should be skipped: %v", backoff, err)
+		}
+		clock.Advance(time.Nanosecond)
+	}
+
+	fail = false
+	if err := fetches.fetch("main"); err != nil {
+		t.Fatalf("fetch() failed: %v", err)
+	}
+	if err := fetches.fetch("other"); err != nil {
+		t.Fatalf("fetch() of a different refspec shouldn't be backed off: %v", err)
+	}
+	if diff := cmp.Diff(FetchStats{Fetches: 5, Failures: 3, Skipped: 3}, fetches.stats); diff != "" {
+		t.Fatalf("stats mismatch (-want +got):\n%s", diff)
+	}
+
+	var disabled *missingFetches
+	if err := disabled.fetch("main"); !errors.Is(err, errFetchSkipped) {
+		t.Fatalf("a nil *missingFetches shouldn't fetch: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+const (
+	// maxSymlinkHops is how many symlinks followSymlinkFileSystem follows for a single path before giving up, the same
+	// limit Linux has.
+	maxSymlinkHops = 40
+	// maxPathDepth is the most names a path may have while its symlinks are followed. Targets can be longer than the
+	// links they replace, so without it a few links pointing into themselves grow a path without bound. It is as many
+	// names as fit in Linux's PATH_MAX.
+	maxPathDepth = 2048
+)
+
+// followedInfo describes what a symlink points to under the name of the link, like os.Stat does.
+type followedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i followedInfo) Name() string {
+	return i.name
+}
+
+// followSymlinkFileSystem serves what symlinks point to from Stat and OpenFile for clients that can't follow them on
+// their own. Targets are resolved as if the root of the filesystem were "/", so following never leaves the tree.
+type followSymlinkFileSystem struct {
+	billy.Filesystem
+}
+
+// resolve follows every symlink in filename, including those in the directories leading up to it. escapes reports if
+// any of them pointed outside of the tree and was clamped to it. Cycles of symlinks fail with ELOOP as soon as they
+// come around, and paths that grow too deep while following fail with ENAMETOOLONG.
+func (s followSymlinkFileSystem) resolve(filename string) (resolved string, escapes bool, err error) {
+	root := RootGitPath()
+	path, err := root.Resolve(filename)
+	if err != nil {
+		return "", false, err
+	}
+	if len(path.Path) > maxPathDepth {
+		return "", false, fmt.Errorf("%s is too deep: %w", filename, syscall.ENAMETOOLONG)
+	}
+
+	remaining := path.Path
+	current := RootGitPath()
+	// seen holds every symlink followed along with what was left to resolve after it. Reaching the same one with the
+	// same remainder again can only go around in circles.
+	seen := map[string]bool{}
+	for hops := 0; len(remaining) > 0; {
+		current = FilePath{Path: append(current.Path, remaining[0])}
+		remaining = remaining[1:]
+		info, err := s.Filesystem.Lstat(current.String())
+		if err != nil {
+			return "", false, err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		hops += 1
+		if hops > maxSymlinkHops {
+			return "", false, fmt.Errorf("too many symlinks in %s: %w", filename, syscall.ELOOP)
+		}
+		state := current.String() + "\x00" + strings.Join(remaining, SeparatorString)
+		if seen[state] {
+			return "", false, fmt.Errorf("symlink cycle through %s in %s: %w", current.String(), filename, syscall.ELOOP)
+		}
+		seen[state] = true
+		target, err := s.Filesystem.Readlink(current.String())
+		if err != nil {
+			return "", false, err
+		}
+		link, escaped := resolveSymlink(root, current.Parent(), target)
+		escapes = escapes || escaped
+		if len(link.Path)+len(remaining) > maxPathDepth {
+			return "", false, fmt.Errorf("following symlinks in %s made it too deep: %w", filename, syscall.ENAMETOOLONG)
+		}
+		remaining = append(append([]string(nil), link.Path...), remaining...)
+		current = RootGitPath()
+	}
+	return current.String(), escapes, nil
+}
+
+func (s followSymlinkFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s followSymlinkFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	resolved, _, err := s.resolve(filename)
+	if err != nil {
+		return nil, err
+	}
+	return s.Filesystem.OpenFile(resolved, flag, perm)
+}
+
+func (s followSymlinkFileSystem) Stat(filename string) (os.FileInfo, error) {
+	resolved, _, err := s.resolve(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.Filesystem.Stat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	return followedInfo{FileInfo: info, name: path.Base(filename)}, nil
+}
+
+// Chroot keeps following symlinks in the new root.
+func (s followSymlinkFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	resolved, _, err := s.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := s.Filesystem.Chroot(resolved)
+	if err != nil {
+		return nil, err
+	}
+	return followSymlinkFileSystem{Filesystem: fs}, nil
+}
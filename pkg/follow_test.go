@@ -0,0 +1,64 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestFollowSymlinkLimits(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "cycles")
+	fs := followSymlinkFileSystem{Filesystem: NewReferenceFileSystem(git, BranchRef("master"))}
+	deep := strings.Repeat("d/", 64) + "bottom.txt"
+
+	if info, err := fs.Stat(deep); err != nil || info.Size() != int64(len("Hello World\n")) {
+		t.Fatalf("Stat() of the bottom of the tree = %v, %v", info, err)
+	}
+	// Every again is a symlink to d, so this is the same file after a symlink for every level.
+	again := "d/" + strings.Repeat("again/", maxSymlinkHops-1) + strings.Repeat("d/", 63) + "bottom.txt"
+	if _, err := fs.Stat(again); err != nil {
+		t.Fatalf("Stat() through %d symlinks failed: %v", maxSymlinkHops-1, err)
+	}
+
+	tests := map[string]struct {
+		errno syscall.Errno
+		// cycle is set when the cycle should be noticed before the limit on symlinks is reached.
+		cycle bool
+	}{
+		"self.txt":          {errno: syscall.ELOOP, cycle: true},
+		"ping.txt":          {errno: syscall.ELOOP, cycle: true},
+		"loops/around":      {errno: syscall.ELOOP, cycle: true},
+		"loops/around/file": {errno: syscall.ELOOP, cycle: true},
+		"grow":              {errno: syscall.ELOOP},
+		"d/" + strings.Repeat("again/", maxSymlinkHops+1) + "bottom.txt": {errno: syscall.ELOOP},
+		strings.Repeat("d/", maxPathDepth) + "bottom.txt":                {errno: syscall.ENAMETOOLONG},
+	}
+	for filename, test := range tests {
+		name := filename
+		if len(name) > 32 {
+			name = name[:32] + "..."
+		}
+		_, err := fs.Stat(filename)
+		if !errors.Is(err, test.errno) {
+			t.Fatalf("Stat(%s) returned %v, expected %v", name, err, test.errno)
+		}
+		if cycle := strings.Contains(err.Error(), "cycle"); cycle != test.cycle {
+			t.Fatalf("Stat(%s) returned %v, expected the cycle to be noticed: %t", name, err, test.cycle)
+		}
+	}
+}
@@ -15,35 +15,78 @@
 package pkg
 
 import (
-	"container/list"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/go-git/go-billy/v5"
 	"github.com/jacobsa/fuse"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/fuseutil"
+	"hash/fnv"
 	"io"
+	"io/fs"
 	"log"
 	"os"
-	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 var latest time.Time = time.Unix(1<<63-62135596801, 999999999)
 
+// fusePanics counts the FUSE operations that panicked in the process.
+var fusePanics int64
+
 type billyInode struct {
-	Id       fuseops.InodeID
-	ParentId fuseops.InodeID
-	info     os.FileInfo
-	Children []fuseops.InodeID
+	Id   fuseops.InodeID
+	path string
+	// info is replaced by lookups and refreshes, so it is guarded by billyFuse.mu. Read it with billyFuse.info.
+	info os.FileInfo
+
+	// lookupCount is the number of references the kernel holds to this inode. Every successful LookUpInode adds one
+	// and ForgetInode removes them. The inode is dropped once the count reaches zero.
+	lookupCount uint64
 }
 
+// billyFuse lazily exposes a billy.Filesystem over FUSE. Inodes are only created when the kernel looks them up and are
+// released when the kernel forgets them so the inode table stays proportional to what is cached in the kernel rather
+// than to the size of the repository.
 type billyFuse struct {
 	fuseutil.NotImplementedFileSystem
 
-	inodes  map[fuseops.InodeID]*billyInode
-	handles map[fuseops.HandleID]billy.File
-	fs      billy.Filesystem
+	mu sync.Mutex
+	// inodes holds every inode the kernel currently has a reference to.
+	inodes map[fuseops.InodeID]*billyInode
+	// paths maps a path back to its inode in inodes.
+	paths map[string]fuseops.InodeID
+
+	// directories holds the listing taken when a directory handle was opened so reads at an offset stay consistent,
+	// and files the file opened for each file handle so reads don't open it again every time.
+	directories map[fuseops.HandleID][]fuseutil.Dirent
+	files       map[fuseops.HandleID]billy.File
+	nextHandle  fuseops.HandleID
+	fs          billy.Filesystem
+	tracer      *Tracer
+	accessLog   *AccessLog
+	treeSizes   *TreeSizes
+	readOnly    bool
+	logger      *log.Logger
+	// authorizeWrite is FuseOptions.AuthorizeWrite.
+	authorizeWrite func(uid uint32, path string) error
+
+	attributeTTL time.Duration
+	entryTTL     time.Duration
+}
+
+// info is what inode was last known to be.
+func (f *billyFuse) info(inode *billyInode) os.FileInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return inode.info
 }
 
 func (f *billyFuse) getInode(id fuseops.InodeID) (*billyInode, error) {
@@ -52,6 +95,9 @@ func (f *billyFuse) getInode(id fuseops.InodeID) (*billyInode, error) {
 		return nil, fuse.EINVAL
 	}
 
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	inode, ok := f.inodes[id]
 	if !ok {
 		return nil, fuse.ENOENT
@@ -59,109 +105,158 @@ func (f *billyFuse) getInode(id fuseops.InodeID) (*billyInode, error) {
 	return inode, nil
 }
 
-func NewBillyFuse(fs billy.Filesystem) (fuseutil.FileSystem, error) {
-	billyFuse := new(billyFuse)
-	billyFuse.inodes = map[fuseops.InodeID]*billyInode{}
-	billyFuse.handles = map[fuseops.HandleID]billy.File{}
-	billyFuse.fs = fs
-
-	type queuedPath struct {
-		parentInodeId fuseops.InodeID
-		path          string
+// inodeIdForPath picks the id for path. Ids are derived from a hash of the path so they are stable between listing a
+// directory and looking its entries up, and between restarts. Must be called with f.mu held.
+func (f *billyFuse) inodeIdForPath(path string) fuseops.InodeID {
+	if id, ok := f.paths[path]; ok {
+		return id
 	}
 
-	nextInode := fuseops.RootInodeID
-	createInode := func(info os.FileInfo) *billyInode {
-		node := new(billyInode)
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(path))
+	id := fuseops.InodeID(hash.Sum64())
+	for {
+		// Skip the ids with special meanings and probe past any hash collision with a live inode.
+		inode, taken := f.inodes[id]
+		if id > fuseops.RootInodeID && (!taken || inode.path == path) {
+			return id
+		}
+		id += 1
+	}
+}
 
-		node.Id = fuseops.InodeID(nextInode)
-		nextInode += 1
+// FuseOptions customize how a billy.Filesystem is served over FUSE.
+type FuseOptions struct {
+	// Tracer, if set, traces every FUSE operation.
+	Tracer *Tracer
+	// AccessLog, if set, records every file opened and directory listed along with the uid of the process that did it.
+	AccessLog *AccessLog
+	// AttributeTTL is how long the kernel may cache the attributes of an inode before asking for them again. 0 caches
+	// them forever, which is only right when the filesystem never changes. A negative TTL disables caching.
+	AttributeTTL time.Duration
+	// EntryTTL is how long the kernel may cache which inode a name in a directory is before looking it up again. 0
+	// caches them forever and a negative TTL disables caching.
+	EntryTTL time.Duration
+	// TreeSizes, if set, answers TreeEntriesXattr and TreeSizeXattr for directories served straight from the
+	// repository it measures.
+	TreeSizes *TreeSizes
+	// ReadOnly reports every inode without write permission. FUSE has no way to hand the kernel chattr's immutable
+	// flag, so clearing the write bits is what makes editors open files read-only instead of failing on save.
+	ReadOnly bool
+	// AuthorizeWrite, if set, is asked before data is written to path with the uid of the process writing it, and
+	// the write fails with its error. Writes from processes whose uid can't be told are refused.
+	AuthorizeWrite func(uid uint32, path string) error
+	// Label, if set, labels everything logged and traced while serving an operation, to tell several mounts served by
+	// one process apart. See JoinLabels.
+	Label string
+}
 
-		node.info = info
-		node.Children = []fuseops.InodeID{}
-		billyFuse.inodes[node.Id] = node
-		return node
+// expiration is when something the kernel caches for ttl should be dropped. Expirations are taken from the monotonic
+// clock at full precision so short TTLs aren't thrown off by the wall clock changing.
+func expiration(ttl time.Duration) time.Time {
+	if ttl == 0 {
+		return latest
 	}
+	return time.Now().Add(ttl)
+}
 
-	queue := list.New()
-	queue.PushBack(queuedPath{
-		parentInodeId: 0,
-		path:          ".",
-	})
-	for queue.Len() > 0 {
-		front := queue.Front()
-		next := (front.Value).(queuedPath)
-		currentDirectory := next.path
-		queue.Remove(front)
+// immutable reports whether the kernel is allowed to cache everything forever.
+func (f *billyFuse) immutable() bool {
+	return f.attributeTTL == 0 && f.entryTTL == 0
+}
 
-		fileInfo, err := fs.Stat(currentDirectory)
-		if err != nil {
-			return nil, fmt.Errorf("failed to stat directory %s: %v", currentDirectory, err)
-		}
-		directoryInode := createInode(fileInfo)
-
-		if next.parentInodeId != 0 {
-			parentInode, ok := billyFuse.inodes[next.parentInodeId]
-			if ok {
-				parentInode.Children = append(parentInode.Children, directoryInode.Id)
-			}
-			directoryInode.ParentId = next.parentInodeId
-		}
+func NewBillyFuse(fs billy.Filesystem) (fuseutil.FileSystem, error) {
+	return NewBillyFuseWithOptions(fs, FuseOptions{})
+}
 
-		files, err := fs.ReadDir(currentDirectory)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read dir %s: %v", currentDirectory, err)
-		}
+// NewBillyFuseWithTracer is NewBillyFuse but every FUSE operation is traced with tracer.
+func NewBillyFuseWithTracer(fs billy.Filesystem, tracer *Tracer) (fuseutil.FileSystem, error) {
+	return NewBillyFuseWithOptions(fs, FuseOptions{Tracer: tracer})
+}
 
-		for _, file := range files {
-			if file.IsDir() {
-				queue.PushBack(queuedPath{
-					parentInodeId: directoryInode.Id,
-					path:          filepath.Join(currentDirectory, file.Name()),
-				})
-				continue
-			}
-
-			fileInode := createInode(file)
-			fileInode.ParentId = directoryInode.Id
-			directoryInode.Children = append(directoryInode.Children, fileInode.Id)
-		}
+// NewBillyFuseWithOptions is NewBillyFuse customized by options.
+func NewBillyFuseWithOptions(fs billy.Filesystem, options FuseOptions) (fuseutil.FileSystem, error) {
+	billyFuse := new(billyFuse)
+	billyFuse.inodes = map[fuseops.InodeID]*billyInode{}
+	billyFuse.paths = map[string]fuseops.InodeID{}
+	billyFuse.directories = map[fuseops.HandleID][]fuseutil.Dirent{}
+	billyFuse.files = map[fuseops.HandleID]billy.File{}
+	billyFuse.fs = fs
+	billyFuse.tracer = options.Tracer.Labelled(options.Label)
+	billyFuse.accessLog = options.AccessLog
+	billyFuse.attributeTTL = options.AttributeTTL
+	billyFuse.entryTTL = options.EntryTTL
+	billyFuse.treeSizes = options.TreeSizes
+	billyFuse.readOnly = options.ReadOnly
+	billyFuse.logger = LabelLogger(log.Default(), options.Label)
+	billyFuse.authorizeWrite = options.AuthorizeWrite
+
+	info, err := fs.Stat(".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat root directory: %v", err)
 	}
 
+	// The kernel never forgets the root.
+	billyFuse.inodes[fuseops.RootInodeID] = &billyInode{
+		Id:          fuseops.RootInodeID,
+		path:        ".",
+		info:        info,
+		lookupCount: 1,
+	}
+	billyFuse.paths["."] = fuseops.RootInodeID
+
 	return billyFuse, nil
 }
 
 func NewBillyFuseServer(fs billy.Filesystem) (fuse.Server, error) {
-	fuseFileSystem, err := NewBillyFuse(fs)
+	return NewBillyFuseServerWithOptions(fs, FuseOptions{})
+}
+
+// NewBillyFuseServerWithTracer is NewBillyFuseServer but every FUSE operation is traced with tracer.
+func NewBillyFuseServerWithTracer(fs billy.Filesystem, tracer *Tracer) (fuse.Server, error) {
+	return NewBillyFuseServerWithOptions(fs, FuseOptions{Tracer: tracer})
+}
+
+// NewBillyFuseServerWithOptions is NewBillyFuseServer customized by options.
+func NewBillyFuseServerWithOptions(fs billy.Filesystem, options FuseOptions) (fuse.Server, error) {
+	fuseFileSystem, err := NewBillyFuseWithOptions(fs, options)
 	if err != nil {
 		return nil, err
 	}
 	return fuseutil.NewFileSystemServer(fuseFileSystem), nil
 }
 
-func (f *billyFuse) findChildInode(parent fuseops.InodeID, name string) (fuseops.InodeID, error) {
-	log.Println("fuse findChildInode()")
-	inode, err := f.getInode(parent)
+// processUid is the real uid of the process pid. FUSE only says which process made a request so it is read from
+// /proc, and ok is false if the process is already gone.
+func processUid(pid uint32) (uid uint32, ok bool) {
+	status, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
 	if err != nil {
-		return 0, fuse.EEXIST
-	}
-	if !inode.info.IsDir() {
-		return 0, fuse.ENOTDIR
+		return 0, false
 	}
-	for _, childId := range inode.Children {
-		inode, err = f.getInode(childId)
-		if err != nil {
-			continue
-		}
-		if inode.info.Name() == name {
-			return childId, nil
+	for _, line := range strings.Split(string(status), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "Uid:" {
+			uid, err := strconv.ParseUint(fields[1], 10, 32)
+			return uint32(uid), err == nil
 		}
 	}
-	return 0, fuse.ENOENT
+	return 0, false
+}
+
+// recordAccess writes op on path to the access log, falling back to the pid of the process that did it if its uid
+// can't be told.
+func (f *billyFuse) recordAccess(ctx fuseops.OpContext, op, path string) {
+	if f.accessLog == nil {
+		return
+	}
+
+	client := fmt.Sprintf("pid:%d", ctx.Pid)
+	if uid, ok := processUid(ctx.Pid); ok {
+		client = fmt.Sprintf("uid:%d", uid)
+	}
+	f.accessLog.Record(client, op, path)
 }
 
 func infoToAttributes(info os.FileInfo) fuseops.InodeAttributes {
-	log.Println("fuse infoToAttributes()")
 	mode := info.Mode()
 	if mode.IsDir() {
 		// make directories readable
@@ -179,81 +274,180 @@ func infoToAttributes(info os.FileInfo) fuseops.InodeAttributes {
 		Uid:    0,
 		Gid:    0,
 	}
-	log.Printf("%s attributes -> %v. Mode: %s", info.Name(), attributes, mode.String())
 	return attributes
 }
 
-func (f *billyFuse) LookUpInode(ctx context.Context, op *fuseops.LookUpInodeOp) error {
-	log.Println("fuse LookUpInode()")
-	// Find the child within the parent.
-	childId, err := f.findChildInode(op.Parent, op.Name)
-	if err != nil {
-		return err
+// attributes is infoToAttributes with the write bits cleared when the filesystem is read-only.
+func (f *billyFuse) attributes(info os.FileInfo) fuseops.InodeAttributes {
+	f.logger.Println("fuse infoToAttributes()")
+	attributes := infoToAttributes(info)
+	f.logger.Printf("%s attributes -> %v. Mode: %s", RedactPath(info.Name()), attributes, attributes.Mode.String())
+	if f.readOnly {
+		attributes.Mode &^= 0222
+	}
+	return attributes
+}
+
+func direntType(mode os.FileMode) fuseutil.DirentType {
+	if mode&os.ModeDir != 0 {
+		return fuseutil.DT_Directory
+	} else if mode&os.ModeSymlink != 0 {
+		return fuseutil.DT_Link
 	}
+	return fuseutil.DT_File
+}
 
-	inode, err := f.getInode(childId)
+func (f *billyFuse) LookUpInode(ctx context.Context, op *fuseops.LookUpInodeOp) (err error) {
+	f.logger.Println("fuse LookUpInode()")
+	defer f.tracer.Begin("fuse", "LookUpInode", op.Parent, redactedPath(op.Name)).End(&err)
+	defer f.recoverOp("LookUpInode", &err)
+	parent, err := f.getInode(op.Parent)
 	if err != nil {
 		return fuse.ENOENT
 	}
+	if !f.info(parent).IsDir() {
+		return fuse.ENOTDIR
+	}
+
+	op.Entry, err = f.lookUp(f.fs.Join(parent.path, op.Name))
+	return err
+}
+
+// lookUp adds a reference to the inode for path, creating it if the kernel doesn't know about it yet.
+func (f *billyFuse) lookUp(path string) (fuseops.ChildInodeEntry, error) {
+	info, err := f.fs.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fuseops.ChildInodeEntry{}, fuse.ENOENT
+	} else if err != nil {
+		return fuseops.ChildInodeEntry{}, fuse.EIO
+	}
+
+	f.mu.Lock()
+	id := f.inodeIdForPath(path)
+	inode, ok := f.inodes[id]
+	if !ok {
+		inode = &billyInode{
+			Id:   id,
+			path: path,
+		}
+		f.inodes[id] = inode
+		f.paths[path] = id
+	}
+	inode.info = info
+	inode.lookupCount += 1
+	f.mu.Unlock()
 
 	// Copy over information.
-	op.Entry.Child = childId
-	op.Entry.Attributes = infoToAttributes(inode.info)
-	op.Entry.AttributesExpiration = latest
-	op.Entry.EntryExpiration = latest
+	return fuseops.ChildInodeEntry{
+		Child:                id,
+		Attributes:           f.attributes(info),
+		AttributesExpiration: expiration(f.attributeTTL),
+		EntryExpiration:      expiration(f.entryTTL),
+	}, nil
+}
 
+// forget drops n references to the inode, deleting it when none are left. Must be called with f.mu held.
+func (f *billyFuse) forget(id fuseops.InodeID, n uint64) {
+	inode, ok := f.inodes[id]
+	if !ok || id == fuseops.RootInodeID {
+		return
+	}
+
+	if n >= inode.lookupCount {
+		delete(f.inodes, id)
+		delete(f.paths, inode.path)
+		return
+	}
+	inode.lookupCount -= n
+}
+
+func (f *billyFuse) ForgetInode(ctx context.Context, op *fuseops.ForgetInodeOp) (err error) {
+	f.logger.Println("fuse ForgetInode()")
+	defer f.tracer.Begin("fuse", "ForgetInode", op.Inode, op.N).End(&err)
+	defer f.recoverOp("ForgetInode", &err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.forget(op.Inode, op.N)
+	return nil
+}
+
+func (f *billyFuse) BatchForget(ctx context.Context, op *fuseops.BatchForgetOp) (err error) {
+	f.logger.Println("fuse BatchForget()")
+	defer f.tracer.Begin("fuse", "BatchForget", len(op.Entries)).End(&err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, entry := range op.Entries {
+		f.forget(entry.Inode, entry.N)
+	}
 	return nil
 }
 
-func (f *billyFuse) GetInodeAttributes(ctx context.Context, op *fuseops.GetInodeAttributesOp) error {
-	log.Println("fuse GetInodeAttributes()")
+func (f *billyFuse) GetInodeAttributes(ctx context.Context, op *fuseops.GetInodeAttributesOp) (err error) {
+	f.logger.Println("fuse GetInodeAttributes()")
+	defer f.tracer.Begin("fuse", "GetInodeAttributes", op.Inode).End(&err)
+	defer f.recoverOp("GetInodeAttributes", &err)
 	inode, err := f.getInode(op.Inode)
 	if err != nil {
 		return fuse.ENOENT
 	}
-	op.Attributes = infoToAttributes(inode.info)
-	op.AttributesExpiration = latest
+	op.AttributesExpiration = expiration(f.attributeTTL)
+	if f.attributeTTL != 0 {
+		// The kernel only asks again once what it had expired, so it should get what is there now.
+		op.Attributes, err = f.refresh(inode)
+		return err
+	}
+	op.Attributes = f.attributes(f.info(inode))
 	return nil
 }
 
-func (f *billyFuse) ReadDir(ctx context.Context, op *fuseops.ReadDirOp) error {
-	log.Println("fuse ReadDir()")
+func (f *billyFuse) OpenDir(ctx context.Context, op *fuseops.OpenDirOp) (err error) {
+	f.logger.Println("fuse OpenDir()")
+	defer f.tracer.Begin("fuse", "OpenDir", op.Inode).End(&err)
+	defer f.recoverOp("OpenDir", &err)
 	inode, err := f.getInode(op.Inode)
 	if err != nil {
 		return fuse.ENOENT
 	}
-
-	if !inode.info.IsDir() {
+	if !f.info(inode).IsDir() {
 		return fuse.ENOTDIR
 	}
 
-	var entries []fuseutil.Dirent
-	offset := 0
-	for _, child := range inode.Children {
-		childInode, err := f.getInode(child)
-		if err != nil {
-			return fuse.EIO
-		}
-		offset += 1
-
-		entType := fuseutil.DT_Unknown
-		mode := childInode.info.Mode()
-		if mode&os.ModeDir != 0 {
-			entType = fuseutil.DT_Directory
-		} else if mode&os.ModeSymlink != 0 {
-			entType = fuseutil.DT_Link
-		} else {
-			entType = fuseutil.DT_File
-		}
+	files, err := f.fs.ReadDir(inode.path)
+	if err != nil {
+		return toErrno(err)
+	}
+	f.recordAccess(op.OpContext, "list", inode.path)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
+	entries := make([]fuseutil.Dirent, 0, len(files))
+	for index, file := range files {
 		entries = append(entries, fuseutil.Dirent{
-			Offset: fuseops.DirOffset(offset),
-			Inode:  child,
-			Name:   childInode.info.Name(),
-			Type:   entType,
+			Offset: fuseops.DirOffset(index + 1),
+			Inode:  f.inodeIdForPath(f.fs.Join(inode.path, file.Name())),
+			Name:   file.Name(),
+			Type:   direntType(file.Mode()),
 		})
 	}
 
+	f.nextHandle += 1
+	op.Handle = f.nextHandle
+	f.directories[op.Handle] = entries
+	return nil
+}
+
+func (f *billyFuse) ReadDir(ctx context.Context, op *fuseops.ReadDirOp) (err error) {
+	f.logger.Println("fuse ReadDir()")
+	defer f.tracer.Begin("fuse", "ReadDir", op.Inode, op.Offset).End(&err)
+	defer f.recoverOp("ReadDir", &err)
+	f.mu.Lock()
+	entries, ok := f.directories[op.Handle]
+	f.mu.Unlock()
+	if !ok {
+		return fuse.EIO
+	}
+
 	// Grab the range of interest.
 	if op.Offset > fuseops.DirOffset(len(entries)) {
 		return fuse.EIO
@@ -274,36 +468,108 @@ func (f *billyFuse) ReadDir(ctx context.Context, op *fuseops.ReadDirOp) error {
 	return nil
 }
 
+func (f *billyFuse) ReleaseDirHandle(ctx context.Context, op *fuseops.ReleaseDirHandleOp) (err error) {
+	f.logger.Println("fuse ReleaseDirHandle()")
+	defer f.tracer.Begin("fuse", "ReleaseDirHandle", op.Handle).End(&err)
+	defer f.recoverOp("ReleaseDirHandle", &err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.directories, op.Handle)
+	return nil
+}
+
 func (f *billyFuse) getBillyPath(inodeId fuseops.InodeID) (string, error) {
-	log.Println("fuse getBillyPath()")
+	f.logger.Println("fuse getBillyPath()")
 	inode, err := f.getInode(inodeId)
 	if err != nil {
 		return "", fuse.EIO
 	}
+	return inode.path, nil
+}
 
-	path := ""
-	for inode.Id != fuseops.RootInodeID {
-		path = f.fs.Join(inode.info.Name(), path)
+func (f *billyFuse) OpenFile(ctx context.Context, op *fuseops.OpenFileOp) (err error) {
+	f.logger.Println("fuse OpenFile()")
+	defer f.tracer.Begin("fuse", "OpenFile", op.Inode).End(&err)
+	defer f.recoverOp("OpenFile", &err)
+	inode, err := f.getInode(op.Inode)
+	if err != nil {
+		return fuse.ENOENT
+	}
+	if f.info(inode).IsDir() {
+		return syscall.EISDIR
+	}
 
-		inode, err = f.getInode(inode.ParentId)
-		if err != nil {
-			return "", err
-		}
+	file, err := f.open(inode.path)
+	// Files that are only opened to be written to may not be readable. Reads open them again if they need to.
+	if err != nil && f.readOnly {
+		return err
+	}
+	f.recordAccess(op.OpContext, "read", inode.path)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if file != nil {
+		f.nextHandle += 1
+		op.Handle = f.nextHandle
+		f.files[op.Handle] = file
+	}
+	// Contents of a file never change underneath us so the kernel can keep what it has already read, unless the
+	// filesystem is expected to change and the same path may now be a different file.
+	op.KeepPageCache = f.immutable()
+	return nil
+}
+
+// open opens path for reading, answering with the errno the filesystem refused it with.
+func (f *billyFuse) open(path string) (billy.File, error) {
+	file, err := f.fs.Open(path)
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		// Decorators refuse some files with an errno, like EFBIG for files over --max-file-size.
+		return nil, errno
+	} else if err != nil {
+		return nil, fuse.EIO
 	}
-	return f.fs.Join(".", path), nil
+	return file, nil
 }
 
-func (f *billyFuse) ReadFile(ctx context.Context, op *fuseops.ReadFileOp) error {
-	log.Println("fuse ReadFile()")
+func (f *billyFuse) ReadSymlink(ctx context.Context, op *fuseops.ReadSymlinkOp) (err error) {
+	f.logger.Println("fuse ReadSymlink()")
+	defer f.tracer.Begin("fuse", "ReadSymlink", op.Inode).End(&err)
+	defer f.recoverOp("ReadSymlink", &err)
 	path, err := f.getBillyPath(op.Inode)
 	if err != nil {
 		return err
 	}
 
-	handle, err := f.fs.Open(path)
-	if err != nil {
+	target, err := f.fs.Readlink(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fuse.ENOENT
+	} else if err != nil {
 		return fuse.EIO
 	}
+	op.Target = target
+	return nil
+}
+
+func (f *billyFuse) ReadFile(ctx context.Context, op *fuseops.ReadFileOp) (err error) {
+	f.logger.Println("fuse ReadFile()")
+	defer f.tracer.Begin("fuse", "ReadFile", op.Inode, op.Offset, len(op.Dst)).End(&err)
+	defer f.recoverOp("ReadFile", &err)
+	f.mu.Lock()
+	handle, ok := f.files[op.Handle]
+	f.mu.Unlock()
+	// Files made with CreateFile have no handle of their own, so they are opened for each read.
+	if !ok {
+		path, err := f.getBillyPath(op.Inode)
+		if err != nil {
+			return err
+		}
+		handle, err = f.open(path)
+		if err != nil {
+			return err
+		}
+		defer handle.Close()
+	}
 
 	bytesRead, err := handle.ReadAt(op.Dst, op.Offset)
 	op.BytesRead = bytesRead
@@ -315,8 +581,360 @@ func (f *billyFuse) ReadFile(ctx context.Context, op *fuseops.ReadFileOp) error
 	return nil
 }
 
-func (f *billyFuse) StatFS(ctx context.Context, op *fuseops.StatFSOp) error {
-	log.Println("fuse StatFS()")
+// MimeTypeXattr is the extended attribute files carry their content type in, as DetectContentType guesses it.
+const MimeTypeXattr = "user.mime_type"
+
+func (f *billyFuse) ListXattr(ctx context.Context, op *fuseops.ListXattrOp) (err error) {
+	f.logger.Println("fuse ListXattr()")
+	defer f.tracer.Begin("fuse", "ListXattr", op.Inode).End(&err)
+	defer f.recoverOp("ListXattr", &err)
+	inode, err := f.getInode(op.Inode)
+	if err != nil {
+		return fuse.ENOENT
+	}
+	info := f.info(inode)
+	var names string
+	switch {
+	case info.Mode().IsRegular():
+		names = MimeTypeXattr + "\x00"
+	case info.IsDir() && f.treeSizes != nil:
+		names = TreeEntriesXattr + "\x00" + TreeSizeXattr + "\x00"
+	default:
+		return nil
+	}
+	op.BytesRead = len(names)
+	// An empty buffer asks how big the list is.
+	if len(op.Dst) == 0 {
+		return nil
+	}
+	if len(op.Dst) < len(names) {
+		return syscall.ERANGE
+	}
+	copy(op.Dst, names)
+	return nil
+}
+
+func (f *billyFuse) GetXattr(ctx context.Context, op *fuseops.GetXattrOp) (err error) {
+	f.logger.Println("fuse GetXattr()")
+	defer f.tracer.Begin("fuse", "GetXattr", op.Inode).End(&err)
+	defer f.recoverOp("GetXattr", &err)
+	inode, err := f.getInode(op.Inode)
+	if err != nil {
+		return fuse.ENOENT
+	}
+	value, err := f.xattr(inode, op.Name)
+	if err != nil {
+		return err
+	}
+	op.BytesRead = len(value)
+	// An empty buffer asks how big the value is.
+	if len(op.Dst) == 0 {
+		return nil
+	}
+	if len(op.Dst) < len(value) {
+		return syscall.ERANGE
+	}
+	copy(op.Dst, value)
+	return nil
+}
+
+// xattr is the value of the extended attribute called name of inode.
+func (f *billyFuse) xattr(inode *billyInode, name string) (string, error) {
+	info := f.info(inode)
+	switch {
+	case name == MimeTypeXattr && info.Mode().IsRegular():
+		contentType, err := DetectContentType(f.fs, inode.path, info)
+		if err != nil {
+			return "", toErrno(err)
+		}
+		return contentType, nil
+	case info.IsDir() && f.treeSizes != nil:
+		if _, ok := treeSizeXattr(name, TreeSize{}); !ok {
+			return "", fuse.ENOATTR
+		}
+		size, err := f.treeSizes.Directory(f.fs, inode.path, info)
+		if errors.Is(err, ErrNoTreeSize) {
+			return "", fuse.ENOATTR
+		} else if err != nil {
+			return "", toErrno(err)
+		}
+		value, _ := treeSizeXattr(name, size)
+		return value, nil
+	}
+	return "", fuse.ENOATTR
+}
+
+// FusePanics is how many FUSE operations have panicked in the process. Each one failed with EIO and left the mount
+// serving.
+func FusePanics() int64 {
+	return atomic.LoadInt64(&fusePanics)
+}
+
+// recoverOp fails the FUSE operation op with EIO instead of letting a panic in it crash the process and take the mount
+// down with it. It must be deferred by the operation, after anything deferred that has to see the error.
+func (f *billyFuse) recoverOp(op string, err *error) {
+	if r := recover(); r != nil {
+		atomic.AddInt64(&fusePanics, 1)
+		f.logger.Printf("fuse %s() panicked: %v\n%s", op, r, debug.Stack())
+		*err = fuse.EIO
+	}
+}
+
+// toErrno picks the error FUSE should return for an error from the billy.Filesystem.
+func toErrno(err error) error {
+	var errno syscall.Errno
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &errno):
+		return errno
+	case errors.Is(err, billy.ErrReadOnly):
+		return syscall.EROFS
+	case errors.Is(err, fs.ErrNotExist):
+		return fuse.ENOENT
+	case errors.Is(err, fs.ErrExist):
+		return fuse.EEXIST
+	case errors.Is(err, fs.ErrPermission):
+		return syscall.EACCES
+	default:
+		return fuse.EIO
+	}
+}
+
+// childPath is the path of name in the directory parent.
+func (f *billyFuse) childPath(parent fuseops.InodeID, name string) (string, error) {
+	inode, err := f.getInode(parent)
+	if err != nil {
+		return "", fuse.ENOENT
+	}
+	if !f.info(inode).IsDir() {
+		return "", fuse.ENOTDIR
+	}
+	return f.fs.Join(inode.path, name), nil
+}
+
+// refresh re-reads the attributes of an inode after it was changed.
+func (f *billyFuse) refresh(inode *billyInode) (fuseops.InodeAttributes, error) {
+	info, err := f.fs.Lstat(inode.path)
+	if err != nil {
+		return fuseops.InodeAttributes{}, toErrno(err)
+	}
+	f.mu.Lock()
+	inode.info = info
+	f.mu.Unlock()
+	return f.attributes(info), nil
+}
+
+// The operations below only succeed for paths the billy.Filesystem lets be written to, like those overlaid by
+// NewBuildCacheFileSystem. Everything else fails with EROFS.
+
+func (f *billyFuse) MkDir(ctx context.Context, op *fuseops.MkDirOp) (err error) {
+	f.logger.Println("fuse MkDir()")
+	defer f.tracer.Begin("fuse", "MkDir", op.Parent, redactedPath(op.Name)).End(&err)
+	defer f.recoverOp("MkDir", &err)
+	path, err := f.childPath(op.Parent, op.Name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.fs.Lstat(path); err == nil {
+		return fuse.EEXIST
+	}
+	if err := f.fs.MkdirAll(path, op.Mode.Perm()); err != nil {
+		return toErrno(err)
+	}
+	op.Entry, err = f.lookUp(path)
+	return err
+}
+
+func (f *billyFuse) CreateFile(ctx context.Context, op *fuseops.CreateFileOp) (err error) {
+	f.logger.Println("fuse CreateFile()")
+	defer f.tracer.Begin("fuse", "CreateFile", op.Parent, redactedPath(op.Name)).End(&err)
+	defer f.recoverOp("CreateFile", &err)
+	path, err := f.childPath(op.Parent, op.Name)
+	if err != nil {
+		return err
+	}
+	file, err := f.fs.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, op.Mode.Perm())
+	if err != nil {
+		return toErrno(err)
+	}
+	if err := file.Close(); err != nil {
+		return toErrno(err)
+	}
+	f.recordAccess(op.OpContext, "write", path)
+	op.Entry, err = f.lookUp(path)
+	return err
+}
+
+func (f *billyFuse) CreateSymlink(ctx context.Context, op *fuseops.CreateSymlinkOp) (err error) {
+	f.logger.Println("fuse CreateSymlink()")
+	defer f.tracer.Begin("fuse", "CreateSymlink", op.Parent, redactedPath(op.Name), redactedPath(op.Target)).End(&err)
+	defer f.recoverOp("CreateSymlink", &err)
+	path, err := f.childPath(op.Parent, op.Name)
+	if err != nil {
+		return err
+	}
+	if err := f.fs.Symlink(op.Target, path); err != nil {
+		return toErrno(err)
+	}
+	op.Entry, err = f.lookUp(path)
+	return err
+}
+
+func (f *billyFuse) WriteFile(ctx context.Context, op *fuseops.WriteFileOp) (err error) {
+	f.logger.Println("fuse WriteFile()")
+	defer f.tracer.Begin("fuse", "WriteFile", op.Inode, op.Offset, len(op.Data)).End(&err)
+	defer f.recoverOp("WriteFile", &err)
+	inode, err := f.getInode(op.Inode)
+	if err != nil {
+		return fuse.ENOENT
+	}
+	if f.authorizeWrite != nil {
+		uid, ok := processUid(op.OpContext.Pid)
+		if !ok {
+			return syscall.EACCES
+		}
+		if err := f.authorizeWrite(uid, inode.path); err != nil {
+			return toErrno(err)
+		}
+	}
+
+	file, err := f.fs.OpenFile(inode.path, os.O_WRONLY, 0)
+	if err != nil {
+		return toErrno(err)
+	}
+	defer file.Close()
+	if _, err := file.Seek(op.Offset, io.SeekStart); err != nil {
+		return toErrno(err)
+	}
+	if _, err := file.Write(op.Data); err != nil {
+		return toErrno(err)
+	}
+	if err := file.Close(); err != nil {
+		return toErrno(err)
+	}
+	_, err = f.refresh(inode)
+	return err
+}
+
+func (f *billyFuse) SetInodeAttributes(ctx context.Context, op *fuseops.SetInodeAttributesOp) (err error) {
+	f.logger.Println("fuse SetInodeAttributes()")
+	defer f.tracer.Begin("fuse", "SetInodeAttributes", op.Inode).End(&err)
+	defer f.recoverOp("SetInodeAttributes", &err)
+	inode, err := f.getInode(op.Inode)
+	if err != nil {
+		return fuse.ENOENT
+	}
+
+	// Only truncating is supported. Modes and times are left as they are.
+	if op.Size != nil {
+		file, err := f.fs.OpenFile(inode.path, os.O_WRONLY, 0)
+		if err != nil {
+			return toErrno(err)
+		}
+		defer file.Close()
+		if err := file.Truncate(int64(*op.Size)); err != nil {
+			return toErrno(err)
+		}
+	}
+
+	op.Attributes, err = f.refresh(inode)
+	op.AttributesExpiration = expiration(f.attributeTTL)
+	return err
+}
+
+func (f *billyFuse) Unlink(ctx context.Context, op *fuseops.UnlinkOp) (err error) {
+	f.logger.Println("fuse Unlink()")
+	defer f.tracer.Begin("fuse", "Unlink", op.Parent, redactedPath(op.Name)).End(&err)
+	defer f.recoverOp("Unlink", &err)
+	path, err := f.childPath(op.Parent, op.Name)
+	if err != nil {
+		return err
+	}
+	return toErrno(f.fs.Remove(path))
+}
+
+func (f *billyFuse) RmDir(ctx context.Context, op *fuseops.RmDirOp) (err error) {
+	f.logger.Println("fuse RmDir()")
+	defer f.tracer.Begin("fuse", "RmDir", op.Parent, redactedPath(op.Name)).End(&err)
+	defer f.recoverOp("RmDir", &err)
+	path, err := f.childPath(op.Parent, op.Name)
+	if err != nil {
+		return err
+	}
+	names, err := ReadDirNames(f.fs, path)
+	if err != nil {
+		return toErrno(err)
+	}
+	if len(names) != 0 {
+		return fuse.ENOTEMPTY
+	}
+	return toErrno(f.fs.Remove(path))
+}
+
+func (f *billyFuse) Rename(ctx context.Context, op *fuseops.RenameOp) (err error) {
+	f.logger.Println("fuse Rename()")
+	defer f.tracer.Begin("fuse", "Rename", op.OldParent, redactedPath(op.OldName), op.NewParent, redactedPath(op.NewName)).End(&err)
+	defer f.recoverOp("Rename", &err)
+	from, err := f.childPath(op.OldParent, op.OldName)
+	if err != nil {
+		return err
+	}
+	to, err := f.childPath(op.NewParent, op.NewName)
+	if err != nil {
+		return err
+	}
+	if err := f.fs.Rename(from, to); err != nil {
+		return toErrno(err)
+	}
+
+	// The kernel keeps using the same inodes for the renamed file and anything under it.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, inode := range f.inodes {
+		if inode.path != from && !strings.HasPrefix(inode.path, from+"/") {
+			continue
+		}
+		delete(f.paths, inode.path)
+		inode.path = to + strings.TrimPrefix(inode.path, from)
+		f.paths[inode.path] = inode.Id
+	}
+	return nil
+}
+
+func (f *billyFuse) SyncFile(ctx context.Context, op *fuseops.SyncFileOp) (err error) {
+	f.logger.Println("fuse SyncFile()")
+	defer f.tracer.Begin("fuse", "SyncFile", op.Inode).End(&err)
+	defer f.recoverOp("SyncFile", &err)
+	// Every write is handed to the billy.Filesystem as soon as it arrives.
+	return nil
+}
+
+func (f *billyFuse) FlushFile(ctx context.Context, op *fuseops.FlushFileOp) (err error) {
+	f.logger.Println("fuse FlushFile()")
+	defer f.tracer.Begin("fuse", "FlushFile", op.Inode).End(&err)
+	defer f.recoverOp("FlushFile", &err)
+	return nil
+}
+
+func (f *billyFuse) ReleaseFileHandle(ctx context.Context, op *fuseops.ReleaseFileHandleOp) (err error) {
+	f.logger.Println("fuse ReleaseFileHandle()")
+	defer f.tracer.Begin("fuse", "ReleaseFileHandle", op.Handle).End(&err)
+	defer f.recoverOp("ReleaseFileHandle", &err)
+	f.mu.Lock()
+	file, ok := f.files[op.Handle]
+	delete(f.files, op.Handle)
+	f.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return toErrno(file.Close())
+}
+
+func (f *billyFuse) StatFS(ctx context.Context, op *fuseops.StatFSOp) (err error) {
+	f.logger.Println("fuse StatFS()")
+	defer f.tracer.Begin("fuse", "StatFS").End(&err)
+	defer f.recoverOp("StatFS", &err)
 	_ = ctx
 	_ = op
 	return nil
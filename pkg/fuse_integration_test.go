@@ -0,0 +1,209 @@
+//go:build integration
+// +build integration
+
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Integration tests that mount a playbook repository through the kernel. These need /dev/fuse and fusermount so they
+// only run when asked for:
+//
+//	go test -tags integration ./pkg/
+
+package pkg
+
+import (
+	"errors"
+	"github.com/jacobsa/fuse"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+)
+
+func mountPlaybook(t *testing.T, playbook string) string {
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skipf("/dev/fuse is not available: %v", err)
+	}
+
+	git := newGitCliFromPlaybook(t, playbook)
+	fs := NewReferenceFileSystem(git, BranchRef("master"))
+
+	server, err := NewBillyFuseServer(fs)
+	if err != nil {
+		t.Fatalf("NewBillyFuseServer() failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	_, err = fuse.Mount(dir, server, &fuse.MountConfig{
+		ReadOnly:                  true,
+		DisableWritebackCaching:   true,
+		DisableDefaultPermissions: true,
+	})
+	if err != nil {
+		t.Fatalf("fuse.Mount() failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := fuse.Unmount(dir); err != nil {
+			t.Errorf("fuse.Unmount() failed: %v", err)
+		}
+	})
+	return dir
+}
+
+func TestKernelMount(t *testing.T) {
+	dir := mountPlaybook(t, "base")
+
+	t.Run("open", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(dir, "real.txt"))
+		if err != nil {
+			t.Fatalf("ReadFile(real.txt) failed: %v", err)
+		}
+		if string(data) != "Hello World\n" {
+			t.Fatalf("real.txt contained %q", data)
+		}
+
+		data, err = os.ReadFile(filepath.Join(dir, "test", "nested.txt"))
+		if err != nil {
+			t.Fatalf("ReadFile(test/nested.txt) failed: %v", err)
+		}
+		if string(data) != "Nested file\n" {
+			t.Fatalf("test/nested.txt contained %q", data)
+		}
+	})
+
+	t.Run("readdir", func(t *testing.T) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir(/) failed: %v", err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		sort.Strings(names)
+
+		want := []string{"executable.sh", "real.txt", "symlink.txt", "test"}
+		if len(names) != len(want) {
+			t.Fatalf("ReadDir(/) returned %v, want %v", names, want)
+		}
+		for i := range want {
+			if names[i] != want[i] {
+				t.Fatalf("ReadDir(/) returned %v, want %v", names, want)
+			}
+		}
+	})
+
+	t.Run("readlink", func(t *testing.T) {
+		target, err := os.Readlink(filepath.Join(dir, "symlink.txt"))
+		if err != nil {
+			t.Fatalf("Readlink(symlink.txt) failed: %v", err)
+		}
+		if target != "real.txt" {
+			t.Fatalf("symlink.txt -> %s, want real.txt", target)
+		}
+
+		target, err = os.Readlink(filepath.Join(dir, "test", "escaping.txt"))
+		if err != nil {
+			t.Fatalf("Readlink(test/escaping.txt) failed: %v", err)
+		}
+		if target != "../real.txt" {
+			t.Fatalf("test/escaping.txt -> %s, want ../real.txt", target)
+		}
+	})
+
+	t.Run("mmap", func(t *testing.T) {
+		file, err := os.Open(filepath.Join(dir, "real.txt"))
+		if err != nil {
+			t.Fatalf("Open(real.txt) failed: %v", err)
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			t.Fatalf("Stat(real.txt) failed: %v", err)
+		}
+
+		data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			t.Fatalf("Mmap(real.txt) failed: %v", err)
+		}
+		defer syscall.Munmap(data)
+
+		if string(data) != "Hello World\n" {
+			t.Fatalf("mapped real.txt contained %q", data)
+		}
+	})
+
+	t.Run("writes fail with EROFS", func(t *testing.T) {
+		attempts := map[string]func() error{
+			"open for writing": func() error {
+				file, err := os.OpenFile(filepath.Join(dir, "real.txt"), os.O_WRONLY, 0)
+				if err == nil {
+					file.Close()
+				}
+				return err
+			},
+			"create": func() error {
+				return os.WriteFile(filepath.Join(dir, "new.txt"), []byte("data"), 0644)
+			},
+			"mkdir": func() error {
+				return os.Mkdir(filepath.Join(dir, "new"), 0755)
+			},
+			"remove": func() error {
+				return os.Remove(filepath.Join(dir, "real.txt"))
+			},
+			"rename": func() error {
+				return os.Rename(filepath.Join(dir, "real.txt"), filepath.Join(dir, "renamed.txt"))
+			},
+			"symlink": func() error {
+				return os.Symlink("real.txt", filepath.Join(dir, "link.txt"))
+			},
+			"chmod": func() error {
+				return os.Chmod(filepath.Join(dir, "real.txt"), 0777)
+			},
+			"truncate": func() error {
+				return os.Truncate(filepath.Join(dir, "real.txt"), 0)
+			},
+		}
+
+		for name, attempt := range attempts {
+			if err := attempt(); !errors.Is(err, syscall.EROFS) {
+				t.Errorf("%s: expected EROFS, got: %v", name, err)
+			}
+		}
+	})
+}
+
+func TestKernelMountExec(t *testing.T) {
+	dir := mountPlaybook(t, "executables")
+
+	t.Run("binary", func(t *testing.T) {
+		// The loader maps the binary into memory so this only works if the size the kernel was given is exact.
+		if err := exec.Command(filepath.Join(dir, "bin", "true")).Run(); err != nil {
+			t.Fatalf("running bin/true failed: %v", err)
+		}
+	})
+
+	t.Run("script", func(t *testing.T) {
+		output, err := exec.Command(filepath.Join(dir, "bin", "hello.sh")).Output()
+		if err != nil {
+			t.Fatalf("running bin/hello.sh failed: %v", err)
+		}
+		if string(output) != "Hello World\n" {
+			t.Fatalf("bin/hello.sh printed %q", output)
+		}
+	})
+}
@@ -0,0 +1,474 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFuseInodeLifetime(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "base")
+	fileSystem, err := NewBillyFuse(NewReferenceFileSystem(git, BranchRef("master")))
+	if err != nil {
+		t.Fatalf("NewBillyFuse() failed: %v", err)
+	}
+	f := fileSystem.(*billyFuse)
+	ctx := context.Background()
+
+	lookUp := func(parent fuseops.InodeID, name string) fuseops.InodeID {
+		op := &fuseops.LookUpInodeOp{Parent: parent, Name: name}
+		if err := f.LookUpInode(ctx, op); err != nil {
+			t.Fatalf("LookUpInode(%d, %s) failed: %v", parent, name, err)
+		}
+		return op.Entry.Child
+	}
+
+	if len(f.inodes) != 1 {
+		t.Fatalf("only the root should exist before any lookups, found %d inodes", len(f.inodes))
+	}
+
+	test := lookUp(fuseops.RootInodeID, "test")
+	nested := lookUp(test, "nested.txt")
+	if again := lookUp(test, "nested.txt"); again != nested {
+		t.Fatalf("looking up test/nested.txt twice returned different inodes: %d, %d", nested, again)
+	}
+	if len(f.inodes) != 3 {
+		t.Fatalf("expected root, test, and test/nested.txt inodes, found %d", len(f.inodes))
+	}
+
+	if err := f.LookUpInode(ctx, &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "missing"}); err == nil {
+		t.Fatal("LookUpInode() found a file that doesn't exist")
+	}
+
+	t.Run("listing reports lookup inodes", func(t *testing.T) {
+		op := &fuseops.OpenDirOp{Inode: test}
+		if err := f.OpenDir(ctx, op); err != nil {
+			t.Fatalf("OpenDir(test) failed: %v", err)
+		}
+		defer f.ReleaseDirHandle(ctx, &fuseops.ReleaseDirHandleOp{Handle: op.Handle})
+
+		for _, entry := range f.directories[op.Handle] {
+			if entry.Name == "nested.txt" && entry.Inode != nested {
+				t.Fatalf("listing reported inode %d for nested.txt, lookup returned %d", entry.Inode, nested)
+			}
+		}
+	})
+
+	t.Run("forgetting", func(t *testing.T) {
+		_ = f.ForgetInode(ctx, &fuseops.ForgetInodeOp{Inode: nested, N: 1})
+		if _, ok := f.inodes[nested]; !ok {
+			t.Fatal("test/nested.txt was dropped while the kernel still held a reference")
+		}
+
+		_ = f.BatchForget(ctx, &fuseops.BatchForgetOp{Entries: []fuseops.BatchForgetEntry{
+			{Inode: nested, N: 1},
+			{Inode: test, N: 1},
+			{Inode: fuseops.RootInodeID, N: 1},
+		}})
+		if len(f.inodes) != 1 || len(f.paths) != 1 {
+			t.Fatalf("expected only the root to remain, found %d inodes and %d paths", len(f.inodes), len(f.paths))
+		}
+	})
+}
+
+func TestFuseWrites(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "buildcache")
+	cache := memfs.New()
+	fs, err := NewBuildCacheFileSystem(NewReferenceFileSystem(git, BranchRef("master")), cache)
+	if err != nil {
+		t.Fatalf("NewBuildCacheFileSystem() failed: %v", err)
+	}
+	fileSystem, err := NewBillyFuse(fs)
+	if err != nil {
+		t.Fatalf("NewBillyFuse() failed: %v", err)
+	}
+	f := fileSystem.(*billyFuse)
+	ctx := context.Background()
+
+	mkdir := &fuseops.MkDirOp{Parent: fuseops.RootInodeID, Name: "build", Mode: 0755}
+	if err := f.MkDir(ctx, mkdir); err != nil {
+		t.Fatalf("MkDir(build) failed: %v", err)
+	}
+	create := &fuseops.CreateFileOp{Parent: mkdir.Entry.Child, Name: "main", Mode: 0755}
+	if err := f.CreateFile(ctx, create); err != nil {
+		t.Fatalf("CreateFile(build/main) failed: %v", err)
+	}
+	write := &fuseops.WriteFileOp{Inode: create.Entry.Child, Offset: 0, Data: []byte("Hello World")}
+	if err := f.WriteFile(ctx, write); err != nil {
+		t.Fatalf("WriteFile(build/main) failed: %v", err)
+	}
+	size := uint64(5)
+	truncate := &fuseops.SetInodeAttributesOp{Inode: create.Entry.Child, Size: &size}
+	if err := f.SetInodeAttributes(ctx, truncate); err != nil || truncate.Attributes.Size != 5 {
+		t.Fatalf("SetInodeAttributes(build/main) returned %d bytes, %v", truncate.Attributes.Size, err)
+	}
+	if contents, err := util.ReadFile(cache, "build/main"); err != nil || string(contents) != "Hello" {
+		t.Fatalf("build/main contained %q, %v", contents, err)
+	}
+
+	rename := &fuseops.RenameOp{OldParent: fuseops.RootInodeID, OldName: "build", NewParent: fuseops.RootInodeID, NewName: "node_modules"}
+	if err := f.Rename(ctx, rename); err != nil {
+		t.Fatalf("Rename(build, node_modules) failed: %v", err)
+	}
+	if path, err := f.getBillyPath(create.Entry.Child); err != nil || path != "node_modules/main" {
+		t.Fatalf("build/main was not moved to node_modules/main: %s, %v", path, err)
+	}
+	if err := f.RmDir(ctx, &fuseops.RmDirOp{Parent: fuseops.RootInodeID, Name: "node_modules"}); err != fuse.ENOTEMPTY {
+		t.Fatalf("RmDir(node_modules) should fail with ENOTEMPTY: %v", err)
+	}
+	if err := f.Unlink(ctx, &fuseops.UnlinkOp{Parent: mkdir.Entry.Child, Name: "main"}); err != nil {
+		t.Fatalf("Unlink(node_modules/main) failed: %v", err)
+	}
+
+	err = f.CreateFile(ctx, &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: "main.c", Mode: 0644})
+	if err != syscall.EROFS {
+		t.Fatalf("CreateFile(main.c) should fail with EROFS: %v", err)
+	}
+}
+
+func TestFuseMimeTypeXattr(t *testing.T) {
+	fs := memfs.New()
+	if err := util.WriteFile(fs, "image", []byte("\x89PNG\r\n\x1a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewBillyFuse(fs)
+	if err != nil {
+		t.Fatalf("NewBillyFuse() failed: %v", err)
+	}
+	f := fileSystem.(*billyFuse)
+	ctx := context.Background()
+
+	lookUp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "image"}
+	if err := f.LookUpInode(ctx, lookUp); err != nil {
+		t.Fatalf("LookUpInode(image) failed: %v", err)
+	}
+	image := lookUp.Entry.Child
+
+	list := &fuseops.ListXattrOp{Inode: image, Dst: make([]byte, 64)}
+	if err := f.ListXattr(ctx, list); err != nil || string(list.Dst[:list.BytesRead]) != MimeTypeXattr+"\x00" {
+		t.Fatalf("ListXattr(image) = %q, %v", list.Dst[:list.BytesRead], err)
+	}
+
+	size := &fuseops.GetXattrOp{Inode: image, Name: MimeTypeXattr}
+	if err := f.GetXattr(ctx, size); err != nil || size.BytesRead != len("image/png") {
+		t.Fatalf("GetXattr(image) with no buffer returned %d bytes, %v", size.BytesRead, err)
+	}
+	if err := f.GetXattr(ctx, &fuseops.GetXattrOp{Inode: image, Name: MimeTypeXattr, Dst: make([]byte, 2)}); err != syscall.ERANGE {
+		t.Fatalf("GetXattr(image) into a short buffer returned %v, expected ERANGE", err)
+	}
+	get := &fuseops.GetXattrOp{Inode: image, Name: MimeTypeXattr, Dst: make([]byte, 64)}
+	if err := f.GetXattr(ctx, get); err != nil || string(get.Dst[:get.BytesRead]) != "image/png" {
+		t.Fatalf("GetXattr(image) = %q, %v", get.Dst[:get.BytesRead], err)
+	}
+
+	if err := f.GetXattr(ctx, &fuseops.GetXattrOp{Inode: image, Name: "user.other"}); err != fuse.ENOATTR {
+		t.Fatalf("GetXattr(image, user.other) returned %v, expected ENOATTR", err)
+	}
+	if err := f.GetXattr(ctx, &fuseops.GetXattrOp{Inode: fuseops.RootInodeID, Name: MimeTypeXattr}); err != fuse.ENOATTR {
+		t.Fatalf("GetXattr(.) returned %v, expected ENOATTR for a directory", err)
+	}
+}
+
+func TestFuseTreeSizeXattr(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "dates")
+	reference := BranchRef("master")
+	fs := NewIntrospectionFileSystem(NewReferenceFileSystem(git, reference), git, reference)
+	fileSystem, err := NewBillyFuseWithOptions(fs, FuseOptions{TreeSizes: NewTreeSizes(git)})
+	if err != nil {
+		t.Fatalf("NewBillyFuseWithOptions() failed: %v", err)
+	}
+	f := fileSystem.(*billyFuse)
+	ctx := context.Background()
+
+	lookUp := func(t *testing.T, name string) fuseops.InodeID {
+		op := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: name}
+		if err := f.LookUpInode(ctx, op); err != nil {
+			t.Fatalf("LookUpInode(%s) failed: %v", name, err)
+		}
+		return op.Entry.Child
+	}
+	get := func(t *testing.T, inode fuseops.InodeID, name string) string {
+		op := &fuseops.GetXattrOp{Inode: inode, Name: name, Dst: make([]byte, 64)}
+		if err := f.GetXattr(ctx, op); err != nil {
+			t.Fatalf("GetXattr(%d, %s) failed: %v", inode, name, err)
+		}
+		return string(op.Dst[:op.BytesRead])
+	}
+
+	old := lookUp(t, "old")
+	list := &fuseops.ListXattrOp{Inode: old, Dst: make([]byte, 64)}
+	if err := f.ListXattr(ctx, list); err != nil || string(list.Dst[:list.BytesRead]) != TreeEntriesXattr+"\x00"+TreeSizeXattr+"\x00" {
+		t.Fatalf("ListXattr(old) = %q, %v", list.Dst[:list.BytesRead], err)
+	}
+
+	oldSize := len("Committed first and never changed again.\n")
+	newSize := len("Committed second.\n")
+	tests := map[fuseops.InodeID][2]string{
+		old:                 {"1", strconv.Itoa(oldSize)},
+		fuseops.RootInodeID: {"2", strconv.Itoa(oldSize + newSize)},
+	}
+	for inode, want := range tests {
+		if entries, size := get(t, inode, TreeEntriesXattr), get(t, inode, TreeSizeXattr); entries != want[0] || size != want[1] {
+			t.Fatalf("inode %d has %s entries of %s bytes, expected %s entries of %s bytes", inode, entries, size, want[0], want[1])
+		}
+	}
+
+	// Directories that aren't trees in git can't be measured.
+	if err := f.GetXattr(ctx, &fuseops.GetXattrOp{Inode: lookUp(t, IntrospectionDirectory), Name: TreeSizeXattr}); err != fuse.ENOATTR {
+		t.Fatalf("GetXattr(.gitfs) returned %v, expected ENOATTR", err)
+	}
+	if err := f.GetXattr(ctx, &fuseops.GetXattrOp{Inode: old, Name: MimeTypeXattr}); err != fuse.ENOATTR {
+		t.Fatalf("GetXattr(old, %s) returned %v, expected ENOATTR for a directory", MimeTypeXattr, err)
+	}
+}
+
+func TestFuseReadOnlyMode(t *testing.T) {
+	git := NewFakeGit(nil)
+	git.Commit("master", "Add a script", map[string]FakeFile{
+		"bin/hello.sh": {Contents: "#!/bin/sh\necho \"Hello World\"\n", Mode: FakeModeExecutable},
+	})
+	fs := NewReferenceFileSystem(git, BranchRef("master"))
+	ctx := context.Background()
+
+	modes := func(t *testing.T, options FuseOptions) map[string]os.FileMode {
+		fileSystem, err := NewBillyFuseWithOptions(fs, options)
+		if err != nil {
+			t.Fatalf("NewBillyFuseWithOptions() failed: %v", err)
+		}
+		f := fileSystem.(*billyFuse)
+
+		modes := map[string]os.FileMode{}
+		parent := fuseops.InodeID(fuseops.RootInodeID)
+		for _, name := range []string{"bin", "hello.sh"} {
+			op := &fuseops.LookUpInodeOp{Parent: parent, Name: name}
+			if err := f.LookUpInode(ctx, op); err != nil {
+				t.Fatalf("LookUpInode(%s) failed: %v", name, err)
+			}
+			attributes := &fuseops.GetInodeAttributesOp{Inode: op.Entry.Child}
+			if err := f.GetInodeAttributes(ctx, attributes); err != nil {
+				t.Fatalf("GetInodeAttributes(%s) failed: %v", name, err)
+			}
+			if attributes.Attributes.Mode != op.Entry.Attributes.Mode {
+				t.Fatalf("%s looked up as %s but has attributes %s", name, op.Entry.Attributes.Mode, attributes.Attributes.Mode)
+			}
+			modes[name] = op.Entry.Attributes.Mode
+			parent = op.Entry.Child
+		}
+		return modes
+	}
+
+	writable := modes(t, FuseOptions{})
+	readOnly := modes(t, FuseOptions{ReadOnly: true})
+	if writable["hello.sh"]&0222 == 0 {
+		t.Fatalf("hello.sh is %s, expected it to be writable without ReadOnly", writable["hello.sh"])
+	}
+	for name, mode := range writable {
+		if want := mode &^ 0222; readOnly[name] != want {
+			t.Fatalf("%s is %s with ReadOnly, expected %s", name, readOnly[name], want)
+		}
+	}
+}
+
+func TestFuseExpiration(t *testing.T) {
+	backing := memfs.New()
+	if err := util.WriteFile(backing, "file.txt", []byte("short"), 0644); err != nil {
+		t.Fatalf("failed to write file.txt: %v", err)
+	}
+	ctx := context.Background()
+
+	t.Run("forever by default", func(t *testing.T) {
+		fileSystem, err := NewBillyFuse(backing)
+		if err != nil {
+			t.Fatalf("NewBillyFuse() failed: %v", err)
+		}
+		f := fileSystem.(*billyFuse)
+		op := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "file.txt"}
+		if err := f.LookUpInode(ctx, op); err != nil {
+			t.Fatalf("LookUpInode(file.txt) failed: %v", err)
+		}
+		if !op.Entry.AttributesExpiration.Equal(latest) || !op.Entry.EntryExpiration.Equal(latest) {
+			t.Fatalf("LookUpInode() expires at %v and %v, expected never", op.Entry.AttributesExpiration, op.Entry.EntryExpiration)
+		}
+		open := &fuseops.OpenFileOp{Inode: op.Entry.Child}
+		if err := f.OpenFile(ctx, open); err != nil || !open.KeepPageCache {
+			t.Fatalf("OpenFile() should keep the page cache of an immutable filesystem: %v", err)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		fileSystem, err := NewBillyFuseWithOptions(backing, FuseOptions{AttributeTTL: 1500 * time.Millisecond, EntryTTL: time.Nanosecond})
+		if err != nil {
+			t.Fatalf("NewBillyFuseWithOptions() failed: %v", err)
+		}
+		f := fileSystem.(*billyFuse)
+		before := time.Now()
+		op := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "file.txt"}
+		if err := f.LookUpInode(ctx, op); err != nil {
+			t.Fatalf("LookUpInode(file.txt) failed: %v", err)
+		}
+		if expires := op.Entry.AttributesExpiration.Sub(before); expires < 1500*time.Millisecond || expires > time.Minute {
+			t.Fatalf("attributes expire after %v, expected 1.5s", expires)
+		}
+		if expires := op.Entry.EntryExpiration.Sub(before); expires < time.Nanosecond || expires > time.Minute {
+			t.Fatalf("entry expires after %v, expected 1ns", expires)
+		}
+
+		if err := util.WriteFile(backing, "file.txt", []byte("much longer"), 0644); err != nil {
+			t.Fatalf("failed to rewrite file.txt: %v", err)
+		}
+		attributes := &fuseops.GetInodeAttributesOp{Inode: op.Entry.Child}
+		if err := f.GetInodeAttributes(ctx, attributes); err != nil {
+			t.Fatalf("GetInodeAttributes() failed: %v", err)
+		}
+		if attributes.Attributes.Size != uint64(len("much longer")) {
+			t.Fatalf("GetInodeAttributes() reported %d bytes after the file changed", attributes.Attributes.Size)
+		}
+
+		open := &fuseops.OpenFileOp{Inode: op.Entry.Child}
+		if err := f.OpenFile(ctx, open); err != nil || open.KeepPageCache {
+			t.Fatalf("OpenFile() should drop the page cache when attributes expire: %v", err)
+		}
+
+		// Refreshes replace what is known about the inode while other operations read it, which -race checks.
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_ = f.GetInodeAttributes(ctx, &fuseops.GetInodeAttributesOp{Inode: op.Entry.Child})
+			}()
+			go func() {
+				defer wg.Done()
+				_ = f.ListXattr(ctx, &fuseops.ListXattrOp{Inode: op.Entry.Child})
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+// panickingFileSystem panics when a path it was told to is looked up.
+type panickingFileSystem struct {
+	billy.Filesystem
+	path string
+}
+
+func (p panickingFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	if filename == p.path {
+		panic("index out of range")
+	}
+	return p.Filesystem.Lstat(filename)
+}
+
+func TestFusePanicRecovery(t *testing.T) {
+	backing := memfs.New()
+	for _, name := range []string{"bad.txt", "good.txt"} {
+		if err := util.WriteFile(backing, name, []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	fileSystem, err := NewBillyFuse(panickingFileSystem{Filesystem: backing, path: "bad.txt"})
+	if err != nil {
+		t.Fatalf("NewBillyFuse() failed: %v", err)
+	}
+	f := fileSystem.(*billyFuse)
+	ctx := context.Background()
+
+	panics := FusePanics()
+	err = f.LookUpInode(ctx, &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "bad.txt"})
+	if err != fuse.EIO {
+		t.Fatalf("LookUpInode(bad.txt) = %v, expected EIO", err)
+	}
+	if FusePanics() != panics+1 {
+		t.Fatalf("FusePanics() = %d after a panic, expected %d", FusePanics(), panics+1)
+	}
+	if err := f.LookUpInode(ctx, &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "good.txt"}); err != nil {
+		t.Fatalf("LookUpInode(good.txt) failed after a panic: %v", err)
+	}
+}
+
+// openCountingFileSystem counts how many times files are opened and closed.
+type openCountingFileSystem struct {
+	billy.Filesystem
+	opens, closes *int
+}
+
+type closeCountingFile struct {
+	billy.File
+	closes *int
+}
+
+func (f closeCountingFile) Close() error {
+	*f.closes++
+	return f.File.Close()
+}
+
+func (s openCountingFileSystem) Open(filename string) (billy.File, error) {
+	file, err := s.Filesystem.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	*s.opens++
+	return closeCountingFile{File: file, closes: s.closes}, nil
+}
+
+func TestFuseFileHandles(t *testing.T) {
+	backing := memfs.New()
+	if err := util.WriteFile(backing, "file.txt", []byte("Hello World\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var opens, closes int
+	fileSystem, err := NewBillyFuseWithOptions(openCountingFileSystem{Filesystem: backing, opens: &opens, closes: &closes}, FuseOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("NewBillyFuseWithOptions() failed: %v", err)
+	}
+	f := fileSystem.(*billyFuse)
+	ctx := context.Background()
+
+	lookUp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "file.txt"}
+	if err := f.LookUpInode(ctx, lookUp); err != nil {
+		t.Fatalf("LookUpInode(file.txt) failed: %v", err)
+	}
+	open := &fuseops.OpenFileOp{Inode: lookUp.Entry.Child}
+	if err := f.OpenFile(ctx, open); err != nil {
+		t.Fatalf("OpenFile(file.txt) failed: %v", err)
+	}
+	for offset := int64(0); offset < 12; offset += 4 {
+		read := &fuseops.ReadFileOp{Inode: lookUp.Entry.Child, Handle: open.Handle, Offset: offset, Dst: make([]byte, 4)}
+		if err := f.ReadFile(ctx, read); err != nil || read.BytesRead != 4 {
+			t.Fatalf("ReadFile(file.txt, %d) read %d bytes: %v", offset, read.BytesRead, err)
+		}
+	}
+	if opens != 1 || closes != 0 {
+		t.Fatalf("reading through one handle opened file.txt %d times and closed it %d times", opens, closes)
+	}
+
+	if err := f.ReleaseFileHandle(ctx, &fuseops.ReleaseFileHandleOp{Handle: open.Handle}); err != nil {
+		t.Fatalf("ReleaseFileHandle() failed: %v", err)
+	}
+	if closes != 1 || len(f.files) != 0 {
+		t.Fatalf("releasing the handle closed file.txt %d times and kept %d files", closes, len(f.files))
+	}
+}
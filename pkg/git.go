@@ -18,65 +18,302 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gravypod/gitfs/pkg/gitism"
+	"log"
+	"strconv"
+	"time"
 )
 
 var (
-	ErrNoTreeLikeSpecified   = errors.New("cannot identify tree")
-	ErrCannotListCommit      = errors.New("cannot list commit")
-	ErrMultipleRefsSpecified = errors.New("only specify Commit, Branch, or Tag")
+	ErrNoTreeLikeSpecified = errors.New("cannot identify tree")
+	ErrCannotListCommit    = errors.New("cannot list commit")
+	// ErrNoNote is returned by ReadNote for commits without a note.
+	ErrNoNote = gitism.ErrNoNote
+	// ErrMaintenanceUnsupported is returned by Maintain for clients that can't compact the repository.
+	ErrMaintenanceUnsupported = errors.New("repository maintenance is not supported by this git client")
 )
 
-type GitReference struct {
-	Commit, Branch, Tag *string
+type GitPath struct {
+	Reference Ref
+	TreePath  string
+}
+
+type Git interface {
+	ListTree(path GitPath, handler func(entry gitism.TreeEntry) error) error
+	// ListDirectory calls handler with the entry of the directory at path and then with each of its children, all
+	// from one git command. It calls handler with nothing if path isn't a directory.
+	ListDirectory(path GitPath, handler func(entry gitism.TreeEntry) error) error
+	// ListTreeRecursive calls handler with every file, symlink, and submodule under path, however deeply nested, from
+	// one git command. Directories aren't listed.
+	ListTreeRecursive(path GitPath, handler func(entry gitism.TreeEntry) error) error
+	// ListTreeNames is ListTree with only the path of each entry, which is cheaper since sizes aren't looked up.
+	ListTreeNames(path GitPath, handler func(path string) error) error
+	ListBranches(handler func(branch string) error) error
+	ListTags(handler func(branch string) error) error
+	ListCommits(ref Ref, handler func(branch string) error) error
+	// CommitParents returns the full hashes of commit's parents, first parent first.
+	CommitParents(commit string) ([]string, error)
+	// WalkCommits calls handler with every commit reachable from ref, newest first. With firstParent only the first
+	// parent of each merge is followed so the merged branches' commits are skipped, like git log --first-parent.
+	WalkCommits(ref Ref, firstParent bool, handler func(commit gitism.GraphCommit) error) error
+	// ListChanges calls handler with every file modified by commit, with renames detected.
+	ListChanges(commit string, handler func(change gitism.Change) error) error
+	// ReadBlob returns the contents of the blob named by hash. Callers must not modify the returned slice since it
+	// may be shared with concurrent callers reading the same blob.
+	ReadBlob(hash string) ([]byte, error)
+	// ReadBlobs calls handler with the contents of every blob in hashes, in order, reading all of them with one git
+	// command. It is much faster than calling ReadBlob for each when there are many.
+	ReadBlobs(hashes []string, handler func(hash string, contents []byte) error) error
+	// ReadTreeBlobs calls handler with every file and symlink under path, however deeply nested, and its contents,
+	// listing them with one git command and reading them with another. Only the entries include returns true for are
+	// read, or all of them if include is nil. include also sees submodules, which are never read. Blobs found at
+	// several paths are read once.
+	ReadTreeBlobs(path GitPath, include func(entry gitism.TreeEntry) bool, handler func(entry gitism.TreeEntry, contents []byte) error) error
+	// BlobSize is the length of the blob named by hash without reading its contents.
+	BlobSize(hash string) (int64, error)
+	// ResolveCommit returns the full hash of the commit ref points to.
+	ResolveCommit(ref Ref) (string, error)
+	// VerifyCommit checks the GPG or SSH signature of commit against the keys git is configured to trust.
+	VerifyCommit(commit string) error
+	// VerifyTag checks the GPG or SSH signature of the annotated tag named tag against the keys git is configured to
+	// trust.
+	VerifyTag(tag string) error
+	// ListWorktreeChanges calls handler with the path of every file in worktree, a working tree of the repository,
+	// that differs from HEAD, including untracked files that aren't ignored.
+	ListWorktreeChanges(worktree string, handler func(path string) error) error
+	// ReadNote returns the note attached to commit in notesRef, like refs/notes/commits, or ErrNoNote if there isn't
+	// one.
+	ReadNote(notesRef string, commit string) ([]byte, error)
+	// Describe names commit relative to the closest tag, like git describe.
+	Describe(commit string) (string, error)
+	// LastModified returns when the newest commit reachable from commit that changed something at or under path was
+	// committed.
+	LastModified(commit string, path string) (time.Time, error)
+	// LastCommit describes the newest commit reachable from commit that changed something at or under path. An empty
+	// path describes commit itself.
+	LastCommit(commit string, path string) (gitism.CommitInfo, error)
+	// SearchCommits calls handler with the commits reachable from ref whose message has a line matching pattern, an
+	// extended regular expression, newest first like git log --grep. Only the first limit are found when it is
+	// positive.
+	SearchCommits(ref Ref, pattern string, limit int, handler func(commit gitism.CommitInfo) error) error
+	// FileHistory calls handler with every commit reachable from ref that changed the file at path, newest first, and
+	// how it changed it, following the file back across renames like git log --follow.
+	FileHistory(ref Ref, path string, handler func(commit string, change gitism.Change) error) error
+	// Bisect picks the commit halfway between bad and good to test next. See gitism.Command.Bisect.
+	Bisect(bad string, good []string) (gitism.BisectStep, error)
+	// ObjectFormat is the hash algorithm the repository uses to name objects.
+	ObjectFormat() (gitism.ObjectFormat, error)
+	// CountObjects describes the size of the repository's object store.
+	CountObjects() (gitism.ObjectCounts, error)
+}
+
+type cliGit struct {
+	cli     gitism.Command
+	blobs   *blobReads
+	fetches *missingFetches
+	index   *stagedTree
+	cache   Cache
+	budget  *MemoryBudget
+	pools   *processPools
+	handles *openHandles
+	// replaced is nil when replace refs are ignored.
+	replaced *replacedObjects
+}
+
+// cliOptions are what CliOptions configure: how git is run and what the client does around it.
+type cliOptions struct {
+	gitism.CommandOptions
+	// fetchRemotes are the remotes refs and objects missing from the repository are fetched from, in order.
+	fetchRemotes []string
+	// cache holds blobs and listings of commits between commands, and between processes for caches that are shared.
+	cache Cache
+	// budget bounds the memory held by open files and memory caches.
+	budget *MemoryBudget
+	// workers is how many git cat-file processes of each kind are kept running, and workerRequests how many objects
+	// each answers before it is replaced.
+	workers        int
+	workerRequests int
+	// noReplaceObjects ignores refs/replace/.
+	noReplaceObjects bool
+	// faults, if set, makes the client delay and fail calls on purpose.
+	faults *Faults
 }
 
-func (p GitReference) treeLike() (string, error) {
-	possible := []*string{
-		p.Branch,
-		p.Commit,
-		p.Tag,
+// CliOption customizes how NewCliGit runs git.
+type CliOption func(options *cliOptions)
+
+// WithGitExecutable runs the git binary at path (or the named binary found on $PATH) instead of "git".
+func WithGitExecutable(path string) CliOption {
+	return func(options *cliOptions) {
+		options.Executable = path
 	}
-	var selected *string
-	for _, treeLike := range possible {
-		if treeLike == nil {
-			continue
-		}
+}
 
-		if selected != nil {
-			return "", ErrMultipleRefsSpecified
-		}
+// WithPath replaces $PATH for git and any helpers it runs.
+func WithPath(path string) CliOption {
+	return func(options *cliOptions) {
+		options.Path = path
+	}
+}
+
+// WithGitConfig passes "-c key=value" to every git command.
+func WithGitConfig(key, value string) CliOption {
+	return func(options *cliOptions) {
+		options.Config = append(options.Config, key+"="+value)
+	}
+}
 
-		selected = treeLike
+// WithAlternateObjectDirectories lets git read objects from dirs as well as the repository's own object store.
+func WithAlternateObjectDirectories(dirs ...string) CliOption {
+	return func(options *cliOptions) {
+		options.AlternateObjectDirectories = append(options.AlternateObjectDirectories, dirs...)
 	}
-	if selected == nil {
-		return "", ErrNoTreeLikeSpecified
+}
+
+// WithEnvironment sets extra "KEY=value" environment variables for git.
+func WithEnvironment(variables ...string) CliOption {
+	return func(options *cliOptions) {
+		options.Environment = append(options.Environment, variables...)
 	}
-	return *selected, nil
 }
 
-type GitPath struct {
-	Reference GitReference
-	TreePath  string
+// WithGitRetries runs commands that failed because maintenance like git gc held a lock on the repository again, up
+// to retries times, backing off between attempts. See gitism.CommandOptions.Retries.
+func WithGitRetries(retries int) CliOption {
+	return func(options *cliOptions) {
+		options.Retries = retries
+	}
 }
 
-type Git interface {
-	ListTree(path GitPath, handler func(entry gitism.TreeEntry) error) error
-	ListBranches(handler func(branch string) error) error
-	ListTags(handler func(branch string) error) error
-	ListCommits(ref GitReference, handler func(branch string) error) error
-	ReadBlob(hash string) ([]byte, error)
+// WithCache keeps blobs, their sizes, and listings of commits named by their full hash in cache. Failing to use cache
+// is logged, and otherwise only makes git run.
+func WithCache(cache Cache) CliOption {
+	return func(options *cliOptions) {
+		options.cache = cache
+	}
 }
 
-type cliGit struct {
-	cli gitism.Command
+// WithMemoryBudget counts the blobs of open files and of a memory cache given to WithCache against budget. Share one
+// budget between every client in a process to bound their memory together.
+func WithMemoryBudget(budget *MemoryBudget) CliOption {
+	return func(options *cliOptions) {
+		options.budget = budget
+	}
+}
+
+// WithProcessPool keeps workers git cat-file processes running for reading blobs, and as many for looking up their
+// sizes, so reads don't wait for git to start. Each process is replaced after answering requests objects, or 1000 if
+// requests is 0. Reads run git themselves when every process is busy. Close the client to stop the processes.
+func WithProcessPool(workers, requests int) CliOption {
+	return func(options *cliOptions) {
+		options.workers = workers
+		options.workerRequests = requests
+	}
 }
 
-func NewCliGit(gitDirectory string) (Git, error) {
-	cli, err := gitism.NewCommand(gitDirectory)
+// WithTracer logs every git command to tracer under the operation that ran it.
+func WithTracer(tracer *Tracer) CliOption {
+	return func(options *cliOptions) {
+		if tracer != nil {
+			options.Observer = tracer.observeGit
+		}
+	}
+}
+
+// NewCliGit reads the repository at gitDirectory by running git. When built with the embeddedgit tag it falls back to
+// NewEmbeddedGit if there is no git on $PATH, in which case only WithFaults of the options applies.
+func NewCliGit(gitDirectory string, options ...CliOption) (Git, error) {
+	cliOptions := cliOptions{}
+	for _, option := range options {
+		option(&cliOptions)
+	}
+
+	cli, err := gitism.NewCommandWithOptions(gitDirectory, cliOptions.CommandOptions)
+	if cliOptions.fallsBack(err) {
+		log.Printf("Reading %s with the embedded git backend: %v", gitDirectory, err)
+		git, err := NewEmbeddedGit(gitDirectory)
+		if err != nil || cliOptions.faults == nil {
+			return git, err
+		}
+		return NewFaultyGit(git, *cliOptions.faults), nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	return cliGit{cli: cli}, nil
+	git := cliGit{
+		cli:     cli,
+		blobs:   newBlobReads(),
+		index:   newStagedTree(cli.LsFilesStage, cli.IndexFile()),
+		cache:   cliOptions.cache,
+		budget:  cliOptions.budget,
+		handles: newOpenHandles(),
+	}
+	git.budget.track(git.cache)
+	if !cliOptions.noReplaceObjects {
+		git.replaced = newReplacedObjects(cli.ReplaceRefs)
+	}
+	if cliOptions.workers > 0 {
+		requests := cliOptions.workerRequests
+		if requests <= 0 {
+			requests = defaultWorkerRequests
+		}
+		git.pools = &processPools{
+			contents: newBatchPool(cliOptions.workers, requests, func() (*gitism.BatchProcess, error) {
+				return cli.StartBatch(false)
+			}),
+			sizes: newBatchPool(cliOptions.workers, requests, func() (*gitism.BatchProcess, error) {
+				return cli.StartBatch(true)
+			}),
+		}
+	}
+	if len(cliOptions.fetchRemotes) > 0 {
+		git.fetches = newMissingFetches(cliOptions.fetchRemotes, func(remote, refspec string) error {
+			return cli.Fetch(remote, refspec)
+		}, time.Now)
+	}
+	if cliOptions.faults != nil {
+		return NewFaultyGit(git, *cliOptions.faults), nil
+	}
+	return git, nil
+}
+
+// fallsBack reports if the embedded git backend should be used because NewCommandWithOptions failed with err. A git
+// executable that was asked for by name isn't replaced when it is missing.
+func (o cliOptions) fallsBack(err error) bool {
+	return embeddedFallback && o.Executable == "" && errors.Is(err, gitism.ErrGitNotFound)
+}
+
+func (g cliGit) backend() string {
+	return fmt.Sprintf("git %s (%s)", g.cli.Version(), g.cli.Executable())
+}
+
+// Backend describes how git reads the repository, like "git 2.39.5 (/usr/bin/git)" or "embedded", or is empty if it
+// can't tell.
+func Backend(git Git) string {
+	if described, ok := git.(interface{ backend() string }); ok {
+		return described.backend()
+	}
+	return ""
+}
+
+// maintain compacts the repository with task, then replaces the pooled processes so they let go of the packs it
+// deleted and start reading the ones it wrote.
+func (g cliGit) maintain(task gitism.MaintenanceTask) error {
+	if err := g.cli.Maintain(task); err != nil {
+		return err
+	}
+	g.pools.recycle()
+	return nil
+}
+
+// Maintain compacts the repository git reads with task, like git gc, while it keeps being served. Clients that don't
+// run git, like the embedded one, return ErrMaintenanceUnsupported.
+func Maintain(git Git, task gitism.MaintenanceTask) error {
+	if maintained, ok := git.(interface {
+		maintain(task gitism.MaintenanceTask) error
+	}); ok {
+		return maintained.maintain(task)
+	}
+	return ErrMaintenanceUnsupported
 }
 
 func (g cliGit) ListBranches(handler func(branch string) error) error {
@@ -84,11 +321,11 @@ func (g cliGit) ListBranches(handler func(branch string) error) error {
 }
 
 func (g cliGit) ListTags(handler func(branch string) error) error {
-	return g.ListTags(handler)
+	return g.cli.ListTags(handler)
 }
 
-func (g cliGit) ListCommits(ref GitReference, handler func(branch string) error) error {
-	if ref.Commit != nil {
+func (g cliGit) ListCommits(ref Ref, handler func(branch string) error) error {
+	if ref.Kind == RefCommit {
 		return ErrCannotListCommit
 	}
 	treeLike, err := ref.treeLike()
@@ -98,14 +335,267 @@ func (g cliGit) ListCommits(ref GitReference, handler func(branch string) error)
 	return g.cli.ListCommits(treeLike, handler)
 }
 
+func (g cliGit) CommitParents(commit string) ([]string, error) {
+	return g.cli.Parents(commit)
+}
+
+func (g cliGit) WalkCommits(ref Ref, firstParent bool, handler func(commit gitism.GraphCommit) error) error {
+	treeLike, err := ref.treeLike()
+	if err != nil {
+		return err
+	}
+	return g.cli.RevList(treeLike, firstParent, handler)
+}
+
+func (g cliGit) ListChanges(commit string, handler func(change gitism.Change) error) error {
+	return g.cli.DiffTree(commit, handler)
+}
+
 func (g cliGit) ListTree(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	if path.Reference.Kind == RefIndex {
+		return g.index.list(path.TreePath, handler)
+	}
+	return g.cachedListing(listingCacheKey("tree", path), func(handler func(entry gitism.TreeEntry) error) error {
+		return g.listTree(path, handler)
+	}, handler)
+}
+
+func (g cliGit) listTree(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	treeLike, err := path.Reference.treeLike()
+	if err != nil {
+		return fmt.Errorf("please provide a Commit, Tag, or Branch: %v", err)
+	}
+	listed := false
+	err = g.cli.LsTree(treeLike, path.TreePath, func(entry gitism.TreeEntry) error {
+		listed = true
+		return handler(entry)
+	})
+	if !listed && g.fetchMissingRef(path.Reference) {
+		return g.cli.LsTree(treeLike, path.TreePath, handler)
+	}
+	return err
+}
+
+func (g cliGit) ListDirectory(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	if path.Reference.Kind == RefIndex {
+		return g.index.listDirectory(path.TreePath, handler)
+	}
+	return g.cachedListing(listingCacheKey("directory", path), func(handler func(entry gitism.TreeEntry) error) error {
+		return g.listDirectory(path, handler)
+	}, handler)
+}
+
+func (g cliGit) listDirectory(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	treeLike, err := path.Reference.treeLike()
+	if err != nil {
+		return fmt.Errorf("please provide a Commit, Tag, or Branch: %v", err)
+	}
+	listed := false
+	err = g.cli.LsTreeDirectory(treeLike, path.TreePath, func(entry gitism.TreeEntry) error {
+		listed = true
+		return handler(entry)
+	})
+	if !listed && g.fetchMissingRef(path.Reference) {
+		return g.cli.LsTreeDirectory(treeLike, path.TreePath, handler)
+	}
+	return err
+}
+
+func (g cliGit) ListTreeRecursive(path GitPath, handler func(entry gitism.TreeEntry) error) error {
 	treeLike, err := path.Reference.treeLike()
 	if err != nil {
 		return fmt.Errorf("please provide a Commit, Tag, or Branch: %v", err)
 	}
-	return g.cli.LsTree(treeLike, path.TreePath, handler)
+	treePath := path.TreePath
+	if treePath == "" {
+		treePath = "."
+	}
+	return g.cli.LsTreeRecursive(treeLike, treePath, handler)
+}
+
+func (g cliGit) ListTreeNames(path GitPath, handler func(path string) error) error {
+	if path.Reference.Kind == RefIndex {
+		return g.index.list(path.TreePath, func(entry gitism.TreeEntry) error {
+			return handler(entry.Path)
+		})
+	}
+	treeLike, err := path.Reference.treeLike()
+	if err != nil {
+		return fmt.Errorf("please provide a Commit, Tag, or Branch: %v", err)
+	}
+	listed := false
+	err = g.cli.LsTreeNames(treeLike, path.TreePath, func(name string) error {
+		listed = true
+		return handler(name)
+	})
+	if !listed && g.fetchMissingRef(path.Reference) {
+		return g.cli.LsTreeNames(treeLike, path.TreePath, handler)
+	}
+	return err
 }
 
 func (g cliGit) ReadBlob(hash string) ([]byte, error) {
+	if contents, ok := g.cachedBlob(hash); ok {
+		return contents, nil
+	}
+	return g.blobs.do(hash, func() ([]byte, error) {
+		contents, err := g.catBlob(hash)
+		if err != nil && g.fetches.fetch(hash) == nil {
+			contents, err = g.catBlob(hash)
+		}
+		if err == nil {
+			g.cacheSet("blob/"+hash, contents)
+		}
+		return contents, err
+	})
+}
+
+// catBlob reads the blob named by hash from a pooled process if one is ready, and otherwise runs git.
+func (g cliGit) catBlob(hash string) ([]byte, error) {
+	if contents, ok, err := g.pools.readBlob(hash); ok {
+		return contents, err
+	}
 	return g.cli.CatFile("blob", hash)
 }
+
+// catSize looks the size of the object named by hash up with a pooled process if one is ready, and otherwise runs git.
+func (g cliGit) catSize(hash string) (int64, error) {
+	if size, ok, err := g.pools.blobSize(hash); ok {
+		return size, err
+	}
+	return g.cli.CatFileSize(hash)
+}
+
+// Close stops the processes kept by WithProcessPool.
+func (g cliGit) Close() error {
+	return g.pools.Close()
+}
+
+func (g cliGit) ReadBlobs(hashes []string, handler func(hash string, contents []byte) error) error {
+	return g.cli.CatFileBatch(hashes, handler)
+}
+
+// ReadTreeBlobs also caches every blob it reads, so the files it read are answered from the cache when they are
+// opened.
+func (g cliGit) ReadTreeBlobs(path GitPath, include func(entry gitism.TreeEntry) bool, handler func(entry gitism.TreeEntry, contents []byte) error) error {
+	return readTreeBlobs(g, path, include, func(entry gitism.TreeEntry, contents []byte) error {
+		g.cacheSet("blob/"+entry.Hash, contents)
+		return handler(entry, contents)
+	})
+}
+
+// readTreeBlobs implements Git.ReadTreeBlobs with git's ListTreeRecursive and ReadBlobs.
+func readTreeBlobs(git Git, path GitPath, include func(entry gitism.TreeEntry) bool, handler func(entry gitism.TreeEntry, contents []byte) error) error {
+	var hashes []string
+	entries := map[string][]gitism.TreeEntry{}
+	err := git.ListTreeRecursive(path, func(entry gitism.TreeEntry) error {
+		if include != nil && !include(entry) || entry.Mode.Type == gitism.Gitlink {
+			return nil
+		}
+		if _, ok := entries[entry.Hash]; !ok {
+			hashes = append(hashes, entry.Hash)
+		}
+		entries[entry.Hash] = append(entries[entry.Hash], entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return git.ReadBlobs(hashes, func(hash string, contents []byte) error {
+		for _, entry := range entries[hash] {
+			if err := handler(entry, contents); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// BlobSize doesn't use network caches, since a size can't be checked without reading the blob.
+func (g cliGit) BlobSize(hash string) (int64, error) {
+	key := "size/" + hash
+	networkCache := isNetworkCache(g.cache)
+	if !networkCache {
+		if cached, ok := g.cacheGet(key); ok {
+			if size, err := strconv.ParseInt(string(cached), 10, 64); err == nil {
+				return size, nil
+			}
+		}
+	}
+	size, err := g.catSize(hash)
+	if err != nil && g.fetches.fetch(hash) == nil {
+		size, err = g.catSize(hash)
+	}
+	if err == nil && !networkCache {
+		g.cacheSet(key, []byte(strconv.FormatInt(size, 10)))
+	}
+	return size, err
+}
+
+func (g cliGit) ObjectFormat() (gitism.ObjectFormat, error) {
+	return g.cli.ObjectFormat()
+}
+
+func (g cliGit) CountObjects() (gitism.ObjectCounts, error) {
+	return g.cli.CountObjects()
+}
+
+func (g cliGit) ResolveCommit(ref Ref) (string, error) {
+	treeLike, err := ref.treeLike()
+	if err != nil {
+		return "", err
+	}
+	commit, err := g.cli.RevParse(treeLike)
+	if err != nil && g.fetches.fetch(ref.refspec()) == nil {
+		return g.cli.RevParse(treeLike)
+	}
+	return commit, err
+}
+
+func (g cliGit) VerifyCommit(commit string) error {
+	return g.cli.VerifyCommit(commit)
+}
+
+func (g cliGit) VerifyTag(tag string) error {
+	return g.cli.VerifyTag(tagPrefix + tag)
+}
+
+func (g cliGit) ListWorktreeChanges(worktree string, handler func(path string) error) error {
+	return g.cli.Status(worktree, handler)
+}
+
+func (g cliGit) ReadNote(notesRef string, commit string) ([]byte, error) {
+	return g.cli.Note(notesRef, commit)
+}
+
+func (g cliGit) Describe(commit string) (string, error) {
+	return g.cli.Describe(commit)
+}
+
+func (g cliGit) LastModified(commit string, path string) (time.Time, error) {
+	return g.cli.LastCommitTime(commit, path)
+}
+
+func (g cliGit) LastCommit(commit string, path string) (gitism.CommitInfo, error) {
+	return g.cli.LastCommit(commit, path)
+}
+
+func (g cliGit) FileHistory(ref Ref, path string, handler func(commit string, change gitism.Change) error) error {
+	treeLike, err := ref.treeLike()
+	if err != nil {
+		return err
+	}
+	return g.cli.FileHistory(treeLike, path, handler)
+}
+
+func (g cliGit) SearchCommits(ref Ref, pattern string, limit int, handler func(commit gitism.CommitInfo) error) error {
+	treeLike, err := ref.treeLike()
+	if err != nil {
+		return err
+	}
+	return g.cli.SearchCommits(treeLike, pattern, limit, handler)
+}
+
+func (g cliGit) Bisect(bad string, good []string) (gitism.BisectStep, error) {
+	return g.cli.Bisect(bad, good)
+}
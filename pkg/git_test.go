@@ -15,10 +15,16 @@
 package pkg
 
 import (
+	"fmt"
 	"github.com/google/go-cmp/cmp"
 	"github.com/gravypod/gitfs/pkg/gitism"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 var BranchMaster = "master"
@@ -72,7 +78,7 @@ func TestListing(t *testing.T) {
 	var got []gitism.TreeEntry
 
 	gitPath := GitPath{
-		Reference: GitReference{Branch: &BranchMaster},
+		Reference: BranchRef(BranchMaster),
 		TreePath:  ".",
 	}
 	err := git.ListTree(gitPath, func(entry gitism.TreeEntry) error {
@@ -95,3 +101,286 @@ func TestListing(t *testing.T) {
 		t.Fatal(diff)
 	}
 }
+
+func TestChanges(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "rename")
+
+	var got []gitism.Change
+	err := git.ListChanges(BranchMaster, func(change gitism.Change) error {
+		got = append(got, change)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to list changes: %v", err)
+	}
+
+	want := []gitism.Change{
+		{
+			Type:         gitism.ChangeRename,
+			PreviousHash: "07604e534c5f6d7273a995438cf3e018d0d634a6",
+			Hash:         "07604e534c5f6d7273a995438cf3e018d0d634a6",
+			PreviousMode: gitism.FileMode{Type: gitism.RegularFile, Perms: 0644},
+			Mode:         gitism.FileMode{Type: gitism.RegularFile, Perms: 0644},
+			PreviousPath: "original.txt",
+			Path:         "renamed/moved.txt",
+			Score:        100,
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestCommitGraph(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "merges")
+	master := BranchRef(BranchMaster)
+
+	merge, err := git.ResolveCommit(master)
+	if err != nil {
+		t.Fatalf("ResolveCommit() failed: %v", err)
+	}
+	mainline := "master^1"
+	mainlineCommit, err := git.ResolveCommit(CommitRef(mainline))
+	if err != nil {
+		t.Fatalf("ResolveCommit() failed: %v", err)
+	}
+
+	parents, err := git.CommitParents(merge)
+	if err != nil {
+		t.Fatalf("CommitParents() failed: %v", err)
+	}
+	if len(parents) != 2 || parents[0] != mainlineCommit {
+		t.Fatalf("merge commit has parents %v but the first should be %s", parents, mainlineCommit)
+	}
+
+	walk := func(firstParent bool) []gitism.GraphCommit {
+		var commits []gitism.GraphCommit
+		err := git.WalkCommits(master, firstParent, func(commit gitism.GraphCommit) error {
+			commits = append(commits, commit)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("WalkCommits(%v) failed: %v", firstParent, err)
+		}
+		return commits
+	}
+
+	if commits := walk(false); len(commits) != 5 {
+		t.Fatalf("expected the whole graph of 5 commits but found %d", len(commits))
+	}
+
+	commits := walk(true)
+	if len(commits) != 3 {
+		t.Fatalf("expected 3 commits on the mainline but found %d", len(commits))
+	}
+	if commits[0].Hash != merge || !commits[0].IsMerge() || commits[1].Hash != mainlineCommit || !commits[2].IsRoot() {
+		t.Fatalf("unexpected mainline history: %+v", commits)
+	}
+}
+
+func TestCliOptions(t *testing.T) {
+	tmp := t.TempDir()
+	repository, err := runPlaybook("base", tmp)
+	if err != nil {
+		t.Fatalf("playbook 'base' failed: %v", err)
+	}
+
+	t.Run("alternates", func(t *testing.T) {
+		empty := filepath.Join(t.TempDir(), "empty.git")
+		if err := exec.Command("git", "init", "--bare", empty).Run(); err != nil {
+			t.Fatalf("git init failed: %v", err)
+		}
+
+		const realTxt = "557db03de997c86a4a028e1ebd3a1ceb225be238"
+		withoutAlternates, err := NewCliGit(empty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contents, _ := withoutAlternates.ReadBlob(realTxt); len(contents) != 0 {
+			t.Fatal("read real.txt from an empty repository")
+		}
+
+		withAlternates, err := NewCliGit(empty, WithAlternateObjectDirectories(filepath.Join(repository, "objects")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := withAlternates.ReadBlob(realTxt)
+		if err != nil {
+			t.Fatalf("ReadBlob() failed: %v", err)
+		}
+		if string(contents) != "Hello World\n" {
+			t.Fatalf("read %q through alternates", contents)
+		}
+	})
+
+	t.Run("config", func(t *testing.T) {
+		// Wrap git with a script that records the arguments it was run with.
+		realGit, err := exec.LookPath("git")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmp := t.TempDir()
+		arguments := filepath.Join(tmp, "arguments")
+		wrapper := filepath.Join(tmp, "git")
+		script := "#!/bin/sh\necho \"$@\" >> " + arguments + "\nexec " + realGit + " \"$@\"\n"
+		if err := os.WriteFile(wrapper, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		git, err := NewCliGit(repository, WithGitExecutable(wrapper), WithGitConfig("core.quotePath", "false"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var branches []string
+		err = git.ListBranches(func(branch string) error {
+			branches = append(branches, branch)
+			return nil
+		})
+		if err != nil || len(branches) != 1 || branches[0] != BranchMaster {
+			t.Fatalf("ListBranches() returned %v, %v", branches, err)
+		}
+
+		recorded, err := os.ReadFile(arguments)
+		if err != nil {
+			t.Fatalf("git wrapper was never run: %v", err)
+		}
+		if !strings.Contains(string(recorded), "-c core.quotePath=false branch --list") {
+			t.Fatalf("git was not passed the config setting: %s", recorded)
+		}
+
+		if _, err := NewCliGit(repository, WithGitConfig("", "value")); err == nil {
+			t.Fatal("NewCliGit() accepted a config setting without a key")
+		}
+	})
+
+	t.Run("executable", func(t *testing.T) {
+		// Builds with the embedded git backend fall back to it instead, see TestEmbeddedFallback.
+		if _, err := NewCliGit(repository, WithPath(t.TempDir())); err == nil && !embeddedFallback {
+			t.Fatal("NewCliGit() found git on an empty $PATH")
+		}
+
+		if _, err := NewCliGit(repository, WithGitExecutable(filepath.Join(t.TempDir(), "git"))); err == nil {
+			t.Fatal("NewCliGit() accepted a git binary that doesn't exist")
+		}
+	})
+}
+
+func TestReadBlobCoalescing(t *testing.T) {
+	tmp := t.TempDir()
+	repository, err := runPlaybook("base", tmp)
+	if err != nil {
+		t.Fatalf("playbook 'base' failed: %v", err)
+	}
+
+	// Make cat-file slow enough for the reads below to overlap.
+	wrapper, reads := recordingGit(t, "cat-file", 500*time.Millisecond)
+	git, err := NewCliGit(repository, WithGitExecutable(wrapper))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const realTxt = "557db03de997c86a4a028e1ebd3a1ceb225be238"
+	const readers = 8
+	results := make(chan string, readers)
+	for i := 0; i < readers; i++ {
+		go func() {
+			contents, err := git.ReadBlob(realTxt)
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			results <- string(contents)
+		}()
+	}
+	for i := 0; i < readers; i++ {
+		if result := <-results; result != "Hello World\n" {
+			t.Fatalf("ReadBlob() returned %q", result)
+		}
+	}
+
+	recorded, err := os.ReadFile(reads)
+	if err != nil {
+		t.Fatalf("git wrapper was never run: %v", err)
+	}
+	if count := strings.Count(string(recorded), "\n"); count != 1 {
+		t.Fatalf("concurrent reads of the same blob ran cat-file %d times:\n%s", count, recorded)
+	}
+
+	if _, err := git.ReadBlob(realTxt); err != nil {
+		t.Fatalf("ReadBlob() failed: %v", err)
+	}
+	recorded, _ = os.ReadFile(reads)
+	if count := strings.Count(string(recorded), "\n"); count != 2 {
+		t.Fatalf("a read after the others finished should run cat-file again but ran it %d times", count)
+	}
+}
+
+func TestReadTreeBlobs(t *testing.T) {
+	repository, err := runPlaybook("base", t.TempDir())
+	if err != nil {
+		t.Fatalf("playbook 'base' failed: %v", err)
+	}
+	wrapper, reads := recordingGit(t, "cat-file", 0)
+	git, err := NewCliGit(repository, WithGitExecutable(wrapper), WithCache(NewMemoryCache(1<<20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	master := GitPath{Reference: BranchRef("master")}
+	read := map[string]string{}
+	err = git.ReadTreeBlobs(master, nil, func(entry gitism.TreeEntry, contents []byte) error {
+		read[entry.Path] = string(contents)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadTreeBlobs() failed: %v", err)
+	}
+
+	// Every blob is read by the one cat-file above and then cached.
+	expected := map[string]string{}
+	err = git.ListTreeRecursive(master, func(entry gitism.TreeEntry) error {
+		contents, err := git.ReadBlob(entry.Hash)
+		expected[entry.Path] = string(contents)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ListTreeRecursive() failed: %v", err)
+	}
+	if diff := cmp.Diff(expected, read); diff != "" {
+		t.Fatalf("ReadTreeBlobs() read the wrong contents (-want +got):\n%s", diff)
+	}
+	recorded, _ := os.ReadFile(reads)
+	if count := strings.Count(string(recorded), "\n"); count != 1 {
+		t.Fatalf("ReadTreeBlobs() and reading what it read ran cat-file %d times:\n%s", count, recorded)
+	}
+
+	var paths []string
+	include := func(entry gitism.TreeEntry) bool {
+		return entry.Path == "real.txt"
+	}
+	err = git.ReadTreeBlobs(master, include, func(entry gitism.TreeEntry, contents []byte) error {
+		paths = append(paths, entry.Path)
+		return nil
+	})
+	if err != nil || len(paths) != 1 || paths[0] != "real.txt" {
+		t.Fatalf("ReadTreeBlobs() of real.txt read %v, %v", paths, err)
+	}
+}
+
+// recordingGit writes a wrapper around git that appends the arguments of every run of subcommand to the returned
+// file, and sleeps for delay before running it.
+func recordingGit(t *testing.T, subcommand string, delay time.Duration) (wrapper string, record string) {
+	realGit, err := exec.LookPath("git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+	record = filepath.Join(tmp, "record")
+	wrapper = filepath.Join(tmp, "git")
+	script := fmt.Sprintf("#!/bin/sh\ncase \"$*\" in *%s*) echo \"$@\" >> %s; sleep %f;; esac\nexec %s \"$@\"\n",
+		subcommand, record, delay.Seconds(), realGit)
+	if err := os.WriteFile(wrapper, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return wrapper, record
+}
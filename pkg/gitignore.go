@@ -0,0 +1,132 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"path"
+	"strings"
+)
+
+// ignoreRule is a single pattern from a .gitignore file.
+type ignoreRule struct {
+	// segments are the pattern split on "/". Patterns without a slash have one segment and match a name at any depth.
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func (r ignoreRule) matches(parts []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		matched, _ := path.Match(r.segments[0], parts[len(parts)-1])
+		return matched
+	}
+	return matchSegments(r.segments, parts)
+}
+
+// matchSegments matches a path against a pattern one segment at a time. A "**" segment matches any number of
+// segments.
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], parts[0]); !matched {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// Gitignore matches paths against the patterns of a .gitignore file. See https://git-scm.com/docs/gitignore for the
+// format.
+type Gitignore struct {
+	rules []ignoreRule
+}
+
+// ParseGitignore reads the patterns in contents. Blank lines and comments are skipped.
+func ParseGitignore(contents []byte) Gitignore {
+	var ignore Gitignore
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimRight(line, " \r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\") {
+			// "\#" and "\!" match names that start with those characters.
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.HasPrefix(line, "/") {
+			rule.anchored = true
+			line = strings.TrimLeft(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.anchored = rule.anchored || strings.Contains(line, "/")
+		rule.segments = strings.Split(line, "/")
+		ignore.rules = append(ignore.rules, rule)
+	}
+	return ignore
+}
+
+// matches reports if the last rule to match parts ignores it.
+func (g Gitignore) matches(parts []string, isDir bool) bool {
+	ignored := false
+	for _, rule := range g.rules {
+		if rule.matches(parts, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// Match reports if filename, relative to the directory of the .gitignore file, is ignored. Like git, everything inside
+// of an ignored directory is ignored and can't be included again by a negated pattern.
+func (g Gitignore) Match(filename string, isDir bool) bool {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil || resolved.IsRoot() {
+		return false
+	}
+
+	for i := 1; i < len(resolved.Path); i++ {
+		if g.matches(resolved.Path[:i], true) {
+			return true
+		}
+	}
+	return g.matches(resolved.Path, isDir)
+}
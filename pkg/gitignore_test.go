@@ -0,0 +1,59 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+)
+
+func TestGitignore(t *testing.T) {
+	ignore := ParseGitignore([]byte(`# Build outputs
+build/
+*.o
+!keep.o
+/coverage
+docs/**/*.html
+\#notes
+
+`))
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"build", true, true},
+		{"build", false, false},
+		{"build/out/main", false, true},
+		{"src/build/cache", false, true},
+		{"main.o", false, true},
+		{"src/lib/util.o", false, true},
+		{"keep.o", false, false},
+		{"build/keep.o", false, true},
+		{"coverage", true, true},
+		{"src/coverage", true, false},
+		{"docs/index.html", false, true},
+		{"docs/api/v1/index.html", false, true},
+		{"src/docs/index.html", false, false},
+		{"#notes", false, true},
+		{"main.c", false, false},
+		{".", true, false},
+	}
+	for _, test := range tests {
+		if got := ignore.Match(test.path, test.isDir); got != test.ignored {
+			t.Fatalf("Match(%s, %v) returned %v", test.path, test.isDir, got)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package gitism
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxAlternateDepth is how deeply alternates of alternates are followed, the same limit git itself has.
+const maxAlternateDepth = 5
+
+// AlternatesFile is where a git directory lists the object directories of other repositories it borrows objects from,
+// like a fork cloned with --shared or --reference.
+var AlternatesFile = filepath.Join("objects", "info", "alternates")
+
+// ParseAlternates returns the object directories listed in the alternates file of objectDirectory. Relative
+// directories are relative to objectDirectory, and blank lines and comments are skipped.
+func ParseAlternates(objectDirectory string, contents []byte) []string {
+	var directories []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(objectDirectory, line)
+		}
+		directories = append(directories, filepath.Clean(line))
+	}
+	return directories
+}
+
+// Alternates returns every object directory the repository borrows objects from, following the alternates of those
+// directories too.
+func (c *Command) Alternates() ([]string, error) {
+	directory := c.directory
+	if directory == "" {
+		directory = ".git"
+	}
+	seen := map[string]bool{}
+	var alternates []string
+	var walk func(objectDirectory string, depth int) error
+	walk = func(objectDirectory string, depth int) error {
+		contents, err := os.ReadFile(filepath.Join(objectDirectory, "info", "alternates"))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, alternate := range ParseAlternates(objectDirectory, contents) {
+			if seen[alternate] {
+				continue
+			}
+			seen[alternate] = true
+			alternates = append(alternates, alternate)
+			if depth < maxAlternateDepth {
+				if err := walk(alternate, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(filepath.Join(directory, "objects"), 0); err != nil {
+		return nil, err
+	}
+	return alternates, nil
+}
@@ -0,0 +1,135 @@
+package gitism
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// BatchProcess is a git cat-file --batch, or --batch-check, left running to answer requests for objects one at a time
+// so each doesn't pay for starting git. It is not safe for concurrent use.
+type BatchProcess struct {
+	command Command
+	args    []string
+	check   bool
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	writer *bufio.Writer
+	reader *bufio.Reader
+	// exited is closed once the process is gone.
+	exited chan struct{}
+
+	requests int
+	// broken is set when a reply couldn't be read, after which requests and replies can't be matched up anymore.
+	broken bool
+}
+
+// StartBatch starts git cat-file --batch, or --batch-check to only learn the types and sizes of objects if check is
+// set.
+func (c *Command) StartBatch(check bool) (*BatchProcess, error) {
+	args := []string{"cat-file", "--batch"}
+	if check {
+		args = []string{"cat-file", "--batch-check"}
+	}
+	cmd := c.execute(args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start stdin pipe '%s': %v", cmd.String(), err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start stdout pipe '%s': %v", cmd.String(), err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start '%s': %v", cmd.String(), err)
+	}
+
+	p := &BatchProcess{
+		command: *c,
+		args:    args,
+		check:   check,
+		cmd:     cmd,
+		stdin:   stdin,
+		writer:  bufio.NewWriter(stdin),
+		reader:  bufio.NewReader(stdout),
+		exited:  make(chan struct{}),
+	}
+	go func() {
+		_ = cmd.Wait()
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+// Object returns the type and size of the object named by name, and its contents unless the process only checks
+// objects. name may be anything git cat-file understands, like a hash or "<commit>:<path>". Objects that don't exist
+// return ErrObjectMissing.
+func (p *BatchProcess) Object(name string) (objectType string, size int64, contents []byte, err error) {
+	defer p.command.observe(time.Now(), append(p.args, name), &err)
+	if strings.ContainsRune(name, '\n') {
+		return "", 0, nil, fmt.Errorf("object name %q contains a newline", name)
+	}
+	if p.broken {
+		return "", 0, nil, fmt.Errorf("'%s' is out of step with its replies", p.cmd.String())
+	}
+	p.requests++
+
+	if _, err := p.writer.WriteString(name + "\n"); err != nil {
+		p.broken = true
+		return "", 0, nil, err
+	}
+	if err := p.writer.Flush(); err != nil {
+		p.broken = true
+		return "", 0, nil, err
+	}
+
+	objectType, length, err := readBatchHeader(p.reader, name)
+	if err != nil {
+		// A missing object is a complete reply, anything else leaves the stream in an unknown state.
+		p.broken = !errors.Is(err, ErrObjectMissing)
+		return "", 0, nil, err
+	}
+	if p.check {
+		return objectType, int64(length), nil, nil
+	}
+	contents = make([]byte, length+1)
+	if _, err := io.ReadFull(p.reader, contents); err != nil {
+		p.broken = true
+		return "", 0, nil, fmt.Errorf("failed to read object %s: %v", name, err)
+	}
+	return objectType, int64(length), contents[:length], nil
+}
+
+// Requests is how many objects the process has been asked for.
+func (p *BatchProcess) Requests() int {
+	return p.requests
+}
+
+// Healthy reports whether the process is still running and can answer more requests.
+func (p *BatchProcess) Healthy() bool {
+	select {
+	case <-p.exited:
+		return false
+	default:
+		return !p.broken
+	}
+}
+
+// Close stops the process, killing it if it doesn't exit once it runs out of requests.
+func (p *BatchProcess) Close() error {
+	_ = p.stdin.Close()
+	select {
+	case <-p.exited:
+	case <-time.After(time.Second):
+		_ = p.cmd.Process.Kill()
+		<-p.exited
+	}
+	return nil
+}
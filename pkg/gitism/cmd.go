@@ -2,24 +2,138 @@ package gitism
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+)
+
+var (
+	// ErrNoNote is returned by Note for objects that don't have a note.
+	ErrNoNote = errors.New("no note found")
+	// ErrObjectMissing is returned by BatchProcess.Object for objects that don't exist.
+	ErrObjectMissing = errors.New("object is missing")
+	// ErrGitNotFound is returned by NewCommandWithOptions when there is no git executable to run.
+	ErrGitNotFound = errors.New("git executable path could not be found")
 )
 
 type Command struct {
 	executable string
 	directory  string
+	config     []string
+	env        []string
+	observer   Observer
+	version    Version
+	retries    int
+}
+
+// Observer is told about every git command that is run, how long it took, and if it failed.
+type Observer func(args []string, duration time.Duration, err error)
+
+// CommandOptions control the environment git is run in. The zero value runs the git found on $PATH with the
+// environment of the current process.
+type CommandOptions struct {
+	// Executable is the git binary to run. Names without a separator are looked up in Path.
+	Executable string
+	// Path replaces $PATH when looking up Executable and in git's environment.
+	Path string
+	// Config are "key=value" settings passed to every git command with -c.
+	Config []string
+	// AlternateObjectDirectories are extra object stores to read from, passed through GIT_ALTERNATE_OBJECT_DIRECTORIES.
+	AlternateObjectDirectories []string
+	// Environment are extra "KEY=value" variables set for git.
+	Environment []string
+	// Observer, if set, is called after every git command finishes.
+	Observer Observer
+	// Retries is how many times a command that failed because the repository was locked, like by git gc, is run again.
+	// Commands that stream their output are only run again if they failed before printing anything.
+	Retries int
 }
 
 func NewCommand(directory string) (Command, error) {
-	executable, err := exec.LookPath("git")
+	return NewCommandWithOptions(directory, CommandOptions{})
+}
+
+func NewCommandWithOptions(directory string, options CommandOptions) (Command, error) {
+	name := options.Executable
+	if name == "" {
+		name = "git"
+	}
+
+	executable, err := lookPath(name, options.Path)
+	if err != nil {
+		return Command{}, fmt.Errorf("%w: %v", ErrGitNotFound, err)
+	}
+
+	for _, setting := range options.Config {
+		if !strings.Contains(setting, "=") || strings.HasPrefix(setting, "=") {
+			return Command{}, fmt.Errorf("git config '%s' must be in the form key=value", setting)
+		}
+	}
+
+	env := os.Environ()
+	if options.Path != "" {
+		env = append(env, "PATH="+options.Path)
+	}
+	if len(options.AlternateObjectDirectories) > 0 {
+		alternates := strings.Join(options.AlternateObjectDirectories, string(os.PathListSeparator))
+		env = append(env, "GIT_ALTERNATE_OBJECT_DIRECTORIES="+alternates)
+	}
+	env = append(env, options.Environment...)
+
+	version, err := detectVersion(executable, env)
 	if err != nil {
-		return Command{}, fmt.Errorf("git executable path could not be found: %v", err)
+		return Command{}, err
 	}
-	return Command{executable: executable, directory: directory}, nil
+
+	return Command{
+		executable: executable,
+		directory:  directory,
+		config:     options.Config,
+		env:        env,
+		observer:   options.Observer,
+		version:    version,
+		retries:    options.Retries,
+	}, nil
+}
+
+// lookPath is exec.LookPath but searches path instead of $PATH when it is set.
+func lookPath(name, path string) (string, error) {
+	if path == "" || strings.ContainsRune(name, os.PathSeparator) {
+		return exec.LookPath(name)
+	}
+
+	for _, dir := range filepath.SplitList(path) {
+		candidate := filepath.Join(dir, name)
+		info, err := os.Stat(candidate)
+		if err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in %s", name, path)
+}
+
+// Executable is the path of the git binary being run.
+func (c *Command) Executable() string {
+	return c.executable
+}
+
+// Version returns the version of git being run.
+func (c *Command) Version() Version {
+	return c.version
+}
+
+// Check runs git with args and throws away what it prints, for finding out if git supports them.
+func (c *Command) Check(args ...string) error {
+	_, err := c.executeString(args...)
+	return err
 }
 
 // CatFile is a wrapper around the git cat-file command. Read more here: https://git-scm.com/docs/git-cat-file.
@@ -27,6 +141,120 @@ func (c *Command) CatFile(objectType string, hash string) ([]byte, error) {
 	return c.executeString("cat-file", objectType, hash)
 }
 
+// CatFileTo streams the contents of the object named by hash to w without holding them in memory.
+func (c *Command) CatFileTo(objectType string, hash string, w io.Writer) (err error) {
+	args := []string{"cat-file", objectType, hash}
+	defer c.observe(time.Now(), args, &err)
+	cmd := c.execute(args...)
+	cmd.Stdout = w
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("'%s' failed: %v", cmd.String(), err)
+	}
+	return nil
+}
+
+// CatFileSize returns the size in bytes of the object named by hash.
+func (c *Command) CatFileSize(hash string) (int64, error) {
+	output, err := c.executeString("cat-file", "-s", hash)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+}
+
+// CatFileBatch calls handler with the contents of every object in hashes, in order, reading all of them with a single
+// git cat-file --batch. It fails on the first object that is missing.
+func (c *Command) CatFileBatch(hashes []string, handler func(hash string, contents []byte) error) (err error) {
+	args := []string{"cat-file", "--batch"}
+	defer c.observe(time.Now(), args, &err)
+	cmd := c.execute(args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to start stdin pipe '%s': %v", cmd.String(), err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to start stdout pipe '%s': %v", cmd.String(), err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start '%s': %v", cmd.String(), err)
+	}
+
+	// Write the requests while the replies are read so neither side fills up its pipe and blocks the other.
+	go func() {
+		writer := bufio.NewWriter(stdin)
+		for _, hash := range hashes {
+			if _, err := writer.WriteString(hash + "\n"); err != nil {
+				break
+			}
+		}
+		_ = writer.Flush()
+		_ = stdin.Close()
+	}()
+
+	if err := readBatch(bufio.NewReader(stdout), hashes, handler); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("'%s' failed: %v", cmd.String(), err)
+	}
+	return nil
+}
+
+// readBatch reads the reply of git cat-file --batch to hashes: "<hash> <type> <size>" then the contents and a newline
+// for each object, or "<hash> missing".
+func readBatch(reader *bufio.Reader, hashes []string, handler func(hash string, contents []byte) error) error {
+	for _, hash := range hashes {
+		_, size, err := readBatchHeader(reader, hash)
+		if err != nil {
+			return err
+		}
+		contents := make([]byte, size+1)
+		if _, err := io.ReadFull(reader, contents); err != nil {
+			return fmt.Errorf("failed to read object %s: %v", hash, err)
+		}
+		if err := handler(hash, contents[:size]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBatchHeader reads the "<hash> <type> <size>" line git cat-file --batch and --batch-check reply to name with. It
+// returns ErrObjectMissing if name doesn't exist.
+func readBatchHeader(reader *bufio.Reader, name string) (objectType string, size int, err error) {
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read object %s: %v", name, err)
+	}
+	fields := strings.Fields(header)
+	if len(fields) == 2 && fields[1] == "missing" {
+		return "", 0, fmt.Errorf("object %s: %w", name, ErrObjectMissing)
+	}
+	if len(fields) != 3 {
+		return "", 0, fmt.Errorf("could not parse cat-file header '%s'", strings.TrimSpace(header))
+	}
+	size, err = strconv.Atoi(fields[2])
+	if err != nil {
+		return "", 0, fmt.Errorf("could not parse cat-file header '%s': %v", strings.TrimSpace(header), err)
+	}
+	return fields[1], size, nil
+}
+
+// LsTreeRecursive lists every blob, symlink, and submodule under path, which is the whole tree if path is ".".
+func (c *Command) LsTreeRecursive(reference string, path string, handler func(entry TreeEntry) error) error {
+	return c.executeHandleLines(func(line string) error {
+		entry, err := NewTreeEntry(line)
+		if err != nil {
+			return fmt.Errorf("could not parse line '%s': %v", line, err)
+		}
+		return handler(entry)
+	}, "ls-tree", "-r", "--long", reference, path)
+}
+
 // LsTree lists a tree-like object from git.
 func (c *Command) LsTree(reference string, path string, handler func(entry TreeEntry) error) error {
 	return c.executeHandleLines(func(line string) error {
@@ -39,11 +267,68 @@ func (c *Command) LsTree(reference string, path string, handler func(entry TreeE
 	}, "ls-tree", "--long", reference, path)
 }
 
+// LsTreeDirectory lists the directory at path and its children with a single ls-tree. The directory's own entry comes
+// first. Nothing is listed if path isn't a directory.
+func (c *Command) LsTreeDirectory(reference string, path string, handler func(entry TreeEntry) error) error {
+	return c.executeHandleLines(func(line string) error {
+		entry, err := NewTreeEntry(line)
+		if err != nil {
+			return fmt.Errorf("could not parse line '%s': %v", line, err)
+		}
+		// -t also lists every directory above path, which are all shorter than it.
+		if len(entry.Path) < len(path) {
+			return nil
+		}
+		return handler(entry)
+	}, "ls-tree", "-t", "--long", reference, path+"/")
+}
+
+// LsTreeNames calls handler with the path of every entry ls-tree lists, without looking up their sizes.
+func (c *Command) LsTreeNames(reference string, path string, handler func(path string) error) error {
+	return c.executeHandleLines(func(line string) error {
+		path, err := unquotePath(line)
+		if err != nil {
+			return fmt.Errorf("could not parse line '%s': %v", line, err)
+		}
+		return handler(path)
+	}, "ls-tree", "--name-only", reference, path)
+}
+
+// LsFilesStage calls handler with every file staged in the index, sorted by path.
+func (c *Command) LsFilesStage(handler func(entry IndexEntry) error) error {
+	return c.executeHandleLines(func(line string) error {
+		entry, err := NewIndexEntry(line)
+		if err != nil {
+			return fmt.Errorf("could not parse line '%s': %v", line, err)
+		}
+		return handler(entry)
+	}, "ls-files", "--stage")
+}
+
+// IndexFile is where git keeps the index of the repository, which only non-bare repositories have.
+func (c *Command) IndexFile() string {
+	if c.directory == "" {
+		return filepath.Join(".git", "index")
+	}
+	return filepath.Join(c.directory, "index")
+}
+
 // ListTags calls handler for with the name of every tag in the git repo.
 func (c *Command) ListTags(handler func(branch string) error) error {
 	return c.executeHandleLines(func(line string) error {
 		return handler(line)
-	}, "branch", "--all")
+	}, "tag", "--list")
+}
+
+// ReplaceRefs calls handler with every object replaced through refs/replace/ and the object git reads in its place.
+func (c *Command) ReplaceRefs(handler func(original, replacement string) error) error {
+	return c.executeHandleLines(func(line string) error {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("could not parse replace ref '%s'", line)
+		}
+		return handler(fields[0], fields[1])
+	}, "for-each-ref", "--format=%(refname:lstrip=2) %(objectname)", "refs/replace/")
 }
 
 // ListBranches calls handler for with the name of every branch in the git repo.
@@ -57,7 +342,7 @@ func (c *Command) ListBranches(handler func(branch string) error) error {
 		}
 
 		return handler(strings.TrimSpace(line))
-	}, "tag", "--all")
+	}, "branch", "--list")
 }
 
 // ListCommits calls handler for with the hash of every commit in the history of ref.
@@ -67,55 +352,407 @@ func (c *Command) ListCommits(ref string, handler func(branch string) error) err
 	}, "log", "--pretty=format:'%h'", "--abbrev=-1", ref)
 }
 
+// RevList calls handler with every commit reachable from ref and its parents, newest first. With firstParent only the
+// first parent of each merge is followed, like git log --first-parent.
+func (c *Command) RevList(ref string, firstParent bool, handler func(commit GraphCommit) error) error {
+	args := []string{"rev-list", "--parents"}
+	if firstParent {
+		args = append(args, "--first-parent")
+	}
+	args, err := c.revisions(args, ref)
+	if err != nil {
+		return err
+	}
+	return c.executeHandleLines(func(line string) error {
+		commit, err := NewGraphCommit(line)
+		if err != nil {
+			return fmt.Errorf("could not parse line '%s': %v", line, err)
+		}
+
+		return handler(commit)
+	}, args...)
+}
+
+// Parents returns the full hashes of commit's parents, first parent first.
+func (c *Command) Parents(commit string) ([]string, error) {
+	args, err := c.revisions([]string{"rev-list", "--parents", "--max-count=1"}, commit)
+	if err != nil {
+		return nil, err
+	}
+	output, err := c.executeString(args...)
+	if err != nil {
+		return nil, err
+	}
+	graphCommit, err := NewGraphCommit(string(output))
+	if err != nil {
+		return nil, err
+	}
+	return graphCommit.Parents, nil
+}
+
+// ObjectFormat returns the hash algorithm used by the repository.
+func (c *Command) ObjectFormat() (ObjectFormat, error) {
+	output, err := c.executeString("rev-parse", "--show-object-format")
+	if err != nil {
+		return "", err
+	}
+	return NewObjectFormat(string(output)), nil
+}
+
+// CountObjects reports how many objects the repository has and how much space they take up.
+func (c *Command) CountObjects() (ObjectCounts, error) {
+	output, err := c.executeString("count-objects", "-v")
+	if err != nil {
+		return ObjectCounts{}, err
+	}
+	return NewObjectCounts(string(output))
+}
+
+// RevParse returns the full hash of the commit that rev points to.
+func (c *Command) RevParse(rev string) (string, error) {
+	args, err := c.revisions([]string{"rev-parse", "--verify"}, rev+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	output, err := c.executeString(args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Describe is a wrapper around git describe. Commits that can't be described by a tag are named by their
+// abbreviated hash.
+func (c *Command) Describe(commit string) (string, error) {
+	output, err := c.executeString("describe", "--tags", "--always", commit)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// LastCommitTime returns the committer time of the newest commit reachable from commit that changed something at or
+// under path. An empty path is the time of commit itself.
+func (c *Command) LastCommitTime(commit string, path string) (time.Time, error) {
+	args, err := c.revisions([]string{"log", "-1", "--format=%ct"}, commit)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if path != "" {
+		args = append(args, "--", ":(literal)"+path)
+	}
+	output, err := c.executeString(args...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse commit time '%s': %v", output, err)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// LastCommit describes the newest commit reachable from commit that changed something at or under path. It fails if no
+// commit did, like when nothing is at path. An empty path describes commit itself.
+func (c *Command) LastCommit(commit string, path string) (CommitInfo, error) {
+	args, err := c.revisions([]string{"log", "-1", "--format=" + CommitInfoFormat}, commit)
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	if path != "" {
+		args = append(args, "--", ":(literal)"+path)
+	}
+	output, err := c.executeString(args...)
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	if len(output) == 0 {
+		return CommitInfo{}, fmt.Errorf("no commit changed '%s'", path)
+	}
+	return NewCommitInfo(string(output))
+}
+
+// SearchCommits calls handler with the commits reachable from ref whose message matches pattern, an extended regular
+// expression, newest first like git log --grep. Only the first limit are found when it is positive.
+func (c *Command) SearchCommits(ref string, pattern string, limit int, handler func(commit CommitInfo) error) error {
+	// Messages span lines, so each commit starts with an ASCII record separator, which messages never contain in
+	// practice.
+	args := []string{"log", "--extended-regexp", "--grep=" + pattern, "--format=%x1e" + CommitInfoFormat}
+	if limit > 0 {
+		args = append(args, "--max-count="+strconv.Itoa(limit))
+	}
+	args, err := c.revisions(args, ref)
+	if err != nil {
+		return err
+	}
+	output, err := c.executeString(args...)
+	if err != nil {
+		return err
+	}
+	for _, record := range strings.Split(string(output), "\x1e")[1:] {
+		commit, err := NewCommitInfo(strings.TrimRight(record, "\n"))
+		if err != nil {
+			return fmt.Errorf("could not parse commit '%s': %v", record, err)
+		}
+		if err := handler(commit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileHistory calls handler with every commit reachable from ref that changed the file at path, newest first, along
+// with how it changed it. The file is followed back across renames like git log --follow, so older changes may be to
+// other paths.
+func (c *Command) FileHistory(ref string, path string, handler func(commit string, change Change) error) error {
+	args, err := c.revisions([]string{"log", "--follow", "--raw", "--no-abbrev", "--format=%x1e%H"}, ref)
+	if err != nil {
+		return err
+	}
+	commit := ""
+	return c.executeHandleLines(func(line string) error {
+		switch {
+		case strings.HasPrefix(line, "\x1e"):
+			commit = strings.TrimPrefix(line, "\x1e")
+		case strings.HasPrefix(line, ":"):
+			change, err := NewChange(line)
+			if err != nil {
+				return fmt.Errorf("could not parse line '%s': %v", line, err)
+			}
+			return handler(commit, change)
+		}
+		return nil
+	}, append(args, "--", ":(literal)"+path)...)
+}
+
+// Bisect picks the commit halfway between bad and the commits in good, which should be its ancestors, to test next
+// with git rev-list --bisect-vars. It fails if every commit reachable from bad is reachable from good.
+func (c *Command) Bisect(bad string, good []string) (BisectStep, error) {
+	args, err := c.revisions([]string{"rev-list", "--bisect-vars"}, bad)
+	if err != nil {
+		return BisectStep{}, err
+	}
+	for _, commit := range good {
+		args = append(args, "^"+commit)
+	}
+	output, err := c.executeString(args...)
+	if err != nil {
+		return BisectStep{}, err
+	}
+	return NewBisectStep(string(output))
+}
+
+// VerifyCommit checks the GPG or SSH signature of commit with git verify-commit. The error describes why the
+// signature was rejected.
+func (c *Command) VerifyCommit(commit string) error {
+	args, err := c.revisions([]string{"verify-commit"}, commit)
+	if err != nil {
+		return err
+	}
+	return c.executeExplained(args...)
+}
+
+// VerifyTag checks the GPG or SSH signature of the annotated tag object named by tag with git verify-tag. The error
+// describes why the signature was rejected.
+func (c *Command) VerifyTag(tag string) error {
+	args, err := c.revisions([]string{"verify-tag"}, tag)
+	if err != nil {
+		return err
+	}
+	return c.executeExplained(args...)
+}
+
+// Fetch downloads refspecs, which may also be bare object hashes, from remote without fetching any tags they point
+// at.
+func (c *Command) Fetch(remote string, refspecs ...string) error {
+	args, err := c.revisions([]string{"fetch", "--quiet", "--no-tags"}, append([]string{remote}, refspecs...)...)
+	if err != nil {
+		return err
+	}
+	return c.executeExplained(args...)
+}
+
+// Note returns the note attached to object in notesRef, like refs/notes/commits. It returns ErrNoNote if there isn't
+// one.
+func (c *Command) Note(notesRef string, object string) (output []byte, err error) {
+	args := []string{"notes", "--ref=" + notesRef, "show", object}
+	defer c.observe(time.Now(), args, &err)
+	cmd := c.execute(args...)
+	output, err = cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		// git notes show only fails this way when there is no note, and fails with 128 for everything else.
+		return nil, ErrNoNote
+	}
+	if err != nil {
+		return nil, fmt.Errorf("'%s' failed: %v", cmd.String(), err)
+	}
+	return output, nil
+}
+
+// Status calls handler with the path of every file in worktree that differs from HEAD, including untracked files
+// but not ignored ones. Renames are reported as a deletion and an addition.
+func (c *Command) Status(worktree string, handler func(path string) error) error {
+	args := []string{"--work-tree", worktree, "status", "--porcelain", "-z", "--untracked-files=all"}
+	if c.version.AtLeast(statusNoRenamesVersion) {
+		args = append(args, "--no-renames")
+	}
+	output, err := c.executeString(args...)
+	if err != nil {
+		return err
+	}
+	records := strings.Split(string(output), "\x00")
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		if record == "" {
+			continue
+		}
+		// "XY <path>" where X and Y are the status in the index and the working tree.
+		if len(record) < 4 || record[2] != ' ' {
+			return fmt.Errorf("could not parse status '%s'", record)
+		}
+		if err := handler(record[3:]); err != nil {
+			return err
+		}
+		// Versions of git without --no-renames follow a rename or copy with the path it came from.
+		if (record[0] == 'R' || record[0] == 'C') && i+1 < len(records) {
+			i++
+			if err := handler(records[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DiffTree calls handler with every file changed by commit. Renames are detected and reported as a single
+// ChangeRename rather than a deletion and an addition.
+func (c *Command) DiffTree(commit string, handler func(change Change) error) error {
+	return c.executeHandleLines(func(line string) error {
+		change, err := NewChange(line)
+		if err != nil {
+			return fmt.Errorf("could not parse line '%s': %v", line, err)
+		}
+
+		return handler(change)
+	}, "diff-tree", "-r", "-M", "--root", "--no-commit-id", "--no-abbrev", commit)
+}
+
 func (c *Command) execute(args ...string) *exec.Cmd {
+	var global []string
 	if c.directory != "" {
-		args = append([]string{
-			"--git-dir", c.directory,
-		}, args...)
+		global = append(global, "--git-dir", c.directory)
 	}
-	cmd := exec.Command("git", args...)
+	for _, setting := range c.config {
+		global = append(global, "-c", setting)
+	}
+	cmd := exec.Command(c.executable, append(global, args...)...)
+	cmd.Env = c.env
 	return cmd
 }
 
+// observe reports a finished command to the observer. It is meant to be deferred at the start of the command.
+func (c *Command) observe(start time.Time, args []string, err *error) {
+	if c.observer != nil {
+		c.observer(args, time.Since(start), *err)
+	}
+}
+
 // executeHandleLines runs git with the provided args
-func (c *Command) executeHandleLines(lineHandler func(line string) error, args ...string) error {
+func (c *Command) executeHandleLines(lineHandler func(line string) error, args ...string) (err error) {
+	defer c.observe(time.Now(), args, &err)
+	return c.retry(func() ([]byte, error) {
+		return c.handleLines(lineHandler, args...)
+	})
+}
+
+// unknownRevision matches what git prints when it is asked about a revision the repository doesn't have, like a
+// branch with no commits yet.
+var unknownRevision = regexp.MustCompile(`Not a valid object name|unknown revision`)
+
+// handleLines is one attempt of executeHandleLines. It fails whenever git does, with an error wrapping os.ErrNotExist
+// for revisions the repository doesn't have, but only asks to be run again if git printed nothing, so that no line is
+// handled twice.
+func (c *Command) handleLines(lineHandler func(line string) error, args ...string) ([]byte, error) {
 	cmd := c.execute(args...)
-	cmd.Stderr = os.Stderr
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("failed to start stdout pipe '%s': %v", cmd.String(), err)
+		return nil, fmt.Errorf("failed to start stdout pipe '%s': %v", cmd.String(), err)
 	}
 
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start '%s': %v", cmd.String(), err)
+		return nil, fmt.Errorf("failed to start '%s': %v", cmd.String(), err)
 	}
-	defer cmd.Wait()
 
+	handled := false
 	reader := bufio.NewScanner(stdout)
 	for reader.Scan() {
-		line := reader.Text()
-		err = lineHandler(line)
-		if err != nil {
-			return err
+		handled = true
+		if err := lineHandler(reader.Text()); err != nil {
+			_ = cmd.Wait()
+			return nil, err
 		}
 	}
 
-	return nil
+	if err := cmd.Wait(); err != nil {
+		err = fmt.Errorf("'%s' failed: %v: %s", cmd.String(), err, strings.TrimSpace(stderr.String()))
+		if unknownRevision.Match(stderr.Bytes()) {
+			err = fmt.Errorf("%v: %w", err, os.ErrNotExist)
+		}
+		if handled {
+			return nil, err
+		}
+		return stderr.Bytes(), err
+	}
+	return nil, nil
 }
 
-func (c *Command) executeString(args ...string) ([]byte, error) {
-	cmd := c.execute(args...)
+// executeExplained runs git for commands that explain a failure on stderr, like verify-commit and fetch, and
+// includes the explanation in the error.
+func (c *Command) executeExplained(args ...string) (err error) {
+	defer c.observe(time.Now(), args, &err)
+	return c.retry(func() ([]byte, error) {
+		cmd := c.execute(args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return stderr.Bytes(), fmt.Errorf("'%s' failed: %v: %s", cmd.String(), err, strings.TrimSpace(stderr.String()))
+		}
+		return nil, nil
+	})
+}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
-	}
+func (c *Command) executeString(args ...string) (output []byte, err error) {
+	defer c.observe(time.Now(), args, &err)
+	err = c.retry(func() ([]byte, error) {
+		cmd := c.execute(args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+
+		err = cmd.Start()
+		if err != nil {
+			return nil, err
+		}
 
-	err = cmd.Start()
+		output, err = io.ReadAll(stdout)
+		if err != nil {
+			_ = cmd.Wait()
+			return nil, err
+		}
+		if err := cmd.Wait(); err != nil {
+			return stderr.Bytes(), fmt.Errorf("'%s' failed: %v", cmd.String(), err)
+		}
+		return nil, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer cmd.Wait()
-
-	return io.ReadAll(stdout)
+	return output, nil
 }
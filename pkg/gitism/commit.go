@@ -1,5 +1,12 @@
 package gitism
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // ChangeType describes the type of modification done to the file in the git index. A full enumerations of types can be
 // found on git's docs: https://git-scm.com/docs/git-diff-tree#:~:text=Possible%20status%20letters%20are
 type ChangeType uint8
@@ -23,8 +30,31 @@ const (
 	ChangeUnmerged
 )
 
+// NewChangeType parses the status letter git-diff-tree prints for a change.
+func NewChangeType(letter byte) ChangeType {
+	switch letter {
+	case 'A':
+		return ChangeAddition
+	case 'C':
+		return ChangeCopy
+	case 'D':
+		return ChangeDeletion
+	case 'M':
+		return ChangeModification
+	case 'R':
+		return ChangeRename
+	case 'T':
+		return ChangeFileType
+	case 'U':
+		return ChangeUnmerged
+	default:
+		return ChangeUnknown
+	}
+}
+
 // ChangeHashMissing is used by git to represent when a file cannot have a hash defined. The logic for when this is used
-// is defined in https://git-scm.com/docs/git-diff-tree#_raw_output_format.
+// is defined in https://git-scm.com/docs/git-diff-tree#_raw_output_format. Repositories using SHA-256 use a longer
+// string of zeros, see ObjectFormat.MissingHash.
 const ChangeHashMissing = "0000000000000000000000000000000000000000"
 
 // Change describes a modification to a single file. Refer to git's documentation about what is possible to include:
@@ -33,10 +63,195 @@ type Change struct {
 	Type               ChangeType
 	PreviousHash, Hash string // The previous hash of the file and the new hash of the file.
 	PreviousMode, Mode FileMode
-	Path               string
+	// PreviousPath is only set for renames and copies, it is where the file was copied or renamed from.
+	PreviousPath string
+	Path         string
+	// Score is the similarity percentage git computed for a rename or copy.
+	Score int
+}
+
+// NewChange parses a single line of git-diff-tree's raw output. For example:
+//
+//	:100644 100644 bcd1234... 0123456... M	file0
+//	:100644 100644 abcd123... 1234567... R86	file1	file3
+func NewChange(rawLine string) (Change, error) {
+	tab := strings.IndexByte(rawLine, '\t')
+	if !strings.HasPrefix(rawLine, ":") || tab == -1 {
+		return Change{}, fmt.Errorf("not a raw diff line")
+	}
+
+	fields := strings.Fields(rawLine[1:tab])
+	if len(fields) != 5 || len(fields[4]) == 0 {
+		return Change{}, fmt.Errorf("expected 5 fields before the path but found %d", len(fields))
+	}
+
+	previousMode, err := strconv.ParseUint(fields[0], 8, 16)
+	if err != nil {
+		return Change{}, err
+	}
+	mode, err := strconv.ParseUint(fields[1], 8, 16)
+	if err != nil {
+		return Change{}, err
+	}
+
+	change := Change{
+		Type:         NewChangeType(fields[4][0]),
+		PreviousHash: fields[2],
+		Hash:         fields[3],
+		PreviousMode: NewFileMode(uint16(previousMode)),
+		Mode:         NewFileMode(uint16(mode)),
+	}
+
+	if score := fields[4][1:]; score != "" {
+		change.Score, err = strconv.Atoi(score)
+		if err != nil {
+			return Change{}, fmt.Errorf("invalid similarity score '%s': %v", score, err)
+		}
+	}
+
+	paths := strings.Split(rawLine[tab+1:], "\t")
+	for i, path := range paths {
+		paths[i], err = unquotePath(path)
+		if err != nil {
+			return Change{}, err
+		}
+	}
+
+	switch {
+	case (change.Type == ChangeRename || change.Type == ChangeCopy) && len(paths) == 2:
+		change.PreviousPath = paths[0]
+		change.Path = paths[1]
+	case len(paths) == 1:
+		change.Path = paths[0]
+	default:
+		return Change{}, fmt.Errorf("unexpected number of paths: %d", len(paths))
+	}
+	return change, nil
+}
+
+// unquotePath undoes the C-style quoting git applies to paths with unusual characters when core.quotePath is set.
+func unquotePath(path string) (string, error) {
+	if !strings.HasPrefix(path, "\"") {
+		return path, nil
+	}
+	return strconv.Unquote(path)
 }
 
 type Commit struct {
 	Hash    string
 	Changes []Change
 }
+
+// GraphCommit is a commit and the commits it was made on top of, as printed by git rev-list --parents.
+type GraphCommit struct {
+	Hash string
+	// Parents are in the order git records them. The first parent is the branch a merge was made on.
+	Parents []string
+}
+
+// NewGraphCommit parses a single line of git rev-list --parents. For example:
+//
+//	0123456... bcd1234... abcd123...
+func NewGraphCommit(line string) (GraphCommit, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return GraphCommit{}, fmt.Errorf("expected a commit hash")
+	}
+	return GraphCommit{Hash: fields[0], Parents: fields[1:]}, nil
+}
+
+// IsMerge is true for commits with more than one parent.
+func (c GraphCommit) IsMerge() bool {
+	return len(c.Parents) > 1
+}
+
+// IsRoot is true for commits without any parents.
+func (c GraphCommit) IsRoot() bool {
+	return len(c.Parents) == 0
+}
+
+// CommitInfo is who wrote a commit, when, and why.
+type CommitInfo struct {
+	Hash        string
+	AuthorName  string
+	AuthorEmail string
+	AuthorTime  time.Time
+	// Message is the full commit message, subject and body.
+	Message string
+}
+
+// CommitInfoFormat is the git log --format NewCommitInfo parses. Fields are separated by NUL bytes, which git doesn't
+// allow in any of them.
+const CommitInfoFormat = "%H%x00%an%x00%ae%x00%at%x00%B"
+
+// NewCommitInfo parses a commit printed with CommitInfoFormat.
+func NewCommitInfo(output string) (CommitInfo, error) {
+	fields := strings.SplitN(output, "\x00", 5)
+	if len(fields) != 5 {
+		return CommitInfo{}, fmt.Errorf("expected 5 fields but found %d", len(fields))
+	}
+	seconds, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return CommitInfo{}, fmt.Errorf("could not parse author time '%s': %v", fields[3], err)
+	}
+	return CommitInfo{
+		Hash:        fields[0],
+		AuthorName:  fields[1],
+		AuthorEmail: fields[2],
+		AuthorTime:  time.Unix(seconds, 0),
+		Message:     strings.TrimRight(fields[4], "\n"),
+	}, nil
+}
+
+// BisectStep is the next commit to test while bisecting, as reported by git rev-list --bisect-vars.
+type BisectStep struct {
+	// Commit is the commit to test next, or the first bad commit once Candidates is 1.
+	Commit string
+	// Candidates is how many commits could still be the first bad one.
+	Candidates int
+	// Remaining is roughly how many commits are left to test after Commit.
+	Remaining int
+	// Steps is roughly how many more commits need to be tested after Commit.
+	Steps int
+}
+
+// NewBisectStep parses the output of git rev-list --bisect-vars. For example:
+//
+//	bisect_rev='e32c5270c00518c5dfe7a9bc48f926ab76e40447'
+//	bisect_nr=3
+//	bisect_good=3
+//	bisect_bad=2
+//	bisect_all=7
+//	bisect_steps=2
+func NewBisectStep(output string) (BisectStep, error) {
+	var step BisectStep
+	fields := map[string]*int{
+		"bisect_nr":    &step.Remaining,
+		"bisect_all":   &step.Candidates,
+		"bisect_steps": &step.Steps,
+	}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		separator := strings.Index(line, "=")
+		if separator < 0 {
+			return BisectStep{}, fmt.Errorf("could not parse bisect line '%s'", line)
+		}
+		name, value := line[:separator], line[separator+1:]
+		if name == "bisect_rev" {
+			step.Commit = strings.Trim(value, "'")
+			continue
+		}
+		field, ok := fields[name]
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return BisectStep{}, fmt.Errorf("could not parse %s '%s': %v", name, value, err)
+		}
+		*field = parsed
+	}
+	if step.Commit == "" || step.Candidates == 0 {
+		return BisectStep{}, fmt.Errorf("no commit to bisect in '%s'", output)
+	}
+	return step, nil
+}
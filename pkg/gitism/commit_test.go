@@ -0,0 +1,141 @@
+package gitism
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+	"time"
+)
+
+func TestChange(t *testing.T) {
+	tests := map[string]Change{
+		":100644 100644 bcd1234bcd1234bcd1234bcd1234bcd1234bcd1 0123456012345601234560123456012345601234 M\tfile0": {
+			Type:         ChangeModification,
+			PreviousHash: "bcd1234bcd1234bcd1234bcd1234bcd1234bcd1",
+			Hash:         "0123456012345601234560123456012345601234",
+			PreviousMode: FileMode{Type: RegularFile, Perms: 0644},
+			Mode:         FileMode{Type: RegularFile, Perms: 0644},
+			Path:         "file0",
+		},
+		":000000 100755 0000000000000000000000000000000000000000 2266c0a976d1b3c4df0b6d02217d1bbe11110693 A\tbin/run.sh": {
+			Type:         ChangeAddition,
+			PreviousHash: ChangeHashMissing,
+			Hash:         "2266c0a976d1b3c4df0b6d02217d1bbe11110693",
+			PreviousMode: FileMode{Type: RegularFile, Perms: 0},
+			Mode:         FileMode{Type: RegularFile, Perms: 0755},
+			Path:         "bin/run.sh",
+		},
+		":100644 100644 abcd123abcd123abcd123abcd123abcd123abcd 1234567123456712345671234567123456712345 R086\tfile1\tdir/file3": {
+			Type:         ChangeRename,
+			PreviousHash: "abcd123abcd123abcd123abcd123abcd123abcd",
+			Hash:         "1234567123456712345671234567123456712345",
+			PreviousMode: FileMode{Type: RegularFile, Perms: 0644},
+			Mode:         FileMode{Type: RegularFile, Perms: 0644},
+			PreviousPath: "file1",
+			Path:         "dir/file3",
+			Score:        86,
+		},
+		":120000 120000 abcd123abcd123abcd123abcd123abcd123abcd abcd123abcd123abcd123abcd123abcd123abcd R100\t\"caf\\303\\251\"\tcafe": {
+			Type:         ChangeRename,
+			PreviousHash: "abcd123abcd123abcd123abcd123abcd123abcd",
+			Hash:         "abcd123abcd123abcd123abcd123abcd123abcd",
+			PreviousMode: FileMode{Type: Symlink, Perms: 0},
+			Mode:         FileMode{Type: Symlink, Perms: 0},
+			PreviousPath: "café",
+			Path:         "cafe",
+			Score:        100,
+		},
+	}
+
+	for line, want := range tests {
+		got, err := NewChange(line)
+		if err != nil {
+			t.Fatalf("could not parse valid change '%s': %v", line, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatal(diff)
+		}
+	}
+
+	for _, invalid := range []string{"", "M\tfile0", ":100644 100644 abc M", ":100644 abc def M\tfile0"} {
+		if _, err := NewChange(invalid); err == nil {
+			t.Fatalf("parsed invalid change line '%s'", invalid)
+		}
+	}
+}
+
+func TestGraphCommit(t *testing.T) {
+	tests := map[string]GraphCommit{
+		"0123456012345601234560123456012345601234": {
+			Hash:    "0123456012345601234560123456012345601234",
+			Parents: []string{},
+		},
+		"0123456012345601234560123456012345601234 bcd1234bcd1234bcd1234bcd1234bcd1234bcd1 abcd123abcd123abcd123abcd123abcd123abcd\n": {
+			Hash:    "0123456012345601234560123456012345601234",
+			Parents: []string{"bcd1234bcd1234bcd1234bcd1234bcd1234bcd1", "abcd123abcd123abcd123abcd123abcd123abcd"},
+		},
+	}
+
+	for line, want := range tests {
+		got, err := NewGraphCommit(line)
+		if err != nil {
+			t.Fatalf("could not parse valid commit '%s': %v", line, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatal(diff)
+		}
+		if got.IsMerge() != (len(want.Parents) > 1) || got.IsRoot() != (len(want.Parents) == 0) {
+			t.Fatalf("'%s' was misclassified as a merge or root commit", line)
+		}
+	}
+
+	if _, err := NewGraphCommit(" \n"); err == nil {
+		t.Fatal("parsed an empty line")
+	}
+}
+
+func TestCommitInfo(t *testing.T) {
+	output := "0123456012345601234560123456012345601234\x00A U Thor\x00author@example.com\x001609459200\x00Add a file\n\nWith a body.\n\n"
+	want := CommitInfo{
+		Hash:        "0123456012345601234560123456012345601234",
+		AuthorName:  "A U Thor",
+		AuthorEmail: "author@example.com",
+		AuthorTime:  time.Unix(1609459200, 0),
+		Message:     "Add a file\n\nWith a body.",
+	}
+	got, err := NewCommitInfo(output)
+	if err != nil {
+		t.Fatalf("could not parse valid commit info: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+
+	for _, invalid := range []string{"", "abc\x00name\x00email\x00time\x00message", "abc\x00name\x00email"} {
+		if _, err := NewCommitInfo(invalid); err == nil {
+			t.Fatalf("parsed invalid commit info %q", invalid)
+		}
+	}
+}
+
+func TestBisectStep(t *testing.T) {
+	output := "bisect_rev='e32c5270c00518c5dfe7a9bc48f926ab76e40447'\nbisect_nr=3\nbisect_good=3\nbisect_bad=2\nbisect_all=7\nbisect_steps=2\n"
+	want := BisectStep{
+		Commit:     "e32c5270c00518c5dfe7a9bc48f926ab76e40447",
+		Candidates: 7,
+		Remaining:  3,
+		Steps:      2,
+	}
+	got, err := NewBisectStep(output)
+	if err != nil {
+		t.Fatalf("could not parse valid bisect output: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+
+	for _, invalid := range []string{"", "bisect_nr=3", "bisect_rev='abc'\nbisect_all=x", "bisect_rev"} {
+		if _, err := NewBisectStep(invalid); err == nil {
+			t.Fatalf("parsed invalid bisect output %q", invalid)
+		}
+	}
+}
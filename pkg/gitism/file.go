@@ -6,6 +6,8 @@ const (
 	RegularFile FileType = iota
 	Directory
 	Symlink
+	// Gitlink is a submodule. The entry names a commit in another repository.
+	Gitlink
 )
 
 // FileMode is a struct representing the "type" of a file in the git repo. This is a tuple of FileType and PermissionMask.
@@ -29,7 +31,12 @@ func NewFileMode(gitMode uint16) FileMode {
 		Perms: PermissionMask(gitMode & gitPermsMask),
 	}
 
-	if gitMode&gitSymlinkMask == gitSymlinkMask || gitMode&gitLinkMask == gitLinkMask {
+	// A gitlink's bits are a superset of a symlink's so it has to be checked first.
+	if gitMode&gitLinkMask == gitLinkMask {
+		// Submodules are checked out as directories.
+		mode.Type = Gitlink
+		mode.Perms = 0444
+	} else if gitMode&gitSymlinkMask == gitSymlinkMask {
 		mode.Type = Symlink
 	} else if gitMode&gitDirectoryMask == gitDirectoryMask {
 		// Git does not store permissions for directories so we need
@@ -0,0 +1,54 @@
+package gitism
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IndexEntry is a file staged in the index of a repository.
+type IndexEntry struct {
+	Mode FileMode
+	Hash string
+	// Stage is 0 for a file ready to be committed. Files with unresolved conflicts are listed once for each version
+	// instead: 1 for the common ancestor, 2 for the current branch, and 3 for the branch being merged.
+	Stage int
+	Path  string
+}
+
+// NewIndexEntry parses a line of git ls-files --stage.
+func NewIndexEntry(lsFilesLine string) (IndexEntry, error) {
+	// "100644 c64211fac0a777ffada0af11bd64ca20e6289d7c 0	README.md"
+	//  <mode> SP <object> SP <stage> TAB <path>
+	tab := strings.IndexByte(lsFilesLine, '\t')
+	if tab == -1 {
+		return IndexEntry{}, fmt.Errorf("expected <mode> <object> <stage>\t<path>")
+	}
+	fields := strings.Fields(lsFilesLine[:tab])
+	if len(fields) != 3 || tab == len(lsFilesLine)-1 {
+		return IndexEntry{}, fmt.Errorf("expected <mode> <object> <stage>\t<path>")
+	}
+
+	mode, err := strconv.ParseUint(fields[0], 8, 16)
+	if err != nil {
+		return IndexEntry{}, err
+	}
+	if !IsHash(fields[1]) {
+		return IndexEntry{}, fmt.Errorf("invalid object name '%s'", fields[1])
+	}
+	stage, err := strconv.Atoi(fields[2])
+	if err != nil || stage < 0 || stage > 3 {
+		return IndexEntry{}, fmt.Errorf("invalid stage '%s'", fields[2])
+	}
+	path, err := unquotePath(lsFilesLine[tab+1:])
+	if err != nil {
+		return IndexEntry{}, err
+	}
+
+	return IndexEntry{
+		Mode:  NewFileMode(uint16(mode)),
+		Hash:  fields[1],
+		Stage: stage,
+		Path:  path,
+	}, nil
+}
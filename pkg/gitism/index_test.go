@@ -0,0 +1,48 @@
+package gitism
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestIndexEntry(t *testing.T) {
+	tests := map[string]IndexEntry{
+		"100644 c64211fac0a777ffada0af11bd64ca20e6289d7c 0\tREADME.md": {
+			Mode: FileMode{Type: RegularFile, Perms: PermissionMask(0644)},
+			Hash: "c64211fac0a777ffada0af11bd64ca20e6289d7c",
+			Path: "README.md",
+		},
+		"100755 2266c0a976d1b3c4df0b6d02217d1bbe11110693 2\tbin/my file.sh": {
+			Mode:  FileMode{Type: RegularFile, Perms: PermissionMask(0755)},
+			Hash:  "2266c0a976d1b3c4df0b6d02217d1bbe11110693",
+			Stage: 2,
+			Path:  "bin/my file.sh",
+		},
+		"160000 6ef19b41225c5369f1c104d45d8d85efa9b057b53b14b4b9b939dd74decc5321 0\t\"caf\\303\\251\"": {
+			Mode: FileMode{Type: Gitlink, Perms: PermissionMask(0444)},
+			Hash: "6ef19b41225c5369f1c104d45d8d85efa9b057b53b14b4b9b939dd74decc5321",
+			Path: "café",
+		},
+	}
+	for line, want := range tests {
+		entry, err := NewIndexEntry(line)
+		if err != nil {
+			t.Fatalf("NewIndexEntry(%q) failed: %v", line, err)
+		}
+		if diff := cmp.Diff(want, entry); diff != "" {
+			t.Fatalf("NewIndexEntry(%q) mismatch (-want +got):\n%s", line, diff)
+		}
+	}
+
+	for _, line := range []string{
+		"",
+		"100644 c64211fac0a777ffada0af11bd64ca20e6289d7c\tREADME.md",
+		"100644 c64211fac0a777ffada0af11bd64ca20e6289d7c 4\tREADME.md",
+		"100644 not-a-hash 0\tREADME.md",
+		"100644 c64211fac0a777ffada0af11bd64ca20e6289d7c 0\t",
+	} {
+		if _, err := NewIndexEntry(line); err == nil {
+			t.Fatalf("NewIndexEntry(%q) should fail", line)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package gitism
+
+import "fmt"
+
+// MaintenanceTask picks how Maintain compacts a repository.
+type MaintenanceTask string
+
+const (
+	// MaintenanceGC runs git gc, which repacks everything into one pack and prunes old loose objects. The largest pack
+	// is left alone on versions of git that can, so most objects keep being read from the same file.
+	MaintenanceGC MaintenanceTask = "gc"
+	// MaintenanceIncremental runs git maintenance run with the commit-graph, loose-objects, and incremental-repack
+	// tasks, which pack loose objects and small packs behind a multi-pack-index and only delete packs the index no
+	// longer refers to. Loose objects are deleted the next time it runs, once they were packed. It is made for
+	// repositories that are read while they are maintained.
+	MaintenanceIncremental MaintenanceTask = "incremental"
+)
+
+var (
+	// keepLargestPackVersion is the first git whose gc understands --keep-largest-pack. Older ones repack everything.
+	keepLargestPackVersion = Version{Major: 2, Minor: 18}
+	// maintenanceTasksVersion is the first git whose maintenance run has the loose-objects and incremental-repack
+	// tasks.
+	maintenanceTasksVersion = Version{Major: 2, Minor: 30}
+)
+
+// ParseMaintenanceTask parses the name of a MaintenanceTask, gc or incremental.
+func ParseMaintenanceTask(name string) (MaintenanceTask, error) {
+	switch task := MaintenanceTask(name); task {
+	case MaintenanceGC, MaintenanceIncremental:
+		return task, nil
+	default:
+		return "", fmt.Errorf("unknown maintenance task '%s', expected gc or incremental", name)
+	}
+}
+
+// Maintain compacts the repository with task and waits for it to finish. Processes already reading the repository,
+// like a BatchProcess, hold on to the packs they opened until they exit, even once maintenance deleted them. Versions
+// of git too old for task return ErrUnsupportedVersion.
+func (c *Command) Maintain(task MaintenanceTask) error {
+	switch task {
+	case MaintenanceGC:
+		args := []string{"gc", "--quiet"}
+		if c.version.AtLeast(keepLargestPackVersion) {
+			args = append(args, "--keep-largest-pack")
+		}
+		return c.executeExplained(args...)
+	case MaintenanceIncremental:
+		if !c.version.AtLeast(maintenanceTasksVersion) {
+			return fmt.Errorf("%w: incremental maintenance needs git %s or later, not %s", ErrUnsupportedVersion, maintenanceTasksVersion, c.version)
+		}
+		if err := c.executeExplained("maintenance", "run", "--quiet", "--task=commit-graph", "--task=loose-objects"); err != nil {
+			return err
+		}
+		// incremental-repack fails without a pack to index, and only sees the pack loose-objects wrote if it is run
+		// separately.
+		counts, err := c.CountObjects()
+		if err != nil || counts.Packs == 0 {
+			return err
+		}
+		return c.executeExplained("maintenance", "run", "--quiet", "--task=incremental-repack")
+	default:
+		return fmt.Errorf("unknown maintenance task '%s'", task)
+	}
+}
@@ -1,11 +1,22 @@
 package gitism
 
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
 type ObjectType uint8
 
 const (
 	UnknownObjectType ObjectType = iota
 	BlobObject
 	TreeObject
+	CommitObject
 )
 
 func NewObjectType(name string) ObjectType {
@@ -14,6 +25,8 @@ func NewObjectType(name string) ObjectType {
 		return BlobObject
 	case "tree":
 		return TreeObject
+	case "commit":
+		return CommitObject
 	default:
 		return UnknownObjectType
 	}
@@ -25,7 +38,124 @@ func (t ObjectType) String() string {
 		return "blob"
 	case TreeObject:
 		return "tree"
+	case CommitObject:
+		return "commit"
 	default:
 		return "unknown-object"
 	}
 }
+
+// ObjectFormat is the hash algorithm a repository names its objects with.
+type ObjectFormat string
+
+const (
+	SHA1   ObjectFormat = "sha1"
+	SHA256 ObjectFormat = "sha256"
+)
+
+// NewObjectFormat parses the output of `git rev-parse --show-object-format`. Versions of git from before SHA-256
+// support don't know the flag so anything unrecognized is SHA-1.
+func NewObjectFormat(name string) ObjectFormat {
+	if strings.TrimSpace(name) == string(SHA256) {
+		return SHA256
+	}
+	return SHA1
+}
+
+// HashLength is the number of hex characters in an object name.
+func (f ObjectFormat) HashLength() int {
+	if f == SHA256 {
+		return 64
+	}
+	return 40
+}
+
+// MissingHash is the object name git uses when there is no object, for example the previous hash of an added file.
+func (f ObjectFormat) MissingHash() string {
+	return strings.Repeat("0", f.HashLength())
+}
+
+// EmptyTree is the name of the tree with nothing in it, which git knows of in every repository whether or not it was
+// ever written.
+func (f ObjectFormat) EmptyTree() string {
+	if f == SHA256 {
+		return "6ef19b41225c5369f1c104d45d8d85efa9b057b53b14b4b9b939dd74decc5321"
+	}
+	return "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+}
+
+// BlobHash is the name of the blob holding contents.
+func (f ObjectFormat) BlobHash(contents []byte) string {
+	var h hash.Hash
+	if f == SHA256 {
+		h = sha256.New()
+	} else {
+		h = sha1.New()
+	}
+	fmt.Fprintf(h, "blob %d\x00", len(contents))
+	h.Write(contents)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// IsHash reports if name is a full SHA-1 or SHA-256 object name.
+func IsHash(name string) bool {
+	if len(name) != SHA1.HashLength() && len(name) != SHA256.HashLength() {
+		return false
+	}
+	for _, c := range name {
+		if !('0' <= c && c <= '9') && !('a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ObjectCounts describes the object store of a repository as reported by git count-objects -v. Sizes are in KiB.
+type ObjectCounts struct {
+	Loose, LooseSize     int64
+	Packed, Packs        int64
+	PackSize             int64
+	PrunePackable        int64
+	Garbage, GarbageSize int64
+}
+
+// NewObjectCounts parses the output of git count-objects -v. For example:
+//
+//	count: 3
+//	size: 12
+//	in-pack: 120
+//	packs: 1
+//	size-pack: 56
+//	prune-packable: 0
+//	garbage: 0
+//	size-garbage: 0
+func NewObjectCounts(output string) (ObjectCounts, error) {
+	var counts ObjectCounts
+	fields := map[string]*int64{
+		"count":          &counts.Loose,
+		"size":           &counts.LooseSize,
+		"in-pack":        &counts.Packed,
+		"packs":          &counts.Packs,
+		"size-pack":      &counts.PackSize,
+		"prune-packable": &counts.PrunePackable,
+		"garbage":        &counts.Garbage,
+		"size-garbage":   &counts.GarbageSize,
+	}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		colon := strings.IndexByte(line, ':')
+		if colon == -1 {
+			return ObjectCounts{}, fmt.Errorf("could not parse line '%s'", line)
+		}
+		field, ok := fields[line[:colon]]
+		if !ok {
+			// Newer versions of git may add fields.
+			continue
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(line[colon+1:]), 10, 64)
+		if err != nil {
+			return ObjectCounts{}, fmt.Errorf("could not parse line '%s': %v", line, err)
+		}
+		*field = value
+	}
+	return counts, nil
+}
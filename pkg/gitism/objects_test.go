@@ -0,0 +1,45 @@
+package gitism
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestObjectCounts(t *testing.T) {
+	output := "count: 3\nsize: 12\nin-pack: 120\npacks: 1\nsize-pack: 56\nprune-packable: 2\ngarbage: 0\nsize-garbage: 0\n"
+	got, err := NewObjectCounts(output)
+	if err != nil {
+		t.Fatalf("could not parse valid output: %v", err)
+	}
+	want := ObjectCounts{Loose: 3, LooseSize: 12, Packed: 120, Packs: 1, PackSize: 56, PrunePackable: 2}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+
+	for _, invalid := range []string{"count 3", "count: three"} {
+		if _, err := NewObjectCounts(invalid); err == nil {
+			t.Fatalf("parsed invalid output '%s'", invalid)
+		}
+	}
+}
+
+func TestBlobHash(t *testing.T) {
+	// git hash-object --stdin <<< "Hello World", with and without --object-format=sha256.
+	for format, want := range map[ObjectFormat]string{
+		SHA1:   "557db03de997c86a4a028e1ebd3a1ceb225be238",
+		SHA256: "7c5c8610459154bdde4984be72c48fb5d9c1c4ac793a6b5976fe38fd1b0b1284",
+	} {
+		if got := format.BlobHash([]byte("Hello World\n")); got != want {
+			t.Errorf("%s.BlobHash() = %s, expected %s", format, got, want)
+		}
+	}
+}
+
+func TestParseAlternates(t *testing.T) {
+	contents := "# borrowed from upstream\n../../../upstream/.git/objects\n\n/srv/mirror/objects\n"
+	got := ParseAlternates("/src/fork/.git/objects", []byte(contents))
+	want := []string{"/src/upstream/.git/objects", "/srv/mirror/objects"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
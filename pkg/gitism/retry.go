@@ -0,0 +1,80 @@
+package gitism
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRepositoryLocked is returned when git kept failing because something else, like git gc or git repack, held a
+// lock on the repository every time it was run.
+var ErrRepositoryLocked = errors.New("repository is locked")
+
+const (
+	// retryBackoff is how long to wait before running a command again the first time, doubling each time after until
+	// maxRetryBackoff.
+	retryBackoff    = 100 * time.Millisecond
+	maxRetryBackoff = 2 * time.Second
+)
+
+// retryable matches what git prints when it fails because of maintenance running in the repository at the same time:
+// a lock file that is already taken, or a pack that was removed by a repack while git was reading it.
+var retryable = regexp.MustCompile(`\.lock': File exists|cannot lock ref|Another git process seems to be running|packfile .* cannot be accessed`)
+
+// sleep waits between attempts. Tests replace it to not wait.
+var sleep = time.Sleep
+
+// RetryStats counts the git commands run again because the repository was locked.
+type RetryStats struct {
+	// Retries is how many times a command was run again.
+	Retries int64 `json:"retries"`
+	// Recovered counts the commands that succeeded after being run again, and Persistent those that failed every
+	// time and returned ErrRepositoryLocked.
+	Recovered  int64 `json:"recovered"`
+	Persistent int64 `json:"persistent"`
+}
+
+var retryStats RetryStats
+
+// Retries reports the commands run again by every Command in the process.
+func Retries() RetryStats {
+	return RetryStats{
+		Retries:    atomic.LoadInt64(&retryStats.Retries),
+		Recovered:  atomic.LoadInt64(&retryStats.Recovered),
+		Persistent: atomic.LoadInt64(&retryStats.Persistent),
+	}
+}
+
+// IsRetryable reports if git failed with stderr because the repository was busy rather than because of what it was
+// asked to do.
+func IsRetryable(stderr []byte) bool {
+	return retryable.Match(stderr)
+}
+
+// retry runs attempt until it succeeds, fails for a reason that isn't retryable, or has been run again c.retries
+// times, backing off between attempts. attempt returns what git printed to stderr along with its error.
+func (c *Command) retry(attempt func() (stderr []byte, err error)) error {
+	backoff := retryBackoff
+	for retries := 0; ; retries++ {
+		stderr, err := attempt()
+		switch {
+		case err == nil:
+			if retries > 0 {
+				atomic.AddInt64(&retryStats.Recovered, 1)
+			}
+			return nil
+		case !IsRetryable(stderr):
+			return err
+		case retries >= c.retries:
+			atomic.AddInt64(&retryStats.Persistent, 1)
+			return fmt.Errorf("%w: %v", ErrRepositoryLocked, err)
+		}
+		atomic.AddInt64(&retryStats.Retries, 1)
+		sleep(backoff)
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
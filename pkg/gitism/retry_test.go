@@ -0,0 +1,153 @@
+package gitism
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const lockedMessage = "fatal: Unable to create '/repository/packed-refs.lock': File exists."
+
+// lockedGit writes a git that fails with message the first failures times it is run, and then lists a file. The
+// returned file has a line for every time it was run.
+func lockedGit(t *testing.T, failures int, message string) (executable string, runs string) {
+	directory := t.TempDir()
+	executable = filepath.Join(directory, "git")
+	runs = filepath.Join(directory, "runs")
+	script := fmt.Sprintf(`#!/bin/sh
+if [ "$1" = version ]; then echo 'git version 2.39.5'; exit 0; fi
+echo run >> '%s'
+if [ $(wc -l < '%s') -le %d ]; then echo "%s" >&2; exit 128; fi
+printf '100644 blob 4b825dc642cb6eb9a060e54bf8d69288fbee4904       0\tREADME.md\n'
+`, runs, runs, failures, message)
+	if err := ioutil.WriteFile(executable, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return executable, runs
+}
+
+func countRuns(t *testing.T, runs string) int {
+	contents, err := ioutil.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(contents), "\n")
+}
+
+func TestRetry(t *testing.T) {
+	var slept []time.Duration
+	sleep = func(backoff time.Duration) {
+		slept = append(slept, backoff)
+	}
+	defer func() {
+		sleep = time.Sleep
+	}()
+
+	command := func(t *testing.T, failures, retries int, message string) (Command, string) {
+		executable, runs := lockedGit(t, failures, message)
+		cli, err := NewCommandWithOptions(t.TempDir(), CommandOptions{Executable: executable, Retries: retries})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cli, runs
+	}
+
+	t.Run("recovers", func(t *testing.T) {
+		slept = nil
+		before := Retries()
+		cli, runs := command(t, 2, 3, lockedMessage)
+		if _, err := cli.RevParse("main"); err != nil {
+			t.Fatalf("RevParse() failed once the lock was released: %v", err)
+		}
+		if got := countRuns(t, runs); got != 3 {
+			t.Fatalf("git was run %d times, want 3", got)
+		}
+		if len(slept) != 2 || slept[1] != 2*slept[0] {
+			t.Fatalf("backed off for %v", slept)
+		}
+		after := Retries()
+		if after.Retries-before.Retries != 2 || after.Recovered-before.Recovered != 1 || after.Persistent != before.Persistent {
+			t.Fatalf("counted %+v, then %+v", before, after)
+		}
+	})
+
+	t.Run("persistent", func(t *testing.T) {
+		before := Retries()
+		cli, runs := command(t, 10, 2, lockedMessage)
+		if err := cli.VerifyCommit("main"); !errors.Is(err, ErrRepositoryLocked) {
+			t.Fatalf("VerifyCommit() = %v, want ErrRepositoryLocked", err)
+		}
+		if got := countRuns(t, runs); got != 3 {
+			t.Fatalf("git was run %d times, want 3", got)
+		}
+		if after := Retries(); after.Persistent-before.Persistent != 1 {
+			t.Fatalf("counted %+v, then %+v", before, after)
+		}
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		cli, runs := command(t, 1, 1, lockedMessage)
+		var paths []string
+		err := cli.LsTree("main", "", func(entry TreeEntry) error {
+			paths = append(paths, entry.Path)
+			return nil
+		})
+		if err != nil || strings.Join(paths, " ") != "README.md" {
+			t.Fatalf("LsTree() = %v, %v", paths, err)
+		}
+		if got := countRuns(t, runs); got != 2 {
+			t.Fatalf("git was run %d times, want 2", got)
+		}
+	})
+
+	t.Run("streaming fails", func(t *testing.T) {
+		cli, runs := command(t, 1, 0, "fatal: Not a valid object name main")
+		err := cli.LsTree("main", "", func(entry TreeEntry) error { return nil })
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("LsTree() of a missing revision = %v, want os.ErrNotExist", err)
+		}
+		if got := countRuns(t, runs); got != 1 {
+			t.Fatalf("git was run %d times, want 1", got)
+		}
+	})
+
+	t.Run("streaming fails after output", func(t *testing.T) {
+		executable := filepath.Join(t.TempDir(), "git")
+		script := fmt.Sprintf(`#!/bin/sh
+if [ "$1" = version ]; then echo 'git version 2.39.5'; exit 0; fi
+printf '100644 blob 4b825dc642cb6eb9a060e54bf8d69288fbee4904       0\tREADME.md\n'
+echo "%s" >&2
+exit 128
+`, lockedMessage)
+		if err := ioutil.WriteFile(executable, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		cli, err := NewCommandWithOptions(t.TempDir(), CommandOptions{Executable: executable, Retries: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		handled := 0
+		err = cli.LsTree("main", "", func(entry TreeEntry) error {
+			handled++
+			return nil
+		})
+		if err == nil || errors.Is(err, ErrRepositoryLocked) || handled != 1 {
+			t.Fatalf("LsTree() = %v after handling %d lines, want the failure without running git again", err, handled)
+		}
+	})
+
+	t.Run("not retryable", func(t *testing.T) {
+		cli, runs := command(t, 1, 3, "fatal: Needed a single revision")
+		if _, err := cli.RevParse("missing"); err == nil || errors.Is(err, ErrRepositoryLocked) {
+			t.Fatalf("RevParse() = %v", err)
+		}
+		if got := countRuns(t, runs); got != 1 {
+			t.Fatalf("git was run %d times, want 1", got)
+		}
+	})
+}
@@ -1,9 +1,9 @@
 package gitism
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
-	"unicode"
 )
 
 type TreeEntry struct {
@@ -16,25 +16,40 @@ type TreeEntry struct {
 
 func NewTreeEntry(lsTreeLine string) (TreeEntry, error) {
 	// We will parse a line in this format:
-	// "100644 blob c64211fac0a777ffada0af11bd64ca20e6289d7c    3500    README.md"
-	//  012345|7890|2345678901234567890123456789012345678901|
-	//  6,     4,   40
-
-	modeText := lsTreeLine[0:6]
-	typeText := lsTreeLine[7:11]
-	hashText := lsTreeLine[12:52]
+	// "100644 blob c64211fac0a777ffada0af11bd64ca20e6289d7c    3500	README.md"
+	//  <mode> SP <type> SP <object> SP+ <size> TAB <path>
+	// The length of <object> depends on the object format of the repository.
+	var fields []string
+	var pathText string
+	if tab := strings.IndexByte(lsTreeLine, '\t'); tab != -1 {
+		fields = strings.Fields(lsTreeLine[:tab])
+		pathText = lsTreeLine[tab+1:]
+	} else {
+		// Tolerate the tab having been expanded into spaces. This can't preserve runs of spaces within the path.
+		fields = strings.Fields(lsTreeLine)
+		if len(fields) > 4 {
+			pathText = strings.Join(fields[4:], " ")
+			fields = fields[:4]
+		}
+	}
+	if len(fields) != 4 || pathText == "" {
+		return TreeEntry{}, fmt.Errorf("expected <mode> <type> <object> <size>\t<path>")
+	}
+	modeText, typeText, hashText, size := fields[0], fields[1], fields[2], fields[3]
 
 	mode, err := strconv.ParseUint(modeText, 8, 16)
 	if err != nil {
 		return TreeEntry{}, err
 	}
 
-	remainder := strings.TrimSpace(lsTreeLine[52:])
+	if !IsHash(hashText) {
+		return TreeEntry{}, fmt.Errorf("invalid object name '%s'", hashText)
+	}
 
-	// <size> and <path> are seperated by a tab character
-	nextWhiteSpace := strings.IndexFunc(remainder, unicode.IsSpace)
-	size := remainder[:nextWhiteSpace]
-	path := strings.TrimSpace(remainder[nextWhiteSpace+1:])
+	path, err := unquotePath(pathText)
+	if err != nil {
+		return TreeEntry{}, err
+	}
 
 	entry := TreeEntry{
 		Mode:   NewFileMode(uint16(mode)),
@@ -26,3 +26,68 @@ func TestTree(t *testing.T) {
 		t.Fatal(diff)
 	}
 }
+
+func TestTreeObjectFormats(t *testing.T) {
+	tests := map[string]TreeEntry{
+		"100755 blob 2266c0a976d1b3c4df0b6d02217d1bbe11110693     633\tbin/my file.sh": {
+			Mode:   FileMode{Type: RegularFile, Perms: PermissionMask(0755)},
+			Object: BlobObject,
+			Hash:   "2266c0a976d1b3c4df0b6d02217d1bbe11110693",
+			Size:   "633",
+			Path:   "bin/my file.sh",
+		},
+		"040000 tree 6ef19b41225c5369f1c104d45d8d85efa9b057b53b14b4b9b939dd74decc5321       -\ttest": {
+			Mode:   FileMode{Type: Directory, Perms: PermissionMask(0444)},
+			Object: TreeObject,
+			Hash:   "6ef19b41225c5369f1c104d45d8d85efa9b057b53b14b4b9b939dd74decc5321",
+			Size:   "-",
+			Path:   "test",
+		},
+		"100644 blob 6ef19b41225c5369f1c104d45d8d85efa9b057b53b14b4b9b939dd74decc5321 5000000000\t\"caf\\303\\251.txt\"": {
+			Mode:   FileMode{Type: RegularFile, Perms: PermissionMask(0644)},
+			Object: BlobObject,
+			Hash:   "6ef19b41225c5369f1c104d45d8d85efa9b057b53b14b4b9b939dd74decc5321",
+			Size:   "5000000000",
+			Path:   "café.txt",
+		},
+	}
+	for line, want := range tests {
+		got, err := NewTreeEntry(line)
+		if err != nil {
+			t.Fatalf("could not parse valid tree '%s': %v", line, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatal(diff)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"100644 blob c64211fa 3500\tREADME.md",
+		"100644 blob c64211fac0a777ffada0af11bd64ca20e6289d7cXX 3500\tREADME.md",
+		"100644 blob c64211fac0a777ffada0af11bd64ca20e6289d7c\tREADME.md",
+	}
+	for _, line := range invalid {
+		if _, err := NewTreeEntry(line); err == nil {
+			t.Fatalf("parsed invalid tree line '%s'", line)
+		}
+	}
+}
+
+func TestGitlink(t *testing.T) {
+	line := "160000 commit 2266c0a976d1b3c4df0b6d02217d1bbe11110693       -\tvendor/lib"
+	got, err := NewTreeEntry(line)
+	if err != nil {
+		t.Fatalf("could not parse gitlink '%s': %v", line, err)
+	}
+	want := TreeEntry{
+		Mode:   FileMode{Type: Gitlink, Perms: PermissionMask(0444)},
+		Object: CommitObject,
+		Hash:   "2266c0a976d1b3c4df0b6d02217d1bbe11110693",
+		Size:   "-",
+		Path:   "vendor/lib",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,115 @@
+package gitism
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrUnsupportedVersion is returned when the git being run is older than MinimumVersion.
+var ErrUnsupportedVersion = errors.New("unsupported git version")
+
+// Version is a release of git.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+var (
+	// MinimumVersion is the oldest git that can be run. It is the first to understand %(refname:lstrip).
+	MinimumVersion = Version{Major: 2, Minor: 13}
+	// endOfOptionsVersion is the first git to understand --end-of-options. Older ones are run without it.
+	endOfOptionsVersion = Version{Major: 2, Minor: 24}
+	// statusNoRenamesVersion is the first git whose status understands --no-renames. Older ones are run without it,
+	// and the renames they report are split back into a deletion and an addition.
+	statusNoRenamesVersion = Version{Major: 2, Minor: 18}
+)
+
+// ParseVersion parses the output of git version, like "git version 2.39.5", "git version 2.37.1 (Apple Git-137.1)",
+// or "git version 2.41.0.windows.1".
+func ParseVersion(output string) (Version, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 3 || fields[0] != "git" || fields[1] != "version" {
+		return Version{}, fmt.Errorf("unexpected output from git version: %q", output)
+	}
+	var numbers [3]int
+	for i, part := range strings.SplitN(fields[2], ".", 4) {
+		if i == len(numbers) {
+			break
+		}
+		// Release candidates are named like 2.40.0-rc1.
+		digits := strings.IndexFunc(part, func(r rune) bool { return r < '0' || r > '9' })
+		if digits == -1 {
+			digits = len(part)
+		}
+		number, err := strconv.Atoi(part[:digits])
+		if err != nil {
+			return Version{}, fmt.Errorf("unexpected version in git version output: %q", output)
+		}
+		numbers[i] = number
+	}
+	return Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, nil
+}
+
+// AtLeast reports if v is other or a later release.
+func (v Version) AtLeast(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major > other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor > other.Minor
+	}
+	return v.Patch >= other.Patch
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+var (
+	versionsMu sync.Mutex
+	// versions holds the version of every git executable already run, so it is only asked once.
+	versions = map[string]Version{}
+)
+
+// detectVersion finds out which version of git executable is, and fails if it is older than MinimumVersion.
+func detectVersion(executable string, env []string) (Version, error) {
+	versionsMu.Lock()
+	defer versionsMu.Unlock()
+	if version, ok := versions[executable]; ok {
+		return version, nil
+	}
+
+	cmd := exec.Command(executable, "version")
+	cmd.Env = env
+	output, err := cmd.Output()
+	if err != nil {
+		return Version{}, fmt.Errorf("'%s' failed: %v", cmd.String(), err)
+	}
+	version, err := ParseVersion(string(output))
+	if err != nil {
+		return Version{}, err
+	}
+	if !version.AtLeast(MinimumVersion) {
+		return Version{}, fmt.Errorf("%w: %s is git %s, but git %s or newer is needed", ErrUnsupportedVersion,
+			executable, version, MinimumVersion)
+	}
+	versions[executable] = version
+	return version, nil
+}
+
+// revisions adds revisions to the end of args, which must be the last of the options. Revisions that look like
+// options are refused by versions of git that can't be told where options end.
+func (c *Command) revisions(args []string, revisions ...string) ([]string, error) {
+	if c.version.AtLeast(endOfOptionsVersion) {
+		return append(append(args, "--end-of-options"), revisions...), nil
+	}
+	for _, revision := range revisions {
+		if strings.HasPrefix(revision, "-") {
+			return nil, fmt.Errorf("git %s can't be given the revision '%s', which looks like an option", c.version, revision)
+		}
+	}
+	return append(args, revisions...), nil
+}
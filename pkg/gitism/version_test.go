@@ -0,0 +1,82 @@
+package gitism
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	for output, want := range map[string]Version{
+		"git version 2.39.5\n":                 {2, 39, 5},
+		"git version 2.37.1 (Apple Git-137.1)": {2, 37, 1},
+		"git version 2.41.0.windows.1":         {2, 41, 0},
+		"git version 2.40.0-rc1":               {2, 40, 0},
+		"git version 1.8":                      {1, 8, 0},
+	} {
+		got, err := ParseVersion(output)
+		if err != nil {
+			t.Fatalf("could not parse '%s': %v", output, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("'%s': %s", output, diff)
+		}
+	}
+
+	for _, invalid := range []string{"", "hub version 2.14.2", "git version two"} {
+		if _, err := ParseVersion(invalid); err == nil {
+			t.Fatalf("parsed invalid output '%s'", invalid)
+		}
+	}
+
+	if !(Version{2, 24, 0}).AtLeast(Version{2, 13, 0}) || (Version{1, 99, 0}).AtLeast(Version{2, 0, 0}) {
+		t.Fatal("versions compared wrong")
+	}
+}
+
+// fakeVersionGit writes a git that claims to be version and records the arguments it is run with in the returned log.
+func fakeVersionGit(t *testing.T, version string) (executable string, log string) {
+	directory := t.TempDir()
+	executable = filepath.Join(directory, "git")
+	log = filepath.Join(directory, "log")
+	script := "#!/bin/sh\n" +
+		"if [ \"$1\" = version ]; then echo 'git version " + version + "'; exit 0; fi\n" +
+		"echo \"$@\" >> '" + log + "'\n" +
+		"echo 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n"
+	if err := ioutil.WriteFile(executable, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return executable, log
+}
+
+func TestVersionNegotiation(t *testing.T) {
+	executable, _ := fakeVersionGit(t, "2.11.0")
+	if _, err := NewCommandWithOptions(t.TempDir(), CommandOptions{Executable: executable}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("git 2.11.0 was not refused: %v", err)
+	}
+
+	executable, log := fakeVersionGit(t, "2.20.1")
+	cli, err := NewCommandWithOptions(t.TempDir(), CommandOptions{Executable: executable})
+	if err != nil {
+		t.Fatalf("git 2.20.1 was refused: %v", err)
+	}
+	if diff := cmp.Diff(Version{2, 20, 1}, cli.Version()); diff != "" {
+		t.Fatal(diff)
+	}
+	if _, err := cli.RevParse("main"); err != nil {
+		t.Fatalf("could not rev-parse: %v", err)
+	}
+	if _, err := cli.RevParse("--all"); err == nil {
+		t.Fatal("revision that looks like an option was passed to git without --end-of-options")
+	}
+	output, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(output), "--end-of-options") || strings.Contains(string(output), "--all") {
+		t.Fatalf("git 2.20.1 was given options it doesn't understand: %s", output)
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GitObjectsDirectory is where NewGitObjectsFileSystem exposes the repository's internals.
+const GitObjectsDirectory = ".gitobjects"
+
+// gitObjectsAllowList are the entries of the git directory that are exposed. This is just enough for git to accept the
+// directory as a GIT_DIR and keeps things like config, which may contain credentials, private.
+var gitObjectsAllowList = map[string]bool{
+	"HEAD":        true,
+	"objects":     true,
+	"packed-refs": true,
+	"refs":        true,
+	"shallow":     true,
+}
+
+type gitObjectsInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i gitObjectsInfo) Name() string {
+	return i.name
+}
+
+func (i gitObjectsInfo) Mode() fs.FileMode {
+	// Nothing in here is writable through the mount.
+	return i.FileInfo.Mode() &^ 0222
+}
+
+// gitObjectsAlternatesInfo is the info of an alternates file whose contents were rewritten.
+type gitObjectsAlternatesInfo struct {
+	gitObjectsInfo
+	size int64
+}
+
+func (i gitObjectsAlternatesInfo) Size() int64 {
+	return i.size
+}
+
+type gitObjectsFile struct {
+	*os.File
+	name string
+}
+
+func (f gitObjectsFile) Name() string {
+	return f.name
+}
+
+func (f gitObjectsFile) Write(p []byte) (n int, err error) {
+	_ = p
+	return 0, billy.ErrNotSupported
+}
+
+func (f gitObjectsFile) Lock() error {
+	return billy.ErrNotSupported
+}
+
+func (f gitObjectsFile) Unlock() error {
+	return billy.ErrNotSupported
+}
+
+func (f gitObjectsFile) Truncate(size int64) error {
+	_ = size
+	return billy.ErrNotSupported
+}
+
+// gitObjectsFileSystem overlays a read-only view of a git directory at /.gitobjects/ so tools that want to run real
+// git commands against the mounted tree can point GIT_DIR at it.
+type gitObjectsFileSystem struct {
+	billy.Filesystem
+	gitDirectory string
+}
+
+// NewGitObjectsFileSystem exposes the refs and objects of the repository at gitDirectory under /.gitobjects/ of fs.
+func NewGitObjectsFileSystem(fs billy.Filesystem, gitDirectory string) billy.Filesystem {
+	return gitObjectsFileSystem{
+		Filesystem:   fs,
+		gitDirectory: gitDirectory,
+	}
+}
+
+// hostPath maps filename to a path in the git directory. ok is false for paths outside of /.gitobjects/.
+func (s gitObjectsFileSystem) hostPath(filename string) (path string, ok bool, err error) {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil {
+		return "", false, err
+	}
+	if len(resolved.Path) == 0 || resolved.Path[0] != GitObjectsDirectory {
+		return "", false, nil
+	}
+
+	rest := resolved.Path[1:]
+	if len(rest) > 0 && !gitObjectsAllowList[rest[0]] {
+		return "", true, fs.ErrNotExist
+	}
+	return filepath.Join(append([]string{s.gitDirectory}, rest...)...), true, nil
+}
+
+func (s gitObjectsFileSystem) lstat(filename, path string) (os.FileInfo, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	objectsInfo := gitObjectsInfo{FileInfo: info, name: filepath.Base(filename)}
+	if path == filepath.Join(s.gitDirectory, gitism.AlternatesFile) && info.Mode().IsRegular() {
+		contents, err := s.alternates(path)
+		if err != nil {
+			return nil, err
+		}
+		return gitObjectsAlternatesInfo{gitObjectsInfo: objectsInfo, size: int64(len(contents))}, nil
+	}
+	return objectsInfo, nil
+}
+
+// alternates reads the alternates file at path with every object directory made absolute. Relative directories are
+// relative to the objects directory on the host, which git would resolve inside of the mount instead and not find
+// the objects the repository borrows from other ones, like the repository a fork was cloned from.
+func (s gitObjectsFileSystem) alternates(path string) ([]byte, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	objectDirectory, err := filepath.Abs(filepath.Join(s.gitDirectory, "objects"))
+	if err != nil {
+		return nil, err
+	}
+	var rewritten []byte
+	for _, alternate := range gitism.ParseAlternates(objectDirectory, contents) {
+		rewritten = append(rewritten, alternate+"\n"...)
+	}
+	return rewritten, nil
+}
+
+func (s gitObjectsFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s gitObjectsFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	path, ok, err := s.hostPath(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+	log.Printf("gitobjects OpenFile(%s)\n", RedactPath(filename))
+
+	if flag != os.O_RDONLY {
+		return nil, billy.ErrReadOnly
+	}
+
+	// Don't let a symlink inside of the git directory lead anywhere else on the host.
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	gitDirectory, err := filepath.EvalSymlinks(s.gitDirectory)
+	if err != nil {
+		return nil, err
+	}
+	if real != gitDirectory && !strings.HasPrefix(real, gitDirectory+SeparatorString) {
+		return nil, ErrEscapesChroot
+	}
+
+	if real == filepath.Join(gitDirectory, gitism.AlternatesFile) {
+		contents, err := s.alternates(real)
+		if err != nil {
+			return nil, err
+		}
+		return newMemoryFile(filename, contents), nil
+	}
+
+	file, err := os.Open(real)
+	if err != nil {
+		return nil, err
+	}
+	return gitObjectsFile{File: file, name: filename}, nil
+}
+
+func (s gitObjectsFileSystem) Stat(filename string) (os.FileInfo, error) {
+	path, ok, err := s.hostPath(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.Filesystem.Stat(filename)
+	}
+	return s.lstat(filename, path)
+}
+
+func (s gitObjectsFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	path, ok, err := s.hostPath(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.Filesystem.Lstat(filename)
+	}
+	return s.lstat(filename, path)
+}
+
+func (s gitObjectsFileSystem) ReadDir(filename string) ([]os.FileInfo, error) {
+	path, ok, err := s.hostPath(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		files, err := s.Filesystem.ReadDir(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		root := RootGitPath()
+		resolved, err := root.Resolve(filename)
+		if err != nil || !resolved.IsRoot() {
+			return files, err
+		}
+
+		info, err := s.lstat(GitObjectsDirectory, s.gitDirectory)
+		if err != nil {
+			return nil, err
+		}
+		return append(files, info), nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	isTop := filepath.Clean(path) == filepath.Clean(s.gitDirectory)
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if isTop && !gitObjectsAllowList[entry.Name()] {
+			continue
+		}
+		info, err := s.lstat(entry.Name(), filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+func (s gitObjectsFileSystem) Readlink(link string) (string, error) {
+	_, ok, err := s.hostPath(link)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return s.Filesystem.Readlink(link)
+	}
+	// Symlinks inside of the git directory are never followed.
+	return "", fs.ErrInvalid
+}
+
+func (s gitObjectsFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	_, ok, err := s.hostPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, billy.ErrNotSupported
+	}
+	return s.Filesystem.Chroot(path)
+}
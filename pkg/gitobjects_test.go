@@ -0,0 +1,96 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGitObjects(t *testing.T) {
+	gitDirectory, err := runPlaybook("base", t.TempDir())
+	if err != nil {
+		t.Fatalf("playbook 'base' failed: %v", err)
+	}
+	git, err := NewCliGit(gitDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewGitObjectsFileSystem(NewReferenceFileSystem(git, BranchRef("master")), gitDirectory)
+
+	t.Run("listing", func(t *testing.T) {
+		paths, err := fs.ReadDir(".")
+		if err != nil {
+			t.Fatalf("ReadDir(.) failed: %v", err)
+		}
+		pathsMap := fileMap(paths)
+		for _, name := range []string{GitObjectsDirectory, "real.txt"} {
+			if _, ok := pathsMap[name]; !ok {
+				t.Fatalf("root listing is missing %s: %v", name, paths)
+			}
+		}
+
+		paths, err = fs.ReadDir(GitObjectsDirectory)
+		if err != nil {
+			t.Fatalf("ReadDir(%s) failed: %v", GitObjectsDirectory, err)
+		}
+		pathsMap = fileMap(paths)
+		for _, name := range []string{"HEAD", "objects", "refs"} {
+			if _, ok := pathsMap[name]; !ok {
+				t.Fatalf("%s is missing %s: %v", GitObjectsDirectory, name, paths)
+			}
+		}
+		if _, ok := pathsMap["config"]; ok {
+			t.Fatalf("%s should not expose config", GitObjectsDirectory)
+		}
+	})
+
+	t.Run("reading", func(t *testing.T) {
+		file, err := fs.Open(".gitobjects/HEAD")
+		if err != nil {
+			t.Fatalf("Open(.gitobjects/HEAD) failed: %v", err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("reading .gitobjects/HEAD failed: %v", err)
+		}
+		if !strings.HasPrefix(string(contents), "ref: refs/heads/") {
+			t.Fatalf(".gitobjects/HEAD contained %q", contents)
+		}
+
+		info, err := fs.Stat(".gitobjects/refs/heads/master")
+		if err != nil {
+			t.Fatalf("Stat(.gitobjects/refs/heads/master) failed: %v", err)
+		}
+		if info.Mode()&0222 != 0 {
+			t.Fatalf(".gitobjects/refs/heads/master is writable: %s", info.Mode())
+		}
+	})
+
+	t.Run("restricted", func(t *testing.T) {
+		if _, err := fs.Open(".gitobjects/config"); err == nil {
+			t.Fatal("was able to open .gitobjects/config")
+		}
+		if _, err := fs.Open(".gitobjects/../../config"); err == nil {
+			t.Fatal("was able to escape the git directory")
+		}
+		if _, err := fs.OpenFile(".gitobjects/HEAD", os.O_RDWR, 0); err == nil {
+			t.Fatal("was able to open .gitobjects/HEAD for writing")
+		}
+	})
+}
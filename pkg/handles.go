@@ -0,0 +1,137 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// OpenHandle is a file read from git that is still open, along with the blob it holds in memory until it is closed.
+type OpenHandle struct {
+	Path string `json:"path"`
+	Ref  string `json:"ref"`
+	Blob string `json:"blob"`
+	// Bytes is the size of the blob's contents held by the handle. They are held on disk instead of in memory if
+	// Spilled is set because the memory budget was spent.
+	Bytes   int64     `json:"bytes"`
+	Spilled bool      `json:"spilled,omitempty"`
+	Opened  time.Time `json:"opened"`
+}
+
+// openHandles tracks every file opened from the ReferenceFileSystems of one Git. Only what describes a handle is
+// kept, not its contents, so a handle that is never closed isn't kept alive by being listed.
+type openHandles struct {
+	mu      sync.Mutex
+	next    uint64
+	handles map[uint64]OpenHandle
+}
+
+func newOpenHandles() *openHandles {
+	return &openHandles{handles: map[uint64]OpenHandle{}}
+}
+
+// handlesOf is where the files read from git are tracked. Each repository tracks its own, so one mount never lists the
+// files of another. Implementations of Git outside of this package don't track theirs.
+func handlesOf(git Git) *openHandles {
+	if git, ok := git.(interface{ openHandles() *openHandles }); ok {
+		return git.openHandles()
+	}
+	return newOpenHandles()
+}
+
+func (g cliGit) openHandles() *openHandles {
+	return g.handles
+}
+
+func (g embeddedGit) openHandles() *openHandles {
+	return g.handles
+}
+
+func (g *FakeGit) openHandles() *openHandles {
+	return g.handles
+}
+
+func (g faultyGit) openHandles() *openHandles {
+	return handlesOf(g.git)
+}
+
+// open records handle and returns the id to close it with.
+func (h *openHandles) open(handle OpenHandle) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.next++
+	h.handles[h.next] = handle
+	return h.next
+}
+
+// close forgets the handle opened as id. Closing it again does nothing.
+func (h *openHandles) close(id uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.handles, id)
+}
+
+// OpenHandles lists every file read from git that hasn't been closed yet, oldest first. Handles from every filesystem
+// serving git are listed together since they share its memory budget.
+func OpenHandles(git Git) []OpenHandle {
+	handles := handlesOf(git)
+	handles.mu.Lock()
+	ids := make([]uint64, 0, len(handles.handles))
+	for id := range handles.handles {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	open := make([]OpenHandle, len(ids))
+	for i, id := range ids {
+		open[i] = handles.handles[id]
+	}
+	handles.mu.Unlock()
+	return open
+}
+
+// HandleReport is the contents of .gitfs/handles.
+type HandleReport struct {
+	Handles []OpenHandle `json:"handles"`
+	// Blobs and Bytes count each distinct blob held in memory once, since handles of the same blob may share its
+	// contents.
+	Blobs int   `json:"blobs"`
+	Bytes int64 `json:"bytes"`
+	// Spilled counts the handles reading their blob from disk.
+	Spilled int `json:"spilled"`
+}
+
+// NewHandleReport summarizes handles by how much memory they pin.
+func NewHandleReport(handles []OpenHandle) HandleReport {
+	report := HandleReport{Handles: handles}
+	if report.Handles == nil {
+		report.Handles = []OpenHandle{}
+	}
+	blobs := map[string]bool{}
+	for _, handle := range handles {
+		if handle.Spilled {
+			report.Spilled++
+			continue
+		}
+		if blobs[handle.Blob] {
+			continue
+		}
+		blobs[handle.Blob] = true
+		report.Blobs++
+		report.Bytes += handle.Bytes
+	}
+	return report
+}
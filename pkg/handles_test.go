@@ -0,0 +1,59 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import "testing"
+
+func TestOpenHandlesPerRepository(t *testing.T) {
+	first, second := NewFakeGit(nil), NewFakeGit(nil)
+	for _, git := range []*FakeGit{first, second} {
+		git.Commit("master", "Initial commit", map[string]FakeFile{
+			"a.txt": {Contents: "a"},
+		})
+	}
+
+	file, err := NewReferenceFileSystem(first, BranchRef("master")).Open("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open := OpenHandles(first); len(open) != 1 || open[0].Path != "a.txt" {
+		t.Fatalf("OpenHandles(first) = %+v, expected a.txt", open)
+	}
+	if open := OpenHandles(second); len(open) != 0 {
+		t.Fatalf("OpenHandles(second) = %+v, expected nothing opened from another repository", open)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if open := OpenHandles(first); len(open) != 0 {
+		t.Fatalf("OpenHandles(first) = %+v after closing a.txt", open)
+	}
+}
+
+func TestNewHandleReport(t *testing.T) {
+	report := NewHandleReport([]OpenHandle{
+		{Path: "a.txt", Blob: "aaaa", Bytes: 10},
+		{Path: "copy-of-a.txt", Blob: "aaaa", Bytes: 10},
+		{Path: "b.txt", Blob: "bbbb", Bytes: 5},
+	})
+	if len(report.Handles) != 3 || report.Blobs != 2 || report.Bytes != 15 {
+		t.Fatalf("NewHandleReport() = %+v, expected 3 handles pinning 15 bytes of 2 blobs", report)
+	}
+
+	if empty := NewHandleReport(nil); empty.Handles == nil {
+		t.Fatal("NewHandleReport(nil) should list no handles rather than null")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// hiddenFileSystem removes every path matching one of patterns, and everything inside of those, as if they were never
+// committed.
+type hiddenFileSystem struct {
+	billy.Filesystem
+	patterns []string
+	// root is where the filesystem was chrooted to so patterns keep matching whole paths.
+	root FilePath
+}
+
+// hidden reports whether filename or one of the directories it is in matches a pattern. Paths that can't be resolved
+// are left for the underlying filesystem to reject.
+func (s hiddenFileSystem) hidden(filename string) bool {
+	resolved, err := s.root.Resolve(filename)
+	if err != nil {
+		return false
+	}
+	for i := 1; i <= len(resolved.Path); i++ {
+		if MatchesAny(s.patterns, strings.Join(resolved.Path[:i], SeparatorString)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s hiddenFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s hiddenFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if s.hidden(filename) {
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: fs.ErrNotExist}
+	}
+	return s.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (s hiddenFileSystem) Stat(filename string) (os.FileInfo, error) {
+	if s.hidden(filename) {
+		return nil, &fs.PathError{Op: "stat", Path: filename, Err: fs.ErrNotExist}
+	}
+	return s.Filesystem.Stat(filename)
+}
+
+func (s hiddenFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	if s.hidden(filename) {
+		return nil, &fs.PathError{Op: "lstat", Path: filename, Err: fs.ErrNotExist}
+	}
+	return s.Filesystem.Lstat(filename)
+}
+
+func (s hiddenFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	if s.hidden(path) {
+		return nil, &fs.PathError{Op: "readdir", Path: path, Err: fs.ErrNotExist}
+	}
+	files, err := s.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := make([]os.FileInfo, 0, len(files))
+	for _, file := range files {
+		if !s.hidden(s.Join(path, file.Name())) {
+			listing = append(listing, file)
+		}
+	}
+	return listing, nil
+}
+
+func (s hiddenFileSystem) Readlink(link string) (string, error) {
+	if s.hidden(link) {
+		return "", &fs.PathError{Op: "readlink", Path: link, Err: fs.ErrNotExist}
+	}
+	return s.Filesystem.Readlink(link)
+}
+
+// Chroot keeps hiding the same paths in the new root.
+func (s hiddenFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	if s.hidden(path) {
+		return nil, &fs.PathError{Op: "chroot", Path: path, Err: fs.ErrNotExist}
+	}
+	root, err := s.root.Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	chrooted, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return hiddenFileSystem{Filesystem: chrooted, patterns: s.patterns, root: root}, nil
+}
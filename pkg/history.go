@@ -0,0 +1,429 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// HistoryDirectory is where NewHistoryFileSystem serves the versions of every file. It shadows any directory of
+	// the same name in the repository.
+	HistoryDirectory = "history-of"
+	// historyTTL is how long the versions of a file are reused for so listing them and reading each doesn't run git
+	// every time.
+	historyTTL = time.Second
+	// maxCachedHistories bounds how many files' versions are remembered. The cache is dropped when it fills up.
+	maxCachedHistories = 1024
+)
+
+// followFileHistory is Git.FileHistory for backends without git log --follow. It walks every commit reachable from
+// ref, newest first, and follows path back across the renames ListChanges detects. Like git log, merges are left out.
+func followFileHistory(git Git, ref Ref, path string, handler func(commit string, change gitism.Change) error) error {
+	path = strings.Trim(path, SeparatorString)
+	return git.WalkCommits(ref, false, func(commit gitism.GraphCommit) error {
+		if len(commit.Parents) > 1 {
+			return nil
+		}
+		var changes []gitism.Change
+		err := git.ListChanges(commit.Hash, func(change gitism.Change) error {
+			if change.Path == path {
+				changes = append(changes, change)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			if err := handler(commit.Hash, change); err != nil {
+				return err
+			}
+			if change.Type == gitism.ChangeRename {
+				path = change.PreviousPath
+			}
+		}
+		return nil
+	})
+}
+
+// fileVersion is a version of a file in its history.
+type fileVersion struct {
+	// name is <n>-<short hash>, counting versions from the oldest at 1.
+	name   string
+	commit string
+	change gitism.Change
+}
+
+// cachedHistory is the versions of a file and when they were listed.
+type cachedHistory struct {
+	versions []fileVersion
+	listed   time.Time
+}
+
+// historyFileSystem adds a directory at /history-of/ mirroring the tree, where every file is a directory of its
+// versions.
+type historyFileSystem struct {
+	billy.Filesystem
+	git       Git
+	reference Ref
+
+	mu        sync.Mutex
+	histories map[string]cachedHistory
+}
+
+// NewHistoryFileSystem serves every version of each file of fs at /history-of/<path>/<n>-<short hash>, read from the
+// commits reachable from reference that changed it, so the evolution of a file can be diffed without running git.
+// Versions are numbered from the oldest at 1 and named after the commit that made them, and files are followed
+// back across renames like git log --follow. Directories under /history-of/ mirror those of fs, and files that were
+// deleted can still be reached by their path.
+func NewHistoryFileSystem(fs billy.Filesystem, git Git, reference Ref) billy.Filesystem {
+	return &historyFileSystem{Filesystem: fs, git: git, reference: reference, histories: map[string]cachedHistory{}}
+}
+
+// history returns the versions of the file at path, oldest first, listing them again if they are older than
+// historyTTL. Deletions and submodules have no contents to serve, so they aren't versions.
+func (s *historyFileSystem) history(path string) ([]fileVersion, error) {
+	s.mu.Lock()
+	cached, ok := s.histories[path]
+	s.mu.Unlock()
+	if ok && time.Since(cached.listed) < historyTTL {
+		return cached.versions, nil
+	}
+
+	var versions []fileVersion
+	// git follows directories too, listing the files under them, which aren't versions of path.
+	followed := path
+	err := s.git.FileHistory(s.reference, path, func(commit string, change gitism.Change) error {
+		if change.Path != followed {
+			return nil
+		}
+		if change.Type == gitism.ChangeRename {
+			followed = change.PreviousPath
+		}
+		if change.Type == gitism.ChangeDeletion || change.Mode.Type == gitism.Gitlink {
+			return nil
+		}
+		versions = append(versions, fileVersion{commit: commit, change: change})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// git lists the newest first.
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+	for i := range versions {
+		versions[i].name = fmt.Sprintf("%d-%s", i+1, shortHash(versions[i].commit))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.histories) >= maxCachedHistories {
+		s.histories = map[string]cachedHistory{}
+	}
+	s.histories[path] = cachedHistory{versions: versions, listed: time.Now()}
+	return versions, nil
+}
+
+// shortHash abbreviates a commit hash the way git does by default.
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
+// historyPath is where a path within /history-of/ leads.
+type historyPath struct {
+	// path is the path in the tree that is mirrored.
+	path string
+	// versions is set when path is a file, which is a directory of its versions.
+	versions []fileVersion
+	// version is set when the path is one of the versions.
+	version *fileVersion
+}
+
+// isFile reports whether the path leads to the versions of a file or one of them rather than a directory.
+func (p historyPath) isFile() bool {
+	return p.versions != nil
+}
+
+// lookup maps filename to a path within /history-of/. ok is false for paths outside of it. The path is a directory
+// as long as fs has a directory there, and a file with versions once it doesn't. Paths fs doesn't have were deleted,
+// and are taken to be directories as long as they can't be a deleted file or one of its versions.
+func (s *historyFileSystem) lookup(filename string) (history historyPath, ok bool, err error) {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil {
+		return historyPath{}, false, err
+	}
+	if len(resolved.Path) == 0 || resolved.Path[0] != HistoryDirectory {
+		return historyPath{}, false, nil
+	}
+
+	elements := resolved.Path[1:]
+	for i := range elements {
+		path := strings.Join(elements[:i+1], SeparatorString)
+		info, err := s.Filesystem.Lstat(path)
+		if err == nil && info.IsDir() {
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return historyPath{}, true, err
+		}
+		deleted := err != nil
+
+		// Only directories have more than a version under them.
+		rest := elements[i+1:]
+		if len(rest) > 1 {
+			if deleted {
+				continue
+			}
+			return historyPath{}, true, fs.ErrNotExist
+		}
+		versions, err := s.history(path)
+		if err != nil {
+			return historyPath{}, true, err
+		}
+		history = historyPath{path: path, versions: versions}
+		if len(versions) > 0 && len(rest) == 0 {
+			return history, true, nil
+		}
+		for i := range versions {
+			if len(rest) > 0 && versions[i].name == rest[0] {
+				history.version = &versions[i]
+				return history, true, nil
+			}
+		}
+		if deleted && len(rest) > 0 {
+			continue
+		}
+		return historyPath{}, true, fs.ErrNotExist
+	}
+	return historyPath{path: strings.Join(elements, SeparatorString)}, true, nil
+}
+
+// directoryInfo describes the directory mirroring path, or the versions of the file at path.
+func (s *historyFileSystem) directoryInfo(path string) os.FileInfo {
+	name := HistoryDirectory
+	if path != "" {
+		name = path[strings.LastIndex(path, SeparatorString)+1:]
+	}
+	return introspectionInfo{name: name, mode: os.ModeDir | 0555}
+}
+
+// versionInfo describes version as a read-only file holding its contents.
+func (s *historyFileSystem) versionInfo(version fileVersion) (gitFileInfo, error) {
+	size, err := s.git.BlobSize(version.change.Hash)
+	if err != nil {
+		return gitFileInfo{}, err
+	}
+	return gitFileInfo{
+		mode:    0444,
+		gitMode: version.change.Mode,
+		Type:    gitism.BlobObject,
+		Hash:    version.change.Hash,
+		path:    version.name,
+		size:    size,
+	}, nil
+}
+
+func (s *historyFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s *historyFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	history, ok, err := s.lookup(filename)
+	if !ok {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: err}
+	}
+	if flag&writeFlags != 0 {
+		return nil, billy.ErrReadOnly
+	}
+	if history.version == nil {
+		return nil, ErrIsDirectory
+	}
+	info, err := s.versionInfo(*history.version)
+	if err != nil {
+		return nil, err
+	}
+	tree := NewReferenceFileSystem(s.git, CommitRef(history.version.commit)).(ReferenceFileSystem)
+	return tree.openFile(filename, info)
+}
+
+func (s *historyFileSystem) Stat(filename string) (os.FileInfo, error) {
+	history, ok, err := s.lookup(filename)
+	if !ok {
+		return s.Filesystem.Stat(filename)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: filename, Err: err}
+	}
+	if history.version != nil {
+		return s.versionInfo(*history.version)
+	}
+	return s.directoryInfo(history.path), nil
+}
+
+func (s *historyFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	history, ok, err := s.lookup(filename)
+	if !ok {
+		return s.Filesystem.Lstat(filename)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+	if history.version != nil {
+		return s.versionInfo(*history.version)
+	}
+	return s.directoryInfo(history.path), nil
+}
+
+func (s *historyFileSystem) ReadDir(filename string) ([]os.FileInfo, error) {
+	history, ok, err := s.lookup(filename)
+	if !ok {
+		files, err := s.Filesystem.ReadDir(filename)
+		if err != nil {
+			return nil, err
+		}
+		root := RootGitPath()
+		if resolved, err := root.Resolve(filename); err != nil || !resolved.IsRoot() {
+			return files, nil
+		}
+		// The history shadows anything at /history-of/ in the repository.
+		return append(withoutHistoryDirectory(files), s.directoryInfo("")), nil
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+	if history.version != nil {
+		return nil, ErrNotDirectory
+	}
+
+	if history.isFile() {
+		files := make([]os.FileInfo, 0, len(history.versions))
+		for _, version := range history.versions {
+			info, err := s.versionInfo(version)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, info)
+		}
+		return files, nil
+	}
+
+	mirrored, err := s.Filesystem.ReadDir(history.path)
+	if err != nil {
+		return nil, err
+	}
+	if history.path == "" {
+		mirrored = withoutHistoryDirectory(mirrored)
+	}
+	// Every file is a directory of its versions.
+	files := make([]os.FileInfo, 0, len(mirrored))
+	for _, file := range mirrored {
+		files = append(files, introspectionInfo{name: file.Name(), mode: os.ModeDir | 0555})
+	}
+	return files, nil
+}
+
+// withoutHistoryDirectory leaves HistoryDirectory out of a listing of the root.
+func withoutHistoryDirectory(files []os.FileInfo) []os.FileInfo {
+	listing := make([]os.FileInfo, 0, len(files)+1)
+	for _, file := range files {
+		if file.Name() != HistoryDirectory {
+			listing = append(listing, file)
+		}
+	}
+	return listing
+}
+
+func (s *historyFileSystem) Readlink(link string) (string, error) {
+	_, ok, err := s.lookup(link)
+	if !ok {
+		return s.Filesystem.Readlink(link)
+	}
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: link, Err: err}
+	}
+	return "", &fs.PathError{Op: "readlink", Path: link, Err: fs.ErrInvalid}
+}
+
+func (s *historyFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	if s.historical(path) {
+		return nil, billy.ErrNotSupported
+	}
+	return s.Filesystem.Chroot(path)
+}
+
+// The history is read-only.
+
+func (s *historyFileSystem) Create(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (s *historyFileSystem) Rename(oldpath, newpath string) error {
+	if s.historical(oldpath) || s.historical(newpath) {
+		return billy.ErrReadOnly
+	}
+	return s.Filesystem.Rename(oldpath, newpath)
+}
+
+func (s *historyFileSystem) Remove(filename string) error {
+	if s.historical(filename) {
+		return billy.ErrReadOnly
+	}
+	return s.Filesystem.Remove(filename)
+}
+
+func (s *historyFileSystem) TempFile(dir, prefix string) (billy.File, error) {
+	if s.historical(dir) {
+		return nil, billy.ErrReadOnly
+	}
+	return s.Filesystem.TempFile(dir, prefix)
+}
+
+func (s *historyFileSystem) MkdirAll(filename string, perm os.FileMode) error {
+	if s.historical(filename) {
+		return billy.ErrReadOnly
+	}
+	return s.Filesystem.MkdirAll(filename, perm)
+}
+
+func (s *historyFileSystem) Symlink(target, link string) error {
+	if s.historical(link) {
+		return billy.ErrReadOnly
+	}
+	return s.Filesystem.Symlink(target, link)
+}
+
+// historical reports whether filename is within /history-of/.
+func (s *historyFileSystem) historical(filename string) bool {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	return err == nil && len(resolved.Path) > 0 && resolved.Path[0] == HistoryDirectory
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestHistoryFileSystem(t *testing.T) {
+	git := NewFakeGit(nil)
+	first := git.Commit("master", "Add a", map[string]FakeFile{"dir/a.txt": {Contents: "one"}})
+	git.Commit("master", "Add b", map[string]FakeFile{"b.txt": {Contents: "b"}})
+	third := git.Commit("master", "Change a", map[string]FakeFile{"dir/a.txt": {Contents: "two"}})
+	filesystem := NewHistoryFileSystem(NewReferenceFileSystem(git, BranchRef("master")), git, BranchRef("master"))
+
+	names := func(t *testing.T, filename string) []string {
+		paths, err := filesystem.ReadDir(filename)
+		if err != nil {
+			t.Fatalf("ReadDir(%s) failed: %v", filename, err)
+		}
+		var names []string
+		for _, path := range paths {
+			names = append(names, path.Name())
+		}
+		return names
+	}
+	read := func(t *testing.T, filename string) string {
+		file, err := filesystem.Open(filename)
+		if err != nil {
+			t.Fatalf("Open(%s) failed: %v", filename, err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", filename, err)
+		}
+		return string(contents)
+	}
+	firstVersion, secondVersion := "1-"+first[:7], "2-"+third[:7]
+
+	t.Run("listing", func(t *testing.T) {
+		if diff := cmp.Diff([]string{"b.txt", "dir", HistoryDirectory}, names(t, "/")); diff != "" {
+			t.Fatal(diff)
+		}
+		if diff := cmp.Diff([]string{"b.txt", "dir"}, names(t, HistoryDirectory)); diff != "" {
+			t.Fatal(diff)
+		}
+		if diff := cmp.Diff([]string{"a.txt"}, names(t, HistoryDirectory+"/dir")); diff != "" {
+			t.Fatal(diff)
+		}
+		if diff := cmp.Diff([]string{firstVersion, secondVersion}, names(t, HistoryDirectory+"/dir/a.txt")); diff != "" {
+			t.Fatal(diff)
+		}
+		info, err := filesystem.Stat(HistoryDirectory + "/dir/a.txt")
+		if err != nil || !info.IsDir() || info.Name() != "a.txt" {
+			t.Fatalf("Stat(%s/dir/a.txt) returned %v, %v", HistoryDirectory, info, err)
+		}
+	})
+
+	t.Run("versions", func(t *testing.T) {
+		if got := read(t, HistoryDirectory+"/dir/a.txt/"+firstVersion); got != "one" {
+			t.Fatalf("the first version of dir/a.txt was %q", got)
+		}
+		if got := read(t, HistoryDirectory+"/dir/a.txt/"+secondVersion); got != "two" {
+			t.Fatalf("the second version of dir/a.txt was %q", got)
+		}
+		info, err := filesystem.Stat(HistoryDirectory + "/dir/a.txt/" + secondVersion)
+		if err != nil || info.Size() != 3 || !info.Mode().IsRegular() {
+			t.Fatalf("Stat() of the second version of dir/a.txt returned %v, %v", info, err)
+		}
+		if hash, ok := ObjectHash(info); !ok || hash == "" {
+			t.Fatalf("the second version of dir/a.txt has no object hash")
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		for _, filename := range []string{HistoryDirectory + "/missing.txt", HistoryDirectory + "/dir/a.txt/3-0000000", HistoryDirectory + "/dir/a.txt/" + firstVersion + "/more"} {
+			if _, err := filesystem.Stat(filename); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("Stat(%s) returned %v, expected it not to exist", filename, err)
+			}
+		}
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		if _, err := filesystem.OpenFile(HistoryDirectory+"/dir/a.txt/"+firstVersion, os.O_RDWR, 0); err != billy.ErrReadOnly {
+			t.Fatalf("opening a version for writing returned %v", err)
+		}
+		if _, err := filesystem.Open(HistoryDirectory + "/dir/a.txt"); !errors.Is(err, ErrIsDirectory) {
+			t.Fatalf("opening the versions of a file returned %v", err)
+		}
+	})
+}
+
+func TestHistoryFollowsRenames(t *testing.T) {
+	dir := t.TempDir()
+	gitDirectory, err := runPlaybook("rename", dir)
+	if err != nil {
+		t.Fatalf("playbook 'rename' failed: %v", err)
+	}
+	remove := exec.Command("sh", "-c", "git rm --quiet renamed/moved.txt && git commit --quiet -m 'Delete the renamed file'")
+	remove.Dir = dir
+	if output, err := remove.CombinedOutput(); err != nil {
+		t.Fatalf("deleting renamed/moved.txt failed: %v: %s", err, output)
+	}
+	git, err := NewCliGit(gitDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filesystem := NewHistoryFileSystem(NewReferenceFileSystem(git, BranchRef("master")), git, BranchRef("master"))
+
+	versions, err := filesystem.ReadDir(HistoryDirectory + "/renamed/moved.txt")
+	if err != nil {
+		t.Fatalf("ReadDir() of the versions of renamed/moved.txt failed: %v", err)
+	}
+	if len(versions) != 2 || versions[0].Name()[:2] != "1-" || versions[1].Name()[:2] != "2-" {
+		t.Fatalf("the deleted renamed/moved.txt has versions %v, expected the one from before it was renamed too", versions)
+	}
+	file, err := filesystem.Open(HistoryDirectory + "/renamed/moved.txt/" + versions[0].Name())
+	if err != nil {
+		t.Fatalf("Open() of the version from before the rename failed: %v", err)
+	}
+	defer file.Close()
+	contents, err := io.ReadAll(file)
+	if err != nil || string(contents) != "This file will be renamed without any changes to its contents.\n" {
+		t.Fatalf("the version from before the rename was %q, %v", contents, err)
+	}
+}
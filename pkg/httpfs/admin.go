@@ -0,0 +1,250 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpfs
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// CacheAdminPath is where the frontends serve NewCacheAdminHandler on their --admin-listen address.
+const CacheAdminPath = "/admin/cache/"
+
+// RepositoriesAdminPath is where gitfs serves NewRepositoriesAdminHandler on its --admin-listen address.
+const RepositoriesAdminPath = "/admin/repos/"
+
+// RepositoriesAdmin changes and maintains the repositories a mount of a directory of repositories serves, like
+// mount.Mounted.
+type RepositoriesAdmin interface {
+	Repositories() []gitfs.RepositoryStatus
+	AddRepository(name, gitDir string) error
+	RemoveRepository(name string) error
+	MaintainRepository(name string, task gitism.MaintenanceTask) error
+}
+
+// NewCacheAdminHandler lets operators look into and flush cache under CacheAdminPath: GET stats returns its
+// gitfs.CacheStats as JSON and POST flush?scope=blobs|trees|all drops what it holds, all if scope is left out. A nil
+// cache answers that nothing is cached. Requests are checked like those of NewRepositoriesAdminHandler, so without
+// token nobody who can reach the address can flush the cache over and over.
+func NewCacheAdminHandler(cache *gitfs.ManagedCache, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(CacheAdminPath+"stats", func(w http.ResponseWriter, r *http.Request) {
+		if cache == nil {
+			http.Error(w, "no cache is configured", http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "stats must be read with GET", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(cache.Stats())
+	})
+	mux.HandleFunc(CacheAdminPath+"flush", func(w http.ResponseWriter, r *http.Request) {
+		if cache == nil {
+			http.Error(w, "no cache is configured", http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "flushing must be done with POST", http.StatusMethodNotAllowed)
+			return
+		}
+		name := r.URL.Query().Get("scope")
+		if name == "" {
+			name = "all"
+		}
+		scope, err := gitfs.ParseCacheScope(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := cache.FlushScope(scope); err != nil {
+			http.Error(w, fmt.Sprintf("failed to flush %s: %v", scope, err), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "flushed %s\n", scope)
+	})
+	return requireAdminToken(token, mux)
+}
+
+// ErrOutsideReposDir is returned when asked to serve a repository that isn't in the directory of repositories.
+var ErrOutsideReposDir = errors.New("repository is outside of the directory of repositories")
+
+// requireAdminToken only lets requests through to next that carry token as in Authorization: Bearer <token>. Requests
+// with an Origin header come from a browser, likely from a page that isn't the operator's, and are refused whatever
+// they carry. An empty token refuses every request.
+func requireAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			http.Error(w, "admin requests can't be made from a browser", http.StatusForbidden)
+			return
+		}
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "admin requests need the admin token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// repositoryIn resolves gitDir, which is relative to reposDir unless it is absolute, and checks that it is within
+// reposDir once symlinks are followed.
+func repositoryIn(reposDir, gitDir string) (string, error) {
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(reposDir, gitDir)
+	}
+	root, err := filepath.EvalSymlinks(reposDir)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(gitDir)
+	if err != nil {
+		return "", err
+	}
+	relative, err := filepath.Rel(root, resolved)
+	if err != nil || relative == "." || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideReposDir, gitDir)
+	}
+	return gitDir, nil
+}
+
+// NewRepositoriesAdminHandler lets operators change the repositories admin serves under RepositoriesAdminPath: GET
+// list returns the gitfs.RepositoryStatus of each as JSON, POST add?git-dir=DIR&name=NAME serves another, named like
+// gitfs.RepositoryName if name is left out, and POST remove?name=NAME stops serving one. POST
+// maintain?name=NAME&task=gc|incremental compacts one while it keeps being served, or every repository that is served
+// one after another if name is left out, and answers once it is done. It is meant to be run from cron during
+// off-hours, and the task is gc if it is left out.
+//
+// Only repositories within reposDir can be added, and DIR is taken relative to it. Every request must carry token as
+// in Authorization: Bearer <token>, and requests from browsers are refused, so a page the operator visits can't make
+// them.
+func NewRepositoriesAdminHandler(admin RepositoriesAdmin, reposDir, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(RepositoriesAdminPath+"list", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "repositories must be listed with GET", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(admin.Repositories())
+	})
+	mux.HandleFunc(RepositoriesAdminPath+"add", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "adding must be done with POST", http.StatusMethodNotAllowed)
+			return
+		}
+		gitDir := r.URL.Query().Get("git-dir")
+		if gitDir == "" {
+			http.Error(w, "git-dir must name the repository to add", http.StatusBadRequest)
+			return
+		}
+		gitDir, err := repositoryIn(reposDir, gitDir)
+		if errors.Is(err, ErrOutsideReposDir) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("failed to find repository: %v", err), http.StatusBadRequest)
+			return
+		}
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			name = gitfs.RepositoryName(gitDir)
+		}
+		if err := admin.AddRepository(name, gitDir); errors.Is(err, gitfs.ErrInvalidRepositoryName) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("failed to add %s: %v", name, err), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "added %s\n", name)
+	})
+	mux.HandleFunc(RepositoriesAdminPath+"remove", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "removing must be done with POST", http.StatusMethodNotAllowed)
+			return
+		}
+		name := r.URL.Query().Get("name")
+		if err := admin.RemoveRepository(name); errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, fmt.Sprintf("%s is not served", name), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("failed to remove %s: %v", name, err), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "removed %s\n", name)
+	})
+	mux.HandleFunc(RepositoriesAdminPath+"maintain", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "maintenance must be started with POST", http.StatusMethodNotAllowed)
+			return
+		}
+		taskName := r.URL.Query().Get("task")
+		if taskName == "" {
+			taskName = string(gitism.MaintenanceGC)
+		}
+		task, err := gitism.ParseMaintenanceTask(taskName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if name := r.URL.Query().Get("name"); name != "" {
+			if err := admin.MaintainRepository(name, task); errors.Is(err, fs.ErrNotExist) {
+				http.Error(w, fmt.Sprintf("%s is not served", name), http.StatusNotFound)
+				return
+			} else if errors.Is(err, gitfs.ErrMaintenanceUnsupported) {
+				http.Error(w, fmt.Sprintf("%s can't be maintained: %v", name, err), http.StatusNotImplemented)
+				return
+			} else if err != nil {
+				http.Error(w, fmt.Sprintf("failed to maintain %s: %v", name, err), http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprintf(w, "maintained %s with %s\n", name, task)
+			return
+		}
+
+		// One repository failing doesn't stop the others from being maintained.
+		var report strings.Builder
+		failed := false
+		for _, status := range admin.Repositories() {
+			if status.Error != "" {
+				continue
+			}
+			if err := admin.MaintainRepository(status.Name, task); err != nil {
+				failed = true
+				fmt.Fprintf(&report, "failed to maintain %s: %v\n", status.Name, err)
+				continue
+			}
+			fmt.Fprintf(&report, "maintained %s with %s\n", status.Name, task)
+		}
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		io.WriteString(w, report.String())
+	})
+	return requireAdminToken(token, mux)
+}
@@ -0,0 +1,175 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpfs serves a gitfs filesystem over HTTP so it can be browsed without mounting anything.
+package httpfs
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+)
+
+// maxSymlinkHops bounds how many symlinks are followed to serve a single request.
+const maxSymlinkHops = 8
+
+// fileSystem adapts a billy.Filesystem to http.FileSystem.
+type fileSystem struct {
+	fs billy.Filesystem
+}
+
+// NewHandler serves the files in fs. Directories are served as listings and symlinks are followed as long as they
+// stay inside of fs.
+func NewHandler(fs billy.Filesystem) http.Handler {
+	return NewHandlerWithTemplate(fs, nil)
+}
+
+// NewPerClientHandler serves every request with the handler newHandler builds for the IP address of the client, so
+// the filesystem behind it can be decorated per client, for example with gitfs.AccessLog.FileSystem.
+func NewPerClientHandler(newHandler func(client string) http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		newHandler(client).ServeHTTP(w, r)
+	})
+}
+
+// resolve follows symlinks in name until it reaches something that isn't one.
+func (s fileSystem) resolve(name string) (string, os.FileInfo, error) {
+	for hops := 0; ; hops++ {
+		info, err := s.fs.Lstat(name)
+		if err != nil {
+			return "", nil, err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return name, info, nil
+		}
+		if hops == maxSymlinkHops {
+			return "", nil, fs.ErrNotExist
+		}
+
+		target, err := s.fs.Readlink(name)
+		if err != nil {
+			return "", nil, err
+		}
+		if path.IsAbs(target) {
+			// The filesystem rewrites links that stay inside of it to be relative so this one points elsewhere.
+			return "", nil, fs.ErrNotExist
+		}
+		name = path.Join(path.Dir(name), target)
+	}
+}
+
+// cleanName turns a URL path into a path in the filesystem.
+func cleanName(name string) string {
+	name = path.Clean("/" + name)[1:]
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (s fileSystem) Open(name string) (http.File, error) {
+	name, info, err := s.resolve(cleanName(name))
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &directory{fs: s.fs, name: name, info: info}, nil
+	}
+
+	file, err := s.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return regularFile{File: file, info: info}, nil
+}
+
+type regularFile struct {
+	billy.File
+	info os.FileInfo
+}
+
+func (f regularFile) Readdir(count int) ([]fs.FileInfo, error) {
+	_ = count
+	return nil, errors.New("not a directory")
+}
+
+func (f regularFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// directory is an http.File for a directory. The listing is read the first time it is asked for.
+type directory struct {
+	fs      billy.Filesystem
+	name    string
+	info    os.FileInfo
+	entries []os.FileInfo
+	read    bool
+}
+
+func (d *directory) Close() error {
+	return nil
+}
+
+func (d *directory) Read(p []byte) (int, error) {
+	_ = p
+	return 0, errors.New("is a directory")
+}
+
+func (d *directory) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekStart {
+		d.entries = nil
+		d.read = false
+		return 0, nil
+	}
+	return 0, errors.New("is a directory")
+}
+
+func (d *directory) Readdir(count int) ([]fs.FileInfo, error) {
+	if !d.read {
+		entries, err := d.fs.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.read = true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+func (d *directory) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
@@ -0,0 +1,586 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpfs
+
+import (
+	"encoding/json"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runPlaybook builds a repository from one of the playbooks shared with pkg's tests and returns its git directory.
+func runPlaybook(t *testing.T, playbook string) string {
+	script, err := filepath.Abs(filepath.Join("..", "testdata", "playbooks", playbook+".sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cmd := exec.Command(script)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("playbook '%s' failed: %v", playbook, err)
+	}
+	return filepath.Join(dir, ".git")
+}
+
+func get(t *testing.T, url string) (int, string) {
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("reading %s failed: %v", url, err)
+	}
+	return response.StatusCode, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	fs := memfs.New()
+	if err := util.WriteFile(fs, "real.txt", []byte("Hello World\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteFile(fs, "test/nested.txt", []byte("Nested file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("../real.txt", "test/link.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/etc/passwd", "escaping.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(NewHandler(fs))
+	defer server.Close()
+
+	tests := map[string]struct {
+		status   int
+		contains string
+	}{
+		"/real.txt":      {http.StatusOK, "Hello World\n"},
+		"/test/":         {http.StatusOK, "nested.txt"},
+		"/test/link.txt": {http.StatusOK, "Hello World\n"},
+		"/escaping.txt":  {http.StatusNotFound, ""},
+		"/missing.txt":   {http.StatusNotFound, ""},
+	}
+	for path, test := range tests {
+		status, body := get(t, server.URL+path)
+		if status != test.status || !strings.Contains(body, test.contains) {
+			t.Fatalf("GET %s returned %d %q", path, status, body)
+		}
+	}
+
+	t.Run("content types", func(t *testing.T) {
+		if err := util.WriteFile(fs, "image", []byte("\x89PNG\r\n\x1a\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for path, expected := range map[string]string{"/image": "image/png", "/test/link.txt": "text/plain; charset=utf-8"} {
+			response, err := http.Get(server.URL + path)
+			if err != nil {
+				t.Fatalf("GET %s failed: %v", path, err)
+			}
+			response.Body.Close()
+			if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, strings.Split(expected, ";")[0]) {
+				t.Fatalf("GET %s was served as %s, expected %s", path, contentType, expected)
+			}
+		}
+	})
+}
+
+func TestSmartHTTP(t *testing.T) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skipf("git is not installed: %v", err)
+	}
+	gitDirectory := runPlaybook(t, "base")
+
+	mux := http.NewServeMux()
+	mux.Handle(SmartHTTPPath+"/", NewSmartHTTPHandler(git, gitDirectory, SmartHTTPPath))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	output, err := exec.Command(git, "clone", server.URL+SmartHTTPPath, clone).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git clone failed: %v\n%s", err, output)
+	}
+	contents, err := os.ReadFile(filepath.Join(clone, "real.txt"))
+	if err != nil || string(contents) != "Hello World\n" {
+		t.Fatalf("cloned real.txt contained %q, %v", contents, err)
+	}
+
+	status, _ := get(t, server.URL+SmartHTTPPath+"/info/refs?service=git-receive-pack")
+	if status != http.StatusForbidden {
+		t.Fatalf("pushing should be refused but got %d", status)
+	}
+}
+
+func TestListings(t *testing.T) {
+	git, err := gitfs.NewCliGit(runPlaybook(t, "symlinks"))
+	if err != nil {
+		t.Fatalf("NewCliGit() failed: %v", err)
+	}
+	fs := gitfs.NewReferenceFileSystemWithSymlinks(git, gitfs.BranchRef("master"), gitfs.SymlinkPassThrough)
+	listing := template.Must(template.New("listing").Parse(
+		`<h1>{{.Path}}</h1>{{range .Entries}}<li>{{.Name}} {{.Type}} {{.Mode}}</li>{{end}}`))
+	server := httptest.NewServer(NewHandlerWithTemplate(fs, listing))
+	defer server.Close()
+
+	t.Run("json", func(t *testing.T) {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/nested/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Accept", "application/json;q=0.9, text/html")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("GET /nested/ failed: %v", err)
+		}
+		defer response.Body.Close()
+		if contentType := response.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Fatalf("GET /nested/ returned %s", contentType)
+		}
+
+		var got Listing
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode the listing: %v", err)
+		}
+		if got.Path != "/nested/" || len(got.Entries) != 2 {
+			t.Fatalf("unexpected listing: %+v", got)
+		}
+		for _, entry := range got.Entries {
+			if entry.Type != "symlink" || entry.Mode != "120000" || len(entry.Hash) != 40 || entry.Target == "" {
+				t.Fatalf("unexpected entry: %+v", entry)
+			}
+		}
+	})
+
+	t.Run("template", func(t *testing.T) {
+		status, body := get(t, server.URL+"/")
+		want := "<h1>/</h1>"
+		if status != http.StatusOK || !strings.HasPrefix(body, want) || !strings.Contains(body, "<li>nested directory 040000</li>") {
+			t.Fatalf("GET / returned %d %q", status, body)
+		}
+	})
+
+	t.Run("files", func(t *testing.T) {
+		status, body := get(t, server.URL+"/real.txt")
+		if status != http.StatusOK || body != "Hello World\n" {
+			t.Fatalf("GET /real.txt returned %d %q", status, body)
+		}
+	})
+}
+
+func TestListingPages(t *testing.T) {
+	fs := memfs.New()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		if err := util.WriteFile(fs, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := httptest.NewServer(NewHandlerWithOptions(fs, HandlerOptions{MaxListingEntries: 2}))
+	defer server.Close()
+
+	list := func(query string) (int, Listing) {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Accept", "application/json")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("GET /%s failed: %v", query, err)
+		}
+		defer response.Body.Close()
+		var listing Listing
+		if response.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(response.Body).Decode(&listing); err != nil {
+				t.Fatalf("failed to decode the listing of /%s: %v", query, err)
+			}
+		}
+		return response.StatusCode, listing
+	}
+	names := func(listing Listing) string {
+		var names []string
+		for _, entry := range listing.Entries {
+			names = append(names, entry.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	tests := []struct {
+		query string
+		want  string
+		next  string
+	}{
+		{query: "", want: "a,b", next: "b"},
+		{query: "?after=b", want: "c,d", next: "d"},
+		{query: "?after=d", want: "e"},
+		{query: "?after=bb", want: "c,d", next: "d"},
+		{query: "?after=e", want: ""},
+		{query: "?limit=1&after=a", want: "b", next: "b"},
+		{query: "?limit=10", want: "a,b", next: "b"},
+	}
+	for _, test := range tests {
+		status, listing := list(test.query)
+		if status != http.StatusOK || listing.Path != "/" || names(listing) != test.want || listing.Next != test.next {
+			t.Fatalf("GET /%s returned %d %+v, want %s next %q", test.query, status, listing, test.want, test.next)
+		}
+	}
+
+	if status, _ := list("?limit=none"); status != http.StatusBadRequest {
+		t.Fatalf("GET /?limit=none returned %d", status)
+	}
+}
+
+func TestListingETag(t *testing.T) {
+	git, err := gitfs.NewCliGit(runPlaybook(t, "base"))
+	if err != nil {
+		t.Fatalf("NewCliGit() failed: %v", err)
+	}
+	fs := gitfs.NewReferenceFileSystem(git, gitfs.BranchRef("master"))
+	listing := template.Must(template.New("listing").Parse(`{{range .Entries}}{{.Name}} {{end}}`))
+	server := httptest.NewServer(NewHandlerWithTemplate(fs, listing))
+	defer server.Close()
+
+	get := func(accept, ifNoneMatch string) *http.Response {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/test/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Accept", accept)
+		request.Header.Set("If-None-Match", ifNoneMatch)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("GET /test/ failed: %v", err)
+		}
+		response.Body.Close()
+		return response
+	}
+
+	html := get("text/html", "")
+	etag := html.Header.Get("ETag")
+	if html.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("GET /test/ returned %d with ETag %q", html.StatusCode, etag)
+	}
+	if response := get("text/html", `"stale", W/`+etag); response.StatusCode != http.StatusNotModified {
+		t.Fatalf("GET /test/ with a current ETag returned %d", response.StatusCode)
+	}
+	if response := get("application/json", etag); response.StatusCode != http.StatusOK || response.Header.Get("ETag") == etag {
+		t.Fatalf("GET /test/ as JSON returned %d with ETag %q, the same as the HTML listing", response.StatusCode, response.Header.Get("ETag"))
+	}
+}
+
+func TestCommitSearch(t *testing.T) {
+	git, err := gitfs.NewCliGit(runPlaybook(t, "tags"))
+	if err != nil {
+		t.Fatalf("NewCliGit() failed: %v", err)
+	}
+	server := httptest.NewServer(NewCommitSearchHandler(git, gitfs.BranchRef("master")))
+	defer server.Close()
+
+	status, body := get(t, server.URL+CommitSearchPath+"?q=^Change")
+	if status != http.StatusOK {
+		t.Fatalf("searching returned %d %q", status, body)
+	}
+	var results []gitfs.CommitSearchResult
+	if err := json.Unmarshal([]byte(body), &results); err != nil {
+		t.Fatalf("failed to decode %q: %v", body, err)
+	}
+	if len(results) != 1 || results[0].Subject != "Change a normal file" || len(results[0].Hash) != 40 || results[0].Date.IsZero() {
+		t.Fatalf("searching found %+v", results)
+	}
+
+	for _, query := range []string{"", "?q=(", "?q=a&limit=0"} {
+		if status, body := get(t, server.URL+CommitSearchPath+query); status != http.StatusBadRequest {
+			t.Fatalf("searching with %q returned %d %q", query, status, body)
+		}
+	}
+}
+
+func TestBasicMarkdown(t *testing.T) {
+	tests := map[string]string{
+		"# Title\n\nSome `code` and\nmore text.\n": "<h1>Title</h1>\n<p>Some <code>code</code> and more text.</p>\n",
+		"- one\n* two\n\nafter":                    "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n<p>after</p>\n",
+		"```\n<b>\n```\n":                          "<pre><code>&lt;b&gt;\n</code></pre>\n",
+		"<script>alert(1)</script> `unmatched":     "<p>&lt;script&gt;alert(1)&lt;/script&gt; `unmatched</p>\n",
+		"####### not a heading\n#also not":         "<p>####### not a heading #also not</p>\n",
+		"```\nunterminated":                        "<pre><code>unterminated\n</code></pre>\n",
+	}
+	for source, want := range tests {
+		got, err := BasicMarkdown{}.Render([]byte(source))
+		if err != nil {
+			t.Fatalf("Render(%q) failed: %v", source, err)
+		}
+		if string(got) != want {
+			t.Fatalf("Render(%q) = %q, want %q", source, got, want)
+		}
+	}
+}
+
+func TestReadme(t *testing.T) {
+	fs := memfs.New()
+	if err := util.WriteFile(fs, "README.md", []byte("# Docs\n<script>x</script>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteFile(fs, "docs/guide.txt", []byte("guide"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewHandlerWithOptions(fs, HandlerOptions{Readme: BasicMarkdown{}}))
+	defer server.Close()
+
+	status, body := get(t, server.URL+"/")
+	if status != http.StatusOK || !strings.Contains(body, "<h1>Docs</h1>") || !strings.Contains(body, "&lt;script&gt;") {
+		t.Fatalf("GET / should render README.md: %d %q", status, body)
+	}
+	if !strings.Contains(body, `<a href="./docs/">docs/</a>`) || strings.Index(body, "Docs") > strings.Index(body, "docs/") {
+		t.Fatalf("GET / should list the directory below README.md: %q", body)
+	}
+
+	status, body = get(t, server.URL+"/docs/")
+	if status != http.StatusOK || strings.Contains(body, "readme") || !strings.Contains(body, "guide.txt") {
+		t.Fatalf("GET /docs/ should list without a README: %d %q", status, body)
+	}
+}
+
+func TestCacheAdminHandler(t *testing.T) {
+	cache := gitfs.NewManagedCache(gitfs.NewMemoryCache(1 << 20))
+	_ = cache.Set("blob/a", []byte("a"))
+	_ = cache.Set("tree/b", []byte("b"))
+	_, _ = cache.Get("blob/a")
+	server := httptest.NewServer(NewCacheAdminHandler(cache, "secret"))
+	defer server.Close()
+
+	request := func(server *httptest.Server, method, query string, header http.Header) (int, string) {
+		r, err := http.NewRequest(method, server.URL+CacheAdminPath+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header = header
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(body)
+	}
+	authorized := http.Header{"Authorization": {"Bearer secret"}}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		for _, header := range []http.Header{
+			nil,
+			{"Authorization": {"Bearer wrong"}},
+			{"Authorization": {"Bearer secret"}, "Origin": {"https://example.com"}},
+		} {
+			if status, _ := request(server, http.MethodPost, "flush?scope=all", header); status != http.StatusUnauthorized && status != http.StatusForbidden {
+				t.Fatalf("flushing with %v returned %d", header, status)
+			}
+		}
+		if _, err := cache.Get("tree/b"); err != nil {
+			t.Fatalf("unauthorized flushes dropped tree/b: %v", err)
+		}
+		if status, _ := request(server, http.MethodGet, "stats", nil); status != http.StatusUnauthorized {
+			t.Fatalf("stats without the token returned %d", status)
+		}
+	})
+
+	var stats gitfs.CacheStats
+	status, body := request(server, http.MethodGet, "stats", authorized)
+	if err := json.Unmarshal([]byte(body), &stats); status != http.StatusOK || err != nil || stats.Hits != 2 {
+		t.Fatalf("stats returned %d %+v, %v", status, stats, err)
+	}
+
+	flush := func(scope string) int {
+		status, _ := request(server, http.MethodPost, "flush?scope="+scope, authorized)
+		return status
+	}
+	if status := flush("refs"); status != http.StatusBadRequest {
+		t.Fatalf("flushing an unknown scope returned %d", status)
+	}
+	if status := flush("trees"); status != http.StatusOK {
+		t.Fatalf("flushing trees returned %d", status)
+	}
+	if _, err := cache.Get("tree/b"); err == nil {
+		t.Fatal("tree/b survived flushing trees")
+	}
+	if _, err := cache.Get("blob/a"); err != nil {
+		t.Fatalf("flushing trees dropped blob/a: %v", err)
+	}
+	if status, _ := request(server, http.MethodGet, "flush", authorized); status != http.StatusMethodNotAllowed {
+		t.Fatalf("flushing with GET returned %d", status)
+	}
+
+	t.Run("no cache", func(t *testing.T) {
+		server := httptest.NewServer(NewCacheAdminHandler(nil, "secret"))
+		defer server.Close()
+		if status, _ := request(server, http.MethodGet, "stats", authorized); status != http.StatusNotFound {
+			t.Fatalf("stats without a cache returned %d", status)
+		}
+	})
+}
+
+// fakeRepositoriesAdmin records the repositories it is asked to serve. Maintaining one that isn't served fails.
+type fakeRepositoriesAdmin map[string]string
+
+func (a fakeRepositoriesAdmin) Repositories() []gitfs.RepositoryStatus {
+	var statuses []gitfs.RepositoryStatus
+	for name, gitDir := range a {
+		statuses = append(statuses, gitfs.RepositoryStatus{RepositoryStats: gitfs.RepositoryStats{Name: name}, GitDir: gitDir})
+	}
+	return statuses
+}
+
+func (a fakeRepositoriesAdmin) AddRepository(name, gitDir string) error {
+	if err := gitfs.ValidRepositoryName(name); err != nil {
+		return err
+	}
+	a[name] = gitDir
+	return nil
+}
+
+func (a fakeRepositoriesAdmin) RemoveRepository(name string) error {
+	if _, ok := a[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(a, name)
+	return nil
+}
+
+func (a fakeRepositoriesAdmin) MaintainRepository(name string, task gitism.MaintenanceTask) error {
+	if _, ok := a[name]; !ok {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+func TestRepositoriesAdminHandler(t *testing.T) {
+	reposDir := t.TempDir()
+	tools := filepath.Join(reposDir, "tools.git")
+	outside := t.TempDir()
+	for _, dir := range []string{tools, filepath.Join(outside, "private.git")} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(reposDir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := fakeRepositoriesAdmin{}
+	server := httptest.NewServer(NewRepositoriesAdminHandler(admin, reposDir, "secret"))
+	defer server.Close()
+
+	request := func(method, query string, header http.Header) (int, string) {
+		r, err := http.NewRequest(method, server.URL+RepositoriesAdminPath+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header = header
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(body)
+	}
+	authorized := http.Header{"Authorization": {"Bearer secret"}}
+	post := func(query string) int {
+		status, _ := request(http.MethodPost, query, authorized)
+		return status
+	}
+	get := func(t *testing.T, url string) (int, string) {
+		return request(http.MethodGet, strings.TrimPrefix(url, server.URL+RepositoriesAdminPath), authorized)
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		for _, header := range []http.Header{
+			nil,
+			{"Authorization": {"Bearer wrong"}},
+			{"Authorization": {"Bearer secret"}, "Origin": {"https://example.com"}},
+		} {
+			if status, _ := request(http.MethodPost, "add?git-dir=tools.git", header); status != http.StatusUnauthorized && status != http.StatusForbidden {
+				t.Fatalf("adding a repository with %v returned %d", header, status)
+			}
+		}
+		if len(admin) != 0 {
+			t.Fatalf("unauthorized requests added %v", admin)
+		}
+		if status, _ := request(http.MethodGet, "list", nil); status != http.StatusUnauthorized {
+			t.Fatalf("listing without the token returned %d", status)
+		}
+	})
+
+	for _, gitDir := range []string{outside + "/private.git", "../" + filepath.Base(outside) + "/private.git", "escape/private.git", reposDir} {
+		if status := post("add?git-dir=" + gitDir); status != http.StatusForbidden {
+			t.Fatalf("adding %s outside of the repos dir returned %d", gitDir, status)
+		}
+	}
+	if status := post("add?git-dir=tools.git"); status != http.StatusOK {
+		t.Fatalf("adding a repository returned %d", status)
+	}
+	if status := post("add?git-dir=" + tools + "&name=a/b"); status != http.StatusBadRequest {
+		t.Fatalf("adding a repository with an invalid name returned %d", status)
+	}
+	if status := post("add"); status != http.StatusBadRequest {
+		t.Fatalf("adding without a git-dir returned %d", status)
+	}
+
+	status, body := get(t, server.URL+RepositoriesAdminPath+"list")
+	var statuses []gitfs.RepositoryStatus
+	if err := json.Unmarshal([]byte(body), &statuses); status != http.StatusOK || err != nil {
+		t.Fatalf("list returned %d, %v: %s", status, err, body)
+	}
+	if len(statuses) != 1 || statuses[0].Name != "tools" || statuses[0].GitDir != tools {
+		t.Fatalf("list returned %+v", statuses)
+	}
+
+	if status := post("maintain?name=tools&task=incremental"); status != http.StatusOK {
+		t.Fatalf("maintaining a repository returned %d", status)
+	}
+	if status := post("maintain?name=tools&task=fsck"); status != http.StatusBadRequest {
+		t.Fatalf("maintaining a repository with an unknown task returned %d", status)
+	}
+	if status := post("maintain?name=missing"); status != http.StatusNotFound {
+		t.Fatalf("maintaining a repository that isn't served returned %d", status)
+	}
+	if status, all := request(http.MethodPost, "maintain", authorized); status != http.StatusOK || all != "maintained tools with gc\n" {
+		t.Fatalf("maintaining every repository returned %d: %s", status, all)
+	}
+
+	if status := post("remove?name=tools"); status != http.StatusOK {
+		t.Fatalf("removing a repository returned %d", status)
+	}
+	if status := post("remove?name=tools"); status != http.StatusNotFound {
+		t.Fatalf("removing a repository twice returned %d", status)
+	}
+	if status, _ := get(t, server.URL+RepositoriesAdminPath+"add?git-dir=tools.git"); status != http.StatusMethodNotAllowed {
+		t.Fatalf("adding with GET returned %d", status)
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ListingEntry describes one entry of a directory listing.
+type ListingEntry struct {
+	Name string `json:"name"`
+	// Type is file, directory, or symlink.
+	Type string `json:"type"`
+	// Mode is the git mode of the entry, like 100644 or 040000.
+	Mode string `json:"mode"`
+	Size int64  `json:"size"`
+	// Hash is the git object id of the entry, if it came straight from git.
+	Hash string `json:"hash,omitempty"`
+	// Target is where a symlink points.
+	Target string `json:"target,omitempty"`
+}
+
+// Listing is a directory listing. JSON listings are encoded from it and listing templates are executed with it.
+type Listing struct {
+	// Path is the URL path of the directory, ending in a slash.
+	Path    string         `json:"path"`
+	Entries []ListingEntry `json:"entries"`
+	// Next is set when only some of the entries were listed, and lists the rest when passed back as the after
+	// parameter. See HandlerOptions.MaxListingEntries.
+	Next string `json:"next,omitempty"`
+	// Readme is the directory's README rendered to HTML. It is only set for HTML listings served with a
+	// MarkdownRenderer.
+	Readme template.HTML `json:"-"`
+}
+
+// listingHandler answers requests for directories with JSON or a template and leaves everything else to files.
+type listingHandler struct {
+	fs       fileSystem
+	files    http.Handler
+	template *template.Template
+	markdown MarkdownRenderer
+	// maxEntries is HandlerOptions.MaxListingEntries.
+	maxEntries int
+}
+
+// HandlerOptions customize how NewHandlerWithOptions lists directories.
+type HandlerOptions struct {
+	// Listing is executed with a Listing to list directories. If nil, http.FileServer's listings are used unless
+	// Readme is set, in which case DefaultListingTemplate is.
+	Listing *template.Template
+	// Readme, if set, renders the README.md of each directory into Listing.Readme.
+	Readme MarkdownRenderer
+	// MaxListingEntries is the most entries a JSON listing has. Clients page through larger directories by passing
+	// the Listing.Next of each page as the after parameter of the next request, and may ask for smaller pages with the
+	// limit parameter. 0 lists every entry unless limit says otherwise.
+	MaxListingEntries int
+}
+
+// NewHandlerWithTemplate is NewHandler, but directories are listed by executing listing with a Listing. A nil listing
+// uses http.FileServer's listings. Either way, directories are listed as JSON for requests that accept
+// application/json.
+func NewHandlerWithTemplate(fs billy.Filesystem, listing *template.Template) http.Handler {
+	return NewHandlerWithOptions(fs, HandlerOptions{Listing: listing})
+}
+
+// NewHandlerWithOptions is NewHandler with directories listed as options describe.
+func NewHandlerWithOptions(fs billy.Filesystem, options HandlerOptions) http.Handler {
+	files := fileSystem{fs: fs}
+	listing := options.Listing
+	if listing == nil && options.Readme != nil {
+		listing = DefaultListingTemplate
+	}
+	return listingHandler{
+		fs:       files,
+		files:    http.FileServer(files),
+		template: listing,
+		markdown: options.Readme,
+
+		maxEntries: options.MaxListingEntries,
+	}
+}
+
+// acceptsJSON reports whether r asks for application/json.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+func gitMode(info os.FileInfo) string {
+	mode := info.Mode()
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return "120000"
+	case mode.IsDir():
+		return "040000"
+	case mode.Perm()&0111 != 0:
+		return "100755"
+	default:
+		return "100644"
+	}
+}
+
+// entry describes file in the directory name.
+func (h listingHandler) entry(name string, file os.FileInfo) (ListingEntry, error) {
+	entry := ListingEntry{Name: file.Name(), Type: "file", Mode: gitMode(file), Size: file.Size()}
+	entry.Hash, _ = gitfs.ObjectHash(file)
+	switch {
+	case file.Mode()&os.ModeSymlink != 0:
+		entry.Type = "symlink"
+		target, err := h.fs.fs.Readlink(path.Join(name, file.Name()))
+		if err != nil {
+			return ListingEntry{}, err
+		}
+		entry.Target = target
+	case file.IsDir():
+		entry.Type = "directory"
+		entry.Size = 0
+	}
+	return entry, nil
+}
+
+func (h listingHandler) listing(urlPath, name string, files []os.FileInfo) (Listing, error) {
+	listing := Listing{Path: urlPath, Entries: make([]ListingEntry, 0, len(files))}
+	for _, file := range files {
+		entry, err := h.entry(name, file)
+		if err != nil {
+			return Listing{}, err
+		}
+		listing.Entries = append(listing.Entries, entry)
+	}
+	return listing, nil
+}
+
+// page picks the files of the page query asks for, the ones after the file named by its after parameter and at
+// most as many as its limit parameter or maxEntries allow. next is the name of the last file when there are more.
+// Files are listed in the directory's order, so an after that was removed since is taken to be where its name would
+// sort.
+func (h listingHandler) page(files []os.FileInfo, query url.Values) (page []os.FileInfo, next string, err error) {
+	limit := h.maxEntries
+	if value := query.Get("limit"); value != "" {
+		requested, err := strconv.Atoi(value)
+		if err != nil || requested <= 0 {
+			return nil, "", fmt.Errorf("limit must be a positive number of entries, not '%s'", value)
+		}
+		if limit == 0 || requested < limit {
+			limit = requested
+		}
+	}
+
+	start := 0
+	if after := query.Get("after"); after != "" {
+		start = len(files)
+		for i, file := range files {
+			if file.Name() == after {
+				start = i + 1
+				break
+			}
+			if file.Name() > after && i < start {
+				start = i
+			}
+		}
+	}
+	page = files[start:]
+	if limit > 0 && len(page) > limit {
+		page = page[:limit]
+		next = page[limit-1].Name()
+	}
+	return page, next, nil
+}
+
+// serveJSON lists files of the directory name as JSON a page at a time. Entries are written as they are described so
+// huge directories aren't held in memory twice, and a failure partway through aborts the response.
+func (h listingHandler) serveJSON(w http.ResponseWriter, r *http.Request, name string, files []os.FileInfo) {
+	files, next, err := h.page(files, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoded, _ := json.Marshal(r.URL.Path)
+	fmt.Fprintf(w, `{"path":%s,"entries":[`, encoded)
+	for i, file := range files {
+		entry, err := h.entry(name, file)
+		if err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		encoded, _ := json.Marshal(entry)
+		if i > 0 {
+			_, _ = w.Write([]byte{','})
+		}
+		_, _ = w.Write(encoded)
+	}
+	_, _ = w.Write([]byte{']'})
+	if next != "" {
+		encoded, _ := json.Marshal(next)
+		fmt.Fprintf(w, `,"next":%s`, encoded)
+	}
+	_, _ = w.Write([]byte("}\n"))
+}
+
+func (h listingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// http.FileServer redirects directories to a path ending in a slash, so only those are listed.
+	isListing := strings.HasSuffix(r.URL.Path, "/") && (r.Method == http.MethodGet || r.Method == http.MethodHead)
+	asJSON := acceptsJSON(r)
+	if !isListing {
+		h.serveFile(w, r)
+		return
+	}
+	if !asJSON && h.template == nil {
+		h.files.ServeHTTP(w, r)
+		return
+	}
+
+	name, info, err := h.fs.resolve(cleanName(r.URL.Path))
+	if err != nil || !info.IsDir() {
+		h.files.ServeHTTP(w, r)
+		return
+	}
+	if !asJSON {
+		// Keep serving index.html in place of a listing like http.FileServer does.
+		if _, err := h.fs.fs.Stat(path.Join(name, "index.html")); err == nil {
+			h.files.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	files, err := h.fs.fs.ReadDir(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to list directory", http.StatusInternalServerError)
+		return
+	}
+
+	// Clients revalidate cached listings with If-None-Match. The JSON and HTML listings of a directory are different
+	// representations, so they get different tags.
+	etag := gitfs.ListingTag(files)
+	if asJSON {
+		etag += "-json"
+	}
+	etag = `"` + etag + `"`
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if asJSON {
+		h.serveJSON(w, r, name, files)
+		return
+	}
+
+	listing, err := h.listing(r.URL.Path, name, files)
+	if err != nil {
+		http.Error(w, "failed to list directory", http.StatusInternalServerError)
+		return
+	}
+
+	if h.markdown != nil {
+		listing.Readme = h.readme(name)
+	}
+	var body bytes.Buffer
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.template.Execute(&body, listing); err != nil {
+		http.Error(w, "failed to render listing", http.StatusInternalServerError)
+		return
+	}
+	_, _ = body.WriteTo(w)
+}
+
+// etagMatches reports whether the If-None-Match header ifNoneMatch lists etag. Weak tags match their strong
+// counterparts, as If-None-Match compares them weakly.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// serveFile serves the file r asks for with the type gitfs.DetectContentType guesses for it, which http.FileServer
+// keeps rather than sniffing the file itself.
+func (h listingHandler) serveFile(w http.ResponseWriter, r *http.Request) {
+	name, info, err := h.fs.resolve(cleanName(r.URL.Path))
+	if err == nil && info.Mode().IsRegular() {
+		if contentType, err := gitfs.DetectContentType(h.fs.fs, name, info); err == nil {
+			w.Header().Set("Content-Type", contentType)
+		}
+	}
+	h.files.ServeHTTP(w, r)
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpfs
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"strings"
+)
+
+// ReadmeName is the file rendered above the listing of the directory it is in.
+const ReadmeName = "README.md"
+
+// maxReadmeSize is the largest README that is rendered. Larger ones are left to be opened like any other file.
+const maxReadmeSize = 1 << 20
+
+// MarkdownRenderer turns the markdown of a README into HTML for a directory listing. READMEs come from the
+// repository, so anything a renderer doesn't understand must be escaped rather than passed through.
+type MarkdownRenderer interface {
+	Render(source []byte) (template.HTML, error)
+}
+
+// BasicMarkdown renders headings, paragraphs, bulleted lists, fenced code blocks, and inline code. Everything else is
+// shown as escaped text, so it is safe to use on untrusted READMEs without pulling in a full markdown library.
+type BasicMarkdown struct{}
+
+// inlineMarkdown escapes text and turns `code` spans into <code> elements.
+func inlineMarkdown(text string) string {
+	parts := strings.Split(text, "`")
+	if len(parts)%2 == 0 {
+		// An unmatched backtick is just a backtick.
+		return template.HTMLEscapeString(text)
+	}
+	for i, part := range parts {
+		parts[i] = template.HTMLEscapeString(part)
+		if i%2 == 1 {
+			parts[i] = "<code>" + parts[i] + "</code>"
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// headingLevel is the level of a "# heading" line, and 0 if line isn't one.
+func headingLevel(line string) int {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || level == len(line) || line[level] != ' ' {
+		return 0
+	}
+	return level
+}
+
+func (BasicMarkdown) Render(source []byte) (template.HTML, error) {
+	var out strings.Builder
+	var paragraph []string
+	inList, inCode := false, false
+	endParagraph := func() {
+		if len(paragraph) > 0 {
+			fmt.Fprintf(&out, "<p>%s</p>\n", inlineMarkdown(strings.Join(paragraph, " ")))
+			paragraph = nil
+		}
+	}
+	endList := func() {
+		if inList {
+			out.WriteString("</ul>\n")
+			inList = false
+		}
+	}
+
+	for _, line := range strings.Split(string(source), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if inCode {
+			if strings.HasPrefix(strings.TrimSpace(line), "```") {
+				out.WriteString("</code></pre>\n")
+				inCode = false
+			} else {
+				out.WriteString(template.HTMLEscapeString(line) + "\n")
+			}
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			endParagraph()
+			endList()
+			out.WriteString("<pre><code>")
+			inCode = true
+		case trimmed == "":
+			endParagraph()
+			endList()
+		case headingLevel(trimmed) > 0:
+			endParagraph()
+			endList()
+			level := headingLevel(trimmed)
+			fmt.Fprintf(&out, "<h%d>%s</h%d>\n", level, inlineMarkdown(strings.TrimSpace(trimmed[level:])), level)
+		case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* "):
+			endParagraph()
+			if !inList {
+				out.WriteString("<ul>\n")
+				inList = true
+			}
+			fmt.Fprintf(&out, "<li>%s</li>\n", inlineMarkdown(strings.TrimSpace(trimmed[2:])))
+		default:
+			endList()
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	if inCode {
+		out.WriteString("</code></pre>\n")
+	}
+	endParagraph()
+	endList()
+	return template.HTML(out.String()), nil
+}
+
+// DefaultListingTemplate lists a directory below its rendered README. It is used when a MarkdownRenderer is given
+// without a listing template.
+var DefaultListingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Path}}</title></head>
+<body>
+<h1>{{.Path}}</h1>
+{{if .Readme}}<article class="readme">
+{{.Readme}}</article>
+{{end}}<ul>
+{{range .Entries}}<li><a href="./{{.Name}}{{if eq .Type "directory"}}/{{end}}">{{.Name}}{{if eq .Type "directory"}}/{{end}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// readme renders the README of the directory name, and is empty if it has none or it can't be rendered.
+func (h listingHandler) readme(name string) template.HTML {
+	readme, info, err := h.fs.resolve(path.Join(name, ReadmeName))
+	if err != nil || info.IsDir() || info.Size() > maxReadmeSize {
+		return ""
+	}
+	file, err := h.fs.fs.Open(readme)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	source, err := io.ReadAll(io.LimitReader(file, maxReadmeSize))
+	if err != nil {
+		return ""
+	}
+	rendered, err := h.markdown.Render(source)
+	if err != nil {
+		return ""
+	}
+	return rendered
+}
@@ -0,0 +1,66 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"net/http"
+	"strconv"
+)
+
+// CommitSearchPath is where cmd/githttp serves NewCommitSearchHandler. It shadows that path in the repository.
+const CommitSearchPath = "/api/search/commits"
+
+const (
+	// defaultSearchResults is how many commits a search returns when it doesn't ask for a limit.
+	defaultSearchResults = 100
+	// maxSearchResults is the most commits a search can ask for, so one request can't have git describe all history.
+	maxSearchResults = 1000
+)
+
+// NewCommitSearchHandler searches the messages of the commits reachable from reference with GET ?q=PATTERN, an
+// extended regular expression like git log --grep takes, and returns the gitfs.CommitSearchResult of each match as
+// JSON, newest first. Up to 100 commits are returned unless limit asks for more, up to 1000.
+func NewCommitSearchHandler(git gitfs.Git, reference gitfs.Ref) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "commits must be searched with GET", http.StatusMethodNotAllowed)
+			return
+		}
+		limit := defaultSearchResults
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit <= 0 || limit > maxSearchResults {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d, not '%s'", maxSearchResults, value), http.StatusBadRequest)
+				return
+			}
+		}
+
+		results, err := gitfs.SearchCommits(git, reference, r.URL.Query().Get("q"), limit)
+		if errors.Is(err, gitfs.ErrInvalidPattern) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "failed to search commits", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(results)
+	})
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpfs
+
+import (
+	"net/http"
+	"net/http/cgi"
+	"os"
+	"strings"
+)
+
+// SmartHTTPPath is where cmd/githttp serves the smart HTTP protocol, so the repository can be cloned from
+// http://host/.git next to the browsable tree. Trees can't contain a .git entry so this never hides a file.
+const SmartHTTPPath = "/.git"
+
+// NewSmartHTTPHandler serves git's smart HTTP protocol for the repository at gitDirectory under prefix by running
+// git http-backend. Only fetching is allowed; pushes are refused before git is started. env is added to git's
+// environment.
+func NewSmartHTTPHandler(gitExecutable, gitDirectory, prefix string, env ...string) http.Handler {
+	backend := &cgi.Handler{
+		Path: gitExecutable,
+		Args: []string{"http-backend"},
+		Root: prefix,
+		Env: append([]string{
+			// http-backend appends the rest of the URL to GIT_PROJECT_ROOT to find the repository and the
+			// handler strips prefix, so every URL under it maps onto gitDirectory.
+			"GIT_PROJECT_ROOT=" + gitDirectory,
+			"GIT_HTTP_EXPORT_ALL=1",
+		}, env...),
+		InheritEnv: []string{"PATH", "HOME"},
+		Stderr:     os.Stderr,
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if strings.HasSuffix(request.URL.Path, "/git-receive-pack") ||
+			request.URL.Query().Get("service") == "git-receive-pack" {
+			http.Error(writer, "pushing is not supported", http.StatusForbidden)
+			return
+		}
+		backend.ServeHTTP(writer, request)
+	})
+}
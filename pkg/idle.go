@@ -0,0 +1,181 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"github.com/go-git/go-billy/v5"
+	"os"
+	"sync"
+	"time"
+)
+
+// IdleTracker records when a filesystem was last used so a daemon can shut itself down once nobody is using it.
+type IdleTracker struct {
+	mu sync.Mutex
+	// active counts the calls in progress. The filesystem is never idle while one is running.
+	active int
+	last   time.Time
+	// changed is closed and replaced whenever a call starts or finishes, to wake up WaitIdle.
+	changed chan struct{}
+}
+
+// NewIdleTracker returns a tracker that was last used now.
+func NewIdleTracker() *IdleTracker {
+	return &IdleTracker{
+		last:    time.Now(),
+		changed: make(chan struct{}),
+	}
+}
+
+// begin marks the start of a call. The returned function marks its end.
+func (t *IdleTracker) begin() func() {
+	t.mu.Lock()
+	t.active += 1
+	t.notify()
+	t.mu.Unlock()
+	return func() {
+		t.mu.Lock()
+		t.active -= 1
+		t.last = time.Now()
+		t.notify()
+		t.mu.Unlock()
+	}
+}
+
+// notify wakes up WaitIdle. Must be called with t.mu held.
+func (t *IdleTracker) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// Touch counts as a use of the filesystem, restarting the idle window.
+func (t *IdleTracker) Touch() {
+	t.begin()()
+}
+
+// WaitIdle blocks until nothing has used the filesystem for timeout. It returns false if ctx is done first.
+func (t *IdleTracker) WaitIdle(ctx context.Context, timeout time.Duration) bool {
+	for {
+		t.mu.Lock()
+		remaining := timeout - time.Now().Sub(t.last)
+		if t.active > 0 {
+			remaining = timeout
+		} else if remaining <= 0 {
+			t.mu.Unlock()
+			return true
+		}
+		changed := t.changed
+		t.mu.Unlock()
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// idleFileSystem records every call into a filesystem with an IdleTracker.
+type idleFileSystem struct {
+	billy.Filesystem
+	tracker *IdleTracker
+}
+
+// NewIdleFileSystem records every call into fs with tracker. A nil tracker returns fs.
+func NewIdleFileSystem(fs billy.Filesystem, tracker *IdleTracker) billy.Filesystem {
+	if tracker == nil {
+		return fs
+	}
+	return idleFileSystem{Filesystem: fs, tracker: tracker}
+}
+
+func (s idleFileSystem) Create(filename string) (billy.File, error) {
+	defer s.tracker.begin()()
+	return s.Filesystem.Create(filename)
+}
+
+func (s idleFileSystem) Open(filename string) (billy.File, error) {
+	defer s.tracker.begin()()
+	return s.Filesystem.Open(filename)
+}
+
+func (s idleFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	defer s.tracker.begin()()
+	return s.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (s idleFileSystem) Stat(filename string) (os.FileInfo, error) {
+	defer s.tracker.begin()()
+	return s.Filesystem.Stat(filename)
+}
+
+func (s idleFileSystem) Rename(oldpath, newpath string) error {
+	defer s.tracker.begin()()
+	return s.Filesystem.Rename(oldpath, newpath)
+}
+
+func (s idleFileSystem) Remove(filename string) error {
+	defer s.tracker.begin()()
+	return s.Filesystem.Remove(filename)
+}
+
+func (s idleFileSystem) TempFile(dir, prefix string) (billy.File, error) {
+	defer s.tracker.begin()()
+	return s.Filesystem.TempFile(dir, prefix)
+}
+
+func (s idleFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	defer s.tracker.begin()()
+	return s.Filesystem.ReadDir(path)
+}
+
+func (s idleFileSystem) MkdirAll(filename string, perm os.FileMode) error {
+	defer s.tracker.begin()()
+	return s.Filesystem.MkdirAll(filename, perm)
+}
+
+func (s idleFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	defer s.tracker.begin()()
+	return s.Filesystem.Lstat(filename)
+}
+
+func (s idleFileSystem) Symlink(target, link string) error {
+	defer s.tracker.begin()()
+	return s.Filesystem.Symlink(target, link)
+}
+
+func (s idleFileSystem) Readlink(link string) (string, error) {
+	defer s.tracker.begin()()
+	return s.Filesystem.Readlink(link)
+}
+
+// Chroot records calls into the new root with the same tracker.
+func (s idleFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	defer s.tracker.begin()()
+	fs, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewIdleFileSystem(fs, s.tracker), nil
+}
+
+func (s idleFileSystem) Capabilities() billy.Capability {
+	return billy.Capabilities(s.Filesystem)
+}
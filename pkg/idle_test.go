@@ -0,0 +1,73 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"testing"
+	"time"
+)
+
+func TestIdleTracker(t *testing.T) {
+	backing := memfs.New()
+	if err := util.WriteFile(backing, "real.txt", []byte("Hello World\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewIdleTracker()
+	fs := NewIdleFileSystem(backing, tracker)
+
+	const timeout = 100 * time.Millisecond
+	start := time.Now()
+	idle := make(chan bool)
+	go func() {
+		idle <- tracker.WaitIdle(context.Background(), timeout)
+	}()
+
+	// Keep the filesystem busy for a few windows.
+	for time.Since(start) < 3*timeout {
+		if _, err := fs.Stat("real.txt"); err != nil {
+			t.Fatalf("Stat(real.txt) failed: %v", err)
+		}
+		select {
+		case <-idle:
+			t.Fatalf("became idle after %v while in use", time.Since(start))
+		case <-time.After(timeout / 4):
+		}
+	}
+
+	if !<-idle {
+		t.Fatal("WaitIdle() returned false without being cancelled")
+	}
+	if waited := time.Since(start); waited < 3*timeout {
+		t.Fatalf("became idle after %v while in use", waited)
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		tracker.Touch()
+		if tracker.WaitIdle(ctx, time.Hour) {
+			t.Fatal("WaitIdle() should return false once ctx is done")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		if NewIdleFileSystem(backing, nil) != backing {
+			t.Fatal("a nil tracker should not wrap the filesystem")
+		}
+	})
+}
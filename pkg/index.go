@@ -0,0 +1,209 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrIndexRef is returned when IndexRef is used where a commit is needed, since nothing in the index is committed.
+var ErrIndexRef = errors.New("the index is not a commit")
+
+// IndexRef names the files staged in the index of a non-bare repository, which are exactly what would be committed
+// next. Files with unresolved conflicts aren't served since they can't be committed until they are resolved.
+func IndexRef() Ref {
+	return Ref{Kind: RefIndex, Name: "index"}
+}
+
+// stagedTree is the tree implied by the files in the index. git has no tree object for it until it is committed, so
+// the directories are made up from the paths of the files. It is reread whenever the index file changes.
+type stagedTree struct {
+	read      func(handler func(entry gitism.IndexEntry) error) error
+	indexFile string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	tree    *pathTree
+}
+
+func newStagedTree(read func(handler func(entry gitism.IndexEntry) error) error, indexFile string) *stagedTree {
+	return &stagedTree{read: read, indexFile: indexFile}
+}
+
+// pathDirectory is the entry of a directory that has no tree object of its own.
+func pathDirectory(dir string) gitism.TreeEntry {
+	return gitism.TreeEntry{
+		Mode:   gitism.NewFileMode(0040000),
+		Object: gitism.TreeObject,
+		Size:   "-",
+		Path:   dir,
+	}
+}
+
+// pathTree is a tree made up from the paths of the files in it, answering ls-tree the way git would for a tree object
+// holding them.
+type pathTree struct {
+	// entries holds every file and directory by path, with the root at "". children holds the paths in each
+	// directory in the order they were added.
+	entries  map[string]gitism.TreeEntry
+	children map[string][]string
+}
+
+func newPathTree() *pathTree {
+	return &pathTree{
+		entries:  map[string]gitism.TreeEntry{"": pathDirectory("")},
+		children: map[string][]string{},
+	}
+}
+
+// add puts entry in the tree along with any directories leading up to it that aren't there yet. Entries must be added
+// sorted by path for listings to come out in the same order as git's.
+func (t *pathTree) add(entry gitism.TreeEntry) {
+	parent := path.Dir(entry.Path)
+	if parent == "." {
+		parent = ""
+	}
+	if _, ok := t.entries[parent]; !ok {
+		t.add(pathDirectory(parent))
+	}
+	t.entries[entry.Path] = entry
+	t.children[parent] = append(t.children[parent], entry.Path)
+}
+
+// load returns the files and directories in the index, rereading it if it changed since the last time.
+func (t *stagedTree) load() (*pathTree, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info, statErr := os.Stat(t.indexFile)
+	if t.tree != nil && statErr == nil && info.ModTime().Equal(t.modTime) && info.Size() == t.size {
+		return t.tree, nil
+	}
+
+	tree := newPathTree()
+	err := t.read(func(entry gitism.IndexEntry) error {
+		if entry.Stage != 0 {
+			return nil
+		}
+		object := gitism.BlobObject
+		if entry.Mode.Type == gitism.Gitlink {
+			object = gitism.CommitObject
+		}
+		tree.add(gitism.TreeEntry{Mode: entry.Mode, Object: object, Hash: entry.Hash, Size: "-", Path: entry.Path})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	t.tree = tree
+	t.modTime, t.size = time.Time{}, 0
+	if statErr == nil {
+		t.modTime, t.size = info.ModTime(), info.Size()
+	}
+	return tree, nil
+}
+
+// cleanTreePath turns a path passed to ls-tree into a key of pathTree.entries, and reports if it asked for the
+// children of a directory rather than the directory itself.
+func cleanTreePath(treePath string) (string, bool) {
+	children := strings.HasSuffix(treePath, "/")
+	treePath = path.Clean(treePath)
+	if treePath == "." {
+		// ls-tree lists the root's children for "." too.
+		return "", true
+	}
+	return treePath, children
+}
+
+// list is ListTree for the index.
+func (t *stagedTree) list(treePath string, handler func(entry gitism.TreeEntry) error) error {
+	tree, err := t.load()
+	if err != nil {
+		return err
+	}
+	return tree.list(treePath, handler)
+}
+
+// listDirectory is ListDirectory for the index.
+func (t *stagedTree) listDirectory(treePath string, handler func(entry gitism.TreeEntry) error) error {
+	tree, err := t.load()
+	if err != nil {
+		return err
+	}
+	return tree.listDirectory(treePath, handler)
+}
+
+// list is Git.ListTree for the tree.
+func (t *pathTree) list(treePath string, handler func(entry gitism.TreeEntry) error) error {
+	treePath, listChildren := cleanTreePath(treePath)
+	entry, ok := t.entries[treePath]
+	if !ok {
+		return nil
+	}
+	if !listChildren || entry.Object == gitism.CommitObject {
+		// Like ls-tree, a submodule lists itself when its children are asked for.
+		return handler(entry)
+	}
+	for _, child := range t.children[treePath] {
+		if err := handler(t.entries[child]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listDirectory is Git.ListDirectory for the tree.
+func (t *pathTree) listDirectory(treePath string, handler func(entry gitism.TreeEntry) error) error {
+	treePath, _ = cleanTreePath(treePath)
+	entry, ok := t.entries[treePath]
+	if !ok || entry.Object == gitism.BlobObject {
+		return nil
+	}
+	if err := handler(entry); err != nil {
+		return err
+	}
+	for _, child := range t.children[treePath] {
+		if err := handler(t.entries[child]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listRecursive is Git.ListTreeRecursive for the tree.
+func (t *pathTree) listRecursive(treePath string, handler func(entry gitism.TreeEntry) error) error {
+	treePath, _ = cleanTreePath(treePath)
+	entry, ok := t.entries[treePath]
+	if !ok {
+		return nil
+	}
+	if entry.Object != gitism.TreeObject {
+		return handler(entry)
+	}
+	for _, child := range t.children[treePath] {
+		if err := t.listRecursive(child, handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
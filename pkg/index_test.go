@@ -0,0 +1,97 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexRef(t *testing.T) {
+	gitDir, err := runPlaybook("staged", t.TempDir())
+	if err != nil {
+		t.Fatalf("playbook 'staged' failed: %v", err)
+	}
+	git, err := NewCliGit(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewReferenceFileSystem(git, IndexRef())
+
+	names := func(dir string) []string {
+		files, err := fs.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir(%s) failed: %v", dir, err)
+		}
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		return names
+	}
+	if diff := cmp.Diff([]string{"committed.txt", "new"}, names("/")); diff != "" {
+		t.Fatalf("ReadDir(/) should list what is staged (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"nested"}, names("new")); diff != "" {
+		t.Fatalf("ReadDir(new) mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"added.txt"}, names("new/nested")); diff != "" {
+		t.Fatalf("ReadDir(new/nested) mismatch (-want +got):\n%s", diff)
+	}
+
+	contents, err := util.ReadFile(fs, "committed.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "Committed, then changed\n" {
+		t.Fatalf("committed.txt should be the staged version: %q", contents)
+	}
+	info, err := fs.Stat("committed.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(contents)) {
+		t.Fatalf("Stat(committed.txt).Size() = %d, want %d", info.Size(), len(contents))
+	}
+	if info, err := fs.Stat("new/nested"); err != nil || !info.IsDir() {
+		t.Fatalf("Stat(new/nested) should be a directory: %v", err)
+	}
+	if _, err := fs.ReadDir("committed.txt"); !errors.Is(err, ErrNotDirectory) {
+		t.Fatalf("ReadDir(committed.txt) should fail with ErrNotDirectory: %v", err)
+	}
+	for _, missing := range []string{"removed.txt", "untracked.txt"} {
+		if _, err := fs.Stat(missing); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Stat(%s) should fail since it isn't staged: %v", missing, err)
+		}
+	}
+	if _, err := git.ResolveCommit(IndexRef()); !errors.Is(err, ErrIndexRef) {
+		t.Fatalf("ResolveCommit(IndexRef()) should fail with ErrIndexRef: %v", err)
+	}
+
+	// Staging more is picked up without remounting.
+	add := exec.Command("git", "add", "untracked.txt")
+	add.Dir = filepath.Dir(gitDir)
+	if err := add.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"committed.txt", "new", "untracked.txt"}, names("/")); diff != "" {
+		t.Fatalf("ReadDir(/) should list newly staged files (-want +got):\n%s", diff)
+	}
+}
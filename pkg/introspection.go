@@ -0,0 +1,495 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// IntrospectionDirectory is where NewIntrospectionFileSystem exposes information about the mounted tree. It shadows
+// any directory of the same name in the repository.
+const IntrospectionDirectory = ".gitfs"
+
+// NotesRef is where .gitfs/notes reads notes from, the default of git notes.
+const NotesRef = "refs/notes/commits"
+
+// introspectionFile generates the contents of a file in IntrospectionDirectory. It is called on every access so the
+// contents follow the reference as it moves.
+type introspectionFile func(s introspectionFileSystem) ([]byte, error)
+
+var introspectionFiles = map[string]introspectionFile{
+	// commit is the full hash of the commit being served.
+	"commit": func(s introspectionFileSystem) ([]byte, error) {
+		commit, err := s.git.ResolveCommit(s.reference)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(commit + "\n"), nil
+	},
+	// id is the SnapshotID of what is being served.
+	"id": func(s introspectionFileSystem) ([]byte, error) {
+		commit, err := s.git.ResolveCommit(s.reference)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(SnapshotID(s.repository, s.reference, commit, s.filters) + "\n"), nil
+	},
+	// describe is the output of git describe for the commit being served.
+	"describe": func(s introspectionFileSystem) ([]byte, error) {
+		commit, err := s.git.ResolveCommit(s.reference)
+		if err != nil {
+			return nil, err
+		}
+		description, err := s.git.Describe(commit)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(description + "\n"), nil
+	},
+	// notes is the git note attached to the commit being served, and empty if it has none.
+	"notes": func(s introspectionFileSystem) ([]byte, error) {
+		commit, err := s.git.ResolveCommit(s.reference)
+		if err != nil {
+			return nil, err
+		}
+		note, err := s.git.ReadNote(NotesRef, commit)
+		if errors.Is(err, ErrNoNote) {
+			return nil, nil
+		}
+		return note, err
+	},
+	// epoch counts the times what is served changed, see readEpoch.
+	EpochFile: func(s introspectionFileSystem) ([]byte, error) {
+		contents, _, err := s.readEpoch()
+		return contents, err
+	},
+	// CHANGELOG.txt lists the commits made since the ref last moved, see readChangelog.
+	ChangelogFile: func(s introspectionFileSystem) ([]byte, error) {
+		return s.readChangelog()
+	},
+	// handles lists the files read from the repository that are open and the memory their blobs pin.
+	"handles": func(s introspectionFileSystem) ([]byte, error) {
+		contents, err := json.MarshalIndent(NewHandleReport(OpenHandles(s.git)), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(contents, '\n'), nil
+	},
+	// stats.json describes the repository as a whole for dashboards that scrape mounts.
+	"stats.json": func(s introspectionFileSystem) ([]byte, error) {
+		commit, err := s.git.ResolveCommit(s.reference)
+		if err != nil {
+			return nil, err
+		}
+		stats := repositoryStats{
+			ID:         SnapshotID(s.repository, s.reference, commit, s.filters),
+			Commit:     commit,
+			Backend:    Backend(s.git),
+			FusePanics: FusePanics(),
+			GitRetries: gitism.Retries(),
+			Labels:     s.label,
+		}
+		// HEAD doesn't resolve in repositories whose default branch has no commits yet.
+		stats.Head, _ = s.git.ResolveCommit(CommitRef("HEAD"))
+
+		err = s.git.ListBranches(func(string) error {
+			stats.Branches++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = s.git.ListTags(func(string) error {
+			stats.Tags++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		counts, err := s.git.CountObjects()
+		if err != nil {
+			return nil, err
+		}
+		stats.Objects = objectStats{
+			Loose:          counts.Loose,
+			LooseSizeKiB:   counts.LooseSize,
+			Packed:         counts.Packed,
+			Packs:          counts.Packs,
+			PackSizeKiB:    counts.PackSize,
+			Garbage:        counts.Garbage,
+			GarbageSizeKiB: counts.GarbageSize,
+		}
+
+		if git, ok := s.git.(interface {
+			linkedObjects() ([]string, int, error)
+		}); ok {
+			stats.Alternates, stats.ReplaceRefs, err = git.linkedObjects()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if git, ok := s.git.(interface{ fetchStats() (FetchStats, bool) }); ok {
+			if fetches, ok := git.fetchStats(); ok {
+				stats.Fetches = &fetches
+			}
+		}
+		if git, ok := s.git.(interface{ cacheStats() (CacheStats, bool) }); ok {
+			if cache, ok := git.cacheStats(); ok {
+				stats.Cache = &cache
+			}
+		}
+		if git, ok := s.git.(interface{ budgetStats() (MemoryStats, bool) }); ok {
+			if memory, ok := git.budgetStats(); ok {
+				stats.Memory = &memory
+			}
+		}
+
+		contents, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(contents, '\n'), nil
+	},
+}
+
+// repositoryStats is the contents of .gitfs/stats.json.
+type repositoryStats struct {
+	// ID is the SnapshotID of what is being served, for labelling what is scraped from each mount.
+	ID string `json:"id"`
+	// Commit is the commit being served and Head is the commit HEAD points to in the repository.
+	Commit string `json:"commit"`
+	Head   string `json:"head,omitempty"`
+	// Backend is how the repository is read, see Backend.
+	Backend  string      `json:"backend,omitempty"`
+	Branches int         `json:"branches"`
+	Tags     int         `json:"tags"`
+	Objects  objectStats `json:"objects"`
+	// Alternates are the object directories of other repositories objects are borrowed from, and ReplaceRefs counts
+	// the objects git reads others in place of.
+	Alternates  []string `json:"alternates,omitempty"`
+	ReplaceRefs int      `json:"replace_refs"`
+	// Fetches is only reported when missing refs and objects are fetched.
+	Fetches *FetchStats `json:"fetches,omitempty"`
+	// Cache is only reported when blobs and listings are cached.
+	Cache *CacheStats `json:"cache,omitempty"`
+	// Memory is only reported when there is a memory budget.
+	Memory *MemoryStats `json:"memory,omitempty"`
+	// FusePanics is FusePanics, so dashboards notice a mount that keeps serving through bugs.
+	FusePanics int64 `json:"fuse_panics"`
+	// GitRetries tells commands that succeeded once maintenance let go of the repository apart from those that
+	// failed because it never did.
+	GitRetries gitism.RetryStats `json:"git_retries"`
+	// Labels are those of the mount and repository the stats were read through, see
+	// NewIntrospectionFileSystemWithFilters.
+	Labels string `json:"labels,omitempty"`
+}
+
+type objectStats struct {
+	Loose          int64 `json:"loose"`
+	LooseSizeKiB   int64 `json:"loose_size_kib"`
+	Packed         int64 `json:"packed"`
+	Packs          int64 `json:"packs"`
+	PackSizeKiB    int64 `json:"pack_size_kib"`
+	Garbage        int64 `json:"garbage"`
+	GarbageSizeKiB int64 `json:"garbage_size_kib"`
+}
+
+type introspectionInfo struct {
+	name string
+	mode os.FileMode
+	size int64
+	// modTime is the epoch of Unix time when unset.
+	modTime time.Time
+}
+
+func (i introspectionInfo) Name() string {
+	return i.name
+}
+
+func (i introspectionInfo) Size() int64 {
+	return i.size
+}
+
+func (i introspectionInfo) Mode() fs.FileMode {
+	return i.mode
+}
+
+func (i introspectionInfo) ModTime() time.Time {
+	if i.modTime.IsZero() {
+		return time.Unix(0, 0)
+	}
+	return i.modTime
+}
+
+func (i introspectionInfo) IsDir() bool {
+	return i.mode.IsDir()
+}
+
+func (i introspectionInfo) Sys() interface{} {
+	return nil
+}
+
+// introspectionFileSystem overlays virtual files at /.gitfs/ describing the version of the tree being served so build
+// systems can stamp what they produce from the mount.
+type introspectionFileSystem struct {
+	billy.Filesystem
+	git        Git
+	reference  Ref
+	repository string
+	label      string
+	filters    SnapshotFilters
+	meta       *pathMetadata
+}
+
+// NewIntrospectionFileSystem exposes .gitfs/commit, .gitfs/describe, .gitfs/id, .gitfs/notes, and .gitfs/stats.json
+// for reference on top of fs, along with .gitfs/epoch growing whenever what is served changes, .gitfs/CHANGELOG.txt
+// listing the commits made since reference last moved, .gitfs/handles listing the files open in the process, and
+// .gitfs/meta/<path>.json describing the last commit that changed each path.
+func NewIntrospectionFileSystem(fs billy.Filesystem, git Git, reference Ref) billy.Filesystem {
+	return NewIntrospectionFileSystemWithFilters(fs, git, reference, "", "", SnapshotFilters{})
+}
+
+// NewIntrospectionFileSystemWithFilters is NewIntrospectionFileSystem with .gitfs/id identifying repository, named
+// like RepositoryName, and the filters fs was built with. .gitfs/stats.json is labelled with label, the mount and
+// repository fs is served as, see JoinLabels.
+func NewIntrospectionFileSystemWithFilters(fs billy.Filesystem, git Git, reference Ref, repository, label string, filters SnapshotFilters) billy.Filesystem {
+	return introspectionFileSystem{
+		Filesystem: fs,
+		git:        git,
+		reference:  reference,
+		repository: repository,
+		label:      label,
+		filters:    filters,
+		meta:       newPathMetadata(),
+	}
+}
+
+// lookup maps filename to a path under /.gitfs/. ok is false for paths outside of /.gitfs/ and name is empty for the
+// directory itself.
+func (s introspectionFileSystem) lookup(filename string) (name string, ok bool, err error) {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil {
+		return "", false, err
+	}
+	if len(resolved.Path) == 0 || resolved.Path[0] != IntrospectionDirectory {
+		return "", false, nil
+	}
+
+	switch len(resolved.Path) {
+	case 1:
+		return "", true, nil
+	case 2:
+		if _, exists := introspectionFiles[resolved.Path[1]]; exists {
+			return resolved.Path[1], true, nil
+		}
+	}
+	return "", true, fs.ErrNotExist
+}
+
+func (s introspectionFileSystem) directoryInfo() os.FileInfo {
+	return introspectionInfo{name: IntrospectionDirectory, mode: os.ModeDir | 0555}
+}
+
+func (s introspectionFileSystem) read(name string) ([]byte, os.FileInfo, error) {
+	if name == EpochFile {
+		return s.readEpoch()
+	}
+	contents, err := introspectionFiles[name](s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return contents, introspectionInfo{name: name, mode: 0444, size: int64(len(contents))}, nil
+}
+
+func (s introspectionFileSystem) stat(name string) (os.FileInfo, error) {
+	if name == "" {
+		return s.directoryInfo(), nil
+	}
+	_, info, err := s.read(name)
+	return info, err
+}
+
+func (s introspectionFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s introspectionFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if path, ok, err := s.lookupMeta(filename); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return s.metaOpen(filename, path, flag)
+	}
+	name, ok, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+	log.Printf("introspection OpenFile(%s)\n", RedactPath(filename))
+
+	if flag != os.O_RDONLY {
+		return nil, billy.ErrReadOnly
+	}
+	if name == "" {
+		return nil, fs.ErrInvalid
+	}
+
+	contents, _, err := s.read(name)
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryFile(filename, contents), nil
+}
+
+func (s introspectionFileSystem) Stat(filename string) (os.FileInfo, error) {
+	if path, ok, err := s.lookupMeta(filename); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return s.metaStat(path)
+	}
+	name, ok, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.Filesystem.Stat(filename)
+	}
+	return s.stat(name)
+}
+
+func (s introspectionFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	if path, ok, err := s.lookupMeta(filename); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return s.metaStat(path)
+	}
+	name, ok, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.Filesystem.Lstat(filename)
+	}
+	return s.stat(name)
+}
+
+func (s introspectionFileSystem) ReadDir(filename string) ([]os.FileInfo, error) {
+	if path, ok, err := s.lookupMeta(filename); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return s.metaReadDir(path)
+	}
+	name, ok, err := s.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		files, err := s.Filesystem.ReadDir(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		root := RootGitPath()
+		resolved, err := root.Resolve(filename)
+		if err != nil || !resolved.IsRoot() {
+			return files, err
+		}
+
+		// Drop the repository's own .gitfs so the listing matches what Stat returns.
+		listing := make([]os.FileInfo, 0, len(files)+1)
+		for _, file := range files {
+			if file.Name() != IntrospectionDirectory {
+				listing = append(listing, file)
+			}
+		}
+		return append(listing, s.directoryInfo()), nil
+	}
+
+	if name != "" {
+		return nil, fs.ErrInvalid
+	}
+
+	names := make([]string, 0, len(introspectionFiles)+1)
+	for name := range introspectionFiles {
+		names = append(names, name)
+	}
+	names = append(names, MetaDirectory)
+	sort.Strings(names)
+
+	var files []os.FileInfo
+	for _, name := range names {
+		if name == MetaDirectory {
+			files = append(files, introspectionInfo{name: MetaDirectory, mode: os.ModeDir | 0555})
+			continue
+		}
+		info, err := s.stat(name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+func (s introspectionFileSystem) Readlink(link string) (string, error) {
+	if _, ok, err := s.lookupMeta(link); ok || err != nil {
+		if err != nil {
+			return "", err
+		}
+		return "", fs.ErrInvalid
+	}
+	_, ok, err := s.lookup(link)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return s.Filesystem.Readlink(link)
+	}
+	return "", fs.ErrInvalid
+}
+
+func (s introspectionFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	if _, ok, err := s.lookupMeta(path); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return nil, billy.ErrNotSupported
+	}
+	_, ok, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, billy.ErrNotSupported
+	}
+	return s.Filesystem.Chroot(path)
+}
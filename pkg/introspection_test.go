@@ -0,0 +1,327 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"encoding/json"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestIntrospection(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "tags")
+	reference := BranchRef("master")
+	fs := NewIntrospectionFileSystem(NewReferenceFileSystem(git, reference), git, reference)
+
+	read := func(t *testing.T, filename string) string {
+		file, err := fs.Open(filename)
+		if err != nil {
+			t.Fatalf("Open(%s) failed: %v", filename, err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", filename, err)
+		}
+		info, err := fs.Stat(filename)
+		if err != nil {
+			t.Fatalf("Stat(%s) failed: %v", filename, err)
+		}
+		if info.Size() != int64(len(contents)) {
+			t.Fatalf("Stat(%s) reported %d bytes but read %d", filename, info.Size(), len(contents))
+		}
+		return string(contents)
+	}
+
+	t.Run("listing", func(t *testing.T) {
+		paths, err := fs.ReadDir(".")
+		if err != nil {
+			t.Fatalf("ReadDir(.) failed: %v", err)
+		}
+		pathsMap := fileMap(paths)
+		if info, ok := pathsMap[IntrospectionDirectory]; !ok || !info.IsDir() {
+			t.Fatalf("root listing is missing %s: %v", IntrospectionDirectory, paths)
+		}
+
+		paths, err = fs.ReadDir(IntrospectionDirectory)
+		if err != nil {
+			t.Fatalf("ReadDir(%s) failed: %v", IntrospectionDirectory, err)
+		}
+		var names []string
+		for _, path := range paths {
+			names = append(names, path.Name())
+		}
+		if strings.Join(names, " ") != "CHANGELOG.txt commit describe epoch handles id meta notes stats.json" {
+			t.Fatalf("%s contained %v", IntrospectionDirectory, paths)
+		}
+	})
+
+	t.Run("commit and describe", func(t *testing.T) {
+		commit := strings.TrimSpace(read(t, ".gitfs/commit"))
+		if len(commit) != 40 {
+			t.Fatalf(".gitfs/commit contained %q", commit)
+		}
+
+		describe := strings.TrimSpace(read(t, ".gitfs/describe"))
+		abbreviated := strings.TrimPrefix(describe, "v1.0-1-g")
+		if abbreviated == describe || !strings.HasPrefix(commit, abbreviated) {
+			t.Fatalf(".gitfs/describe contained %q for commit %s", describe, commit)
+		}
+	})
+
+	t.Run("id", func(t *testing.T) {
+		commit := strings.TrimSpace(read(t, ".gitfs/commit"))
+		if id := strings.TrimSpace(read(t, ".gitfs/id")); id != SnapshotID("", reference, commit, SnapshotFilters{}) {
+			t.Fatalf(".gitfs/id contained %q", id)
+		}
+
+		filters := SnapshotFilters{MaxFileSize: 1024}
+		filtered := NewIntrospectionFileSystemWithFilters(NewReferenceFileSystem(git, reference), git, reference, "tags", "", filters)
+		file, err := filtered.Open(".gitfs/id")
+		if err != nil {
+			t.Fatalf("Open(.gitfs/id) failed: %v", err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id := strings.TrimSpace(string(contents)); id != SnapshotID("tags", reference, commit, filters) {
+			t.Fatalf(".gitfs/id contained %q with filters", id)
+		}
+	})
+
+	t.Run("notes", func(t *testing.T) {
+		if notes := read(t, ".gitfs/notes"); notes != "" {
+			t.Fatalf(".gitfs/notes should be empty for a commit without a note: %q", notes)
+		}
+
+		reference := TagRef("v1.0")
+		fs := NewIntrospectionFileSystem(NewReferenceFileSystem(git, reference), git, reference)
+		file, err := fs.Open(".gitfs/notes")
+		if err != nil {
+			t.Fatalf("Open(.gitfs/notes) failed: %v", err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(contents) != "Released as v1.0\n" {
+			t.Fatalf(".gitfs/notes contained %q", contents)
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		var stats repositoryStats
+		if err := json.Unmarshal([]byte(read(t, ".gitfs/stats.json")), &stats); err != nil {
+			t.Fatalf(".gitfs/stats.json is not valid JSON: %v", err)
+		}
+		commit := strings.TrimSpace(read(t, ".gitfs/commit"))
+		if stats.ID != strings.TrimSpace(read(t, ".gitfs/id")) {
+			t.Fatalf(".gitfs/stats.json reported id %q", stats.ID)
+		}
+		if stats.Commit != commit || stats.Head != commit || stats.Branches != 1 || stats.Tags != 1 {
+			t.Fatalf(".gitfs/stats.json contained %+v", stats)
+		}
+		if stats.Objects.Loose+stats.Objects.Packed == 0 {
+			t.Fatalf(".gitfs/stats.json counted no objects: %+v", stats.Objects)
+		}
+		if !strings.HasPrefix(stats.Backend, "git ") {
+			t.Fatalf(".gitfs/stats.json reported backend %q", stats.Backend)
+		}
+	})
+
+	t.Run("handles", func(t *testing.T) {
+		handles := func() HandleReport {
+			var report HandleReport
+			if err := json.Unmarshal([]byte(read(t, ".gitfs/handles")), &report); err != nil {
+				t.Fatalf(".gitfs/handles is not valid JSON: %v", err)
+			}
+			return report
+		}
+		opened := func(report HandleReport) bool {
+			for _, handle := range report.Handles {
+				if handle.Path == "real.txt" && handle.Ref == reference.String() && handle.Bytes > 0 {
+					return true
+				}
+			}
+			return false
+		}
+
+		file, err := fs.Open("real.txt")
+		if err != nil {
+			t.Fatalf("Open(real.txt) failed: %v", err)
+		}
+		if report := handles(); !opened(report) || report.Blobs == 0 || report.Bytes == 0 {
+			file.Close()
+			t.Fatalf(".gitfs/handles is missing the open real.txt: %+v", report)
+		}
+		file.Close()
+		if report := handles(); opened(report) {
+			t.Fatalf(".gitfs/handles still lists real.txt after it was closed: %+v", report)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := fs.Stat(".gitfs/missing"); err == nil {
+			t.Fatalf("Stat(.gitfs/missing) should fail")
+		}
+		if _, err := fs.Open(".gitfs"); err == nil {
+			t.Fatalf("Open(.gitfs) should fail")
+		}
+	})
+
+	t.Run("unknown reference", func(t *testing.T) {
+		reference := BranchRef("missing")
+		fs := NewIntrospectionFileSystem(NewReferenceFileSystem(git, reference), git, reference)
+		if _, err := fs.Open(".gitfs/commit"); err == nil {
+			t.Fatalf("Open(.gitfs/commit) should fail for a branch that does not exist")
+		}
+	})
+}
+
+func TestEpoch(t *testing.T) {
+	gitDirectory, err := runPlaybook("tags", t.TempDir())
+	if err != nil {
+		t.Fatalf("playbook 'tags' failed: %v", err)
+	}
+	git, err := NewCliGit(gitDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reference := BranchRef("master")
+	fs := NewSwappableFileSystem(NewIntrospectionFileSystemWithFilters(NewReferenceFileSystem(git, reference), git, reference, gitDirectory, "", SnapshotFilters{}))
+
+	epoch := func() int64 {
+		file, err := fs.Open(".gitfs/epoch")
+		if err != nil {
+			t.Fatalf("Open(.gitfs/epoch) failed: %v", err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		epoch, err := strconv.ParseInt(strings.TrimSpace(string(contents)), 10, 64)
+		if err != nil {
+			t.Fatalf(".gitfs/epoch contained %q", contents)
+		}
+		info, err := fs.Stat(".gitfs/epoch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ModTime().Unix() != epoch {
+			t.Fatalf(".gitfs/epoch was modified at %v, expected the epoch %d", info.ModTime(), epoch)
+		}
+		return epoch
+	}
+
+	first := epoch()
+	if again := epoch(); again != first {
+		t.Fatalf("the epoch went from %d to %d while nothing changed", first, again)
+	}
+	if output, err := exec.Command("git", "--git-dir", gitDirectory, "update-ref", "refs/heads/master", "v1.0^{commit}").CombinedOutput(); err != nil {
+		t.Fatalf("moving master failed: %v: %s", err, output)
+	}
+	if moved := epoch(); moved != first+1 {
+		t.Fatalf("the epoch went from %d to %d when master moved, expected it to advance once", first, moved)
+	}
+	fs.Swap(fs.current())
+	if reloaded := epoch(); reloaded != first+2 {
+		t.Fatalf("the epoch went from %d to %d when the filesystem was swapped, expected it to advance again", first+1, reloaded)
+	}
+}
+
+func TestChangelog(t *testing.T) {
+	git := NewFakeGit(nil)
+	git.Commit("master", "Add a", map[string]FakeFile{"a": {Contents: "a"}})
+	first := git.Commit("master", "Change a", map[string]FakeFile{"a": {Contents: "b"}})
+	reference := BranchRef("master")
+	fs := NewIntrospectionFileSystemWithFilters(NewReferenceFileSystem(git, reference), git, reference, t.Name(), "", SnapshotFilters{})
+
+	changelog := func() string {
+		t.Helper()
+		file, err := fs.Open(".gitfs/CHANGELOG.txt")
+		if err != nil {
+			t.Fatalf("Open(.gitfs/CHANGELOG.txt) failed: %v", err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(contents)
+	}
+
+	if got := changelog(); got != "" {
+		t.Fatalf("the changelog before master moved was %q", got)
+	}
+
+	side := git.Commit("side", "Start a side branch", map[string]FakeFile{"b": {Contents: "b"}})
+	second := git.Commit("master", "Change a again\n\nWith a body.", map[string]FakeFile{"a": {Contents: "c"}})
+	if _, err := git.Merge("master", "side", "Merge side", map[string]FakeFile{"a": {Contents: "c"}, "b": {Contents: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	merge, _ := git.ResolveCommit(reference)
+	want := first + ".." + merge + "\n" + merge + " Merge side\n" + second + " Change a again\n"
+	if got := changelog(); got != want {
+		t.Fatalf("the changelog after master moved was %q, expected %q without %s from the side branch", got, want, side)
+	}
+	if got := changelog(); got != want {
+		t.Fatalf("reading the changelog again gave %q", got)
+	}
+
+	if err := git.Branch("master", side); err != nil {
+		t.Fatal(err)
+	}
+	if got := changelog(); !strings.HasPrefix(got, merge+".."+side+"\n"+side+" Start a side branch\n") || !strings.Contains(got, "history was rewritten") {
+		t.Fatalf("the changelog after master was rewritten was %q", got)
+	}
+
+	t.Run("followed", func(t *testing.T) {
+		gitDirectory, err := runPlaybook("tags", t.TempDir())
+		if err != nil {
+			t.Fatalf("playbook 'tags' failed: %v", err)
+		}
+		git, err := NewCliGit(gitDirectory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reference := CommitRef("master")
+		fs := NewIntrospectionFileSystemWithFilters(NewReferenceFileSystem(git, reference), git, reference, gitDirectory, "", SnapshotFilters{})
+		FollowRef(gitDirectory, CommitRef("v1.0"), reference)
+
+		master, err := git.ResolveCommit(reference)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := fs.Open(".gitfs/CHANGELOG.txt")
+		if err != nil {
+			t.Fatalf("Open(.gitfs/CHANGELOG.txt) failed: %v", err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "v1.0.." + master + "\n" + master + " Change a normal file\n"; string(contents) != want {
+			t.Fatalf("the changelog of a followed ref was %q, expected %q", contents, want)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"io"
+	"log"
+)
+
+// Labels tag what is logged and traced for a mount or repository, so the load of one process serving several of them
+// can be attributed. They are handed to what serves each one when it is built: LabelLogger for loggers,
+// Tracer.Labelled for traces, AccessLog.Labelled for access logs, and FuseOptions.Label for FUSE operations. Labels
+// nest, so a repository served by a mount is labelled with both as mount/repository.
+
+// JoinLabels nests label under outer, like a repository under the mount serving it.
+func JoinLabels(outer, label string) string {
+	if outer == "" {
+		return label
+	}
+	if label == "" {
+		return outer
+	}
+	return outer + "/" + label
+}
+
+// labelledWriter prefixes every write with a label in brackets. Loggers write a line at a time, so every line logged
+// through it is labelled.
+type labelledWriter struct {
+	writer io.Writer
+	label  string
+}
+
+// LabelLogger is logger with every line prefixed by label. logger is returned as is when label is empty.
+func LabelLogger(logger *log.Logger, label string) *log.Logger {
+	if logger == nil || label == "" {
+		return logger
+	}
+	return log.New(labelledWriter{writer: logger.Writer(), label: label}, logger.Prefix(), logger.Flags())
+}
+
+func (w labelledWriter) Write(p []byte) (int, error) {
+	if _, err := w.writer.Write(append([]byte("["+w.label+"] "), p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/jacobsa/fuse/fuseops"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLabelLogger(t *testing.T) {
+	output := new(bytes.Buffer)
+	logger := log.New(output, "fuse: ", 0)
+
+	if LabelLogger(logger, "") != logger {
+		t.Fatal("LabelLogger() with no label should return the logger as is")
+	}
+	LabelLogger(logger, JoinLabels("mount", "repository")).Print("nested")
+	LabelLogger(logger, JoinLabels("", "repository")).Print("repository")
+	logger.Print("unlabelled")
+
+	expected := "[mount/repository] fuse: nested\n[repository] fuse: repository\nfuse: unlabelled\n"
+	if output.String() != expected {
+		t.Fatalf("logged %q, expected %q", output, expected)
+	}
+}
+
+func TestLabelledTrace(t *testing.T) {
+	output := new(bytes.Buffer)
+	tracer := NewTracer(log.New(output, "", 0))
+	gitDirectory, err := runPlaybook("base", t.TempDir())
+	if err != nil {
+		t.Fatalf("playbook 'base' failed: %v", err)
+	}
+	git, err := NewCliGit(gitDirectory, WithTracer(tracer.Labelled("main").Labelled("docs")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessLog := new(bytes.Buffer)
+	repositories := NewRepositoriesFileSystem(0)
+	repository := NewAccessLog(accessLog, git, BranchRef("master")).Labelled("main").Labelled("docs").FileSystem(NewReferenceFileSystem(git, BranchRef("master")), "uid:0")
+	if _, err := repositories.AddRepository("docs", repository); err != nil {
+		t.Fatal(err)
+	}
+	fuse, err := NewBillyFuseWithOptions(repositories, FuseOptions{Tracer: tracer, Label: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookUp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "docs"}
+	if err := fuse.LookUpInode(context.Background(), lookUp); err != nil {
+		t.Fatal(err)
+	}
+	open := &fuseops.OpenDirOp{Inode: lookUp.Entry.Child}
+	if err := fuse.OpenDir(context.Background(), open); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		if !strings.HasPrefix(line, "[main] ") && !strings.HasPrefix(line, "[main/docs] ") {
+			t.Fatalf("trace line %q isn't labelled with the mount", line)
+		}
+	}
+	if !strings.Contains(output.String(), "[main/docs] [2]   git ") {
+		t.Fatalf("the git commands run for docs aren't labelled with it:\n%s", output)
+	}
+	var entry AccessEntry
+	if err := json.Unmarshal(accessLog.Bytes(), &entry); err != nil || entry.Labels != "main/docs" {
+		t.Fatalf("access log entry %+v, %v, expected it to be labelled main/docs", entry, err)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// LinkFarmStats counts what LinkFarm did.
+type LinkFarmStats struct {
+	// Files counts the files linked into the farm.
+	Files int
+	// Stored and StoredBytes count the blobs that weren't in the store yet and were written to it.
+	Stored      int
+	StoredBytes int64
+}
+
+// LinkFarmOptions customize LinkFarm.
+type LinkFarmOptions struct {
+	// Jobs is how many git commands read blobs at once. 0 runs one per CPU.
+	Jobs int
+}
+
+// BlobStorePath is where LinkFarm keeps the blob named by hash in store. Executables are kept apart from other files
+// since every hard link to a file shares its mode.
+func BlobStorePath(store, hash string, executable bool) string {
+	name := hash[2:]
+	if executable {
+		name += ".x"
+	}
+	return filepath.Join(store, hash[:2], name)
+}
+
+// LinkFarm materializes the tree of ref into dst, which must not exist yet, as hard links to read-only files in store,
+// a directory of blobs named by hash. Only blobs that aren't in store yet are read from git, so farms of many commits
+// take about as long as a checkout of what changed between them and share the storage of everything else. store
+// must be on the same filesystem as dst. Symlinks are created as symlinks and submodules as empty directories. ref
+// is resolved once, so the farm is of a single commit even if ref moves while it is built.
+func LinkFarm(git Git, ref Ref, store, dst string, options LinkFarmOptions) (LinkFarmStats, error) {
+	commit, err := git.ResolveCommit(ref)
+	if err != nil {
+		return LinkFarmStats{}, err
+	}
+	if err := os.Mkdir(dst, 0755); err != nil {
+		return LinkFarmStats{}, err
+	}
+
+	var stats LinkFarmStats
+	// missing are the blobs to read from git, each with where it goes: the store for files and the farm for symlinks.
+	var missing []gitism.TreeEntry
+	var targets []string
+	stored := map[string]bool{}
+	var links [][2]string
+	err = git.ListTreeRecursive(GitPath{Reference: CommitRef(commit)}, func(entry gitism.TreeEntry) error {
+		target := filepath.Join(dst, filepath.FromSlash(entry.Path))
+		switch entry.Mode.Type {
+		case gitism.Gitlink:
+			return os.MkdirAll(target, 0755)
+		case gitism.Symlink:
+			missing = append(missing, entry)
+			targets = append(targets, target)
+			return nil
+		}
+
+		blob := BlobStorePath(store, entry.Hash, entry.Mode.Perms&0111 != 0)
+		links = append(links, [2]string{blob, target})
+		if stored[blob] {
+			return nil
+		}
+		stored[blob] = true
+		if _, err := os.Lstat(blob); err == nil {
+			return nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		missing = append(missing, entry)
+		targets = append(targets, blob)
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for _, batch := range splitCopy(missing, targets, options.Jobs) {
+		wg.Add(1)
+		go func(batch *copyBatch) {
+			defer wg.Done()
+			i := 0
+			err := git.ReadBlobs(batch.hashes, func(hash string, contents []byte) error {
+				mu.Lock()
+				stopped := firstErr != nil
+				mu.Unlock()
+				if stopped {
+					return errCopyStopped
+				}
+
+				entry, target := batch.entries[i], batch.targets[i]
+				i++
+				if entry.Mode.Type == gitism.Symlink {
+					return writeCopy(entry, target, contents)
+				}
+				if err := storeBlob(target, contents, entry.Mode.Perms&0111 != 0); err != nil {
+					return err
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				stats.Stored++
+				stats.StoredBytes += int64(len(contents))
+				return nil
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil && !errors.Is(err, errCopyStopped) {
+				firstErr = err
+			}
+		}(batch)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return stats, firstErr
+	}
+
+	for _, link := range links {
+		if err := os.MkdirAll(filepath.Dir(link[1]), 0755); err != nil {
+			return stats, err
+		}
+		if err := os.Link(link[0], link[1]); err != nil {
+			if errors.Is(err, syscall.EXDEV) {
+				return stats, fmt.Errorf("the blob store must be on the same filesystem as %s: %w", dst, err)
+			}
+			return stats, err
+		}
+		stats.Files++
+	}
+	return stats, nil
+}
+
+// storeBlob writes contents to path in a blob store as a read-only file. It is written under a temporary name first
+// so farms being built at the same time never link to a partially written blob.
+func storeBlob(path string, contents []byte, executable bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		return err
+	}
+	var perm os.FileMode = 0444
+	if executable {
+		perm = 0555
+	}
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
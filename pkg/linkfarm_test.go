@@ -0,0 +1,83 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkFarm(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "base")
+	master := BranchRef("master")
+	store := filepath.Join(t.TempDir(), "store")
+	farms := t.TempDir()
+
+	first := filepath.Join(farms, "first")
+	stats, err := LinkFarm(git, master, store, first, LinkFarmOptions{Jobs: 2})
+	if err != nil {
+		t.Fatalf("LinkFarm() failed: %v", err)
+	}
+	// symlink.txt and test/escaping.txt are symlinks, which aren't stored.
+	if stats.Files != 3 || stats.Stored != 3 || stats.StoredBytes == 0 {
+		t.Fatalf("LinkFarm() returned %+v", stats)
+	}
+
+	t.Run("contents", func(t *testing.T) {
+		if contents, err := os.ReadFile(filepath.Join(first, "test", "nested.txt")); err != nil || string(contents) != "Nested file\n" {
+			t.Fatalf("test/nested.txt contained %q, %v", contents, err)
+		}
+		if info, err := os.Stat(filepath.Join(first, "executable.sh")); err != nil || info.Mode().Perm() != 0555 {
+			t.Fatalf("executable.sh was linked with %v, %v", info.Mode(), err)
+		}
+		if info, err := os.Stat(filepath.Join(first, "real.txt")); err != nil || info.Mode().Perm() != 0444 {
+			t.Fatalf("real.txt was linked with %v, %v", info.Mode(), err)
+		}
+		if target, err := os.Readlink(filepath.Join(first, "symlink.txt")); err != nil || target != "real.txt" {
+			t.Fatalf("symlink.txt pointed at %q, %v", target, err)
+		}
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		second := filepath.Join(farms, "second")
+		stats, err := LinkFarm(git, master, store, second, LinkFarmOptions{})
+		if err != nil {
+			t.Fatalf("LinkFarm() failed: %v", err)
+		}
+		if stats.Files != 3 || stats.Stored != 0 {
+			t.Fatalf("LinkFarm() of a commit already in the store returned %+v", stats)
+		}
+		a, err := os.Stat(filepath.Join(first, "test", "nested.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.Stat(filepath.Join(second, "test", "nested.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !os.SameFile(a, b) {
+			t.Fatal("test/nested.txt of both farms should be the same file in the store")
+		}
+	})
+
+	t.Run("existing", func(t *testing.T) {
+		if _, err := LinkFarm(git, master, store, first, LinkFarmOptions{}); !errors.Is(err, fs.ErrExist) {
+			t.Fatalf("LinkFarm() into an existing directory returned %v, expected %v", err, fs.ErrExist)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// ListingTag identifies the contents of a directory listing, so clients that cached a listing can ask whether it
+// changed without transferring it again. Like a git tree hash, it covers the name, mode, and object hash of every
+// entry, and the size and modification time of entries that don't come straight from git. Listings only share a tag
+// when they are the same.
+func ListingTag(infos []os.FileInfo) string {
+	hash := sha256.New()
+	for _, info := range infos {
+		if object, ok := ObjectHash(info); ok && object != "" {
+			fmt.Fprintf(hash, "git %o %s %s\x00", uint32(info.Mode()), object, info.Name())
+			continue
+		}
+		fmt.Fprintf(hash, "file %o %d %d %s\x00", uint32(info.Mode()), info.Size(), info.ModTime().UnixNano(), info.Name())
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
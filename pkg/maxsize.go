@@ -0,0 +1,67 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"io/fs"
+	"log"
+	"os"
+	"syscall"
+)
+
+// ErrFileTooLarge is returned in an *fs.PathError when opening a file larger than the limit given to
+// NewMaxFileSizeFileSystem.
+var ErrFileTooLarge error = syscall.EFBIG
+
+// maxFileSizeFileSystem refuses to open files larger than limit so they are never read into memory. Stat and ReadDir
+// still report their real size.
+type maxFileSizeFileSystem struct {
+	billy.Filesystem
+	limit int64
+}
+
+// NewMaxFileSizeFileSystem refuses to open files in fs larger than limit bytes. A limit of 0 or less returns fs.
+func NewMaxFileSizeFileSystem(fs billy.Filesystem, limit int64) billy.Filesystem {
+	if limit <= 0 {
+		return fs
+	}
+	return maxFileSizeFileSystem{
+		Filesystem: fs,
+		limit:      limit,
+	}
+}
+
+func (s maxFileSizeFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s maxFileSizeFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	info, err := s.Filesystem.Stat(filename)
+	if err == nil && info.Mode().IsRegular() && info.Size() > s.limit {
+		log.Printf("refusing to open %s: %d bytes is over the limit of %d\n", RedactPath(filename), info.Size(), s.limit)
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: ErrFileTooLarge}
+	}
+	return s.Filesystem.OpenFile(filename, flag, perm)
+}
+
+// Chroot keeps the limit in the new root.
+func (s maxFileSizeFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	fs, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewMaxFileSizeFileSystem(fs, s.limit), nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"io"
+	"syscall"
+	"testing"
+)
+
+func TestMaxFileSizeFileSystem(t *testing.T) {
+	backing := memfs.New()
+	for name, contents := range map[string]string{
+		"data/small.txt": "0123456789",
+		"data/large.bin": "0123456789a",
+	} {
+		if err := util.WriteFile(backing, name, []byte(contents), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	fs := NewMaxFileSizeFileSystem(backing, 10)
+
+	t.Run("under the limit", func(t *testing.T) {
+		file, err := fs.Open("data/small.txt")
+		if err != nil {
+			t.Fatalf("Open(data/small.txt) failed: %v", err)
+		}
+		contents, err := io.ReadAll(file)
+		if err != nil || string(contents) != "0123456789" {
+			t.Fatalf("data/small.txt contained %q, %v", contents, err)
+		}
+	})
+
+	t.Run("over the limit", func(t *testing.T) {
+		if _, err := fs.Open("data/large.bin"); !errors.Is(err, ErrFileTooLarge) || !errors.Is(err, syscall.EFBIG) {
+			t.Fatalf("Open(data/large.bin) should fail with ErrFileTooLarge: %v", err)
+		}
+
+		info, err := fs.Stat("data/large.bin")
+		if err != nil || info.Size() != 11 {
+			t.Fatalf("Stat(data/large.bin) should report the real size: %v, %v", info, err)
+		}
+	})
+
+	t.Run("chroot", func(t *testing.T) {
+		chrooted, err := fs.Chroot("data")
+		if err != nil {
+			t.Fatalf("Chroot(data) failed: %v", err)
+		}
+		if _, err := chrooted.Open("large.bin"); !errors.Is(err, ErrFileTooLarge) {
+			t.Fatalf("Open(large.bin) should fail with ErrFileTooLarge after Chroot: %v", err)
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		if NewMaxFileSizeFileSystem(backing, 0) != backing {
+			t.Fatalf("a limit of 0 should not wrap the filesystem")
+		}
+	})
+}
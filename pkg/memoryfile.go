@@ -0,0 +1,56 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"github.com/go-git/go-billy/v5"
+)
+
+// memoryFile is a read-only billy.File for contents generated by gitfs rather than read from a blob.
+type memoryFile struct {
+	*bytes.Reader
+	name string
+}
+
+func (f memoryFile) Name() string {
+	return f.name
+}
+
+func (f memoryFile) Write(p []byte) (n int, err error) {
+	_ = p
+	return 0, billy.ErrNotSupported
+}
+
+func (f memoryFile) Close() error {
+	return nil
+}
+
+func (f memoryFile) Lock() error {
+	return billy.ErrNotSupported
+}
+
+func (f memoryFile) Unlock() error {
+	return billy.ErrNotSupported
+}
+
+func (f memoryFile) Truncate(size int64) error {
+	_ = size
+	return billy.ErrNotSupported
+}
+
+func newMemoryFile(name string, contents []byte) memoryFile {
+	return memoryFile{Reader: bytes.NewReader(contents), name: name}
+}
@@ -0,0 +1,244 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MetaDirectory is where the introspection filesystem describes the last commit that changed each path of the
+	// repository, at .gitfs/meta/<path>.json.
+	MetaDirectory = "meta"
+	// metaSuffix is added to the name of a path to name the file describing it.
+	metaSuffix = ".json"
+	// maxCachedMetadata bounds how many paths' metadata is remembered before starting over.
+	maxCachedMetadata = 1 << 16
+)
+
+// PathMetadata is the contents of .gitfs/meta/<path>.json, describing the last commit that changed something at or
+// under path, for static site generators that show who last edited a page.
+type PathMetadata struct {
+	Path        string    `json:"path"`
+	Commit      string    `json:"commit"`
+	Author      string    `json:"author"`
+	AuthorEmail string    `json:"author_email"`
+	Date        time.Time `json:"date"`
+	Message     string    `json:"message"`
+}
+
+// pathMetadata remembers the contents of the files in .gitfs/meta/ by the commit they describe a path at, so git is
+// only asked again once the reference moves.
+type pathMetadata struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newPathMetadata() *pathMetadata {
+	return &pathMetadata{files: map[string][]byte{}}
+}
+
+func (m *pathMetadata) get(commit string, path FilePath) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	contents, ok := m.files[commit+"\x00"+path.String()]
+	return contents, ok
+}
+
+func (m *pathMetadata) set(commit string, path FilePath, contents []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.files) >= maxCachedMetadata {
+		m.files = make(map[string][]byte)
+	}
+	m.files[commit+"\x00"+path.String()] = contents
+}
+
+// lookupMeta maps filename to a path within /.gitfs/meta/. ok is false for paths outside of it.
+func (s introspectionFileSystem) lookupMeta(filename string) (path FilePath, ok bool, err error) {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil {
+		return FilePath{}, false, err
+	}
+	if len(resolved.Path) < 2 || resolved.Path[0] != IntrospectionDirectory || resolved.Path[1] != MetaDirectory {
+		return FilePath{}, false, nil
+	}
+	return FilePath{Path: resolved.Path[2:]}, true, nil
+}
+
+// described is the path of the repository that the file at path within /.gitfs/meta/ describes.
+func described(path FilePath) (FilePath, bool) {
+	if path.IsRoot() {
+		return FilePath{}, false
+	}
+	name := path.Path[len(path.Path)-1]
+	trimmed := strings.TrimSuffix(name, metaSuffix)
+	if trimmed == name || trimmed == "" {
+		return FilePath{}, false
+	}
+	parent := path.Parent()
+	return FilePath{Path: append(append([]string{}, parent.Path...), trimmed)}, true
+}
+
+// metaDirectory reports whether path within /.gitfs/meta/ is a directory, which it is for every directory of the
+// repository.
+func (s introspectionFileSystem) metaDirectory(path FilePath) (bool, error) {
+	if path.IsRoot() {
+		return true, nil
+	}
+	if path.Path[0] == IntrospectionDirectory {
+		return false, nil
+	}
+	info, err := s.Filesystem.Lstat(path.String())
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+// metaRead generates the contents of the file describing path at commit.
+func (s introspectionFileSystem) metaRead(commit string, path FilePath) ([]byte, error) {
+	if path.Path[0] == IntrospectionDirectory {
+		return nil, fs.ErrNotExist
+	}
+	if contents, ok := s.meta.get(commit, path); ok {
+		return contents, nil
+	}
+	// Only describe what is actually served, not everything git log would find.
+	if _, err := s.Filesystem.Lstat(path.String()); err != nil {
+		return nil, err
+	}
+
+	info, err := s.git.LastCommit(commit, path.String())
+	if err != nil {
+		return nil, err
+	}
+	contents, err := json.MarshalIndent(PathMetadata{
+		Path:        path.String(),
+		Commit:      info.Hash,
+		Author:      info.AuthorName,
+		AuthorEmail: info.AuthorEmail,
+		Date:        info.AuthorTime.UTC(),
+		Message:     info.Message,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	contents = append(contents, '\n')
+	s.meta.set(commit, path, contents)
+	return contents, nil
+}
+
+// metaStat describes path within /.gitfs/meta/.
+func (s introspectionFileSystem) metaStat(path FilePath) (os.FileInfo, error) {
+	name := MetaDirectory
+	if !path.IsRoot() {
+		name = path.Path[len(path.Path)-1]
+	}
+	directory, err := s.metaDirectory(path)
+	if err != nil {
+		return nil, err
+	}
+	if directory {
+		return introspectionInfo{name: name, mode: os.ModeDir | 0555}, nil
+	}
+
+	target, ok := described(path)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	commit, err := s.git.ResolveCommit(s.reference)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := s.metaRead(commit, target)
+	if err != nil {
+		return nil, err
+	}
+	return introspectionInfo{name: name, mode: 0444, size: int64(len(contents))}, nil
+}
+
+func (s introspectionFileSystem) metaOpen(filename string, path FilePath, flag int) (billy.File, error) {
+	if flag != os.O_RDONLY {
+		return nil, billy.ErrReadOnly
+	}
+	directory, err := s.metaDirectory(path)
+	if err != nil {
+		return nil, err
+	}
+	if directory {
+		return nil, ErrIsDirectory
+	}
+
+	target, ok := described(path)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	commit, err := s.git.ResolveCommit(s.reference)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := s.metaRead(commit, target)
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryFile(filename, contents), nil
+}
+
+// metaReadDir lists a directory and a file describing each path in the directory of the repository at path.
+func (s introspectionFileSystem) metaReadDir(path FilePath) ([]os.FileInfo, error) {
+	directory, err := s.metaDirectory(path)
+	if err != nil {
+		return nil, err
+	}
+	if !directory {
+		return nil, fs.ErrInvalid
+	}
+	commit, err := s.git.ResolveCommit(s.reference)
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.Filesystem.ReadDir(path.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var listing []os.FileInfo
+	for _, file := range files {
+		if path.IsRoot() && file.Name() == IntrospectionDirectory {
+			continue
+		}
+		if file.IsDir() {
+			listing = append(listing, introspectionInfo{name: file.Name(), mode: os.ModeDir | 0555})
+		}
+		child := FilePath{Path: append(append([]string{}, path.Path...), file.Name())}
+		contents, err := s.metaRead(commit, child)
+		if err != nil {
+			return nil, err
+		}
+		listing = append(listing, introspectionInfo{name: file.Name() + metaSuffix, mode: 0444, size: int64(len(contents))})
+	}
+	return listing, nil
+}
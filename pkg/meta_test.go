@@ -0,0 +1,104 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func TestPathMetadata(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "dates")
+	reference := BranchRef("master")
+	filesystem := NewIntrospectionFileSystem(NewReferenceFileSystem(git, reference), git, reference)
+
+	metadata := func(t *testing.T, filename string) PathMetadata {
+		file, err := filesystem.Open(filename)
+		if err != nil {
+			t.Fatalf("Open(%s) failed: %v", filename, err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", filename, err)
+		}
+		info, err := filesystem.Stat(filename)
+		if err != nil {
+			t.Fatalf("Stat(%s) failed: %v", filename, err)
+		}
+		if info.Size() != int64(len(contents)) {
+			t.Fatalf("Stat(%s) reported %d bytes but read %d", filename, info.Size(), len(contents))
+		}
+		var metadata PathMetadata
+		if err := json.Unmarshal(contents, &metadata); err != nil {
+			t.Fatalf("%s is not valid JSON: %v", filename, err)
+		}
+		return metadata
+	}
+
+	t.Run("file", func(t *testing.T) {
+		got := metadata(t, ".gitfs/meta/old/file.txt.json")
+		if got.Path != "old/file.txt" || got.Message != "Add an old file" || !got.Date.Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Fatalf("old/file.txt was described as %+v", got)
+		}
+		if got.Commit == "" || got.Author == "" || got.AuthorEmail == "" {
+			t.Fatalf("old/file.txt is missing who committed it: %+v", got)
+		}
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		if got := metadata(t, ".gitfs/meta/new.json"); got.Path != "new" || got.Message != "Add a new file" {
+			t.Fatalf("new was described as %+v", got)
+		}
+	})
+
+	t.Run("listing", func(t *testing.T) {
+		paths, err := filesystem.ReadDir(".gitfs/meta")
+		if err != nil {
+			t.Fatalf("ReadDir(.gitfs/meta) failed: %v", err)
+		}
+		var names []string
+		for _, path := range paths {
+			names = append(names, path.Name())
+		}
+		if diff := cmp.Diff([]string{"new", "new.json", "old", "old.json"}, names); diff != "" {
+			t.Fatal(diff)
+		}
+		if !paths[0].IsDir() || paths[1].IsDir() {
+			t.Fatalf("expected new to be a directory and new.json to be a file: %v", paths)
+		}
+
+		paths, err = filesystem.ReadDir(".gitfs/meta/old")
+		if err != nil {
+			t.Fatalf("ReadDir(.gitfs/meta/old) failed: %v", err)
+		}
+		if len(paths) != 1 || paths[0].Name() != "file.txt.json" || paths[0].Size() == 0 {
+			t.Fatalf(".gitfs/meta/old contained %v", paths)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		for _, filename := range []string{".gitfs/meta/missing.txt.json", ".gitfs/meta/old/file.txt", ".gitfs/meta/.gitfs.json"} {
+			if _, err := filesystem.Stat(filename); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("Stat(%s) = %v, expected it to not exist", filename, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,46 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mount
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestAuthorizeControl(t *testing.T) {
+	me := uint32(os.Getuid())
+	someone := me + 1
+
+	options := Options{Control: true}
+	if err := options.authorizeControl(me, ".gitfs/control"); err != nil {
+		t.Fatalf("the user running the mount wasn't allowed to control it: %v", err)
+	}
+	if err := options.authorizeControl(someone, "./.gitfs/control"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("another user controlling the mount returned %v, expected fs.ErrPermission", err)
+	}
+	if err := options.authorizeControl(someone, "build/main"); err != nil {
+		t.Fatalf("writing elsewhere was refused: %v", err)
+	}
+
+	options.ControlUIDs = []uint32{someone}
+	if err := options.authorizeControl(someone, ".gitfs/control"); err != nil {
+		t.Fatalf("a user in ControlUIDs wasn't allowed to control the mount: %v", err)
+	}
+	if err := options.authorizeControl(me, ".gitfs/control"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("a user missing from ControlUIDs controlling the mount returned %v, expected fs.ErrPermission", err)
+	}
+}
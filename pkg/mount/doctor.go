@@ -0,0 +1,78 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mount
+
+import (
+	"errors"
+	"fmt"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"io/fs"
+	"os"
+	"os/exec"
+)
+
+// fuseDevice is the device FUSE filesystems are served through.
+const fuseDevice = "/dev/fuse"
+
+// DiagnoseFuse checks that this machine can mount FUSE filesystems as the current user.
+func DiagnoseFuse() []gitfs.Diagnosis {
+	root := os.Geteuid() == 0
+	diagnoses := []gitfs.Diagnosis{diagnoseFuseDevice()}
+
+	fusermount := gitfs.Diagnosis{Check: "fusermount"}
+	path, err := exec.LookPath("fusermount3")
+	if err != nil {
+		path, err = exec.LookPath("fusermount")
+	}
+	switch {
+	case err != nil:
+		fusermount.Problem = "neither fusermount3 nor fusermount is on $PATH"
+		fusermount.Remediation = "Install the fuse3 or fuse package, which mounts and unmounts FUSE filesystems for users other than root."
+		// Root mounts without it.
+		fusermount.Warning = root
+	case !root:
+		info, err := os.Stat(path)
+		if err == nil && info.Mode()&fs.ModeSetuid == 0 {
+			fusermount.Problem = fmt.Sprintf("%s isn't setuid, so it can only mount as root", path)
+			fusermount.Remediation = fmt.Sprintf("Run chmod u+s %s as root, or reinstall the package it came from.", path)
+			break
+		}
+		fusermount.Detail = path
+	default:
+		fusermount.Detail = path
+	}
+	return append(diagnoses, fusermount)
+}
+
+// diagnoseFuseDevice checks that the kernel supports FUSE and lets the current user use it.
+func diagnoseFuseDevice() gitfs.Diagnosis {
+	diagnosis := gitfs.Diagnosis{Check: fuseDevice}
+	device, err := os.OpenFile(fuseDevice, os.O_RDWR, 0)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		diagnosis.Problem = fmt.Sprintf("%s doesn't exist", fuseDevice)
+		diagnosis.Remediation = "Load the fuse kernel module with modprobe fuse. In a container, pass the device in, like docker run --device /dev/fuse."
+	case errors.Is(err, fs.ErrPermission):
+		diagnosis.Problem = fmt.Sprintf("%s can't be opened by this user", fuseDevice)
+		diagnosis.Remediation = fmt.Sprintf("Give this user read and write access to %s, usually through the fuse group, or run gitfs as root.", fuseDevice)
+	case err != nil:
+		diagnosis.Problem = fmt.Sprintf("%s can't be opened: %v", fuseDevice, err)
+		diagnosis.Remediation = "Check that the kernel supports FUSE and that nothing, like a container's seccomp or AppArmor profile, blocks it."
+	default:
+		_ = device.Close()
+		diagnosis.Detail = "available"
+	}
+	return diagnosis
+}
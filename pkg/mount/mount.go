@@ -0,0 +1,822 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mount is a high level API for embedding gitfs FUSE mounts into other Go programs. It wraps constructing the
+// git backend, configuring FUSE, and unmounting when the program is asked to exit.
+//
+//	mounted, err := mount.Mount(ctx, mount.Options{GitDir: "/srv/repo.git", MountPoint: "/mnt/repo"})
+//	if err != nil {
+//		return err
+//	}
+//	defer mounted.Unmount()
+//	return mounted.Join(ctx)
+package mount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"github.com/gravypod/gitfs/pkg/remote"
+	"github.com/jacobsa/fuse"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrNoBackend       = errors.New("must provide a git directory, a directory of repositories, or a remote server")
+	ErrTooManyBackends = errors.New("a directory of repositories can't be served along with a git directory or a remote server")
+	ErrNoMountPoint    = errors.New("must provide a location to mount into")
+	ErrNeedsRemount    = errors.New("changing the backend or mount point requires a remount")
+	ErrNoRepositories  = errors.New("only mounts of a directory of repositories can add, remove, and maintain repositories")
+)
+
+// WatchTTL is how long the kernel caches attributes and directory entries of mounts with Options.Watch.
+const WatchTTL = time.Second
+
+type Options struct {
+	// GitDir is the path to a bare git repository to serve.
+	GitDir string
+	// GitOptions customize how git is run for GitDir.
+	GitOptions []gitfs.CliOption
+	// Remote is the address of a gitfsd server to mount instead of GitDir.
+	Remote string
+	// ReposDir is a directory of bare repositories to serve side by side instead of GitDir, each in a directory named
+	// like gitfs.RepositoryName. Every repository gets a git client of its own and is served with the rest of the
+	// options, and one that fails to open is logged and left out instead of failing the mount. Repositories can be
+	// added and removed while mounted with Mounted.AddRepository and Mounted.RemoveRepository.
+	ReposDir string
+	// ReposDirInterval is how often ReposDir is listed again to serve the repositories added to it and stop serving
+	// the ones removed from it, like a mirror that is kept in sync with a server. 0 only lists it when mounting.
+	ReposDirInterval time.Duration
+	// RepositoryConcurrency is how many calls each repository of ReposDir may serve at once, so one that hangs can't
+	// tie up more. 0 is unlimited.
+	RepositoryConcurrency int
+	// Git reads GitDir. If it is nil a client is started for GitDir with GitOptions and Tracer. Mounts of the same
+	// repository can share one so they share its caches.
+	Git gitfs.Git
+	// Branch to mount. Defaults to "master".
+	Branch string
+	// Ref to mount instead of Branch, like a tag, a commit, or gitfs.IndexRef() for the files staged in a non-bare
+	// repository.
+	Ref gitfs.Ref
+	// DirtyWorktree is a working tree of GitDir whose uncommitted changes are shown on top of the ref, which should be
+	// the branch it has checked out. Disabled if empty.
+	DirtyWorktree string
+	// VerifySignatures refuses to mount unless the ref is signed by a key git trusts, configured through GitOptions
+	// with GNUPGHOME or gpg.ssh.allowedSignersFile. The verified commit is served even if the ref moves.
+	VerifySignatures bool
+	// ExpectCommit is the full or abbreviated hash of the commit the ref must point to. Mounting fails with
+	// gitfs.ErrUnexpectedCommit if it doesn't, and otherwise that exact commit is served even if the ref moves.
+	ExpectCommit string
+	// DirectoryTimes reports the time of the last commit that changed something within each directory as its
+	// modification time instead of the epoch.
+	DirectoryTimes bool
+	// Symlinks decides how symlinks pointing outside of the repository are served.
+	Symlinks gitfs.SymlinkPolicy
+	// Normalization lists names in a Unicode normalization form and lets them be looked up in any form, for clients
+	// like macOS that create names in a different form than they were committed in.
+	Normalization gitfs.UnicodeNormalization
+	// ExposeGitObjects adds a read-only view of GitDir's refs and objects at /.gitobjects/.
+	ExposeGitObjects bool
+	// Introspection adds .gitfs/commit, .gitfs/describe, and .gitfs/notes describing the commit being served from
+	// GitDir, .gitfs/id identifying it together with the options that filter it, .gitfs/epoch growing whenever the ref
+	// moves or caches are dropped, .gitfs/CHANGELOG.txt listing the commits made since the ref last moved,
+	// .gitfs/stats.json describing the repository, .gitfs/handles listing the files held open from the repository, and
+	// .gitfs/meta/<path>.json describing the last commit that changed each path.
+	Introspection bool
+	// DirectoryOrder sorts directory listings. It applies to remote servers too.
+	DirectoryOrder gitfs.DirectoryOrder
+	// MaxFileSize is the largest file in GitDir that may be read, in bytes. 0 is unlimited.
+	MaxFileSize int64
+	// ChunkSeparator, if set, serves files of GitDir that were split into chunks named <file><ChunkSeparator><n> as the
+	// file they were split from. See gitfs.NewChunkedFileSystem.
+	ChunkSeparator string
+	// RateLimits slow down opening large files and listing directories in GitDir.
+	RateLimits gitfs.RateLimits
+	// Archives makes tarballs and zips in GitDir browsable as directories next to them.
+	Archives bool
+	// Releases serves the tree of every tag of GitDir named after a semantic version at /releases/<tag>/, with
+	// /releases/latest linking to the newest one. See gitfs.NewReleasesFileSystem.
+	Releases bool
+	// History serves every version of each file of GitDir at /history-of/<path>/. See gitfs.NewHistoryFileSystem.
+	History bool
+	// Templates are patterns of files served from GitDir whose @@COMMIT@@, @@DESCRIBE@@, @@REF@@, @@BRANCH@@, and
+	// @@TAG@@ tokens are expanded.
+	Templates []string
+	// AccessLog is a file to append a JSON line to for every file read and directory listed through the mount,
+	// recording the uid of the process that did it and the commit it read from. See gitfs.AccessLog.
+	AccessLog string
+	// RefResolver, if set, serves the commit it resolves ResolveName to instead of the ref. It is asked again whenever
+	// the mount is reloaded or refreshed, so a mount can follow something like the last commit that passed CI. See
+	// gitfs.RefResolver. It is ignored while bisecting.
+	RefResolver gitfs.RefResolver
+	// ResolveName is the name RefResolver resolves, like main.
+	ResolveName string
+	// ResolveInterval is how often RefResolver is asked again while mounted. The mount is refreshed when the commit
+	// changes, and the kernel drops what it cached after WatchTTL like with Watch so the new commit shows up promptly.
+	// 0 only asks when mounting and refreshing.
+	ResolveInterval time.Duration
+	// Bisection, if set, serves the commit it is testing from GitDir instead of the ref and adds .gitfs/bisect/ to mark
+	// it good or bad, reloading the mount with the next commit to test. The mount is writable and the kernel caches what it reads
+	// like with Watch so the next commit shows up promptly. See gitfs.NewBisectFileSystem.
+	Bisection *gitfs.Bisection
+	// Control adds .gitfs/control to the root of the mount, which refreshes the mount when refresh is written to it,
+	// like with Mounted.Refresh, so a job that just pushed can read what it pushed without waiting. The mount is
+	// writable so it can be written to, and the kernel still keeps what it cached for AttributeTTL and EntryTTL.
+	Control bool
+	// ControlUIDs are the users allowed to write to .gitfs/control. Only the user running the mount is when it is
+	// empty.
+	ControlUIDs []uint32
+	// BuildCache is a directory that paths ignored by the .gitignore at the root of GitDir are read from and written
+	// to, so builds can run inside of the mount. The mount is read-only when it is empty.
+	BuildCache string
+
+	// MountPoint is the directory to mount into. It is created if it does not exist.
+	MountPoint string
+	// Name, if set, labels what the mount logs and traces, its access log entries, and its .gitfs/stats.json, so the
+	// load of several mounts served by one process can be attributed. Repositories of ReposDir are labelled with their
+	// name under it. See gitfs.JoinLabels.
+	Name string
+
+	// HandleSignals unmounts the filesystem when the process receives SIGINT or SIGTERM.
+	HandleSignals bool
+
+	// AttributeTTL and EntryTTL are how long the kernel may cache attributes and directory entries. 0 caches them
+	// forever, or for WatchTTL with Watch. See gitfs.FuseOptions.
+	AttributeTTL time.Duration
+	EntryTTL     time.Duration
+	// Watch expects the ref to move while mounted, like a branch that is pushed to, and has the kernel drop what it
+	// cached after WatchTTL unless AttributeTTL or EntryTTL say otherwise, so the new commit shows up promptly.
+	Watch bool
+
+	// IdleUnmount unmounts the filesystem once nothing has used it for this long. The unmount is retried after
+	// another window if the mount is busy. 0 never unmounts.
+	IdleUnmount time.Duration
+
+	// Tracer, if set, logs every FUSE operation and the git commands it ran.
+	Tracer *gitfs.Tracer
+
+	// DebugLogger, if set, logs every request and response exchanged with the kernel.
+	DebugLogger *log.Logger
+	// ErrorLogger, if set, logs requests that failed.
+	ErrorLogger *log.Logger
+}
+
+// Mounted is a live gitfs mount.
+type Mounted struct {
+	dir     string
+	mounted *fuse.MountedFileSystem
+
+	// mu guards the fields used by Reload.
+	mu      sync.Mutex
+	options Options
+	fs      *gitfs.SwappableFileSystem
+	closers []io.Closer
+	// repositories are those served from Options.ReposDir, if it is set.
+	repositories *repositories
+	// accessLog is the file behind Options.AccessLog. It stays open across reloads.
+	accessLog *os.File
+	// maintenance is held while a repository is maintained, so repositories are maintained one at a time.
+	maintenance sync.Mutex
+
+	// unmountMu guards unmounted so a failed unmount can be retried.
+	unmountMu sync.Mutex
+	unmounted bool
+	stop      func()
+}
+
+// reference is the ref the options serve.
+func (o Options) reference() gitfs.Ref {
+	if o.Ref.Name != "" {
+		return o.Ref
+	}
+	if o.Branch == "" {
+		return gitfs.BranchRef("master")
+	}
+	return gitfs.BranchRef(o.Branch)
+}
+
+// resolved is the options serving the commit RefResolver resolves ResolveName to, or o if there is no RefResolver.
+func (o Options) resolved() (Options, error) {
+	if o.RefResolver == nil || o.Bisection != nil {
+		return o, nil
+	}
+	ref, err := gitfs.ResolveRef(o.RefResolver, o.ResolveName)
+	if err != nil {
+		return o, err
+	}
+	log.Printf("Resolved %s to %s", o.ResolveName, ref)
+	o.Ref = ref
+	return o, nil
+}
+
+// following reports whether RefResolver is asked again while mounted.
+func (o Options) following() bool {
+	return o.RefResolver != nil && o.ResolveInterval > 0 && o.Bisection == nil
+}
+
+// readOnly reports whether nothing can be written through the mount, not even to control it.
+func (o Options) readOnly() bool {
+	return o.BuildCache == "" && o.Bisection == nil && !o.Control
+}
+
+// authorizeControl refuses writes to .gitfs/control from users not in ControlUIDs.
+func (o Options) authorizeControl(uid uint32, path string) error {
+	if !gitfs.IsControlFile(path) {
+		return nil
+	}
+	allowed := o.ControlUIDs
+	if len(allowed) == 0 {
+		allowed = []uint32{uint32(os.Getuid())}
+	}
+	for _, controller := range allowed {
+		if uid == controller {
+			return nil
+		}
+	}
+	return &fs.PathError{Op: "write", Path: path, Err: fs.ErrPermission}
+}
+
+// ttl is how long the kernel may cache what configured says, which is WatchTTL when unset on a watched mount.
+func (o Options) ttl(configured time.Duration) time.Duration {
+	if configured == 0 && (o.Watch || o.Bisection != nil || o.following()) {
+		return WatchTTL
+	}
+	return configured
+}
+
+// openAccessLog opens the access log described by options, if there is one.
+func openAccessLog(options Options) (*os.File, *gitfs.AccessLog, error) {
+	if options.AccessLog == "" {
+		return nil, nil, nil
+	}
+
+	git := options.Git
+	if git == nil && options.GitDir != "" {
+		var err error
+		// The access log only resolves the ref now and then, which isn't worth keeping git processes running for.
+		gitOptions := append(append([]gitfs.CliOption{}, options.GitOptions...), gitfs.WithProcessPool(0, 0))
+		git, err = gitfs.NewCliGit(options.GitDir, gitOptions...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create git client for directory '%s': %v", options.GitDir, err)
+		}
+	}
+
+	file, err := os.OpenFile(options.AccessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open access log: %v", err)
+	}
+	return file, gitfs.NewAccessLog(file, git, options.reference()).Labelled(options.Name), nil
+}
+
+// openTreeSizes measures the directories of options.GitDir for gitfs.TreeEntriesXattr and gitfs.TreeSizeXattr. It is
+// nil for mounts of anything else, and for mounts showing a dirty worktree whose directories aren't the trees git has.
+func openTreeSizes(options Options) (*gitfs.TreeSizes, error) {
+	if options.GitDir == "" || options.ReposDir != "" || options.Remote != "" || options.DirtyWorktree != "" {
+		return nil, nil
+	}
+	git := options.Git
+	if git == nil {
+		var err error
+		// Trees are measured with ls-tree, which doesn't use the git processes kept running for reading blobs.
+		gitOptions := append(append([]gitfs.CliOption{}, options.GitOptions...), gitfs.WithProcessPool(0, 0))
+		git, err = gitfs.NewCliGit(options.GitDir, gitOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create git client for directory '%s': %v", options.GitDir, err)
+		}
+	}
+	return gitfs.NewTreeSizes(git), nil
+}
+
+// open builds the filesystem options describe. Mounts of Options.ReposDir also return the repositories they serve,
+// which include those in gitDirs, mapping names to the repositories served before a reload.
+func open(options Options, gitDirs map[string]string) (billy.Filesystem, []io.Closer, *repositories, error) {
+	if options.ReposDir == "" {
+		fs, _, closers, err := newFileSystem(options)
+		return fs, closers, nil, err
+	}
+	if options.GitDir != "" || options.Remote != "" || options.Git != nil {
+		return nil, nil, nil, ErrTooManyBackends
+	}
+	repositories, err := openRepositories(options, gitDirs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return repositories.fs, []io.Closer{repositories}, repositories, nil
+}
+
+// newFileSystem serves options.GitDir or options.Remote, along with the git client reading the former.
+func newFileSystem(options Options) (billy.Filesystem, gitfs.Git, []io.Closer, error) {
+	if options.Remote != "" {
+		client, err := remote.Dial("tcp", options.Remote)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to connect to remote server '%s': %v", options.Remote, err)
+		}
+		return gitfs.NewOrderedFileSystem(remote.NewFileSystem(client), options.DirectoryOrder), nil, []io.Closer{client}, nil
+	}
+
+	if options.GitDir == "" {
+		return nil, nil, nil, ErrNoBackend
+	}
+
+	git := options.Git
+	var closers []io.Closer
+	if git == nil {
+		gitOptions := append([]gitfs.CliOption{gitfs.WithTracer(options.Tracer.Labelled(options.Name))}, options.GitOptions...)
+		var err error
+		git, err = gitfs.NewCliGit(options.GitDir, gitOptions...)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create git client for directory '%s': %v", options.GitDir, err)
+		}
+		// Clients keep git processes running with gitfs.WithProcessPool.
+		if closer, ok := git.(io.Closer); ok {
+			closers = append(closers, closer)
+		}
+	}
+
+	fs, err := newGitFileSystem(git, options)
+	if err != nil {
+		for _, closer := range closers {
+			closer.Close()
+		}
+		return nil, nil, nil, err
+	}
+	return fs, git, closers, nil
+}
+
+// newGitFileSystem serves options.GitDir through git.
+func newGitFileSystem(git gitfs.Git, options Options) (billy.Filesystem, error) {
+	var err error
+	reference := options.reference()
+	if options.VerifySignatures {
+		reference, err = gitfs.VerifyRef(git, reference)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.ExpectCommit != "" {
+		reference, err = gitfs.PinRef(git, reference, options.ExpectCommit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var buildCache billy.Filesystem
+	if options.BuildCache != "" {
+		if err := os.MkdirAll(options.BuildCache, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create build cache: %v", err)
+		}
+		buildCache = osfs.New(options.BuildCache)
+	}
+	return gitfs.New(git, gitfs.Options{
+		Ref:              reference,
+		Symlinks:         options.Symlinks,
+		Normalization:    options.Normalization,
+		DirectoryTimes:   options.DirectoryTimes,
+		DirtyWorktree:    options.DirtyWorktree,
+		History:          options.History,
+		Releases:         options.Releases,
+		MaxFileSize:      options.MaxFileSize,
+		RateLimits:       options.RateLimits,
+		ChunkSeparator:   options.ChunkSeparator,
+		Archives:         options.Archives,
+		Templates:        options.Templates,
+		BuildCache:       buildCache,
+		Introspection:    options.Introspection,
+		Bisection:        options.Bisection,
+		ExposeGitObjects: options.ExposeGitObjects,
+		GitDir:           options.GitDir,
+		Label:            options.Name,
+		DirectoryOrder:   options.DirectoryOrder,
+	})
+}
+
+// Mount builds the backend described by options and mounts it. The filesystem is unmounted when ctx is cancelled.
+func Mount(ctx context.Context, options Options) (*Mounted, error) {
+	if options.MountPoint == "" {
+		return nil, ErrNoMountPoint
+	}
+
+	if _, err := os.Stat(options.MountPoint); os.IsNotExist(err) {
+		err := os.Mkdir(options.MountPoint, os.FileMode(0444))
+		if err != nil {
+			return nil, fmt.Errorf("could not create mount path: %v", err)
+		}
+	}
+
+	dir, err := filepath.Abs(options.MountPoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve path: %v", err)
+	}
+
+	if options.Bisection != nil {
+		options.Ref = gitfs.CommitRef(options.Bisection.Commit())
+	}
+	options, err = options.resolved()
+	if err != nil {
+		return nil, err
+	}
+	fs, closers, repositories, err := open(options, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Mounted{
+		dir:          dir,
+		options:      options,
+		fs:           gitfs.NewSwappableFileSystem(fs),
+		closers:      closers,
+		repositories: repositories,
+	}
+
+	var accessLog *gitfs.AccessLog
+	m.accessLog, accessLog, err = openAccessLog(options)
+	if err != nil {
+		m.close()
+		return nil, err
+	}
+
+	treeSizes, err := openTreeSizes(options)
+	if err != nil {
+		m.close()
+		return nil, err
+	}
+
+	var served billy.Filesystem = m.fs
+	var idle *gitfs.IdleTracker
+	if options.IdleUnmount > 0 {
+		idle = gitfs.NewIdleTracker()
+		served = gitfs.NewIdleFileSystem(served, idle)
+	}
+
+	if options.Control {
+		served = gitfs.NewControlFileSystem(served, m.Refresh)
+	}
+
+	readOnly := options.readOnly()
+	var authorizeWrite func(uid uint32, path string) error
+	if options.Control {
+		authorizeWrite = m.authorizeWrite
+	}
+	server, err := gitfs.NewBillyFuseServerWithOptions(served, gitfs.FuseOptions{
+		Tracer:       options.Tracer,
+		AccessLog:    accessLog,
+		AttributeTTL: options.ttl(options.AttributeTTL),
+		EntryTTL:     options.ttl(options.EntryTTL),
+		TreeSizes:    treeSizes,
+		ReadOnly:     readOnly,
+		Label:        options.Name,
+
+		AuthorizeWrite: authorizeWrite,
+	})
+	if err != nil {
+		m.close()
+		return nil, fmt.Errorf("failed to start go-billy server: %v", err)
+	}
+
+	config := fuse.MountConfig{
+		ReadOnly:                  readOnly,
+		DisableWritebackCaching:   true,
+		EnableSymlinkCaching:      false,
+		DisableDefaultPermissions: true,
+
+		DebugLogger: gitfs.LabelLogger(options.DebugLogger, options.Name),
+		ErrorLogger: gitfs.LabelLogger(options.ErrorLogger, options.Name),
+	}
+
+	m.mounted, err = fuse.Mount(dir, server, &config)
+	if err != nil {
+		m.close()
+		return nil, fmt.Errorf("mount failed: %v", err)
+	}
+
+	m.watch(ctx, options.HandleSignals)
+	if options.Bisection != nil {
+		options.Bisection.OnMove(m.bisect)
+	}
+	if idle != nil {
+		m.unmountWhenIdle(ctx, idle, options.IdleUnmount)
+	}
+	if repositories != nil && options.ReposDirInterval > 0 {
+		m.rescanRepositories(ctx, options.ReposDirInterval)
+	}
+	if options.following() {
+		m.followResolver(ctx, options.ResolveInterval)
+	}
+	return m, nil
+}
+
+// watch unmounts when ctx is done or, if requested, when the process is asked to exit.
+func (m *Mounted) watch(ctx context.Context, handleSignals bool) {
+	signals := make(chan os.Signal, 1)
+	if handleSignals {
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	}
+
+	done := make(chan struct{})
+	m.stop = func() {
+		signal.Stop(signals)
+		close(done)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case sig := <-signals:
+			log.Printf("Received %s, unmounting %s", sig, m.dir)
+		case <-done:
+			return
+		}
+		if err := m.Unmount(); err != nil {
+			log.Printf("Failed to unmount %s: %v", m.dir, err)
+		}
+	}()
+}
+
+// unmountWhenIdle unmounts once idle has seen no use for timeout, trying again after another timeout if the kernel
+// refuses because something still has the mount open.
+func (m *Mounted) unmountWhenIdle(ctx context.Context, idle *gitfs.IdleTracker, timeout time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := m.stop
+	m.stop = func() {
+		cancel()
+		stop()
+	}
+
+	go func() {
+		for idle.WaitIdle(ctx, timeout) {
+			log.Printf("Unused for %v, unmounting %s", timeout, m.dir)
+			err := m.Unmount()
+			if err == nil {
+				return
+			}
+			log.Printf("Failed to unmount %s: %v", m.dir, err)
+			idle.Touch()
+		}
+	}()
+}
+
+// rescanRepositories lists Options.ReposDir every interval to serve the repositories added to it and stop serving the
+// ones removed from it.
+func (m *Mounted) rescanRepositories(ctx context.Context, interval time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := m.stop
+	m.stop = func() {
+		cancel()
+		stop()
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := m.rescan(); err != nil {
+				log.Printf("Failed to look for added and removed repositories: %v", err)
+			}
+		}
+	}()
+}
+
+// rescan looks for repositories added to or removed from Options.ReposDir once.
+func (m *Mounted) rescan() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.repositories == nil {
+		return nil
+	}
+	return m.repositories.rescan()
+}
+
+// followResolver asks Options.RefResolver where its name points every interval and refreshes the mount when it moved.
+func (m *Mounted) followResolver(ctx context.Context, interval time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := m.stop
+	m.stop = func() {
+		cancel()
+		stop()
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := m.reresolve(); err != nil {
+				log.Printf("Keeping %s on the commit it serves: %v", m.dir, err)
+			}
+		}
+	}()
+}
+
+// reresolve refreshes the mount if Options.RefResolver resolves to another commit than the one being served.
+func (m *Mounted) reresolve() error {
+	m.mu.Lock()
+	options := m.options
+	m.mu.Unlock()
+	if !options.following() {
+		return nil
+	}
+	ref, err := gitfs.ResolveRef(options.RefResolver, options.ResolveName)
+	if err != nil || ref == options.Ref {
+		return err
+	}
+	log.Printf("%s moved from %s to %s", options.ResolveName, options.Ref, ref)
+	return m.Refresh()
+}
+
+// bisect reloads the mount to serve commit, the next commit Options.Bisection tests.
+func (m *Mounted) bisect(commit string) error {
+	m.mu.Lock()
+	options := m.options
+	m.mu.Unlock()
+	options.Ref = gitfs.CommitRef(commit)
+	return m.Reload(options)
+}
+
+// Dir is the absolute path the filesystem is mounted at.
+func (m *Mounted) Dir() string {
+	return m.dir
+}
+
+// Join blocks until the filesystem is unmounted.
+func (m *Mounted) Join(ctx context.Context) error {
+	err := m.mounted.Join(ctx)
+	m.close()
+	return err
+}
+
+// Reload rebuilds the filesystem from options and swaps it in without unmounting. Options that pick the backend or
+// the mount point can't change this way and return ErrNeedsRemount. Attributes the kernel has already cached may
+// keep being served until it drops them.
+func (m *Mounted) Reload(options Options) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if options.GitDir != m.options.GitDir || options.Remote != m.options.Remote || options.ReposDir != m.options.ReposDir {
+		return ErrNeedsRemount
+	}
+	if options.Name != m.options.Name || options.AccessLog != m.options.AccessLog || options.IdleUnmount != m.options.IdleUnmount || options.ReposDirInterval != m.options.ReposDirInterval {
+		return ErrNeedsRemount
+	}
+	if options.ttl(options.AttributeTTL) != m.options.ttl(m.options.AttributeTTL) || options.ttl(options.EntryTTL) != m.options.ttl(m.options.EntryTTL) {
+		return ErrNeedsRemount
+	}
+	if options.Control != m.options.Control || options.following() != m.options.following() {
+		return ErrNeedsRemount
+	}
+	if options.readOnly() != m.options.readOnly() {
+		// The kernel only lets writes through to mounts that weren't read-only when they were mounted.
+		return ErrNeedsRemount
+	}
+	if dir, err := filepath.Abs(options.MountPoint); err != nil || dir != m.dir {
+		return ErrNeedsRemount
+	}
+
+	// Repositories added while mounted are opened again with the new options.
+	options, err := options.resolved()
+	if err != nil {
+		return err
+	}
+	fs, closers, repositories, err := open(options, m.repositories.gitDirs())
+	if err != nil {
+		return err
+	}
+	if options.Introspection {
+		gitfs.FollowRef(gitfs.RepositoryName(options.GitDir), m.options.Ref, options.Ref)
+	}
+	m.fs.Swap(fs)
+	closeAll(m.closers)
+	m.options = options
+	m.closers = closers
+	m.repositories = repositories
+	return nil
+}
+
+// Refresh rebuilds the filesystem with the options it is served with and swaps it in, like a Reload that changes
+// nothing. Everything read from the repositories so far is dropped, so the ref is resolved again, with
+// Options.RefResolver if it is set, and .gitfs/epoch advances.
+func (m *Mounted) Refresh() error {
+	m.mu.Lock()
+	options := m.options
+	m.mu.Unlock()
+	if err := m.Reload(options); err != nil {
+		return err
+	}
+	log.Printf("Refreshed %s", m.dir)
+	return nil
+}
+
+// authorizeWrite is Options.authorizeControl for the options the mount is served with.
+func (m *Mounted) authorizeWrite(uid uint32, path string) error {
+	m.mu.Lock()
+	options := m.options
+	m.mu.Unlock()
+	return options.authorizeControl(uid, path)
+}
+
+// Repositories describes every repository a mount of Options.ReposDir was asked to serve, sorted by name, including
+// those that failed to open. It is empty for other mounts.
+func (m *Mounted) Repositories() []gitfs.RepositoryStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.repositories.status()
+}
+
+// AddRepository opens the bare repository at gitDir with the options of the mount and serves it at /name, replacing
+// what was served there unless it fails to open. Mounts not of Options.ReposDir return ErrNoRepositories.
+func (m *Mounted) AddRepository(name, gitDir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.repositories == nil {
+		return ErrNoRepositories
+	}
+	return m.repositories.add(name, gitDir, false)
+}
+
+// RemoveRepository stops serving the repository at /name and stops its git client. Directory entries the kernel has
+// already cached may keep being listed until it drops them, but fail to be read. Mounts not of Options.ReposDir
+// return ErrNoRepositories.
+func (m *Mounted) RemoveRepository(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.repositories == nil {
+		return ErrNoRepositories
+	}
+	return m.repositories.remove(name)
+}
+
+// MaintainRepository compacts the repository at /name with task, like git gc, and waits for it to finish. It keeps
+// being served throughout, and repositories are maintained one at a time so that maintaining many during off-hours
+// doesn't starve reads of the disk. Mounts not of Options.ReposDir return ErrNoRepositories.
+func (m *Mounted) MaintainRepository(name string, task gitism.MaintenanceTask) error {
+	m.maintenance.Lock()
+	defer m.maintenance.Unlock()
+	m.mu.Lock()
+	repositories := m.repositories
+	m.mu.Unlock()
+	if repositories == nil {
+		return ErrNoRepositories
+	}
+	return repositories.maintain(name, task)
+}
+
+// Unmount detaches the filesystem. It is safe to call more than once, and may be called again if it fails because the
+// mount is busy.
+func (m *Mounted) Unmount() error {
+	m.unmountMu.Lock()
+	defer m.unmountMu.Unlock()
+	if m.unmounted {
+		return nil
+	}
+	if err := fuse.Unmount(m.dir); err != nil {
+		return err
+	}
+	m.unmounted = true
+	return nil
+}
+
+func (m *Mounted) close() {
+	if m.stop != nil {
+		m.stop()
+		m.stop = nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	closeAll(m.closers)
+	m.closers = nil
+	m.repositories = nil
+	if m.accessLog != nil {
+		m.accessLog.Close()
+		m.accessLog = nil
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mount
+
+import (
+	"fmt"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// repositories are the repositories a mount of Options.ReposDir serves.
+type repositories struct {
+	options Options
+	fs      *gitfs.RepositoriesFileSystem
+
+	mu     sync.Mutex
+	served map[string]*repository
+}
+
+// repository is one of the repositories a mount of Options.ReposDir was asked to serve.
+type repository struct {
+	gitDir  string
+	git     gitfs.Git
+	closers []io.Closer
+	// discovered is set for repositories found in Options.ReposDir, which are removed when they are removed from it.
+	discovered bool
+	// err is why the repository couldn't be opened, in which case it isn't served.
+	err error
+}
+
+// openRepositories serves every repository in options.ReposDir, along with the ones in gitDirs, which maps names to
+// the repositories served before a reload. Repositories that fail to open are logged and left out.
+func openRepositories(options Options, gitDirs map[string]string) (*repositories, error) {
+	found, err := gitfs.FindRepositories(options.ReposDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repositories in '%s': %v", options.ReposDir, err)
+	}
+
+	r := &repositories{
+		options: options,
+		fs:      gitfs.NewRepositoriesFileSystem(options.RepositoryConcurrency),
+		served:  map[string]*repository{},
+	}
+	for name, gitDir := range found {
+		if err := r.add(name, gitDir, true); err != nil {
+			log.Printf("Not serving repository %s: %v", name, err)
+		}
+	}
+	for name, gitDir := range gitDirs {
+		if _, ok := found[name]; ok {
+			continue
+		}
+		if err := r.add(name, gitDir, false); err != nil {
+			log.Printf("Not serving repository %s: %v", name, err)
+		}
+	}
+	return r, nil
+}
+
+// rescan serves the repositories added to Options.ReposDir since it was last listed and stops serving the ones removed
+// from it. Repositories added some other way are left alone, and ones that failed to open aren't tried again until
+// they are removed and added back.
+func (r *repositories) rescan() error {
+	found, err := gitfs.FindRepositories(r.options.ReposDir)
+	if err != nil {
+		return fmt.Errorf("failed to list repositories in '%s': %v", r.options.ReposDir, err)
+	}
+
+	var added, removed []string
+	r.mu.Lock()
+	for name, served := range r.served {
+		if served.discovered && found[name] != served.gitDir {
+			removed = append(removed, name)
+		}
+	}
+	for name := range found {
+		if _, ok := r.served[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, name := range removed {
+		if err := r.remove(name); err != nil {
+			return err
+		}
+		log.Printf("Stopped serving repository %s, which was removed from %s", name, r.options.ReposDir)
+		if _, ok := found[name]; ok {
+			// It was replaced by another repository with the same name, like repo.git with repo.
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		if err := r.add(name, found[name], true); err != nil {
+			log.Printf("Not serving repository %s: %v", name, err)
+			continue
+		}
+		log.Printf("Serving repository %s, which was added to %s", name, r.options.ReposDir)
+	}
+	return nil
+}
+
+// add opens the repository at gitDir with the options of the mount and serves it as name. A repository already
+// served as name is replaced, or kept if gitDir fails to open. Discovered repositories are those found in
+// Options.ReposDir.
+func (r *repositories) add(name, gitDir string, discovered bool) error {
+	if err := gitfs.ValidRepositoryName(name); err != nil {
+		return err
+	}
+	options := r.options
+	options.ReposDir = ""
+	options.GitDir = gitDir
+	options.Name = gitfs.JoinLabels(options.Name, name)
+	if options.BuildCache != "" {
+		// Repositories don't share what their builds leave behind.
+		options.BuildCache = filepath.Join(options.BuildCache, name)
+	}
+	served, git, closers, err := newFileSystem(options)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous := r.served[name]
+	if err != nil {
+		if previous == nil || previous.err != nil {
+			r.served[name] = &repository{gitDir: gitDir, discovered: discovered, err: err}
+		}
+		return err
+	}
+	if _, err := r.fs.AddRepository(name, served); err != nil {
+		closeAll(closers)
+		return err
+	}
+	r.served[name] = &repository{gitDir: gitDir, git: git, closers: closers, discovered: discovered}
+	if previous != nil {
+		closeAll(previous.closers)
+	}
+	return nil
+}
+
+// remove stops serving the repository named name.
+func (r *repositories) remove(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	removed, ok := r.served[name]
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(r.served, name)
+	r.fs.RemoveRepository(name)
+	closeAll(removed.closers)
+	return nil
+}
+
+// maintain compacts the repository named name with task while it keeps being served.
+func (r *repositories) maintain(name string, task gitism.MaintenanceTask) error {
+	r.mu.Lock()
+	served, ok := r.served[name]
+	r.mu.Unlock()
+	if !ok {
+		return &fs.PathError{Op: "maintain", Path: name, Err: fs.ErrNotExist}
+	}
+	if served.err != nil {
+		return fmt.Errorf("%s isn't served: %v", name, served.err)
+	}
+	return gitfs.Maintain(served.git, task)
+}
+
+// status describes every repository, sorted by name. A nil *repositories has none.
+func (r *repositories) status() []gitfs.RepositoryStatus {
+	if r == nil {
+		return nil
+	}
+	stats := map[string]gitfs.RepositoryStats{}
+	for _, repositoryStats := range r.fs.Stats() {
+		stats[repositoryStats.Name] = repositoryStats
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := make([]gitfs.RepositoryStatus, 0, len(r.served))
+	for name, served := range r.served {
+		status := gitfs.RepositoryStatus{RepositoryStats: stats[name], GitDir: served.gitDir}
+		status.Name = name
+		if served.err != nil {
+			status.Error = served.err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// gitDirs maps the name of every repository to its path. A nil *repositories has none.
+func (r *repositories) gitDirs() map[string]string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	gitDirs := map[string]string{}
+	for name, served := range r.served {
+		gitDirs[name] = served.gitDir
+	}
+	return gitDirs
+}
+
+// Close stops every repository's git client.
+func (r *repositories) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, served := range r.served {
+		closeAll(served.closers)
+	}
+	r.served = map[string]*repository{}
+	return nil
+}
+
+func closeAll(closers []io.Closer) {
+	for _, closer := range closers {
+		closer.Close()
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mount
+
+import (
+	"errors"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRepositories(t *testing.T) {
+	reposDir := t.TempDir()
+	for _, name := range []string{"one.git", "two.git"} {
+		if output, err := exec.Command("git", "init", "--bare", filepath.Join(reposDir, name)).CombinedOutput(); err != nil {
+			t.Fatalf("git init failed: %v: %s", err, output)
+		}
+	}
+	// A build cache that can't be created fails to open the repositories added after it is broken.
+	buildCache := filepath.Join(t.TempDir(), "build")
+
+	r, err := openRepositories(Options{ReposDir: reposDir, BuildCache: buildCache}, nil)
+	if err != nil {
+		t.Fatalf("openRepositories() failed: %v", err)
+	}
+	defer r.Close()
+	if names := r.fs.Names(); !reflect.DeepEqual(names, []string{"one", "two"}) {
+		t.Fatalf("serving %v, expected one and two", names)
+	}
+
+	if err := os.RemoveAll(buildCache); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(buildCache, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.add("three", filepath.Join(reposDir, "one.git"), false); err == nil {
+		t.Fatal("added a repository whose build cache can't be created")
+	}
+	if err := r.add("one", filepath.Join(reposDir, "two.git"), false); err == nil {
+		t.Fatal("replaced a repository with one whose build cache can't be created")
+	}
+	statuses := r.status()
+	if len(statuses) != 3 || statuses[0].GitDir != filepath.Join(reposDir, "one.git") || statuses[1].Error == "" {
+		t.Fatalf("status() = %+v, expected one to be kept and three to have failed", statuses)
+	}
+	if names := r.fs.Names(); !reflect.DeepEqual(names, []string{"one", "two"}) {
+		t.Fatalf("serving %v after failed additions", names)
+	}
+
+	if err := r.maintain("one", gitism.MaintenanceGC); err != nil {
+		t.Fatalf("maintain(one) failed: %v", err)
+	}
+	if err := r.maintain("three", gitism.MaintenanceGC); err == nil {
+		t.Fatal("maintained three, which failed to open")
+	}
+	if err := r.maintain("four", gitism.MaintenanceGC); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("maintaining four, which was never added, = %v", err)
+	}
+
+	if err := r.remove("two"); err != nil {
+		t.Fatalf("remove(two) failed: %v", err)
+	}
+	if err := r.remove("two"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("removing two twice = %v", err)
+	}
+	if names := r.fs.Names(); !reflect.DeepEqual(names, []string{"one"}) {
+		t.Fatalf("serving %v after removing two", names)
+	}
+	if err := r.add("a/b", filepath.Join(reposDir, "two.git"), false); err == nil {
+		t.Fatal("added a repository with a separator in its name")
+	}
+}
+
+func TestRepositoriesRescan(t *testing.T) {
+	reposDir := t.TempDir()
+	initBare := func(path string) {
+		if output, err := exec.Command("git", "init", "--bare", path).CombinedOutput(); err != nil {
+			t.Fatalf("git init failed: %v: %s", err, output)
+		}
+	}
+	initBare(filepath.Join(reposDir, "one.git"))
+	elsewhere := filepath.Join(t.TempDir(), "elsewhere.git")
+	initBare(elsewhere)
+
+	r, err := openRepositories(Options{ReposDir: reposDir}, nil)
+	if err != nil {
+		t.Fatalf("openRepositories() failed: %v", err)
+	}
+	defer r.Close()
+	if err := r.add("elsewhere", elsewhere, false); err != nil {
+		t.Fatalf("add(elsewhere) failed: %v", err)
+	}
+
+	rescan := func(expected ...string) {
+		t.Helper()
+		if err := r.rescan(); err != nil {
+			t.Fatalf("rescan() failed: %v", err)
+		}
+		if names := r.fs.Names(); !reflect.DeepEqual(names, expected) {
+			t.Fatalf("serving %v, expected %v", names, expected)
+		}
+	}
+
+	initBare(filepath.Join(reposDir, "two.git"))
+	rescan("elsewhere", "one", "two")
+
+	if err := os.RemoveAll(filepath.Join(reposDir, "one.git")); err != nil {
+		t.Fatal(err)
+	}
+	rescan("elsewhere", "two")
+
+	// Renaming a repository without changing its name serves it from its new path.
+	if err := os.Rename(filepath.Join(reposDir, "two.git"), filepath.Join(reposDir, "two")); err != nil {
+		t.Fatal(err)
+	}
+	rescan("elsewhere", "two")
+	if gitDirs := r.gitDirs(); gitDirs["two"] != filepath.Join(reposDir, "two") {
+		t.Fatalf("two is served from %s after being renamed", gitDirs["two"])
+	}
+}
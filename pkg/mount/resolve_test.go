@@ -0,0 +1,82 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mount
+
+import (
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"testing"
+	"time"
+)
+
+func TestResolvedOptions(t *testing.T) {
+	names := 0
+	options := Options{
+		Ref: gitfs.BranchRef("main"),
+		RefResolver: gitfs.RefResolverFunc(func(name string) (string, error) {
+			names++
+			return name + "-green\n", nil
+		}),
+		ResolveName: "main",
+	}
+
+	resolved, err := options.resolved()
+	if err != nil || resolved.reference() != gitfs.CommitRef("main-green") {
+		t.Fatalf("resolved() serves %v, %v, expected the commit the resolver returned", resolved.reference(), err)
+	}
+	if again, err := resolved.resolved(); err != nil || again.reference() != resolved.reference() || names != 2 {
+		t.Fatalf("resolving again served %v, %v after %d resolutions", again.reference(), err, names)
+	}
+
+	options.Bisection = new(gitfs.Bisection)
+	if bisecting, err := options.resolved(); err != nil || bisecting.reference() != options.Ref || names != 2 {
+		t.Fatalf("resolved() served %v, %v while bisecting", bisecting.reference(), err)
+	}
+}
+
+func TestFollowResolver(t *testing.T) {
+	git := gitfs.NewFakeGit(time.Now)
+	first := git.Commit("master", "First", map[string]gitfs.FakeFile{"a.txt": {Contents: "a"}})
+	second := git.Commit("master", "Second", map[string]gitfs.FakeFile{"a.txt": {Contents: "b"}})
+	green := first
+	options := Options{
+		GitDir:          t.TempDir(),
+		MountPoint:      t.TempDir(),
+		Git:             git,
+		RefResolver:     gitfs.RefResolverFunc(func(string) (string, error) { return green, nil }),
+		ResolveName:     "master",
+		ResolveInterval: time.Minute,
+	}
+	if options.ttl(0) != WatchTTL {
+		t.Fatalf("the kernel caches a mount following a resolver for %v, expected WatchTTL", options.ttl(0))
+	}
+	options, err := options.resolved()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Mounted{dir: options.MountPoint, options: options, fs: gitfs.NewSwappableFileSystem(memfs.New())}
+
+	if err := m.reresolve(); err != nil || m.options.Ref != gitfs.CommitRef(first) {
+		t.Fatalf("reresolve() left %v, %v, expected the first commit", m.options.Ref, err)
+	}
+	green = second
+	if err := m.reresolve(); err != nil || m.options.Ref != gitfs.CommitRef(second) {
+		t.Fatalf("reresolve() left %v, %v after the resolver moved, expected the second commit", m.options.Ref, err)
+	}
+	if contents, err := util.ReadFile(m.fs, "a.txt"); err != nil || string(contents) != "b" {
+		t.Fatalf("a.txt contained %q, %v after the resolver moved", contents, err)
+	}
+}
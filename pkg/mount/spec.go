@@ -0,0 +1,61 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mount
+
+import (
+	"fmt"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"strings"
+)
+
+// Spec is one of several mounts of the same repository served by a single process.
+type Spec struct {
+	Ref        gitfs.Ref
+	MountPoint string
+}
+
+// ParseSpec parses a spec in the form kind=name:mountpoint, like ref=main:/mnt/main. kind is ref for any name
+// gitfs.ParseRef accepts, or branch, tag, or commit to say which it is. git doesn't allow colons in ref names so the
+// mount point starts after the first one.
+func ParseSpec(spec string) (Spec, error) {
+	colon := strings.IndexRune(spec, ':')
+	equals := strings.IndexRune(spec, '=')
+	if colon < 0 || equals < 0 || equals > colon {
+		return Spec{}, fmt.Errorf("mount spec '%s' must be in the form ref=name:mountpoint", spec)
+	}
+	kind, name, mountPoint := spec[:equals], spec[equals+1:colon], spec[colon+1:]
+	if name == "" || mountPoint == "" {
+		return Spec{}, fmt.Errorf("mount spec '%s' must name a ref and a mount point", spec)
+	}
+
+	var ref gitfs.Ref
+	switch kind {
+	case "ref":
+		var err error
+		ref, err = gitfs.ParseRef(name)
+		if err != nil {
+			return Spec{}, fmt.Errorf("mount spec '%s' has an invalid ref: %v", spec, err)
+		}
+	case "branch":
+		ref = gitfs.BranchRef(name)
+	case "tag":
+		ref = gitfs.TagRef(name)
+	case "commit":
+		ref = gitfs.CommitRef(name)
+	default:
+		return Spec{}, fmt.Errorf("mount spec '%s' must start with ref=, branch=, tag=, or commit=", spec)
+	}
+	return Spec{Ref: ref, MountPoint: mountPoint}, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mount
+
+import (
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"testing"
+)
+
+func TestParseSpec(t *testing.T) {
+	valid := map[string]Spec{
+		"ref=main:/mnt/main":               {Ref: gitfs.BranchRef("main"), MountPoint: "/mnt/main"},
+		"ref=refs/tags/v1.2:/mnt/v1.2":     {Ref: gitfs.TagRef("v1.2"), MountPoint: "/mnt/v1.2"},
+		"tag=v1.2:/mnt/v1.2":               {Ref: gitfs.TagRef("v1.2"), MountPoint: "/mnt/v1.2"},
+		"branch=feature/x:relative/dir":    {Ref: gitfs.BranchRef("feature/x"), MountPoint: "relative/dir"},
+		"commit=HEAD~1:/mnt/c:with:colons": {Ref: gitfs.CommitRef("HEAD~1"), MountPoint: "/mnt/c:with:colons"},
+		"ref=0123abcd:/mnt/commit":         {Ref: gitfs.CommitRef("0123abcd"), MountPoint: "/mnt/commit"},
+	}
+	for spec, want := range valid {
+		got, err := ParseSpec(spec)
+		if err != nil {
+			t.Fatalf("ParseSpec(%s) failed: %v", spec, err)
+		}
+		if got != want {
+			t.Fatalf("ParseSpec(%s) = %+v, want %+v", spec, got, want)
+		}
+	}
+
+	for _, spec := range []string{
+		"main:/mnt/main",
+		"ref=main",
+		"ref=:/mnt/main",
+		"ref=main:",
+		"remote=main:/mnt/main",
+		"ref=-main:/mnt/main",
+		"/mnt/a=b:c",
+	} {
+		if _, err := ParseSpec(spec); err == nil {
+			t.Fatalf("ParseSpec(%s) should fail", spec)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// ClientLimits protect the server from a single client. Zero values disable a limit.
+type ClientLimits struct {
+	// MaxClients is how many client addresses may be connected at once. Connections from new addresses beyond this
+	// are closed as soon as they are accepted.
+	MaxClients int
+	// PerClientRate is how many requests per second each client address may make. Requests beyond the rate are
+	// delayed rather than rejected.
+	PerClientRate float64
+}
+
+// ClientStats are the totals for one client address.
+type ClientStats struct {
+	Connections  int
+	Requests     uint64
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+// clientIdleTimeout is how long a client without connections is remembered before its statistics are dropped.
+const clientIdleTimeout = 10 * time.Minute
+
+type client struct {
+	stats ClientStats
+	// active is when the client last connected, disconnected, or made a request.
+	active time.Time
+
+	// tokens and refilled implement a token bucket for PerClientRate that holds at most one second of requests.
+	tokens   float64
+	refilled time.Time
+}
+
+// ClientTracker keeps statistics about, and enforces ClientLimits on, the clients of a listener.
+type ClientTracker struct {
+	limits      ClientLimits
+	now         func() time.Time
+	sleep       func(time.Duration)
+	idleTimeout time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+func NewClientTracker(limits ClientLimits) *ClientTracker {
+	return &ClientTracker{
+		limits:      limits,
+		now:         time.Now,
+		sleep:       time.Sleep,
+		idleTimeout: clientIdleTimeout,
+		clients:     map[string]*client{},
+	}
+}
+
+// Stats returns a copy of the statistics of every client that is connected or was recently, keyed by address.
+func (t *ClientTracker) Stats() map[string]ClientStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.forgetIdle()
+	stats := make(map[string]ClientStats, len(t.clients))
+	for address, client := range t.clients {
+		stats[address] = client.stats
+	}
+	return stats
+}
+
+// Listen wraps listener so every connection it accepts is tracked.
+func (t *ClientTracker) Listen(listener net.Listener) net.Listener {
+	return trackedListener{Listener: listener, tracker: t}
+}
+
+func clientAddress(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// connect registers a new connection from address. It returns false if MaxClients doesn't leave room for it.
+func (t *ClientTracker) connect(address string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.forgetIdle()
+
+	c, ok := t.clients[address]
+	if !ok || c.stats.Connections == 0 {
+		if t.limits.MaxClients > 0 && t.connectedClients() >= t.limits.MaxClients {
+			return false
+		}
+	}
+	if !ok {
+		c = &client{tokens: t.limits.PerClientRate, refilled: t.now()}
+		t.clients[address] = c
+	}
+	c.stats.Connections += 1
+	c.active = t.now()
+	return true
+}
+
+// forgetIdle drops the clients that have had no connection for idleTimeout so addresses that stopped connecting don't
+// stay in memory forever. Must be called with t.mu held.
+func (t *ClientTracker) forgetIdle() {
+	now := t.now()
+	for address, client := range t.clients {
+		if client.stats.Connections == 0 && now.Sub(client.active) > t.idleTimeout {
+			delete(t.clients, address)
+		}
+	}
+}
+
+// connectedClients counts the addresses with an open connection. Must be called with t.mu held.
+func (t *ClientTracker) connectedClients() int {
+	connected := 0
+	for _, client := range t.clients {
+		if client.stats.Connections > 0 {
+			connected += 1
+		}
+	}
+	return connected
+}
+
+func (t *ClientTracker) disconnect(address string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.clients[address]
+	c.stats.Connections -= 1
+	c.active = t.now()
+}
+
+func (t *ClientTracker) transferred(address string, read, written int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.clients[address]
+	c.stats.BytesRead += uint64(read)
+	c.stats.BytesWritten += uint64(written)
+}
+
+// requested records requests from address and waits until PerClientRate allows them.
+func (t *ClientTracker) requested(address string, requests int) {
+	t.mu.Lock()
+	c := t.clients[address]
+	c.stats.Requests += uint64(requests)
+	c.active = t.now()
+	if t.limits.PerClientRate <= 0 {
+		t.mu.Unlock()
+		return
+	}
+
+	now := t.now()
+	c.tokens += now.Sub(c.refilled).Seconds() * t.limits.PerClientRate
+	if c.tokens > t.limits.PerClientRate {
+		c.tokens = t.limits.PerClientRate
+	}
+	c.refilled = now
+	// Take the tokens now, even if that leaves the bucket in debt, so concurrent connections queue up behind this one.
+	c.tokens -= float64(requests)
+	debt := -c.tokens
+	t.mu.Unlock()
+
+	if debt > 0 {
+		t.sleep(time.Duration(debt / t.limits.PerClientRate * float64(time.Second)))
+	}
+}
+
+type trackedListener struct {
+	net.Listener
+	tracker *ClientTracker
+}
+
+func (l trackedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		address := clientAddress(conn)
+		if !l.tracker.connect(address) {
+			log.Printf("Refusing NFS client %s: already serving %d clients", address, l.tracker.limits.MaxClients)
+			conn.Close()
+			continue
+		}
+		return &trackedConn{Conn: conn, tracker: l.tracker, address: address}, nil
+	}
+}
+
+// trackedConn counts the RPC requests arriving on a connection by following the record marking from RFC 5531.
+type trackedConn struct {
+	net.Conn
+	tracker *ClientTracker
+	address string
+
+	closeOnce sync.Once
+
+	// header collects the four byte fragment header, which may be split across reads.
+	header     [4]byte
+	headerSize int
+	// remaining is how much of the current fragment has not been read yet.
+	remaining uint32
+	// inRecord is set while the fragments of a record are being read.
+	inRecord bool
+	// lastFragment is set if the current fragment ends its record.
+	lastFragment bool
+}
+
+// records advances the record marking state over data and returns how many records started in it.
+func (c *trackedConn) records(data []byte) int {
+	started := 0
+	for len(data) > 0 {
+		if c.remaining > 0 {
+			if uint32(len(data)) < c.remaining {
+				c.remaining -= uint32(len(data))
+				return started
+			}
+			data = data[c.remaining:]
+			c.remaining = 0
+			if c.lastFragment {
+				c.inRecord = false
+			}
+			continue
+		}
+
+		copied := copy(c.header[c.headerSize:], data)
+		c.headerSize += copied
+		data = data[copied:]
+		if c.headerSize < len(c.header) {
+			return started
+		}
+		c.headerSize = 0
+
+		header := binary.BigEndian.Uint32(c.header[:])
+		if !c.inRecord {
+			c.inRecord = true
+			started += 1
+		}
+		c.lastFragment = header&lastFragment != 0
+		c.remaining = header &^ lastFragment
+		if c.remaining == 0 && c.lastFragment {
+			c.inRecord = false
+		}
+	}
+	return started
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.tracker.transferred(c.address, n, 0)
+		if requests := c.records(p[:n]); requests > 0 {
+			c.tracker.requested(c.address, requests)
+		}
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.tracker.transferred(c.address, 0, n)
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.tracker.disconnect(c.address)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,195 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func record(fragments ...[]byte) []byte {
+	var data []byte
+	for index, fragment := range fragments {
+		header := uint32(len(fragment))
+		if index == len(fragments)-1 {
+			header |= lastFragment
+		}
+		data = append(appendUint32(data, header), fragment...)
+	}
+	return data
+}
+
+func TestRecordCounting(t *testing.T) {
+	var stream []byte
+	stream = append(stream, record([]byte("first"))...)
+	stream = append(stream, record([]byte("sec"), []byte("ond"))...)
+	stream = append(stream, record([]byte{})...)
+	stream = append(stream, record([]byte("fourth"))...)
+
+	// Every way of splitting the stream into reads has to find the same records.
+	for size := 1; size <= len(stream); size++ {
+		conn := &trackedConn{}
+		started := 0
+		for offset := 0; offset < len(stream); offset += size {
+			end := offset + size
+			if end > len(stream) {
+				end = len(stream)
+			}
+			started += conn.records(stream[offset:end])
+		}
+		if started != 4 || conn.inRecord {
+			t.Fatalf("reading %d bytes at a time found %d records", size, started)
+		}
+	}
+}
+
+func TestClientTracker(t *testing.T) {
+	listen := func(t *testing.T, tracker *ClientTracker) net.Listener {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		return tracker.Listen(listener)
+	}
+
+	t.Run("stats", func(t *testing.T) {
+		tracker := NewClientTracker(ClientLimits{})
+		listener := listen(t, tracker)
+
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		requests := append(record([]byte("one")), record([]byte("t"), []byte("wo"))...)
+		if _, err := client.Write(requests); err != nil {
+			t.Fatal(err)
+		}
+
+		server, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(server, make([]byte, len(requests))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Write([]byte("reply")); err != nil {
+			t.Fatal(err)
+		}
+
+		stats := tracker.Stats()["127.0.0.1"]
+		want := ClientStats{Connections: 1, Requests: 2, BytesRead: uint64(len(requests)), BytesWritten: 5}
+		if stats != want {
+			t.Fatalf("got stats %+v, want %+v", stats, want)
+		}
+
+		server.Close()
+		server.Close()
+		if connections := tracker.Stats()["127.0.0.1"].Connections; connections != 0 {
+			t.Fatalf("%d connections are still open", connections)
+		}
+	})
+
+	t.Run("max clients", func(t *testing.T) {
+		tracker := NewClientTracker(ClientLimits{MaxClients: 1})
+		listener := listen(t, tracker)
+
+		dial := func(local string) net.Conn {
+			dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(local)}}
+			conn, err := dialer.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Skipf("cannot dial from %s: %v", local, err)
+			}
+			return conn
+		}
+
+		first := dial("127.0.0.1")
+		defer first.Close()
+		refused := dial("127.0.0.2")
+		defer refused.Close()
+		second := dial("127.0.0.1")
+		defer second.Close()
+
+		for i := 0; i < 2; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if address := clientAddress(conn); address != "127.0.0.1" {
+				t.Fatalf("accepted a connection from %s", address)
+			}
+		}
+
+		refused.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := refused.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("connection beyond --max-clients should be closed: %v", err)
+		}
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		tracker := NewClientTracker(ClientLimits{PerClientRate: 2})
+		now := time.Unix(0, 0)
+		var slept time.Duration
+		tracker.now = func() time.Time { return now }
+		tracker.sleep = func(duration time.Duration) { slept += duration }
+
+		tracker.connect("client")
+		// The bucket starts full with one second of requests.
+		tracker.requested("client", 2)
+		if slept != 0 {
+			t.Fatalf("slept for %s within the rate", slept)
+		}
+		tracker.requested("client", 1)
+		if slept != 500*time.Millisecond {
+			t.Fatalf("slept for %s, want 500ms", slept)
+		}
+		now = now.Add(time.Second)
+		slept = 0
+		tracker.requested("client", 1)
+		if slept != 0 {
+			t.Fatalf("slept for %s after the bucket refilled", slept)
+		}
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		tracker := NewClientTracker(ClientLimits{})
+		now := time.Unix(0, 0)
+		tracker.now = func() time.Time { return now }
+
+		tracker.connect("busy")
+		tracker.connect("idle")
+		tracker.requested("idle", 1)
+		tracker.disconnect("idle")
+		now = now.Add(clientIdleTimeout)
+		if stats := tracker.Stats(); len(stats) != 2 {
+			t.Fatalf("clients were forgotten before the idle timeout: %+v", stats)
+		}
+
+		now = now.Add(time.Second)
+		stats := tracker.Stats()
+		if _, ok := stats["idle"]; ok || len(stats) != 1 {
+			t.Fatalf("only the idle client should be forgotten: %+v", stats)
+		}
+		// A client that comes back starts over.
+		tracker.connect("idle")
+		if stats := tracker.Stats()["idle"]; stats != (ClientStats{Connections: 1}) {
+			t.Fatalf("returning client has stats %+v", stats)
+		}
+	})
+}
@@ -0,0 +1,256 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nfs holds the pieces gitfs layers on top of go-nfs to export a repository.
+package nfs
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	gonfs "github.com/willscott/go-nfs"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	handleVersion byte = 1
+
+	// handleLiteral handles carry the path itself.
+	handleLiteral byte = 0
+	// handleHashed handles carry a hash of a path that was too long to fit.
+	handleHashed byte = 1
+
+	// maxHandleSize is the largest file handle NFSv3 allows.
+	maxHandleSize = 64
+	headerSize    = 2 + exportIdSize
+	exportIdSize  = 8
+	pathHashSize  = 16
+
+	// maxHashedPaths bounds how many paths behind hashed handles, and hashes known not to name any path, are
+	// remembered. Forgotten paths are found again by walking the tree.
+	maxHashedPaths = 16384
+)
+
+var ErrStaleHandle = errors.New("file handle does not belong to this export")
+
+// StableHandler hands out file handles that are a pure function of the export and the path, unlike the caching
+// handler from go-nfs which forgets every handle when the daemon restarts and leaves clients with ESTALE. Everything
+// but handle management is forwarded to the wrapped handler.
+type StableHandler struct {
+	gonfs.Handler
+	fs       billy.Filesystem
+	exportId [exportIdSize]byte
+
+	mu sync.Mutex
+	// hashed remembers the paths behind the most recently used hashed handles. After a restart it is refilled by
+	// walking the tree.
+	hashed *hashedPaths
+	// walking is held while the tree is walked, so a burst of unknown handles walks it once.
+	walking sync.Mutex
+}
+
+// hashedPath is the path behind a hashed handle, or nil if walking the tree found no path with the hash.
+type hashedPath struct {
+	hash [pathHashSize]byte
+	path []string
+}
+
+// hashedPaths keeps the most recently used hashedPath entries, up to size.
+type hashedPaths struct {
+	size    int
+	order   *list.List
+	entries map[[pathHashSize]byte]*list.Element
+}
+
+func newHashedPaths(size int) *hashedPaths {
+	return &hashedPaths{size: size, order: list.New(), entries: map[[pathHashSize]byte]*list.Element{}}
+}
+
+// get returns the path behind hash, which is nil if hash is known not to name any path. ok is false if hash isn't
+// remembered either way.
+func (p *hashedPaths) get(hash [pathHashSize]byte) (path []string, ok bool) {
+	element, ok := p.entries[hash]
+	if !ok {
+		return nil, false
+	}
+	p.order.MoveToFront(element)
+	return element.Value.(*hashedPath).path, true
+}
+
+// put remembers path as the one behind hash, forgetting the least recently used entry if there are too many.
+func (p *hashedPaths) put(hash [pathHashSize]byte, path []string) {
+	if element, ok := p.entries[hash]; ok {
+		element.Value.(*hashedPath).path = path
+		p.order.MoveToFront(element)
+		return
+	}
+	p.entries[hash] = p.order.PushFront(&hashedPath{hash: hash, path: path})
+	if p.order.Len() > p.size {
+		oldest := p.order.Back()
+		p.order.Remove(oldest)
+		delete(p.entries, oldest.Value.(*hashedPath).hash)
+	}
+}
+
+// NewStableHandler serves fs through handler with stable file handles. exportId names what is being exported, for
+// example the repository and reference, so handles from a different export are rejected rather than resolved to the
+// wrong file.
+func NewStableHandler(handler gonfs.Handler, fs billy.Filesystem, exportId string) *StableHandler {
+	h := &StableHandler{
+		Handler: handler,
+		fs:      fs,
+		hashed:  newHashedPaths(maxHashedPaths),
+	}
+	sum := sha256.Sum256([]byte(exportId))
+	copy(h.exportId[:], sum[:])
+	return h
+}
+
+func hashPath(path []string) [pathHashSize]byte {
+	sum := sha256.Sum256([]byte(strings.Join(path, "/")))
+	var hash [pathHashSize]byte
+	copy(hash[:], sum[:])
+	return hash
+}
+
+func (h *StableHandler) Mount(ctx context.Context, conn net.Conn, request gonfs.MountRequest) (gonfs.MountStatus, billy.Filesystem, []gonfs.AuthFlavor) {
+	status, _, flavors := h.Handler.Mount(ctx, conn, request)
+	return status, h.fs, flavors
+}
+
+func (h *StableHandler) ToHandle(fs billy.Filesystem, path []string) []byte {
+	_ = fs
+	handle := make([]byte, 0, maxHandleSize)
+	handle = append(handle, handleVersion)
+
+	joined := strings.Join(path, "/")
+	if headerSize+len(joined) <= maxHandleSize {
+		handle = append(handle, handleLiteral)
+		handle = append(handle, h.exportId[:]...)
+		return append(handle, joined...)
+	}
+
+	hash := hashPath(path)
+	h.mu.Lock()
+	h.hashed.put(hash, append([]string(nil), path...))
+	h.mu.Unlock()
+
+	handle = append(handle, handleHashed)
+	handle = append(handle, h.exportId[:]...)
+	return append(handle, hash[:]...)
+}
+
+func (h *StableHandler) FromHandle(handle []byte) (billy.Filesystem, []string, error) {
+	if len(handle) < headerSize || handle[0] != handleVersion || !bytes.Equal(handle[2:headerSize], h.exportId[:]) {
+		return nil, nil, ErrStaleHandle
+	}
+	payload := handle[headerSize:]
+
+	switch handle[1] {
+	case handleLiteral:
+		if len(payload) == 0 {
+			return h.fs, []string{}, nil
+		}
+		return h.fs, strings.Split(string(payload), "/"), nil
+	case handleHashed:
+		if len(payload) != pathHashSize {
+			return nil, nil, ErrStaleHandle
+		}
+		var hash [pathHashSize]byte
+		copy(hash[:], payload)
+		path, err := h.findHashed(hash)
+		if err != nil {
+			return nil, nil, err
+		}
+		return h.fs, path, nil
+	default:
+		return nil, nil, ErrStaleHandle
+	}
+}
+
+// findHashed looks up the path behind a hashed handle, walking the tree to find it if it was handed out before a
+// restart or has since been forgotten. Hashes a walk doesn't find are remembered as stale, so handles for files that
+// are gone don't walk the tree again.
+func (h *StableHandler) findHashed(hash [pathHashSize]byte) ([]string, error) {
+	if path, ok, err := h.lookupHashed(hash); ok {
+		return path, err
+	}
+
+	h.walking.Lock()
+	defer h.walking.Unlock()
+	// Another request may have walked the tree while this one waited.
+	if path, ok, err := h.lookupHashed(hash); ok {
+		return path, err
+	}
+	var path []string
+	if err := h.walk(nil, hash, &path); err != nil {
+		return nil, err
+	}
+	if path == nil {
+		h.mu.Lock()
+		h.hashed.put(hash, nil)
+		h.mu.Unlock()
+		return nil, ErrStaleHandle
+	}
+	return path, nil
+}
+
+// lookupHashed returns the path remembered for hash, failing with ErrStaleHandle if hash is known not to name any. ok
+// is false if nothing is remembered for hash.
+func (h *StableHandler) lookupHashed(hash [pathHashSize]byte) (path []string, ok bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	path, ok = h.hashed.get(hash)
+	if ok && path == nil {
+		return nil, true, ErrStaleHandle
+	}
+	return path, ok, nil
+}
+
+// walk remembers the hash of every long path under dir until it finds the one matching hash.
+func (h *StableHandler) walk(dir []string, hash [pathHashSize]byte, found *[]string) error {
+	files, err := h.fs.ReadDir(h.fs.Join(append([]string{"."}, dir...)...))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		path := append(append([]string(nil), dir...), file.Name())
+		if headerSize+len(strings.Join(path, "/")) > maxHandleSize {
+			pathHash := hashPath(path)
+			h.mu.Lock()
+			h.hashed.put(pathHash, path)
+			h.mu.Unlock()
+			if pathHash == hash {
+				*found = path
+				return nil
+			}
+		}
+		if file.IsDir() {
+			if err := h.walk(path, hash, found); err != nil || *found != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// HandleLimit is reported for go-nfs's own bookkeeping. Handles from this handler never expire.
+func (h *StableHandler) HandleLimit() int {
+	return 1024
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"strings"
+	"testing"
+)
+
+// countingFileSystem counts the directories read from it.
+type countingFileSystem struct {
+	billy.Filesystem
+	reads int
+}
+
+func (fs *countingFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	fs.reads++
+	return fs.Filesystem.ReadDir(path)
+}
+
+func TestStableHandler(t *testing.T) {
+	fs := memfs.New()
+	long := strings.Repeat("directory/", 8) + "file.txt"
+	for _, name := range []string{"short.txt", long} {
+		if err := fs.MkdirAll(fs.Join(".", name, ".."), 0755); err != nil {
+			t.Fatal(err)
+		}
+		file, err := fs.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+
+	paths := [][]string{
+		{},
+		{"short.txt"},
+		strings.Split(long, "/"),
+	}
+
+	handler := NewStableHandler(nil, fs, "repository@master")
+	for _, path := range paths {
+		handle := handler.ToHandle(fs, path)
+		if len(handle) > maxHandleSize {
+			t.Fatalf("handle for %v is %d bytes", path, len(handle))
+		}
+
+		// A new handler stands in for the daemon restarting.
+		restarted := NewStableHandler(nil, fs, "repository@master")
+		if diff := cmp.Diff(handle, restarted.ToHandle(fs, path)); diff != "" {
+			t.Fatalf("handle for %v changed across a restart: %s", path, diff)
+		}
+		_, got, err := restarted.FromHandle(handle)
+		if err != nil {
+			t.Fatalf("FromHandle() for %v failed after a restart: %v", path, err)
+		}
+		if diff := cmp.Diff(path, got); diff != "" {
+			t.Fatalf("FromHandle() returned the wrong path: %s", diff)
+		}
+
+		other := NewStableHandler(nil, fs, "repository@release")
+		if _, _, err := other.FromHandle(handle); err != ErrStaleHandle {
+			t.Fatalf("handle for %v was accepted by another export: %v", path, err)
+		}
+	}
+
+	if _, _, err := handler.FromHandle([]byte{1}); err != ErrStaleHandle {
+		t.Fatalf("FromHandle() accepted a truncated handle: %v", err)
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		counting := &countingFileSystem{Filesystem: fs}
+		handler := NewStableHandler(nil, counting, "repository@master")
+		gone := handler.ToHandle(counting, strings.Split(strings.Repeat("directory/", 8)+"gone.txt", "/"))
+
+		restarted := NewStableHandler(nil, counting, "repository@master")
+		for i := 0; i < 3; i++ {
+			if _, _, err := restarted.FromHandle(gone); err != ErrStaleHandle {
+				t.Fatalf("FromHandle() for a missing file = %v, expected ErrStaleHandle", err)
+			}
+		}
+		if walked := counting.reads; walked != 9 {
+			t.Fatalf("resolving a missing file three times read %d directories, expected one walk of 9", walked)
+		}
+	})
+}
+
+func TestHashedPaths(t *testing.T) {
+	paths := newHashedPaths(2)
+	a, b, c := hashPath([]string{"a"}), hashPath([]string{"b"}), hashPath([]string{"c"})
+	paths.put(a, []string{"a"})
+	paths.put(b, nil)
+	paths.get(a)
+	paths.put(c, []string{"c"})
+
+	if path, ok := paths.get(a); !ok || !cmp.Equal(path, []string{"a"}) {
+		t.Fatalf("get(a) = %v, %v, expected the recently used path to be kept", path, ok)
+	}
+	if _, ok := paths.get(b); ok {
+		t.Fatal("get(b) should have been forgotten as the least recently used")
+	}
+	if path, ok := paths.get(c); !ok || !cmp.Equal(path, []string{"c"}) {
+		t.Fatalf("get(c) = %v, %v", path, ok)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs
+
+import (
+	"context"
+	"crypto/rand"
+	"github.com/go-git/go-billy/v5"
+	gonfs "github.com/willscott/go-nfs"
+	"net"
+	"sync"
+	"time"
+)
+
+// ServePerClient serves NFS on listener like go-nfs's Serve but gives every connection the handler newHandler builds
+// for the client's address. go-nfs never tells the filesystem which connection a request came from so this is the
+// only way to decorate it per client, for example to record who read what in an access log.
+func ServePerClient(listener net.Listener, newHandler func(client string) gonfs.Handler) error {
+	// Every connection shares a server id so clients see the same verifiers whichever connection they use.
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if ne, ok := err.(net.Error); ok && ne.Temporary() {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		} else if err != nil {
+			return err
+		}
+		go func() {
+			server := &gonfs.Server{Handler: newHandler(clientAddress(conn)), ID: id}
+			_ = server.Serve(newConnListener(conn))
+		}()
+	}
+}
+
+// connListener is a net.Listener that accepts a single connection and then blocks until that connection is closed.
+type connListener struct {
+	conns  chan net.Conn
+	addr   net.Addr
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	l := &connListener{
+		conns:  make(chan net.Conn, 1),
+		addr:   conn.LocalAddr(),
+		closed: make(chan struct{}),
+	}
+	l.conns <- closingConn{Conn: conn, listener: l}
+	return l
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// closingConn closes its listener along with itself so go-nfs stops serving once the client goes away.
+type closingConn struct {
+	net.Conn
+	listener *connListener
+}
+
+func (c closingConn) Close() error {
+	_ = c.listener.Close()
+	return c.Conn.Close()
+}
+
+// clientHandler serves its own filesystem in place of the one the wrapped handler resolves handles to.
+type clientHandler struct {
+	gonfs.Handler
+	fs billy.Filesystem
+}
+
+// NewClientHandler serves fs through handler. fs should be a decorated view of the filesystem handler serves, since
+// handles are still made and resolved by handler.
+func NewClientHandler(handler gonfs.Handler, fs billy.Filesystem) gonfs.Handler {
+	return clientHandler{Handler: handler, fs: fs}
+}
+
+func (h clientHandler) Mount(ctx context.Context, conn net.Conn, request gonfs.MountRequest) (gonfs.MountStatus, billy.Filesystem, []gonfs.AuthFlavor) {
+	status, _, flavors := h.Handler.Mount(ctx, conn, request)
+	return status, h.fs, flavors
+}
+
+func (h clientHandler) FromHandle(handle []byte) (billy.Filesystem, []string, error) {
+	_, path, err := h.Handler.FromHandle(handle)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.fs, path, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs
+
+import (
+	"github.com/go-git/go-billy/v5/memfs"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnListener(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	listener := newConnListener(server)
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept() failed: %v", err)
+	}
+
+	accepted := make(chan error)
+	go func() {
+		_, err := listener.Accept()
+		accepted <- err
+	}()
+	select {
+	case err := <-accepted:
+		t.Fatalf("Accept() returned a second time before the connection closed: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	conn.Close()
+	if err := <-accepted; err == nil {
+		t.Fatal("Accept() should fail once the connection is closed")
+	}
+}
+
+func TestClientHandler(t *testing.T) {
+	shared := memfs.New()
+	perClient := memfs.New()
+	handler := NewClientHandler(NewStableHandler(nil, shared, "repository@master"), perClient)
+
+	handle := handler.ToHandle(perClient, []string{"file.txt"})
+	fs, path, err := handler.FromHandle(handle)
+	if err != nil {
+		t.Fatalf("FromHandle() failed: %v", err)
+	}
+	if fs != perClient || len(path) != 1 || path[0] != "file.txt" {
+		t.Fatalf("FromHandle() returned %v, %v instead of the client's filesystem", fs, path)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+)
+
+// ONC RPC constants from RFC 5531 and the portmapper protocol from RFC 1833.
+const (
+	rpcCall    uint32 = 0
+	rpcReply   uint32 = 1
+	rpcVersion uint32 = 2
+
+	msgAccepted uint32 = 0
+	msgDenied   uint32 = 1
+
+	acceptSuccess      uint32 = 0
+	acceptProgUnavail  uint32 = 1
+	acceptProgMismatch uint32 = 2
+	acceptProcUnavail  uint32 = 3
+	acceptGarbageArgs  uint32 = 4
+
+	rejectRpcMismatch uint32 = 0
+
+	PortmapperProgram uint32 = 100000
+	portmapperVersion uint32 = 2
+
+	portmapperNull    uint32 = 0
+	portmapperGetPort uint32 = 3
+	portmapperDump    uint32 = 4
+
+	NfsProgram   uint32 = 100003
+	MountProgram uint32 = 100005
+
+	ProtocolTCP uint32 = 6
+	ProtocolUDP uint32 = 17
+
+	// lastFragment marks the final fragment of a record on a stream transport.
+	lastFragment uint32 = 1 << 31
+	// maxRecordSize bounds the requests the portmapper reads. Real requests are well under 1 KiB.
+	maxRecordSize = 64 * 1024
+)
+
+var errMalformedCall = errors.New("malformed rpc call")
+
+// Mapping tells clients which port serves a version of an RPC program.
+type Mapping struct {
+	Program, Version, Protocol, Port uint32
+}
+
+// Portmapper is a read-only portmapper (rpcbind version 2) that answers for a fixed set of mappings. It lets stock
+// clients find the NFS and MOUNT services without being told the port, for hosts that don't already run rpcbind.
+type Portmapper struct {
+	mappings []Mapping
+}
+
+func NewPortmapper(mappings ...Mapping) *Portmapper {
+	return &Portmapper{mappings: mappings}
+}
+
+// ServeUDP answers portmapper calls arriving on conn until it is closed.
+func (p *Portmapper) ServeUDP(conn net.PacketConn) error {
+	buffer := make([]byte, maxRecordSize)
+	for {
+		n, address, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return err
+		}
+		reply, err := p.handle(buffer[:n])
+		if err != nil {
+			log.Printf("portmapper: dropping call from %s: %v", address, err)
+			continue
+		}
+		if _, err := conn.WriteTo(reply, address); err != nil {
+			log.Printf("portmapper: failed to reply to %s: %v", address, err)
+		}
+	}
+}
+
+// ServeTCP answers portmapper calls from connections accepted on listener until it is closed.
+func (p *Portmapper) ServeTCP(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := p.serveConn(conn); err != nil && err != io.EOF {
+				log.Printf("portmapper: connection from %s failed: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// serveConn handles record-marked calls on a stream connection.
+func (p *Portmapper) serveConn(conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+	for {
+		var record []byte
+		for {
+			var header uint32
+			if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
+				return err
+			}
+			size := header &^ lastFragment
+			if len(record)+int(size) > maxRecordSize {
+				return errMalformedCall
+			}
+			fragment := make([]byte, size)
+			if _, err := io.ReadFull(reader, fragment); err != nil {
+				return err
+			}
+			record = append(record, fragment...)
+			if header&lastFragment != 0 {
+				break
+			}
+		}
+
+		reply, err := p.handle(record)
+		if err != nil {
+			return err
+		}
+		framed := make([]byte, 4, 4+len(reply))
+		binary.BigEndian.PutUint32(framed, lastFragment|uint32(len(reply)))
+		if _, err := conn.Write(append(framed, reply...)); err != nil {
+			return err
+		}
+	}
+}
+
+// xdrReader decodes the handful of XDR types a portmapper call uses.
+type xdrReader struct {
+	data []byte
+	err  error
+}
+
+func (r *xdrReader) uint32() uint32 {
+	if r.err != nil || len(r.data) < 4 {
+		r.err = errMalformedCall
+		return 0
+	}
+	value := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return value
+}
+
+// opaque skips variable length opaque data, which is padded to a multiple of four bytes.
+func (r *xdrReader) opaque() {
+	size := int(r.uint32())
+	padded := (size + 3) &^ 3
+	if r.err != nil || size > len(r.data) || padded > len(r.data) {
+		r.err = errMalformedCall
+		return
+	}
+	r.data = r.data[padded:]
+}
+
+func appendUint32(buffer []byte, values ...uint32) []byte {
+	for _, value := range values {
+		var encoded [4]byte
+		binary.BigEndian.PutUint32(encoded[:], value)
+		buffer = append(buffer, encoded[:]...)
+	}
+	return buffer
+}
+
+// handle decodes one RPC call and builds the reply to it.
+func (p *Portmapper) handle(call []byte) ([]byte, error) {
+	r := &xdrReader{data: call}
+	xid := r.uint32()
+	messageType := r.uint32()
+	version := r.uint32()
+	program := r.uint32()
+	programVersion := r.uint32()
+	procedure := r.uint32()
+	// Credentials and verifier. Every caller is treated the same so both are skipped.
+	r.uint32()
+	r.opaque()
+	r.uint32()
+	r.opaque()
+	if r.err != nil || messageType != rpcCall {
+		return nil, errMalformedCall
+	}
+
+	reply := appendUint32(nil, xid, rpcReply)
+	if version != rpcVersion {
+		return appendUint32(reply, msgDenied, rejectRpcMismatch, rpcVersion, rpcVersion), nil
+	}
+	// Accepted replies carry a null verifier.
+	reply = appendUint32(reply, msgAccepted, 0, 0)
+
+	if program != PortmapperProgram {
+		return appendUint32(reply, acceptProgUnavail), nil
+	}
+	if programVersion != portmapperVersion {
+		return appendUint32(reply, acceptProgMismatch, portmapperVersion, portmapperVersion), nil
+	}
+
+	switch procedure {
+	case portmapperNull:
+		return appendUint32(reply, acceptSuccess), nil
+	case portmapperGetPort:
+		wanted := Mapping{Program: r.uint32(), Version: r.uint32(), Protocol: r.uint32()}
+		if r.err != nil {
+			return appendUint32(reply, acceptGarbageArgs), nil
+		}
+		// Zero tells the client the program isn't registered.
+		var port uint32
+		for _, mapping := range p.mappings {
+			if mapping.Program == wanted.Program && mapping.Version == wanted.Version && mapping.Protocol == wanted.Protocol {
+				port = mapping.Port
+				break
+			}
+		}
+		return appendUint32(reply, acceptSuccess, port), nil
+	case portmapperDump:
+		reply = appendUint32(reply, acceptSuccess)
+		for _, mapping := range p.mappings {
+			reply = appendUint32(reply, 1, mapping.Program, mapping.Version, mapping.Protocol, mapping.Port)
+		}
+		return appendUint32(reply, 0), nil
+	default:
+		return appendUint32(reply, acceptProcUnavail), nil
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs
+
+import (
+	"encoding/binary"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"net"
+	"testing"
+)
+
+func getPortCall(xid, program, version, protocol uint32) []byte {
+	return appendUint32(nil,
+		xid, rpcCall, rpcVersion, PortmapperProgram, portmapperVersion, portmapperGetPort,
+		// AUTH_NONE credentials and verifier.
+		0, 0, 0, 0,
+		program, version, protocol, 0)
+}
+
+func TestPortmapper(t *testing.T) {
+	portmapper := NewPortmapper(
+		Mapping{Program: NfsProgram, Version: 3, Protocol: ProtocolTCP, Port: 2049},
+		Mapping{Program: MountProgram, Version: 3, Protocol: ProtocolTCP, Port: 2049},
+	)
+
+	t.Run("getport", func(t *testing.T) {
+		tests := map[string]struct {
+			call []byte
+			port uint32
+		}{
+			"nfs":           {getPortCall(1, NfsProgram, 3, ProtocolTCP), 2049},
+			"mount":         {getPortCall(2, MountProgram, 3, ProtocolTCP), 2049},
+			"unregistered":  {getPortCall(3, MountProgram, 3, ProtocolUDP), 0},
+			"wrong version": {getPortCall(4, NfsProgram, 4, ProtocolTCP), 0},
+		}
+		for name, test := range tests {
+			reply, err := portmapper.handle(test.call)
+			if err != nil {
+				t.Fatalf("%s: handle() failed: %v", name, err)
+			}
+			xid := binary.BigEndian.Uint32(test.call)
+			want := appendUint32(nil, xid, rpcReply, msgAccepted, 0, 0, acceptSuccess, test.port)
+			if diff := cmp.Diff(want, reply); diff != "" {
+				t.Fatalf("%s: %s", name, diff)
+			}
+		}
+	})
+
+	t.Run("dump", func(t *testing.T) {
+		call := appendUint32(nil, 9, rpcCall, rpcVersion, PortmapperProgram, portmapperVersion, portmapperDump, 0, 0, 0, 0)
+		reply, err := portmapper.handle(call)
+		if err != nil {
+			t.Fatalf("handle() failed: %v", err)
+		}
+		want := appendUint32(nil, 9, rpcReply, msgAccepted, 0, 0, acceptSuccess,
+			1, NfsProgram, 3, ProtocolTCP, 2049,
+			1, MountProgram, 3, ProtocolTCP, 2049,
+			0)
+		if diff := cmp.Diff(want, reply); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		if _, err := portmapper.handle([]byte{0, 0, 0, 1}); err == nil {
+			t.Fatalf("handle() accepted a truncated call")
+		}
+		call := appendUint32(nil, 5, rpcCall, rpcVersion, NfsProgram, 3, 0, 0, 0, 0, 0)
+		reply, err := portmapper.handle(call)
+		if err != nil {
+			t.Fatalf("handle() failed: %v", err)
+		}
+		if diff := cmp.Diff(appendUint32(nil, 5, rpcReply, msgAccepted, 0, 0, acceptProgUnavail), reply); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		go portmapper.ServeUDP(conn)
+
+		client, err := net.Dial("udp", conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		if _, err := client.Write(getPortCall(7, NfsProgram, 3, ProtocolTCP)); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, 64)
+		n, err := client.Read(reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 28 || binary.BigEndian.Uint32(reply[24:]) != 2049 {
+			t.Fatalf("unexpected reply %v", reply[:n])
+		}
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go portmapper.ServeTCP(listener)
+
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		call := getPortCall(8, MountProgram, 3, ProtocolTCP)
+		if _, err := client.Write(append(appendUint32(nil, lastFragment|uint32(len(call))), call...)); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, 32)
+		if _, err := io.ReadFull(client, reply); err != nil {
+			t.Fatal(err)
+		}
+		if binary.BigEndian.Uint32(reply) != lastFragment|28 || binary.BigEndian.Uint32(reply[28:]) != 2049 {
+			t.Fatalf("unexpected reply %v", reply)
+		}
+	})
+}
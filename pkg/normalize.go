@@ -0,0 +1,240 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"golang.org/x/text/unicode/norm"
+	"os"
+	"sync"
+)
+
+// UnicodeNormalization is the Unicode normalization form names are served in. Git stores names as whatever bytes
+// were committed, so the same name can be in the repository in one form and asked for in another, like by macOS,
+// which decomposes the names Finder and its APIs create.
+type UnicodeNormalization uint8
+
+const (
+	// NormalizeNone serves names exactly as they were committed and only finds them by the same bytes.
+	NormalizeNone UnicodeNormalization = iota
+	// NormalizeNFC lists names composed and finds them in any form.
+	NormalizeNFC
+	// NormalizeNFD lists names decomposed and finds them in any form.
+	NormalizeNFD
+)
+
+func ParseUnicodeNormalization(name string) (UnicodeNormalization, error) {
+	switch name {
+	case "none":
+		return NormalizeNone, nil
+	case "nfc":
+		return NormalizeNFC, nil
+	case "nfd":
+		return NormalizeNFD, nil
+	default:
+		return 0, fmt.Errorf("unknown unicode normalization '%s', expected none, nfc, or nfd", name)
+	}
+}
+
+func (n UnicodeNormalization) String() string {
+	switch n {
+	case NormalizeNone:
+		return "none"
+	case NormalizeNFC:
+		return "nfc"
+	case NormalizeNFD:
+		return "nfd"
+	default:
+		return "unknown-unicode-normalization"
+	}
+}
+
+// maxNormalizedDirectories bounds how many directories normalizedIndexes holds the names of.
+const maxNormalizedDirectories = 4096
+
+// normalizedIndexes maps the NFC form of each name in a directory to the names committed in that directory that
+// normalize to it, keyed by the hash of the directory's tree so an index never goes stale.
+type normalizedIndexes struct {
+	mu      sync.Mutex
+	indexes map[string]map[string][]string
+}
+
+// normalizingFileSystem lists names in one normalization form and finds them by any.
+type normalizingFileSystem struct {
+	billy.Filesystem
+	form    norm.Form
+	indexes *normalizedIndexes
+}
+
+// form is the normalization form names are listed in.
+func (n UnicodeNormalization) form() norm.Form {
+	if n == NormalizeNFD {
+		return norm.NFD
+	}
+	return norm.NFC
+}
+
+// NewNormalizingFileSystem lists names in the normalization form and finds every path no matter which form its
+// names are asked for in. It is fs if form is NormalizeNone.
+func NewNormalizingFileSystem(fs billy.Filesystem, form UnicodeNormalization) billy.Filesystem {
+	if form == NormalizeNone {
+		return fs
+	}
+	return normalizingFileSystem{
+		Filesystem: fs,
+		form:       form.form(),
+		indexes:    &normalizedIndexes{indexes: map[string]map[string][]string{}},
+	}
+}
+
+// index maps the NFC form of each name in directory to the names committed.
+func (s normalizingFileSystem) index(directory string) (map[string][]string, error) {
+	hash := ""
+	if info, err := s.Filesystem.Lstat(directory); err == nil {
+		hash, _ = ObjectHash(info)
+	}
+	if hash != "" {
+		s.indexes.mu.Lock()
+		index, ok := s.indexes.indexes[hash]
+		s.indexes.mu.Unlock()
+		if ok {
+			return index, nil
+		}
+	}
+
+	names, err := ReadDirNames(s.Filesystem, directory)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string][]string, len(names))
+	for _, name := range names {
+		normalized := norm.NFC.String(name)
+		index[normalized] = append(index[normalized], name)
+	}
+
+	if hash != "" {
+		s.indexes.mu.Lock()
+		if len(s.indexes.indexes) >= maxNormalizedDirectories {
+			s.indexes.indexes = map[string]map[string][]string{}
+		}
+		s.indexes.indexes[hash] = index
+		s.indexes.mu.Unlock()
+	}
+	return index, nil
+}
+
+// resolve finds the committed path filename refers to. Paths that don't match anything are returned as they were for
+// the underlying filesystem to reject.
+func (s normalizingFileSystem) resolve(filename string) string {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil || resolved.IsRoot() {
+		return filename
+	}
+	if _, err := s.Filesystem.Lstat(resolved.String()); err == nil {
+		return filename
+	}
+
+	matched := RootGitPath()
+	for _, part := range resolved.Path {
+		index, err := s.index(matched.String())
+		if err != nil {
+			return filename
+		}
+		names := index[norm.NFC.String(part)]
+		if len(names) == 0 {
+			return filename
+		}
+		// The same name can be committed in more than one form, in which case an exact match wins.
+		name := names[0]
+		for _, committed := range names {
+			if committed == part {
+				name = committed
+			}
+		}
+		matched = FilePath{Path: append(matched.Path, name)}
+	}
+	return matched.String()
+}
+
+// normalized renames info to the form names are listed in.
+func (s normalizingFileSystem) normalized(info os.FileInfo) os.FileInfo {
+	if name := s.form.String(info.Name()); name != info.Name() {
+		return followedInfo{FileInfo: info, name: name}
+	}
+	return info
+}
+
+func (s normalizingFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s normalizingFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	return s.Filesystem.OpenFile(s.resolve(filename), flag, perm)
+}
+
+func (s normalizingFileSystem) Stat(filename string) (os.FileInfo, error) {
+	info, err := s.Filesystem.Stat(s.resolve(filename))
+	if err != nil {
+		return nil, err
+	}
+	return s.normalized(info), nil
+}
+
+func (s normalizingFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	info, err := s.Filesystem.Lstat(s.resolve(filename))
+	if err != nil {
+		return nil, err
+	}
+	return s.normalized(info), nil
+}
+
+func (s normalizingFileSystem) ReadDir(filename string) ([]os.FileInfo, error) {
+	files, err := s.Filesystem.ReadDir(s.resolve(filename))
+	if err != nil {
+		return nil, err
+	}
+	for i, file := range files {
+		files[i] = s.normalized(file)
+	}
+	return files, nil
+}
+
+func (s normalizingFileSystem) ReadDirNames(filename string) ([]string, error) {
+	names, err := ReadDirNames(s.Filesystem, s.resolve(filename))
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range names {
+		names[i] = s.form.String(name)
+	}
+	return names, nil
+}
+
+func (s normalizingFileSystem) Readlink(link string) (string, error) {
+	return s.Filesystem.Readlink(s.resolve(link))
+}
+
+// Chroot keeps normalizing names in the new root.
+func (s normalizingFileSystem) Chroot(filename string) (billy.Filesystem, error) {
+	fs, err := s.Filesystem.Chroot(s.resolve(filename))
+	if err != nil {
+		return nil, err
+	}
+	chrooted := s
+	chrooted.Filesystem = fs
+	return chrooted, nil
+}
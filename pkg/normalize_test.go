@@ -0,0 +1,79 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/text/unicode/norm"
+	"sort"
+	"testing"
+)
+
+func TestNormalizingFileSystem(t *testing.T) {
+	composed := norm.NFC.String("café.txt")
+	decomposedDirectory := norm.NFD.String("résumé")
+	git := NewFakeGit(nil)
+	git.Commit("master", "Add accented names", map[string]FakeFile{
+		composed: {Contents: "coffee"},
+		decomposedDirectory + "/" + norm.NFD.String("naïve.txt"): {Contents: "naive"},
+	})
+	reference := NewReferenceFileSystem(git, BranchRef("master"))
+
+	if _, ok := NewNormalizingFileSystem(reference, NormalizeNone).(ReferenceFileSystem); !ok {
+		t.Fatal("NormalizeNone should serve the filesystem as it is")
+	}
+
+	for _, form := range []UnicodeNormalization{NormalizeNFC, NormalizeNFD} {
+		t.Run(form.String(), func(t *testing.T) {
+			fs := NewNormalizingFileSystem(reference, form)
+			for _, asked := range []norm.Form{norm.NFC, norm.NFD} {
+				contents, err := util.ReadFile(fs, asked.String("café.txt"))
+				if err != nil || string(contents) != "coffee" {
+					t.Fatalf("reading café.txt returned %q, %v", contents, err)
+				}
+				contents, err = util.ReadFile(fs, asked.String("résumé/naïve.txt"))
+				if err != nil || string(contents) != "naive" {
+					t.Fatalf("reading résumé/naïve.txt returned %q, %v", contents, err)
+				}
+			}
+
+			listed := form.form()
+			files, err := fs.ReadDir(".")
+			if err != nil {
+				t.Fatalf("ReadDir(.) failed: %v", err)
+			}
+			var names []string
+			for _, file := range files {
+				names = append(names, file.Name())
+			}
+			sort.Strings(names)
+			want := []string{listed.String("café.txt"), listed.String("résumé")}
+			sort.Strings(want)
+			if diff := cmp.Diff(want, names); diff != "" {
+				t.Fatalf("unexpected listing (-want +got):\n%s", diff)
+			}
+
+			chrooted, err := fs.Chroot(norm.NFC.String("résumé"))
+			if err != nil {
+				t.Fatalf("Chroot(résumé) failed: %v", err)
+			}
+			info, err := chrooted.Stat(norm.NFC.String("naïve.txt"))
+			if err != nil || info.Name() != listed.String("naïve.txt") {
+				t.Fatalf("Stat(naïve.txt) = %v, %v", info, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"time"
+)
+
+// defaultCachedFileSize is the largest file CacheOptions keeps when it isn't given a MaxFileSize.
+const defaultCachedFileSize = 1 << 20
+
+// CacheOptions picks what New keeps around between opens of the same file.
+type CacheOptions struct {
+	// Cache, if set, keeps the contents of files opened through the filesystem under blob/<hash>, the same key a Git
+	// given WithCache uses, so one Cache can back both.
+	Cache Cache
+	// MaxFileSize is the largest file, in bytes, put in Cache. It defaults to 1MiB.
+	MaxFileSize int64
+}
+
+// Options configures the filesystem New serves. The zero value serves HEAD with symlinks rewritten to stay inside of
+// the tree, the same as NewReferenceFileSystem.
+type Options struct {
+	// Ref is the commit served. It defaults to HEAD.
+	Ref Ref
+	// Subdir, if set, is a directory in the tree to serve instead of its root. It can't be combined with History or
+	// Introspection, which find paths from the root of the tree.
+	Subdir string
+	// CaseInsensitive lets every path be looked up with any capitalization. Listings still show the committed names.
+	CaseInsensitive bool
+	// Normalization lists names in a Unicode normalization form and lets them be looked up in any form.
+	Normalization UnicodeNormalization
+	// Symlinks decides how symlinks pointing outside of the tree are served.
+	Symlinks SymlinkPolicy
+	// FollowSymlinks makes Stat and OpenFile act on what symlinks point to, like os.Stat and os.Open, instead of on
+	// the links themselves. Lstat, ReadDir, and Readlink still show the links.
+	FollowSymlinks bool
+	// DereferenceSymlinks serves symlinks pointing into the tree as what they point to from every call, like
+	// NewDereferenceFileSystem, for clients that can't follow symlinks at all.
+	DereferenceSymlinks bool
+	// Hide removes every path matching one of these patterns, along with everything inside of it. Patterns are
+	// matched like MatchesAny, relative to Subdir.
+	Hide  []string
+	Cache CacheOptions
+	// Clock tells the time for the filesystem, like when a file was opened. It defaults to time.Now.
+	Clock func() time.Time
+
+	// DirectoryTimes dates each directory by the last commit to change something within it. It is ignored for the
+	// index, which has no commits.
+	DirectoryTimes bool
+	// DirtyWorktree, if set, is a worktree whose uncommitted changes are served over the commit.
+	DirtyWorktree string
+	// History serves every version of each file under /history-of/. It is ignored for the index.
+	History bool
+	// Releases serves the tree of every semantic version tag under /releases/.
+	Releases bool
+	// MaxFileSize refuses to read files larger than this many bytes. 0 is unlimited.
+	MaxFileSize int64
+	// RateLimits throttles opening large files and listing directories.
+	RateLimits RateLimits
+	// ChunkSeparator, if set, presents files split into chunks named <file><separator><n> as the file they were
+	// split from.
+	ChunkSeparator string
+	// Archives browses archives as read-only directories.
+	Archives bool
+	// Templates expands the variables of NewReferenceTemplateVariables in files matching these patterns.
+	Templates []string
+	// BuildCache, if set, holds the files the tree's .gitignore ignores, so builds can write their outputs.
+	BuildCache billy.Filesystem
+	// Dotfiles decides how names starting with a dot are hidden.
+	Dotfiles DotfilePolicy
+	// TextOnly serves only files that look like text.
+	TextOnly bool
+	// Introspection serves /.gitfs/ describing what is served. It is ignored for the index, which has no commit to
+	// describe.
+	Introspection bool
+	// Bisection, if set, serves /.gitfs/bisect/ to drive it.
+	Bisection *Bisection
+	// ExposeGitObjects serves the refs and objects of GitDir read-only under /.gitobjects/.
+	ExposeGitObjects bool
+	// GitDir is the repository's git directory. Introspection names the repository after it.
+	GitDir string
+	// Label tells apart the statistics of filesystems sharing a Git. See JoinLabels.
+	Label string
+	// SecretFilter refuses to serve files matching SecretPatterns, or DefaultSecretPatterns if there are none.
+	SecretFilter   bool
+	SecretPatterns []string
+	// DirectoryOrder orders directory listings.
+	DirectoryOrder DirectoryOrder
+}
+
+// New serves the tree of git chosen by options. It is the one constructor every option is added to, so library users
+// don't need to know which decorators to stack in which order.
+func New(git Git, options Options) (billy.Filesystem, error) {
+	reference := options.Ref
+	if reference == (Ref{}) {
+		reference = CommitRef("HEAD")
+	}
+	clock := options.Clock
+	if clock == nil {
+		clock = time.Now
+	}
+	// Neither has anything to describe about the index.
+	indexed := reference.Kind == RefIndex
+	history := options.History && !indexed
+	introspection := options.Introspection && !indexed
+	if options.Subdir != "" && (history || introspection) {
+		return nil, fmt.Errorf("a subdirectory can't be served with history or introspection")
+	}
+
+	var fs billy.Filesystem = ReferenceFileSystem{
+		git:       git,
+		reference: reference,
+		root:      RootGitPath(),
+		symlinks:  options.Symlinks,
+		targets:   newSymlinkTargets(),
+		clock:     clock,
+	}
+	if options.DirectoryTimes && !indexed {
+		fs = NewDirectoryTimeFileSystem(fs, git, reference)
+	}
+	if options.DirtyWorktree != "" {
+		fs = NewDirtyFileSystem(fs, git, options.DirtyWorktree)
+	}
+	if options.Subdir != "" {
+		var err error
+		fs, err = fs.Chroot(options.Subdir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Cache.Cache != nil {
+		fs = newContentCacheFileSystem(fs, options.Cache)
+	}
+	if history {
+		fs = NewHistoryFileSystem(fs, git, reference)
+	}
+	if options.Releases {
+		fs = NewReleasesFileSystem(fs, git, options.Symlinks)
+	}
+	if options.FollowSymlinks {
+		fs = followSymlinkFileSystem{Filesystem: fs}
+	}
+	if options.DereferenceSymlinks {
+		fs = NewDereferenceFileSystem(fs)
+	}
+	if len(options.Hide) > 0 {
+		fs = hiddenFileSystem{Filesystem: fs, patterns: options.Hide}
+	}
+	fs = NewNormalizingFileSystem(fs, options.Normalization)
+	if options.CaseInsensitive {
+		fs = caseInsensitiveFileSystem{Filesystem: fs}
+	}
+	fs = NewMaxFileSizeFileSystem(fs, options.MaxFileSize)
+	fs = NewRateLimitFileSystem(fs, options.RateLimits)
+	if options.ChunkSeparator != "" {
+		fs = NewChunkedFileSystem(fs, NewSplitChunkConvention(options.ChunkSeparator))
+	}
+	if options.Archives {
+		fs = NewArchiveFileSystem(fs)
+	}
+	fs = NewTemplateFileSystem(fs, options.Templates, NewReferenceTemplateVariables(git, reference))
+	if options.BuildCache != nil {
+		var err error
+		fs, err = NewBuildCacheFileSystem(fs, options.BuildCache)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read .gitignore for the build cache: %v", err)
+		}
+	}
+	fs = NewDotfileFileSystem(fs, options.Dotfiles)
+	if options.TextOnly {
+		fs = NewTextOnlyFileSystem(fs)
+	}
+	if introspection {
+		fs = NewIntrospectionFileSystemWithFilters(fs, git, reference, RepositoryName(options.GitDir), options.Label, options.snapshotFilters())
+	}
+	if options.Bisection != nil {
+		fs = NewBisectFileSystem(fs, options.Bisection)
+	}
+	if options.ExposeGitObjects {
+		fs = NewGitObjectsFileSystem(fs, options.GitDir)
+	}
+	if options.SecretFilter {
+		var err error
+		fs, err = NewSecretFilterFileSystem(fs, options.SecretPatterns)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewOrderedFileSystem(fs, options.DirectoryOrder), nil
+}
+
+// snapshotFilters describes what options change about the files served, for the snapshot ID.
+func (options Options) snapshotFilters() SnapshotFilters {
+	return SnapshotFilters{
+		Symlinks:       options.Symlinks,
+		FollowSymlinks: options.FollowSymlinks || options.DereferenceSymlinks,
+		Normalization:  options.Normalization,
+		MaxFileSize:    options.MaxFileSize,
+		ChunkSeparator: options.ChunkSeparator,
+		Archives:       options.Archives,
+		TextOnly:       options.TextOnly,
+		Releases:       options.Releases,
+		History:        options.History,
+		Templates:      options.Templates,
+		Dotfiles:       options.Dotfiles,
+		SecretFilter:   options.SecretFilter,
+		SecretPatterns: options.SecretPatterns,
+		Hide:           options.Hide,
+		DirtyWorktree:  options.DirtyWorktree != "",
+		GitObjects:     options.ExposeGitObjects,
+	}
+}
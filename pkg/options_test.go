@@ -0,0 +1,198 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	git := fakeSymlinks()
+
+	newFs := func(t *testing.T, options Options) billy.Filesystem {
+		fs, err := New(git, options)
+		if err != nil {
+			t.Fatalf("New(%+v) failed: %v", options, err)
+		}
+		return fs
+	}
+	read := func(t *testing.T, fs billy.Filesystem, filename string) string {
+		contents, err := util.ReadFile(fs, filename)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) failed: %v", filename, err)
+		}
+		return string(contents)
+	}
+	names := func(t *testing.T, fs billy.Filesystem, path string) []string {
+		files, err := fs.ReadDir(path)
+		if err != nil {
+			t.Fatalf("ReadDir(%s) failed: %v", path, err)
+		}
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		return names
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		fs := newFs(t, Options{})
+		if contents := read(t, fs, "real.txt"); contents != "Hello World\n" {
+			t.Fatalf("real.txt contains %q", contents)
+		}
+		if target, err := fs.Readlink("absolute.txt"); err != nil || target != "etc/passwd" {
+			t.Fatalf("Readlink(absolute.txt) = %q, %v, expected the target to be rewritten", target, err)
+		}
+	})
+
+	t.Run("Subdir", func(t *testing.T) {
+		fs := newFs(t, Options{Ref: BranchRef("master"), Subdir: "nested"})
+		if diff := cmp.Diff([]string{"escaping.txt", "up.txt"}, names(t, fs, ".")); diff != "" {
+			t.Fatalf("unexpected listing of nested (-want +got):\n%s", diff)
+		}
+		if _, err := New(git, Options{Subdir: "real.txt"}); err == nil {
+			t.Fatalf("New() with a file as Subdir succeeded")
+		}
+		if _, err := New(git, Options{Subdir: "nested", Introspection: true}); err == nil {
+			t.Fatalf("New() with Subdir and Introspection succeeded")
+		}
+	})
+
+	t.Run("CaseInsensitive", func(t *testing.T) {
+		if _, err := newFs(t, Options{}).Stat("REAL.txt"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Stat(REAL.txt) returned %v, expected it to not exist", err)
+		}
+		fs := newFs(t, Options{CaseInsensitive: true})
+		if contents := read(t, fs, "REAL.txt"); contents != "Hello World\n" {
+			t.Fatalf("REAL.txt contains %q", contents)
+		}
+		if info, err := fs.Lstat("Nested/UP.TXT"); err != nil || info.Name() != "up.txt" {
+			t.Fatalf("Lstat(Nested/UP.TXT) = %v, %v, expected nested/up.txt", info, err)
+		}
+		if _, err := fs.Stat("missing.txt"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Stat(missing.txt) returned %v, expected it to not exist", err)
+		}
+	})
+
+	t.Run("FollowSymlinks", func(t *testing.T) {
+		fs := newFs(t, Options{FollowSymlinks: true})
+		info, err := fs.Stat("nested/up.txt")
+		if err != nil {
+			t.Fatalf("Stat(nested/up.txt) failed: %v", err)
+		}
+		if info.Name() != "up.txt" || !info.Mode().IsRegular() || info.Size() != int64(len("Hello World\n")) {
+			t.Fatalf("Stat(nested/up.txt) = %s %s %d, expected the regular file it links to", info.Name(), info.Mode(), info.Size())
+		}
+		if contents := read(t, fs, "nested/up.txt"); contents != "Hello World\n" {
+			t.Fatalf("nested/up.txt contains %q", contents)
+		}
+		if info, err := fs.Lstat("nested/up.txt"); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Fatalf("Lstat(nested/up.txt) = %v, %v, expected the link itself", info, err)
+		}
+		if _, err := fs.Stat("absolute.txt"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Stat(absolute.txt) returned %v, expected its target to not exist in the tree", err)
+		}
+	})
+
+	t.Run("Hide", func(t *testing.T) {
+		fs := newFs(t, Options{Hide: []string{"nested", "abs*"}})
+		if diff := cmp.Diff([]string{"real.txt", "relative.txt"}, names(t, fs, ".")); diff != "" {
+			t.Fatalf("unexpected listing (-want +got):\n%s", diff)
+		}
+		for _, filename := range []string{"nested", "nested/up.txt", "absolute.txt"} {
+			if _, err := fs.Lstat(filename); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("Lstat(%s) returned %v, expected it to be hidden", filename, err)
+			}
+		}
+
+		// Patterns keep matching whole paths after a chroot.
+		fs = newFs(t, Options{Hide: []string{"nested/up.txt"}})
+		chrooted, err := fs.Chroot("nested")
+		if err != nil {
+			t.Fatalf("Chroot(nested) failed: %v", err)
+		}
+		if diff := cmp.Diff([]string{"escaping.txt"}, names(t, chrooted, ".")); diff != "" {
+			t.Fatalf("unexpected listing of nested (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Cache", func(t *testing.T) {
+		cache := NewMemoryCache(1 << 20)
+		fs := newFs(t, Options{Cache: CacheOptions{Cache: cache}})
+		read(t, fs, "real.txt")
+		info, err := fs.Lstat("real.txt")
+		if err != nil {
+			t.Fatalf("Lstat(real.txt) failed: %v", err)
+		}
+		hash, _ := ObjectHash(info)
+		if contents, err := cache.Get("blob/" + hash); err != nil || string(contents) != "Hello World\n" {
+			t.Fatalf("cache holds %q, %v for real.txt", contents, err)
+		}
+
+		// Served from the cache, so it's still there once git can't read it.
+		if err := cache.Set("blob/"+hash, []byte("cached")); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+		if contents := read(t, fs, "real.txt"); contents != "cached" {
+			t.Fatalf("real.txt contains %q, expected what was cached", contents)
+		}
+	})
+
+	t.Run("Stack", func(t *testing.T) {
+		fs := newFs(t, Options{
+			Ref:            BranchRef("master"),
+			Introspection:  true,
+			SecretFilter:   true,
+			Hide:           []string{"absolute.txt"},
+			DirectoryOrder: OrderName,
+		})
+		if diff := cmp.Diff([]string{".gitfs", "nested", "real.txt", "relative.txt"}, names(t, fs, ".")); diff != "" {
+			t.Fatalf("unexpected listing (-want +got):\n%s", diff)
+		}
+		commit, err := git.ResolveCommit(BranchRef("master"))
+		if err != nil {
+			t.Fatalf("ResolveCommit(master) failed: %v", err)
+		}
+		if contents := read(t, fs, ".gitfs/commit"); contents != commit+"\n" {
+			t.Fatalf(".gitfs/commit contains %q, expected %s", contents, commit)
+		}
+	})
+
+	t.Run("Clock", func(t *testing.T) {
+		now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		fs := newFs(t, Options{Clock: func() time.Time { return now }})
+		file, err := fs.Open("real.txt")
+		if err != nil {
+			t.Fatalf("Open(real.txt) failed: %v", err)
+		}
+		defer file.Close()
+		for _, handle := range OpenHandles(git) {
+			if handle.Path == "real.txt" {
+				if !handle.Opened.Equal(now) {
+					t.Fatalf("real.txt was opened at %s, expected %s", handle.Opened, now)
+				}
+				return
+			}
+		}
+		t.Fatalf("real.txt isn't in the open handles")
+	})
+
+}
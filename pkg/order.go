@@ -0,0 +1,101 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"os"
+	"sort"
+)
+
+// DirectoryOrder decides the order ReadDir lists entries in.
+type DirectoryOrder uint8
+
+const (
+	// OrderGit lists entries in whatever order the backend produced them. For git this is sorted by name, with
+	// directories sorted as if their names ended in "/".
+	OrderGit DirectoryOrder = iota
+	// OrderName sorts entries by name.
+	OrderName
+	// OrderDirectoriesFirst sorts directories by name before everything else sorted by name.
+	OrderDirectoriesFirst
+)
+
+func ParseDirectoryOrder(name string) (DirectoryOrder, error) {
+	switch name {
+	case "git":
+		return OrderGit, nil
+	case "name":
+		return OrderName, nil
+	case "dirs-first":
+		return OrderDirectoriesFirst, nil
+	default:
+		return 0, fmt.Errorf("unknown directory order '%s', expected git, name, or dirs-first", name)
+	}
+}
+
+func (o DirectoryOrder) String() string {
+	switch o {
+	case OrderGit:
+		return "git"
+	case OrderName:
+		return "name"
+	case OrderDirectoriesFirst:
+		return "dirs-first"
+	default:
+		return "unknown-directory-order"
+	}
+}
+
+// orderedFileSystem sorts every listing so it doesn't depend on the backend or the decorators in front of it.
+type orderedFileSystem struct {
+	billy.Filesystem
+	order DirectoryOrder
+}
+
+// NewOrderedFileSystem lists entries of fs in order. OrderGit returns fs.
+func NewOrderedFileSystem(fs billy.Filesystem, order DirectoryOrder) billy.Filesystem {
+	if order == OrderGit {
+		return fs
+	}
+	return orderedFileSystem{
+		Filesystem: fs,
+		order:      order,
+	}
+}
+
+func (s orderedFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	files, err := s.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if s.order == OrderDirectoriesFirst && files[i].IsDir() != files[j].IsDir() {
+			return files[i].IsDir()
+		}
+		return files[i].Name() < files[j].Name()
+	})
+	return files, nil
+}
+
+// Chroot keeps sorting listings in the new root.
+func (s orderedFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	fs, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewOrderedFileSystem(fs, s.order), nil
+}
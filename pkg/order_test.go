@@ -0,0 +1,55 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestOrderedFileSystem(t *testing.T) {
+	git := fakeSymlinks()
+	reference := BranchRef("master")
+
+	tests := map[DirectoryOrder][]string{
+		OrderName:             {"absolute.txt", "nested", "real.txt", "relative.txt"},
+		OrderDirectoriesFirst: {"nested", "absolute.txt", "real.txt", "relative.txt"},
+	}
+	for order, want := range tests {
+		t.Run(order.String(), func(t *testing.T) {
+			parsed, err := ParseDirectoryOrder(order.String())
+			if err != nil || parsed != order {
+				t.Fatalf("ParseDirectoryOrder(%s) = %v, %v", order, parsed, err)
+			}
+
+			fs := NewOrderedFileSystem(NewReferenceFileSystemWithSymlinks(git, reference, SymlinkPassThrough), order)
+			files, err := fs.ReadDir(".")
+			if err != nil {
+				t.Fatalf("ReadDir(.) failed: %v", err)
+			}
+			var names []string
+			for _, file := range files {
+				names = append(names, file.Name())
+			}
+			if diff := cmp.Diff(want, names); diff != "" {
+				t.Fatalf("ReadDir(.) listed entries in the wrong order (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := ParseDirectoryOrder("random"); err == nil {
+		t.Fatalf("ParseDirectoryOrder(random) should fail")
+	}
+}
@@ -16,6 +16,7 @@ package pkg
 
 import (
 	"errors"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -39,7 +40,21 @@ func (p *FilePath) Parent() FilePath {
 	return FilePath{Path: p.Path[:len(p.Path)-1]}
 }
 
+// Resolve interprets request relative to p. Empty segments, like those in "foo//bar", "foo/", or "", are ignored. A
+// leading separator is ignored too so absolute paths are resolved relative to p. request may climb out of p with "..",
+// but not out of the root of the repository.
 func (p *FilePath) Resolve(request string) (FilePath, error) {
+	return p.resolve(request, 0)
+}
+
+// ResolveWithin is Resolve for callers that use p as the root of their filesystem, like a chroot. request may not
+// climb out of p with "..".
+func (p *FilePath) ResolveWithin(request string) (FilePath, error) {
+	return p.resolve(request, len(p.Path))
+}
+
+// resolve is Resolve refusing to climb above the first floor elements of p.
+func (p *FilePath) resolve(request string, floor int) (FilePath, error) {
 	requestParts := strings.Split(request, SeparatorString)
 	scratch := make([]string, len(p.Path)+len(requestParts))
 
@@ -53,11 +68,11 @@ func (p *FilePath) Resolve(request string) (FilePath, error) {
 	for _, path := range requestParts {
 		switch path {
 		case "..":
-			if idx == 0 {
+			if idx == floor {
 				return FilePath{}, ErrEscapesChroot
 			}
 			idx -= 1
-		case ".":
+		case ".", "":
 			continue
 		default:
 			scratch[idx] = path
@@ -123,3 +138,24 @@ func RootGitPath() FilePath {
 		Path: nil,
 	}
 }
+
+// MatchesAny reports whether filename matches one of patterns. Patterns are path.Match patterns matched against the
+// whole path of a file, or against its name if the pattern has no separator.
+func MatchesAny(patterns []string, filename string) bool {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil || resolved.IsRoot() {
+		return false
+	}
+	name := resolved.String()
+	for _, pattern := range patterns {
+		subject := name
+		if !strings.Contains(pattern, SeparatorString) {
+			subject = path.Base(name)
+		}
+		if matched, _ := path.Match(pattern, subject); matched {
+			return true
+		}
+	}
+	return false
+}
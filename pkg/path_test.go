@@ -26,6 +26,59 @@ func TestPath(t *testing.T) {
 		}
 	})
 
+	t.Run("normalizing", func(t *testing.T) {
+		tests := []struct {
+			base    []string
+			request string
+			want    string
+		}{
+			{nil, "", "."},
+			{nil, ".", "."},
+			{nil, "/", "."},
+			{nil, "foo/", "foo"},
+			{nil, "foo//bar", "foo/bar"},
+			{nil, "/foo/bar", "foo/bar"},
+			{nil, "//foo/./bar/", "foo/bar"},
+			{nil, "foo/../bar", "bar"},
+			{[]string{"test"}, "", "test"},
+			{[]string{"test"}, "/nested.txt", "test/nested.txt"},
+			{[]string{"test"}, "./nested.txt/", "test/nested.txt"},
+		}
+
+		for _, test := range tests {
+			base := FilePath{Path: test.base}
+			resolved, err := base.Resolve(test.request)
+			if err != nil {
+				t.Fatalf("failed to resolve '%s' from %v: %v", test.request, test.base, err)
+			}
+			if text := resolved.String(); text != test.want {
+				t.Fatalf("resolving '%s' from %v expected '%s', got: '%s'", test.request, test.base, test.want, text)
+			}
+			for _, part := range resolved.Path {
+				if part == "" || part == "." || part == ".." {
+					t.Fatalf("resolving '%s' left segment '%s' in %v", test.request, part, resolved.Path)
+				}
+			}
+		}
+
+		root := RootGitPath()
+		for _, escaping := range []string{"..", "/..", "foo/../..", "//../foo"} {
+			if _, err := root.Resolve(escaping); err != ErrEscapesChroot {
+				t.Fatalf("resolving '%s' from the root should escape, got: %v", escaping, err)
+			}
+		}
+
+		chroot := FilePath{Path: []string{"a", "b"}}
+		for _, escaping := range []string{"..", "../c", "/../c", "c/../.."} {
+			if _, err := chroot.ResolveWithin(escaping); err != ErrEscapesChroot {
+				t.Fatalf("resolving '%s' within %v should escape, got: %v", escaping, chroot.Path, err)
+			}
+		}
+		if resolved, err := chroot.ResolveWithin("c/../d"); err != nil || resolved.String() != "a/b/d" {
+			t.Fatalf("resolving 'c/../d' within %v returned %v, %v", chroot.Path, resolved.Path, err)
+		}
+	})
+
 	t.Run("parents", func(t *testing.T) {
 		p := FilePath{
 			Path: []string{
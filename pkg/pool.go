@@ -0,0 +1,242 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"log"
+	"sync"
+)
+
+// defaultWorkerRequests is how many objects a pooled process answers before it is replaced when WithProcessPool isn't
+// given a limit.
+const defaultWorkerRequests = 1000
+
+// batchPool keeps git cat-file processes started ahead of time so latency sensitive reads don't wait for git to
+// start. Processes that exit or fall out of step with their replies are replaced, and so are processes that have
+// answered maxRequests objects, so one process doesn't grow or hold on to packs forever. A nil *batchPool has no
+// processes.
+type batchPool struct {
+	start       func() (*gitism.BatchProcess, error)
+	size        int
+	maxRequests int
+	idle        chan *gitism.BatchProcess
+
+	mu sync.Mutex
+	// live counts the processes that are idle, in use, or starting.
+	live   int
+	closed bool
+	// generation is bumped by recycle, and born holds the generation each running process was started in so that
+	// processes from before are replaced once they are returned.
+	generation int
+	born       map[*gitism.BatchProcess]int
+}
+
+// newBatchPool starts size processes with start in the background.
+func newBatchPool(size, maxRequests int, start func() (*gitism.BatchProcess, error)) *batchPool {
+	pool := &batchPool{
+		start:       start,
+		size:        size,
+		maxRequests: maxRequests,
+		idle:        make(chan *gitism.BatchProcess, size),
+		born:        map[*gitism.BatchProcess]int{},
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for pool.live < size {
+		pool.live++
+		go pool.spawn()
+	}
+	return pool
+}
+
+// spawn starts a process for a slot already counted in live and makes it idle.
+func (p *batchPool) spawn() {
+	process, err := p.start()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		log.Printf("failed to start a pooled git process: %v", err)
+		p.live--
+		return
+	}
+	if p.closed {
+		p.live--
+		go process.Close()
+		return
+	}
+	p.born[process] = p.generation
+	p.idle <- process
+}
+
+// replace stops process and starts another in its place.
+func (p *batchPool) replace(process *gitism.BatchProcess) {
+	go process.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.born, process)
+	if p.closed {
+		p.live--
+		return
+	}
+	go p.spawn()
+}
+
+// get takes a healthy idle process, or returns nil if there isn't one ready. Missing processes are started again in
+// the background rather than waited for.
+func (p *batchPool) get() *gitism.BatchProcess {
+	if p == nil {
+		return nil
+	}
+	for {
+		select {
+		case process := <-p.idle:
+			if process.Healthy() && p.current(process) {
+				return process
+			}
+			p.replace(process)
+		default:
+			p.mu.Lock()
+			for !p.closed && p.live < p.size {
+				p.live++
+				go p.spawn()
+			}
+			p.mu.Unlock()
+			return nil
+		}
+	}
+}
+
+// put returns a process taken with get.
+func (p *batchPool) put(process *gitism.BatchProcess) {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed || !p.current(process) || !process.Healthy() || process.Requests() >= p.maxRequests {
+		p.replace(process)
+		return
+	}
+	p.idle <- process
+}
+
+// object asks a pooled process for the object named by name. ok is false if no process was ready, in which case the
+// caller should run git itself.
+func (p *batchPool) object(name string) (objectType string, size int64, contents []byte, ok bool, err error) {
+	process := p.get()
+	if process == nil {
+		return "", 0, nil, false, nil
+	}
+	defer p.put(process)
+	objectType, size, contents, err = process.Object(name)
+	return objectType, size, contents, true, err
+}
+
+// recycle replaces every idle process, and every process in use once it is returned, so that none keep reading packs
+// that were replaced since they started.
+func (p *batchPool) recycle() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.generation++
+	p.mu.Unlock()
+	for {
+		select {
+		case process := <-p.idle:
+			if p.current(process) {
+				// Replacements are queued behind every process that was idle, so only they are left.
+				p.idle <- process
+				return
+			}
+			p.replace(process)
+		default:
+			return
+		}
+	}
+}
+
+// current reports if process was started since the pool was last recycled.
+func (p *batchPool) current(process *gitism.BatchProcess) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.born[process] == p.generation
+}
+
+// Close stops every idle process, and every process in use once it is returned.
+func (p *batchPool) Close() error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	for {
+		select {
+		case process := <-p.idle:
+			p.mu.Lock()
+			p.live--
+			delete(p.born, process)
+			p.mu.Unlock()
+			go process.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// processPools are the pooled processes of a cliGit: ones reading contents and ones only checking sizes.
+type processPools struct {
+	contents *batchPool
+	sizes    *batchPool
+}
+
+// readBlob reads the blob named by hash from a pooled process. ok is false if no process was ready.
+func (p *processPools) readBlob(hash string) (contents []byte, ok bool, err error) {
+	if p == nil {
+		return nil, false, nil
+	}
+	objectType, _, contents, ok, err := p.contents.object(hash)
+	if ok && err == nil && objectType != "blob" {
+		err = fmt.Errorf("object %s is a %s, not a blob", hash, objectType)
+	}
+	return contents, ok, err
+}
+
+// blobSize looks the size of the object named by hash up with a pooled process. ok is false if no process was ready.
+func (p *processPools) blobSize(hash string) (size int64, ok bool, err error) {
+	if p == nil {
+		return 0, false, nil
+	}
+	_, size, _, ok, err = p.sizes.object(hash)
+	return size, ok, err
+}
+
+// recycle replaces every pooled process. See batchPool.recycle.
+func (p *processPools) recycle() {
+	if p == nil {
+		return
+	}
+	p.contents.recycle()
+	p.sizes.recycle()
+}
+
+func (p *processPools) Close() error {
+	if p == nil {
+		return nil
+	}
+	p.contents.Close()
+	return p.sizes.Close()
+}
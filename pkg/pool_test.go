@@ -0,0 +1,169 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessPool(t *testing.T) {
+	tmp := t.TempDir()
+	repository, err := runPlaybook("base", tmp)
+	if err != nil {
+		t.Fatalf("playbook 'base' failed: %v", err)
+	}
+	wrapper, record := recordingGit(t, "cat-file", 0)
+	client, err := NewCliGit(repository, WithGitExecutable(wrapper), WithProcessPool(1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	git := client.(cliGit)
+	defer git.Close()
+
+	runs := func() []string {
+		recorded, _ := os.ReadFile(record)
+		return strings.Split(strings.TrimSpace(string(recorded)), "\n")
+	}
+	ready := func(t *testing.T) {
+		deadline := time.Now().Add(10 * time.Second)
+		for len(git.pools.contents.idle) == 0 || len(git.pools.sizes.idle) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("the pooled processes never started")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	const realTxt = "557db03de997c86a4a028e1ebd3a1ceb225be238"
+	t.Run("reads", func(t *testing.T) {
+		ready(t)
+		if contents, err := git.ReadBlob(realTxt); err != nil || string(contents) != "Hello World\n" {
+			t.Fatalf("ReadBlob() = %q, %v", contents, err)
+		}
+		if size, err := git.BlobSize(realTxt); err != nil || size != 12 {
+			t.Fatalf("BlobSize() = %d, %v", size, err)
+		}
+		for _, run := range runs() {
+			if !strings.Contains(run, "--batch") {
+				t.Fatalf("git was started for a read while processes were ready: %v", runs())
+			}
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		ready(t)
+		if _, err := git.ReadBlob("0000000000000000000000000000000000000000"); err == nil {
+			t.Fatal("ReadBlob() of a missing blob should fail")
+		}
+		if _, err := git.ReadBlob("HEAD"); err == nil {
+			t.Fatal("ReadBlob() of a commit should fail")
+		}
+	})
+
+	t.Run("recycling", func(t *testing.T) {
+		ready(t)
+		before := len(runs())
+		for i := 0; i < 2; i++ {
+			ready(t)
+			if _, err := git.ReadBlob(realTxt); err != nil {
+				t.Fatalf("ReadBlob() failed: %v", err)
+			}
+		}
+		ready(t)
+		if started := len(runs()) - before; started == 0 {
+			t.Fatal("a process that answered its limit of requests wasn't replaced")
+		}
+	})
+
+	t.Run("maintenance", func(t *testing.T) {
+		ready(t)
+		before := len(runs())
+		// The repository starts out without a pack for incremental maintenance to index.
+		if err := Maintain(git, gitism.MaintenanceIncremental); err != nil {
+			t.Fatalf("Maintain(incremental) failed: %v", err)
+		}
+		if err := Maintain(git, gitism.MaintenanceGC); err != nil {
+			t.Fatalf("Maintain() failed: %v", err)
+		}
+		counts, err := git.CountObjects()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts.Loose != 0 || counts.Packs == 0 {
+			t.Fatalf("CountObjects() after gc = %+v, expected everything to be packed", counts)
+		}
+		ready(t)
+		started := 0
+		for _, run := range runs()[before:] {
+			if strings.Contains(run, "--batch") {
+				started++
+			}
+		}
+		if started < 2 {
+			t.Fatalf("the pooled processes weren't replaced after maintenance: %v", runs()[before:])
+		}
+		if contents, err := git.ReadBlob(realTxt); err != nil || string(contents) != "Hello World\n" {
+			t.Fatalf("ReadBlob() after maintenance = %q, %v", contents, err)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		git.Close()
+		if git.pools.contents.get() != nil {
+			t.Fatal("a closed pool handed out a process")
+		}
+		if contents, err := git.ReadBlob(realTxt); err != nil || string(contents) != "Hello World\n" {
+			t.Fatalf("ReadBlob() after closing = %q, %v", contents, err)
+		}
+	})
+}
+
+func TestBatchProcess(t *testing.T) {
+	tmp := t.TempDir()
+	repository, err := runPlaybook("base", tmp)
+	if err != nil {
+		t.Fatalf("playbook 'base' failed: %v", err)
+	}
+	cli, err := gitism.NewCommand(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	process, err := cli.StartBatch(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer process.Close()
+
+	objectType, size, contents, err := process.Object("master:real.txt")
+	if err != nil || objectType != "blob" || size != 12 || string(contents) != "Hello World\n" {
+		t.Fatalf("Object(master:real.txt) = %s, %d, %q, %v", objectType, size, contents, err)
+	}
+	if _, _, _, err := process.Object("master:missing.txt"); !errors.Is(err, gitism.ErrObjectMissing) {
+		t.Fatalf("Object(master:missing.txt) should fail with ErrObjectMissing: %v", err)
+	}
+	if !process.Healthy() || process.Requests() != 2 {
+		t.Fatalf("a missing object should leave the process healthy after %d requests", process.Requests())
+	}
+
+	process.Close()
+	if process.Healthy() {
+		t.Fatal("a closed process is still healthy")
+	}
+}
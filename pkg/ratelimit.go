@@ -0,0 +1,139 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"os"
+	"sync"
+	"time"
+)
+
+// RateLimits throttle the operations that are expensive to serve from git so a job scanning the whole repository
+// can't starve everyone else. Operations over the rate are delayed rather than rejected. Zero values disable a limit.
+type RateLimits struct {
+	// LargeFileSize is the size in bytes above which opening a file counts against LargeFileRate.
+	LargeFileSize int64
+	// LargeFileRate is how many files larger than LargeFileSize may be opened per second.
+	LargeFileRate float64
+	// ListingRate is how many directories may be listed per second.
+	ListingRate float64
+}
+
+// tokenBucket delays callers once more than rate operations a second have been taken from it. It holds at most one
+// second of operations. A nil *tokenBucket never delays.
+type tokenBucket struct {
+	rate  float64
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	mu       sync.Mutex
+	tokens   float64
+	refilled time.Time
+}
+
+func newTokenBucket(rate float64, now func() time.Time, sleep func(time.Duration)) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		rate:     rate,
+		now:      now,
+		sleep:    sleep,
+		tokens:   rate,
+		refilled: now(),
+	}
+}
+
+// take waits until the bucket has a token for one more operation.
+func (b *tokenBucket) take() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	now := b.now()
+	b.tokens += now.Sub(b.refilled).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.refilled = now
+	// Take the token now, even if that leaves the bucket in debt, so concurrent callers queue up behind this one.
+	b.tokens -= 1
+	debt := -b.tokens
+	b.mu.Unlock()
+
+	if debt > 0 {
+		b.sleep(time.Duration(debt / b.rate * float64(time.Second)))
+	}
+}
+
+// rateLimitFileSystem delays large file opens and directory listings to the rates in limits. The buckets are shared
+// with every filesystem chrooted from it.
+type rateLimitFileSystem struct {
+	billy.Filesystem
+	limits     RateLimits
+	largeFiles *tokenBucket
+	listings   *tokenBucket
+}
+
+// NewRateLimitFileSystem delays opening files and listing directories in fs to the rates in limits. fs is returned
+// unchanged if limits doesn't limit anything.
+func NewRateLimitFileSystem(fs billy.Filesystem, limits RateLimits) billy.Filesystem {
+	return newRateLimitFileSystem(fs, limits, time.Now, time.Sleep)
+}
+
+func newRateLimitFileSystem(fs billy.Filesystem, limits RateLimits, now func() time.Time, sleep func(time.Duration)) billy.Filesystem {
+	largeFiles := newTokenBucket(limits.LargeFileRate, now, sleep)
+	listings := newTokenBucket(limits.ListingRate, now, sleep)
+	if largeFiles == nil && listings == nil {
+		return fs
+	}
+	return rateLimitFileSystem{
+		Filesystem: fs,
+		limits:     limits,
+		largeFiles: largeFiles,
+		listings:   listings,
+	}
+}
+
+func (s rateLimitFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s rateLimitFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if s.largeFiles != nil {
+		info, err := s.Filesystem.Stat(filename)
+		if err == nil && info.Mode().IsRegular() && info.Size() > s.limits.LargeFileSize {
+			s.largeFiles.take()
+		}
+	}
+	return s.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (s rateLimitFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	s.listings.take()
+	return s.Filesystem.ReadDir(path)
+}
+
+// Chroot shares the rate limits with the new root.
+func (s rateLimitFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	fs, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	s.Filesystem = fs
+	return s, nil
+}
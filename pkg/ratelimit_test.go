@@ -0,0 +1,81 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"testing"
+	"time"
+)
+
+func TestRateLimitFileSystem(t *testing.T) {
+	backing := memfs.New()
+	for name, contents := range map[string]string{
+		"data/small.txt": "0123456789",
+		"data/large.bin": "0123456789a",
+	} {
+		if err := util.WriteFile(backing, name, []byte(contents), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	t.Run("large files", func(t *testing.T) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		fs := newRateLimitFileSystem(backing, RateLimits{LargeFileSize: 10, LargeFileRate: 2}, clock.Now, clock.Sleep)
+		for i := 0; i < 10; i++ {
+			if _, err := fs.Open("data/small.txt"); err != nil {
+				t.Fatalf("Open(data/small.txt) failed: %v", err)
+			}
+		}
+		if clock.Slept() != 0 {
+			t.Fatalf("small files should not be limited but slept for %v", clock.Slept())
+		}
+
+		for i := 0; i < 4; i++ {
+			if _, err := fs.Open("data/large.bin"); err != nil {
+				t.Fatalf("Open(data/large.bin) failed: %v", err)
+			}
+		}
+		// The first two opens use the burst and the next two wait half a second each.
+		if clock.Slept() != time.Second {
+			t.Fatalf("opening 4 large files at 2 per second slept for %v", clock.Slept())
+		}
+	})
+
+	t.Run("listings", func(t *testing.T) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		fs := newRateLimitFileSystem(backing, RateLimits{ListingRate: 1}, clock.Now, clock.Sleep)
+		chrooted, err := fs.Chroot("data")
+		if err != nil {
+			t.Fatalf("Chroot(data) failed: %v", err)
+		}
+		if _, err := fs.ReadDir("data"); err != nil {
+			t.Fatalf("ReadDir(data) failed: %v", err)
+		}
+		if _, err := chrooted.ReadDir("/"); err != nil {
+			t.Fatalf("ReadDir(/) failed: %v", err)
+		}
+		if clock.Slept() != time.Second {
+			t.Fatalf("a chrooted filesystem should share the limit but slept for %v", clock.Slept())
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		if NewRateLimitFileSystem(backing, RateLimits{LargeFileSize: 10}) != backing {
+			t.Fatalf("limits without a rate should not wrap the filesystem")
+		}
+	})
+}
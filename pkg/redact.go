@@ -0,0 +1,163 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// pathRedactionKey is what RedactPath hashes path elements with, or nil if paths are logged as they are.
+var pathRedactionKey []byte
+
+// RedactPathsInLogs makes RedactPath hash every path gitfs logs, traces, or writes to an access log, for deployments
+// where the names of files are sensitive. Paths are hashed with key, so the same path is hashed the same way by every
+// frontend and across restarts given the same key, or with a random key that only lasts as long as the process if key
+// is empty. It must be called before anything is served.
+func RedactPathsInLogs(key string) error {
+	if key == "" {
+		random := make([]byte, sha256.Size)
+		if _, err := rand.Read(random); err != nil {
+			return fmt.Errorf("failed to generate a key for redacting paths: %v", err)
+		}
+		pathRedactionKey = random
+		return nil
+	}
+	pathRedactionKey = []byte(key)
+	return nil
+}
+
+// RedactPath hashes each element of name when paths are redacted with RedactPathsInLogs, and returns it as is
+// otherwise. What is left is enough to tell paths apart and follow one through a log: how deep it is, which paths
+// share a directory, and the extension of its file, like 3b18e512dd/a76d7e6a1f/0c4d2b3e9f.go for src/pkg/main.go.
+func RedactPath(name string) string {
+	if pathRedactionKey == nil {
+		return name
+	}
+	elements := strings.Split(name, "/")
+	for i, element := range elements {
+		if element == "" || element == "." || element == ".." {
+			continue
+		}
+		mac := hmac.New(sha256.New, pathRedactionKey)
+		mac.Write([]byte(element))
+		elements[i] = hex.EncodeToString(mac.Sum(nil)[:5]) + redactedExtension(element)
+	}
+	return strings.Join(elements, "/")
+}
+
+// redactedExtension is the extension RedactPath keeps of element. Long extensions and those with more than letters and
+// digits, like in notes.2023-01-01, could be telling names themselves and are dropped, and so are the names of dot
+// files.
+func redactedExtension(element string) string {
+	extension := path.Ext(element)
+	if extension == "" || extension == element || len(extension) > 8 {
+		return ""
+	}
+	for _, r := range extension[1:] {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return ""
+		}
+	}
+	return extension
+}
+
+// redactedPath is a path formatted with RedactPath, for passing to Tracer.Begin without hashing it when nothing is
+// traced.
+type redactedPath string
+
+func (p redactedPath) String() string {
+	return RedactPath(string(p))
+}
+
+// redactPaths replaces every mention of one of paths in text, like an error that quotes the path it failed on, with
+// the path as RedactPath formats it. Mentions only count if they aren't part of a longer name.
+func redactPaths(text string, paths ...string) string {
+	if pathRedactionKey == nil {
+		return text
+	}
+	// Longer paths go first so a path isn't cut short by one of its parents.
+	sorted := append([]string(nil), paths...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+	for _, name := range sorted {
+		name = strings.Trim(name, "/")
+		if name == "" || name == "." {
+			continue
+		}
+		var redacted strings.Builder
+		for {
+			index := strings.Index(text, name)
+			if index == -1 {
+				break
+			}
+			end := index + len(name)
+			if index > 0 && isNameByte(text[index-1]) || end < len(text) && isNameByte(text[end]) {
+				redacted.WriteString(text[:end])
+			} else {
+				redacted.WriteString(text[:index])
+				redacted.WriteString(RedactPath(name))
+			}
+			text = text[end:]
+		}
+		redacted.WriteString(text)
+		text = redacted.String()
+	}
+	return text
+}
+
+// isNameByte reports if b may be part of a path element next to a mention of a path, rather than delimit it.
+func isNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '.' || b == '_' || b == '-'
+}
+
+// redactError formats err for a log, with the path of a *fs.PathError and every mention of paths redacted.
+func redactError(err error, paths ...string) string {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		paths = append(paths, pathErr.Path)
+	}
+	return redactPaths(err.Error(), paths...)
+}
+
+// redactGitArgs formats the arguments of a git command with RedactPath when paths are redacted, along with the paths
+// it found in them. git is given paths and revisions in the same places, so every argument that isn't the subcommand,
+// an option, or an object hash is taken to be a path.
+func redactGitArgs(args []string) (redacted []string, paths []string) {
+	if pathRedactionKey == nil {
+		return args, nil
+	}
+	redacted = make([]string, len(args))
+	for i, arg := range args {
+		name := strings.TrimPrefix(arg, ":(literal)")
+		if i == 0 || strings.HasPrefix(arg, "-") || gitism.IsHash(arg) || name == "" {
+			redacted[i] = arg
+			continue
+		}
+		redacted[i] = arg[:len(arg)-len(name)] + RedactPath(name)
+		paths = append(paths, name)
+	}
+	return redacted, paths
+}
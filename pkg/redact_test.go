@@ -0,0 +1,130 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jacobsa/fuse/fuseops"
+	"io/fs"
+	"log"
+	"strings"
+	"testing"
+)
+
+// redactPathsForTest redacts paths with key until the test ends.
+func redactPathsForTest(t *testing.T, key string) {
+	if err := RedactPathsInLogs(key); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pathRedactionKey = nil })
+}
+
+func TestRedactPath(t *testing.T) {
+	if redacted := RedactPath("src/main.go"); redacted != "src/main.go" {
+		t.Fatalf("RedactPath() without redaction = %q", redacted)
+	}
+	redactPathsForTest(t, "key")
+
+	redacted := RedactPath("/src/pkg/main.go")
+	elements := strings.Split(redacted, "/")
+	if len(elements) != 4 || elements[0] != "" || !strings.HasSuffix(elements[3], ".go") || strings.Contains(redacted, "src") {
+		t.Fatalf("RedactPath() = %q, expected three hashed elements and the extension", redacted)
+	}
+	if sibling := RedactPath("src/pkg/util.go"); !strings.HasPrefix(sibling, strings.Join(elements[1:3], "/")+"/") {
+		t.Fatalf("RedactPath() of a sibling = %q, expected it to share %q", sibling, redacted)
+	}
+	if again := RedactPath("/src/pkg/main.go"); again != redacted {
+		t.Fatalf("RedactPath() changed from %q to %q", redacted, again)
+	}
+	for _, name := range []string{".env", "notes.2023-01-01", "archive.averylongextension", "Makefile"} {
+		if redacted := RedactPath(name); strings.Contains(redacted, ".") {
+			t.Fatalf("RedactPath(%q) = %q kept an extension that could be telling", name, redacted)
+		}
+	}
+	if redacted := RedactPath("./a/../b"); !strings.HasPrefix(redacted, "./") || !strings.Contains(redacted, "/../") {
+		t.Fatalf("RedactPath() = %q, expected . and .. to be kept", redacted)
+	}
+
+	other := RedactPath("src")
+	redactPathsForTest(t, "another key")
+	if RedactPath("src") == other {
+		t.Fatal("RedactPath() hashed the same way with a different key")
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	redactPathsForTest(t, "key")
+	err := &fs.PathError{Op: "open", Path: "secret/plans.txt", Err: fs.ErrNotExist}
+	if redacted := redactError(err); strings.Contains(redacted, "secret") || !strings.Contains(redacted, RedactPath("secret/plans.txt")) {
+		t.Fatalf("redactError() = %q", redacted)
+	}
+	err2 := errors.New("'git ls-tree master secret' failed: no secret in secrets/")
+	expected := "'git ls-tree master " + RedactPath("secret") + "' failed: no " + RedactPath("secret") + " in secrets/"
+	if redacted := redactError(err2, "secret"); redacted != expected {
+		t.Fatalf("redactError() = %q, expected %q", redacted, expected)
+	}
+
+	args, paths := redactGitArgs([]string{"log", "-1", "557db03de997c86a4a028e1ebd3a1ceb225be238", "--", ":(literal)secret/plans.txt"})
+	if strings.Join(args, " ") != "log -1 557db03de997c86a4a028e1ebd3a1ceb225be238 -- :(literal)"+RedactPath("secret/plans.txt") {
+		t.Fatalf("redactGitArgs() = %v", args)
+	}
+	if len(paths) != 1 || paths[0] != "secret/plans.txt" {
+		t.Fatalf("redactGitArgs() found the paths %v", paths)
+	}
+}
+
+func TestRedactedLogs(t *testing.T) {
+	redactPathsForTest(t, "key")
+	output := new(bytes.Buffer)
+	tracer := NewTracer(log.New(output, "", 0))
+	gitDirectory, err := runPlaybook("base", t.TempDir())
+	if err != nil {
+		t.Fatalf("playbook 'base' failed: %v", err)
+	}
+	git, err := NewCliGit(gitDirectory, WithTracer(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessLog := new(bytes.Buffer)
+	filesystem := NewAccessLog(accessLog, git, BranchRef("master")).FileSystem(NewReferenceFileSystem(git, BranchRef("master")), "uid:0")
+	fuse, err := NewBillyFuseWithTracer(NewTracingFileSystem(filesystem, tracer, "billy"), tracer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"real.txt", "missing.txt"} {
+		op := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: name}
+		_ = fuse.LookUpInode(context.Background(), op)
+	}
+	if _, err := filesystem.Open("test/nested.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"real", "missing", "nested"} {
+		if strings.Contains(output.String(), name) {
+			t.Fatalf("the trace mentions %s:\n%s", name, output)
+		}
+	}
+	if !strings.Contains(output.String(), RedactPath("missing.txt")) {
+		t.Fatalf("the trace doesn't mention missing.txt redacted:\n%s", output)
+	}
+	var entry AccessEntry
+	if err := json.Unmarshal(accessLog.Bytes(), &entry); err != nil || entry.Path != RedactPath("test/nested.txt") {
+		t.Fatalf("access log entry %+v, %v, expected a redacted path", entry, err)
+	}
+}
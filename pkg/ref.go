@@ -0,0 +1,193 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"strings"
+)
+
+var (
+	// ErrUnexpectedCommit is returned by PinRef when a ref doesn't point to the commit it was expected to.
+	ErrUnexpectedCommit = errors.New("ref does not point to the expected commit")
+	// ErrBadSignature is returned by VerifyRef when a ref isn't signed by a trusted key.
+	ErrBadSignature = errors.New("signature could not be verified")
+)
+
+// RefKind is the kind of name a Ref holds.
+type RefKind uint8
+
+const (
+	// RefCommit names a commit by its hash or by any other revision git can resolve to a commit.
+	RefCommit RefKind = iota
+	// RefBranch names a branch without its refs/heads/ prefix.
+	RefBranch
+	// RefTag names a tag without its refs/tags/ prefix.
+	RefTag
+	// RefIndex names the files staged in the index. See IndexRef.
+	RefIndex
+)
+
+func (k RefKind) String() string {
+	switch k {
+	case RefCommit:
+		return "commit"
+	case RefBranch:
+		return "branch"
+	case RefTag:
+		return "tag"
+	case RefIndex:
+		return "index"
+	default:
+		return fmt.Sprintf("RefKind(%d)", uint8(k))
+	}
+}
+
+const (
+	branchPrefix = "refs/heads/"
+	tagPrefix    = "refs/tags/"
+)
+
+// Ref names the commit a tree is read from.
+type Ref struct {
+	Kind RefKind
+	Name string
+}
+
+// BranchRef follows the tip of branch.
+func BranchRef(branch string) Ref {
+	return Ref{Kind: RefBranch, Name: branch}
+}
+
+// TagRef reads from the commit tag points to.
+func TagRef(tag string) Ref {
+	return Ref{Kind: RefTag, Name: tag}
+}
+
+// CommitRef reads from the commit named by revision.
+func CommitRef(revision string) Ref {
+	return Ref{Kind: RefCommit, Name: revision}
+}
+
+// ParseRef turns a name into a Ref. Names starting with refs/heads/ or refs/tags/ are branches and tags, hexadecimal
+// names of 4 to 64 characters are commit hashes, other names starting with refs/ are kept whole as commits, and
+// anything else is the short name of a branch.
+func ParseRef(name string) (Ref, error) {
+	switch {
+	case name == "":
+		return Ref{}, ErrNoTreeLikeSpecified
+	case strings.HasPrefix(name, branchPrefix) && len(name) > len(branchPrefix):
+		return BranchRef(strings.TrimPrefix(name, branchPrefix)), nil
+	case strings.HasPrefix(name, tagPrefix) && len(name) > len(tagPrefix):
+		return TagRef(strings.TrimPrefix(name, tagPrefix)), nil
+	case strings.HasPrefix(name, "refs/") || isHash(name):
+		return CommitRef(name), nil
+	case strings.HasPrefix(name, "-"):
+		return Ref{}, fmt.Errorf("invalid ref '%s'", name)
+	default:
+		return BranchRef(name), nil
+	}
+}
+
+// PinRef checks that ref points to expected, a full or abbreviated commit hash, and returns a ref to that exact commit
+// so what is served doesn't change if ref moves later.
+func PinRef(git Git, ref Ref, expected string) (Ref, error) {
+	expected = strings.ToLower(expected)
+	if !isHash(expected) {
+		return Ref{}, fmt.Errorf("invalid commit hash '%s'", expected)
+	}
+	commit, err := git.ResolveCommit(ref)
+	if err != nil {
+		return Ref{}, fmt.Errorf("failed to resolve %s: %v", ref, err)
+	}
+	if !strings.HasPrefix(commit, expected) {
+		return Ref{}, fmt.Errorf("%s points to %s instead of %s: %w", ref, commit, expected, ErrUnexpectedCommit)
+	}
+	return CommitRef(commit), nil
+}
+
+// VerifyRef checks that ref is signed by a key git trusts and returns a ref to the commit that was verified so what is
+// served doesn't change if ref moves later. Tags must be annotated tags with a signature of their own, anything else
+// needs a signed commit.
+func VerifyRef(git Git, ref Ref) (Ref, error) {
+	if ref.Kind == RefTag {
+		if err := git.VerifyTag(ref.Name); err != nil {
+			return Ref{}, fmt.Errorf("%s: %v: %w", ref, err, ErrBadSignature)
+		}
+	}
+	commit, err := git.ResolveCommit(ref)
+	if err != nil {
+		return Ref{}, fmt.Errorf("failed to resolve %s: %v", ref, err)
+	}
+	if ref.Kind != RefTag {
+		if err := git.VerifyCommit(commit); err != nil {
+			return Ref{}, fmt.Errorf("%s: %v: %w", ref, err, ErrBadSignature)
+		}
+	}
+	return CommitRef(commit), nil
+}
+
+// isHash is true for names that can only be an abbreviated or full object hash.
+func isHash(name string) bool {
+	if len(name) < 4 || len(name) > 64 {
+		return false
+	}
+	for _, c := range name {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// String is the full name of the ref, which ParseRef turns back into the same Ref.
+func (r Ref) String() string {
+	switch r.Kind {
+	case RefBranch:
+		return branchPrefix + r.Name
+	case RefTag:
+		return tagPrefix + r.Name
+	default:
+		return r.Name
+	}
+}
+
+// refspec fetches the ref into the same name in the repository. Commits can only be fetched by their full hash and
+// have no refspec otherwise.
+func (r Ref) refspec() string {
+	switch r.Kind {
+	case RefBranch, RefTag:
+		return "+" + r.String() + ":" + r.String()
+	case RefCommit:
+		if gitism.IsHash(r.Name) {
+			return r.Name
+		}
+	}
+	return ""
+}
+
+// treeLike is the name passed to git to read the tree of the ref. Branches and tags are fully qualified so a branch
+// never resolves to a tag of the same name, which git would otherwise prefer.
+func (r Ref) treeLike() (string, error) {
+	if r.Kind == RefIndex {
+		return "", ErrIndexRef
+	}
+	if r.Name == "" {
+		return "", ErrNoTreeLikeSpecified
+	}
+	return r.String(), nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRef(t *testing.T) {
+	tests := map[string]Ref{
+		"refs/heads/main":       BranchRef("main"),
+		"refs/heads/feature/x":  BranchRef("feature/x"),
+		"refs/tags/v1.0":        TagRef("v1.0"),
+		"abc123":                CommitRef("abc123"),
+		"refs/remotes/origin/x": CommitRef("refs/remotes/origin/x"),
+		"main":                  BranchRef("main"),
+		"cafe-branch":           BranchRef("cafe-branch"),
+	}
+	for name, want := range tests {
+		got, err := ParseRef(name)
+		if err != nil {
+			t.Fatalf("ParseRef(%s) failed: %v", name, err)
+		}
+		if got != want {
+			t.Fatalf("ParseRef(%s) returned %+v but wanted %+v", name, got, want)
+		}
+		if roundTrip, err := ParseRef(got.String()); err != nil || roundTrip != got {
+			t.Fatalf("ParseRef(%s) returned %+v, %v", got.String(), roundTrip, err)
+		}
+	}
+
+	for _, invalid := range []string{"", "--all"} {
+		if _, err := ParseRef(invalid); err == nil {
+			t.Fatalf("ParseRef(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestPinRef(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "tags")
+	tag, err := git.ResolveCommit(TagRef("v1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := git.ResolveCommit(BranchRef("master"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{tag, tag[:8], strings.ToUpper(tag)} {
+		pinned, err := PinRef(git, TagRef("v1.0"), expected)
+		if err != nil {
+			t.Fatalf("PinRef(v1.0, %s) failed: %v", expected, err)
+		}
+		if pinned != CommitRef(tag) {
+			t.Fatalf("PinRef(v1.0, %s) returned %+v", expected, pinned)
+		}
+	}
+
+	if _, err := PinRef(git, BranchRef("master"), tag); !errors.Is(err, ErrUnexpectedCommit) {
+		t.Fatalf("PinRef(master, %s) should fail with ErrUnexpectedCommit since master is %s: %v", tag, master, err)
+	}
+	if _, err := PinRef(git, BranchRef("master"), "not-a-hash"); err == nil {
+		t.Fatal("PinRef() should reject an invalid hash")
+	}
+	if _, err := PinRef(git, BranchRef("missing"), master); err == nil || errors.Is(err, ErrUnexpectedCommit) {
+		t.Fatalf("PinRef(missing) should fail to resolve the ref: %v", err)
+	}
+}
+
+func TestVerifyRef(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is needed to sign commits")
+	}
+	gitDir, err := runPlaybook("signed", t.TempDir())
+	if err != nil {
+		t.Fatalf("playbook 'signed' failed: %v", err)
+	}
+	git, err := NewCliGit(gitDir, WithGitConfig("gpg.ssh.allowedSignersFile", filepath.Join(filepath.Dir(gitDir), "allowed_signers")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []Ref{BranchRef("master"), TagRef("v1.0")} {
+		commit, err := git.ResolveCommit(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verified, err := VerifyRef(git, ref)
+		if err != nil {
+			t.Fatalf("VerifyRef(%s) failed: %v", ref, err)
+		}
+		if verified != CommitRef(commit) {
+			t.Fatalf("VerifyRef(%s) returned %+v instead of commit %s", ref, verified, commit)
+		}
+	}
+
+	for _, ref := range []Ref{BranchRef("unsigned"), TagRef("v1.0-unsigned")} {
+		if _, err := VerifyRef(git, ref); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("VerifyRef(%s) should fail with ErrBadSignature: %v", ref, err)
+		}
+	}
+
+	untrusted := newGitCliFromPlaybook(t, "signed")
+	if _, err := VerifyRef(untrusted, BranchRef("master")); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("VerifyRef(master) without allowed signers should fail with ErrBadSignature: %v", err)
+	}
+}
+
+func TestBranchAndTagWithTheSameName(t *testing.T) {
+	gitDir, err := runPlaybook("tags", t.TempDir())
+	if err != nil {
+		t.Fatalf("playbook 'tags' failed: %v", err)
+	}
+	if output, err := exec.Command("git", "--git-dir", gitDir, "branch", "v1.0", "master").CombinedOutput(); err != nil {
+		t.Fatalf("failed to add branch v1.0: %v: %s", err, output)
+	}
+	cli, err := NewCliGit(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded, err := NewEmbeddedGit(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := NewFakeGit(nil)
+	fake.Commit("master", "Add a normal file", map[string]FakeFile{"real.txt": {Contents: "Hello World\n"}})
+	if err := fake.Tag("v1.0", "master"); err != nil {
+		t.Fatal(err)
+	}
+	fake.Commit("master", "Change a normal file", map[string]FakeFile{"real.txt": {Contents: "Hello World, again\n"}})
+	if err := fake.Branch("v1.0", "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, git := range map[string]Git{"cli": cli, "embedded": embedded, "fake": fake} {
+		t.Run(name, func(t *testing.T) {
+			master, err := git.ResolveCommit(BranchRef("master"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for ref, want := range map[Ref]string{BranchRef("v1.0"): "Hello World, again\n", TagRef("v1.0"): "Hello World\n"} {
+				commit, err := git.ResolveCommit(ref)
+				if err != nil {
+					t.Fatalf("ResolveCommit(%s) failed: %v", ref, err)
+				}
+				if (commit == master) != (ref.Kind == RefBranch) {
+					t.Fatalf("ResolveCommit(%s) returned %s while master is %s", ref, commit, master)
+				}
+				var hash string
+				err = git.ListTree(GitPath{Reference: ref, TreePath: "real.txt"}, func(entry gitism.TreeEntry) error {
+					if entry.Path == "real.txt" {
+						hash = entry.Hash
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("listing %s failed: %v", ref, err)
+				}
+				if contents, err := git.ReadBlob(hash); err != nil || string(contents) != want {
+					t.Fatalf("real.txt at %s is %q, %v but wanted %q", ref, contents, err, want)
+				}
+			}
+		})
+	}
+}
@@ -15,7 +15,7 @@
 package pkg
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"github.com/go-git/go-billy/v5"
 	"github.com/gravypod/gitfs/pkg/gitism"
@@ -24,12 +24,23 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"syscall"
 	"time"
 )
 
+// GitFileStat is what Sys returns for the os.FileInfo of a file served straight from git.
+type GitFileStat struct {
+	// Hash names the object. It is empty for the root of the tree.
+	Hash       string
+	ObjectType gitism.ObjectType
+	// Mode is the mode of the entry in its tree.
+	Mode gitism.FileMode
+}
+
 type gitFileInfo struct {
-	mode os.FileMode
-	Type gitism.ObjectType
+	mode    os.FileMode
+	gitMode gitism.FileMode
+	Type    gitism.ObjectType
 	// TODO(gravypod): should this be parsed into an int or is this a waste of cycles?
 	Hash string
 
@@ -37,7 +48,7 @@ type gitFileInfo struct {
 	//                 handle the parent dirs? This could save memory
 	path string
 
-	size uint32
+	size int64
 }
 
 func (i gitFileInfo) Name() string {
@@ -45,7 +56,7 @@ func (i gitFileInfo) Name() string {
 }
 
 func (i gitFileInfo) Size() int64 {
-	return int64(i.size)
+	return i.size
 }
 
 func (i gitFileInfo) Mode() fs.FileMode {
@@ -60,16 +71,33 @@ func (i gitFileInfo) IsDir() bool {
 	return i.Mode().IsDir()
 }
 
+// Sys returns a *GitFileStat.
 func (i gitFileInfo) Sys() interface{} {
-	return nil
+	return &GitFileStat{
+		Hash:       i.Hash,
+		ObjectType: i.Type,
+		Mode:       i.gitMode,
+	}
+}
+
+// ObjectHash returns the hash of the git object info describes. ok is false for files that don't come straight from
+// git, like those generated or rewritten by a decorator.
+func ObjectHash(info os.FileInfo) (hash string, ok bool) {
+	stat, ok := info.Sys().(*GitFileStat)
+	if !ok || stat == nil {
+		return "", false
+	}
+	return stat.Hash, true
 }
 
 type gitFile struct {
-	name     string
-	fs       ReferenceFileSystem
-	info     gitFileInfo
-	contents []byte
-	reader   *bytes.Reader
+	name string
+	fs   ReferenceFileSystem
+	info gitFileInfo
+	blob openedBlob
+	// handle is the id of the file in handles, which lists it in OpenHandles.
+	handles *openHandles
+	handle  uint64
 }
 
 func (f gitFile) Name() string {
@@ -82,19 +110,20 @@ func (f gitFile) Write(p []byte) (n int, err error) {
 }
 
 func (f gitFile) Read(p []byte) (n int, err error) {
-	return f.reader.Read(p)
+	return f.blob.Read(p)
 }
 
 func (f gitFile) ReadAt(p []byte, off int64) (n int, err error) {
-	return f.reader.ReadAt(p, off)
+	return f.blob.ReadAt(p, off)
 }
 
 func (f gitFile) Seek(offset int64, whence int) (int64, error) {
-	return f.reader.Seek(offset, whence)
+	return f.blob.Seek(offset, whence)
 }
 
 func (f gitFile) Close() error {
-	return nil
+	f.handles.close(f.handle)
+	return f.blob.close()
 }
 
 func (f gitFile) Lock() error {
@@ -112,32 +141,70 @@ func (f gitFile) Truncate(size int64) error {
 
 type ReferenceFileSystem struct {
 	git       Git
-	reference GitReference
+	reference Ref
 	// Either an empty string or a path to a directory with the repository.
-	root FilePath
+	root     FilePath
+	symlinks SymlinkPolicy
+	// targets is shared with every chroot of the filesystem.
+	targets *symlinkTargets
+	clock   func() time.Time
 }
 
-func NewReferenceFileSystem(git Git, reference GitReference) billy.Filesystem {
+// NewReferenceFileSystem serves the tree of reference. New does the same with every other option available.
+func NewReferenceFileSystem(git Git, reference Ref) billy.Filesystem {
+	return NewReferenceFileSystemWithSymlinks(git, reference, SymlinkRewrite)
+}
+
+// NewReferenceFileSystemWithSymlinks is NewReferenceFileSystem with control over how symlinks pointing outside of the
+// tree are served.
+func NewReferenceFileSystemWithSymlinks(git Git, reference Ref, symlinks SymlinkPolicy) billy.Filesystem {
 	return ReferenceFileSystem{
 		git:       git,
 		reference: reference,
 		root:      RootGitPath(),
+		symlinks:  symlinks,
+		targets:   newSymlinkTargets(),
+		clock:     time.Now,
 	}
 }
 
+var (
+	// ErrIsDirectory is returned when opening a directory as a file.
+	ErrIsDirectory error = syscall.EISDIR
+	// ErrNotDirectory is returned when a file is used as a directory.
+	ErrNotDirectory error = syscall.ENOTDIR
+	// ErrSubmodule is returned when opening a submodule as a file. Submodules are served as empty directories.
+	ErrSubmodule = fmt.Errorf("path is a submodule: %w", syscall.EISDIR)
+)
+
 func (s ReferenceFileSystem) openFile(filename string, fileInfo gitFileInfo) (billy.File, error) {
-	contents, err := s.git.ReadBlob(fileInfo.Hash)
+	switch fileInfo.Type {
+	case gitism.TreeObject:
+		return nil, ErrIsDirectory
+	case gitism.CommitObject:
+		return nil, ErrSubmodule
+	}
+
+	blob, err := openBlob(s.git, fileInfo.Hash, fileInfo.Size())
 	if err != nil {
 		return nil, err
 	}
 
 	file := gitFile{
-		name:     filename,
-		fs:       s,
-		info:     fileInfo,
-		contents: contents,
+		name: filename,
+		fs:   s,
+		info: fileInfo,
+		blob: blob,
 	}
-	file.reader = bytes.NewReader(file.contents)
+	file.handles = handlesOf(s.git)
+	file.handle = file.handles.open(OpenHandle{
+		Path:    filename,
+		Ref:     s.reference.String(),
+		Blob:    fileInfo.Hash,
+		Bytes:   blob.size,
+		Spilled: blob.spilled,
+		Opened:  s.clock().UTC(),
+	})
 
 	return file, nil
 }
@@ -150,43 +217,72 @@ func (s ReferenceFileSystem) lsTree(path FilePath, children bool, handler func(f
 		relativePath += SeparatorString
 	}
 
-	branch := "master"
 	gitPath := GitPath{
-		Reference: GitReference{
-			Branch: &branch,
-		},
-		TreePath: relativePath,
+		Reference: s.reference,
+		TreePath:  relativePath,
 	}
 
 	return s.git.ListTree(gitPath, func(entry gitism.TreeEntry) error {
-		file := gitFileInfo{
-			Hash: entry.Hash,
-			path: entry.Path,
-			size: 0,
+		file, err := s.newFileInfo(entry)
+		if err != nil {
+			return err
 		}
+		return handler(file)
+	})
+}
 
-		// Type
-		file.Type = entry.Object
-
-		// Mode
-		file.mode = fs.FileMode(entry.Mode.Perms)
-		if entry.Mode.Type == gitism.Symlink {
-			file.mode |= fs.ModeSymlink
-		} else if entry.Mode.Type == gitism.Directory {
-			file.mode |= fs.ModeDir
+// lsDirectory lists the directory at path, calling handler with its own entry first and then with its children. It
+// calls handler with nothing if path isn't a directory.
+func (s ReferenceFileSystem) lsDirectory(path FilePath, handler func(file gitFileInfo) error) error {
+	gitPath := GitPath{
+		Reference: s.reference,
+		TreePath:  path.String(),
+	}
+	return s.git.ListDirectory(gitPath, func(entry gitism.TreeEntry) error {
+		file, err := s.newFileInfo(entry)
+		if err != nil {
+			return err
 		}
+		return handler(file)
+	})
+}
+
+func (s ReferenceFileSystem) newFileInfo(entry gitism.TreeEntry) (gitFileInfo, error) {
+	file := gitFileInfo{
+		Hash: entry.Hash,
+		path: entry.Path,
+		size: 0,
+	}
 
-		// Size
-		if entry.Size != "-" {
-			parsedSize, err := strconv.ParseUint(entry.Size, 10, 32)
-			if err != nil {
-				return err
-			}
-			file.size = uint32(parsedSize)
+	// Type
+	file.Type = entry.Object
+
+	// Mode
+	file.gitMode = entry.Mode
+	file.mode = fs.FileMode(entry.Mode.Perms)
+	if entry.Mode.Type == gitism.Symlink {
+		file.mode |= fs.ModeSymlink
+	} else if entry.Mode.Type == gitism.Directory || entry.Mode.Type == gitism.Gitlink {
+		file.mode |= fs.ModeDir
+	}
+
+	// Size. Stat has to agree with the blob's length byte for byte or the kernel will cut off reads and mmap,
+	// which breaks running executables from the mount, so ask git directly if ls-tree didn't say.
+	if entry.Size != "-" {
+		parsedSize, err := strconv.ParseInt(entry.Size, 10, 64)
+		if err != nil {
+			return gitFileInfo{}, err
 		}
+		file.size = parsedSize
+	} else if entry.Object == gitism.BlobObject {
+		blobSize, err := s.git.BlobSize(entry.Hash)
+		if err != nil {
+			return gitFileInfo{}, err
+		}
+		file.size = blobSize
+	}
 
-		return handler(file)
-	})
+	return file, nil
 }
 
 func (s ReferenceFileSystem) lsFile(path FilePath) (gitFileInfo, error) {
@@ -201,7 +297,7 @@ func (s ReferenceFileSystem) lsFile(path FilePath) (gitFileInfo, error) {
 		return nil
 	})
 	if err != nil {
-		return gitFileInfo{}, nil
+		return gitFileInfo{}, err
 	}
 	if !seen {
 		return gitFileInfo{}, fs.ErrNotExist
@@ -209,6 +305,78 @@ func (s ReferenceFileSystem) lsFile(path FilePath) (gitFileInfo, error) {
 	return returnedPath, nil
 }
 
+// readSymlink resolves the symlink at path and reports if its target is absolute or escapes the root.
+func (s ReferenceFileSystem) readSymlink(path FilePath, fileInfo gitFileInfo) (target string, resolved FilePath, escapes bool, err error) {
+	target, err = s.targets.read(s.git, fileInfo.Hash)
+	if err != nil {
+		return "", FilePath{}, false, err
+	}
+	resolved, escapes = resolveSymlink(s.root, path.Parent(), target)
+	return target, resolved, escapes, nil
+}
+
+// servedTarget is what Readlink returns for the symlink at path according to the SymlinkPolicy. It is
+// fs.ErrNotExist if the symlink is hidden.
+func (s ReferenceFileSystem) servedTarget(path FilePath, fileInfo gitFileInfo) (string, error) {
+	target, resolved, escapes, err := s.readSymlink(path, fileInfo)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case s.symlinks == SymlinkPassThrough:
+		return target, nil
+	case s.symlinks == SymlinkHide && escapes:
+		return "", fs.ErrNotExist
+	default:
+		return relativeTo(path.Parent(), resolved)
+	}
+}
+
+// sizeSymlink sets the size of the symlink at path to the length of the target Readlink returns, which is what
+// readlink(2) callers size their buffers with. Rewritten targets aren't as long as the blob they were read from.
+func (s ReferenceFileSystem) sizeSymlink(path FilePath, fileInfo *gitFileInfo) error {
+	if fileInfo.mode&fs.ModeSymlink == 0 || s.symlinks == SymlinkPassThrough {
+		return nil
+	}
+	target, err := s.servedTarget(path, *fileInfo)
+	if err != nil {
+		return err
+	}
+	fileInfo.size = int64(len(target))
+	return nil
+}
+
+// hidden reports if the SymlinkHide policy removes this file from the filesystem.
+func (s ReferenceFileSystem) hidden(path FilePath, fileInfo gitFileInfo) (bool, error) {
+	if s.symlinks != SymlinkHide || fileInfo.mode&fs.ModeSymlink == 0 {
+		return false, nil
+	}
+	_, _, escapes, err := s.readSymlink(path, fileInfo)
+	return escapes, err
+}
+
+// statFile is lsFile for a path the user asked for, so it honours hidden symlinks.
+func (s ReferenceFileSystem) statFile(path FilePath) (gitFileInfo, error) {
+	fileInfo, err := s.lsFile(path)
+	if err != nil {
+		return gitFileInfo{}, err
+	}
+	hidden, err := s.hidden(path, fileInfo)
+	if err != nil {
+		return gitFileInfo{}, err
+	}
+	if hidden {
+		return gitFileInfo{}, fs.ErrNotExist
+	}
+	return fileInfo, nil
+}
+
+// NewReferenceFileSystemAt is NewReferenceFileSystem rooted at subpath, which must be a directory in the tree.
+func NewReferenceFileSystemAt(git Git, reference Ref, subpath string) (billy.Filesystem, error) {
+	return NewReferenceFileSystem(git, reference).Chroot(subpath)
+}
+
 // billy.Basic type implementation
 
 func (s ReferenceFileSystem) Create(filename string) (billy.File, error) {
@@ -217,12 +385,12 @@ func (s ReferenceFileSystem) Create(filename string) (billy.File, error) {
 }
 
 func (s ReferenceFileSystem) Open(filename string) (billy.File, error) {
-	log.Printf("Open(%s)\n", filename)
-	path, err := s.root.Resolve(filename)
+	log.Printf("Open(%s)\n", RedactPath(filename))
+	path, err := s.root.ResolveWithin(filename)
 	if err != nil {
-		return nil, fs.ErrInvalid
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: err}
 	}
-	fileInfo, err := s.lsFile(path)
+	fileInfo, err := s.statFile(path)
 	if err != nil {
 		return nil, err
 	}
@@ -230,34 +398,32 @@ func (s ReferenceFileSystem) Open(filename string) (billy.File, error) {
 }
 
 func (s ReferenceFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
-	log.Printf("OpenFile(%s, %d, %s)\n", filename, flag, perm.String())
+	log.Printf("OpenFile(%s, %d, %s)\n", RedactPath(filename), flag, perm.String())
 
-	path, err := s.root.Resolve(filename)
+	path, err := s.root.ResolveWithin(filename)
 	if err != nil {
-		return nil, fs.ErrInvalid
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: err}
 	}
 
 	if flag != os.O_RDONLY {
 		return nil, billy.ErrReadOnly
 	}
 
-	fileInfo, err := s.lsFile(path)
+	fileInfo, err := s.statFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	if perm != fileInfo.mode {
-		return nil, billy.ErrReadOnly
-	}
+	// perm only matters when creating a file, which O_RDONLY never does.
 	return s.openFile(filename, fileInfo)
 }
 
 func (s ReferenceFileSystem) Stat(filename string) (os.FileInfo, error) {
-	log.Printf("Stat(%s)\n", filename)
+	log.Printf("Stat(%s)\n", RedactPath(filename))
 
-	path, err := s.root.Resolve(filename)
+	path, err := s.root.ResolveWithin(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse path %s: %v", filename, err)
+		return nil, fmt.Errorf("failed to parse path %s: %w", filename, err)
 	}
 
 	// Root must be a directory so we like and say it is. Git doesn't really have a root they expose through ls-tree
@@ -265,15 +431,23 @@ func (s ReferenceFileSystem) Stat(filename string) (os.FileInfo, error) {
 	// are pointing to at head but I didn't feel like executing another git command here.
 	if path.IsRoot() {
 		return gitFileInfo{
-			mode: 0555 | os.ModeDir,
-			Type: gitism.TreeObject,
-			Hash: "",
-			path: filename,
-			size: 0,
+			mode:    0555 | os.ModeDir,
+			gitMode: gitism.FileMode{Type: gitism.Directory, Perms: 0555},
+			Type:    gitism.TreeObject,
+			Hash:    "",
+			path:    filename,
+			size:    0,
 		}, nil
 	}
 
-	return s.lsFile(path)
+	fileInfo, err := s.statFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sizeSymlink(path, &fileInfo); err != nil {
+		return nil, err
+	}
+	return fileInfo, nil
 }
 
 func (s ReferenceFileSystem) Rename(oldpath, newpath string) error {
@@ -302,29 +476,107 @@ func (s ReferenceFileSystem) TempFile(dir, prefix string) (billy.File, error) {
 // billy.Dir type implementation
 
 func (s ReferenceFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
-	log.Printf("ReadDir(%s)\n", path)
-	gitPath, err := s.root.Resolve(path)
+	log.Printf("ReadDir(%s)\n", RedactPath(path))
+	gitPath, err := s.root.ResolveWithin(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse path %s: %v", path, err)
+		return nil, fmt.Errorf("failed to parse path %s: %w", path, err)
 	}
 
-	if !gitPath.IsRoot() {
-		fileInfo, err := s.lsFile(gitPath)
+	var files []os.FileInfo
+	addChild := func(file gitFileInfo) error {
+		filePath, err := gitPath.Resolve(file.Name())
 		if err != nil {
-			return nil, err
+			return err
+		}
+		hidden, err := s.hidden(filePath, file)
+		if err != nil {
+			return err
 		}
+		if hidden {
+			return nil
+		}
+		if err := s.sizeSymlink(filePath, &file); err != nil {
+			return err
+		}
+		files = append(files, file)
+		return nil
+	}
+
+	if gitPath.IsRoot() {
+		err = s.lsTree(gitPath, true, addChild)
+		return files, err
+	}
 
-		if !fileInfo.IsDir() {
-			return nil, fs.ErrInvalid
+	// List the directory along with its own entry so a single ls-tree both checks that it is one and lists it.
+	var directory *gitFileInfo
+	err = s.lsDirectory(gitPath, func(file gitFileInfo) error {
+		if directory == nil {
+			directory = &file
+			return nil
 		}
+		return addChild(file)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if directory == nil {
+		// Nothing was listed so this is either a file or nothing at all.
+		return nil, s.notDirectory("readdir", path, gitPath)
+	}
+	// The submodule's commit isn't in this repository so there is nothing to list.
+	if directory.Type == gitism.CommitObject {
+		return nil, nil
 	}
+	return files, nil
+}
 
-	var files []os.FileInfo
-	err = s.lsTree(gitPath, true, func(file gitFileInfo) error {
-		files = append(files, file)
+// notDirectory is the error for listing path, which isn't a directory. It is ErrNotDirectory if path exists and
+// syscall.ENOENT, which is also fs.ErrNotExist, if it doesn't.
+func (s ReferenceFileSystem) notDirectory(op string, path string, gitPath FilePath) error {
+	if _, err := s.statFile(gitPath); errors.Is(err, fs.ErrNotExist) {
+		return &fs.PathError{Op: op, Path: path, Err: syscall.ENOENT}
+	} else if err != nil {
+		return err
+	}
+	return &fs.PathError{Op: op, Path: path, Err: ErrNotDirectory}
+}
+
+// ReadDirNames lists the names in the directory at path like ReadDir but without finding out anything else about
+// them, which saves git from looking up the size of every blob.
+func (s ReferenceFileSystem) ReadDirNames(path string) ([]string, error) {
+	if s.symlinks == SymlinkHide {
+		// Hiding symlinks means reading them, which needs the rest of ReadDir's work.
+		return readDirNames(s, path)
+	}
+
+	gitPath, err := s.root.ResolveWithin(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse path %s: %w", path, err)
+	}
+	// The trailing separator lists the tree's children rather than the tree itself.
+	treePath := GitPath{Reference: s.reference, TreePath: gitPath.String() + SeparatorString}
+
+	var names []string
+	err = s.git.ListTreeNames(treePath, func(child string) error {
+		// A submodule lists itself rather than its children.
+		if child != gitPath.String() {
+			names = append(names, filepath.Base(child))
+		}
 		return nil
 	})
-	return files, err
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 || gitPath.IsRoot() {
+		return names, nil
+	}
+
+	// git has no empty directories so this is a submodule, a file, or nothing at all.
+	fileInfo, err := s.statFile(gitPath)
+	if err == nil && fileInfo.Type == gitism.CommitObject {
+		return nil, nil
+	}
+	return nil, s.notDirectory("readdir", path, gitPath)
 }
 
 func (s ReferenceFileSystem) MkdirAll(filename string, perm os.FileMode) error {
@@ -341,20 +593,26 @@ func (s ReferenceFileSystem) Root() string {
 }
 
 func (s ReferenceFileSystem) Chroot(path string) (billy.Filesystem, error) {
-	log.Printf("Chroot(%s)\n", path)
-	gitPath, err := s.root.Resolve(path)
+	log.Printf("Chroot(%s)\n", RedactPath(path))
+	gitPath, err := s.root.ResolveWithin(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse path %s: %v", path, err)
+		return nil, fmt.Errorf("failed to parse path %s: %w", path, err)
 	}
 
-	// TODO(gravypod): Handle these following cases...
-	//  1. path does not exist
-	//  2. path leads to a symlink
-	//  3. path is not a directory
-	return ReferenceFileSystem{
-		root: gitPath,
-		git:  s.git,
-	}, nil
+	if !gitPath.IsRoot() {
+		fileInfo, err := s.statFile(gitPath)
+		if err != nil {
+			return nil, err
+		}
+		// Symlinks aren't followed so the new root is always the directory that was asked for.
+		if !fileInfo.IsDir() || fileInfo.Type != gitism.TreeObject {
+			return nil, fmt.Errorf("cannot chroot into %s: %w", path, fs.ErrInvalid)
+		}
+	}
+
+	chrooted := s
+	chrooted.root = gitPath
+	return chrooted, nil
 }
 
 // billy.Symlink type implementation
@@ -369,26 +627,23 @@ func (s ReferenceFileSystem) Symlink(target, link string) error {
 	return billy.ErrReadOnly
 }
 
+// Readlink returns the target of link relative to the directory containing it. Targets that are absolute or escape
+// the root of the filesystem are handled according to the SymlinkPolicy.
 func (s ReferenceFileSystem) Readlink(link string) (string, error) {
-	log.Printf("ReadLink(%s)\n", link)
-	gitPath, err := s.root.Resolve(link)
+	log.Printf("ReadLink(%s)\n", RedactPath(link))
+	gitPath, err := s.root.ResolveWithin(link)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse path %s: %v", link, err)
+		return "", fmt.Errorf("failed to parse path %s: %w", link, err)
 	}
 	fileInfo, err := s.lsFile(gitPath)
 	if err != nil {
 		return "", err
 	}
-	contents, err := s.git.ReadBlob(fileInfo.Hash)
-	if err != nil {
-		return "", err
+	if fileInfo.mode&fs.ModeSymlink == 0 {
+		return "", fs.ErrInvalid
 	}
-	parent := gitPath.Parent()
-	realGitPath, err := parent.Resolve(string(contents))
-	if err != nil {
-		return "", err
-	}
-	return realGitPath.String(), nil
+
+	return s.servedTarget(gitPath, fileInfo)
 }
 
 // billy.Change type implementation
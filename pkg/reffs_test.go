@@ -15,10 +15,16 @@
 package pkg
 
 import (
+	"errors"
 	"github.com/go-git/go-billy/v5"
+	"github.com/google/go-cmp/cmp"
+	"github.com/gravypod/gitfs/pkg/gitism"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -32,8 +38,7 @@ func fileMap(paths []os.FileInfo) map[string]os.FileInfo {
 
 func TestBase(t *testing.T) {
 	git := newGitCliFromPlaybook(t, "base")
-	branch := "master"
-	fs := NewReferenceFileSystem(git, GitReference{Branch: &branch})
+	fs := NewReferenceFileSystem(git, BranchRef("master"))
 	t.Run("reported capabilities", func(t *testing.T) {
 		capabilities := billy.Capabilities(fs)
 		writableCapabilities := []billy.Capability{
@@ -276,3 +281,465 @@ func TestBase(t *testing.T) {
 		}
 	})
 }
+
+func TestGitFileStat(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "base")
+	fs := NewReferenceFileSystem(git, BranchRef("master"))
+
+	want := map[string]GitFileStat{
+		"real.txt": {
+			Hash:       "557db03de997c86a4a028e1ebd3a1ceb225be238",
+			ObjectType: gitism.BlobObject,
+			Mode:       gitism.FileMode{Type: gitism.RegularFile, Perms: 0644},
+		},
+		"executable.sh": {
+			Hash:       "2266c0a976d1b3c4df0b6d02217d1bbe11110693",
+			ObjectType: gitism.BlobObject,
+			Mode:       gitism.FileMode{Type: gitism.RegularFile, Perms: 0755},
+		},
+		"symlink.txt": {
+			Hash:       "c9c61fe1fb4b3bbadb18744348069f1cb5aa7416",
+			ObjectType: gitism.BlobObject,
+			Mode:       gitism.FileMode{Type: gitism.Symlink},
+		},
+		"test": {
+			Hash:       "4e59bddb9f480a1b6d0041c534b5c53a5921dd52",
+			ObjectType: gitism.TreeObject,
+			Mode:       gitism.FileMode{Type: gitism.Directory, Perms: 0444},
+		},
+		"/": {
+			ObjectType: gitism.TreeObject,
+			Mode:       gitism.FileMode{Type: gitism.Directory, Perms: 0555},
+		},
+	}
+	for path, expected := range want {
+		info, err := fs.Lstat(path)
+		if err != nil {
+			t.Fatalf("Lstat(%s) failed: %v", path, err)
+		}
+		stat, ok := info.Sys().(*GitFileStat)
+		if !ok {
+			t.Fatalf("Lstat(%s).Sys() returned %T", path, info.Sys())
+		}
+		if diff := cmp.Diff(expected, *stat); diff != "" {
+			t.Fatalf("Lstat(%s).Sys() differs: %s", path, diff)
+		}
+	}
+
+	templated := NewTemplateFileSystem(fs, []string{"real.txt"}, func() (map[string]string, error) {
+		return nil, nil
+	})
+	info, err := templated.Stat("real.txt")
+	if err != nil {
+		t.Fatalf("Stat(real.txt) failed: %v", err)
+	}
+	if hash, ok := ObjectHash(info); ok {
+		t.Fatalf("a templated file should not report the hash of its blob but reported %s", hash)
+	}
+}
+
+func TestReadDirRunsOneLsTree(t *testing.T) {
+	repository, err := runPlaybook("base", t.TempDir())
+	if err != nil {
+		t.Fatalf("playbook 'base' failed: %v", err)
+	}
+	wrapper, record := recordingGit(t, "ls-tree", 0)
+	git, err := NewCliGit(repository, WithGitExecutable(wrapper))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewReferenceFileSystem(git, BranchRef("master"))
+
+	paths, err := fs.ReadDir("test")
+	if err != nil {
+		t.Fatalf("ReadDir(test) failed: %v", err)
+	}
+	if pathsMap := fileMap(paths); len(paths) != 2 || pathsMap["nested.txt"] == nil || pathsMap["escaping.txt"] == nil {
+		t.Fatalf("ReadDir(test) returned %v", paths)
+	}
+	recorded, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatalf("git wrapper was never run: %v", err)
+	}
+	if count := strings.Count(string(recorded), "\n"); count != 1 {
+		t.Fatalf("ReadDir(test) ran ls-tree %d times:\n%s", count, recorded)
+	}
+}
+
+func TestSymlinkPolicies(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "symlinks")
+
+	tests := map[SymlinkPolicy]map[string]string{
+		SymlinkRewrite: {
+			"relative.txt":        "real.txt",
+			"nested/up.txt":       "../real.txt",
+			"absolute.txt":        "etc/passwd",
+			"nested/escaping.txt": "../outside.txt",
+		},
+		SymlinkHide: {
+			"relative.txt":  "real.txt",
+			"nested/up.txt": "../real.txt",
+		},
+		SymlinkPassThrough: {
+			"relative.txt":        "real.txt",
+			"nested/up.txt":       "../real.txt",
+			"absolute.txt":        "/etc/passwd",
+			"nested/escaping.txt": "../../outside.txt",
+		},
+	}
+
+	for policy, targets := range tests {
+		t.Run(policy.String(), func(t *testing.T) {
+			fs := NewReferenceFileSystemWithSymlinks(git, BranchRef("master"), policy)
+
+			for link, want := range targets {
+				got, err := fs.Readlink(link)
+				if err != nil {
+					t.Fatalf("Readlink(%s) failed: %v", link, err)
+				}
+				if got != want {
+					t.Fatalf("Readlink(%s) = %s, want %s", link, got, want)
+				}
+
+				// readlink(2) callers size their buffer from lstat(2), so both have to agree.
+				info, err := fs.Lstat(link)
+				if err != nil {
+					t.Fatalf("Lstat(%s) failed: %v", link, err)
+				}
+				if info.Size() != int64(len(want)) {
+					t.Fatalf("Lstat(%s) has size %d, expected the length of %s", link, info.Size(), want)
+				}
+				paths, err := fs.ReadDir(filepath.Dir(link))
+				if err != nil {
+					t.Fatalf("ReadDir(%s) failed: %v", filepath.Dir(link), err)
+				}
+				if listed := fileMap(paths)[filepath.Base(link)]; listed == nil || listed.Size() != int64(len(want)) {
+					t.Fatalf("listing %s returned %v, expected a size of %d", link, listed, len(want))
+				}
+			}
+
+			for _, link := range []string{"absolute.txt", "nested/escaping.txt"} {
+				_, hasTarget := targets[link]
+				if _, err := fs.Lstat(link); hasTarget != (err == nil) {
+					t.Fatalf("Lstat(%s) returned %v", link, err)
+				}
+
+				paths, err := fs.ReadDir(filepath.Dir(link))
+				if err != nil {
+					t.Fatalf("ReadDir(%s) failed: %v", filepath.Dir(link), err)
+				}
+				if _, listed := fileMap(paths)[filepath.Base(link)]; listed != hasTarget {
+					t.Fatalf("listing %s returned %v", link, paths)
+				}
+			}
+		})
+	}
+}
+
+// blobCountingGit counts the blobs read through it.
+type blobCountingGit struct {
+	Git
+	reads map[string]int
+}
+
+func (g blobCountingGit) ReadBlob(hash string) ([]byte, error) {
+	g.reads[hash]++
+	return g.Git.ReadBlob(hash)
+}
+
+func TestSymlinkTargetsCached(t *testing.T) {
+	git := blobCountingGit{Git: fakeSymlinks(), reads: map[string]int{}}
+	fs := NewReferenceFileSystem(git, BranchRef("master"))
+	nested, err := fs.Chroot("nested")
+	if err != nil {
+		t.Fatalf("Chroot(nested) failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := fs.Readlink("relative.txt"); err != nil {
+			t.Fatalf("Readlink(relative.txt) failed: %v", err)
+		}
+		if _, err := fs.ReadDir("nested"); err != nil {
+			t.Fatalf("ReadDir(nested) failed: %v", err)
+		}
+		if _, err := nested.Lstat("up.txt"); err != nil {
+			t.Fatalf("Lstat(up.txt) failed: %v", err)
+		}
+	}
+	for hash, reads := range git.reads {
+		if reads != 1 {
+			t.Fatalf("the symlink in blob %s was read %d times", hash, reads)
+		}
+	}
+	if len(git.reads) != 3 {
+		t.Fatalf("expected relative.txt, nested/up.txt, and nested/escaping.txt to be read, read %v", git.reads)
+	}
+}
+
+func TestSha256(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "sha256")
+
+	format, err := git.ObjectFormat()
+	if err != nil {
+		t.Fatalf("ObjectFormat() failed: %v", err)
+	}
+	if format != gitism.SHA256 {
+		t.Fatalf("expected a sha256 repository but found %s", format)
+	}
+
+	fs := NewReferenceFileSystem(git, BranchRef("master"))
+
+	paths, err := fs.ReadDir(".")
+	if err != nil {
+		t.Fatalf("ReadDir(.) failed: %v", err)
+	}
+	pathsMap := fileMap(paths)
+	if len(paths) != 2 || pathsMap["test"] == nil || pathsMap["real.txt"] == nil {
+		t.Fatalf("ReadDir(.) returned %v", paths)
+	}
+
+	info, err := fs.Stat("test/nested.txt")
+	if err != nil {
+		t.Fatalf("Stat(test/nested.txt) failed: %v", err)
+	}
+	if hash, _ := ObjectHash(info); len(hash) != 64 {
+		t.Fatalf("test/nested.txt has a hash of the wrong length: %s", hash)
+	}
+
+	file, err := fs.Open("test/nested.txt")
+	if err != nil {
+		t.Fatalf("Open(test/nested.txt) failed: %v", err)
+	}
+	contents, err := io.ReadAll(file)
+	if err != nil || string(contents) != "Nested file\n" {
+		t.Fatalf("reading test/nested.txt returned %q, %v", contents, err)
+	}
+}
+
+func TestSizesMatchContents(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "executables")
+	fs := NewReferenceFileSystem(git, BranchRef("master"))
+
+	for _, name := range []string{"bin/true", "bin/hello.sh"} {
+		info, err := fs.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat(%s) failed: %v", name, err)
+		}
+		file, err := fs.Open(name)
+		if err != nil {
+			t.Fatalf("Open(%s) failed: %v", name, err)
+		}
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", name, err)
+		}
+		if info.Size() != int64(len(contents)) || info.Size() == 0 {
+			t.Fatalf("Stat(%s) reported %d bytes but %d were read", name, info.Size(), len(contents))
+		}
+		if info.Mode().Perm()&0111 == 0 {
+			t.Fatalf("%s is not executable: %s", name, info.Mode())
+		}
+	}
+}
+
+// largeGit reports every blob as larger than 4 GiB without having to store one in a test repository.
+type largeGit struct {
+	Git
+}
+
+func (g largeGit) ListTree(path GitPath, handler func(entry gitism.TreeEntry) error) error {
+	return g.Git.ListTree(path, func(entry gitism.TreeEntry) error {
+		if entry.Object == gitism.BlobObject {
+			entry.Size = "5000000000"
+		}
+		return handler(entry)
+	})
+}
+
+func TestLargeFiles(t *testing.T) {
+	git := largeGit{Git: newGitCliFromPlaybook(t, "base")}
+	fs := NewReferenceFileSystem(git, BranchRef("master"))
+
+	info, err := fs.Stat("real.txt")
+	if err != nil {
+		t.Fatalf("Stat(real.txt) failed: %v", err)
+	}
+	if info.Size() != 5000000000 {
+		t.Fatalf("Stat(real.txt) reported %d bytes", info.Size())
+	}
+
+	attributes := infoToAttributes(info)
+	if attributes.Size != 5000000000 {
+		t.Fatalf("FUSE attributes reported %d bytes", attributes.Size)
+	}
+}
+
+func TestOpenNonFiles(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "submodule")
+	fs := NewReferenceFileSystem(git, BranchRef("master"))
+
+	t.Run("directory", func(t *testing.T) {
+		if _, err := fs.Open("vendor"); !errors.Is(err, ErrIsDirectory) {
+			t.Fatalf("Open(vendor) should fail with ErrIsDirectory: %v", err)
+		}
+		if _, err := fs.OpenFile("vendor", os.O_RDONLY, 0); !errors.Is(err, syscall.EISDIR) {
+			t.Fatalf("OpenFile(vendor) should fail with EISDIR: %v", err)
+		}
+	})
+
+	t.Run("submodule", func(t *testing.T) {
+		info, err := fs.Stat("vendor/lib")
+		if err != nil {
+			t.Fatalf("Stat(vendor/lib) failed: %v", err)
+		}
+		if !info.IsDir() {
+			t.Fatalf("vendor/lib should be a directory: %s", info.Mode())
+		}
+
+		paths, err := fs.ReadDir("vendor/lib")
+		if err != nil || len(paths) != 0 {
+			t.Fatalf("ReadDir(vendor/lib) returned %v, %v", paths, err)
+		}
+		names, err := fs.(ReferenceFileSystem).ReadDirNames("vendor/lib")
+		if err != nil || len(names) != 0 {
+			t.Fatalf("ReadDirNames(vendor/lib) returned %v, %v", names, err)
+		}
+
+		_, err = fs.Open("vendor/lib")
+		if !errors.Is(err, ErrSubmodule) || !errors.Is(err, syscall.EISDIR) {
+			t.Fatalf("Open(vendor/lib) should fail with ErrSubmodule: %v", err)
+		}
+
+		if _, err := fs.Readlink("vendor/lib"); err == nil {
+			t.Fatalf("Readlink(vendor/lib) should fail")
+		}
+	})
+}
+
+func TestReadDirErrors(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "base")
+	for _, policy := range []SymlinkPolicy{SymlinkRewrite, SymlinkHide} {
+		fs := NewReferenceFileSystemWithSymlinks(git, BranchRef("master"), policy)
+		readers := map[string]func(path string) error{
+			"ReadDir": func(path string) error {
+				_, err := fs.ReadDir(path)
+				return err
+			},
+			"ReadDirNames": func(path string) error {
+				_, err := ReadDirNames(fs, path)
+				return err
+			},
+		}
+		for name, read := range readers {
+			if err := read("real.txt"); !errors.Is(err, ErrNotDirectory) || !errors.Is(err, syscall.ENOTDIR) {
+				t.Fatalf("%s(real.txt) should fail with ENOTDIR: %v", name, err)
+			}
+			if err := read("test/nested.txt"); !errors.Is(err, syscall.ENOTDIR) {
+				t.Fatalf("%s(test/nested.txt) should fail with ENOTDIR: %v", name, err)
+			}
+			if err := read("missing"); !errors.Is(err, syscall.ENOENT) || !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("%s(missing) should fail with ENOENT: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestReadDirNames(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "base")
+	for _, policy := range []SymlinkPolicy{SymlinkRewrite, SymlinkHide} {
+		fs := NewReferenceFileSystemWithSymlinks(git, BranchRef("master"), policy)
+		for _, path := range []string{".", "test"} {
+			infos, err := fs.ReadDir(path)
+			if err != nil {
+				t.Fatalf("ReadDir(%s) failed: %v", path, err)
+			}
+			var want []string
+			for _, info := range infos {
+				want = append(want, info.Name())
+			}
+			got, err := ReadDirNames(fs, path)
+			if err != nil {
+				t.Fatalf("ReadDirNames(%s) failed: %v", path, err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("ReadDirNames(%s) differs from ReadDir with %s symlinks: %s", path, policy, diff)
+			}
+		}
+	}
+}
+
+func TestReferenceFileSystemAt(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "base")
+	reference := BranchRef("master")
+
+	t.Run("directory", func(t *testing.T) {
+		fs, err := NewReferenceFileSystemAt(git, reference, "test")
+		if err != nil {
+			t.Fatalf("NewReferenceFileSystemAt(test) failed: %v", err)
+		}
+		paths, err := fs.ReadDir(".")
+		if err != nil {
+			t.Fatalf("ReadDir(.) failed: %v", err)
+		}
+		if _, ok := fileMap(paths)["nested.txt"]; !ok || len(paths) != 2 {
+			t.Fatalf("ReadDir(.) returned %v", paths)
+		}
+		file, err := fs.Open("nested.txt")
+		if err != nil {
+			t.Fatalf("Open(nested.txt) failed: %v", err)
+		}
+		contents, err := io.ReadAll(file)
+		if err != nil || string(contents) != "Nested file\n" {
+			t.Fatalf("reading nested.txt returned %q, %v", contents, err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := NewReferenceFileSystemAt(git, reference, "missing"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("NewReferenceFileSystemAt(missing) should fail with ErrNotExist: %v", err)
+		}
+		if _, err := NewReferenceFileSystemAt(git, reference, "real.txt"); !errors.Is(err, os.ErrInvalid) {
+			t.Fatalf("NewReferenceFileSystemAt(real.txt) should fail with ErrInvalid: %v", err)
+		}
+		if _, err := NewReferenceFileSystemAt(git, reference, "../"); err == nil {
+			t.Fatalf("NewReferenceFileSystemAt(../) should fail")
+		}
+	})
+
+	t.Run("escaping", func(t *testing.T) {
+		fs, err := NewReferenceFileSystemAt(git, reference, "test")
+		if err != nil {
+			t.Fatalf("NewReferenceFileSystemAt(test) failed: %v", err)
+		}
+		for _, name := range []string{"..", "../real.txt", "/../real.txt"} {
+			if _, err := fs.Open(name); !errors.Is(err, ErrEscapesChroot) {
+				t.Fatalf("Open(%s) should fail with ErrEscapesChroot: %v", name, err)
+			}
+			if _, err := fs.ReadDir(name); !errors.Is(err, ErrEscapesChroot) {
+				t.Fatalf("ReadDir(%s) should fail with ErrEscapesChroot: %v", name, err)
+			}
+			if _, err := fs.Chroot(name); !errors.Is(err, ErrEscapesChroot) {
+				t.Fatalf("Chroot(%s) should fail with ErrEscapesChroot: %v", name, err)
+			}
+		}
+	})
+}
+
+func TestReferences(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "tags")
+
+	for reference, want := range map[Ref]string{
+		TagRef("v1.0"):      "Hello World\n",
+		BranchRef("master"): "Hello World, again\n",
+	} {
+		fs := NewReferenceFileSystem(git, reference)
+		file, err := fs.Open("real.txt")
+		if err != nil {
+			t.Fatalf("Open(real.txt) failed: %v", err)
+		}
+		contents, err := io.ReadAll(file)
+		if err != nil || string(contents) != want {
+			t.Fatalf("reading real.txt returned %q, %v but wanted %q", contents, err, want)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ReleasesDirectory is where NewReleasesFileSystem serves every release. It shadows any directory of the same name
+	// in the repository.
+	ReleasesDirectory = "releases"
+	// LatestReleaseName names the symlink in ReleasesDirectory to the newest release that isn't a prerelease.
+	LatestReleaseName = "latest"
+)
+
+// releasesTTL is how long the list of releases is reused for so walking a release doesn't run git for every path.
+const releasesTTL = time.Second
+
+// releasesFileSystem adds a directory at /releases/ with the tree of every tag named after a semantic version.
+type releasesFileSystem struct {
+	billy.Filesystem
+	git      Git
+	symlinks SymlinkPolicy
+
+	mu       sync.Mutex
+	releases []Release
+	listed   time.Time
+}
+
+// NewReleasesFileSystem serves the tree of every tag of git that is a semantic version, like v1.2.3, at
+// /releases/<tag>/ on top of fs, oldest first, along with /releases/latest linking to the newest one that isn't a
+// prerelease so deployments can mount the latest release without knowing its name. New tags show up within a second.
+func NewReleasesFileSystem(fs billy.Filesystem, git Git, symlinks SymlinkPolicy) billy.Filesystem {
+	return &releasesFileSystem{Filesystem: fs, git: git, symlinks: symlinks}
+}
+
+// list returns the releases, listing them again if they are older than releasesTTL.
+func (s *releasesFileSystem) list() ([]Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.releases != nil && time.Since(s.listed) < releasesTTL {
+		return s.releases, nil
+	}
+	releases, err := ListReleases(s.git)
+	if err != nil {
+		return nil, err
+	}
+	if releases == nil {
+		releases = []Release{}
+	}
+	s.releases, s.listed = releases, time.Now()
+	return releases, nil
+}
+
+// releasePath is where a path within /releases/ leads.
+type releasePath struct {
+	// tag is the release the path is in, or empty for /releases/ itself.
+	tag string
+	// path is the path within the tree of tag.
+	path string
+	// latest is set for /releases/latest itself.
+	latest bool
+}
+
+// lookup maps filename to a path within /releases/. ok is false for paths outside of it. /releases/latest/ leads into
+// the latest release.
+func (s *releasesFileSystem) lookup(filename string) (release releasePath, ok bool, err error) {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil {
+		return releasePath{}, false, err
+	}
+	if len(resolved.Path) == 0 || resolved.Path[0] != ReleasesDirectory {
+		return releasePath{}, false, nil
+	}
+	if len(resolved.Path) == 1 {
+		return releasePath{}, true, nil
+	}
+
+	releases, err := s.list()
+	if err != nil {
+		return releasePath{}, true, err
+	}
+	name := resolved.Path[1]
+	release = releasePath{
+		path:   SeparatorString + strings.Join(resolved.Path[2:], SeparatorString),
+		latest: name == LatestReleaseName && len(resolved.Path) == 2,
+	}
+	if name == LatestReleaseName {
+		latest, exists := LatestRelease(releases)
+		if !exists {
+			return releasePath{}, true, fs.ErrNotExist
+		}
+		release.tag = latest.Tag
+		return release, true, nil
+	}
+	for _, candidate := range releases {
+		if candidate.Tag == name {
+			release.tag = name
+			return release, true, nil
+		}
+	}
+	return releasePath{}, true, fs.ErrNotExist
+}
+
+// tree serves the tree of the release tag.
+func (s *releasesFileSystem) tree(tag string) billy.Filesystem {
+	return NewReferenceFileSystemWithSymlinks(s.git, TagRef(tag), s.symlinks)
+}
+
+func (s *releasesFileSystem) directoryInfo() os.FileInfo {
+	return introspectionInfo{name: ReleasesDirectory, mode: os.ModeDir | 0555}
+}
+
+func (s *releasesFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s *releasesFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	release, ok, err := s.lookup(filename)
+	if !ok {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: err}
+	}
+	if flag&writeFlags != 0 {
+		return nil, billy.ErrReadOnly
+	}
+	if release.tag == "" {
+		return nil, ErrIsDirectory
+	}
+	return s.tree(release.tag).OpenFile(release.path, flag, perm)
+}
+
+func (s *releasesFileSystem) Stat(filename string) (os.FileInfo, error) {
+	release, ok, err := s.lookup(filename)
+	if !ok {
+		return s.Filesystem.Stat(filename)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: filename, Err: err}
+	}
+	if release.tag == "" {
+		return s.directoryInfo(), nil
+	}
+	info, err := s.tree(release.tag).Stat(release.path)
+	if err != nil {
+		return nil, err
+	}
+	if release.path == SeparatorString {
+		name := release.tag
+		if release.latest {
+			name = LatestReleaseName
+		}
+		return introspectionInfo{name: name, mode: info.Mode()}, nil
+	}
+	return info, nil
+}
+
+func (s *releasesFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	release, ok, err := s.lookup(filename)
+	if !ok {
+		return s.Filesystem.Lstat(filename)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: filename, Err: err}
+	}
+	if release.latest {
+		return introspectionInfo{name: LatestReleaseName, mode: os.ModeSymlink | 0777, size: int64(len(release.tag))}, nil
+	}
+	if release.tag == "" {
+		return s.directoryInfo(), nil
+	}
+	if release.path == SeparatorString {
+		return introspectionInfo{name: release.tag, mode: os.ModeDir | 0555}, nil
+	}
+	return s.tree(release.tag).Lstat(release.path)
+}
+
+func (s *releasesFileSystem) ReadDir(filename string) ([]os.FileInfo, error) {
+	release, ok, err := s.lookup(filename)
+	if !ok {
+		files, err := s.Filesystem.ReadDir(filename)
+		if err != nil {
+			return nil, err
+		}
+		root := RootGitPath()
+		if resolved, err := root.Resolve(filename); err != nil || !resolved.IsRoot() {
+			return files, nil
+		}
+		// The releases shadow anything at /releases/ in the repository.
+		listing := make([]os.FileInfo, 0, len(files)+1)
+		for _, file := range files {
+			if file.Name() != ReleasesDirectory {
+				listing = append(listing, file)
+			}
+		}
+		return append(listing, s.directoryInfo()), nil
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: filename, Err: err}
+	}
+	if release.tag != "" {
+		return s.tree(release.tag).ReadDir(release.path)
+	}
+
+	releases, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	files := make([]os.FileInfo, 0, len(releases)+1)
+	for _, release := range releases {
+		files = append(files, introspectionInfo{name: release.Tag, mode: os.ModeDir | 0555})
+	}
+	if latest, exists := LatestRelease(releases); exists {
+		files = append(files, introspectionInfo{name: LatestReleaseName, mode: os.ModeSymlink | 0777, size: int64(len(latest.Tag))})
+	}
+	return files, nil
+}
+
+func (s *releasesFileSystem) Readlink(link string) (string, error) {
+	release, ok, err := s.lookup(link)
+	if !ok {
+		return s.Filesystem.Readlink(link)
+	}
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: link, Err: err}
+	}
+	if release.latest {
+		return release.tag, nil
+	}
+	if release.tag == "" || release.path == SeparatorString {
+		return "", &fs.PathError{Op: "readlink", Path: link, Err: fs.ErrInvalid}
+	}
+	return s.tree(release.tag).Readlink(release.path)
+}
+
+func (s *releasesFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	release, ok, err := s.lookup(path)
+	if !ok {
+		return s.Filesystem.Chroot(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if release.tag == "" {
+		return nil, billy.ErrNotSupported
+	}
+	return s.tree(release.tag).Chroot(release.path)
+}
+
+// The releases are read-only.
+
+func (s *releasesFileSystem) Create(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (s *releasesFileSystem) Rename(oldpath, newpath string) error {
+	if s.released(oldpath) || s.released(newpath) {
+		return billy.ErrReadOnly
+	}
+	return s.Filesystem.Rename(oldpath, newpath)
+}
+
+func (s *releasesFileSystem) Remove(filename string) error {
+	if s.released(filename) {
+		return billy.ErrReadOnly
+	}
+	return s.Filesystem.Remove(filename)
+}
+
+func (s *releasesFileSystem) TempFile(dir, prefix string) (billy.File, error) {
+	if s.released(dir) {
+		return nil, billy.ErrReadOnly
+	}
+	return s.Filesystem.TempFile(dir, prefix)
+}
+
+func (s *releasesFileSystem) MkdirAll(filename string, perm os.FileMode) error {
+	if s.released(filename) {
+		return billy.ErrReadOnly
+	}
+	return s.Filesystem.MkdirAll(filename, perm)
+}
+
+func (s *releasesFileSystem) Symlink(target, link string) error {
+	if s.released(link) {
+		return billy.ErrReadOnly
+	}
+	return s.Filesystem.Symlink(target, link)
+}
+
+// released reports whether filename is within /releases/.
+func (s *releasesFileSystem) released(filename string) bool {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	return err == nil && len(resolved.Path) > 0 && resolved.Path[0] == ReleasesDirectory
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestReleasesFileSystem(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "releases")
+	filesystem := NewReleasesFileSystem(NewReferenceFileSystem(git, BranchRef("master")), git, SymlinkRewrite)
+
+	read := func(t *testing.T, filename string) string {
+		file, err := filesystem.Open(filename)
+		if err != nil {
+			t.Fatalf("Open(%s) failed: %v", filename, err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", filename, err)
+		}
+		return string(contents)
+	}
+	names := func(t *testing.T, filename string) []string {
+		paths, err := filesystem.ReadDir(filename)
+		if err != nil {
+			t.Fatalf("ReadDir(%s) failed: %v", filename, err)
+		}
+		var names []string
+		for _, path := range paths {
+			names = append(names, path.Name())
+		}
+		return names
+	}
+
+	t.Run("listing", func(t *testing.T) {
+		if diff := cmp.Diff([]string{"version.txt", ReleasesDirectory}, names(t, "/")); diff != "" {
+			t.Fatal(diff)
+		}
+		if diff := cmp.Diff([]string{"v1.0.0", "v1.1.0", "v1.2.0", "v1.10.0", "v2.0.0-rc.1", LatestReleaseName}, names(t, ReleasesDirectory)); diff != "" {
+			t.Fatal(diff)
+		}
+		if diff := cmp.Diff([]string{"releases", "version.txt"}, names(t, "releases/v1.0.0")); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("releases", func(t *testing.T) {
+		for tag, version := range map[string]string{"v1.0.0": "1.0.0\n", "v1.1.0": "1.0.0\n", "v1.10.0": "1.10.0\n", "v2.0.0-rc.1": "2.0.0-rc.1\n"} {
+			if got := read(t, "releases/"+tag+"/version.txt"); got != version {
+				t.Fatalf("releases/%s/version.txt = %q, expected %q", tag, got, version)
+			}
+		}
+		info, err := filesystem.Stat("releases/v1.2.0")
+		if err != nil || !info.IsDir() || info.Name() != "v1.2.0" {
+			t.Fatalf("Stat(releases/v1.2.0) = %v, %v", info, err)
+		}
+	})
+
+	t.Run("latest", func(t *testing.T) {
+		info, err := filesystem.Lstat("releases/latest")
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Fatalf("Lstat(releases/latest) = %v, %v, expected a symlink", info, err)
+		}
+		target, err := filesystem.Readlink("releases/latest")
+		if err != nil || target != "v1.10.0" {
+			t.Fatalf("Readlink(releases/latest) = %s, %v, expected v1.10.0", target, err)
+		}
+		if info, err := filesystem.Stat("releases/latest"); err != nil || !info.IsDir() {
+			t.Fatalf("Stat(releases/latest) = %v, %v, expected it to follow the link", info, err)
+		}
+		if got := read(t, "releases/latest/version.txt"); got != "1.10.0\n" {
+			t.Fatalf("releases/latest/version.txt = %q", got)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		for _, filename := range []string{"releases/nightly", "releases/1.2", "releases/notes.txt", "releases/v1.0.0/missing.txt"} {
+			if _, err := filesystem.Stat(filename); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("Stat(%s) = %v, expected it to not exist", filename, err)
+			}
+		}
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		if _, err := filesystem.Create("releases/v1.0.0/new.txt"); !errors.Is(err, billy.ErrReadOnly) {
+			t.Fatalf("Create() in a release = %v, expected %v", err, billy.ErrReadOnly)
+		}
+		if err := filesystem.Remove("releases/v1.0.0/version.txt"); !errors.Is(err, billy.ErrReadOnly) {
+			t.Fatalf("Remove() in a release = %v, expected %v", err, billy.ErrReadOnly)
+		}
+	})
+}
@@ -0,0 +1,400 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"io"
+	"io/fs"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxCachedListings bounds how many directory listings a Client keeps to revalidate. One is dropped to make room
+// when it fills up.
+const maxCachedListings = 1024
+
+type Client struct {
+	rpc *rpc.Client
+
+	mu sync.Mutex
+	// listings are the last entries ReadDir got for each path, to be sent again only if they changed.
+	listings map[string]cachedListing
+}
+
+// cachedListing is a directory listing and the ETag the server gave it.
+type cachedListing struct {
+	etag    string
+	entries []FileInfo
+}
+
+// Dial connects to a gitfs remote server.
+func Dial(network, address string) (*Client, error) {
+	client, err := rpc.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(client), nil
+}
+
+func NewClient(client *rpc.Client) *Client {
+	return &Client{rpc: client, listings: map[string]cachedListing{}}
+}
+
+func (c *Client) call(method string, request interface{}, response interface{}) error {
+	return fromWireError(c.rpc.Call(ServiceName+"."+method, request, response))
+}
+
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+// readDir lists path, only transferring the entries when they changed since the client last listed it.
+func (c *Client) readDir(path string) ([]FileInfo, error) {
+	c.mu.Lock()
+	cached, ok := c.listings[path]
+	c.mu.Unlock()
+
+	var response ReadDirResponse
+	err := c.call("ReadDir", ReadDirRequest{Path: path, IfNoneMatch: cached.etag}, &response)
+	if err != nil {
+		return nil, err
+	}
+	if ok && response.NotModified {
+		return cached.entries, nil
+	}
+	if response.ETag == "" {
+		return response.Entries, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.listings[path]; !ok && len(c.listings) >= maxCachedListings {
+		for evicted := range c.listings {
+			delete(c.listings, evicted)
+			break
+		}
+	}
+	c.listings[path] = cachedListing{etag: response.ETag, entries: response.Entries}
+	return response.Entries, nil
+}
+
+// ListRefs returns the branches and tags of the repository the server is backed by.
+func (c *Client) ListRefs() (branches []string, tags []string, err error) {
+	var response ListRefsResponse
+	err = c.call("ListRefs", ListRefsRequest{}, &response)
+	if err != nil {
+		return nil, nil, err
+	}
+	return response.Branches, response.Tags, nil
+}
+
+type remoteFileInfo struct {
+	info FileInfo
+}
+
+func (i remoteFileInfo) Name() string {
+	return i.info.Name
+}
+
+func (i remoteFileInfo) Size() int64 {
+	return i.info.Size
+}
+
+func (i remoteFileInfo) Mode() fs.FileMode {
+	return i.info.Mode
+}
+
+func (i remoteFileInfo) ModTime() time.Time {
+	return i.info.ModTime
+}
+
+func (i remoteFileInfo) IsDir() bool {
+	return i.info.Mode.IsDir()
+}
+
+func (i remoteFileInfo) Sys() interface{} {
+	return nil
+}
+
+type remoteFile struct {
+	fs     *FileSystem
+	name   string
+	size   int64
+	offset int64
+	// handle is what the server opened the file as, or 0 for servers older than OpenRequest, which are read by path.
+	handle uint64
+	closed uint32
+}
+
+func (f *remoteFile) Name() string {
+	return f.name
+}
+
+func (f *remoteFile) Write(p []byte) (n int, err error) {
+	_ = p
+	return 0, billy.ErrNotSupported
+}
+
+func (f *remoteFile) Read(p []byte) (n int, err error) {
+	n, err = f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// ReadAt splits reads larger than MaxReadLength into several requests.
+func (f *remoteFile) ReadAt(p []byte, off int64) (n int, err error) {
+	for n < len(p) {
+		length := len(p) - n
+		if length > MaxReadLength {
+			length = MaxReadLength
+		}
+		read, err := f.readAt(p[n:n+length], off+int64(n))
+		n += read
+		if err != nil {
+			return n, err
+		}
+		if read < length {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+func (f *remoteFile) readAt(p []byte, off int64) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if atomic.LoadUint32(&f.closed) != 0 {
+		return 0, fs.ErrClosed
+	}
+	request := ReadRequest{Handle: f.handle, Offset: off, Length: len(p)}
+	if f.handle == 0 {
+		request.Path = f.fs.path(f.name)
+	}
+	var response ReadResponse
+	err = f.fs.client.call("Read", request, &response)
+	if err != nil {
+		return 0, err
+	}
+	n = copy(p, response.Data)
+	if response.EOF {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *remoteFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fs.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *remoteFile) Close() error {
+	if !atomic.CompareAndSwapUint32(&f.closed, 0, 1) || f.handle == 0 {
+		return nil
+	}
+	return f.fs.client.call("Close", CloseRequest{Handle: f.handle}, &CloseResponse{})
+}
+
+func (f *remoteFile) Lock() error {
+	return billy.ErrNotSupported
+}
+
+func (f *remoteFile) Unlock() error {
+	return billy.ErrNotSupported
+}
+
+func (f *remoteFile) Truncate(size int64) error {
+	_ = size
+	return billy.ErrNotSupported
+}
+
+// FileSystem is a read-only billy.Filesystem backed by a remote server.
+type FileSystem struct {
+	client *Client
+	root   string
+}
+
+func NewFileSystem(client *Client) billy.Filesystem {
+	return &FileSystem{client: client, root: "."}
+}
+
+func (s *FileSystem) path(filename string) string {
+	return filepath.Join(s.root, filename)
+}
+
+func (s *FileSystem) Create(filename string) (billy.File, error) {
+	_ = filename
+	return nil, billy.ErrReadOnly
+}
+
+func (s *FileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s *FileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	_ = perm
+	if flag != os.O_RDONLY {
+		return nil, billy.ErrReadOnly
+	}
+	var response OpenResponse
+	err := s.client.call("Open", OpenRequest{Path: s.path(filename)}, &response)
+	if isUnknownMethod(err) {
+		info, err := s.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		return &remoteFile{fs: s, name: filename, size: info.Size()}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &remoteFile{fs: s, name: filename, size: response.Info.Size, handle: response.Handle}, nil
+}
+
+// isUnknownMethod reports if err is net/rpc refusing a call the server doesn't have, like Open on an older server.
+func isUnknownMethod(err error) bool {
+	serverError, ok := err.(rpc.ServerError)
+	return ok && strings.HasPrefix(string(serverError), "rpc: can't find method ")
+}
+
+func (s *FileSystem) Stat(filename string) (os.FileInfo, error) {
+	var response StatResponse
+	err := s.client.call("Stat", PathRequest{Path: s.path(filename)}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return remoteFileInfo{info: response.Info}, nil
+}
+
+func (s *FileSystem) Rename(oldpath, newpath string) error {
+	_ = oldpath
+	_ = newpath
+	return billy.ErrReadOnly
+}
+
+func (s *FileSystem) Remove(filename string) error {
+	_ = filename
+	return billy.ErrReadOnly
+}
+
+func (s *FileSystem) Join(elem ...string) string {
+	return filepath.Clean(filepath.Join(elem...))
+}
+
+func (s *FileSystem) TempFile(dir, prefix string) (billy.File, error) {
+	_ = dir
+	_ = prefix
+	return nil, billy.ErrReadOnly
+}
+
+func (s *FileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	entries, err := s.client.readDir(s.path(path))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		infos = append(infos, remoteFileInfo{info: entry})
+	}
+	return infos, nil
+}
+
+func (s *FileSystem) MkdirAll(filename string, perm os.FileMode) error {
+	_ = filename
+	_ = perm
+	return billy.ErrReadOnly
+}
+
+func (s *FileSystem) Lstat(filename string) (os.FileInfo, error) {
+	return s.Stat(filename)
+}
+
+func (s *FileSystem) Symlink(target, link string) error {
+	_ = target
+	_ = link
+	return billy.ErrReadOnly
+}
+
+func (s *FileSystem) Readlink(link string) (string, error) {
+	var response ReadlinkResponse
+	err := s.client.call("Readlink", PathRequest{Path: s.path(link)}, &response)
+	if err != nil {
+		return "", err
+	}
+	return response.Target, nil
+}
+
+func (s *FileSystem) Chroot(path string) (billy.Filesystem, error) {
+	info, err := s.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fs.ErrInvalid
+	}
+	return &FileSystem{client: s.client, root: s.path(path)}, nil
+}
+
+func (s *FileSystem) Root() string {
+	return s.root
+}
+
+func (s *FileSystem) Chmod(name string, mode os.FileMode) error {
+	_ = name
+	_ = mode
+	return billy.ErrReadOnly
+}
+
+func (s *FileSystem) Lchown(name string, uid, gid int) error {
+	_ = name
+	_ = uid
+	_ = gid
+	return billy.ErrReadOnly
+}
+
+func (s *FileSystem) Chown(name string, uid, gid int) error {
+	_ = name
+	_ = uid
+	_ = gid
+	return billy.ErrReadOnly
+}
+
+func (s *FileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	_ = name
+	_ = atime
+	_ = mtime
+	return billy.ErrReadOnly
+}
+
+func (s *FileSystem) Capabilities() billy.Capability {
+	return billy.ReadCapability | billy.SeekCapability
+}
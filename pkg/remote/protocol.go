@@ -0,0 +1,172 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remote implements a small RPC protocol for serving a billy.Filesystem from a central gitfs daemon to many
+// thin clients. The server runs next to the repository and the client implements billy.Filesystem on top of the
+// connection so it can be handed to any of the existing frontends (FUSE, NFS) without the repository being present
+// on the client's machine.
+//
+// The protocol is built on net/rpc with gob encoding rather than gRPC so it does not pull in any code generation or
+// dependencies. The price is that it has no schema beyond the Go types below, so only Go clients can speak it.
+package remote
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"io/fs"
+	"net/rpc"
+	"os"
+	"time"
+)
+
+// ServiceName is the name the filesystem service is registered under on the rpc.Server.
+const ServiceName = "GitFS"
+
+// FileInfo is the wire representation of an os.FileInfo.
+type FileInfo struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+}
+
+func newFileInfo(info os.FileInfo) FileInfo {
+	return FileInfo{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+	}
+}
+
+type PathRequest struct {
+	Path string
+}
+
+type StatResponse struct {
+	Info FileInfo
+}
+
+// ReadDirRequest lists Path. Clients that cached a listing pass its ETag as IfNoneMatch, and get NotModified back
+// instead of the entries when it is still current. It is compatible with the PathRequest older clients send.
+type ReadDirRequest struct {
+	Path        string
+	IfNoneMatch string
+}
+
+type ReadDirResponse struct {
+	Entries []FileInfo
+	// ETag identifies the listing, see gitfs.ListingTag.
+	ETag string
+	// NotModified is set in place of Entries when the listing still has the ETag the request passed.
+	NotModified bool
+}
+
+// MaxReadLength is the most a ReadRequest may ask for, so a single request can't make the server allocate an
+// arbitrary amount of memory. The client splits larger reads.
+const MaxReadLength = 1 << 20
+
+// MaxOpenHandles is how many files a single connection may hold open, so clients that never close their files can't
+// exhaust the server.
+const MaxOpenHandles = 1024
+
+// ErrTooManyOpenFiles is returned by Open when a connection already holds MaxOpenHandles files open.
+var ErrTooManyOpenFiles = errors.New("too many open files")
+
+// OpenRequest opens Path so it can be read through a handle, without the server opening it again for every read.
+type OpenRequest struct {
+	Path string
+}
+
+type OpenResponse struct {
+	// Handle names the opened file in ReadRequest and CloseRequest. It is never 0.
+	Handle uint64
+	Info   FileInfo
+}
+
+// CloseRequest closes a file opened by OpenRequest.
+type CloseRequest struct {
+	Handle uint64
+}
+
+type CloseResponse struct{}
+
+// ReadRequest reads up to Length bytes starting at Offset of the file opened as Handle, or of Path if Handle is 0 as
+// older clients send. Length may be at most MaxReadLength.
+type ReadRequest struct {
+	Path   string
+	Handle uint64
+	Offset int64
+	Length int
+}
+
+type ReadResponse struct {
+	Data []byte
+	// EOF is set when the read reached the end of the file.
+	EOF bool
+}
+
+type ReadlinkResponse struct {
+	Target string
+}
+
+type ListRefsRequest struct{}
+
+type ListRefsResponse struct {
+	Branches []string
+	Tags     []string
+}
+
+// wireErrors are the sentinel errors that survive a round trip through the protocol. net/rpc only transmits the
+// text of an error so anything not in this list reaches the client as an opaque rpc.ServerError.
+var wireErrors = []error{
+	fs.ErrNotExist,
+	fs.ErrInvalid,
+	fs.ErrPermission,
+	fs.ErrClosed,
+	ErrTooManyOpenFiles,
+	billy.ErrReadOnly,
+	billy.ErrNotSupported,
+	gitfs.ErrEscapesChroot,
+	// ErrSubmodule wraps ErrIsDirectory so it has to be matched first.
+	gitfs.ErrSubmodule,
+	gitfs.ErrIsDirectory,
+	gitfs.ErrNotDirectory,
+}
+
+func toWireError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, sentinel := range wireErrors {
+		if errors.Is(err, sentinel) {
+			return sentinel
+		}
+	}
+	return err
+}
+
+func fromWireError(err error) error {
+	serverError, ok := err.(rpc.ServerError)
+	if !ok {
+		return err
+	}
+	for _, sentinel := range wireErrors {
+		if string(serverError) == sentinel.Error() {
+			return sentinel
+		}
+	}
+	return err
+}
@@ -0,0 +1,254 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"io"
+	iofs "io/fs"
+	"net"
+	"net/rpc"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestClient(t *testing.T, backing billy.Filesystem) *Client {
+	server, err := NewServer(backing, nil)
+	if err != nil {
+		t.Fatalf("NewServer() failed: %v", err)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+
+	client := NewClient(rpc.NewClient(clientConn))
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return client
+}
+
+func TestRemote(t *testing.T) {
+	backing := memfs.New()
+	if err := util.WriteFile(backing, "real.txt", []byte("Hello World\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteFile(backing, "test/nested.txt", []byte("Nested file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t, backing)
+	fs := NewFileSystem(client)
+
+	t.Run("stat", func(t *testing.T) {
+		info, err := fs.Stat("real.txt")
+		if err != nil {
+			t.Fatalf("Stat(real.txt) failed: %v", err)
+		}
+		if info.Name() != "real.txt" || info.Size() != 12 {
+			t.Fatalf("Stat(real.txt) returned wrong info: %s, %d", info.Name(), info.Size())
+		}
+
+		_, err = fs.Stat("missing.txt")
+		if err != os.ErrNotExist {
+			t.Fatalf("Stat(missing.txt) should return os.ErrNotExist, got: %v", err)
+		}
+	})
+
+	t.Run("reading", func(t *testing.T) {
+		file, err := fs.Open("real.txt")
+		if err != nil {
+			t.Fatalf("Open(real.txt) failed: %v", err)
+		}
+		data, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("io.ReadAll(file) failed: %v", err)
+		}
+		if string(data) != "Hello World\n" {
+			t.Fatalf("file.Read() on real.txt produced incorrect data: %q", data)
+		}
+
+		buffer := make([]byte, 5)
+		n, err := file.ReadAt(buffer, 6)
+		if err != nil || n != 5 || string(buffer) != "World" {
+			t.Fatalf("file.ReadAt(6) returned %d, %q, %v", n, buffer[:n], err)
+		}
+	})
+
+	t.Run("large reads", func(t *testing.T) {
+		large := bytes.Repeat([]byte("0123456789abcdef"), (2*MaxReadLength+MaxReadLength/2)/16)
+		if err := util.WriteFile(backing, "large.bin", large, 0644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := fs.Open("large.bin")
+		if err != nil {
+			t.Fatalf("Open(large.bin) failed: %v", err)
+		}
+		buffer := make([]byte, len(large)+1)
+		n, err := file.ReadAt(buffer, 0)
+		if err != io.EOF || !bytes.Equal(buffer[:n], large) {
+			t.Fatalf("file.ReadAt() of more than MaxReadLength returned %d bytes, %v", n, err)
+		}
+
+		service := &Service{fs: backing}
+		var response ReadResponse
+		if err := service.Read(ReadRequest{Path: "large.bin", Length: MaxReadLength + 1}, &response); err == nil {
+			t.Fatalf("Read() of more than MaxReadLength should fail")
+		}
+	})
+
+	t.Run("listing directories", func(t *testing.T) {
+		chroot, err := fs.Chroot("test")
+		if err != nil {
+			t.Fatalf("Chroot(test) failed: %v", err)
+		}
+		entries, err := chroot.ReadDir(".")
+		if err != nil {
+			t.Fatalf("ReadDir(.) failed: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "nested.txt" {
+			t.Fatalf("ReadDir(.) in test/ returned wrong entries: %v", entries)
+		}
+	})
+
+	t.Run("mutators", func(t *testing.T) {
+		if _, err := fs.Create("something.txt"); err != billy.ErrReadOnly {
+			t.Fatalf("Was allowed to create something.txt")
+		}
+	})
+
+	t.Run("list refs without git", func(t *testing.T) {
+		if _, _, err := client.ListRefs(); err == nil {
+			t.Fatal("ListRefs() should fail when the server has no repository")
+		}
+	})
+}
+
+func TestReadDirETag(t *testing.T) {
+	// memfs makes up a new modification time every time it is asked.
+	backing := osfs.New(t.TempDir())
+	if err := util.WriteFile(backing, "real.txt", []byte("Hello World\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	service := &Service{fs: backing}
+
+	var listed ReadDirResponse
+	if err := service.ReadDir(ReadDirRequest{Path: "."}, &listed); err != nil {
+		t.Fatalf("ReadDir(.) failed: %v", err)
+	}
+	if listed.ETag == "" || listed.NotModified || len(listed.Entries) != 1 {
+		t.Fatalf("ReadDir(.) returned %+v", listed)
+	}
+
+	var revalidated ReadDirResponse
+	if err := service.ReadDir(ReadDirRequest{Path: ".", IfNoneMatch: listed.ETag}, &revalidated); err != nil {
+		t.Fatalf("ReadDir(.) failed: %v", err)
+	}
+	if !revalidated.NotModified || len(revalidated.Entries) != 0 || revalidated.ETag != listed.ETag {
+		t.Fatalf("revalidating an unchanged listing returned %+v", revalidated)
+	}
+
+	client := newTestClient(t, backing)
+	fs := NewFileSystem(client)
+	if entries, err := fs.ReadDir("."); err != nil || len(entries) != 1 {
+		t.Fatalf("ReadDir(.) returned %v, %v", entries, err)
+	}
+	if err := util.WriteFile(backing, "new.txt", []byte("New\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var changed ReadDirResponse
+	if err := service.ReadDir(ReadDirRequest{Path: ".", IfNoneMatch: listed.ETag}, &changed); err != nil {
+		t.Fatalf("ReadDir(.) failed: %v", err)
+	}
+	if changed.NotModified || len(changed.Entries) != 2 || changed.ETag == listed.ETag {
+		t.Fatalf("revalidating a changed listing returned %+v", changed)
+	}
+	if entries, err := fs.ReadDir("."); err != nil || len(entries) != 2 {
+		t.Fatalf("ReadDir(.) after adding a file returned %v, %v", entries, err)
+	}
+	if entries, err := fs.ReadDir("."); err != nil || len(entries) != 2 {
+		t.Fatalf("ReadDir(.) of a cached listing returned %v, %v", entries, err)
+	}
+}
+
+// countingFileSystem counts how many times files are opened.
+type countingFileSystem struct {
+	billy.Filesystem
+	opens *int64
+}
+
+func (c countingFileSystem) Open(filename string) (billy.File, error) {
+	atomic.AddInt64(c.opens, 1)
+	return c.Filesystem.Open(filename)
+}
+
+func TestOpenHandles(t *testing.T) {
+	backing := memfs.New()
+	large := bytes.Repeat([]byte("0123456789abcdef"), (2*MaxReadLength+MaxReadLength/2)/16)
+	if err := util.WriteFile(backing, "large.bin", large, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var opens int64
+	server, service, err := newServer(countingFileSystem{Filesystem: backing, opens: &opens}, nil)
+	if err != nil {
+		t.Fatalf("newServer() failed: %v", err)
+	}
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client := NewClient(rpc.NewClient(clientConn))
+	t.Cleanup(func() {
+		client.Close()
+	})
+	fs := NewFileSystem(client)
+	openHandles := func() int {
+		service.mu.Lock()
+		defer service.mu.Unlock()
+		return len(service.open)
+	}
+
+	file, err := fs.Open("large.bin")
+	if err != nil {
+		t.Fatalf("Open(large.bin) failed: %v", err)
+	}
+	contents, err := io.ReadAll(file)
+	if err != nil || !bytes.Equal(contents, large) {
+		t.Fatalf("io.ReadAll(large.bin) returned %d bytes, %v", len(contents), err)
+	}
+	if opens := atomic.LoadInt64(&opens); opens != 1 {
+		t.Fatalf("reading large.bin opened it %d times on the server, expected once", opens)
+	}
+	if open := openHandles(); open != 1 {
+		t.Fatalf("the server holds %d files open, expected 1", open)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("closing a file twice failed: %v", err)
+	}
+	if open := openHandles(); open != 0 {
+		t.Fatalf("the server holds %d files open after they were closed", open)
+	}
+	if _, err := file.ReadAt(make([]byte, 1), 0); !errors.Is(err, iofs.ErrClosed) {
+		t.Fatalf("ReadAt() after Close() returned %v, expected fs.ErrClosed", err)
+	}
+
+	for i := 0; i < MaxOpenHandles; i++ {
+		if _, err := fs.Open("large.bin"); err != nil {
+			t.Fatalf("opening large.bin %d times failed: %v", i+1, err)
+		}
+	}
+	if _, err := fs.Open("large.bin"); !errors.Is(err, ErrTooManyOpenFiles) {
+		t.Fatalf("opening more than MaxOpenHandles files returned %v, expected ErrTooManyOpenFiles", err)
+	}
+	service.closeAll()
+	if open := openHandles(); open != 0 {
+		t.Fatalf("the server holds %d files open after closing them all", open)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	gitfs "github.com/gravypod/gitfs/pkg"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/rpc"
+	"sync"
+)
+
+var ErrNoGit = errors.New("server was not configured with a git repository")
+
+// Service is the receiver registered with net/rpc. All methods follow the net/rpc calling convention.
+type Service struct {
+	fs  billy.Filesystem
+	git gitfs.Git
+
+	mu sync.Mutex
+	// open are the files opened by Open that haven't been closed yet, by their handle.
+	open       map[uint64]billy.File
+	nextHandle uint64
+}
+
+func newService(fs billy.Filesystem, git gitfs.Git) *Service {
+	return &Service{fs: fs, git: git, open: map[uint64]billy.File{}}
+}
+
+// NewServer creates an rpc.Server exposing fs. git is used to answer ListRefs and may be nil. Files opened through the
+// server stay open until the client closes them, so each connection should get its own server, as Serve does.
+func NewServer(fs billy.Filesystem, git gitfs.Git) (*rpc.Server, error) {
+	server, _, err := newServer(fs, git)
+	return server, err
+}
+
+func newServer(fs billy.Filesystem, git gitfs.Git) (*rpc.Server, *Service, error) {
+	server := rpc.NewServer()
+	service := newService(fs, git)
+	err := server.RegisterName(ServiceName, service)
+	if err != nil {
+		return nil, nil, err
+	}
+	return server, service, nil
+}
+
+// Serve accepts connections on listener until it is closed. Files a connection left open are closed once it ends.
+func Serve(listener net.Listener, fs billy.Filesystem, git gitfs.Git) error {
+	log.Printf("Remote server started at %s\n", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		server, service, err := newServer(fs, git)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		go func() {
+			server.ServeConn(conn)
+			service.closeAll()
+		}()
+	}
+}
+
+// closeAll closes every file that is still open.
+func (s *Service) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for handle, file := range s.open {
+		file.Close()
+		delete(s.open, handle)
+	}
+}
+
+func (s *Service) Stat(request PathRequest, response *StatResponse) error {
+	info, err := s.fs.Stat(request.Path)
+	if err != nil {
+		return toWireError(err)
+	}
+	response.Info = newFileInfo(info)
+	return nil
+}
+
+func (s *Service) ReadDir(request ReadDirRequest, response *ReadDirResponse) error {
+	infos, err := s.fs.ReadDir(request.Path)
+	if err != nil {
+		return toWireError(err)
+	}
+	response.ETag = gitfs.ListingTag(infos)
+	if request.IfNoneMatch == response.ETag {
+		response.NotModified = true
+		return nil
+	}
+	response.Entries = make([]FileInfo, 0, len(infos))
+	for _, info := range infos {
+		response.Entries = append(response.Entries, newFileInfo(info))
+	}
+	return nil
+}
+
+func (s *Service) Open(request OpenRequest, response *OpenResponse) error {
+	s.mu.Lock()
+	full := len(s.open) >= MaxOpenHandles
+	s.mu.Unlock()
+	if full {
+		return ErrTooManyOpenFiles
+	}
+
+	file, err := s.fs.Open(request.Path)
+	if err != nil {
+		return toWireError(err)
+	}
+	info, err := s.fs.Stat(request.Path)
+	if err != nil {
+		file.Close()
+		return toWireError(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextHandle++
+	s.open[s.nextHandle] = file
+	response.Handle = s.nextHandle
+	response.Info = newFileInfo(info)
+	return nil
+}
+
+func (s *Service) Close(request CloseRequest, response *CloseResponse) error {
+	_ = response
+	s.mu.Lock()
+	file, ok := s.open[request.Handle]
+	delete(s.open, request.Handle)
+	s.mu.Unlock()
+	if !ok {
+		return fs.ErrClosed
+	}
+	return toWireError(file.Close())
+}
+
+func (s *Service) Read(request ReadRequest, response *ReadResponse) error {
+	if request.Length < 0 || request.Length > MaxReadLength || request.Offset < 0 {
+		return fs.ErrInvalid
+	}
+
+	var file billy.File
+	if request.Handle != 0 {
+		s.mu.Lock()
+		opened, ok := s.open[request.Handle]
+		s.mu.Unlock()
+		if !ok {
+			return fs.ErrClosed
+		}
+		file = opened
+	} else {
+		opened, err := s.fs.Open(request.Path)
+		if err != nil {
+			return toWireError(err)
+		}
+		defer opened.Close()
+		file = opened
+	}
+
+	buffer := make([]byte, request.Length)
+	n, err := file.ReadAt(buffer, request.Offset)
+	if err != nil && err != io.EOF {
+		return toWireError(err)
+	}
+	response.Data = buffer[:n]
+	response.EOF = err == io.EOF
+	return nil
+}
+
+func (s *Service) Readlink(request PathRequest, response *ReadlinkResponse) error {
+	target, err := s.fs.Readlink(request.Path)
+	if err != nil {
+		return toWireError(err)
+	}
+	response.Target = target
+	return nil
+}
+
+func (s *Service) ListRefs(request ListRefsRequest, response *ListRefsResponse) error {
+	_ = request
+	if s.git == nil {
+		return ErrNoGit
+	}
+
+	err := s.git.ListBranches(func(branch string) error {
+		response.Branches = append(response.Branches, branch)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.git.ListTags(func(tag string) error {
+		response.Tags = append(response.Tags, tag)
+		return nil
+	})
+}
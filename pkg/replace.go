@@ -0,0 +1,104 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+// WithReplaceObjects decides whether git reads the objects refs/replace/ swaps in for others, which it does by
+// default. Disable it to serve history exactly as it was committed.
+func WithReplaceObjects(enabled bool) CliOption {
+	return func(options *cliOptions) {
+		options.noReplaceObjects = !enabled
+		if !enabled {
+			options.Environment = append(options.Environment, "GIT_NO_REPLACE_OBJECTS=1")
+		}
+	}
+}
+
+// replacedObjects are the objects git reads something else in place of because of refs/replace/. Replacing an
+// object doesn't change its name, so anything cached by name has to skip them. They are listed once per client.
+type replacedObjects struct {
+	list func(handler func(original, replacement string) error) error
+
+	once    sync.Once
+	objects map[string]bool
+	// failed is set if the replacements couldn't be listed, in which case every object might be replaced.
+	failed bool
+}
+
+func newReplacedObjects(list func(handler func(original, replacement string) error) error) *replacedObjects {
+	return &replacedObjects{list: list}
+}
+
+func (r *replacedObjects) load() {
+	r.once.Do(func() {
+		r.objects = map[string]bool{}
+		err := r.list(func(original, replacement string) error {
+			r.objects[original] = true
+			return nil
+		})
+		if err != nil {
+			log.Printf("failed to list replace refs, not caching objects: %v", err)
+			r.failed = true
+		}
+	})
+}
+
+// contains reports whether hash may be replaced. A nil *replacedObjects replaces nothing.
+func (r *replacedObjects) contains(hash string) bool {
+	if r == nil {
+		return false
+	}
+	r.load()
+	return r.failed || r.objects[hash]
+}
+
+// any reports whether any object may be replaced.
+func (r *replacedObjects) any() bool {
+	if r == nil {
+		return false
+	}
+	r.load()
+	return r.failed || len(r.objects) > 0
+}
+
+// cacheable reports whether key names something that can be cached. Blobs and sizes of replaced objects can't be, and
+// neither can listings once anything is replaced, since any tree or commit they pass through might be.
+func (g cliGit) cacheable(key string) bool {
+	switch namespace := cacheNamespace(key); namespace {
+	case "blob", "size":
+		return !g.replaced.contains(strings.TrimPrefix(key, namespace+"/"))
+	default:
+		return !g.replaced.any()
+	}
+}
+
+// linkedObjects lists the object directories the repository borrows from and counts the replace refs git honors, for
+// checking that forks and repositories with rewritten history are served from where they should be.
+func (g cliGit) linkedObjects() (alternates []string, replaceRefs int, err error) {
+	alternates, err = g.cli.Alternates()
+	if err != nil || g.replaced == nil {
+		return alternates, 0, err
+	}
+	err = g.cli.ReplaceRefs(func(original, replacement string) error {
+		replaceRefs++
+		return nil
+	})
+	return alternates, replaceRefs, err
+}
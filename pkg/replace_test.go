@@ -0,0 +1,130 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"encoding/json"
+	"github.com/go-git/go-billy/v5"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAlternatesAndReplaceObjects(t *testing.T) {
+	tmp := t.TempDir()
+	repository, err := runPlaybook("alternates", tmp)
+	if err != nil {
+		t.Fatalf("playbook 'alternates' failed: %v", err)
+	}
+	master := BranchRef("master")
+	read := func(t *testing.T, fs billy.Filesystem, filename string) string {
+		file, err := fs.Open(filename)
+		if err != nil {
+			t.Fatalf("Open(%s) failed: %v", filename, err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", filename, err)
+		}
+		return string(contents)
+	}
+	newGit := func(t *testing.T, options ...CliOption) Git {
+		git, err := NewCliGit(repository, options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return git
+	}
+
+	t.Run("alternates", func(t *testing.T) {
+		fs := NewReferenceFileSystem(newGit(t), master)
+		if contents := read(t, fs, "shared.txt"); contents != "Only stored in upstream.\n" {
+			t.Fatalf("shared.txt contained %q", contents)
+		}
+	})
+
+	t.Run("replace objects", func(t *testing.T) {
+		fs := NewReferenceFileSystem(newGit(t), master)
+		if contents := read(t, fs, "fork.txt"); contents != "Replaced in the fork.\n" {
+			t.Fatalf("fork.txt contained %q, expected the replacement", contents)
+		}
+		info, err := fs.Stat("fork.txt")
+		if err != nil {
+			t.Fatalf("Stat(fork.txt) failed: %v", err)
+		}
+		if info.Size() != int64(len("Replaced in the fork.\n")) {
+			t.Fatalf("Stat(fork.txt) reported the size of the original: %d", info.Size())
+		}
+
+		fs = NewReferenceFileSystem(newGit(t, WithReplaceObjects(false)), master)
+		if contents := read(t, fs, "fork.txt"); contents != "Committed to the fork.\n" {
+			t.Fatalf("fork.txt contained %q without replace objects, expected the original", contents)
+		}
+	})
+
+	t.Run("shared cache", func(t *testing.T) {
+		// The original and replacement have the same name, so clients that disagree on replacing it can't share it
+		// through a cache.
+		cache := NewMemoryCache(1 << 20)
+		original := NewReferenceFileSystem(newGit(t, WithCache(cache), WithReplaceObjects(false)), master)
+		replaced := NewReferenceFileSystem(newGit(t, WithCache(cache)), master)
+		for i := 0; i < 2; i++ {
+			if contents := read(t, original, "fork.txt"); contents != "Committed to the fork.\n" {
+				t.Fatalf("fork.txt contained %q without replace objects, expected the original", contents)
+			}
+			if contents := read(t, replaced, "fork.txt"); contents != "Replaced in the fork.\n" {
+				t.Fatalf("fork.txt contained %q, expected the replacement", contents)
+			}
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		git := newGit(t)
+		fs := NewIntrospectionFileSystem(NewReferenceFileSystem(git, master), git, master)
+		var stats repositoryStats
+		if err := json.Unmarshal([]byte(read(t, fs, ".gitfs/stats.json")), &stats); err != nil {
+			t.Fatalf(".gitfs/stats.json is not valid JSON: %v", err)
+		}
+		upstream, err := filepath.Abs(filepath.Join(tmp, "upstream", ".git", "objects"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(stats.Alternates, []string{upstream}) || stats.ReplaceRefs != 1 {
+			t.Fatalf(".gitfs/stats.json reported alternates %v and %d replace refs", stats.Alternates, stats.ReplaceRefs)
+		}
+	})
+
+	t.Run("git objects", func(t *testing.T) {
+		fs := NewGitObjectsFileSystem(NewReferenceFileSystem(newGit(t), master), repository)
+		contents := read(t, fs, ".gitobjects/objects/info/alternates")
+		upstream := filepath.Join(tmp, "upstream", ".git", "objects")
+		if contents != upstream+"\n" {
+			t.Fatalf(".gitobjects/objects/info/alternates contained %q, expected %q", contents, upstream+"\n")
+		}
+		info, err := fs.Stat(".gitobjects/objects/info/alternates")
+		if err != nil {
+			t.Fatalf("Stat(.gitobjects/objects/info/alternates) failed: %v", err)
+		}
+		if info.Size() != int64(len(contents)) {
+			t.Fatalf("Stat() reported %d bytes but read %d", info.Size(), len(contents))
+		}
+		if _, err := os.Stat(upstream); err != nil {
+			t.Fatalf("rewritten alternate does not exist: %v", err)
+		}
+	})
+}
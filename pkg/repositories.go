@@ -0,0 +1,522 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/helper/chroot"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrInvalidRepositoryName is returned when adding a repository under a name that can't be a directory at the root of
+// a RepositoriesFileSystem.
+var ErrInvalidRepositoryName = errors.New("repository names must be a single, non-empty path element")
+
+// RepositoryStats count the calls made to one repository of a RepositoriesFileSystem, labelled with its name.
+type RepositoryStats struct {
+	Name  string `json:"name"`
+	Calls int64  `json:"calls"`
+	// Failures counts calls that failed for any reason other than the path not existing.
+	Failures int64 `json:"failures"`
+	// InFlight counts calls being served or waiting for the repository to have room for them.
+	InFlight int64 `json:"in_flight"`
+}
+
+// RepositoryStatus describes a repository a multi-repository mount was asked to serve.
+type RepositoryStatus struct {
+	RepositoryStats
+	GitDir string `json:"git_dir"`
+	// Error is why the repository couldn't be opened. Repositories that failed aren't served.
+	Error string `json:"error,omitempty"`
+}
+
+// servedRepository is a repository of a RepositoriesFileSystem.
+type servedRepository struct {
+	// The counters come first so they are aligned for atomic operations on 32-bit platforms.
+	calls, failures, inFlight int64
+
+	name string
+	fs   billy.Filesystem
+	// slots holds a value for every call being served when the repository's concurrency is limited.
+	slots chan struct{}
+}
+
+// call runs f as a call to the repository, waiting for room if too many are already being served.
+func (r *servedRepository) call(f func() error) error {
+	atomic.AddInt64(&r.calls, 1)
+	atomic.AddInt64(&r.inFlight, 1)
+	defer atomic.AddInt64(&r.inFlight, -1)
+	if r.slots != nil {
+		r.slots <- struct{}{}
+		defer func() { <-r.slots }()
+	}
+
+	err := f()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		atomic.AddInt64(&r.failures, 1)
+	}
+	return err
+}
+
+func (r *servedRepository) stats() RepositoryStats {
+	return RepositoryStats{
+		Name:     r.name,
+		Calls:    atomic.LoadInt64(&r.calls),
+		Failures: atomic.LoadInt64(&r.failures),
+		InFlight: atomic.LoadInt64(&r.inFlight),
+	}
+}
+
+// repositoryInfo describes the directory a repository is served in.
+type repositoryInfo struct {
+	name string
+}
+
+func (i repositoryInfo) Name() string {
+	return i.name
+}
+
+func (i repositoryInfo) Size() int64 {
+	return 0
+}
+
+func (i repositoryInfo) Mode() fs.FileMode {
+	return os.ModeDir | 0555
+}
+
+func (i repositoryInfo) ModTime() time.Time {
+	return time.Unix(0, 0)
+}
+
+func (i repositoryInfo) IsDir() bool {
+	return true
+}
+
+func (i repositoryInfo) Sys() interface{} {
+	return nil
+}
+
+// RepositoriesFileSystem serves several repositories side by side, each in a directory at its root named after it.
+// Every repository has a filesystem of its own, with its own git client, caches, and rate limits, so one that is
+// corrupt or slow only fails or slows down the paths under it. Repositories can be added and removed while it is
+// being served.
+type RepositoriesFileSystem struct {
+	// concurrency is how many calls each repository may serve at once. 0 is unlimited.
+	concurrency int
+
+	mu           sync.RWMutex
+	repositories map[string]*servedRepository
+}
+
+// NewRepositoriesFileSystem serves no repositories until they are added. Each repository serves at most concurrency
+// calls at once, so one that hangs can't pile up an unbounded number of them. 0 is unlimited.
+func NewRepositoriesFileSystem(concurrency int) *RepositoriesFileSystem {
+	return &RepositoriesFileSystem{
+		concurrency:  concurrency,
+		repositories: map[string]*servedRepository{},
+	}
+}
+
+// ValidRepositoryName returns ErrInvalidRepositoryName if name can't be added to a RepositoriesFileSystem.
+func ValidRepositoryName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, filepath.Separator) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidRepositoryName, name)
+	}
+	return nil
+}
+
+// AddRepository serves fs at /name, replacing and returning the filesystem served there before, if any.
+func (s *RepositoriesFileSystem) AddRepository(name string, fs billy.Filesystem) (billy.Filesystem, error) {
+	if err := ValidRepositoryName(name); err != nil {
+		return nil, err
+	}
+	repository := &servedRepository{name: name, fs: fs}
+	if s.concurrency > 0 {
+		repository.slots = make(chan struct{}, s.concurrency)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.repositories[name]
+	s.repositories[name] = repository
+	if previous == nil {
+		return nil, nil
+	}
+	return previous.fs, nil
+}
+
+// RemoveRepository stops serving the repository at /name and returns its filesystem, or nil if there was none. Calls
+// already in flight finish against it.
+func (s *RepositoriesFileSystem) RemoveRepository(name string) billy.Filesystem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repository, ok := s.repositories[name]
+	if !ok {
+		return nil
+	}
+	delete(s.repositories, name)
+	return repository.fs
+}
+
+// Names lists the repositories being served, sorted.
+func (s *RepositoriesFileSystem) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.repositories))
+	for name := range s.repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stats counts the calls made to each repository being served, sorted by name.
+func (s *RepositoriesFileSystem) Stats() []RepositoryStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := make([]RepositoryStats, 0, len(s.repositories))
+	for _, repository := range s.repositories {
+		stats = append(stats, repository.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// route finds the repository filename is in and the path of filename within it. The repository is nil for the root.
+func (s *RepositoriesFileSystem) route(op, filename string) (*servedRepository, string, error) {
+	root := RootGitPath()
+	resolved, err := root.Resolve(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	if resolved.IsRoot() {
+		return nil, "", nil
+	}
+
+	s.mu.RLock()
+	repository, ok := s.repositories[resolved.Path[0]]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, "", &fs.PathError{Op: op, Path: filename, Err: fs.ErrNotExist}
+	}
+	return repository, SeparatorString + strings.Join(resolved.Path[1:], SeparatorString), nil
+}
+
+// billy.Basic type implementation
+
+func (s *RepositoriesFileSystem) Create(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (s *RepositoriesFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s *RepositoriesFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	repository, path, err := s.route("open", filename)
+	if err != nil {
+		return nil, err
+	}
+	if repository == nil {
+		return nil, ErrIsDirectory
+	}
+	var file billy.File
+	err = repository.call(func() (err error) {
+		file, err = repository.fs.OpenFile(path, flag, perm)
+		return err
+	})
+	return file, err
+}
+
+func (s *RepositoriesFileSystem) Stat(filename string) (os.FileInfo, error) {
+	return s.stat("stat", filename, func(fs billy.Filesystem, path string) (os.FileInfo, error) {
+		return fs.Stat(path)
+	})
+}
+
+// stat describes filename with stat, or the directory the repository is in without asking it, so listing and
+// entering a repository never depend on it being healthy.
+func (s *RepositoriesFileSystem) stat(op, filename string, stat func(fs billy.Filesystem, path string) (os.FileInfo, error)) (os.FileInfo, error) {
+	repository, path, err := s.route(op, filename)
+	if err != nil {
+		return nil, err
+	}
+	if repository == nil {
+		return repositoryInfo{name: SeparatorString}, nil
+	}
+	if path == SeparatorString {
+		return repositoryInfo{name: repository.name}, nil
+	}
+	var info os.FileInfo
+	err = repository.call(func() (err error) {
+		info, err = stat(repository.fs, path)
+		return err
+	})
+	return info, err
+}
+
+func (s *RepositoriesFileSystem) Rename(oldpath, newpath string) error {
+	oldRepository, oldPath, err := s.route("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	newRepository, newPath, err := s.route("rename", newpath)
+	if err != nil {
+		return err
+	}
+	if oldRepository == nil || newRepository == nil || oldPath == SeparatorString || newPath == SeparatorString {
+		return billy.ErrReadOnly
+	}
+	if oldRepository != newRepository {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+	return oldRepository.call(func() error {
+		return oldRepository.fs.Rename(oldPath, newPath)
+	})
+}
+
+func (s *RepositoriesFileSystem) Remove(filename string) error {
+	return s.change("remove", filename, func(fs billy.Filesystem, path string) error {
+		return fs.Remove(path)
+	})
+}
+
+// change runs f against the repository filename is in. The root and the directories repositories are served in are
+// read-only.
+func (s *RepositoriesFileSystem) change(op, filename string, f func(fs billy.Filesystem, path string) error) error {
+	root := RootGitPath()
+	if resolved, err := root.Resolve(filename); err == nil && len(resolved.Path) <= 1 {
+		return billy.ErrReadOnly
+	}
+	repository, path, err := s.route(op, filename)
+	if err != nil {
+		return err
+	}
+	return repository.call(func() error {
+		return f(repository.fs, path)
+	})
+}
+
+func (s *RepositoriesFileSystem) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// billy.TempFile type implementation
+
+func (s *RepositoriesFileSystem) TempFile(dir, prefix string) (billy.File, error) {
+	repository, path, err := s.route("tempfile", dir)
+	if err != nil {
+		return nil, err
+	}
+	if repository == nil {
+		return nil, billy.ErrReadOnly
+	}
+	var file billy.File
+	err = repository.call(func() (err error) {
+		file, err = repository.fs.TempFile(path, prefix)
+		return err
+	})
+	return file, err
+}
+
+// billy.Dir type implementation
+
+func (s *RepositoriesFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	repository, inner, err := s.route("readdir", path)
+	if err != nil {
+		return nil, err
+	}
+	if repository == nil {
+		var files []os.FileInfo
+		for _, name := range s.Names() {
+			files = append(files, repositoryInfo{name: name})
+		}
+		return files, nil
+	}
+	var files []os.FileInfo
+	err = repository.call(func() (err error) {
+		files, err = repository.fs.ReadDir(inner)
+		return err
+	})
+	return files, err
+}
+
+// ReadDirNames uses the ReadDirNames of the repository's filesystem if it has one.
+func (s *RepositoriesFileSystem) ReadDirNames(path string) ([]string, error) {
+	repository, inner, err := s.route("readdir", path)
+	if err != nil {
+		return nil, err
+	}
+	if repository == nil {
+		return s.Names(), nil
+	}
+	var names []string
+	err = repository.call(func() (err error) {
+		names, err = ReadDirNames(repository.fs, inner)
+		return err
+	})
+	return names, err
+}
+
+func (s *RepositoriesFileSystem) MkdirAll(filename string, perm os.FileMode) error {
+	return s.change("mkdir", filename, func(fs billy.Filesystem, path string) error {
+		return fs.MkdirAll(path, perm)
+	})
+}
+
+// billy.Symlink type implementation
+
+func (s *RepositoriesFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	return s.stat("lstat", filename, func(fs billy.Filesystem, path string) (os.FileInfo, error) {
+		return fs.Lstat(path)
+	})
+}
+
+func (s *RepositoriesFileSystem) Symlink(target, link string) error {
+	return s.change("symlink", link, func(fs billy.Filesystem, path string) error {
+		return fs.Symlink(target, path)
+	})
+}
+
+func (s *RepositoriesFileSystem) Readlink(link string) (string, error) {
+	repository, path, err := s.route("readlink", link)
+	if err != nil {
+		return "", err
+	}
+	if repository == nil || path == SeparatorString {
+		return "", &fs.PathError{Op: "readlink", Path: link, Err: fs.ErrInvalid}
+	}
+	var target string
+	err = repository.call(func() (err error) {
+		target, err = repository.fs.Readlink(path)
+		return err
+	})
+	return target, err
+}
+
+// billy.Chroot type implementation
+
+func (s *RepositoriesFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	return chroot.New(s, path), nil
+}
+
+func (s *RepositoriesFileSystem) Root() string {
+	return "."
+}
+
+// billy.Change type implementation
+
+func (s *RepositoriesFileSystem) Chmod(name string, mode os.FileMode) error {
+	return s.change("chmod", name, func(fs billy.Filesystem, path string) error {
+		change, ok := fs.(billy.Change)
+		if !ok {
+			return billy.ErrNotSupported
+		}
+		return change.Chmod(path, mode)
+	})
+}
+
+func (s *RepositoriesFileSystem) Lchown(name string, uid, gid int) error {
+	return s.change("lchown", name, func(fs billy.Filesystem, path string) error {
+		change, ok := fs.(billy.Change)
+		if !ok {
+			return billy.ErrNotSupported
+		}
+		return change.Lchown(path, uid, gid)
+	})
+}
+
+func (s *RepositoriesFileSystem) Chown(name string, uid, gid int) error {
+	return s.change("chown", name, func(fs billy.Filesystem, path string) error {
+		change, ok := fs.(billy.Change)
+		if !ok {
+			return billy.ErrNotSupported
+		}
+		return change.Chown(path, uid, gid)
+	})
+}
+
+func (s *RepositoriesFileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return s.change("chtimes", name, func(fs billy.Filesystem, path string) error {
+		change, ok := fs.(billy.Change)
+		if !ok {
+			return billy.ErrNotSupported
+		}
+		return change.Chtimes(path, atime, mtime)
+	})
+}
+
+// billy.Capable
+
+// Capabilities are those of every repository combined, and only reading and seeking when there are none.
+func (s *RepositoriesFileSystem) Capabilities() billy.Capability {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	capabilities := billy.ReadCapability | billy.SeekCapability
+	for _, repository := range s.repositories {
+		capabilities |= billy.Capabilities(repository.fs)
+	}
+	return capabilities
+}
+
+// IsBareRepository reports whether directory looks like a bare git repository.
+func IsBareRepository(directory string) bool {
+	for _, name := range []string{"objects", "refs"} {
+		if info, err := os.Stat(filepath.Join(directory, name)); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	info, err := os.Stat(filepath.Join(directory, "HEAD"))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// FindRepositories maps the names of the bare repositories directly inside of directory, named like RepositoryName,
+// to their paths. When two would get the same name, like repo and repo.git, the first one sorted by path wins.
+func FindRepositories(directory string) (map[string]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	repositories := map[string]string{}
+	for _, entry := range entries {
+		path := filepath.Join(directory, entry.Name())
+		if !IsBareRepository(path) {
+			continue
+		}
+		name := RepositoryName(path)
+		if ValidRepositoryName(name) != nil {
+			continue
+		}
+		if existing, ok := repositories[name]; ok {
+			log.Printf("not serving %s since %s is already served as %s", path, existing, name)
+			continue
+		}
+		repositories[name] = path
+	}
+	return repositories, nil
+}
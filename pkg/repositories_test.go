@@ -0,0 +1,221 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// blockingFileSystem holds every Stat until release is closed.
+type blockingFileSystem struct {
+	billy.Filesystem
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s blockingFileSystem) Stat(filename string) (os.FileInfo, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return s.Filesystem.Stat(filename)
+}
+
+// failingFileSystem fails every read like a repository git can't read anything from.
+type failingFileSystem struct {
+	billy.Filesystem
+}
+
+func (s failingFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: filename, Err: syscall.EIO}
+}
+
+func (s failingFileSystem) Stat(filename string) (os.FileInfo, error) {
+	return nil, &fs.PathError{Op: "stat", Path: filename, Err: syscall.EIO}
+}
+
+func TestRepositoriesFileSystem(t *testing.T) {
+	healthy := NewReferenceFileSystem(newGitCliFromPlaybook(t, "base"), BranchRef("master"))
+	fs := NewRepositoriesFileSystem(0)
+	for name, repository := range map[string]billy.Filesystem{"healthy": healthy, "broken": failingFileSystem{memfs.New()}} {
+		if _, err := fs.AddRepository(name, repository); err != nil {
+			t.Fatalf("AddRepository(%s) failed: %v", name, err)
+		}
+	}
+
+	t.Run("listing", func(t *testing.T) {
+		files, err := fs.ReadDir("/")
+		if err != nil {
+			t.Fatalf("ReadDir(/) failed: %v", err)
+		}
+		var names []string
+		for _, file := range files {
+			if !file.IsDir() {
+				t.Fatalf("%s is not a directory", file.Name())
+			}
+			names = append(names, file.Name())
+		}
+		if !reflect.DeepEqual(names, []string{"broken", "healthy"}) {
+			t.Fatalf("ReadDir(/) listed %v", names)
+		}
+		if info, err := fs.Stat("broken"); err != nil || !info.IsDir() {
+			t.Fatalf("Stat(broken) = %v, %v, expected a directory even though it is broken", info, err)
+		}
+	})
+
+	t.Run("isolation", func(t *testing.T) {
+		file, err := fs.Open("healthy/real.txt")
+		if err != nil {
+			t.Fatalf("Open(healthy/real.txt) failed: %v", err)
+		}
+		contents, err := io.ReadAll(file)
+		file.Close()
+		if err != nil || string(contents) != "Hello World\n" {
+			t.Fatalf("healthy/real.txt contained %q, %v", contents, err)
+		}
+
+		if _, err := fs.Open("broken/real.txt"); !errors.Is(err, syscall.EIO) {
+			t.Fatalf("Open(broken/real.txt) = %v, expected the repository's own error", err)
+		}
+		if _, err := fs.Stat("missing/real.txt"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Stat(missing/real.txt) should not exist: %v", err)
+		}
+
+		failures := map[string]int64{}
+		for _, stats := range fs.Stats() {
+			failures[stats.Name] = stats.Failures
+		}
+		if failures["healthy"] != 0 || failures["broken"] == 0 {
+			t.Fatalf("Stats() counted failures %v", failures)
+		}
+	})
+
+	t.Run("read only root", func(t *testing.T) {
+		if err := fs.MkdirAll("new", 0755); !errors.Is(err, billy.ErrReadOnly) {
+			t.Fatalf("MkdirAll(new) = %v, expected billy.ErrReadOnly", err)
+		}
+		if err := fs.Remove("healthy"); !errors.Is(err, billy.ErrReadOnly) {
+			t.Fatalf("Remove(healthy) = %v, expected billy.ErrReadOnly", err)
+		}
+		if _, err := fs.Open("/"); !errors.Is(err, ErrIsDirectory) {
+			t.Fatalf("Open(/) = %v, expected ErrIsDirectory", err)
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		if removed := fs.RemoveRepository("broken"); removed == nil {
+			t.Fatal("RemoveRepository(broken) found nothing to remove")
+		}
+		if _, err := fs.ReadDir("broken"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("ReadDir(broken) after removing it = %v", err)
+		}
+		if names := fs.Names(); !reflect.DeepEqual(names, []string{"healthy"}) {
+			t.Fatalf("Names() = %v after removing broken", names)
+		}
+	})
+
+	t.Run("invalid names", func(t *testing.T) {
+		for _, name := range []string{"", ".", "..", "a/b"} {
+			if _, err := fs.AddRepository(name, memfs.New()); !errors.Is(err, ErrInvalidRepositoryName) {
+				t.Fatalf("AddRepository(%q) = %v, expected ErrInvalidRepositoryName", name, err)
+			}
+		}
+	})
+}
+
+func TestRepositoriesConcurrency(t *testing.T) {
+	inner := memfs.New()
+	if err := util.WriteFile(inner, "file.txt", []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	blocking := blockingFileSystem{Filesystem: inner, started: make(chan struct{}, 2), release: make(chan struct{})}
+	fs := NewRepositoriesFileSystem(1)
+	if _, err := fs.AddRepository("slow", blocking); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.AddRepository("fast", inner); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := fs.Stat("slow/file.txt")
+			done <- err
+		}()
+	}
+	<-blocking.started
+	select {
+	case <-blocking.started:
+		t.Fatal("a second call was let into a repository limited to one")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Other repositories aren't held up.
+	if _, err := fs.Stat("fast/file.txt"); err != nil {
+		t.Fatalf("Stat(fast/file.txt) failed: %v", err)
+	}
+	if stats := fs.Stats(); stats[1].Name != "slow" || stats[1].InFlight != 2 {
+		t.Fatalf("Stats() = %+v, expected 2 calls in flight in slow", stats)
+	}
+
+	close(blocking.release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("Stat(slow/file.txt) failed: %v", err)
+		}
+	}
+}
+
+func TestFindRepositories(t *testing.T) {
+	directory := t.TempDir()
+	for _, name := range []string{"one.git", "two"} {
+		gitDirectory, err := runPlaybook("base", t.TempDir())
+		if err != nil {
+			t.Fatalf("playbook 'base' failed: %v", err)
+		}
+		if err := os.Rename(gitDirectory, filepath.Join(directory, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(directory, "not-a-repository"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	repositories, err := FindRepositories(directory)
+	if err != nil {
+		t.Fatalf("FindRepositories() failed: %v", err)
+	}
+	expected := map[string]string{
+		"one": filepath.Join(directory, "one.git"),
+		"two": filepath.Join(directory, "two"),
+	}
+	if !reflect.DeepEqual(repositories, expected) {
+		t.Fatalf("FindRepositories() = %v, expected %v", repositories, expected)
+	}
+
+	if _, err := FindRepositories(filepath.Join(directory, "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("FindRepositories() of a missing directory = %v", err)
+	}
+}
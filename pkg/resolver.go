@@ -0,0 +1,188 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrUnresolved is returned when a RefResolver has no commit for a name.
+var ErrUnresolved = errors.New("name didn't resolve to a commit")
+
+// RefResolver maps a logical name to the commit to serve for it, like the last commit of main that passed CI for
+// "main", so a mount can follow something git doesn't know about. It is asked when mounting and whenever the mount is
+// refreshed. The commit can be anything git rev-parse understands but is usually a full hash.
+type RefResolver interface {
+	Resolve(name string) (string, error)
+}
+
+// RefResolverFunc is a RefResolver that calls itself.
+type RefResolverFunc func(name string) (string, error)
+
+func (f RefResolverFunc) Resolve(name string) (string, error) {
+	return f(name)
+}
+
+// ResolveRef resolves name with resolver to the ref of the commit to serve.
+func ResolveRef(resolver RefResolver, name string) (Ref, error) {
+	commit, err := resolver.Resolve(name)
+	if err != nil {
+		return Ref{}, fmt.Errorf("failed to resolve '%s': %w", name, err)
+	}
+	commit = strings.TrimSpace(commit)
+	if commit == "" {
+		return Ref{}, fmt.Errorf("failed to resolve '%s': %w", name, ErrUnresolved)
+	}
+	return CommitRef(commit), nil
+}
+
+// commandResolverTimeout is how long a command resolving a name may run.
+const commandResolverTimeout = 30 * time.Second
+
+// commandResolver runs a command to resolve names.
+type commandResolver struct {
+	command string
+}
+
+// NewCommandRefResolver resolves names by running command with the name as its only argument. The commit is the
+// first line it prints, and it resolves nothing if it prints nothing. It fails if it exits with an error or runs for
+// longer than 30 seconds.
+func NewCommandRefResolver(command string) RefResolver {
+	return commandResolver{command: command}
+}
+
+func (r commandResolver) Resolve(name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandResolverTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, r.command, name)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("'%s %s' failed: %v: %s", r.command, name, err, strings.TrimSpace(stderr.String()))
+	}
+	return firstLine(output)
+}
+
+// NamePlaceholder is replaced by the name being resolved in the URL of NewHTTPRefResolver and the path of
+// NewFileRefResolver.
+const NamePlaceholder = "{name}"
+
+// httpResolverTimeout is how long fetching a commit over HTTP may take.
+const httpResolverTimeout = 30 * time.Second
+
+// maxResolvedSize is the most that is read of what names resolve to. A commit is a single line.
+const maxResolvedSize = 4096
+
+// firstLine is the first line of contents, which resolves nothing if it is blank.
+func firstLine(contents []byte) (string, error) {
+	line := strings.SplitN(string(contents), "\n", 2)[0]
+	if strings.TrimSpace(line) == "" {
+		return "", ErrUnresolved
+	}
+	return line, nil
+}
+
+// httpResolver fetches the commits names resolve to.
+type httpResolver struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPRefResolver resolves names by fetching address with NamePlaceholder replaced by the name, escaped for a URL.
+// It is the integration point for CI systems: one that publishes the last commit of each branch that passed at a URL
+// like https://ci.example.com/last-green/{name}, as text with the commit on the first line, can have gitfs serve
+// the last passing build and follow it as it moves with a resolve interval. A 404 resolves nothing.
+func NewHTTPRefResolver(address string) RefResolver {
+	return httpResolver{url: address, client: &http.Client{Timeout: httpResolverTimeout}}
+}
+
+func (r httpResolver) Resolve(name string) (string, error) {
+	address := strings.ReplaceAll(r.url, NamePlaceholder, url.PathEscape(name))
+	response, err := r.client.Get(address)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return "", ErrUnresolved
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s failed: %s", address, response.Status)
+	}
+	contents, err := io.ReadAll(io.LimitReader(response.Body, maxResolvedSize))
+	if err != nil {
+		return "", fmt.Errorf("fetching %s failed: %v", address, err)
+	}
+	return firstLine(contents)
+}
+
+// fileResolver reads the commits names resolve to from files.
+type fileResolver struct {
+	path string
+}
+
+// NewFileRefResolver resolves names by reading the file at path with NamePlaceholder replaced by the name, for CI
+// systems that write the last commit that passed to a shared file instead of serving it, see NewHTTPRefResolver. The
+// commit is the first line of the file and a missing file resolves nothing. Writers should rename the file into place
+// so it is never read half written.
+func NewFileRefResolver(path string) RefResolver {
+	return fileResolver{path: path}
+}
+
+func (r fileResolver) Resolve(name string) (string, error) {
+	file, err := os.Open(strings.ReplaceAll(r.path, NamePlaceholder, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", ErrUnresolved
+	} else if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	contents, err := io.ReadAll(io.LimitReader(file, maxResolvedSize))
+	if err != nil {
+		return "", err
+	}
+	return firstLine(contents)
+}
+
+// ParseRefResolver builds the RefResolver spec describes: "exec:COMMAND" runs COMMAND with the name to resolve, see
+// NewCommandRefResolver, an http:// or https:// URL fetches the commit from it, see NewHTTPRefResolver, and
+// "file:PATH" reads it from a file, see NewFileRefResolver. URLs and paths may contain NamePlaceholder.
+func ParseRefResolver(spec string) (RefResolver, error) {
+	switch {
+	case strings.HasPrefix(spec, "exec:") && len(spec) > len("exec:"):
+		return NewCommandRefResolver(strings.TrimPrefix(spec, "exec:")), nil
+	case strings.HasPrefix(spec, "file:") && len(spec) > len("file:"):
+		return NewFileRefResolver(strings.TrimPrefix(spec, "file:")), nil
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		if _, err := url.Parse(strings.ReplaceAll(spec, NamePlaceholder, "name")); err != nil {
+			return nil, fmt.Errorf("invalid ref resolver URL '%s': %v", spec, err)
+		}
+		return NewHTTPRefResolver(spec), nil
+	default:
+		return nil, fmt.Errorf("unknown ref resolver '%s', expected exec:COMMAND, file:PATH, or an http:// or https:// URL", spec)
+	}
+}
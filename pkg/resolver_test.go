@@ -0,0 +1,111 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommandRefResolver(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "resolve.sh")
+	contents := `#!/bin/sh
+case "$1" in
+  main) printf '557db03de997c86a4a028e1ebd3a1ceb225be238\nignored\n' ;;
+  unknown) ;;
+  *) echo "no builds of $1" >&2; exit 1 ;;
+esac
+`
+	if err := os.WriteFile(script, []byte(contents), 0755); err != nil {
+		t.Fatal(err)
+	}
+	resolver, err := ParseRefResolver("exec:" + script)
+	if err != nil {
+		t.Fatalf("ParseRefResolver() failed: %v", err)
+	}
+
+	ref, err := ResolveRef(resolver, "main")
+	if err != nil || ref != CommitRef("557db03de997c86a4a028e1ebd3a1ceb225be238") {
+		t.Fatalf("ResolveRef(main) = %v, %v", ref, err)
+	}
+	if _, err := ResolveRef(resolver, "unknown"); !errors.Is(err, ErrUnresolved) {
+		t.Fatalf("ResolveRef(unknown) returned %v, expected ErrUnresolved", err)
+	}
+	if _, err := ResolveRef(resolver, "broken"); err == nil || errors.Is(err, ErrUnresolved) {
+		t.Fatalf("ResolveRef(broken) returned %v, expected the command to fail", err)
+	}
+
+	for _, spec := range []string{"", "exec:", "file:", "http", "http://%zz/{name}"} {
+		if _, err := ParseRefResolver(spec); err == nil {
+			t.Fatalf("ParseRefResolver(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestHTTPRefResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/last-green/feature/x":
+			fmt.Fprintln(w, "557db03de997c86a4a028e1ebd3a1ceb225be238")
+		case "/last-green/broken":
+			http.Error(w, "build service is down", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	resolver, err := ParseRefResolver(server.URL + "/last-green/" + NamePlaceholder)
+	if err != nil {
+		t.Fatalf("ParseRefResolver() failed: %v", err)
+	}
+
+	if ref, err := ResolveRef(resolver, "feature/x"); err != nil || ref != CommitRef("557db03de997c86a4a028e1ebd3a1ceb225be238") {
+		t.Fatalf("ResolveRef(feature/x) = %v, %v", ref, err)
+	}
+	if _, err := ResolveRef(resolver, "main"); !errors.Is(err, ErrUnresolved) {
+		t.Fatalf("ResolveRef(main) returned %v, expected a 404 to be ErrUnresolved", err)
+	}
+	if _, err := ResolveRef(resolver, "broken"); err == nil || errors.Is(err, ErrUnresolved) {
+		t.Fatalf("ResolveRef(broken) returned %v, expected the server's error", err)
+	}
+}
+
+func TestFileRefResolver(t *testing.T) {
+	directory := t.TempDir()
+	if err := os.WriteFile(filepath.Join(directory, "main"), []byte("557db03de997c86a4a028e1ebd3a1ceb225be238\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(directory, "empty"), []byte("\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resolver, err := ParseRefResolver("file:" + filepath.Join(directory, NamePlaceholder))
+	if err != nil {
+		t.Fatalf("ParseRefResolver() failed: %v", err)
+	}
+
+	if ref, err := ResolveRef(resolver, "main"); err != nil || ref != CommitRef("557db03de997c86a4a028e1ebd3a1ceb225be238") {
+		t.Fatalf("ResolveRef(main) = %v, %v", ref, err)
+	}
+	for _, name := range []string{"empty", "missing"} {
+		if _, err := ResolveRef(resolver, name); !errors.Is(err, ErrUnresolved) {
+			t.Fatalf("ResolveRef(%s) returned %v, expected ErrUnresolved", name, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io/fs"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrInvalidPattern is returned when searching with a pattern that isn't a regular expression.
+var ErrInvalidPattern = fmt.Errorf("%w: invalid search pattern", fs.ErrInvalid)
+
+// CommitSearchResult is a commit whose message matched a search.
+type CommitSearchResult struct {
+	Hash string `json:"hash"`
+	// Date is when the commit was authored.
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+	// Matches are the lines of the message that matched, the subject included.
+	Matches []string `json:"matches"`
+}
+
+// MatchingLines returns the lines of message matcher matches, the way git log --grep matches messages line by line.
+func MatchingLines(matcher *regexp.Regexp, message string) []string {
+	var matches []string
+	for _, line := range strings.Split(message, "\n") {
+		if matcher.MatchString(line) {
+			matches = append(matches, line)
+		}
+	}
+	return matches
+}
+
+// commitSubject is the first line of message.
+func commitSubject(message string) string {
+	return strings.SplitN(message, "\n", 2)[0]
+}
+
+// SearchCommits finds the commits reachable from ref whose message matches pattern, an extended regular expression
+// like git log --grep takes, newest first. Only the first limit are found when it is positive.
+func SearchCommits(git Git, ref Ref, pattern string, limit int) ([]CommitSearchResult, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("%w: the pattern is empty", ErrInvalidPattern)
+	}
+	// Patterns are compiled before git sees them so bad ones are refused the same way by every backend.
+	matcher, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w '%s': %v", ErrInvalidPattern, pattern, err)
+	}
+	results := []CommitSearchResult{}
+	err = git.SearchCommits(ref, pattern, limit, func(commit gitism.CommitInfo) error {
+		results = append(results, CommitSearchResult{
+			Hash:    commit.Hash,
+			Date:    commit.AuthorTime,
+			Subject: commitSubject(commit.Message),
+			Matches: MatchingLines(matcher, commit.Message),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSearchCommits(t *testing.T) {
+	git := NewFakeGit(nil)
+	first := git.Commit("master", "Add a\n\nFixes the build.", map[string]FakeFile{"a": {Contents: "a"}})
+	git.Commit("master", "Change a", map[string]FakeFile{"a": {Contents: "b"}})
+	third := git.Commit("master", "Fix a typo\n\nThe build was fine.\nfixes #12", map[string]FakeFile{"a": {Contents: "c"}})
+
+	results, err := SearchCommits(git, BranchRef("master"), "[Ff]ix", 0)
+	if err != nil {
+		t.Fatalf("SearchCommits() failed: %v", err)
+	}
+	if len(results) != 2 || results[0].Hash != third || results[1].Hash != first {
+		t.Fatalf("SearchCommits() found %+v", results)
+	}
+	if got := results[0]; got.Subject != "Fix a typo" || len(got.Matches) != 2 || got.Matches[1] != "fixes #12" {
+		t.Fatalf("SearchCommits() described %+v", got)
+	}
+	if got := results[1]; got.Subject != "Add a" || len(got.Matches) != 1 || got.Matches[0] != "Fixes the build." {
+		t.Fatalf("SearchCommits() described %+v", got)
+	}
+
+	if results, err := SearchCommits(git, BranchRef("master"), "a", 1); err != nil || len(results) != 1 || results[0].Hash != third {
+		t.Fatalf("SearchCommits() with a limit found %+v, %v", results, err)
+	}
+	if results, err := SearchCommits(git, BranchRef("master"), "nothing", 0); err != nil || results == nil || len(results) != 0 {
+		t.Fatalf("SearchCommits() for nothing found %+v, %v", results, err)
+	}
+	for _, pattern := range []string{"", "(unclosed"} {
+		if _, err := SearchCommits(git, BranchRef("master"), pattern, 0); !errors.Is(err, ErrInvalidPattern) {
+			t.Fatalf("SearchCommits(%q) returned %v, expected ErrInvalidPattern", pattern, err)
+		}
+	}
+}
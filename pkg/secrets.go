@@ -0,0 +1,179 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// DefaultSecretPatterns match private keys and access tokens that are commonly committed by mistake.
+var DefaultSecretPatterns = []string{
+	// PEM encoded private keys, including OpenSSH and PGP ones.
+	`-----BEGIN [A-Z ]*PRIVATE KEY( BLOCK)?-----`,
+	// AWS access key ids.
+	`\b(AKIA|ASIA)[0-9A-Z]{16}\b`,
+	// GitHub personal access, OAuth, app, and refresh tokens.
+	`\bgh[pousr]_[A-Za-z0-9]{36,}\b`,
+	// Slack tokens.
+	`\bxox[abposr]-[A-Za-z0-9-]{10,}`,
+	// Google API keys.
+	`\bAIza[0-9A-Za-z_-]{35}\b`,
+}
+
+// ErrSecret is returned in an *fs.PathError when opening a file that matches a secret pattern. It is a plain errno so
+// frontends checking os.IsPermission answer with their own permission denied error.
+var ErrSecret error = syscall.EACCES
+
+// secretVerdictCacheSize is how many files are remembered as safe or not before forgetting all of them.
+const secretVerdictCacheSize = 1 << 16
+
+// secretVerdicts remembers whether blobs contain a secret by their hash so each is only scanned once.
+type secretVerdicts struct {
+	mu       sync.Mutex
+	verdicts map[string]bool
+}
+
+func (v *secretVerdicts) get(hash string) (secret bool, ok bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	secret, ok = v.verdicts[hash]
+	return secret, ok
+}
+
+func (v *secretVerdicts) put(hash string, secret bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.verdicts) >= secretVerdictCacheSize {
+		v.verdicts = make(map[string]bool)
+	}
+	v.verdicts[hash] = secret
+}
+
+// secretFileSystem refuses to open files whose contents match a secret pattern. Files are still listed and can be
+// stat'd so tools walking the tree keep working.
+type secretFileSystem struct {
+	billy.Filesystem
+	pattern  *regexp.Regexp
+	verdicts *secretVerdicts
+}
+
+// NewSecretFilterFileSystem refuses to serve files in fs matching one of patterns, which are regular expressions. An
+// empty list uses DefaultSecretPatterns.
+func NewSecretFilterFileSystem(fs billy.Filesystem, patterns []string) (billy.Filesystem, error) {
+	if len(patterns) == 0 {
+		patterns = DefaultSecretPatterns
+	}
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid secret pattern %q: %v", pattern, err)
+		}
+	}
+	pattern, err := regexp.Compile("(?:" + strings.Join(patterns, ")|(?:") + ")")
+	if err != nil {
+		return nil, err
+	}
+	return secretFileSystem{
+		Filesystem: fs,
+		pattern:    pattern,
+		verdicts:   &secretVerdicts{verdicts: make(map[string]bool)},
+	}, nil
+}
+
+// scanReader remembers the error reading a file failed with, which MatchReader takes to be the end of the file.
+type scanReader struct {
+	io.Reader
+	err error
+}
+
+func (r *scanReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// scan reports whether file contains a secret and rewinds it so it can be read from the start. A file that can't be
+// read to the end isn't known to be safe, so it fails the scan.
+func (s secretFileSystem) scan(file billy.File) (bool, error) {
+	reader := &scanReader{Reader: file}
+	secret := s.pattern.MatchReader(bufio.NewReader(reader))
+	if !secret && reader.err != nil {
+		return false, reader.err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return secret, nil
+}
+
+func (s secretFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s secretFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	file, err := s.Filesystem.OpenFile(filename, flag, perm)
+	// Only files opened for writing go unscanned. Flags like O_NOFOLLOW still read the file.
+	if err != nil || flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return file, err
+	}
+
+	var hash string
+	if info, err := s.Filesystem.Stat(filename); err == nil {
+		hash, _ = ObjectHash(info)
+	}
+
+	secret, known := s.verdicts.get(hash)
+	if hash == "" || !known {
+		secret, err = s.scan(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if hash != "" {
+			s.verdicts.put(hash, secret)
+		}
+	}
+
+	if secret {
+		file.Close()
+		log.Printf("secret filter refused to serve %s\n", RedactPath(filename))
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: ErrSecret}
+	}
+	return file, nil
+}
+
+// Chroot keeps filtering files in the new root.
+func (s secretFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	fs, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return secretFileSystem{
+		Filesystem: fs,
+		pattern:    s.pattern,
+		verdicts:   s.verdicts,
+	}, nil
+}
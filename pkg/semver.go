@@ -0,0 +1,211 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, as described by https://semver.org, like 1.2.3 or 2.0.0-rc.1+build.5.
+type Version struct {
+	Major, Minor, Patch uint64
+	// Prerelease are the dot separated identifiers after the "-", like rc and 1 of 2.0.0-rc.1.
+	Prerelease []string
+	// Build is what follows the "+". It is ignored when ordering versions.
+	Build string
+}
+
+// ParseVersion parses a semantic version. Tags often start with a "v", like v1.2.3, which is ignored.
+func ParseVersion(name string) (Version, error) {
+	rest := strings.TrimPrefix(name, "v")
+	var version Version
+	if plus := strings.IndexByte(rest, '+'); plus >= 0 {
+		version.Build = rest[plus+1:]
+		rest = rest[:plus]
+		if !validIdentifiers(version.Build, false) {
+			return Version{}, fmt.Errorf("invalid build metadata in version '%s'", name)
+		}
+	}
+	if minus := strings.IndexByte(rest, '-'); minus >= 0 {
+		prerelease := rest[minus+1:]
+		rest = rest[:minus]
+		if !validIdentifiers(prerelease, true) {
+			return Version{}, fmt.Errorf("invalid prerelease in version '%s'", name)
+		}
+		version.Prerelease = strings.Split(prerelease, ".")
+	}
+
+	numbers := strings.Split(rest, ".")
+	if len(numbers) != 3 {
+		return Version{}, fmt.Errorf("version '%s' is not major.minor.patch", name)
+	}
+	for i, field := range []*uint64{&version.Major, &version.Minor, &version.Patch} {
+		if !numeric(numbers[i]) || (len(numbers[i]) > 1 && numbers[i][0] == '0') {
+			return Version{}, fmt.Errorf("invalid number '%s' in version '%s'", numbers[i], name)
+		}
+		var err error
+		*field, err = strconv.ParseUint(numbers[i], 10, 64)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid number '%s' in version '%s': %v", numbers[i], name, err)
+		}
+	}
+	return version, nil
+}
+
+// validIdentifiers reports whether identifiers is a dot separated list of alphanumerics and hyphens. Prereleases
+// can't have numbers with leading zeros.
+func validIdentifiers(identifiers string, prerelease bool) bool {
+	for _, identifier := range strings.Split(identifiers, ".") {
+		if identifier == "" {
+			return false
+		}
+		for _, r := range identifier {
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+				return false
+			}
+		}
+		if prerelease && numeric(identifier) && len(identifier) > 1 && identifier[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+// numeric reports whether s is a non-empty string of digits.
+func numeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare returns -1, 0, or 1 when v is older than, the same as, or newer than other by semver's precedence rules.
+func (v Version) Compare(other Version) int {
+	for _, pair := range [][2]uint64{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// A release is newer than its prereleases.
+	switch {
+	case len(v.Prerelease) == 0 && len(other.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(other.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(other.Prerelease); i++ {
+		if c := compareIdentifiers(v.Prerelease[i], other.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(v.Prerelease) < len(other.Prerelease):
+		return -1
+	case len(v.Prerelease) > len(other.Prerelease):
+		return 1
+	}
+	return 0
+}
+
+// compareIdentifiers orders prerelease identifiers. Numbers are compared numerically and are older than anything
+// alphanumeric, which is compared in ASCII order.
+func compareIdentifiers(a, b string) int {
+	aNumeric, bNumeric := numeric(a), numeric(b)
+	switch {
+	case aNumeric && bNumeric:
+		if len(a) != len(b) {
+			if len(a) < len(b) {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	case aNumeric:
+		return -1
+	case bNumeric:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// IsPrerelease reports whether v is a prerelease, like 2.0.0-rc.1.
+func (v Version) IsPrerelease() bool {
+	return len(v.Prerelease) > 0
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Release is a tag named after a semantic version.
+type Release struct {
+	Tag     string
+	Version Version
+}
+
+// ListReleases lists the tags of git that are semantic versions, oldest first. Other tags are left out. Tags of the
+// same version, like 1.0.0 and v1.0.0, are ordered by name.
+func ListReleases(git Git) ([]Release, error) {
+	var releases []Release
+	err := git.ListTags(func(tag string) error {
+		version, err := ParseVersion(tag)
+		if err == nil {
+			releases = append(releases, Release{Tag: tag, Version: version})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(releases, func(i, j int) bool {
+		if c := releases[i].Version.Compare(releases[j].Version); c != 0 {
+			return c < 0
+		}
+		return releases[i].Tag < releases[j].Tag
+	})
+	return releases, nil
+}
+
+// LatestRelease is the newest release in releases, sorted like ListReleases returns them, that isn't a prerelease. ok
+// is false if there is none.
+func LatestRelease(releases []Release) (release Release, ok bool) {
+	for i := len(releases) - 1; i >= 0; i-- {
+		if !releases[i].Version.IsPrerelease() {
+			return releases[i], true
+		}
+	}
+	return Release{}, false
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"sort"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := map[string]Version{
+		"1.2.3":                 {Major: 1, Minor: 2, Patch: 3},
+		"v0.0.0":                {},
+		"v2.0.0-rc.1":           {Major: 2, Prerelease: []string{"rc", "1"}},
+		"1.0.0-alpha-1+build.5": {Major: 1, Prerelease: []string{"alpha-1"}, Build: "build.5"},
+		"1.0.0+20210101":        {Major: 1, Build: "20210101"},
+	}
+	for name, want := range tests {
+		got, err := ParseVersion(name)
+		if err != nil {
+			t.Fatalf("ParseVersion(%s) failed: %v", name, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("ParseVersion(%s): %s", name, diff)
+		}
+		if got.String() != name && "v"+got.String() != name {
+			t.Fatalf("ParseVersion(%s).String() = %s", name, got.String())
+		}
+	}
+
+	for _, invalid := range []string{"", "1.2", "1.2.3.4", "01.2.3", "1.2.3-", "1.2.3-01", "1.2.3-rc..1", "1.2.3+", "release-1.2.3", "1.2.x", "vv1.2.3"} {
+		if _, err := ParseVersion(invalid); err == nil {
+			t.Fatalf("parsed invalid version '%s'", invalid)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// The example from https://semver.org, oldest first.
+	want := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "1.10.0", "2.0.0"}
+	var versions []Version
+	for i := len(want) - 1; i >= 0; i-- {
+		version, err := ParseVersion(want[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Compare(versions[j]) < 0
+	})
+	var got []string
+	for _, version := range versions {
+		got = append(got, version.String())
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+
+	a, _ := ParseVersion("1.0.0+a")
+	b, _ := ParseVersion("1.0.0+b")
+	if a.Compare(b) != 0 {
+		t.Fatal("build metadata should not affect precedence")
+	}
+}
+
+func TestListReleases(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "releases")
+	releases, err := ListReleases(git)
+	if err != nil {
+		t.Fatalf("ListReleases() failed: %v", err)
+	}
+	var tags []string
+	for _, release := range releases {
+		tags = append(tags, release.Tag)
+	}
+	if diff := cmp.Diff([]string{"v1.0.0", "v1.1.0", "v1.2.0", "v1.10.0", "v2.0.0-rc.1"}, tags); diff != "" {
+		t.Fatal(diff)
+	}
+
+	latest, ok := LatestRelease(releases)
+	if !ok || latest.Tag != "v1.10.0" {
+		t.Fatalf("LatestRelease() = %v, %v, expected v1.10.0", latest, ok)
+	}
+	if _, ok := LatestRelease(releases[len(releases)-1:]); ok {
+		t.Fatal("LatestRelease() found a release among only prereleases")
+	}
+}
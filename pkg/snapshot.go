@@ -0,0 +1,90 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// snapshotVersion changes whenever what goes into a snapshot ID does, so old IDs never collide with new ones.
+const snapshotVersion = "gitfs-snapshot-v1"
+
+// SnapshotFilters are the options that change which files a mount serves or what is in them. Options that only
+// change how fast or in which order files are served are left out so they don't change the snapshot ID.
+type SnapshotFilters struct {
+	Symlinks       SymlinkPolicy        `json:"symlinks"`
+	FollowSymlinks bool                 `json:"follow_symlinks,omitempty"`
+	Normalization  UnicodeNormalization `json:"normalization,omitempty"`
+	MaxFileSize    int64                `json:"max_file_size,omitempty"`
+	ChunkSeparator string               `json:"chunk_separator,omitempty"`
+	Archives       bool                 `json:"archives,omitempty"`
+	TextOnly       bool                 `json:"text_only,omitempty"`
+	Releases       bool                 `json:"releases,omitempty"`
+	History        bool                 `json:"history,omitempty"`
+	Templates      []string             `json:"templates,omitempty"`
+	Dotfiles       DotfilePolicy        `json:"dotfiles,omitempty"`
+	SecretFilter   bool                 `json:"secret_filter,omitempty"`
+	SecretPatterns []string             `json:"secret_patterns,omitempty"`
+	Hide           []string             `json:"hide,omitempty"`
+	// DirtyWorktree is set when uncommitted changes are shown, in which case the commit doesn't pin the contents.
+	DirtyWorktree bool `json:"dirty_worktree,omitempty"`
+	GitObjects    bool `json:"git_objects,omitempty"`
+}
+
+// SnapshotID identifies exactly what a mount serves: commit of repository, reached through ref, with filters
+// applied. Mounts of the same input on any machine get the same ID, so build systems can key remote cache entries off
+// of it.
+func SnapshotID(repository string, ref Ref, commit string, filters SnapshotFilters) string {
+	// Patterns are sets, so their order doesn't matter.
+	filters.Templates = sortedCopy(filters.Templates)
+	filters.SecretPatterns = sortedCopy(filters.SecretPatterns)
+	filters.Hide = sortedCopy(filters.Hide)
+	encoded, _ := json.Marshal(struct {
+		Version    string          `json:"version"`
+		Repository string          `json:"repository"`
+		Ref        string          `json:"ref"`
+		Commit     string          `json:"commit"`
+		Filters    SnapshotFilters `json:"filters"`
+	}{snapshotVersion, repository, ref.String(), commit, filters})
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+func sortedCopy(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// RepositoryName names the repository at gitDir the same way on every machine it is cloned to: the name of its
+// directory without a .git suffix, or of its working tree if gitDir is a .git directory.
+func RepositoryName(gitDir string) string {
+	if absolute, err := filepath.Abs(gitDir); err == nil {
+		gitDir = absolute
+	}
+	name := filepath.Base(gitDir)
+	if name == ".git" {
+		name = filepath.Base(filepath.Dir(gitDir))
+	}
+	return strings.TrimSuffix(name, ".git")
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotID(t *testing.T) {
+	const commit = "557db03de997c86a4a028e1ebd3a1ceb225be238"
+	master := BranchRef("master")
+	filters := SnapshotFilters{Templates: []string{"*.go", "*.txt"}, SecretFilter: true}
+	id := SnapshotID("gitfs", master, commit, filters)
+	if len(id) != 64 {
+		t.Fatalf("SnapshotID() = %q, expected a sha256", id)
+	}
+
+	reordered := SnapshotFilters{Templates: []string{"*.txt", "*.go"}, SecretFilter: true}
+	if SnapshotID("gitfs", master, commit, reordered) != id {
+		t.Fatal("the order of template patterns changed the snapshot ID")
+	}
+	if filters.Templates[0] != "*.go" {
+		t.Fatal("SnapshotID() sorted the caller's patterns")
+	}
+
+	different := map[string]string{
+		"repository": SnapshotID("other", master, commit, filters),
+		"ref":        SnapshotID("gitfs", TagRef("master"), commit, filters),
+		"commit":     SnapshotID("gitfs", master, "0000000000000000000000000000000000000000", filters),
+		"filters":    SnapshotID("gitfs", master, commit, SnapshotFilters{Templates: filters.Templates}),
+	}
+	for changed, other := range different {
+		if other == id {
+			t.Fatalf("changing the %s kept the same snapshot ID", changed)
+		}
+	}
+}
+
+func TestRepositoryName(t *testing.T) {
+	tests := map[string]string{
+		"/srv/git/gitfs.git":       "gitfs",
+		"/home/user/gitfs/.git":    "gitfs",
+		"/srv/git/gitfs":           "gitfs",
+		filepath.Join("..", "pkg"): "pkg",
+	}
+	for gitDir, expected := range tests {
+		if actual := RepositoryName(gitDir); actual != expected {
+			t.Fatalf("RepositoryName(%s) = %s, expected %s", gitDir, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"os"
+	"sync"
+	"time"
+)
+
+// SwappableFileSystem forwards every call to a filesystem that can be replaced while it is being served. This lets a
+// daemon pick up new settings without unmounting. Calls already in flight finish against the old filesystem.
+type SwappableFileSystem struct {
+	mu sync.RWMutex
+	fs billy.Filesystem
+}
+
+func NewSwappableFileSystem(fs billy.Filesystem) *SwappableFileSystem {
+	return &SwappableFileSystem{fs: fs}
+}
+
+// Swap replaces the filesystem being served and returns the previous one. The epoch advances, see EpochFile.
+func (s *SwappableFileSystem) Swap(fs billy.Filesystem) billy.Filesystem {
+	s.mu.Lock()
+	previous := s.fs
+	s.fs = fs
+	s.mu.Unlock()
+	advanceEpoch()
+	return previous
+}
+
+func (s *SwappableFileSystem) current() billy.Filesystem {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.fs
+}
+
+// billy.Basic type implementation
+
+func (s *SwappableFileSystem) Create(filename string) (billy.File, error) {
+	return s.current().Create(filename)
+}
+
+func (s *SwappableFileSystem) Open(filename string) (billy.File, error) {
+	return s.current().Open(filename)
+}
+
+func (s *SwappableFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	return s.current().OpenFile(filename, flag, perm)
+}
+
+func (s *SwappableFileSystem) Stat(filename string) (os.FileInfo, error) {
+	return s.current().Stat(filename)
+}
+
+func (s *SwappableFileSystem) Rename(oldpath, newpath string) error {
+	return s.current().Rename(oldpath, newpath)
+}
+
+func (s *SwappableFileSystem) Remove(filename string) error {
+	return s.current().Remove(filename)
+}
+
+func (s *SwappableFileSystem) Join(elem ...string) string {
+	return s.current().Join(elem...)
+}
+
+// billy.TempFile type implementation
+
+func (s *SwappableFileSystem) TempFile(dir, prefix string) (billy.File, error) {
+	return s.current().TempFile(dir, prefix)
+}
+
+// billy.Dir type implementation
+
+func (s *SwappableFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	return s.current().ReadDir(path)
+}
+
+// ReadDirNames uses the current filesystem's ReadDirNames if it has one.
+func (s *SwappableFileSystem) ReadDirNames(path string) ([]string, error) {
+	return ReadDirNames(s.current(), path)
+}
+
+func (s *SwappableFileSystem) MkdirAll(filename string, perm os.FileMode) error {
+	return s.current().MkdirAll(filename, perm)
+}
+
+// billy.Symlink type implementation
+
+func (s *SwappableFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	return s.current().Lstat(filename)
+}
+
+func (s *SwappableFileSystem) Symlink(target, link string) error {
+	return s.current().Symlink(target, link)
+}
+
+func (s *SwappableFileSystem) Readlink(link string) (string, error) {
+	return s.current().Readlink(link)
+}
+
+// billy.Chroot type implementation
+
+// Chroot is taken from the current filesystem, so the result doesn't follow later swaps.
+func (s *SwappableFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	return s.current().Chroot(path)
+}
+
+func (s *SwappableFileSystem) Root() string {
+	return s.current().Root()
+}
+
+// billy.Change type implementation
+
+func (s *SwappableFileSystem) Chmod(name string, mode os.FileMode) error {
+	change, ok := s.current().(billy.Change)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+	return change.Chmod(name, mode)
+}
+
+func (s *SwappableFileSystem) Lchown(name string, uid, gid int) error {
+	change, ok := s.current().(billy.Change)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+	return change.Lchown(name, uid, gid)
+}
+
+func (s *SwappableFileSystem) Chown(name string, uid, gid int) error {
+	change, ok := s.current().(billy.Change)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+	return change.Chown(name, uid, gid)
+}
+
+func (s *SwappableFileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	change, ok := s.current().(billy.Change)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+	return change.Chtimes(name, atime, mtime)
+}
+
+// billy.Capable
+
+func (s *SwappableFileSystem) Capabilities() billy.Capability {
+	return billy.Capabilities(s.current())
+}
@@ -0,0 +1,37 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+)
+
+func TestSwappableFileSystem(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "tags")
+	reference := BranchRef("master")
+	swappable := NewSwappableFileSystem(NewReferenceFileSystem(git, reference))
+
+	if _, err := swappable.Stat(".gitfs/commit"); err == nil {
+		t.Fatalf("Stat(.gitfs/commit) should fail before introspection is enabled")
+	}
+
+	swappable.Swap(NewIntrospectionFileSystem(NewReferenceFileSystem(git, reference), git, reference))
+	if _, err := swappable.Stat(".gitfs/commit"); err != nil {
+		t.Fatalf("Stat(.gitfs/commit) failed after swapping: %v", err)
+	}
+	if _, err := swappable.Stat("real.txt"); err != nil {
+		t.Fatalf("Stat(real.txt) failed after swapping: %v", err)
+	}
+}
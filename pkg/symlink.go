@@ -0,0 +1,140 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// SymlinkPolicy decides what happens to symlinks whose target is absolute or leaves the root of the filesystem. These
+// targets are meaningless (or dangerous) on the machine the repository is served to.
+type SymlinkPolicy uint8
+
+const (
+	// SymlinkRewrite resolves targets as if the root of the filesystem were "/" so they always stay inside of the tree.
+	SymlinkRewrite SymlinkPolicy = iota
+	// SymlinkHide removes symlinks with absolute or escaping targets from the filesystem.
+	SymlinkHide
+	// SymlinkPassThrough serves the target exactly as it was committed.
+	SymlinkPassThrough
+)
+
+func ParseSymlinkPolicy(name string) (SymlinkPolicy, error) {
+	switch name {
+	case "rewrite":
+		return SymlinkRewrite, nil
+	case "hide":
+		return SymlinkHide, nil
+	case "passthrough":
+		return SymlinkPassThrough, nil
+	default:
+		return 0, fmt.Errorf("unknown symlink policy '%s', expected rewrite, hide, or passthrough", name)
+	}
+}
+
+func (p SymlinkPolicy) String() string {
+	switch p {
+	case SymlinkRewrite:
+		return "rewrite"
+	case SymlinkHide:
+		return "hide"
+	case SymlinkPassThrough:
+		return "passthrough"
+	default:
+		return "unknown-symlink-policy"
+	}
+}
+
+// resolveSymlink resolves target, the contents of a symlink living in directory parent, within root. Absolute
+// targets start from root and ".." never climbs above it, like in a chroot. escapes reports if either happened.
+func resolveSymlink(root, parent FilePath, target string) (resolved FilePath, escapes bool) {
+	var scratch []string
+	if filepath.IsAbs(target) {
+		scratch = append(scratch, root.Path...)
+		escapes = true
+	} else {
+		scratch = append(scratch, parent.Path...)
+	}
+
+	for _, part := range strings.Split(target, SeparatorString) {
+		switch part {
+		case ".", "":
+			continue
+		case "..":
+			if len(scratch) <= len(root.Path) {
+				escapes = true
+				continue
+			}
+			scratch = scratch[:len(scratch)-1]
+		default:
+			scratch = append(scratch, part)
+		}
+	}
+
+	return FilePath{Path: scratch}, escapes
+}
+
+// relativeTo expresses target as a path relative to the directory dir.
+func relativeTo(dir, target FilePath) (string, error) {
+	return filepath.Rel(dir.String(), target.String())
+}
+
+// maxSymlinkTargets bounds how many targets symlinkTargets remembers. Targets are short, so this is at most a few MiB.
+const maxSymlinkTargets = 1 << 14
+
+// symlinkTargets remembers the targets of symlinks by the hash of their blob, which can't change, so listing,
+// stating, and reading links doesn't run git for each of them every time.
+type symlinkTargets struct {
+	mu      sync.Mutex
+	targets map[string]string
+}
+
+func newSymlinkTargets() *symlinkTargets {
+	return &symlinkTargets{targets: map[string]string{}}
+}
+
+// read returns the target of the symlink stored in the blob hash, reading it with git the first time. A nil
+// symlinkTargets reads every time.
+func (t *symlinkTargets) read(git Git, hash string) (string, error) {
+	if t == nil {
+		contents, err := git.ReadBlob(hash)
+		return string(contents), err
+	}
+
+	t.mu.Lock()
+	target, ok := t.targets[hash]
+	t.mu.Unlock()
+	if ok {
+		return target, nil
+	}
+
+	contents, err := git.ReadBlob(hash)
+	if err != nil {
+		return "", err
+	}
+	target = string(contents)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.targets) >= maxSymlinkTargets {
+		// Starting over is simpler than tracking use, and trees rarely hold this many distinct symlinks.
+		t.targets = map[string]string{}
+	}
+	t.targets[hash] = target
+	return target, nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"io"
+	"os"
+	"strings"
+)
+
+// TemplateVariables returns the values substituted for @@NAME@@ tokens. It is called whenever a templated file is
+// read so values can follow the revision being served.
+type TemplateVariables func() (map[string]string, error)
+
+// NewReferenceTemplateVariables describes the commit reference points to: COMMIT is its full hash, DESCRIBE is the
+// output of git describe, REF is the name of the reference, and BRANCH or TAG are set when reference is one.
+func NewReferenceTemplateVariables(git Git, reference Ref) TemplateVariables {
+	return func() (map[string]string, error) {
+		if _, err := reference.treeLike(); err != nil {
+			return nil, err
+		}
+		commit, err := git.ResolveCommit(reference)
+		if err != nil {
+			return nil, err
+		}
+		description, err := git.Describe(commit)
+		if err != nil {
+			return nil, err
+		}
+
+		variables := map[string]string{
+			"COMMIT":   commit,
+			"DESCRIBE": description,
+			"REF":      reference.Name,
+		}
+		switch reference.Kind {
+		case RefBranch:
+			variables["BRANCH"] = reference.Name
+		case RefTag:
+			variables["TAG"] = reference.Name
+		}
+		return variables, nil
+	}
+}
+
+type templateInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i templateInfo) Size() int64 {
+	return i.size
+}
+
+// Sys hides the blob's GitFileStat since the expanded contents no longer match it.
+func (i templateInfo) Sys() interface{} {
+	return nil
+}
+
+// templateFileSystem expands @@NAME@@ tokens in files matching one of patterns when they are read. Stat reports the
+// size after expansion so readers that trust it, like the kernel, see the whole file.
+type templateFileSystem struct {
+	billy.Filesystem
+	patterns  []string
+	variables TemplateVariables
+}
+
+// NewTemplateFileSystem expands tokens in the files of fs that match one of patterns, as in MatchesAny.
+func NewTemplateFileSystem(fs billy.Filesystem, patterns []string, variables TemplateVariables) billy.Filesystem {
+	if len(patterns) == 0 {
+		return fs
+	}
+	return templateFileSystem{
+		Filesystem: fs,
+		patterns:   patterns,
+		variables:  variables,
+	}
+}
+
+func (s templateFileSystem) matches(filename string) bool {
+	return MatchesAny(s.patterns, filename)
+}
+
+// expand reads filename from the underlying filesystem and substitutes every known token.
+func (s templateFileSystem) expand(filename string) ([]byte, error) {
+	file, err := s.Filesystem.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	contents, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	variables, err := s.variables()
+	if err != nil {
+		return nil, err
+	}
+	replacements := make([]string, 0, 2*len(variables))
+	for name, value := range variables {
+		replacements = append(replacements, "@@"+name+"@@", value)
+	}
+	return []byte(strings.NewReplacer(replacements...).Replace(string(contents))), nil
+}
+
+// expandInfo fixes the size of info if filename is a file that gets expanded.
+func (s templateFileSystem) expandInfo(filename string, info os.FileInfo) (os.FileInfo, error) {
+	if !info.Mode().IsRegular() || !s.matches(filename) {
+		return info, nil
+	}
+	contents, err := s.expand(filename)
+	if err != nil {
+		return nil, err
+	}
+	return templateInfo{FileInfo: info, size: int64(len(contents))}, nil
+}
+
+func (s templateFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s templateFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag != os.O_RDONLY || !s.matches(filename) {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+	info, err := s.Filesystem.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+
+	contents, err := s.expand(filename)
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryFile(filename, contents), nil
+}
+
+func (s templateFileSystem) Stat(filename string) (os.FileInfo, error) {
+	info, err := s.Filesystem.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return s.expandInfo(filename, info)
+}
+
+func (s templateFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	info, err := s.Filesystem.Lstat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return s.expandInfo(filename, info)
+}
+
+func (s templateFileSystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	files, err := s.Filesystem.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	for index, file := range files {
+		files[index], err = s.expandInfo(s.Join(dirname, file.Name()), file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// Chroot keeps expanding files in the new root. Patterns with a separator are matched against paths relative to it.
+func (s templateFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	fs, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewTemplateFileSystem(fs, s.patterns, s.variables), nil
+}
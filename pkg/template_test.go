@@ -0,0 +1,89 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTemplateFileSystem(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "templates")
+	reference := BranchRef("master")
+	fs := NewTemplateFileSystem(NewReferenceFileSystem(git, reference), []string{"*.conf"}, NewReferenceTemplateVariables(git, reference))
+
+	commit, err := git.ResolveCommit(reference)
+	if err != nil {
+		t.Fatalf("ResolveCommit() failed: %v", err)
+	}
+
+	read := func(t *testing.T, filename string) string {
+		file, err := fs.Open(filename)
+		if err != nil {
+			t.Fatalf("Open(%s) failed: %v", filename, err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", filename, err)
+		}
+		return string(contents)
+	}
+
+	t.Run("expanded", func(t *testing.T) {
+		want := "commit=" + commit + "\nversion=v2.0\nbranch=master\nunknown=@@UNKNOWN@@\n"
+		if got := read(t, "config/version.conf"); got != want {
+			t.Fatalf("config/version.conf contained %q, want %q", got, want)
+		}
+
+		info, err := fs.Stat("config/version.conf")
+		if err != nil {
+			t.Fatalf("Stat(config/version.conf) failed: %v", err)
+		}
+		if info.Size() != int64(len(want)) {
+			t.Fatalf("Stat(config/version.conf) reported %d bytes, want %d", info.Size(), len(want))
+		}
+
+		files, err := fs.ReadDir("config")
+		if err != nil {
+			t.Fatalf("ReadDir(config) failed: %v", err)
+		}
+		if size := fileMap(files)["version.conf"].Size(); size != int64(len(want)) {
+			t.Fatalf("ReadDir(config) reported %d bytes for version.conf, want %d", size, len(want))
+		}
+	})
+
+	t.Run("unmatched", func(t *testing.T) {
+		if got := read(t, "config/raw.txt"); !strings.Contains(got, "@@COMMIT@@") {
+			t.Fatalf("config/raw.txt should not be expanded: %q", got)
+		}
+	})
+
+	t.Run("chroot", func(t *testing.T) {
+		chrooted, err := fs.Chroot("config")
+		if err != nil {
+			t.Fatalf("Chroot(config) failed: %v", err)
+		}
+		file, err := chrooted.Open("version.conf")
+		if err != nil {
+			t.Fatalf("Open(version.conf) failed: %v", err)
+		}
+		contents, err := io.ReadAll(file)
+		if err != nil || !strings.Contains(string(contents), commit) {
+			t.Fatalf("version.conf contained %q, %v", contents, err)
+		}
+	})
+}
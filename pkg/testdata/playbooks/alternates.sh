@@ -0,0 +1,33 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## upstream/ is the repository this one was forked from ##
+mkdir upstream/
+(
+  cd upstream/
+  git init
+  cat <<EOF2 >shared.txt
+Only stored in upstream.
+EOF2
+  git add shared.txt
+  git commit -m "Add a shared file"
+)
+
+## Borrow the objects of upstream/ instead of copying them, like git clone --shared ##
+echo "../../upstream/.git/objects" >.git/objects/info/alternates
+git update-ref refs/heads/master "$(git -C upstream/ rev-parse HEAD)"
+git reset --hard
+
+
+## fork.txt is only committed to the fork ##
+cat <<EOF2 >fork.txt
+Committed to the fork.
+EOF2
+git add fork.txt
+git commit -m "Add a file to the fork"
+
+
+## Replace the contents of fork.txt without rewriting history ##
+git replace "$(git rev-parse HEAD:fork.txt)" "$(echo "Replaced in the fork." | git hash-object -w --stdin)"
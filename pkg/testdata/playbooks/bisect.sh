@@ -0,0 +1,17 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## Eight commits to version.txt, the sixth of which breaks it ##
+for version in 1 2 3 4 5 6 7 8; do
+  echo "$version" >version.txt
+  if [ "$version" -ge 6 ]; then
+    echo "broken" >status.txt
+  else
+    echo "working" >status.txt
+  fi
+  git add version.txt status.txt
+  git commit -m "Version $version"
+  git tag "v$version"
+done
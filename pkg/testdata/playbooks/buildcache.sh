@@ -0,0 +1,17 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## .gitignore, src/main.c, prebuilt.o ##
+mkdir -p src/
+cat <<EOF >.gitignore
+build/
+node_modules/
+*.o
+EOF
+echo "int main() { return 0; }" >src/main.c
+echo "Checked in even though it is ignored" >prebuilt.o
+git add .gitignore src/
+git add -f prebuilt.o
+git commit -m "Add sources"
@@ -0,0 +1,38 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## A file at the bottom of a deeply nested tree ##
+deep=d
+for i in $(seq 2 64); do
+  deep="$deep/d"
+done
+mkdir -p "$deep"
+cat <<EOF >"$deep/bottom.txt"
+Hello World
+EOF
+git add d
+git commit -m "Add a deeply nested tree"
+
+
+## Symlinks that never resolve ##
+ln -s self.txt self.txt
+ln -s pong.txt ping.txt
+ln -s ping.txt pong.txt
+mkdir loops/
+ln -s ../loops/around loops/around
+git add self.txt ping.txt pong.txt loops/
+git commit -m "Add symlink cycles"
+
+
+## A symlink that grows the path every time it is followed ##
+ln -s grow/grow grow
+git add grow
+git commit -m "Add a symlink pointing into itself"
+
+
+## A symlink to its own directory, which can be followed forever but always resolves ##
+ln -s . d/again
+git add d/again
+git commit -m "Add a symlink to its own directory"
@@ -0,0 +1,21 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## old/file.txt at 2021-01-01 ##
+mkdir old/
+cat <<EOF2 >old/file.txt
+Committed first and never changed again.
+EOF2
+git add old/file.txt
+GIT_AUTHOR_DATE="2021-01-01T00:00:00Z" GIT_COMMITTER_DATE="2021-01-01T00:00:00Z" git commit -m "Add an old file"
+
+
+## new/file.txt at 2021-06-01 ##
+mkdir new/
+cat <<EOF2 >new/file.txt
+Committed second.
+EOF2
+git add new/file.txt
+GIT_AUTHOR_DATE="2021-06-01T00:00:00Z" GIT_COMMITTER_DATE="2021-06-01T00:00:00Z" git commit -m "Add a new file"
@@ -0,0 +1,24 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## Committed ##
+mkdir -p dir removed
+echo "Committed" >changed.txt
+echo "Committed" >deleted.txt
+echo "Committed" >dir/unchanged.txt
+echo "Committed" >removed/file.txt
+echo "*.log" >.gitignore
+git add .
+git commit -m "Add files"
+
+
+## Uncommitted changes ##
+echo "Changed" >changed.txt
+rm deleted.txt
+rm -r removed
+mkdir -p added/nested
+echo "Added" >added/nested/file.txt
+echo "Untracked" >dir/untracked.txt
+echo "Ignored" >ignored.log
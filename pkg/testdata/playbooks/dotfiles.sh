@@ -0,0 +1,14 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## .github/workflows/ci.yml, .gitignore, README.md, src/main.go, src/.env ##
+mkdir -p .github/workflows/ src/
+echo "on: push" >.github/workflows/ci.yml
+echo "*.o" >.gitignore
+echo "Read me" >README.md
+echo "package main" >src/main.go
+echo "DEBUG=1" >src/.env
+git add .github/ .gitignore README.md src/
+git commit -m "Add dotfiles"
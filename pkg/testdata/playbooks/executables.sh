@@ -0,0 +1,22 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## bin/true (+x) ##
+# A real binary so running it goes through mmap rather than an interpreter reading the file.
+mkdir bin/
+if [ -x /bin/true ]; then cp /bin/true bin/true; else cp /usr/bin/true bin/true; fi
+chmod +x bin/true
+git add bin/
+git commit -m "Add a binary."
+
+
+## bin/hello.sh (+x) ##
+cat <<EOF >bin/hello.sh
+#!/bin/sh
+echo "Hello World"
+EOF
+chmod +x bin/hello.sh
+git add bin/hello.sh
+git commit -m "Add a script."
@@ -0,0 +1,39 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## real.txt ##
+cat <<EOF >real.txt
+Hello World
+EOF
+git add real.txt
+git commit -m "Add a normal file"
+
+
+## mainline.txt ##
+cat <<EOF >mainline.txt
+Committed to master
+EOF
+git add mainline.txt
+git commit -m "Add a file on master"
+
+
+## feature.txt (feature) ##
+git checkout -b feature HEAD~1
+cat <<EOF >feature.txt
+Committed to a branch
+EOF
+git add feature.txt
+git commit -m "Add a file on a branch"
+
+cat <<EOF >>feature.txt
+And changed again
+EOF
+git add feature.txt
+git commit -m "Change the file on the branch"
+
+
+## merge feature into master ##
+git checkout master
+git merge --no-ff -m "Merge the branch" feature
@@ -0,0 +1,19 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## version.txt at each release, tagged in the order they were released ##
+mkdir releases/
+echo "Shadowed by the releases." >releases/notes.txt
+for version in 1.0.0 1.2.0 1.10.0 2.0.0-rc.1; do
+  echo "$version" >version.txt
+  git add version.txt releases/notes.txt
+  git commit -m "Release $version"
+  git tag "v$version"
+done
+
+## An annotated release and tags that aren't versions ##
+git tag -a v1.1.0 -m "Backported release" v1.2.0~1
+git tag nightly
+git tag 1.2
@@ -0,0 +1,17 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## original.txt ##
+cat <<EOF >original.txt
+This file will be renamed without any changes to its contents.
+EOF
+git add original.txt
+git commit -m "Add a file"
+
+
+## original.txt -> renamed/moved.txt ##
+mkdir renamed/
+git mv original.txt renamed/moved.txt
+git commit -m "Rename a file"
@@ -0,0 +1,20 @@
+#!/usr/bin/env sh
+set -e
+
+git init --object-format=sha256
+
+## real.txt ##
+cat <<EOF >real.txt
+Hello World
+EOF
+git add real.txt
+git commit -m "Add a normal file"
+
+
+## test/nested.txt ##
+mkdir test/
+cat <<EOF >test/nested.txt
+Nested file
+EOF
+git add test/
+git commit -m "Add a directory."
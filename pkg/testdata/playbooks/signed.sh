@@ -0,0 +1,30 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+# Sign with a throwaway SSH key that allowed_signers trusts.
+ssh-keygen -q -t ed25519 -N "" -C gitfs -f signing_key
+echo "gitfs@example.com $(cat signing_key.pub)" >allowed_signers
+git config gpg.format ssh
+git config user.signingkey "$PWD/signing_key"
+git config user.email gitfs@example.com
+
+## real.txt (v1.0, signed) ##
+cat <<EOF >real.txt
+Hello World
+EOF
+git add real.txt
+git commit -S -m "Add a normal file"
+git tag -s v1.0 -m "First release"
+git tag -a v1.0-unsigned -m "First release, unsigned"
+
+
+## real.txt (unsigned) ##
+git checkout -b unsigned
+cat <<EOF >real.txt
+Hello World, again
+EOF
+git add real.txt
+git commit -m "Change a normal file"
+git checkout -
@@ -0,0 +1,35 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## committed.txt, removed.txt ##
+cat <<EOF >committed.txt
+Committed
+EOF
+cat <<EOF >removed.txt
+Removed
+EOF
+git add committed.txt removed.txt
+git commit -m "Add files"
+
+
+## Staged changes ##
+cat <<EOF >committed.txt
+Committed, then changed
+EOF
+mkdir -p new/nested
+cat <<EOF >new/nested/added.txt
+Added
+EOF
+git add committed.txt new
+git rm --quiet removed.txt
+
+
+## Unstaged changes ##
+cat <<EOF >committed.txt
+Changed again but not staged
+EOF
+cat <<EOF >untracked.txt
+Untracked
+EOF
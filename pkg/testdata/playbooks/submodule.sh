@@ -0,0 +1,18 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## real.txt ##
+cat <<EOF >real.txt
+Hello World
+EOF
+git add real.txt
+git commit -m "Add a normal file"
+
+
+## vendor/lib (submodule) ##
+# Record a gitlink directly. The commit it points at doesn't have to exist in this repository, just like a real
+# submodule.
+git update-index --add --cacheinfo "160000,$(git rev-parse HEAD),vendor/lib"
+git commit -m "Add a submodule"
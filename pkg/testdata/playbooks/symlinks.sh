@@ -0,0 +1,26 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## real.txt ##
+cat <<EOF >real.txt
+Hello World
+EOF
+git add real.txt
+git commit -m "Add a normal file"
+
+
+## Symlinks that stay inside of the repository ##
+ln -s real.txt relative.txt
+mkdir nested/
+ln -s ../real.txt nested/up.txt
+git add relative.txt nested/
+git commit -m "Add symlinks inside of the repo"
+
+
+## Symlinks that point outside of the repository ##
+ln -s /etc/passwd absolute.txt
+ln -s ../../outside.txt nested/escaping.txt
+git add absolute.txt nested/escaping.txt
+git commit -m "Add symlinks outside of the repo"
@@ -0,0 +1,21 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## real.txt (v1.0) ##
+cat <<EOF >real.txt
+Hello World
+EOF
+git add real.txt
+git commit -m "Add a normal file"
+git tag -a v1.0 -m "First release"
+git notes add -m "Released as v1.0"
+
+
+## real.txt ##
+cat <<EOF >real.txt
+Hello World, again
+EOF
+git add real.txt
+git commit -m "Change a normal file"
@@ -0,0 +1,19 @@
+#!/usr/bin/env sh
+set -e
+
+git init
+
+## config/version.conf, config/raw.txt ##
+mkdir config/
+cat <<'EOF' >config/version.conf
+commit=@@COMMIT@@
+version=@@DESCRIBE@@
+branch=@@BRANCH@@
+unknown=@@UNKNOWN@@
+EOF
+cat <<'EOF' >config/raw.txt
+commit=@@COMMIT@@
+EOF
+git add config/
+git commit -m "Add config files"
+git tag -a v2.0 -m "Second release"
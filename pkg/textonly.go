@@ -0,0 +1,203 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"io"
+	"os"
+	"path"
+	"sync"
+)
+
+const (
+	// binarySniffLength is how much of a file is searched for a null byte to tell if it is binary, the same as git
+	// looks at before diffing it.
+	binarySniffLength = 8000
+	// maxCachedBinaryVerdicts bounds how many blobs are remembered as binary or not. The cache is dropped when it
+	// fills up.
+	maxCachedBinaryVerdicts = 1 << 16
+)
+
+// PlaceholderContentType is the content type of the placeholders NewTextOnlyFileSystem serves instead of binary files.
+const PlaceholderContentType = "text/plain; charset=utf-8"
+
+// binaryVerdicts remembers whether blobs are binary by hash. Blobs never change so every filesystem can share it.
+var binaryVerdicts = &binaryVerdictCache{verdicts: map[string]bool{}}
+
+type binaryVerdictCache struct {
+	mu       sync.Mutex
+	verdicts map[string]bool
+}
+
+func (c *binaryVerdictCache) get(hash string) (binary bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	binary, ok = c.verdicts[hash]
+	return binary, ok
+}
+
+func (c *binaryVerdictCache) put(hash string, binary bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.verdicts) >= maxCachedBinaryVerdicts {
+		c.verdicts = map[string]bool{}
+	}
+	c.verdicts[hash] = binary
+}
+
+// IsBinary guesses whether head, the start of a file, is binary the way git does: text never has a null byte in the
+// first binarySniffLength bytes.
+func IsBinary(head []byte) bool {
+	if len(head) > binarySniffLength {
+		head = head[:binarySniffLength]
+	}
+	return bytes.IndexByte(head, 0) != -1
+}
+
+// placeholderInfo describes the placeholder served instead of a binary file.
+type placeholderInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i placeholderInfo) Size() int64 {
+	return i.size
+}
+
+// Sys hides the blob's GitFileStat since the placeholder doesn't match it.
+func (i placeholderInfo) Sys() interface{} {
+	return nil
+}
+
+// textOnlyFileSystem serves a short placeholder instead of every binary file, for exporting just the source of a tree
+// to tools that only handle text. Stat reports the size of the placeholder so readers that trust it see all of it.
+type textOnlyFileSystem struct {
+	billy.Filesystem
+}
+
+// NewTextOnlyFileSystem replaces the contents of the binary files in fs, as IsBinary tells them apart, with a line
+// saying which file was left out and how large it is.
+func NewTextOnlyFileSystem(fs billy.Filesystem) billy.Filesystem {
+	return textOnlyFileSystem{Filesystem: fs}
+}
+
+// binary reports whether the regular file filename described by info is binary. Blobs straight from git are only
+// sniffed once.
+func (s textOnlyFileSystem) binary(filename string, info os.FileInfo) (bool, error) {
+	if !info.Mode().IsRegular() {
+		return false, nil
+	}
+	hash, cacheable := ObjectHash(info)
+	if cacheable {
+		if binary, ok := binaryVerdicts.get(hash); ok {
+			return binary, nil
+		}
+	}
+
+	file, err := s.Filesystem.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	head := make([]byte, binarySniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+
+	binary := IsBinary(head[:n])
+	if cacheable {
+		binaryVerdicts.put(hash, binary)
+	}
+	return binary, nil
+}
+
+// placeholder is what is served instead of the binary file filename described by info.
+func placeholder(filename string, info os.FileInfo) []byte {
+	return []byte(fmt.Sprintf("Binary file %s (%d bytes) left out of this text only export.\n", path.Base(filename), info.Size()))
+}
+
+// replaceInfo swaps info for that of its placeholder if filename is binary.
+func (s textOnlyFileSystem) replaceInfo(filename string, info os.FileInfo) (os.FileInfo, error) {
+	binary, err := s.binary(filename, info)
+	if err != nil || !binary {
+		return info, err
+	}
+	return placeholderInfo{FileInfo: info, size: int64(len(placeholder(filename, info)))}, nil
+}
+
+func (s textOnlyFileSystem) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s textOnlyFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+	info, err := s.Filesystem.Stat(filename)
+	if err != nil {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+	binary, err := s.binary(filename, info)
+	if err != nil {
+		return nil, err
+	}
+	if !binary {
+		return s.Filesystem.OpenFile(filename, flag, perm)
+	}
+	return newMemoryFile(filename, placeholder(filename, info)), nil
+}
+
+func (s textOnlyFileSystem) Stat(filename string) (os.FileInfo, error) {
+	info, err := s.Filesystem.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return s.replaceInfo(filename, info)
+}
+
+func (s textOnlyFileSystem) Lstat(filename string) (os.FileInfo, error) {
+	info, err := s.Filesystem.Lstat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return s.replaceInfo(filename, info)
+}
+
+func (s textOnlyFileSystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	files, err := s.Filesystem.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	for index, file := range files {
+		files[index], err = s.replaceInfo(s.Join(dirname, file.Name()), file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// Chroot keeps replacing binary files in the new root.
+func (s textOnlyFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	fs, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewTextOnlyFileSystem(fs), nil
+}
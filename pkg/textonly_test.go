@@ -0,0 +1,96 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTextOnlyFileSystem(t *testing.T) {
+	git := NewFakeGit(nil)
+	binary := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	git.Commit("master", "Add files", map[string]FakeFile{
+		"src/main.go":     {Contents: "package main\n"},
+		"assets/logo.png": {Contents: binary},
+		"assets/late.bin": {Contents: strings.Repeat("a", binarySniffLength) + "\x00"},
+		"link":            {Contents: "assets/logo.png", Mode: FakeModeSymlink},
+	})
+	fs := NewTextOnlyFileSystem(NewReferenceFileSystem(git, BranchRef("master")))
+
+	read := func(t *testing.T, filename string) string {
+		file, err := fs.Open(filename)
+		if err != nil {
+			t.Fatalf("Open(%s) failed: %v", filename, err)
+		}
+		defer file.Close()
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", filename, err)
+		}
+		return string(contents)
+	}
+
+	t.Run("binary", func(t *testing.T) {
+		want := "Binary file logo.png (16 bytes) left out of this text only export.\n"
+		if got := read(t, "assets/logo.png"); got != want {
+			t.Fatalf("assets/logo.png contained %q, want %q", got, want)
+		}
+
+		info, err := fs.Stat("assets/logo.png")
+		if err != nil {
+			t.Fatalf("Stat(assets/logo.png) failed: %v", err)
+		}
+		if info.Size() != int64(len(want)) {
+			t.Fatalf("Stat(assets/logo.png) reported %d bytes, want %d", info.Size(), len(want))
+		}
+		if _, ok := ObjectHash(info); ok {
+			t.Fatalf("Stat(assets/logo.png) still reported the hash of the binary blob")
+		}
+		files, err := fs.ReadDir("assets")
+		if err != nil {
+			t.Fatalf("ReadDir(assets) failed: %v", err)
+		}
+		if size := fileMap(files)["logo.png"].Size(); size != int64(len(want)) {
+			t.Fatalf("ReadDir(assets) reported %d bytes for logo.png, want %d", size, len(want))
+		}
+
+		contentType, err := DetectContentType(fs, "assets/logo.png", info)
+		if err != nil {
+			t.Fatalf("DetectContentType(assets/logo.png) failed: %v", err)
+		}
+		if contentType != PlaceholderContentType {
+			t.Fatalf("DetectContentType(assets/logo.png) = %q, want %q", contentType, PlaceholderContentType)
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		if got := read(t, "src/main.go"); got != "package main\n" {
+			t.Fatalf("src/main.go contained %q", got)
+		}
+		// Like git, only the start of a file is searched for a null byte.
+		if got := read(t, "assets/late.bin"); len(got) != binarySniffLength+1 {
+			t.Fatalf("assets/late.bin was replaced with %q", got)
+		}
+		info, err := fs.Lstat("link")
+		if err != nil {
+			t.Fatalf("Lstat(link) failed: %v", err)
+		}
+		if info.Size() != int64(len("assets/logo.png")) {
+			t.Fatalf("Lstat(link) reported %d bytes, want the length of its target", info.Size())
+		}
+	})
+}
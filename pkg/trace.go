@@ -0,0 +1,155 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer writes a trace log that tags every filesystem operation with an id and attributes the git commands run while
+// it was open to that id, which is enough to explain where the time went in a slow `ls`. A nil *Tracer is valid and
+// does nothing so callers don't need to check if tracing is enabled.
+type Tracer struct {
+	logger *log.Logger
+	// label prefixes every line, see Labelled.
+	label string
+	spans *openSpans
+}
+
+// openSpans are the spans open on a Tracer and the copies Labelled made of it.
+type openSpans struct {
+	mu     sync.Mutex
+	nextId uint64
+	// open holds the spans that haven't ended. Nothing below the frontends takes a context, so a git command is
+	// attributed to every operation open while it ran. Operations are usually served one at a time when a slow one is
+	// being traced, which leaves exactly one.
+	open map[uint64]*Span
+}
+
+func NewTracer(logger *log.Logger) *Tracer {
+	return &Tracer{
+		logger: logger,
+		spans:  &openSpans{open: map[uint64]*Span{}},
+	}
+}
+
+// Labelled is t with every line it logs prefixed by label, so the operations and git commands of each mount and
+// repository served by one process can be told apart. Labels nest, so labelling a labelled tracer with a repository
+// labels it as mount/repository. Spans keep being numbered together with t.
+func (t *Tracer) Labelled(label string) *Tracer {
+	if t == nil || label == "" {
+		return t
+	}
+	return &Tracer{logger: t.logger, label: JoinLabels(t.label, label), spans: t.spans}
+}
+
+// Span is a single traced operation.
+type Span struct {
+	tracer *Tracer
+	id     uint64
+	name   string
+	start  time.Time
+	// paths are the arguments that name files, whose mentions in the error the operation fails with are redacted.
+	paths []string
+}
+
+// Begin opens a span for an operation named by frontend and op. Arguments that are paths should be passed as a
+// redactedPath.
+func (t *Tracer) Begin(frontend, op string, args ...interface{}) *Span {
+	if t == nil {
+		return nil
+	}
+
+	formatted := make([]string, 0, len(args))
+	var paths []string
+	for _, arg := range args {
+		formatted = append(formatted, fmt.Sprint(arg))
+		if path, ok := arg.(redactedPath); ok {
+			paths = append(paths, string(path))
+		}
+	}
+	span := &Span{
+		tracer: t,
+		name:   fmt.Sprintf("%s %s(%s)", frontend, op, strings.Join(formatted, ", ")),
+		start:  time.Now(),
+		paths:  paths,
+	}
+
+	t.spans.mu.Lock()
+	t.spans.nextId += 1
+	span.id = t.spans.nextId
+	t.spans.open[span.id] = span
+	t.spans.mu.Unlock()
+
+	t.printf(strconv.FormatUint(span.id, 10), 0, "%s", span.name)
+	return span
+}
+
+// End closes the span. It takes a pointer so it can be deferred before the operation's error is known.
+func (s *Span) End(err *error) {
+	if s == nil {
+		return
+	}
+
+	s.tracer.spans.mu.Lock()
+	delete(s.tracer.spans.open, s.id)
+	s.tracer.spans.mu.Unlock()
+
+	result := "ok"
+	if err != nil && *err != nil {
+		result = redactError(*err, s.paths...)
+	}
+	s.tracer.printf(strconv.FormatUint(s.id, 10), 0, "%s took %s: %s", s.name, time.Since(s.start), result)
+}
+
+// observeGit is a gitism.Observer that logs git commands under the operations open while they ran.
+func (t *Tracer) observeGit(args []string, duration time.Duration, err error) {
+	t.spans.mu.Lock()
+	ids := make([]uint64, 0, len(t.spans.open))
+	for id := range t.spans.open {
+		ids = append(ids, id)
+	}
+	t.spans.mu.Unlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	formatted := make([]string, len(ids))
+	for i, id := range ids {
+		formatted[i] = strconv.FormatUint(id, 10)
+	}
+
+	redacted, paths := redactGitArgs(args)
+	result := "ok"
+	if err != nil {
+		result = redactError(err, paths...)
+	}
+	t.printf(strings.Join(formatted, ","), 1, "git %s took %s: %s", strings.Join(redacted, " "), duration, result)
+}
+
+// printf logs a line of the trace for the operations ids, a list separated by commas, prefixed by the label of t.
+func (t *Tracer) printf(ids string, depth int, format string, args ...interface{}) {
+	prefix := "[-] "
+	if ids != "" {
+		prefix = "[" + ids + "] "
+	}
+	if t.label != "" {
+		prefix = "[" + t.label + "] " + prefix
+	}
+	t.logger.Printf(prefix+strings.Repeat("  ", depth)+format, args...)
+}
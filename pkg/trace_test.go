@@ -0,0 +1,82 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"github.com/jacobsa/fuse/fuseops"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	output := new(bytes.Buffer)
+	tracer := NewTracer(log.New(output, "", 0))
+
+	gitDirectory, err := runPlaybook("base", t.TempDir())
+	if err != nil {
+		t.Fatalf("playbook 'base' failed: %v", err)
+	}
+	git, err := NewCliGit(gitDirectory, WithTracer(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := NewBillyFuseWithTracer(NewReferenceFileSystem(git, BranchRef("master")), tracer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"real.txt", "missing.txt"} {
+		op := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: name}
+		_ = fs.LookUpInode(context.Background(), op)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	line := regexp.MustCompile(`^\[(\d+)\] +(fuse LookUpInode|git ls-tree)`)
+	ids := map[string]bool{}
+	for _, l := range lines {
+		match := line.FindStringSubmatch(l)
+		if match == nil {
+			t.Fatalf("unexpected trace line %q in:\n%s", l, output)
+		}
+		ids[match[1]] = true
+	}
+	if len(ids) != 2 || !ids["1"] || !ids["2"] {
+		t.Fatalf("expected every line to belong to one of two operations:\n%s", output)
+	}
+	if !strings.Contains(output.String(), "[1]   git ls-tree --long refs/heads/master real.txt took") {
+		t.Fatalf("git command was not attributed to the first lookup:\n%s", output)
+	}
+
+	// A git command run while several operations are open is attributed to all of them.
+	output.Reset()
+	first, second := tracer.Begin("nfs", "Read"), tracer.Begin("nfs", "Read")
+	tracer.observeGit([]string{"cat-file", "-p", "HEAD"}, 0, nil)
+	second.End(nil)
+	tracer.observeGit([]string{"cat-file", "-p", "HEAD"}, 0, nil)
+	first.End(nil)
+	if !strings.Contains(output.String(), "[3,4]   git cat-file") || !strings.Contains(output.String(), "[3]   git cat-file") {
+		t.Fatalf("git commands were not attributed to the open operations:\n%s", output)
+	}
+
+	var nilTracer *Tracer
+	nilTracer.Begin("fuse", "LookUpInode").End(nil)
+	if nilTracer.Labelled("mount") != nil {
+		t.Fatal("labelling a nil tracer should leave it nil")
+	}
+}
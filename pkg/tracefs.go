@@ -0,0 +1,172 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"os"
+	"time"
+)
+
+// tracingFileSystem opens a span around every call into a filesystem for frontends, like NFS, that only talk to
+// gitfs through billy.
+type tracingFileSystem struct {
+	fs       billy.Filesystem
+	tracer   *Tracer
+	frontend string
+}
+
+// NewTracingFileSystem traces every call into fs as an operation of frontend.
+func NewTracingFileSystem(fs billy.Filesystem, tracer *Tracer, frontend string) billy.Filesystem {
+	if tracer == nil {
+		return fs
+	}
+	return tracingFileSystem{
+		fs:       fs,
+		tracer:   tracer,
+		frontend: frontend,
+	}
+}
+
+// billy.Basic type implementation
+
+func (s tracingFileSystem) Create(filename string) (file billy.File, err error) {
+	defer s.tracer.Begin(s.frontend, "Create", redactedPath(filename)).End(&err)
+	return s.fs.Create(filename)
+}
+
+func (s tracingFileSystem) Open(filename string) (file billy.File, err error) {
+	defer s.tracer.Begin(s.frontend, "Open", redactedPath(filename)).End(&err)
+	return s.fs.Open(filename)
+}
+
+func (s tracingFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (file billy.File, err error) {
+	defer s.tracer.Begin(s.frontend, "OpenFile", redactedPath(filename), flag, perm).End(&err)
+	return s.fs.OpenFile(filename, flag, perm)
+}
+
+func (s tracingFileSystem) Stat(filename string) (info os.FileInfo, err error) {
+	defer s.tracer.Begin(s.frontend, "Stat", redactedPath(filename)).End(&err)
+	return s.fs.Stat(filename)
+}
+
+func (s tracingFileSystem) Rename(oldpath, newpath string) (err error) {
+	defer s.tracer.Begin(s.frontend, "Rename", redactedPath(oldpath), redactedPath(newpath)).End(&err)
+	return s.fs.Rename(oldpath, newpath)
+}
+
+func (s tracingFileSystem) Remove(filename string) (err error) {
+	defer s.tracer.Begin(s.frontend, "Remove", redactedPath(filename)).End(&err)
+	return s.fs.Remove(filename)
+}
+
+func (s tracingFileSystem) Join(elem ...string) string {
+	return s.fs.Join(elem...)
+}
+
+// billy.TempFile type implementation
+
+func (s tracingFileSystem) TempFile(dir, prefix string) (file billy.File, err error) {
+	defer s.tracer.Begin(s.frontend, "TempFile", redactedPath(dir), redactedPath(prefix)).End(&err)
+	return s.fs.TempFile(dir, prefix)
+}
+
+// billy.Dir type implementation
+
+func (s tracingFileSystem) ReadDir(path string) (files []os.FileInfo, err error) {
+	defer s.tracer.Begin(s.frontend, "ReadDir", redactedPath(path)).End(&err)
+	return s.fs.ReadDir(path)
+}
+
+func (s tracingFileSystem) MkdirAll(filename string, perm os.FileMode) (err error) {
+	defer s.tracer.Begin(s.frontend, "MkdirAll", redactedPath(filename), perm).End(&err)
+	return s.fs.MkdirAll(filename, perm)
+}
+
+// billy.Symlink type implementation
+
+func (s tracingFileSystem) Lstat(filename string) (info os.FileInfo, err error) {
+	defer s.tracer.Begin(s.frontend, "Lstat", redactedPath(filename)).End(&err)
+	return s.fs.Lstat(filename)
+}
+
+func (s tracingFileSystem) Symlink(target, link string) (err error) {
+	defer s.tracer.Begin(s.frontend, "Symlink", redactedPath(target), redactedPath(link)).End(&err)
+	return s.fs.Symlink(target, link)
+}
+
+func (s tracingFileSystem) Readlink(link string) (target string, err error) {
+	defer s.tracer.Begin(s.frontend, "Readlink", redactedPath(link)).End(&err)
+	return s.fs.Readlink(link)
+}
+
+// billy.Chroot type implementation
+
+func (s tracingFileSystem) Chroot(path string) (fs billy.Filesystem, err error) {
+	defer s.tracer.Begin(s.frontend, "Chroot", redactedPath(path)).End(&err)
+	fs, err = s.fs.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewTracingFileSystem(fs, s.tracer, s.frontend), nil
+}
+
+func (s tracingFileSystem) Root() string {
+	return s.fs.Root()
+}
+
+// billy.Change type implementation
+
+func (s tracingFileSystem) Chmod(name string, mode os.FileMode) (err error) {
+	defer s.tracer.Begin(s.frontend, "Chmod", redactedPath(name), mode).End(&err)
+	change, ok := s.fs.(billy.Change)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+	return change.Chmod(name, mode)
+}
+
+func (s tracingFileSystem) Lchown(name string, uid, gid int) (err error) {
+	defer s.tracer.Begin(s.frontend, "Lchown", redactedPath(name), uid, gid).End(&err)
+	change, ok := s.fs.(billy.Change)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+	return change.Lchown(name, uid, gid)
+}
+
+func (s tracingFileSystem) Chown(name string, uid, gid int) (err error) {
+	defer s.tracer.Begin(s.frontend, "Chown", redactedPath(name), uid, gid).End(&err)
+	change, ok := s.fs.(billy.Change)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+	return change.Chown(name, uid, gid)
+}
+
+func (s tracingFileSystem) Chtimes(name string, atime time.Time, mtime time.Time) (err error) {
+	defer s.tracer.Begin(s.frontend, "Chtimes", redactedPath(name), atime, mtime).End(&err)
+	change, ok := s.fs.(billy.Change)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+	return change.Chtimes(name, atime, mtime)
+}
+
+// billy.Capable
+
+func (s tracingFileSystem) Capabilities() billy.Capability {
+	return billy.Capabilities(s.fs)
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	// TreeEntriesXattr is the extended attribute directories carry the number of files, symlinks, and submodules
+	// under them in, however deeply nested.
+	TreeEntriesXattr = "user.gitfs.tree_entries"
+	// TreeSizeXattr is the extended attribute directories carry the total size of the files and symlinks under them
+	// in, in bytes.
+	TreeSizeXattr = "user.gitfs.tree_size"
+	// maxCachedTreeSizes bounds how many trees' sizes are remembered. The cache is dropped when it fills up.
+	maxCachedTreeSizes = 1 << 16
+)
+
+// ErrNoTreeSize is returned for directories that aren't trees in git, like those generated by a decorator.
+var ErrNoTreeSize = errors.New("directory is not a git tree")
+
+// TreeSize describes everything under a directory.
+type TreeSize struct {
+	// Entries counts the files, symlinks, and submodules under the directory. Directories aren't counted.
+	Entries int64
+	// Size is the total size of the files and symlinks under the directory, in bytes.
+	Size int64
+}
+
+// TreeSizes measures directories with a single recursive ls-tree each and remembers the result by tree hash, so
+// tools can tell how big a directory is without walking it.
+type TreeSizes struct {
+	git Git
+
+	mu    sync.Mutex
+	sizes map[string]TreeSize
+}
+
+// NewTreeSizes measures trees of the repository git reads.
+func NewTreeSizes(git Git) *TreeSizes {
+	return &TreeSizes{git: git, sizes: map[string]TreeSize{}}
+}
+
+// Tree measures the tree named by hash.
+func (t *TreeSizes) Tree(hash string) (TreeSize, error) {
+	t.mu.Lock()
+	size, ok := t.sizes[hash]
+	t.mu.Unlock()
+	if ok {
+		return size, nil
+	}
+
+	err := t.git.ListTreeRecursive(GitPath{Reference: CommitRef(hash)}, func(entry gitism.TreeEntry) error {
+		size.Entries++
+		// Submodules have no size.
+		if entry.Object == gitism.BlobObject {
+			bytes, err := strconv.ParseInt(entry.Size, 10, 64)
+			if err != nil {
+				return err
+			}
+			size.Size += bytes
+		}
+		return nil
+	})
+	if err != nil {
+		return TreeSize{}, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.sizes) >= maxCachedTreeSizes {
+		t.sizes = map[string]TreeSize{}
+	}
+	t.sizes[hash] = size
+	return size, nil
+}
+
+// Directory measures the directory at name in fs, described by info. Git has no tree hash for the root so it is
+// measured from the trees and files in it. Other directories that don't come straight from git return ErrNoTreeSize.
+func (t *TreeSizes) Directory(fs billy.Filesystem, name string, info os.FileInfo) (TreeSize, error) {
+	if hash, ok := ObjectHash(info); ok && hash != "" {
+		return t.Tree(hash)
+	}
+	root := RootGitPath()
+	if resolved, err := root.Resolve(name); err != nil || !resolved.IsRoot() {
+		return TreeSize{}, ErrNoTreeSize
+	}
+
+	files, err := fs.ReadDir(name)
+	if err != nil {
+		return TreeSize{}, err
+	}
+	var size TreeSize
+	for _, file := range files {
+		hash, fromGit := ObjectHash(file)
+		switch {
+		case !file.IsDir():
+			size.Entries++
+			if file.Mode()&os.ModeType == 0 || file.Mode()&os.ModeSymlink != 0 {
+				size.Size += file.Size()
+			}
+		case fromGit && hash != "":
+			child, err := t.Tree(hash)
+			if err != nil {
+				return TreeSize{}, err
+			}
+			size.Entries += child.Entries
+			size.Size += child.Size
+		}
+		// Directories generated by decorators, like .gitfs, aren't part of the tree.
+	}
+	return size, nil
+}
+
+// treeSizeXattr formats the attribute called name of size. ok is false for other attributes.
+func treeSizeXattr(name string, size TreeSize) (value string, ok bool) {
+	switch name {
+	case TreeEntriesXattr:
+		return strconv.FormatInt(size.Entries, 10), true
+	case TreeSizeXattr:
+		return strconv.FormatInt(size.Size, 10), true
+	}
+	return "", false
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/gravypod/gitfs/pkg/gitism"
+	"io"
+	"path"
+)
+
+// WarmStats counts what Warm read.
+type WarmStats struct {
+	Directories int
+	Files       int
+	Bytes       int64
+}
+
+// Warm lists every directory in fs and reads every file matching one of patterns, as in MatchesAny, so later reads
+// are answered from warm caches. Every file is read if there are no patterns. Symlinks are not followed.
+func Warm(fs billy.Filesystem, patterns []string) (WarmStats, error) {
+	var stats WarmStats
+	err := warm(fs, ".", patterns, &stats)
+	return stats, err
+}
+
+// WarmTree reads every file of ref matching one of patterns, as in Warm, straight from git with Git.ReadTreeBlobs,
+// which is much faster than walking a filesystem since the whole tree is read by two git commands. It fills the
+// cache of clients that have one and the kernel's page cache of the repository, but not the caches of a mount.
+func WarmTree(git Git, ref Ref, patterns []string) (WarmStats, error) {
+	var stats WarmStats
+	directories := map[string]bool{".": true}
+	include := func(entry gitism.TreeEntry) bool {
+		for dir := path.Dir(entry.Path); !directories[dir]; dir = path.Dir(dir) {
+			directories[dir] = true
+		}
+		if entry.Mode.Type == gitism.Gitlink {
+			directories[entry.Path] = true
+		}
+		return entry.Mode.Type == gitism.RegularFile && (len(patterns) == 0 || MatchesAny(patterns, entry.Path))
+	}
+	err := git.ReadTreeBlobs(GitPath{Reference: ref}, include, func(entry gitism.TreeEntry, contents []byte) error {
+		stats.Files += 1
+		stats.Bytes += int64(len(contents))
+		return nil
+	})
+	stats.Directories = len(directories)
+	return stats, err
+}
+
+func warm(fs billy.Filesystem, dirname string, patterns []string, stats *WarmStats) error {
+	files, err := fs.ReadDir(dirname)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", dirname, err)
+	}
+	stats.Directories += 1
+
+	for _, file := range files {
+		filename := fs.Join(dirname, file.Name())
+		switch {
+		case file.IsDir():
+			if err := warm(fs, filename, patterns, stats); err != nil {
+				return err
+			}
+		case file.Mode().IsRegular():
+			if len(patterns) > 0 && !MatchesAny(patterns, filename) {
+				continue
+			}
+			size, err := warmFile(fs, filename)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", filename, err)
+			}
+			stats.Files += 1
+			stats.Bytes += size
+		}
+	}
+	return nil
+}
+
+func warmFile(fs billy.Filesystem, filename string) (int64, error) {
+	file, err := fs.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return io.Copy(io.Discard, file)
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+)
+
+func TestWarm(t *testing.T) {
+	git := newGitCliFromPlaybook(t, "templates")
+	fs := NewReferenceFileSystem(git, BranchRef("master"))
+
+	t.Run("everything", func(t *testing.T) {
+		stats, err := Warm(fs, nil)
+		if err != nil {
+			t.Fatalf("Warm() failed: %v", err)
+		}
+		if stats.Directories != 2 || stats.Files != 2 || stats.Bytes == 0 {
+			t.Fatalf("Warm() should read both directories and both files: %+v", stats)
+		}
+	})
+
+	t.Run("patterns", func(t *testing.T) {
+		stats, err := Warm(fs, []string{"*.conf"})
+		if err != nil {
+			t.Fatalf("Warm() failed: %v", err)
+		}
+		info, err := fs.Stat("config/version.conf")
+		if err != nil {
+			t.Fatalf("Stat(config/version.conf) failed: %v", err)
+		}
+		if stats.Directories != 2 || stats.Files != 1 || stats.Bytes != info.Size() {
+			t.Fatalf("Warm() should only read config/version.conf: %+v", stats)
+		}
+	})
+
+	t.Run("tree", func(t *testing.T) {
+		walked, err := Warm(fs, nil)
+		if err != nil {
+			t.Fatalf("Warm() failed: %v", err)
+		}
+		stats, err := WarmTree(git, BranchRef("master"), nil)
+		if err != nil {
+			t.Fatalf("WarmTree() failed: %v", err)
+		}
+		if stats != walked {
+			t.Fatalf("WarmTree() = %+v, expected the same as walking the filesystem: %+v", stats, walked)
+		}
+		if stats, err := WarmTree(git, BranchRef("master"), []string{"*.conf"}); err != nil || stats.Files != 1 {
+			t.Fatalf("WarmTree() should only read config/version.conf: %+v, %v", stats, err)
+		}
+	})
+}
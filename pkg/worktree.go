@@ -0,0 +1,133 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"os"
+	"time"
+)
+
+// worktreeFileSystem is a read-only view of a filesystem for libraries like go-git that take a billy.Filesystem as a
+// worktree. Decorators don't forward billy.Capable, so without it billy.Capabilities would report the whole stack as
+// writable and callers would only find out when a write failed somewhere below.
+type worktreeFileSystem struct {
+	billy.Filesystem
+}
+
+// NewWorktreeFileSystem wraps fs so it can be handed to go-git as a worktree without letting it change anything:
+//
+//	storage := filesystem.NewStorage(osfs.New(gitDir), cache.NewObjectLRUDefault())
+//	repository, err := git.Open(storage, gitfs.NewWorktreeFileSystem(fs))
+//
+// go-git reads objects from the same repository gitfs serves, so nothing is cloned. Every write fails with
+// billy.ErrReadOnly and Capabilities only reports reading and seeking.
+func NewWorktreeFileSystem(fs billy.Filesystem) billy.Filesystem {
+	return worktreeFileSystem{Filesystem: fs}
+}
+
+// billy.Basic type implementation
+
+func (s worktreeFileSystem) Create(filename string) (billy.File, error) {
+	_ = filename
+	return nil, billy.ErrReadOnly
+}
+
+func (s worktreeFileSystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag != os.O_RDONLY {
+		return nil, billy.ErrReadOnly
+	}
+	return s.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (s worktreeFileSystem) Rename(oldpath, newpath string) error {
+	_ = oldpath
+	_ = newpath
+	return billy.ErrReadOnly
+}
+
+func (s worktreeFileSystem) Remove(filename string) error {
+	_ = filename
+	return billy.ErrReadOnly
+}
+
+// billy.TempFile type implementation
+
+func (s worktreeFileSystem) TempFile(dir, prefix string) (billy.File, error) {
+	_ = dir
+	_ = prefix
+	return nil, billy.ErrReadOnly
+}
+
+// billy.Dir type implementation
+
+func (s worktreeFileSystem) MkdirAll(filename string, perm os.FileMode) error {
+	_ = filename
+	_ = perm
+	return billy.ErrReadOnly
+}
+
+// billy.Symlink type implementation
+
+func (s worktreeFileSystem) Symlink(target, link string) error {
+	_ = target
+	_ = link
+	return billy.ErrReadOnly
+}
+
+// billy.Chroot type implementation
+
+func (s worktreeFileSystem) Chroot(path string) (billy.Filesystem, error) {
+	fs, err := s.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewWorktreeFileSystem(fs), nil
+}
+
+// billy.Change type implementation
+
+func (s worktreeFileSystem) Chmod(name string, mode os.FileMode) error {
+	_ = name
+	_ = mode
+	return billy.ErrReadOnly
+}
+
+func (s worktreeFileSystem) Lchown(name string, uid, gid int) error {
+	_ = name
+	_ = uid
+	_ = gid
+	return billy.ErrReadOnly
+}
+
+func (s worktreeFileSystem) Chown(name string, uid, gid int) error {
+	_ = name
+	_ = uid
+	_ = gid
+	return billy.ErrReadOnly
+}
+
+func (s worktreeFileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	_ = name
+	_ = atime
+	_ = mtime
+	return billy.ErrReadOnly
+}
+
+// billy.Capable
+
+func (s worktreeFileSystem) Capabilities() billy.Capability {
+	return billy.ReadCapability | billy.SeekCapability
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestWorktreeFileSystem(t *testing.T) {
+	// memfs is writable so every write below is refused by the wrapper rather than the backend.
+	backing := memfs.New()
+	if err := util.WriteFile(backing, "src/main.go", []byte("package main\n"), 0644); err != nil {
+		t.Fatalf("failed to write src/main.go: %v", err)
+	}
+	fs := NewWorktreeFileSystem(NewTemplateFileSystem(backing, []string{"*.txt"}, nil))
+
+	if capabilities := billy.Capabilities(fs); capabilities != billy.ReadCapability|billy.SeekCapability {
+		t.Fatalf("Capabilities() should only allow reading and seeking: %v", capabilities)
+	}
+
+	file, err := fs.Open("src/main.go")
+	if err != nil {
+		t.Fatalf("Open(src/main.go) failed: %v", err)
+	}
+	contents, err := io.ReadAll(file)
+	if err != nil || string(contents) != "package main\n" {
+		t.Fatalf("src/main.go contained %q, %v", contents, err)
+	}
+
+	writes := map[string]func(fs billy.Filesystem) error{
+		"Create": func(fs billy.Filesystem) error {
+			_, err := fs.Create("new.go")
+			return err
+		},
+		"OpenFile": func(fs billy.Filesystem) error {
+			_, err := fs.OpenFile("src/main.go", os.O_WRONLY|os.O_TRUNC, 0644)
+			return err
+		},
+		"Remove": func(fs billy.Filesystem) error {
+			return fs.Remove("src/main.go")
+		},
+		"Rename": func(fs billy.Filesystem) error {
+			return fs.Rename("src/main.go", "main.go")
+		},
+		"MkdirAll": func(fs billy.Filesystem) error {
+			return fs.MkdirAll("build", 0755)
+		},
+		"TempFile": func(fs billy.Filesystem) error {
+			_, err := fs.TempFile("", "gitfs")
+			return err
+		},
+		"Symlink": func(fs billy.Filesystem) error {
+			return fs.Symlink("src/main.go", "main.go")
+		},
+		"Chroot then Create": func(fs billy.Filesystem) error {
+			chrooted, err := fs.Chroot("src")
+			if err != nil {
+				return err
+			}
+			_, err = chrooted.Create("new.go")
+			return err
+		},
+	}
+	for name, write := range writes {
+		t.Run(name, func(t *testing.T) {
+			if err := write(fs); !errors.Is(err, billy.ErrReadOnly) {
+				t.Fatalf("%s should fail with ErrReadOnly: %v", name, err)
+			}
+		})
+	}
+}